@@ -6,6 +6,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -15,6 +16,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 
 	"github.com/facebookincubator/contest/plugins/listeners/httplistener"
 )
@@ -26,8 +28,14 @@ import (
 // Start a job with the provided job description from a JSON file
 //   ./contestcli-http start < start.json
 //
+// Start a job whose descriptor declares a firmware_version parameter
+//   ./contestcli-http -p firmware_version=1.2.3 start < start.json
+//
 // Get the status of a job whose ID is 10
 //   ./contestcli-http status 10
+//
+// Follow the events of the job whose ID is 10 until it ends
+//   ./contestcli-http tail 10
 
 const (
 	defaultRequestor = "contestcli-http"
@@ -36,13 +44,39 @@ const (
 var (
 	flagAddr      = flag.String("addr", "http://localhost:8080", "ConTest server [scheme://]host:port[/basepath] to connect to")
 	flagRequestor = flag.String("r", defaultRequestor, "Identifier of the requestor of the API call")
+	flagParams    = parameterValues{}
 )
 
+func init() {
+	flag.Var(flagParams, "p", "Value of a parameter declared by the job descriptor, as name=value. Can be repeated")
+}
+
+// parameterValues are the values of the job parameters passed on the command
+// line.
+type parameterValues map[string]string
+
+func (p parameterValues) String() string {
+	var values []string
+	for name, value := range p {
+		values = append(values, name+"="+value)
+	}
+	return strings.Join(values, ",")
+}
+
+func (p parameterValues) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("invalid parameter value '%s', must be name=value", v)
+	}
+	p[kv[0]] = kv[1]
+	return nil
+}
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of contestcli-http:\n\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "  contestcli-http [args] command\n\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "command: start, stop, status, retry, version\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "command: start, stop, status, retry, tail, quarantined, releasetarget, plugins, version\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "  start\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "        start a new job using the job description passed via stdin\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "  stop int\n")
@@ -51,6 +85,14 @@ func main() {
 		fmt.Fprintf(flag.CommandLine.Output(), "        get the status of a job by job ID\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "  retry int\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "        retry a job by job ID\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  tail int\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "        print the events of a job by job ID as they are emitted, until it ends\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  quarantined\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "        list the targets quarantined for failing too often\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  releasetarget string\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "        take a target out of quarantine by target ID\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  plugins\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "        list the plugins of the server, with the parameter schemas of the test steps\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "  version\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "        request the API version to the server\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "\nargs:\n")
@@ -64,6 +106,65 @@ func main() {
 	}
 }
 
+// serverURL parses the address of the server.
+func serverURL() (*url.URL, error) {
+	u, err := url.Parse(*flagAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse server address '%s': %v", *flagAddr, err)
+	}
+	if u.Scheme == "" {
+		return nil, errors.New("server URL scheme not specified")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme '%s', please specify either http or https", u.Scheme)
+	}
+	return u, nil
+}
+
+// tail prints the data of the events of a job stream to stdout, one JSON
+// object per line, until the job ends.
+func tail(streamURL string) error {
+	fmt.Fprintf(os.Stderr, "Following events at %s\n", streamURL)
+	resp, err := http.Get(streamURL)
+	if err != nil {
+		return fmt.Errorf("HTTP GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("the server responded with status %s: %s", resp.Status, body)
+	}
+	var name string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data := strings.TrimPrefix(line, "data: ")
+			switch name {
+			case "end":
+				var end httplistener.StreamEnd
+				if err := json.Unmarshal([]byte(data), &end); err != nil {
+					return fmt.Errorf("invalid end of stream '%s': %v", data, err)
+				}
+				fmt.Fprintf(os.Stderr, "Job ended: %s\n", end.State)
+				return nil
+			case "lagged":
+				return errors.New("the stream was interrupted because the client was too slow")
+			default:
+				fmt.Println(data)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read event stream: %v", err)
+	}
+	return errors.New("the event stream ended before the job")
+}
+
 func run(verb string) error {
 	var (
 		params = url.Values{}
@@ -77,26 +178,45 @@ func run(verb string) error {
 			return fmt.Errorf("failed to parse job descriptor: %v", err)
 		}
 		params.Add("jobDesc", string(jobDesc))
+		if len(flagParams) > 0 {
+			values, err := json.Marshal(flagParams)
+			if err != nil {
+				return fmt.Errorf("cannot encode parameter values: %v", err)
+			}
+			params.Set("values", string(values))
+		}
 	case "stop", "status", "retry":
 		jobID := flag.Arg(1)
 		if jobID == "" {
 			return errors.New("missing job ID")
 		}
 		params.Set("jobID", jobID)
-	case "version":
-		// no params for protocol version
+	case "tail":
+		jobID := flag.Arg(1)
+		if jobID == "" {
+			return errors.New("missing job ID")
+		}
+		u, err := serverURL()
+		if err != nil {
+			return err
+		}
+		u.Path += "/jobs/" + url.PathEscape(jobID) + "/events"
+		u.RawQuery = params.Encode()
+		return tail(u.String())
+	case "releasetarget":
+		targetID := flag.Arg(1)
+		if targetID == "" {
+			return errors.New("missing target ID")
+		}
+		params.Set("targetID", targetID)
+	case "quarantined", "plugins", "version":
+		// no params
 	default:
 		return fmt.Errorf("invalid verb: '%s'", verb)
 	}
-	u, err := url.Parse(*flagAddr)
+	u, err := serverURL()
 	if err != nil {
-		return fmt.Errorf("failed to parse server address '%s': %v", *flagAddr, err)
-	}
-	if u.Scheme == "" {
-		return errors.New("server URL scheme not specified")
-	}
-	if u.Scheme != "http" && u.Scheme != "https" {
-		return fmt.Errorf("unsupported URL scheme '%s', please specify either http or https", u.Scheme)
+		return err
 	}
 	u.Path += "/" + verb
 	fmt.Fprintf(os.Stderr, "Requesting URL %s with requestor ID '%s'\n", u.String(), *flagRequestor)
@@ -8,46 +8,144 @@ package main
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/facebookincubator/contest/pkg/api"
 	"github.com/facebookincubator/contest/pkg/config"
+	"github.com/facebookincubator/contest/pkg/health"
 	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/jobid"
 	"github.com/facebookincubator/contest/pkg/jobmanager"
 	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/metrics"
 	"github.com/facebookincubator/contest/pkg/pluginregistry"
+	"github.com/facebookincubator/contest/pkg/secrets"
 	"github.com/facebookincubator/contest/pkg/storage"
 	"github.com/facebookincubator/contest/pkg/target"
 	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/listeners/grpclistener"
 	"github.com/facebookincubator/contest/plugins/listeners/httplistener"
+	"github.com/facebookincubator/contest/plugins/publishers/nats"
+	"github.com/facebookincubator/contest/plugins/publishers/webhook"
+	"github.com/facebookincubator/contest/plugins/reporters/email"
+	"github.com/facebookincubator/contest/plugins/reporters/googlesheets"
 	"github.com/facebookincubator/contest/plugins/reporters/noop"
+	"github.com/facebookincubator/contest/plugins/reporters/pushgateway"
 	"github.com/facebookincubator/contest/plugins/reporters/targetsuccess"
+	webhookreporter "github.com/facebookincubator/contest/plugins/reporters/webhook"
+	"github.com/facebookincubator/contest/plugins/storage/localfs"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
 	"github.com/facebookincubator/contest/plugins/storage/rdbms"
+	"github.com/facebookincubator/contest/plugins/storage/s3"
+	"github.com/facebookincubator/contest/plugins/targetlocker/etcd"
 	"github.com/facebookincubator/contest/plugins/targetlocker/inmemory"
+	nooplocker "github.com/facebookincubator/contest/plugins/targetlocker/noop"
+	"github.com/facebookincubator/contest/plugins/targetmanagers/composite"
 	"github.com/facebookincubator/contest/plugins/targetmanagers/csvtargetmanager"
+	"github.com/facebookincubator/contest/plugins/targetmanagers/httpinventory"
 	"github.com/facebookincubator/contest/plugins/targetmanagers/targetlist"
 	"github.com/facebookincubator/contest/plugins/testfetchers/literal"
 	"github.com/facebookincubator/contest/plugins/testfetchers/uri"
+	"github.com/facebookincubator/contest/plugins/teststeps/apicontract"
+	"github.com/facebookincubator/contest/plugins/teststeps/auditcheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/bgpcheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/cgroupcheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/chaincheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/chaos"
+	"github.com/facebookincubator/contest/plugins/teststeps/cmclean"
 	"github.com/facebookincubator/contest/plugins/teststeps/cmd"
+	"github.com/facebookincubator/contest/plugins/teststeps/depcheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/dmesg"
+	"github.com/facebookincubator/contest/plugins/teststeps/dnsupdate"
 	"github.com/facebookincubator/contest/plugins/teststeps/echo"
+	"github.com/facebookincubator/contest/plugins/teststeps/envcheck"
 	"github.com/facebookincubator/contest/plugins/teststeps/example"
+	execstep "github.com/facebookincubator/contest/plugins/teststeps/exec"
+	"github.com/facebookincubator/contest/plugins/teststeps/fdcheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/fwrules"
+	"github.com/facebookincubator/contest/plugins/teststeps/fwupdate"
+	"github.com/facebookincubator/contest/plugins/teststeps/gpucheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/gracefulrestart"
+	"github.com/facebookincubator/contest/plugins/teststeps/hwinventory"
+	"github.com/facebookincubator/contest/plugins/teststeps/imagedigest"
+	"github.com/facebookincubator/contest/plugins/teststeps/k8srollout"
+	"github.com/facebookincubator/contest/plugins/teststeps/lbmembership"
+	"github.com/facebookincubator/contest/plugins/teststeps/logrotate"
+	"github.com/facebookincubator/contest/plugins/teststeps/mactcheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/memcheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/migratecheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/modcheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/objstorecheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/perfconfig"
+	"github.com/facebookincubator/contest/plugins/teststeps/quorumcheck"
 	"github.com/facebookincubator/contest/plugins/teststeps/randecho"
+	"github.com/facebookincubator/contest/plugins/teststeps/repocheck"
+	"github.com/facebookincubator/contest/plugins/teststeps/routecheck"
 	"github.com/facebookincubator/contest/plugins/teststeps/slowecho"
+	"github.com/facebookincubator/contest/plugins/teststeps/smoke"
 	"github.com/facebookincubator/contest/plugins/teststeps/sshcmd"
 	"github.com/facebookincubator/contest/plugins/teststeps/terminalexpect"
+	"github.com/facebookincubator/contest/plugins/teststeps/ttfb"
+	"github.com/facebookincubator/contest/plugins/teststeps/uptimecheck"
 	"github.com/sirupsen/logrus"
 )
 
 const defaultDBURI = "contest:contest@tcp(localhost:3306)/contest?parseTime=true"
 
+// storage backends selectable with -storage.
+const (
+	storageRDBMS  = "rdbms"
+	storageMemory = "memory"
+)
+
 var (
-	flagDBURI = flag.String("dbURI", defaultDBURI, "Database URI")
+	flagStorage       = flag.String("storage", storageRDBMS, "Storage backend, one of "+storageRDBMS+", "+storageMemory+". The memory backend needs no database, but loses everything when the server stops")
+	flagDBURI         = flag.String("dbURI", defaultDBURI, "Database URI")
+	flagDBReplicaURIs = flag.String("dbReplicaURIs", "", "Comma-separated list of read replica database URIs")
+	flagDBConsistency = flag.String("dbReadConsistency", "", "Consistency of the job request and test event reads with read replicas: strong reads from the primary, eventual from the replicas. By default job requests are read from the primary and test events from the replicas")
+	flagPlugins       = flag.String("plugins", "", "Comma-separated list of Go plugins (.so files) to load at startup")
+	flagUniqueExtIDs  = flag.Bool("uniqueExternalIDs", false, "Reject jobs whose external ID is already used by another job")
+	flagNATSURL       = flag.String("eventsNATSURL", "", "URL of a NATS server to publish the test events to, e.g. nats://localhost:4222")
+	flagNATSSubject   = flag.String("eventsNATSSubject", "contest.events", "NATS subject to publish the test events to")
+	flagWebhookURL    = flag.String("jobWebhookURL", "", "URL to POST the job state transitions to")
+	flagWebhookSecret = flag.String("jobWebhookSecret", "", "Secret signing the job webhook requests, or a secret reference such as ${env:CONTEST_WEBHOOK_SECRET}")
+	flagRateLimit     = flag.String("submissionRateLimit", "", "Default job submission rate limit of each requestor, as <jobs>/<duration>, e.g. 10/1m. Unlimited if empty")
+	flagRateLimits    = flag.String("requestorRateLimits", "", "Comma-separated job submission rate limits of specific requestors, as <requestor>=<jobs>/<duration>")
+	flagMaxJobs       = flag.Uint("maxConcurrentJobs", 0, "Maximum number of jobs running at once. The jobs submitted beyond it wait in queue, by priority. Unlimited if zero")
+	flagJobIDs        = flag.String("jobIDStrategy", jobid.StrategySequential, "Strategy allocating the IDs of new jobs, one of "+strings.Join(jobid.Strategies, ", "))
+	flagTargetLocker  = flag.String("targetLocker", inmemory.Name, "Target locker plugin. Servers sharing targets must use a shared locker, such as "+etcd.Name)
+	flagLockTimeout   = flag.Duration("lockTimeout", config.LockTimeout, "Duration of the target locks, which running jobs refresh")
+	flagEtcdEndpoints = flag.String("etcdEndpoints", "", "Comma-separated list of etcd client URLs used by the "+etcd.Name+" target locker, e.g. http://localhost:2379")
+	flagEtcdPrefix    = flag.String("etcdPrefix", etcd.DefaultPrefix, "Prefix of the etcd keys of the target locks")
+	flagArtifactDir   = flag.String("artifactDir", "", "Directory storing the artifacts attached to the targets by the test steps")
+	flagArtifactS3    = flag.String("artifactS3URL", "", "URL of the S3 bucket storing the artifacts attached to the targets by the test steps, as <endpoint URL>/<bucket>[/<prefix>]. The credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN")
+	flagArtifactS3Reg = flag.String("artifactS3Region", "us-east-1", "Region of the S3 bucket storing the artifacts")
+	flagQuarantine    = flag.Float64("quarantineFailureRate", 0, "Quarantine the targets which failed at least this rate, between 0 and 1, of their last runs, so that target managers skip them. Disabled if zero")
+	flagQuarantineWin = flag.Uint("quarantineWindow", 10, "Number of the latest runs of a target its failure rate is computed on")
+	flagRetentionAge  = flag.Duration("retentionMaxAge", 0, "Prune the test events and reports of the ended jobs requested longer ago than this, e.g. 720h for 30 days. Unlimited if zero")
+	flagRetentionJobs = flag.Uint("retentionMaxJobs", 0, "Prune the test events and reports of the ended jobs beyond this number of the latest ones. Unlimited if zero")
+	flagRetentionInt  = flag.Duration("retentionInterval", time.Hour, "Interval at which the retention limits are applied")
+	flagGRPCAddr      = flag.String("grpcListenAddr", "", "Address of the gRPC API listener, e.g. :8081, served next to the HTTP one. Disabled if empty")
+	flagGRPCCert      = flag.String("grpcCertFile", "", "TLS certificate file of the gRPC API listener, as gRPC needs HTTP/2 over TLS")
+	flagGRPCKey       = flag.String("grpcKeyFile", "", "TLS key file of the gRPC API listener")
+	flagServerMetrics = flag.Bool("serverMetrics", false, "Expose the metrics of the server internals on /metrics: job states, step throughput and latency, locked targets, storage latency and event rates")
 )
 
 var targetManagers = []target.TargetManagerLoader{
 	csvtargetmanager.Load,
 	targetlist.Load,
+	httpinventory.Load,
+}
+
+var targetLockers = []target.LockerLoader{
+	inmemory.Load,
+	nooplocker.Load,
 }
 
 var testFetchers = []test.TestFetcherLoader{
@@ -60,14 +158,52 @@ var testSteps = []test.TestStepLoader{
 	slowecho.Load,
 	example.Load,
 	cmd.Load,
+	execstep.Load,
 	sshcmd.Load,
 	randecho.Load,
 	terminalexpect.Load,
+	fwupdate.Load,
+	k8srollout.Load,
+	envcheck.Load,
+	migratecheck.Load,
+	ttfb.Load,
+	modcheck.Load,
+	uptimecheck.Load,
+	lbmembership.Load,
+	gpucheck.Load,
+	chaos.Load,
+	cgroupcheck.Load,
+	cmclean.Load,
+	mactcheck.Load,
+	apicontract.Load,
+	repocheck.Load,
+	imagedigest.Load,
+	bgpcheck.Load,
+	smoke.Load,
+	dmesg.Load,
+	fdcheck.Load,
+	chaincheck.Load,
+	routecheck.Load,
+	auditcheck.Load,
+	memcheck.Load,
+	hwinventory.Load,
+	depcheck.Load,
+	logrotate.Load,
+	dnsupdate.Load,
+	perfconfig.Load,
+	quorumcheck.Load,
+	fwrules.Load,
+	gracefulrestart.Load,
+	objstorecheck.Load,
 }
 
 var reporters = []job.ReporterLoader{
 	targetsuccess.Load,
 	noop.Load,
+	pushgateway.Load,
+	googlesheets.Load,
+	webhookreporter.Load,
+	email.Load,
 }
 
 // user-defined functions that will be made available to plugins for advanced
@@ -82,6 +218,28 @@ var userFunctions = map[string]interface{}{
 	},
 }
 
+// newS3ArtifactStore returns the S3 artifact store of a bucket given as
+// <endpoint URL>/<bucket>[/<prefix>].
+func newS3ArtifactStore(bucketURL, region string) (*s3.S3, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact bucket URL: %v", err)
+	}
+	path := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	config := s3.Config{
+		Endpoint:        u.Scheme + "://" + u.Host,
+		Region:          region,
+		Bucket:          path[0],
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if len(path) == 2 {
+		config.Prefix = path[1]
+	}
+	return s3.New(config)
+}
+
 func main() {
 	flag.Parse()
 	log := logging.GetLogger("contest")
@@ -95,6 +253,11 @@ func main() {
 			log.Fatal(err)
 		}
 	}
+	// the composite TargetManager instantiates other TargetManager plugins
+	// through the registry.
+	if err := pluginRegistry.RegisterTargetManager(composite.NewLoader(pluginRegistry)()); err != nil {
+		log.Fatal(err)
+	}
 
 	// Register TestFetcher plugins
 	for _, tfloader := range testFetchers {
@@ -118,12 +281,118 @@ func main() {
 		}
 	}
 
+	// Register TargetLocker plugins
+	for _, tlloader := range targetLockers {
+		if err := pluginRegistry.RegisterTargetLocker(tlloader()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// the etcd locker needs to know where the cluster is
+	if *flagEtcdEndpoints != "" {
+		etcdLoader, err := etcd.NewLoader(etcd.Config{
+			Endpoints: strings.Split(*flagEtcdEndpoints, ","),
+			Prefix:    *flagEtcdPrefix,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := pluginRegistry.RegisterTargetLocker(etcdLoader()); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Register plugins built as shared objects
+	if *flagPlugins != "" {
+		for _, path := range strings.Split(*flagPlugins, ",") {
+			if err := pluginRegistry.RegisterPluginFile(path); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	// the step metrics are always exposed, the internal ones on demand
+	if *flagServerMetrics {
+		metrics.SetServerRegistry(metrics.Default)
+	}
+
 	// storage initialization
-	log.Infof("Using database URI: %s", *flagDBURI)
-	storage.SetStorage(rdbms.New(*flagDBURI))
+	switch *flagStorage {
+	case storageRDBMS:
+		log.Infof("Using database URI: %s", *flagDBURI)
+		var dbOpts []rdbms.Opt
+		if *flagDBReplicaURIs != "" {
+			replicaURIs := strings.Split(*flagDBReplicaURIs, ",")
+			log.Infof("Using %d read replica(s)", len(replicaURIs))
+			dbOpts = append(dbOpts, rdbms.ReadReplicas(replicaURIs...))
+		}
+		if *flagDBConsistency != "" {
+			consistency, err := rdbms.ParseReadConsistency(*flagDBConsistency)
+			if err != nil {
+				log.Fatalf("Invalid -dbReadConsistency: %v", err)
+			}
+			dbOpts = append(dbOpts, rdbms.JobRequestReadConsistency(consistency), rdbms.TestEventReadConsistency(consistency))
+		}
+		storage.SetStorage(rdbms.New(*flagDBURI, dbOpts...))
+	case storageMemory:
+		log.Warningf("Using in-memory storage, jobs and events will be lost when the server stops")
+		storage.SetStorage(memory.New())
+	default:
+		log.Fatalf("Unknown storage backend %q, must be one of %s, %s", *flagStorage, storageRDBMS, storageMemory)
+	}
+
+	// artifacts are optional, steps fail to attach them without a store
+	switch {
+	case *flagArtifactDir != "" && *flagArtifactS3 != "":
+		log.Fatalf("Artifacts can be stored either in a directory or in S3, not both")
+	case *flagArtifactDir != "":
+		store, err := localfs.New(*flagArtifactDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		storage.SetArtifactStore(store)
+	case *flagArtifactS3 != "":
+		store, err := newS3ArtifactStore(*flagArtifactS3, *flagArtifactS3Reg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		storage.SetArtifactStore(store)
+	}
+
+	// publish the test events to a message queue, in addition to storing them
+	if *flagNATSURL != "" {
+		publisher, err := nats.New(*flagNATSURL, *flagNATSSubject)
+		if err != nil {
+			log.Fatal(err)
+		}
+		publisher.Start()
+		storage.AddTestEventSubscriber(publisher)
+		defer func() {
+			if err := publisher.Close(10 * time.Second); err != nil {
+				log.Warningf("Failed to publish pending events: %v", err)
+			}
+		}()
+	}
 
 	// set Locker engine
-	target.SetLocker(inmemory.New(config.LockTimeout))
+	config.LockTimeout = *flagLockTimeout
+	locker, err := pluginRegistry.NewTargetLocker(*flagTargetLocker, config.LockTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Using target locker %s", *flagTargetLocker)
+	if *flagServerMetrics {
+		locker = target.NewMeteredLocker(locker)
+	}
+	target.SetLocker(locker)
+
+	// track the health of the targets across jobs
+	if *flagQuarantine != 0 {
+		tracker, err := health.New(*flagQuarantineWin, *flagQuarantine)
+		if err != nil {
+			log.Fatal(err)
+		}
+		target.SetHealthTracker(tracker)
+	}
 
 	// user-defined function registration
 	for name, fn := range userFunctions {
@@ -132,10 +401,77 @@ func main() {
 		}
 	}
 
+	jmOpts := []jobmanager.Option{
+		jobmanager.OptionUniqueExternalIDs(*flagUniqueExtIDs),
+		jobmanager.OptionMaxConcurrentJobs(*flagMaxJobs),
+		jobmanager.OptionRetentionPolicy(storage.RetentionPolicy{MaxAge: *flagRetentionAge, MaxJobs: *flagRetentionJobs}, *flagRetentionInt),
+	}
+
+	jobIDAllocator, err := jobid.New(*flagJobIDs, storage.NextJobSequence)
+	if err != nil {
+		log.Fatal(err)
+	}
+	jmOpts = append(jmOpts, jobmanager.OptionJobIDAllocator(jobIDAllocator))
+
+	// limit the job submissions of each requestor
+	if *flagRateLimit != "" || *flagRateLimits != "" {
+		var (
+			defaultLimit jobmanager.RateLimit
+			err          error
+		)
+		if *flagRateLimit != "" {
+			if defaultLimit, err = jobmanager.ParseRateLimit(*flagRateLimit); err != nil {
+				log.Fatal(err)
+			}
+		}
+		limits := make(map[api.EventRequestor]jobmanager.RateLimit)
+		if *flagRateLimits != "" {
+			for _, entry := range strings.Split(*flagRateLimits, ",") {
+				kv := strings.SplitN(entry, "=", 2)
+				if len(kv) != 2 || kv[0] == "" {
+					log.Fatalf("Invalid requestor rate limit %q, must be <requestor>=<jobs>/<duration>", entry)
+				}
+				limit, err := jobmanager.ParseRateLimit(kv[1])
+				if err != nil {
+					log.Fatal(err)
+				}
+				limits[api.EventRequestor(kv[0])] = limit
+			}
+		}
+		jmOpts = append(jmOpts, jobmanager.OptionSubmissionRateLimit(defaultLimit, limits))
+	}
+
+	// post the job state transitions to a webhook, regardless of the reporters
+	if *flagWebhookURL != "" {
+		secret, err := secrets.Expand(*flagWebhookSecret)
+		if err != nil {
+			log.Fatalf("Could not resolve webhook secret: %v", err)
+		}
+		hook, err := webhook.New(*flagWebhookURL, secret)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hook.Start()
+		storage.AddTestEventSubscriber(hook)
+		jmOpts = append(jmOpts, jobmanager.OptionJobStateObserver(hook))
+		defer func() {
+			if err := hook.Close(10 * time.Second); err != nil {
+				log.Warningf("Failed to deliver pending job transitions: %v", err)
+			}
+		}()
+	}
+
 	// spawn JobManager
-	listener := httplistener.HTTPListener{}
+	var listener api.Listener = &httplistener.HTTPListener{}
+	if *flagGRPCAddr != "" {
+		grpcListener, err := grpclistener.New(*flagGRPCAddr, *flagGRPCCert, *flagGRPCKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listener = api.NewMultiListener(listener, grpcListener)
+	}
 
-	jm, err := jobmanager.New(&listener, pluginRegistry)
+	jm, err := jobmanager.New(listener, pluginRegistry, jmOpts...)
 	if err != nil {
 		log.Fatal(err)
 	}
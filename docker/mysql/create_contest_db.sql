@@ -5,61 +5,136 @@
 
 CREATE TABLE test_events (
 	event_id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
-	job_id BIGINT(20) NOT NULL,
+	job_id VARCHAR(64) NOT NULL,
 	run_id BIGINT(20) NOT NULL,
 	test_name VARCHAR(32) NULL,
 	test_step_label VARCHAR(32) NULL,
 	event_name VARCHAR(32) NULL,
 	target_name VARCHAR(64) NULL,
 	target_id VARCHAR(64) NULL,
+	span_id VARCHAR(32) NULL,
+	parent_span_id VARCHAR(32) NULL,
+	severity VARCHAR(16) NULL,
+	repeat_count BIGINT(20) UNSIGNED NULL,
+	measurement VARCHAR(64) NULL,
+	result_status VARCHAR(16) NULL,
 	payload TEXT NULL,
 	emit_time TIMESTAMP NOT NULL,
-	PRIMARY KEY (event_id)
+	PRIMARY KEY (event_id),
+	INDEX job_id_idx (job_id)
 );
 
 CREATE TABLE framework_events (
 	event_id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
-	job_id BIGINT(20) NOT NULL,
+	job_id VARCHAR(64) NOT NULL,
 	event_name VARCHAR(32) NULL,
 	payload TEXT NULL,
 	emit_time TIMESTAMP NOT NULL,
-	PRIMARY KEY (event_id)
+	PRIMARY KEY (event_id),
+	INDEX job_id_idx (job_id)
 );
 
 CREATE TABLE run_reports (
 	report_id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
-	job_id BIGINT(20) NOT NULL,
+	job_id VARCHAR(64) NOT NULL,
 	run_id BIGINT(20) NOT NULL,
 	reporter_name VARCHAR(32) NOT NULL,
 	success TINYINT(1) NULL,
 	report_time TIMESTAMP NOT NULL,
 	data TEXT NOT NULL,
-	PRIMARY KEY (report_id)
+	PRIMARY KEY (report_id),
+	INDEX job_id_idx (job_id)
 );
 
 CREATE TABLE final_reports (
 	report_id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
-	job_id BIGINT(20) NOT NULL,
+	job_id VARCHAR(64) NOT NULL,
 	success TINYINT(1) NULL,
 	reporter_name VARCHAR(32) NOT NULL,
 	report_time TIMESTAMP NOT NULL,
 	data TEXT NOT NULL,
-	PRIMARY KEY (report_id)
+	PRIMARY KEY (report_id),
+	INDEX job_id_idx (job_id)
 );
 
 CREATE TABLE jobs (
-	job_id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	submission_id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	job_id VARCHAR(64) NOT NULL,
 	name VARCHAR(32) NOT NULL,
+	external_id VARCHAR(64) NULL,
+	series_id VARCHAR(64) NULL,
 	requestor VARCHAR(32) NOT NULL,
 	request_time TIMESTAMP NOT NULL,
 	descriptor TEXT NOT NULL,
-	PRIMARY KEY (job_id)
+	PRIMARY KEY (submission_id),
+	UNIQUE INDEX job_id_idx (job_id),
+	INDEX external_id_idx (external_id),
+	INDEX series_id_idx (series_id),
+	INDEX request_time_idx (request_time)
+);
+
+CREATE TABLE job_sequence (
+	value BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	PRIMARY KEY (value)
 );
 
 CREATE TABLE locks (
 	target_id VARCHAR(64) NOT NULL,
-	job_id BIGINT(20) UNSIGNED NOT NULL,
+	job_id VARCHAR(64) NOT NULL,
 	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	expires_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	PRIMARY KEY (target_id)
 );
+
+CREATE TABLE job_templates (
+	template_id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(64) NOT NULL,
+	version INT UNSIGNED NOT NULL,
+	requestor VARCHAR(32) NOT NULL,
+	request_time TIMESTAMP NOT NULL,
+	descriptor TEXT NOT NULL,
+	PRIMARY KEY (template_id),
+	UNIQUE INDEX name_version_idx (name, version)
+);
+
+CREATE TABLE job_schedules (
+	schedule_id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	cron VARCHAR(64) NULL,
+	run_interval BIGINT(20) NULL,
+	descriptor TEXT NOT NULL,
+	requestor VARCHAR(32) NOT NULL,
+	create_time TIMESTAMP NOT NULL,
+	state VARCHAR(16) NOT NULL,
+	next_run TIMESTAMP NULL,
+	runs BIGINT(20) UNSIGNED NOT NULL DEFAULT 0,
+	last_job_id VARCHAR(64) NULL,
+	PRIMARY KEY (schedule_id)
+);
+
+CREATE TABLE target_outcomes (
+	outcome_id BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+	target_id VARCHAR(64) NOT NULL,
+	job_id VARCHAR(64) NOT NULL,
+	run_id BIGINT(20) UNSIGNED NOT NULL,
+	success TINYINT(1) NOT NULL,
+	outcome_time TIMESTAMP NOT NULL,
+	PRIMARY KEY (outcome_id),
+	INDEX target_id_idx (target_id, outcome_id)
+);
+
+CREATE TABLE target_health (
+	target_id VARCHAR(64) NOT NULL,
+	quarantined TINYINT(1) NOT NULL DEFAULT 0,
+	quarantine_time TIMESTAMP NULL,
+	failure_rate DOUBLE NOT NULL DEFAULT 0,
+	release_time TIMESTAMP NULL,
+	PRIMARY KEY (target_id),
+	INDEX quarantined_idx (quarantined)
+);
+
+CREATE TABLE step_states (
+	job_id VARCHAR(64) NOT NULL,
+	state MEDIUMTEXT NOT NULL,
+	update_time TIMESTAMP NOT NULL,
+	PRIMARY KEY (job_id)
+);
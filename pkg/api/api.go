@@ -11,6 +11,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/facebookincubator/contest/pkg/job"
 	"github.com/facebookincubator/contest/pkg/types"
 )
 
@@ -34,6 +35,9 @@ type API struct {
 	// serverIDFunc is used by ServerID() to return a custom server ID in API
 	// responses.
 	serverIDFunc func() string
+	// jobIDParser validates the job IDs received by the listeners, see
+	// SetJobIDParser.
+	jobIDParser func(string) (types.JobID, error)
 }
 
 // New returns an initialized instance of an API struct with a default server ID
@@ -51,6 +55,23 @@ func NewWithServerIDFunc(serverIDFunc func() string) *API {
 	}
 }
 
+// SetJobIDParser sets the function parsing the job IDs received by the
+// listeners, typically the Parse method of the jobid.Allocator of the server,
+// so that they reject the IDs it cannot have allocated. It must be called
+// before the API is served.
+func (a *API) SetJobIDParser(parse func(string) (types.JobID, error)) {
+	a.jobIDParser = parse
+}
+
+// ParseJobID parses a job ID received by a listener. Without a parser set by
+// SetJobIDParser, the ID is only checked by types.ParseJobID.
+func (a *API) ParseJobID(s string) (types.JobID, error) {
+	if a.jobIDParser != nil {
+		return a.jobIDParser(s)
+	}
+	return types.ParseJobID(s)
+}
+
 // ServerID returns the Server ID to be used in responses. A custom server ID
 // generation function can be passed to New().
 func (a API) ServerID() string {
@@ -144,11 +165,19 @@ func (a *API) SendReceiveEvent(ev *Event, timeout *time.Duration) (*EventRespons
 // This method should return an error if the job description is malformed or
 // invalid, and if the API version is incompatible.
 func (a *API) Start(requestor EventRequestor, jobDescriptor string) (Response, error) {
+	return a.StartWithParameters(requestor, jobDescriptor, nil)
+}
+
+// StartWithParameters is like Start, for a job descriptor which declares
+// parameters. values maps the parameters to their value, and can omit those
+// which have a default value.
+func (a *API) StartWithParameters(requestor EventRequestor, jobDescriptor string, values map[string]string) (Response, error) {
 	ev := &Event{
 		Type: EventTypeStart,
 		Msg: EventStartMsg{
 			requestor:     requestor,
 			JobDescriptor: jobDescriptor,
+			Parameters:    values,
 		},
 		RespCh: make(chan *EventResponse, 1),
 	}
@@ -232,3 +261,296 @@ func (a *API) Retry(requestor EventRequestor, jobID types.JobID) (Response, erro
 	resp.Err = respEv.Err
 	return resp, nil
 }
+
+// Resume releases the targets held at the breakpoints of a job identified by
+// its ID, letting the job continue normally. If jobDescriptor is not empty, it
+// is an updated descriptor of the job, whose step parameters replace the
+// current ones for the steps which have not started yet. The updated
+// descriptor must have the same tests and steps as the original one.
+func (a *API) Resume(requestor EventRequestor, jobID types.JobID, jobDescriptor string) (Response, error) {
+	ev := &Event{
+		Type: EventTypeResume,
+		Msg: EventResumeMsg{
+			requestor:     requestor,
+			JobID:         jobID,
+			JobDescriptor: jobDescriptor,
+		},
+		RespCh: make(chan *EventResponse, 1),
+	}
+	resp := a.newResponse(ResponseTypeResume)
+	respEv, err := a.SendReceiveEvent(ev, nil)
+	if err != nil {
+		return resp, err
+	}
+	resp.Data = ResponseDataResume{
+		JobID: jobID,
+	}
+	resp.Err = respEv.Err
+	return resp, nil
+}
+
+// ReplayFailed starts a new job with the same description as the job
+// identified by its ID, over the targets which failed in its last run only.
+func (a *API) ReplayFailed(requestor EventRequestor, jobID types.JobID) (Response, error) {
+	ev := &Event{
+		Type: EventTypeReplayFailed,
+		Msg: EventReplayFailedMsg{
+			requestor: requestor,
+			JobID:     jobID,
+		},
+		RespCh: make(chan *EventResponse, 1),
+	}
+	resp := a.newResponse(ResponseTypeReplayFailed)
+	respEv, err := a.SendReceiveEvent(ev, nil)
+	if err != nil {
+		return resp, err
+	}
+	resp.Data = ResponseDataReplayFailed{
+		JobID:    jobID,
+		NewJobID: respEv.JobID,
+	}
+	resp.Err = respEv.Err
+	return resp, nil
+}
+
+// StoreTemplate stores a new version of the job template with the given name.
+// See job.Template for the syntax of its parameters.
+func (a *API) StoreTemplate(requestor EventRequestor, name, descriptor string) (Response, error) {
+	ev := &Event{
+		Type: EventTypeStoreTemplate,
+		Msg: EventStoreTemplateMsg{
+			requestor:  requestor,
+			Name:       name,
+			Descriptor: descriptor,
+		},
+		RespCh: make(chan *EventResponse, 1),
+	}
+	resp := a.newResponse(ResponseTypeStoreTemplate)
+	respEv, err := a.SendReceiveEvent(ev, nil)
+	if err != nil {
+		return resp, err
+	}
+	data := ResponseDataStoreTemplate{Name: name}
+	if respEv.Template != nil {
+		data.Version = respEv.Template.Version
+		// the template was validated when stored
+		data.Parameters, _ = respEv.Template.Parameters()
+	}
+	resp.Data = data
+	resp.Err = respEv.Err
+	return resp, nil
+}
+
+// StartTemplate starts a new job from a version of a stored job template, or
+// from its latest version if version is 0, with the given parameter values.
+func (a *API) StartTemplate(requestor EventRequestor, name string, version uint, values map[string]string) (Response, error) {
+	ev := &Event{
+		Type: EventTypeStartTemplate,
+		Msg: EventStartTemplateMsg{
+			requestor: requestor,
+			Name:      name,
+			Version:   version,
+			Values:    values,
+		},
+		RespCh: make(chan *EventResponse, 1),
+	}
+	resp := a.newResponse(ResponseTypeStartTemplate)
+	respEv, err := a.SendReceiveEvent(ev, nil)
+	if err != nil {
+		return resp, err
+	}
+	data := ResponseDataStartTemplate{JobID: respEv.JobID, Name: name, Version: version}
+	if respEv.Template != nil {
+		data.Version = respEv.Template.Version
+	}
+	resp.Data = data
+	resp.Err = respEv.Err
+	return resp, nil
+}
+
+// TestEvents returns a page of at most limit test events of a job, in the
+// order in which they were emitted, or DefaultTestEventsLimit events if limit
+// is 0. The first page is returned for an empty cursor, and the following ones
+// for the cursor returned with the previous page. Events emitted while paging
+// appear in the later pages.
+func (a *API) TestEvents(requestor EventRequestor, jobID types.JobID, limit int, cursor string) (Response, error) {
+	resp := a.newResponse(ResponseTypeTestEvents)
+	if limit == 0 {
+		limit = DefaultTestEventsLimit
+	}
+	if limit < 0 || limit > MaxTestEventsLimit {
+		return resp, fmt.Errorf("invalid limit %d: must be between 1 and %d", limit, MaxTestEventsLimit)
+	}
+	after, err := decodeCursor(jobID, cursor)
+	if err != nil {
+		return resp, err
+	}
+	ev := &Event{
+		Type: EventTypeTestEvents,
+		Msg: EventTestEventsMsg{
+			requestor:     requestor,
+			JobID:         jobID,
+			AfterSequence: after,
+			Limit:         limit,
+		},
+		RespCh: make(chan *EventResponse, 1),
+	}
+	respEv, err := a.SendReceiveEvent(ev, nil)
+	if err != nil {
+		return resp, err
+	}
+	data := ResponseDataTestEvents{JobID: jobID, Cursor: cursor}
+	if respEv.TestEvents != nil {
+		data.Events = respEv.TestEvents.Events
+		data.Cursor = encodeCursor(jobID, respEv.TestEvents.LastSequence)
+		data.More = respEv.TestEvents.More
+	}
+	resp.Data = data
+	resp.Err = respEv.Err
+	return resp, nil
+}
+
+// Verdict returns the verdict of a job, which is produced when the job ends.
+func (a *API) Verdict(requestor EventRequestor, jobID types.JobID) (Response, error) {
+	resp := a.newResponse(ResponseTypeVerdict)
+	ev := &Event{
+		Type: EventTypeVerdict,
+		Msg: EventVerdictMsg{
+			requestor: requestor,
+			JobID:     jobID,
+		},
+		RespCh: make(chan *EventResponse, 1),
+	}
+	respEv, err := a.SendReceiveEvent(ev, nil)
+	if err != nil {
+		return resp, err
+	}
+	resp.Data = ResponseDataVerdict{JobID: jobID, Verdict: respEv.Verdict}
+	resp.Err = respEv.Err
+	return resp, nil
+}
+
+// CreateSchedule creates a schedule which submits a job with the given
+// descriptor, and parameter values, at the times of the recurrence. The jobs
+// of a schedule share a series ID, recorded with their requests.
+func (a *API) CreateSchedule(requestor EventRequestor, jobDescriptor string, recurrence job.Recurrence, values map[string]string) (Response, error) {
+	return a.sendScheduleEvent(EventTypeCreateSchedule, EventCreateScheduleMsg{
+		requestor:     requestor,
+		JobDescriptor: jobDescriptor,
+		Recurrence:    recurrence,
+		Parameters:    values,
+	})
+}
+
+// Schedule returns a job schedule, with the IDs of the jobs it submitted.
+func (a *API) Schedule(requestor EventRequestor, id uint64) (Response, error) {
+	return a.sendScheduleEvent(EventTypeGetSchedule, EventGetScheduleMsg{requestor: requestor, ScheduleID: id})
+}
+
+// Schedules returns all the job schedules, including the cancelled ones.
+func (a *API) Schedules(requestor EventRequestor) (Response, error) {
+	ev := &Event{
+		Type:   EventTypeListSchedules,
+		Msg:    EventListSchedulesMsg{requestor: requestor},
+		RespCh: make(chan *EventResponse, 1),
+	}
+	resp := a.newResponse(ResponseTypeSchedules)
+	respEv, err := a.SendReceiveEvent(ev, nil)
+	if err != nil {
+		return resp, err
+	}
+	resp.Data = ResponseDataSchedules{Schedules: respEv.Schedules}
+	resp.Err = respEv.Err
+	return resp, nil
+}
+
+// Plugins lists the plugins registered in the server, with the parameter
+// schemas of the test steps which declare one.
+func (a *API) Plugins(requestor EventRequestor) (Response, error) {
+	ev := &Event{
+		Type:   EventTypeListPlugins,
+		Msg:    EventListPluginsMsg{requestor: requestor},
+		RespCh: make(chan *EventResponse, 1),
+	}
+	resp := a.newResponse(ResponseTypePlugins)
+	respEv, err := a.SendReceiveEvent(ev, nil)
+	if err != nil {
+		return resp, err
+	}
+	resp.Data = ResponseDataPlugins{Plugins: respEv.Plugins}
+	resp.Err = respEv.Err
+	return resp, nil
+}
+
+// QuarantinedTargets lists the targets quarantined for failing too often.
+func (a *API) QuarantinedTargets(requestor EventRequestor) (Response, error) {
+	return a.sendTargetHealthEvent(EventTypeListQuarantinedTargets, EventListQuarantinedTargetsMsg{requestor: requestor})
+}
+
+// ReleaseTarget takes a target out of quarantine, and returns its health
+// record.
+func (a *API) ReleaseTarget(requestor EventRequestor, targetID string) (Response, error) {
+	if targetID == "" {
+		return a.newResponse(ResponseTypeTargetHealth), errors.New("target ID cannot be empty")
+	}
+	return a.sendTargetHealthEvent(EventTypeReleaseTarget, EventReleaseTargetMsg{requestor: requestor, TargetID: targetID})
+}
+
+// sendTargetHealthEvent sends an event whose response lists health records.
+func (a *API) sendTargetHealthEvent(eventType EventType, msg EventMsg) (Response, error) {
+	ev := &Event{
+		Type:   eventType,
+		Msg:    msg,
+		RespCh: make(chan *EventResponse, 1),
+	}
+	resp := a.newResponse(ResponseTypeTargetHealth)
+	respEv, err := a.SendReceiveEvent(ev, nil)
+	if err != nil {
+		return resp, err
+	}
+	resp.Data = ResponseDataTargetHealth{Targets: respEv.TargetHealth}
+	resp.Err = respEv.Err
+	return resp, nil
+}
+
+// PauseSchedule stops a schedule from submitting jobs until it is resumed.
+// The jobs already submitted are not affected.
+func (a *API) PauseSchedule(requestor EventRequestor, id uint64) (Response, error) {
+	return a.setScheduleState(requestor, id, job.SchedulePaused)
+}
+
+// ResumeSchedule resumes a paused schedule. The runs missed while it was
+// paused are skipped.
+func (a *API) ResumeSchedule(requestor EventRequestor, id uint64) (Response, error) {
+	return a.setScheduleState(requestor, id, job.ScheduleActive)
+}
+
+// CancelSchedule stops a schedule for good.
+func (a *API) CancelSchedule(requestor EventRequestor, id uint64) (Response, error) {
+	return a.setScheduleState(requestor, id, job.ScheduleCancelled)
+}
+
+func (a *API) setScheduleState(requestor EventRequestor, id uint64, state job.ScheduleState) (Response, error) {
+	return a.sendScheduleEvent(EventTypeSetScheduleState, EventSetScheduleStateMsg{requestor: requestor, ScheduleID: id, State: state})
+}
+
+// sendScheduleEvent sends an event whose response is about a single schedule.
+func (a *API) sendScheduleEvent(eventType EventType, msg EventMsg) (Response, error) {
+	ev := &Event{
+		Type:   eventType,
+		Msg:    msg,
+		RespCh: make(chan *EventResponse, 1),
+	}
+	resp := a.newResponse(ResponseTypeSchedule)
+	respEv, err := a.SendReceiveEvent(ev, nil)
+	if err != nil {
+		return resp, err
+	}
+	data := ResponseDataSchedule{JobIDs: respEv.SeriesJobIDs}
+	if len(respEv.Schedules) > 0 {
+		data.Schedule = respEv.Schedules[0]
+	}
+	resp.Data = data
+	resp.Err = respEv.Err
+	return resp, nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// Page sizes of the TestEvents requests.
+const (
+	DefaultTestEventsLimit = 100
+	MaxTestEventsLimit     = 1000
+)
+
+// encodeCursor returns the opaque cursor pointing after the event with the
+// given sequence number. It embeds the job ID, so that cursors cannot be
+// reused across jobs by mistake.
+func encodeCursor(jobID types.JobID, sequence uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d", jobID, sequence)))
+}
+
+// decodeCursor returns the sequence number of a cursor returned for the given
+// job, or 0 for an empty cursor.
+func decodeCursor(jobID types.JobID, cursor string) (uint64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	cursorJobID, err := types.ParseJobID(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	if cursorJobID != jobID {
+		return 0, fmt.Errorf("cursor %q belongs to job %s, not %s", cursor, cursorJobID, jobID)
+	}
+	sequence, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return sequence, nil
+}
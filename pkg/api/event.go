@@ -6,7 +6,10 @@
 package api
 
 import (
+	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/pluginregistry"
+	"github.com/facebookincubator/contest/pkg/target"
 	"github.com/facebookincubator/contest/pkg/types"
 )
 
@@ -27,11 +30,26 @@ func (e EventType) String() string {
 }
 
 var eventTypeNames = map[EventType]string{
-	EventTypeStart:  "event_type_start",
-	EventTypeStatus: "event_type_status",
-	EventTypeStop:   "event_type_stop",
-	EventTypeRetry:  "event_type_retry",
-	EventTypeError:  "event_type_error",
+	EventTypeStart:         "event_type_start",
+	EventTypeStatus:        "event_type_status",
+	EventTypeStop:          "event_type_stop",
+	EventTypeRetry:         "event_type_retry",
+	EventTypeError:         "event_type_error",
+	EventTypeResume:        "event_type_resume",
+	EventTypeReplayFailed:  "event_type_replay_failed",
+	EventTypeStoreTemplate: "event_type_store_template",
+	EventTypeStartTemplate: "event_type_start_template",
+	EventTypeTestEvents:    "event_type_test_events",
+	EventTypeVerdict:       "event_type_verdict",
+
+	EventTypeCreateSchedule:   "event_type_create_schedule",
+	EventTypeListSchedules:    "event_type_list_schedules",
+	EventTypeGetSchedule:      "event_type_get_schedule",
+	EventTypeSetScheduleState: "event_type_set_schedule_state",
+	EventTypeListPlugins:      "event_type_list_plugins",
+
+	EventTypeListQuarantinedTargets: "event_type_list_quarantined_targets",
+	EventTypeReleaseTarget:          "event_type_release_target",
 }
 
 // list of existing API event types.
@@ -41,6 +59,19 @@ const (
 	EventTypeStop
 	EventTypeRetry
 	EventTypeError
+	EventTypeResume
+	EventTypeReplayFailed
+	EventTypeStoreTemplate
+	EventTypeStartTemplate
+	EventTypeTestEvents
+	EventTypeVerdict
+	EventTypeCreateSchedule
+	EventTypeListSchedules
+	EventTypeGetSchedule
+	EventTypeSetScheduleState
+	EventTypeListPlugins
+	EventTypeListQuarantinedTargets
+	EventTypeReleaseTarget
 )
 
 // Event represents an event that the API can generate. This is used by the API
@@ -68,6 +99,9 @@ type EventStartMsg struct {
 	TestID        string
 	NumRuns       uint32
 	JobDescriptor string
+	// Parameters are the values of the parameters declared by the job
+	// descriptor.
+	Parameters map[string]string
 }
 
 // Requestor returns the requestor of the API call as reported by the client.
@@ -100,10 +134,164 @@ type EventRetryMsg struct {
 // Requestor returns the requestor of the API call as reported by the client.
 func (e EventRetryMsg) Requestor() EventRequestor { return e.requestor }
 
+// EventResumeMsg contains the arguments for an event of type Resume.
+type EventResumeMsg struct {
+	requestor EventRequestor
+	JobID     types.JobID
+	// JobDescriptor is an optional updated descriptor of the job.
+	JobDescriptor string
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventResumeMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventReplayFailedMsg contains the arguments for an event of type
+// ReplayFailed.
+type EventReplayFailedMsg struct {
+	requestor EventRequestor
+	JobID     types.JobID
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventReplayFailedMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventStoreTemplateMsg contains the arguments for an event of type
+// StoreTemplate.
+type EventStoreTemplateMsg struct {
+	requestor  EventRequestor
+	Name       string
+	Descriptor string
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventStoreTemplateMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventStartTemplateMsg contains the arguments for an event of type
+// StartTemplate.
+type EventStartTemplateMsg struct {
+	requestor EventRequestor
+	Name      string
+	// Version is the template version to use, or 0 for the latest one.
+	Version uint
+	Values  map[string]string
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventStartTemplateMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventTestEventsMsg contains the arguments for an event of type TestEvents.
+type EventTestEventsMsg struct {
+	requestor EventRequestor
+	JobID     types.JobID
+	// AfterSequence is the sequence number of the last event of the previous
+	// page, or 0 for the first page.
+	AfterSequence uint64
+	Limit         int
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventTestEventsMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventVerdictMsg is an EventMsg for Verdict requests.
+type EventVerdictMsg struct {
+	requestor EventRequestor
+	JobID     types.JobID
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventVerdictMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventCreateScheduleMsg contains the arguments for an event of type
+// CreateSchedule.
+type EventCreateScheduleMsg struct {
+	requestor     EventRequestor
+	JobDescriptor string
+	Recurrence    job.Recurrence
+	// Parameters are the values of the parameters declared by the job
+	// descriptor, used by all the jobs of the schedule.
+	Parameters map[string]string
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventCreateScheduleMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventListSchedulesMsg contains the arguments for an event of type
+// ListSchedules.
+type EventListSchedulesMsg struct {
+	requestor EventRequestor
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventListSchedulesMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventGetScheduleMsg contains the arguments for an event of type
+// GetSchedule.
+type EventGetScheduleMsg struct {
+	requestor  EventRequestor
+	ScheduleID uint64
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventGetScheduleMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventSetScheduleStateMsg contains the arguments for an event of type
+// SetScheduleState, which pauses, resumes or cancels a schedule.
+type EventSetScheduleStateMsg struct {
+	requestor  EventRequestor
+	ScheduleID uint64
+	State      job.ScheduleState
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventSetScheduleStateMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventListPluginsMsg contains the arguments for an event of type
+// ListPlugins.
+type EventListPluginsMsg struct {
+	requestor EventRequestor
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventListPluginsMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventListQuarantinedTargetsMsg contains the arguments for an event of type
+// ListQuarantinedTargets.
+type EventListQuarantinedTargetsMsg struct {
+	requestor EventRequestor
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventListQuarantinedTargetsMsg) Requestor() EventRequestor { return e.requestor }
+
+// EventReleaseTargetMsg contains the arguments for an event of type
+// ReleaseTarget, which takes a target out of quarantine.
+type EventReleaseTargetMsg struct {
+	requestor EventRequestor
+	TargetID  string
+}
+
+// Requestor returns the requestor of the API call as reported by the client.
+func (e EventReleaseTargetMsg) Requestor() EventRequestor { return e.requestor }
+
 // EventResponse is a response to an EventMsg.
 type EventResponse struct {
 	Requestor EventRequestor
 	JobID     types.JobID
 	Err       error
 	Status    *job.Status
+	// Template is the template stored, or used to start a job.
+	Template *job.Template
+	// TestEvents is a page of the test events of a job.
+	TestEvents *testevent.Page
+	// Verdict is the verdict of a job, or nil if the job did not end yet.
+	Verdict *job.Verdict
+	// Schedules are the job schedules listed, or the one the request is
+	// about, and SeriesJobIDs the jobs it submitted.
+	Schedules    []*job.Schedule
+	SeriesJobIDs []types.JobID
+	// Plugins are the registered plugins, with their parameter schemas.
+	Plugins []pluginregistry.PluginInfo
+	// TargetHealth are the health records of the quarantined targets, or of
+	// the target released from quarantine.
+	TargetHealth []*target.Health
 }
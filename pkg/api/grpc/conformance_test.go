@@ -0,0 +1,387 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package grpc
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The messages are encoded by hand, so these tests check them against
+// contest.proto: the proto file is parsed, and the fields each message
+// encodes must have the names, numbers, wire types and cardinalities which it
+// declares.
+
+// protoField is a field declared by contest.proto.
+type protoField struct {
+	name     string
+	typ      string
+	number   int
+	repeated bool
+}
+
+// protoFile is what the tests need to know about contest.proto.
+type protoFile struct {
+	pkg      string
+	service  string
+	rpcs     map[string][2]string
+	messages map[string][]protoField
+}
+
+var (
+	protoPackageRe = regexp.MustCompile(`^package\s+([\w.]+)\s*;`)
+	protoServiceRe = regexp.MustCompile(`^service\s+(\w+)\s*\{`)
+	protoRPCRe     = regexp.MustCompile(`^rpc\s+(\w+)\s*\(\s*(\w+)\s*\)\s*returns\s*\(\s*(\w+)\s*\)\s*;`)
+	protoMessageRe = regexp.MustCompile(`^message\s+(\w+)\s*\{\s*(\})?$`)
+	protoFieldRe   = regexp.MustCompile(`^(repeated\s+)?(map<\s*\w+\s*,\s*\w+\s*>|[\w.]+)\s+(\w+)\s*=\s*(\d+)\s*;`)
+)
+
+// parseProto parses the subset of the proto3 syntax which contest.proto uses.
+func parseProto(t *testing.T) *protoFile {
+	data, err := ioutil.ReadFile("contest.proto")
+	require.NoError(t, err)
+	f := protoFile{rpcs: make(map[string][2]string), messages: make(map[string][]protoField)}
+	var message string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if m := protoPackageRe.FindStringSubmatch(line); m != nil {
+			f.pkg = m[1]
+		} else if m := protoServiceRe.FindStringSubmatch(line); m != nil {
+			f.service = m[1]
+		} else if m := protoRPCRe.FindStringSubmatch(line); m != nil {
+			f.rpcs[m[1]] = [2]string{m[2], m[3]}
+		} else if m := protoMessageRe.FindStringSubmatch(line); m != nil {
+			f.messages[m[1]] = []protoField{}
+			if m[2] == "" {
+				message = m[1]
+			}
+		} else if line == "}" {
+			message = ""
+		} else if m := protoFieldRe.FindStringSubmatch(line); m != nil && message != "" {
+			number, err := strconv.Atoi(m[4])
+			require.NoError(t, err)
+			f.messages[message] = append(f.messages[message], protoField{
+				name:     m[3],
+				typ:      strings.Replace(m[2], " ", "", -1),
+				number:   number,
+				repeated: m[1] != "",
+			})
+		} else if message != "" && line != "" && !strings.HasPrefix(line, "//") {
+			t.Fatalf("contest.proto:%d: unsupported declaration %q", i+1, line)
+		}
+	}
+	// The well-known and implicit messages the fields refer to.
+	f.messages["google.protobuf.Timestamp"] = []protoField{
+		{name: "seconds", typ: "int64", number: 1},
+		{name: "nanos", typ: "int32", number: 2},
+	}
+	f.messages["map<string,string>"] = []protoField{
+		{name: "key", typ: "string", number: 1},
+		{name: "value", typ: "string", number: 2},
+	}
+	return &f
+}
+
+// protoMessages returns new instances of the Go types of the messages of
+// contest.proto.
+var protoMessages = map[string]func() Message{
+	"StartRequest":   func() Message { return &StartRequest{} },
+	"StartResponse":  func() Message { return &StartResponse{} },
+	"StopRequest":    func() Message { return &JobRequest{} },
+	"StopResponse":   func() Message { return &StopResponse{} },
+	"StatusRequest":  func() Message { return &JobRequest{} },
+	"StatusResponse": func() Message { return &StatusResponse{} },
+	"RetryRequest":   func() Message { return &JobRequest{} },
+	"RetryResponse":  func() Message { return &RetryResponse{} },
+	"ReportRequest":  func() Message { return &JobRequest{} },
+	"ReportResponse": func() Message { return &ReportResponse{} },
+	"JobStatus":      func() Message { return &JobStatus{} },
+	"Summary":        func() Message { return &Summary{} },
+	"TargetStatus":   func() Message { return &TargetStatus{} },
+	"ResultSummary":  func() Message { return &ResultSummary{} },
+	"Report":         func() Message { return &Report{} },
+	"Verdict":        func() Message { return &Verdict{} },
+	"StepVerdict":    func() Message { return &StepVerdict{} },
+	"FailedTarget":   func() Message { return &FailedTarget{} },
+}
+
+// goFieldName returns the name of the Go field of a proto field.
+func goFieldName(name string) string {
+	var b strings.Builder
+	for _, word := range strings.Split(name, "_") {
+		switch word {
+		case "id", "json":
+			b.WriteString(strings.ToUpper(word))
+		default:
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
+
+// protoWireType returns the wire type of the values of a type.
+func protoWireType(typ string) int {
+	switch typ {
+	case "bool", "int32", "int64", "uint32", "uint64":
+		return wireVarint
+	case "double", "fixed64":
+		return wireFixed64
+	case "float", "fixed32":
+		return wireFixed32
+	default:
+		return wireBytes
+	}
+}
+
+// populate sets every field of a value, with values which are distinct and
+// not zero, so that they are all encoded. Repeated fields and maps get two
+// elements.
+func populate(v reflect.Value, n *int) {
+	*n++
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(fmt.Sprintf("value %d", *n))
+	case reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(*n) << 20)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Float64:
+		v.SetFloat(float64(*n) + 0.25)
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		populate(v.Elem(), n)
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), 2, 2)
+		for i := 0; i < s.Len(); i++ {
+			populate(s.Index(i), n)
+		}
+		v.Set(s)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for i := 0; i < 2; i++ {
+			key, value := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+			populate(key, n)
+			populate(value, n)
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			v.Set(reflect.ValueOf(time.Unix(1600000000+int64(*n), int64(*n)*1000)))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			populate(v.Field(i), n)
+		}
+	default:
+		panic(fmt.Sprintf("unsupported kind %s", v.Kind()))
+	}
+}
+
+// checkWire walks the fields of an encoded message independently of the
+// decoder, and checks them against the declaration of the message.
+// populated tells whether all the fields must be present, with two elements
+// for the repeated ones.
+func checkWire(t *testing.T, f *protoFile, path, message string, data []byte, populated bool) {
+	fields := make(map[int]protoField)
+	for _, field := range f.messages[message] {
+		fields[field.number] = field
+	}
+	seen := make(map[int]int)
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		require.True(t, n > 0, "%s: invalid tag", path)
+		data = data[n:]
+		number, wireType := int(tag>>3), int(tag&7)
+		field, ok := fields[number]
+		require.True(t, ok, "%s: field %d is not declared by %s", path, number, message)
+		fieldPath := path + "." + field.name
+		require.Equal(t, protoWireType(field.typ), wireType, "%s: wire type", fieldPath)
+		seen[number]++
+
+		switch wireType {
+		case wireVarint:
+			_, n = binary.Uvarint(data)
+			require.True(t, n > 0, "%s: invalid varint", fieldPath)
+			data = data[n:]
+		case wireFixed64:
+			require.True(t, len(data) >= 8, "%s: truncated", fieldPath)
+			data = data[8:]
+		case wireFixed32:
+			require.True(t, len(data) >= 4, "%s: truncated", fieldPath)
+			data = data[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			require.True(t, n > 0 && length <= uint64(len(data)-n), "%s: invalid length", fieldPath)
+			value := data[n : n+int(length)]
+			data = data[n+int(length):]
+			if _, ok := f.messages[field.typ]; ok {
+				checkWire(t, f, fieldPath, field.typ, value, populated)
+			}
+		}
+	}
+	if !populated {
+		return
+	}
+	for number, field := range fields {
+		expected := 1
+		if field.repeated || strings.HasPrefix(field.typ, "map<") {
+			expected = 2
+		}
+		require.Equal(t, expected, seen[number], "%s.%s: number of values", path, field.name)
+	}
+}
+
+func TestMessagesMatchProto(t *testing.T) {
+	f := parseProto(t)
+
+	require.Equal(t, ServiceName, f.pkg+"."+f.service)
+	h := NewHandler(nil).(*handler)
+	var rpcs, methods []string
+	for name, types := range f.rpcs {
+		rpcs = append(rpcs, name)
+		for _, typ := range types {
+			require.Contains(t, protoMessages, typ, "message of rpc %s", name)
+		}
+	}
+	for name := range h.methods {
+		methods = append(methods, name)
+	}
+	sort.Strings(rpcs)
+	sort.Strings(methods)
+	require.Equal(t, rpcs, methods)
+
+	for name := range f.messages {
+		if name == "google.protobuf.Timestamp" || strings.HasPrefix(name, "map<") {
+			continue
+		}
+		require.Contains(t, protoMessages, name, "Go type of message %s", name)
+	}
+	for name, newMessage := range protoMessages {
+		name, newMessage := name, newMessage
+		t.Run(name, func(t *testing.T) {
+			fields, ok := f.messages[name]
+			require.True(t, ok, "message %s is not declared by contest.proto", name)
+			goType := reflect.TypeOf(newMessage()).Elem()
+			require.Equal(t, len(fields), goType.NumField(), "number of fields")
+			for _, field := range fields {
+				_, ok := goType.FieldByName(goFieldName(field.name))
+				require.True(t, ok, "no Go field for field %s", field.name)
+			}
+
+			// A message with all its fields set encodes all of them as
+			// declared, and decodes back to the same value.
+			m := newMessage()
+			var n int
+			populate(reflect.ValueOf(m).Elem(), &n)
+			data := Marshal(m)
+			checkWire(t, f, name, name, data, true)
+			decoded := newMessage()
+			require.NoError(t, Unmarshal(data, decoded))
+			require.Equal(t, m, decoded)
+
+			// An empty message encodes nothing, as in proto3.
+			require.Empty(t, Marshal(newMessage()))
+		})
+	}
+}
+
+// The golden encodings were derived by hand from the protobuf encoding
+// specification, emitting the fields in the order of their numbers as the
+// official encoders do. To check one of them against protoc, run
+//
+//	echo '<text>' | protoc --encode=contest.api.v1.<message> contest.proto | xxd -p
+//
+// from this directory.
+func TestGoldenEncodings(t *testing.T) {
+	for _, tc := range []struct {
+		message string
+		text    string
+		value   Message
+		hex     string
+	}{
+		{
+			message: "StartRequest",
+			text:    `requestor: "alice" job_descriptor: "{}" parameters { key: "k" value: "v" }`,
+			value: &StartRequest{
+				Requestor:     "alice",
+				JobDescriptor: "{}",
+				Parameters:    map[string]string{"k": "v"},
+			},
+			hex: "0a05616c69636512027b7d1a060a016b120176",
+		},
+		{
+			message: "StatusRequest",
+			text:    `requestor: "alice" job_id: "42"`,
+			value:   &JobRequest{Requestor: "alice", JobID: "42"},
+			hex:     "0a05616c69636512023432",
+		},
+		{
+			message: "StopResponse",
+			value:   &StopResponse{},
+		},
+		{
+			message: "StatusResponse",
+			text: `status { name: "job" state: "started" queue_position: 300 ` +
+				`start_time { seconds: 1600000000 nanos: 5 } run_id: 2 ` +
+				`summary { passed: 1 pass_percent: 50 success: true } }`,
+			value: &StatusResponse{Status: &JobStatus{
+				Name:          "job",
+				State:         "started",
+				QueuePosition: 300,
+				StartTime:     time.Unix(1600000000, 5),
+				RunID:         2,
+				Summary:       &Summary{Passed: 1, PassPercent: 50, Success: true},
+			}},
+			hex: "0a2c0a036a6f6212077374617274656418ac0222080880a0f8fa05100530023a0d08012900000000000049403001",
+		},
+		{
+			message: "RetryResponse",
+			text:    `job_id: "7" new_job_id: "8"`,
+			value:   &RetryResponse{JobID: "7", NewJobID: "8"},
+			hex:     "0a0137120138",
+		},
+		{
+			message: "ReportResponse",
+			text: `job_id: "7" verdict { state: "done" success: true runs: 1 ` +
+				`steps { test: "t" step: "s" passed: 1 } time { seconds: 1 } } ` +
+				`run_reports { run_id: 1 reporter_name: "r" success: true data_json: "{}" }`,
+			value: &ReportResponse{
+				JobID: "7",
+				Verdict: &Verdict{
+					State:   "done",
+					Success: true,
+					Runs:    1,
+					Steps:   []*StepVerdict{{Test: "t", Step: "s", Passed: 1}},
+					Time:    time.Unix(1, 0),
+				},
+				RunReports: []*Report{{RunID: 1, ReporterName: "r", Success: true, DataJSON: "{}"}},
+			},
+			hex: "0a013712180a04646f6e651001200152080a01741201731801620208011a0b080112017218012a027b7d",
+		},
+	} {
+		t.Run(tc.message, func(t *testing.T) {
+			data, err := hex.DecodeString(tc.hex)
+			require.NoError(t, err)
+			require.Equal(t, tc.hex, hex.EncodeToString(Marshal(tc.value)))
+			decoded := protoMessages[tc.message]()
+			require.NoError(t, Unmarshal(data, decoded))
+			require.Equal(t, tc.value, decoded)
+			checkWire(t, parseProto(t), tc.message, tc.message, data, false)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package grpc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/job"
+)
+
+// newJobStatus converts the status of a job. The targets are those of the
+// current run.
+func newJobStatus(s *job.Status) *JobStatus {
+	status := JobStatus{
+		Name:          s.Name,
+		State:         s.State,
+		QueuePosition: uint32(s.QueuePosition),
+		StartTime:     s.StartTime,
+		EndTime:       s.EndTime,
+		RunID:         uint64(s.RunStatus.RunID),
+	}
+	if s.Summary != nil {
+		status.Summary = &Summary{
+			Passed:              uint32(s.Summary.Passed),
+			Failed:              uint32(s.Summary.Failed),
+			InformationalPassed: uint32(s.Summary.InformationalPassed),
+			InformationalFailed: uint32(s.Summary.InformationalFailed),
+			PassPercent:         s.Summary.PassPercent,
+			Success:             s.Summary.Success,
+		}
+	}
+	for _, t := range s.RunStatus.TestStatuses {
+		for _, ts := range t.TargetStatuses {
+			target := TargetStatus{
+				TestName:      t.TestName,
+				StepLabel:     ts.TestStepLabel,
+				InTime:        ts.InTime,
+				OutTime:       ts.OutTime,
+				Error:         ts.Error,
+				Verdict:       string(ts.Verdict),
+				Reason:        ts.Reason,
+				Informational: ts.Informational,
+			}
+			if ts.Target != nil {
+				target.TargetID, target.TargetName = ts.Target.ID, ts.Target.Name
+			}
+			status.Targets = append(status.Targets, &target)
+		}
+	}
+	for _, r := range s.Results {
+		status.Results = append(status.Results, &ResultSummary{
+			Measurement: r.Measurement,
+			Unit:        r.Unit,
+			Passed:      uint32(r.Passed),
+			Failed:      uint32(r.Failed),
+			Skipped:     uint32(r.Skipped),
+			Values:      uint32(r.Values),
+			Min:         r.Min,
+			Max:         r.Max,
+			Mean:        r.Mean,
+		})
+	}
+	return &status
+}
+
+// newVerdict converts the verdict of a job, or returns nil if there is none
+// yet.
+func newVerdict(v *job.Verdict) *Verdict {
+	if v == nil {
+		return nil
+	}
+	verdict := Verdict{
+		State:               v.State,
+		Success:             v.Success,
+		Error:               v.Error,
+		Runs:                uint32(v.Runs),
+		Passed:              uint32(v.Counts.Passed),
+		Failed:              uint32(v.Counts.Failed),
+		InformationalPassed: uint32(v.Counts.InformationalPassed),
+		InformationalFailed: uint32(v.Counts.InformationalFailed),
+		PassPercent:         v.Counts.PassPercent,
+		Time:                v.Time,
+	}
+	for _, s := range v.Steps {
+		verdict.Steps = append(verdict.Steps, &StepVerdict{
+			Test:    s.Test,
+			Step:    s.Step,
+			Passed:  uint32(s.Passed),
+			Failed:  uint32(s.Failed),
+			Skipped: uint32(s.Skipped),
+		})
+	}
+	for _, t := range v.FailedTargets {
+		verdict.FailedTargets = append(verdict.FailedTargets, &FailedTarget{
+			RunID:         uint64(t.Run),
+			Test:          t.Test,
+			Step:          t.Step,
+			TargetID:      t.TargetID,
+			TargetName:    t.TargetName,
+			Error:         t.Error,
+			Informational: t.Informational,
+		})
+	}
+	return &verdict
+}
+
+// newReport converts a report, with the run it belongs to, or 0 for a final
+// report.
+func newReport(runID uint64, r *job.Report) (*Report, error) {
+	data, err := r.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode the data of report %s: %v", r.ReporterName, err)
+	}
+	return &Report{
+		RunID:        runID,
+		ReporterName: r.ReporterName,
+		Success:      r.Success,
+		ReportTime:   r.ReportTime,
+		DataJSON:     strings.TrimSuffix(string(data), "\n"),
+	}, nil
+}
+
+// newReportResponse builds the response of the GetReport method.
+func newReportResponse(jobID string, v *job.Verdict, report *job.JobReport) (*ReportResponse, error) {
+	resp := ReportResponse{JobID: jobID, Verdict: newVerdict(v)}
+	if report == nil {
+		return &resp, nil
+	}
+	for i, reports := range report.RunReports {
+		for _, r := range reports {
+			// runs are numbered from 1
+			pr, err := newReport(uint64(i+1), r)
+			if err != nil {
+				return nil, err
+			}
+			resp.RunReports = append(resp.RunReports, pr)
+		}
+	}
+	for _, r := range report.FinalReports {
+		pr, err := newReport(0, r)
+		if err != nil {
+			return nil, err
+		}
+		resp.FinalReports = append(resp.FinalReports, pr)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,563 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package grpc
+
+import (
+	"time"
+)
+
+// The messages of contest.proto. The field numbers must be kept in sync with
+// the proto file.
+
+// StartRequest is the request of the Start method.
+type StartRequest struct {
+	Requestor     string
+	JobDescriptor string
+	Parameters    map[string]string
+}
+
+func (m *StartRequest) marshal(e *encoder) {
+	e.string(1, m.Requestor)
+	e.string(2, m.JobDescriptor)
+	e.stringMap(3, m.Parameters)
+}
+
+func (m *StartRequest) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Requestor = d.string()
+		case 2:
+			m.JobDescriptor = d.string()
+		case 3:
+			var entry mapEntry
+			d.message(&entry)
+			if m.Parameters == nil {
+				m.Parameters = make(map[string]string)
+			}
+			m.Parameters[entry.key] = entry.value
+		}
+	}
+}
+
+// StartResponse is the response of the Start method.
+type StartResponse struct {
+	JobID string
+}
+
+func (m *StartResponse) marshal(e *encoder) {
+	e.string(1, m.JobID)
+}
+
+func (m *StartResponse) unmarshal(d *decoder) {
+	for d.next() {
+		if d.field == 1 {
+			m.JobID = d.string()
+		}
+	}
+}
+
+// JobRequest encodes the requests of the methods which act on a job, i.e.
+// StopRequest, StatusRequest, RetryRequest and ReportRequest, which have the
+// same fields.
+type JobRequest struct {
+	Requestor string
+	JobID     string
+}
+
+func (m *JobRequest) marshal(e *encoder) {
+	e.string(1, m.Requestor)
+	e.string(2, m.JobID)
+}
+
+func (m *JobRequest) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Requestor = d.string()
+		case 2:
+			m.JobID = d.string()
+		}
+	}
+}
+
+// StopResponse is the response of the Stop method.
+type StopResponse struct{}
+
+func (m *StopResponse) marshal(e *encoder) {}
+
+func (m *StopResponse) unmarshal(d *decoder) {
+	for d.next() {
+	}
+}
+
+// StatusResponse is the response of the Status method.
+type StatusResponse struct {
+	Status *JobStatus
+}
+
+func (m *StatusResponse) marshal(e *encoder) {
+	if m.Status != nil {
+		e.message(1, m.Status)
+	}
+}
+
+func (m *StatusResponse) unmarshal(d *decoder) {
+	for d.next() {
+		if d.field == 1 {
+			m.Status = &JobStatus{}
+			d.message(m.Status)
+		}
+	}
+}
+
+// RetryResponse is the response of the Retry method.
+type RetryResponse struct {
+	JobID    string
+	NewJobID string
+}
+
+func (m *RetryResponse) marshal(e *encoder) {
+	e.string(1, m.JobID)
+	e.string(2, m.NewJobID)
+}
+
+func (m *RetryResponse) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.JobID = d.string()
+		case 2:
+			m.NewJobID = d.string()
+		}
+	}
+}
+
+// ReportResponse is the response of the GetReport method.
+type ReportResponse struct {
+	JobID string
+	// Verdict is nil until the job ends.
+	Verdict      *Verdict
+	RunReports   []*Report
+	FinalReports []*Report
+}
+
+func (m *ReportResponse) marshal(e *encoder) {
+	e.string(1, m.JobID)
+	if m.Verdict != nil {
+		e.message(2, m.Verdict)
+	}
+	for _, r := range m.RunReports {
+		e.message(3, r)
+	}
+	for _, r := range m.FinalReports {
+		e.message(4, r)
+	}
+}
+
+func (m *ReportResponse) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.JobID = d.string()
+		case 2:
+			m.Verdict = &Verdict{}
+			d.message(m.Verdict)
+		case 3:
+			r := &Report{}
+			d.message(r)
+			m.RunReports = append(m.RunReports, r)
+		case 4:
+			r := &Report{}
+			d.message(r)
+			m.FinalReports = append(m.FinalReports, r)
+		}
+	}
+}
+
+// JobStatus is the status of a job.
+type JobStatus struct {
+	Name          string
+	State         string
+	QueuePosition uint32
+	StartTime     time.Time
+	EndTime       time.Time
+	RunID         uint64
+	Summary       *Summary
+	Targets       []*TargetStatus
+	Results       []*ResultSummary
+}
+
+func (m *JobStatus) marshal(e *encoder) {
+	e.string(1, m.Name)
+	e.string(2, m.State)
+	e.uint(3, uint64(m.QueuePosition))
+	e.timestamp(4, m.StartTime)
+	e.timestamp(5, m.EndTime)
+	e.uint(6, m.RunID)
+	if m.Summary != nil {
+		e.message(7, m.Summary)
+	}
+	for _, t := range m.Targets {
+		e.message(8, t)
+	}
+	for _, r := range m.Results {
+		e.message(9, r)
+	}
+}
+
+func (m *JobStatus) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Name = d.string()
+		case 2:
+			m.State = d.string()
+		case 3:
+			m.QueuePosition = d.uint32()
+		case 4:
+			m.StartTime = d.timestamp()
+		case 5:
+			m.EndTime = d.timestamp()
+		case 6:
+			m.RunID = d.uint()
+		case 7:
+			m.Summary = &Summary{}
+			d.message(m.Summary)
+		case 8:
+			t := &TargetStatus{}
+			d.message(t)
+			m.Targets = append(m.Targets, t)
+		case 9:
+			r := &ResultSummary{}
+			d.message(r)
+			m.Results = append(m.Results, r)
+		}
+	}
+}
+
+// Summary counts the outcomes of the targets of a job.
+type Summary struct {
+	Passed              uint32
+	Failed              uint32
+	InformationalPassed uint32
+	InformationalFailed uint32
+	PassPercent         float64
+	Success             bool
+}
+
+func (m *Summary) marshal(e *encoder) {
+	e.uint(1, uint64(m.Passed))
+	e.uint(2, uint64(m.Failed))
+	e.uint(3, uint64(m.InformationalPassed))
+	e.uint(4, uint64(m.InformationalFailed))
+	e.double(5, m.PassPercent)
+	e.bool(6, m.Success)
+}
+
+func (m *Summary) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Passed = d.uint32()
+		case 2:
+			m.Failed = d.uint32()
+		case 3:
+			m.InformationalPassed = d.uint32()
+		case 4:
+			m.InformationalFailed = d.uint32()
+		case 5:
+			m.PassPercent = d.double()
+		case 6:
+			m.Success = d.bool()
+		}
+	}
+}
+
+// TargetStatus is the status of a target in the last step it reached.
+type TargetStatus struct {
+	TestName      string
+	StepLabel     string
+	TargetID      string
+	TargetName    string
+	InTime        time.Time
+	OutTime       time.Time
+	Error         string
+	Verdict       string
+	Reason        string
+	Informational bool
+}
+
+func (m *TargetStatus) marshal(e *encoder) {
+	e.string(1, m.TestName)
+	e.string(2, m.StepLabel)
+	e.string(3, m.TargetID)
+	e.string(4, m.TargetName)
+	e.timestamp(5, m.InTime)
+	e.timestamp(6, m.OutTime)
+	e.string(7, m.Error)
+	e.string(8, m.Verdict)
+	e.string(9, m.Reason)
+	e.bool(10, m.Informational)
+}
+
+func (m *TargetStatus) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.TestName = d.string()
+		case 2:
+			m.StepLabel = d.string()
+		case 3:
+			m.TargetID = d.string()
+		case 4:
+			m.TargetName = d.string()
+		case 5:
+			m.InTime = d.timestamp()
+		case 6:
+			m.OutTime = d.timestamp()
+		case 7:
+			m.Error = d.string()
+		case 8:
+			m.Verdict = d.string()
+		case 9:
+			m.Reason = d.string()
+		case 10:
+			m.Informational = d.bool()
+		}
+	}
+}
+
+// ResultSummary aggregates the structured results of a measurement.
+type ResultSummary struct {
+	Measurement string
+	Unit        string
+	Passed      uint32
+	Failed      uint32
+	Skipped     uint32
+	Values      uint32
+	Min         float64
+	Max         float64
+	Mean        float64
+}
+
+func (m *ResultSummary) marshal(e *encoder) {
+	e.string(1, m.Measurement)
+	e.string(2, m.Unit)
+	e.uint(3, uint64(m.Passed))
+	e.uint(4, uint64(m.Failed))
+	e.uint(5, uint64(m.Skipped))
+	e.uint(6, uint64(m.Values))
+	e.double(7, m.Min)
+	e.double(8, m.Max)
+	e.double(9, m.Mean)
+}
+
+func (m *ResultSummary) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Measurement = d.string()
+		case 2:
+			m.Unit = d.string()
+		case 3:
+			m.Passed = d.uint32()
+		case 4:
+			m.Failed = d.uint32()
+		case 5:
+			m.Skipped = d.uint32()
+		case 6:
+			m.Values = d.uint32()
+		case 7:
+			m.Min = d.double()
+		case 8:
+			m.Max = d.double()
+		case 9:
+			m.Mean = d.double()
+		}
+	}
+}
+
+// Report is a run report or a final report. DataJSON is the data of the
+// report encoded as JSON, since its structure depends on the reporter.
+type Report struct {
+	RunID        uint64
+	ReporterName string
+	Success      bool
+	ReportTime   time.Time
+	DataJSON     string
+}
+
+func (m *Report) marshal(e *encoder) {
+	e.uint(1, m.RunID)
+	e.string(2, m.ReporterName)
+	e.bool(3, m.Success)
+	e.timestamp(4, m.ReportTime)
+	e.string(5, m.DataJSON)
+}
+
+func (m *Report) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.RunID = d.uint()
+		case 2:
+			m.ReporterName = d.string()
+		case 3:
+			m.Success = d.bool()
+		case 4:
+			m.ReportTime = d.timestamp()
+		case 5:
+			m.DataJSON = d.string()
+		}
+	}
+}
+
+// Verdict is the verdict of a job which ended.
+type Verdict struct {
+	State               string
+	Success             bool
+	Error               string
+	Runs                uint32
+	Passed              uint32
+	Failed              uint32
+	InformationalPassed uint32
+	InformationalFailed uint32
+	PassPercent         float64
+	Steps               []*StepVerdict
+	FailedTargets       []*FailedTarget
+	Time                time.Time
+}
+
+func (m *Verdict) marshal(e *encoder) {
+	e.string(1, m.State)
+	e.bool(2, m.Success)
+	e.string(3, m.Error)
+	e.uint(4, uint64(m.Runs))
+	e.uint(5, uint64(m.Passed))
+	e.uint(6, uint64(m.Failed))
+	e.uint(7, uint64(m.InformationalPassed))
+	e.uint(8, uint64(m.InformationalFailed))
+	e.double(9, m.PassPercent)
+	for _, s := range m.Steps {
+		e.message(10, s)
+	}
+	for _, t := range m.FailedTargets {
+		e.message(11, t)
+	}
+	e.timestamp(12, m.Time)
+}
+
+func (m *Verdict) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.State = d.string()
+		case 2:
+			m.Success = d.bool()
+		case 3:
+			m.Error = d.string()
+		case 4:
+			m.Runs = d.uint32()
+		case 5:
+			m.Passed = d.uint32()
+		case 6:
+			m.Failed = d.uint32()
+		case 7:
+			m.InformationalPassed = d.uint32()
+		case 8:
+			m.InformationalFailed = d.uint32()
+		case 9:
+			m.PassPercent = d.double()
+		case 10:
+			s := &StepVerdict{}
+			d.message(s)
+			m.Steps = append(m.Steps, s)
+		case 11:
+			t := &FailedTarget{}
+			d.message(t)
+			m.FailedTargets = append(m.FailedTargets, t)
+		case 12:
+			m.Time = d.timestamp()
+		}
+	}
+}
+
+// StepVerdict counts the outcomes of the targets of a test step.
+type StepVerdict struct {
+	Test    string
+	Step    string
+	Passed  uint32
+	Failed  uint32
+	Skipped uint32
+}
+
+func (m *StepVerdict) marshal(e *encoder) {
+	e.string(1, m.Test)
+	e.string(2, m.Step)
+	e.uint(3, uint64(m.Passed))
+	e.uint(4, uint64(m.Failed))
+	e.uint(5, uint64(m.Skipped))
+}
+
+func (m *StepVerdict) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Test = d.string()
+		case 2:
+			m.Step = d.string()
+		case 3:
+			m.Passed = d.uint32()
+		case 4:
+			m.Failed = d.uint32()
+		case 5:
+			m.Skipped = d.uint32()
+		}
+	}
+}
+
+// FailedTarget is a target which failed a test, in the step it failed.
+type FailedTarget struct {
+	RunID         uint64
+	Test          string
+	Step          string
+	TargetID      string
+	TargetName    string
+	Error         string
+	Informational bool
+}
+
+func (m *FailedTarget) marshal(e *encoder) {
+	e.uint(1, m.RunID)
+	e.string(2, m.Test)
+	e.string(3, m.Step)
+	e.string(4, m.TargetID)
+	e.string(5, m.TargetName)
+	e.string(6, m.Error)
+	e.bool(7, m.Informational)
+}
+
+func (m *FailedTarget) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.RunID = d.uint()
+		case 2:
+			m.Test = d.string()
+		case 3:
+			m.Step = d.string()
+		case 4:
+			m.TargetID = d.string()
+		case 5:
+			m.TargetName = d.string()
+		case 6:
+			m.Error = d.string()
+		case 7:
+			m.Informational = d.bool()
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package grpc implements the ConTest service of contest.proto, which exposes
+// the API to gRPC clients. The gRPC protocol is served over the HTTP/2 server
+// of the standard library: requests are length-prefixed protobuf messages, and
+// the status of each call is returned in the grpc-status and grpc-message
+// trailers. Only unary calls without compression are supported, which is all
+// the service needs.
+package grpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+var log = logging.GetLogger("pkg/api/grpc")
+
+// ServiceName is the full name of the service of contest.proto.
+const ServiceName = "contest.api.v1.ConTest"
+
+// MaxMessageSize bounds the size of the request messages, as the default of
+// gRPC servers does.
+const MaxMessageSize = 4 << 20
+
+// The gRPC status codes returned by the service.
+const (
+	CodeOK                = 0
+	CodeUnknown           = 2
+	CodeInvalidArgument   = 3
+	CodeResourceExhausted = 8
+	CodeUnimplemented     = 12
+	CodeInternal          = 13
+	CodeUnavailable       = 14
+)
+
+// StatusError is an error with a gRPC status code.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("rpc error: code = %d desc = %s", e.Code, e.Message)
+}
+
+func statusErrorf(code int, format string, args ...interface{}) *StatusError {
+	return &StatusError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// method implements a method of the service, from the encoded request to the
+// response.
+type method func(request []byte) (Message, *StatusError)
+
+type handler struct {
+	api     *api.API
+	methods map[string]method
+}
+
+// NewHandler returns an http.Handler serving the ConTest service through the
+// given API. It must be served over HTTP/2, as gRPC requires.
+func NewHandler(a *api.API) http.Handler {
+	h := handler{api: a}
+	h.methods = map[string]method{
+		"Start":     h.start,
+		"Stop":      h.stop,
+		"Status":    h.status,
+		"Retry":     h.retry,
+		"GetReport": h.getReport,
+	}
+	return &h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "not a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	resp, err := h.serve(r)
+	if err == nil {
+		if _, writeErr := w.Write(EncodeFrame(Marshal(resp))); writeErr != nil {
+			log.Warningf("Could not write the response of %s: %v", r.URL.Path, writeErr)
+		}
+		w.Header().Set("Grpc-Status", strconv.Itoa(CodeOK))
+		return
+	}
+	log.Debugf("Call to %s failed: %v", r.URL.Path, err)
+	w.Header().Set("Grpc-Status", strconv.Itoa(err.Code))
+	w.Header().Set("Grpc-Message", encodeGRPCMessage(err.Message))
+}
+
+// serve decodes the request of a call, and calls its method.
+func (h *handler) serve(r *http.Request) (Message, *StatusError) {
+	name := strings.TrimPrefix(r.URL.Path, "/"+ServiceName+"/")
+	m, ok := h.methods[name]
+	if !ok || name == r.URL.Path {
+		return nil, statusErrorf(CodeUnimplemented, "unknown method %s", r.URL.Path)
+	}
+	if encoding := r.Header.Get("Grpc-Encoding"); encoding != "" && encoding != "identity" {
+		return nil, statusErrorf(CodeUnimplemented, "unsupported message encoding %s", encoding)
+	}
+	request, err := ReadFrame(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return m(request)
+}
+
+// EncodeFrame prefixes a message with the header of gRPC frames: its
+// compression flag, always 0, and its length.
+func EncodeFrame(msg []byte) []byte {
+	frame := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(msg)))
+	copy(frame[5:], msg)
+	return frame
+}
+
+// ReadFrame reads an uncompressed gRPC frame, and returns its message.
+func ReadFrame(r io.Reader) ([]byte, *StatusError) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, statusErrorf(CodeInvalidArgument, "cannot read message header: %v", err)
+	}
+	if header[0] != 0 {
+		return nil, statusErrorf(CodeUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxMessageSize {
+		return nil, statusErrorf(CodeResourceExhausted, "message of %d bytes exceeds the maximum of %d", size, MaxMessageSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, statusErrorf(CodeInvalidArgument, "cannot read message: %v", err)
+	}
+	return msg, nil
+}
+
+// encodeGRPCMessage percent-encodes a status message, as the grpc-message
+// trailer can only carry printable ASCII.
+func encodeGRPCMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// checkResponse maps the outcome of an API call to a status error.
+func checkResponse(resp api.Response, err error) *StatusError {
+	if err != nil {
+		return statusErrorf(CodeUnavailable, "could not reach the job manager: %v", err)
+	}
+	if resp.Err != nil {
+		return statusErrorf(CodeUnknown, "%v", resp.Err)
+	}
+	return nil
+}
+
+// decodeJobRequest decodes the request of a method which acts on a job.
+func (h *handler) decodeJobRequest(request []byte) (api.EventRequestor, types.JobID, *StatusError) {
+	var req JobRequest
+	if err := Unmarshal(request, &req); err != nil {
+		return "", "", statusErrorf(CodeInvalidArgument, "cannot decode request: %v", err)
+	}
+	if req.Requestor == "" {
+		return "", "", statusErrorf(CodeInvalidArgument, "requestor cannot be empty")
+	}
+	jobID, err := h.api.ParseJobID(req.JobID)
+	if err != nil {
+		return "", "", statusErrorf(CodeInvalidArgument, "%v", err)
+	}
+	return api.EventRequestor(req.Requestor), jobID, nil
+}
+
+func (h *handler) start(request []byte) (Message, *StatusError) {
+	var req StartRequest
+	if err := Unmarshal(request, &req); err != nil {
+		return nil, statusErrorf(CodeInvalidArgument, "cannot decode request: %v", err)
+	}
+	if req.Requestor == "" {
+		return nil, statusErrorf(CodeInvalidArgument, "requestor cannot be empty")
+	}
+	resp, err := h.api.StartWithParameters(api.EventRequestor(req.Requestor), req.JobDescriptor, req.Parameters)
+	if serr := checkResponse(resp, err); serr != nil {
+		return nil, serr
+	}
+	return &StartResponse{JobID: string(resp.Data.(api.ResponseDataStart).JobID)}, nil
+}
+
+func (h *handler) stop(request []byte) (Message, *StatusError) {
+	requestor, jobID, serr := h.decodeJobRequest(request)
+	if serr != nil {
+		return nil, serr
+	}
+	resp, err := h.api.Stop(requestor, jobID)
+	if serr = checkResponse(resp, err); serr != nil {
+		return nil, serr
+	}
+	return &StopResponse{}, nil
+}
+
+func (h *handler) status(request []byte) (Message, *StatusError) {
+	requestor, jobID, serr := h.decodeJobRequest(request)
+	if serr != nil {
+		return nil, serr
+	}
+	resp, err := h.api.Status(requestor, jobID)
+	if serr = checkResponse(resp, err); serr != nil {
+		return nil, serr
+	}
+	status := resp.Data.(api.ResponseDataStatus).Status
+	if status == nil {
+		return nil, statusErrorf(CodeInternal, "no status returned for job %s", jobID)
+	}
+	return &StatusResponse{Status: newJobStatus(status)}, nil
+}
+
+func (h *handler) retry(request []byte) (Message, *StatusError) {
+	requestor, jobID, serr := h.decodeJobRequest(request)
+	if serr != nil {
+		return nil, serr
+	}
+	resp, err := h.api.Retry(requestor, jobID)
+	if serr = checkResponse(resp, err); serr != nil {
+		return nil, serr
+	}
+	data := resp.Data.(api.ResponseDataRetry)
+	return &RetryResponse{JobID: string(data.JobID), NewJobID: string(data.NewJobID)}, nil
+}
+
+// getReport combines the verdict of a job with the reports returned with its
+// status.
+func (h *handler) getReport(request []byte) (Message, *StatusError) {
+	requestor, jobID, serr := h.decodeJobRequest(request)
+	if serr != nil {
+		return nil, serr
+	}
+	resp, err := h.api.Verdict(requestor, jobID)
+	if serr = checkResponse(resp, err); serr != nil {
+		return nil, serr
+	}
+	verdict := resp.Data.(api.ResponseDataVerdict).Verdict
+	resp, err = h.api.Status(requestor, jobID)
+	if serr = checkResponse(resp, err); serr != nil {
+		return nil, serr
+	}
+	var report *job.JobReport
+	if status := resp.Data.(api.ResponseDataStatus).Status; status != nil {
+		report = status.JobReport
+	}
+	reportResp, rerr := newReportResponse(string(jobID), verdict, report)
+	if rerr != nil {
+		return nil, statusErrorf(CodeInternal, "%v", rerr)
+	}
+	return reportResp, nil
+}
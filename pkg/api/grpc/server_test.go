@@ -0,0 +1,264 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package grpc
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/jobid"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+// newTLSConfig returns the configurations of a server with a self-signed
+// certificate for 127.0.0.1, and of a client trusting it.
+func newTLSConfig(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "contest"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server := tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return &server, &tls.Config{RootCAs: pool}
+}
+
+// testServer serves the ConTest service over HTTP/2, through an API whose
+// events are answered by a fake job manager.
+type testServer struct {
+	url    string
+	client *http.Client
+	events chan *api.Event
+	close  func()
+}
+
+func newTestServer(t *testing.T, answer func(*api.Event) *api.EventResponse) *testServer {
+	return newTestServerWithAPI(t, api.New(), answer)
+}
+
+func newTestServerWithAPI(t *testing.T, a *api.API, answer func(*api.Event) *api.EventResponse) *testServer {
+	serverTLS, clientTLS := newTLSConfig(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := http.Server{Handler: NewHandler(a), TLSConfig: serverTLS}
+	go func() {
+		_ = s.ServeTLS(ln, "", "")
+	}()
+	done := make(chan struct{})
+	ts := testServer{
+		url:    "https://" + ln.Addr().String(),
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS, ForceAttemptHTTP2: true}},
+		events: make(chan *api.Event, 10),
+	}
+	go func() {
+		for {
+			select {
+			case ev := <-a.Events:
+				ts.events <- ev
+				ev.RespCh <- answer(ev)
+			case <-done:
+				return
+			}
+		}
+	}()
+	ts.close = func() {
+		close(done)
+		_ = s.Close()
+	}
+	return &ts
+}
+
+// call calls a method, and returns its gRPC status.
+func (ts *testServer) call(t *testing.T, method string, req, resp Message) (int, string) {
+	httpResp, err := ts.client.Post(ts.url+"/"+ServiceName+"/"+method, "application/grpc", bytes.NewReader(EncodeFrame(Marshal(req))))
+	require.NoError(t, err)
+	defer httpResp.Body.Close()
+	require.Equal(t, 2, httpResp.ProtoMajor)
+	require.Equal(t, http.StatusOK, httpResp.StatusCode)
+	body, err := ioutil.ReadAll(httpResp.Body)
+	require.NoError(t, err)
+	code, err := strconv.Atoi(httpResp.Trailer.Get("Grpc-Status"))
+	require.NoError(t, err)
+	msg, err := url.PathUnescape(httpResp.Trailer.Get("Grpc-Message"))
+	require.NoError(t, err)
+	if code == CodeOK {
+		frame, serr := ReadFrame(bytes.NewReader(body))
+		require.Nil(t, serr)
+		require.NoError(t, Unmarshal(frame, resp))
+	}
+	return code, msg
+}
+
+func TestStartAndStatus(t *testing.T) {
+	now := time.Unix(1600000000, 123456789).UTC()
+	ts := newTestServer(t, func(ev *api.Event) *api.EventResponse {
+		switch ev.Type {
+		case api.EventTypeStart:
+			return &api.EventResponse{JobID: "12"}
+		case api.EventTypeStatus:
+			return &api.EventResponse{Status: &job.Status{
+				Name:      "job",
+				State:     string(job.EventJobStarted),
+				StartTime: now,
+				RunStatus: job.RunStatus{
+					RunCoordinates: job.RunCoordinates{JobID: "12", RunID: 2},
+					TestStatuses: []job.TestStatus{{
+						TestCoordinates: job.TestCoordinates{TestName: "test"},
+						TargetStatuses: []job.TargetStatus{{
+							TestStepCoordinates: job.TestStepCoordinates{TestStepLabel: "step"},
+							Target:              &target.Target{ID: "1", Name: "host1"},
+							InTime:              now,
+							Error:               "failed",
+						}},
+					}},
+				},
+				Summary: &job.Summary{Failed: 1, PassPercent: 0},
+				Results: []job.ResultSummary{{Measurement: "latency", Unit: "ms", Passed: 1, Values: 1, Min: 1.5, Max: 1.5, Mean: 1.5}},
+			}}
+		}
+		return &api.EventResponse{Err: errors.New("unexpected event")}
+	})
+	defer ts.close()
+
+	var start StartResponse
+	code, msg := ts.call(t, "Start", &StartRequest{Requestor: "test", JobDescriptor: "{}", Parameters: map[string]string{"a": "1", "b": "2"}}, &start)
+	require.Equal(t, CodeOK, code, msg)
+	require.Equal(t, "12", start.JobID)
+	ev := <-ts.events
+	require.Equal(t, api.EventRequestor("test"), ev.Msg.Requestor())
+	require.Equal(t, map[string]string{"a": "1", "b": "2"}, ev.Msg.(api.EventStartMsg).Parameters)
+
+	var status StatusResponse
+	code, msg = ts.call(t, "Status", &JobRequest{Requestor: "test", JobID: "12"}, &status)
+	require.Equal(t, CodeOK, code, msg)
+	require.Equal(t, types.JobID("12"), (<-ts.events).Msg.(api.EventStatusMsg).JobID)
+	require.NotNil(t, status.Status)
+	require.Equal(t, "job", status.Status.Name)
+	require.True(t, now.Equal(status.Status.StartTime))
+	require.True(t, status.Status.EndTime.IsZero())
+	require.Equal(t, uint64(2), status.Status.RunID)
+	require.Equal(t, &Summary{Failed: 1}, status.Status.Summary)
+	require.Len(t, status.Status.Targets, 1)
+	require.Equal(t, "test", status.Status.Targets[0].TestName)
+	require.Equal(t, "step", status.Status.Targets[0].StepLabel)
+	require.Equal(t, "host1", status.Status.Targets[0].TargetName)
+	require.Equal(t, "failed", status.Status.Targets[0].Error)
+	require.Equal(t, []*ResultSummary{{Measurement: "latency", Unit: "ms", Passed: 1, Values: 1, Min: 1.5, Max: 1.5, Mean: 1.5}}, status.Status.Results)
+}
+
+func TestGetReport(t *testing.T) {
+	ts := newTestServer(t, func(ev *api.Event) *api.EventResponse {
+		switch ev.Type {
+		case api.EventTypeVerdict:
+			return &api.EventResponse{Verdict: &job.Verdict{
+				State:         string(job.EventJobCompleted),
+				Runs:          1,
+				Counts:        job.VerdictCounts{Passed: 1, Failed: 1, PassPercent: 50},
+				FailedTargets: []job.FailedTarget{{Run: 1, Test: "test", Step: "step", TargetID: "2", Error: "failed"}},
+			}}
+		case api.EventTypeStatus:
+			return &api.EventResponse{Status: &job.Status{JobReport: &job.JobReport{
+				RunReports:   [][]*job.Report{{{ReporterName: "TargetSuccess", Success: false, Data: map[string]int{"passed": 1}}}},
+				FinalReports: []*job.Report{{ReporterName: "noop", Success: true, Data: "I did nothing"}},
+			}}}
+		}
+		return &api.EventResponse{Err: errors.New("unexpected event")}
+	})
+	defer ts.close()
+
+	var report ReportResponse
+	code, msg := ts.call(t, "GetReport", &JobRequest{Requestor: "test", JobID: "12"}, &report)
+	require.Equal(t, CodeOK, code, msg)
+	require.Equal(t, "12", report.JobID)
+	require.NotNil(t, report.Verdict)
+	require.Equal(t, string(job.EventJobCompleted), report.Verdict.State)
+	require.False(t, report.Verdict.Success)
+	require.Equal(t, uint32(1), report.Verdict.Failed)
+	require.Equal(t, 50.0, report.Verdict.PassPercent)
+	require.Equal(t, []*FailedTarget{{RunID: 1, Test: "test", Step: "step", TargetID: "2", Error: "failed"}}, report.Verdict.FailedTargets)
+	require.Equal(t, []*Report{{RunID: 1, ReporterName: "TargetSuccess", DataJSON: `{"passed":1}`}}, report.RunReports)
+	require.Equal(t, []*Report{{ReporterName: "noop", Success: true, DataJSON: `"I did nothing"`}}, report.FinalReports)
+}
+
+func TestErrors(t *testing.T) {
+	ts := newTestServer(t, func(ev *api.Event) *api.EventResponse {
+		return &api.EventResponse{Err: errors.New("unknown job 13")}
+	})
+	defer ts.close()
+
+	code, msg := ts.call(t, "Stop", &JobRequest{Requestor: "test", JobID: "13"}, &StopResponse{})
+	require.Equal(t, CodeUnknown, code)
+	require.Equal(t, "unknown job 13", msg)
+
+	code, _ = ts.call(t, "Stop", &JobRequest{Requestor: "test", JobID: "1 3"}, &StopResponse{})
+	require.Equal(t, CodeInvalidArgument, code)
+	code, _ = ts.call(t, "Retry", &JobRequest{JobID: "13"}, &RetryResponse{})
+	require.Equal(t, CodeInvalidArgument, code)
+	code, _ = ts.call(t, "Resume", &JobRequest{Requestor: "test", JobID: "13"}, &StopResponse{})
+	require.Equal(t, CodeUnimplemented, code)
+}
+
+func TestJobIDsAreParsedByTheAllocator(t *testing.T) {
+	a := api.New()
+	a.SetJobIDParser(jobid.NewSequential(nil).Parse)
+	ts := newTestServerWithAPI(t, a, func(ev *api.Event) *api.EventResponse {
+		return &api.EventResponse{JobID: ev.Msg.(api.EventStopMsg).JobID}
+	})
+	defer ts.close()
+
+	// the sequential strategy cannot have allocated a ULID
+	code, _ := ts.call(t, "Stop", &JobRequest{Requestor: "test", JobID: "01ARZ3NDEKTSV4RRFFQ69G5FAV"}, &StopResponse{})
+	require.Equal(t, CodeInvalidArgument, code)
+	code, _ = ts.call(t, "Stop", &JobRequest{Requestor: "test", JobID: "007"}, &StopResponse{})
+	require.Equal(t, CodeOK, code)
+	ev := <-ts.events
+	require.Equal(t, types.JobID("7"), ev.Msg.(api.EventStopMsg).JobID)
+}
+
+func TestUnmarshalSkipsUnknownFields(t *testing.T) {
+	var e encoder
+	e.string(1, "requestor")
+	e.double(7, 1.5)
+	e.tag(8, wireFixed32)
+	e.buf = append(e.buf, 1, 2, 3, 4)
+	e.timestamp(9, time.Now())
+	e.string(2, "12")
+	var req JobRequest
+	require.NoError(t, Unmarshal(e.buf, &req))
+	require.Equal(t, JobRequest{Requestor: "requestor", JobID: "12"}, req)
+
+	require.Error(t, Unmarshal(e.buf[:len(e.buf)-1], &req))
+	require.Error(t, Unmarshal([]byte{0x0a, 0x05, 0x02}, &StartResponse{}))
+}
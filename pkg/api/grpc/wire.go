@@ -0,0 +1,293 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package grpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The protobuf wire types used by the messages of contest.proto.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Message is a message of the ConTest service, which can be encoded in the
+// protobuf wire format.
+type Message interface {
+	marshal(e *encoder)
+	unmarshal(d *decoder)
+}
+
+// Marshal encodes a message in the protobuf wire format.
+func Marshal(m Message) []byte {
+	var e encoder
+	m.marshal(&e)
+	return e.buf
+}
+
+// Unmarshal decodes a message from the protobuf wire format. Unknown fields
+// are skipped, so that older servers and clients can talk to newer ones.
+func Unmarshal(data []byte, m Message) error {
+	d := decoder{buf: data}
+	m.unmarshal(&d)
+	return d.err
+}
+
+// encoder appends the fields of a message to a buffer. As in proto3, fields
+// with a zero value are not encoded.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) varint(v uint64) {
+	for v >= 0x80 {
+		e.buf = append(e.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *encoder) tag(field, wireType int) {
+	e.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (e *encoder) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.varint(v)
+}
+
+func (e *encoder) int(field int, v int64) {
+	e.uint(field, uint64(v))
+}
+
+func (e *encoder) bool(field int, v bool) {
+	if v {
+		e.uint(field, 1)
+	}
+}
+
+func (e *encoder) double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireFixed64)
+	e.buf = append(e.buf, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(e.buf[len(e.buf)-8:], math.Float64bits(v))
+}
+
+func (e *encoder) bytes(field int, v []byte) {
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) string(field int, v string) {
+	if v != "" {
+		e.bytes(field, []byte(v))
+	}
+}
+
+// message encodes an embedded message. Unlike scalars, it is encoded even if
+// empty, since its presence is meaningful.
+func (e *encoder) message(field int, m Message) {
+	e.bytes(field, Marshal(m))
+}
+
+// timestamp encodes a time as a google.protobuf.Timestamp, unless it is zero.
+func (e *encoder) timestamp(field int, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	e.message(field, &timestamp{t})
+}
+
+// stringMap encodes a map<string, string>, as repeated key-value entries.
+func (e *encoder) stringMap(field int, m map[string]string) {
+	for k, v := range m {
+		e.message(field, &mapEntry{key: k, value: v})
+	}
+}
+
+// decoder reads the fields of a message one by one. Decoding errors are
+// sticky: once one happened, next returns false and err tells why.
+type decoder struct {
+	buf []byte
+	err error
+
+	// field and wireType identify the last field read, whose value is in
+	// value for numeric types, or in data for length-delimited ones.
+	field    int
+	wireType int
+	value    uint64
+	data     []byte
+}
+
+func (d *decoder) readVarint() (uint64, bool) {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("invalid varint")
+		return 0, false
+	}
+	d.buf = d.buf[n:]
+	return v, true
+}
+
+// next reads the next field, and returns false at the end of the message or
+// on error.
+func (d *decoder) next() bool {
+	if d.err != nil || len(d.buf) == 0 {
+		return false
+	}
+	tag, ok := d.readVarint()
+	if !ok {
+		return false
+	}
+	d.field, d.wireType = int(tag>>3), int(tag&7)
+	if d.field == 0 {
+		d.err = errors.New("invalid field number 0")
+		return false
+	}
+	switch d.wireType {
+	case wireVarint:
+		d.value, ok = d.readVarint()
+	case wireFixed64:
+		if ok = len(d.buf) >= 8; ok {
+			d.value, d.buf = binary.LittleEndian.Uint64(d.buf), d.buf[8:]
+		}
+	case wireFixed32:
+		if ok = len(d.buf) >= 4; ok {
+			d.value, d.buf = uint64(binary.LittleEndian.Uint32(d.buf)), d.buf[4:]
+		}
+	case wireBytes:
+		var n uint64
+		if n, ok = d.readVarint(); ok {
+			if ok = n <= uint64(len(d.buf)); ok {
+				d.data, d.buf = d.buf[:n], d.buf[n:]
+			}
+		}
+	default:
+		d.err = fmt.Errorf("unsupported wire type %d of field %d", d.wireType, d.field)
+		return false
+	}
+	if !ok {
+		if d.err == nil {
+			d.err = fmt.Errorf("truncated field %d", d.field)
+		}
+		return false
+	}
+	return true
+}
+
+// check records an error if the last field does not have the expected wire
+// type.
+func (d *decoder) check(wireType int) bool {
+	if d.err == nil && d.wireType != wireType {
+		d.err = fmt.Errorf("field %d has wire type %d, expected %d", d.field, d.wireType, wireType)
+	}
+	return d.err == nil
+}
+
+func (d *decoder) uint() uint64 {
+	if !d.check(wireVarint) {
+		return 0
+	}
+	return d.value
+}
+
+func (d *decoder) uint32() uint32 {
+	return uint32(d.uint())
+}
+
+func (d *decoder) int() int64 {
+	return int64(d.uint())
+}
+
+func (d *decoder) bool() bool {
+	return d.uint() != 0
+}
+
+func (d *decoder) double() float64 {
+	if !d.check(wireFixed64) {
+		return 0
+	}
+	return math.Float64frombits(d.value)
+}
+
+func (d *decoder) string() string {
+	if !d.check(wireBytes) {
+		return ""
+	}
+	return string(d.data)
+}
+
+func (d *decoder) message(m Message) {
+	if !d.check(wireBytes) {
+		return
+	}
+	if err := Unmarshal(d.data, m); err != nil {
+		d.err = fmt.Errorf("field %d: %v", d.field, err)
+	}
+}
+
+func (d *decoder) timestamp() time.Time {
+	var ts timestamp
+	d.message(&ts)
+	return ts.Time
+}
+
+// timestamp is a google.protobuf.Timestamp.
+type timestamp struct {
+	time.Time
+}
+
+func (t timestamp) marshal(e *encoder) {
+	e.int(1, t.Unix())
+	e.int(2, int64(t.Nanosecond()))
+}
+
+func (t *timestamp) unmarshal(d *decoder) {
+	var seconds, nanos int64
+	for d.next() {
+		switch d.field {
+		case 1:
+			seconds = d.int()
+		case 2:
+			nanos = d.int()
+		}
+	}
+	t.Time = time.Unix(seconds, nanos)
+}
+
+// mapEntry is an entry of a map<string, string>.
+type mapEntry struct {
+	key, value string
+}
+
+func (m mapEntry) marshal(e *encoder) {
+	e.string(1, m.key)
+	e.string(2, m.value)
+}
+
+func (m *mapEntry) unmarshal(d *decoder) {
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.key = d.string()
+		case 2:
+			m.value = d.string()
+		}
+	}
+}
@@ -15,3 +15,40 @@ type Listener interface {
 	// shutdown.
 	Serve(<-chan struct{}, *API) error
 }
+
+// multiListener serves the API through several listeners at once.
+type multiListener []Listener
+
+// NewMultiListener returns a Listener which serves the API through all the
+// given listeners, e.g. HTTP and gRPC. When one of them returns, the others
+// are shut down, and Serve returns the first error, if any.
+func NewMultiListener(listeners ...Listener) Listener {
+	return multiListener(listeners)
+}
+
+// Serve implements the Listener interface.
+func (m multiListener) Serve(cancel <-chan struct{}, a *API) error {
+	stop := make(chan struct{})
+	errCh := make(chan error, len(m))
+	for _, l := range m {
+		go func(l Listener) {
+			errCh <- l.Serve(stop, a)
+		}(l)
+	}
+	var (
+		err      error
+		returned int
+	)
+	select {
+	case <-cancel:
+	case err = <-errCh:
+		returned++
+	}
+	close(stop)
+	for ; returned < len(m); returned++ {
+		if lerr := <-errCh; lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	return err
+}
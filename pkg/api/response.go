@@ -6,7 +6,10 @@
 package api
 
 import (
+	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/pluginregistry"
+	"github.com/facebookincubator/contest/pkg/target"
 	"github.com/facebookincubator/contest/pkg/types"
 )
 
@@ -20,15 +23,35 @@ const (
 	ResponseTypeStatus
 	ResponseTypeRetry
 	ResponseTypeVersion
+	ResponseTypeResume
+	ResponseTypeReplayFailed
+	ResponseTypeStoreTemplate
+	ResponseTypeStartTemplate
+	ResponseTypeTestEvents
+	ResponseTypeVerdict
+	ResponseTypeSchedule
+	ResponseTypeSchedules
+	ResponseTypePlugins
+	ResponseTypeTargetHealth
 )
 
 // ResponseTypeToName maps response types to their names.
 var ResponseTypeToName = map[ResponseType]string{
-	ResponseTypeStart:   "ResponseTypeStart",
-	ResponseTypeStop:    "ResponseTypeStop",
-	ResponseTypeStatus:  "ResponseTypeStatus",
-	ResponseTypeRetry:   "ResponseTypeRetry",
-	ResponseTypeVersion: "ResponseTypeVersion",
+	ResponseTypeStart:         "ResponseTypeStart",
+	ResponseTypeStop:          "ResponseTypeStop",
+	ResponseTypeStatus:        "ResponseTypeStatus",
+	ResponseTypeRetry:         "ResponseTypeRetry",
+	ResponseTypeVersion:       "ResponseTypeVersion",
+	ResponseTypeResume:        "ResponseTypeResume",
+	ResponseTypeReplayFailed:  "ResponseTypeReplayFailed",
+	ResponseTypeStoreTemplate: "ResponseTypeStoreTemplate",
+	ResponseTypeStartTemplate: "ResponseTypeStartTemplate",
+	ResponseTypeTestEvents:    "ResponseTypeTestEvents",
+	ResponseTypeVerdict:       "ResponseTypeVerdict",
+	ResponseTypeSchedule:      "ResponseTypeSchedule",
+	ResponseTypeSchedules:     "ResponseTypeSchedules",
+	ResponseTypePlugins:       "ResponseTypePlugins",
+	ResponseTypeTargetHealth:  "ResponseTypeTargetHealth",
 }
 
 // Response is the type returned to any API request.
@@ -93,3 +116,126 @@ type ResponseDataVersion struct {
 func (r ResponseDataVersion) Type() ResponseType {
 	return ResponseTypeVersion
 }
+
+// ResponseDataResume is the response type for a Resume request.
+type ResponseDataResume struct {
+	JobID types.JobID
+}
+
+// Type returns the response type.
+func (r ResponseDataResume) Type() ResponseType {
+	return ResponseTypeResume
+}
+
+// ResponseDataReplayFailed is the response type for a ReplayFailed request.
+type ResponseDataReplayFailed struct {
+	// JobID is the ID of the replayed job.
+	JobID types.JobID
+	// NewJobID is the ID of the job running over the failed targets.
+	NewJobID types.JobID
+}
+
+// Type returns the response type.
+func (r ResponseDataReplayFailed) Type() ResponseType {
+	return ResponseTypeReplayFailed
+}
+
+// ResponseDataStoreTemplate is the response type for a StoreTemplate request.
+type ResponseDataStoreTemplate struct {
+	Name    string
+	Version uint
+	// Parameters are the parameters of the template.
+	Parameters []job.TemplateParameter
+}
+
+// Type returns the response type.
+func (r ResponseDataStoreTemplate) Type() ResponseType {
+	return ResponseTypeStoreTemplate
+}
+
+// ResponseDataStartTemplate is the response type for a StartTemplate request.
+type ResponseDataStartTemplate struct {
+	JobID types.JobID
+	// Name and Version identify the template used.
+	Name    string
+	Version uint
+}
+
+// Type returns the response type.
+func (r ResponseDataStartTemplate) Type() ResponseType {
+	return ResponseTypeStartTemplate
+}
+
+// ResponseDataTestEvents is the response type for a TestEvents request.
+type ResponseDataTestEvents struct {
+	JobID  types.JobID
+	Events []testevent.Event
+	// Cursor is passed to the next request to get the following events. It
+	// is returned even when there are no more events yet, so that clients can
+	// poll for the events of running jobs.
+	Cursor string
+	// More is set if more events were already available.
+	More bool
+}
+
+// Type returns the response type.
+func (r ResponseDataTestEvents) Type() ResponseType {
+	return ResponseTypeTestEvents
+}
+
+// ResponseDataVerdict is the response type for a Verdict request.
+type ResponseDataVerdict struct {
+	JobID types.JobID
+	// Verdict is nil until the job ends.
+	Verdict *job.Verdict
+}
+
+// Type returns the response type.
+func (r ResponseDataVerdict) Type() ResponseType {
+	return ResponseTypeVerdict
+}
+
+// ResponseDataSchedule is the response type for the requests about a job
+// schedule.
+type ResponseDataSchedule struct {
+	Schedule *job.Schedule
+	// JobIDs are the jobs submitted by the schedule, only returned by
+	// GetSchedule.
+	JobIDs []types.JobID `json:",omitempty"`
+}
+
+// Type returns the response type.
+func (r ResponseDataSchedule) Type() ResponseType {
+	return ResponseTypeSchedule
+}
+
+// ResponseDataSchedules is the response type for a ListSchedules request.
+type ResponseDataSchedules struct {
+	Schedules []*job.Schedule
+}
+
+// Type returns the response type.
+func (r ResponseDataSchedules) Type() ResponseType {
+	return ResponseTypeSchedules
+}
+
+// ResponseDataPlugins is the response type for a ListPlugins request.
+type ResponseDataPlugins struct {
+	Plugins []pluginregistry.PluginInfo
+}
+
+// Type returns the response type.
+func (r ResponseDataPlugins) Type() ResponseType {
+	return ResponseTypePlugins
+}
+
+// ResponseDataTargetHealth is the response type for the requests about
+// quarantined targets.
+type ResponseDataTargetHealth struct {
+	Targets []*target.Health
+}
+
+// Type returns the response type.
+func (r ResponseDataTargetHealth) Type() ResponseType {
+	return ResponseTypeTargetHealth
+}
@@ -8,6 +8,7 @@ package cerrors
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/facebookincubator/contest/pkg/target"
 )
@@ -53,3 +54,16 @@ type ErrTestStepClosedChannels struct {
 func (e *ErrTestStepClosedChannels) Error() string {
 	return fmt.Sprintf("test step %v closed output channels (api violation)", e.StepName)
 }
+
+// ErrSubmissionRateLimited indicates that a job submission was rejected
+// because its requestor exceeded the submission rate limit. RetryAfter is the
+// time after which a submission would be accepted again.
+type ErrSubmissionRateLimited struct {
+	Requestor  string
+	RetryAfter time.Duration
+}
+
+// Error returns the error string associated with the error
+func (e *ErrSubmissionRateLimited) Error() string {
+	return fmt.Sprintf("job submission rate limit exceeded for requestor '%s', retry after %v", e.Requestor, e.RetryAfter)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package testevent
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/target"
+)
+
+// DedupEmitter wraps an Emitter and collapses consecutive identical events,
+// i.e. with the same name, target, payload, severity and span, into a single
+// event whose Repeat field counts them. The pending event is held back, and
+// its count updated in place, until a different event is emitted, it has been
+// pending for the flush interval, or Flush is called.
+//
+// Test steps opt in by wrapping the emitter they are given, e.g. around a
+// polling loop, and must call Flush before returning.
+type DedupEmitter struct {
+	ev       Emitter
+	interval time.Duration
+
+	lock    sync.Mutex
+	pending *Data
+	timer   *time.Timer
+	// err is the error of a flush triggered by the timer, returned by the
+	// next call.
+	err error
+}
+
+// NewDedupEmitter returns a DedupEmitter wrapping ev. Pending events are
+// emitted at the latest after interval, or only when they change or are
+// flushed if interval is 0.
+func NewDedupEmitter(ev Emitter, interval time.Duration) *DedupEmitter {
+	return &DedupEmitter{ev: ev, interval: interval}
+}
+
+func sameTarget(a, b *target.Target) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func samePayload(a, b *json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return bytes.Equal(*a, *b)
+}
+
+// identical tells whether two events can be collapsed.
+func identical(a, b *Data) bool {
+	return a.EventName == b.EventName &&
+		a.Severity == b.Severity &&
+		a.SpanID == b.SpanID &&
+		a.ParentSpanID == b.ParentSpanID &&
+		sameTarget(a.Target, b.Target) &&
+		samePayload(a.Payload, b.Payload)
+}
+
+// flush emits the pending event, if any. It must be called with the lock
+// held.
+func (d *DedupEmitter) flush() error {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if d.pending == nil {
+		return nil
+	}
+	data := *d.pending
+	d.pending = nil
+	if data.Repeat == 1 {
+		data.Repeat = 0
+	}
+	return d.ev.Emit(data)
+}
+
+// takeErr returns and clears the error of the last flush triggered by the
+// timer. It must be called with the lock held.
+func (d *DedupEmitter) takeErr() error {
+	err := d.err
+	d.err = nil
+	return err
+}
+
+// Emit collapses the event into the pending one if they are identical, or
+// emits the pending event and holds this one back otherwise.
+func (d *DedupEmitter) Emit(data Data) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.takeErr(); err != nil {
+		return err
+	}
+	if d.pending != nil && identical(d.pending, &data) {
+		d.pending.Repeat += data.Count()
+		return nil
+	}
+	if err := d.flush(); err != nil {
+		return err
+	}
+	data.Repeat = data.Count()
+	d.pending = &data
+	if d.interval > 0 {
+		pending := d.pending
+		d.timer = time.AfterFunc(d.interval, func() {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			// the event may have been flushed since the timer fired
+			if d.pending == pending {
+				d.err = d.flush()
+			}
+		})
+	}
+	return nil
+}
+
+// Flush emits the pending event, if any.
+func (d *DedupEmitter) Flush() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.takeErr(); err != nil {
+		return err
+	}
+	return d.flush()
+}
+
+// StartSpan emits the pending event, so that events stay in order, and opens
+// a span on the wrapped emitter.
+func (d *DedupEmitter) StartSpan(name string, target *target.Target, parent SpanID) (SpanID, error) {
+	if err := d.Flush(); err != nil {
+		return "", err
+	}
+	return d.ev.StartSpan(name, target, parent)
+}
+
+// EndSpan emits the pending event, so that events stay in order, and closes
+// a span on the wrapped emitter.
+func (d *DedupEmitter) EndSpan(id SpanID) error {
+	if err := d.Flush(); err != nil {
+		return err
+	}
+	return d.ev.EndSpan(id)
+}
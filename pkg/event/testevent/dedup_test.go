@@ -0,0 +1,111 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package testevent_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/facebookincubator/contest/pkg/event/testevent"
+)
+
+func TestDedupEmitterCollapsesIdenticalEvents(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID, err := storage.NewJobRequestEmitter().Emit(&job.Request{JobName: "dedup"})
+	require.NoError(t, err)
+	ev := storage.NewTestEventEmitterFetcher(Header{JobID: jobID, TestName: "test", TestStepLabel: "poll"})
+	dedup := NewDedupEmitter(ev, 0)
+	tgt := &target.Target{Name: "host1", ID: "1"}
+	payload := json.RawMessage(`{"Message":"still waiting"}`)
+
+	for i := 0; i < 100; i++ {
+		// each event has its own copies of the target and payload
+		tgtCopy, payloadCopy := *tgt, append(json.RawMessage(nil), payload...)
+		require.NoError(t, dedup.Emit(Data{EventName: event.Name("Waiting"), Target: &tgtCopy, Payload: &payloadCopy}))
+	}
+	events, err := ev.Fetch(QueryJobID(jobID))
+	require.NoError(t, err)
+	require.Empty(t, events, "the pending event is emitted on flush")
+
+	require.NoError(t, dedup.Flush())
+	events, err = ev.Fetch(QueryJobID(jobID))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, uint64(100), events[0].Data.Repeat)
+	require.Equal(t, tgt, events[0].Data.Target)
+	require.Equal(t, payload, *events[0].Data.Payload)
+
+	counts, err := storage.NewJobRequestFetcher().FetchEventCounts(jobID)
+	require.NoError(t, err)
+	require.Equal(t, map[event.Name]uint64{"Waiting": 100}, counts)
+}
+
+func TestDedupEmitterFlushesOnChange(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("1")
+	ev := storage.NewTestEventEmitterFetcher(Header{JobID: jobID, TestName: "test", TestStepLabel: "poll"})
+	dedup := NewDedupEmitter(ev, 0)
+	host1 := &target.Target{Name: "host1", ID: "1"}
+	host2 := &target.Target{Name: "host2", ID: "2"}
+
+	for _, data := range []Data{
+		{EventName: "Waiting", Target: host1},
+		{EventName: "Waiting", Target: host1},
+		{EventName: "Waiting", Target: host2},
+		{EventName: "Waiting", Target: host1, Severity: SeverityWarning},
+		{EventName: "Done", Target: host1},
+	} {
+		require.NoError(t, dedup.Emit(data))
+	}
+	require.NoError(t, dedup.Flush())
+	// flushing twice does not emit the event again
+	require.NoError(t, dedup.Flush())
+
+	events, err := ev.Fetch(QueryJobID(jobID))
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	var repeats []uint64
+	for _, e := range events {
+		repeats = append(repeats, e.Data.Repeat)
+	}
+	// single events are emitted as usual
+	require.Equal(t, []uint64{2, 0, 0, 0}, repeats)
+	require.Equal(t, host2, events[1].Data.Target)
+	require.Equal(t, SeverityWarning, events[2].Data.Severity)
+}
+
+func TestDedupEmitterFlushesAfterInterval(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("1")
+	ev := storage.NewTestEventEmitterFetcher(Header{JobID: jobID, TestName: "test", TestStepLabel: "poll"})
+	dedup := NewDedupEmitter(ev, 10*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, dedup.Emit(Data{EventName: "Waiting"}))
+	}
+	require.Eventually(t, func() bool {
+		events, err := ev.Fetch(QueryJobID(jobID))
+		return err == nil && len(events) == 1 && events[0].Data.Repeat == 3
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// identical events emitted after the flush start a new event
+	require.NoError(t, dedup.Emit(Data{EventName: "Waiting"}))
+	_, err := dedup.StartSpan("wait", nil, "")
+	require.NoError(t, err)
+	events, err := ev.Fetch(QueryJobID(jobID))
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	require.Equal(t, EventSpanStarted, events[2].Data.EventName)
+}
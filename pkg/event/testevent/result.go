@@ -0,0 +1,132 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package testevent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/target"
+)
+
+// EventTargetResult carries a structured Result of a target as its payload.
+// Like the span events, it does not need to be declared by the test steps.
+const EventTargetResult = event.Name("TargetResult")
+
+// MaxMeasurementLength is the maximum length of the name of a measurement.
+const MaxMeasurementLength = 64
+
+// ResultStatus is the outcome of a measurement or check on a target.
+type ResultStatus string
+
+// list of the statuses of results.
+const (
+	ResultPass ResultStatus = "pass"
+	ResultFail ResultStatus = "fail"
+	ResultSkip ResultStatus = "skip"
+)
+
+// Valid tells whether a result status is one of the known statuses.
+func (s ResultStatus) Valid() bool {
+	switch s {
+	case ResultPass, ResultFail, ResultSkip:
+		return true
+	}
+	return false
+}
+
+// Artifact references a file produced while testing a target, e.g. a log or
+// a trace, which is stored outside of ConTest.
+type Artifact struct {
+	Name string
+	URI  string
+	// MediaType is the type of the content, e.g. "text/plain".
+	MediaType string `json:",omitempty"`
+}
+
+// Result is the structured result of a measurement or check on a target, so
+// that reporters can aggregate and render results without knowing the step
+// which emitted them.
+type Result struct {
+	// Measurement names what was measured or checked, e.g. "boot_time".
+	Measurement string
+	// Unit is the unit of Value, e.g. "s" or "MB/s".
+	Unit string `json:",omitempty"`
+	// Value is the measured value, if any.
+	Value  *float64 `json:",omitempty"`
+	Status ResultStatus
+	// Message explains the status, e.g. why a check failed.
+	Message   string     `json:",omitempty"`
+	Artifacts []Artifact `json:",omitempty"`
+}
+
+// Validate checks that a result can be emitted.
+func (r *Result) Validate() error {
+	if r.Measurement == "" {
+		return errors.New("the measurement name cannot be empty")
+	}
+	if len(r.Measurement) > MaxMeasurementLength {
+		return fmt.Errorf("measurement name %q is longer than %d characters", r.Measurement, MaxMeasurementLength)
+	}
+	if !r.Status.Valid() {
+		return fmt.Errorf("invalid status %q for measurement %s", r.Status, r.Measurement)
+	}
+	if r.Value != nil && (math.IsNaN(*r.Value) || math.IsInf(*r.Value, 0)) {
+		return fmt.Errorf("value of measurement %s is not a finite number", r.Measurement)
+	}
+	for idx, a := range r.Artifacts {
+		if a.Name == "" || a.URI == "" {
+			return fmt.Errorf("artifact #%d of measurement %s needs a name and a URI", idx, r.Measurement)
+		}
+	}
+	return nil
+}
+
+// NewResultData returns the data of the event carrying a result. Failed
+// results are errors, the others are informational.
+func NewResultData(t *target.Target, r Result) (Data, error) {
+	if err := r.Validate(); err != nil {
+		return Data{}, fmt.Errorf("invalid result: %v", err)
+	}
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return Data{}, fmt.Errorf("could not encode result: %v", err)
+	}
+	rawPayload := json.RawMessage(payload)
+	severity := SeverityInfo
+	if r.Status == ResultFail {
+		severity = SeverityError
+	}
+	return Data{EventName: EventTargetResult, Target: t, Payload: &rawPayload, Severity: severity}, nil
+}
+
+// EmitResult emits a result for a target.
+func EmitResult(ev Emitter, t *target.Target, r Result) error {
+	data, err := NewResultData(t, r)
+	if err != nil {
+		return err
+	}
+	return ev.Emit(data)
+}
+
+// Result decodes the result carried by an event, or returns an error if the
+// event is not a result.
+func (d *Data) Result() (*Result, error) {
+	if d.EventName != EventTargetResult {
+		return nil, fmt.Errorf("event %s is not a result", d.EventName)
+	}
+	if d.Payload == nil {
+		return nil, errors.New("result event has no payload")
+	}
+	var r Result
+	if err := json.Unmarshal(*d.Payload, &r); err != nil {
+		return nil, fmt.Errorf("could not decode result: %v", err)
+	}
+	return &r, nil
+}
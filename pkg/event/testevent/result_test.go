@@ -0,0 +1,73 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package testevent_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/facebookincubator/contest/pkg/event/testevent"
+)
+
+func value(v float64) *float64 {
+	return &v
+}
+
+func TestEmitAndQueryResults(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("1")
+	ev := storage.NewTestEventEmitterFetcher(Header{JobID: jobID, TestName: "test", TestStepLabel: "step"})
+	tgt := &target.Target{Name: "host1", ID: "1"}
+
+	bootTime := Result{
+		Measurement: "boot_time",
+		Unit:        "s",
+		Value:       value(12.5),
+		Status:      ResultPass,
+		Artifacts:   []Artifact{{Name: "console", URI: "https://logs.example.com/1/console", MediaType: "text/plain"}},
+	}
+	require.NoError(t, EmitResult(ev, tgt, bootTime))
+	require.NoError(t, EmitResult(ev, tgt, Result{Measurement: "nic_link", Status: ResultFail, Message: "link down"}))
+	require.NoError(t, ev.Emit(Data{EventName: event.Name("Other"), Target: tgt}))
+
+	require.Error(t, EmitResult(ev, tgt, Result{Status: ResultPass}))
+	require.Error(t, EmitResult(ev, tgt, Result{Measurement: "boot_time", Status: "great"}))
+	require.Error(t, EmitResult(ev, tgt, Result{Measurement: "boot_time", Status: ResultPass, Value: value(math.NaN())}))
+	require.Error(t, EmitResult(ev, tgt, Result{Measurement: "boot_time", Status: ResultPass, Artifacts: []Artifact{{Name: "log"}}}))
+
+	events, err := ev.Fetch(QueryJobID(jobID), QueryMeasurement("boot_time"))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, SeverityInfo, events[0].Data.Severity)
+	result, err := events[0].Data.Result()
+	require.NoError(t, err)
+	require.Equal(t, bootTime, *result)
+
+	events, err = ev.Fetch(QueryJobID(jobID), QueryResultStatus(ResultFail))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, SeverityError, events[0].Data.Severity)
+	result, err = events[0].Data.Result()
+	require.NoError(t, err)
+	require.Equal(t, "nic_link", result.Measurement)
+
+	events, err = ev.Fetch(QueryJobID(jobID), QueryMeasurements([]string{"nic_link"}), QueryResultStatus(ResultPass))
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	events, err = ev.Fetch(QueryJobID(jobID), QueryEventName(event.Name("Other")))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	_, err = events[0].Data.Result()
+	require.Error(t, err)
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package testevent
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+)
+
+// SpanID identifies a span, which groups the events emitted by a
+// sub-operation of a test step. Spans can be nested. The empty SpanID means
+// no span.
+type SpanID string
+
+// Events delimiting spans. They are emitted by the Emitter itself, and do not
+// need to be declared by the test steps.
+const (
+	EventSpanStarted = event.Name("SpanStarted")
+	EventSpanEnded   = event.Name("SpanEnded")
+)
+
+// SpanPayload is the payload of SpanStarted events.
+type SpanPayload struct {
+	Name string
+}
+
+// SpanNode is a node of a tree of spans.
+type SpanNode struct {
+	ID   SpanID
+	Name string
+	// Start and End are the emission times of the events delimiting the
+	// span. End is zero if the span was not closed.
+	Start, End time.Time
+	// Events are the events which belong to the span, excluding the ones
+	// delimiting it and its children.
+	Events   []Event
+	Children []*SpanNode
+}
+
+// BuildSpanTree arranges events in a tree of spans. The returned root node
+// has no ID and holds the events which are not part of any span, as well as
+// the events whose span is unknown. Events are expected in emission order;
+// to render a tree per target, pass the events of one target at a time.
+func BuildSpanTree(events []Event) *SpanNode {
+	root := &SpanNode{}
+	nodes := map[SpanID]*SpanNode{"": root}
+	parentOf := func(ev Event) *SpanNode {
+		if n, ok := nodes[ev.Data.ParentSpanID]; ok {
+			return n
+		}
+		return root
+	}
+	for _, ev := range events {
+		if ev.Data == nil {
+			continue
+		}
+		switch {
+		case ev.Data.EventName == EventSpanStarted && ev.Data.SpanID != "":
+			n := &SpanNode{ID: ev.Data.SpanID, Start: ev.EmitTime}
+			if ev.Data.Payload != nil {
+				var p SpanPayload
+				if err := json.Unmarshal(*ev.Data.Payload, &p); err == nil {
+					n.Name = p.Name
+				}
+			}
+			parent := parentOf(ev)
+			parent.Children = append(parent.Children, n)
+			nodes[n.ID] = n
+		case ev.Data.EventName == EventSpanEnded && ev.Data.SpanID != "":
+			if n, ok := nodes[ev.Data.SpanID]; ok {
+				n.End = ev.EmitTime
+			}
+		default:
+			parent := parentOf(ev)
+			parent.Events = append(parent.Events, ev)
+		}
+	}
+	return root
+}
@@ -0,0 +1,65 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package testevent_test
+
+import (
+	"testing"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/facebookincubator/contest/pkg/event/testevent"
+)
+
+func TestBuildSpanTree(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("1")
+	ev := storage.NewTestEventEmitterFetcher(Header{JobID: jobID, TestName: "test", TestStepLabel: "step"})
+	tgt := &target.Target{Name: "host1", ID: "1"}
+
+	require.NoError(t, ev.Emit(Data{EventName: event.Name("Begin"), Target: tgt}))
+	flash, err := ev.StartSpan("flash", tgt, "")
+	require.NoError(t, err)
+	require.NoError(t, ev.Emit(Data{EventName: event.Name("Download"), Target: tgt, ParentSpanID: flash}))
+	write, err := ev.StartSpan("write", tgt, flash)
+	require.NoError(t, err)
+	require.NoError(t, ev.Emit(Data{EventName: event.Name("Block"), Target: tgt, ParentSpanID: write}))
+	require.NoError(t, ev.Emit(Data{EventName: event.Name("Block"), Target: tgt, ParentSpanID: write}))
+	require.NoError(t, ev.EndSpan(write))
+	require.NoError(t, ev.EndSpan(flash))
+
+	// spans must be open to be closed or used as parents
+	require.Error(t, ev.EndSpan(flash))
+	_, err = ev.StartSpan("late", tgt, write)
+	require.Error(t, err)
+
+	events, err := ev.Fetch(QueryJobID(jobID))
+	require.NoError(t, err)
+	root := BuildSpanTree(events)
+
+	require.Len(t, root.Events, 1)
+	require.Equal(t, event.Name("Begin"), root.Events[0].Data.EventName)
+	require.Len(t, root.Children, 1)
+
+	flashNode := root.Children[0]
+	require.Equal(t, flash, flashNode.ID)
+	require.Equal(t, "flash", flashNode.Name)
+	require.False(t, flashNode.End.IsZero())
+	require.Len(t, flashNode.Events, 1)
+	require.Equal(t, event.Name("Download"), flashNode.Events[0].Data.EventName)
+	require.Len(t, flashNode.Children, 1)
+
+	writeNode := flashNode.Children[0]
+	require.Equal(t, write, writeNode.ID)
+	require.Equal(t, "write", writeNode.Name)
+	require.False(t, writeNode.End.IsZero())
+	require.Len(t, writeNode.Events, 2)
+	require.Empty(t, writeNode.Children)
+}
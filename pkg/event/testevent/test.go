@@ -26,11 +26,51 @@ type Header struct {
 	TestStepLabel string
 }
 
+// Severity is the level of a test event, which lets reporters and
+// dashboards tell informational events from warnings and errors.
+type Severity string
+
+// list of the severities of test events.
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// Valid tells whether a severity is one of the known severities.
+func (s Severity) Valid() bool {
+	switch s {
+	case SeverityInfo, SeverityWarning, SeverityError:
+		return true
+	}
+	return false
+}
+
 // Data models the data of a test event. It is populated by the TestStep
 type Data struct {
 	EventName event.Name
 	Target    *target.Target
 	Payload   *json.RawMessage
+	// Severity is the level of the event. Events emitted without a severity
+	// are informational.
+	Severity Severity
+	// SpanID identifies the span delimited by the SpanStarted and SpanEnded
+	// events. It is not set on other events.
+	SpanID SpanID
+	// ParentSpanID is the span which the event belongs to, if any.
+	ParentSpanID SpanID
+	// Repeat is the number of consecutive identical events which this event
+	// stands for, when they were collapsed by a DedupEmitter. Zero means a
+	// single event.
+	Repeat uint64 `json:",omitempty"`
+}
+
+// Count returns the number of events which the data stands for.
+func (d *Data) Count() uint64 {
+	if d.Repeat == 0 {
+		return 1
+	}
+	return d.Repeat
 }
 
 // Event models an event object that can be emitted by a TestStep
@@ -52,6 +92,11 @@ type Query struct {
 	RunID         types.RunID
 	TestName      string
 	TestStepLabel string
+	Severities    []Severity
+	// Measurements and ResultStatuses select the EventTargetResult events
+	// by the measurement and status of their result.
+	Measurements   []string
+	ResultStatuses []ResultStatus
 }
 
 // QueryField defines a function type used to set a field's value on Query objects
@@ -87,6 +132,9 @@ type queryFieldEmittedEndTime time.Time
 type queryFieldTestName string
 type queryFieldTestStepLabel string
 type queryFieldRunID types.RunID
+type queryFieldSeverities []Severity
+type queryFieldMeasurements []string
+type queryFieldResultStatuses []ResultStatus
 
 // QueryJobID sets the JobID field of the Query object
 func QueryJobID(jobID types.JobID) QueryField                            { return queryFieldJobID(jobID) }
@@ -137,9 +185,61 @@ func QueryRunID(runID types.RunID) QueryField {
 }
 func (value queryFieldRunID) queryFieldPointer(query *Query) interface{} { return &query.RunID }
 
+// QuerySeverities sets the Severities field of the Query object
+func QuerySeverities(severities []Severity) QueryField { return queryFieldSeverities(severities) }
+func (value queryFieldSeverities) queryFieldPointer(query *Query) interface{} {
+	return &query.Severities
+}
+
+// QuerySeverity sets a single severity in the Severities field of the Query
+// object
+func QuerySeverity(severity Severity) QueryField { return queryFieldSeverities{severity} }
+
+// QueryMeasurements sets the Measurements field of the Query object
+func QueryMeasurements(measurements []string) QueryField { return queryFieldMeasurements(measurements) }
+func (value queryFieldMeasurements) queryFieldPointer(query *Query) interface{} {
+	return &query.Measurements
+}
+
+// QueryMeasurement sets a single measurement in the Measurements field of the
+// Query object
+func QueryMeasurement(measurement string) QueryField { return queryFieldMeasurements{measurement} }
+
+// QueryResultStatuses sets the ResultStatuses field of the Query object
+func QueryResultStatuses(statuses []ResultStatus) QueryField {
+	return queryFieldResultStatuses(statuses)
+}
+func (value queryFieldResultStatuses) queryFieldPointer(query *Query) interface{} {
+	return &query.ResultStatuses
+}
+
+// QueryResultStatus sets a single status in the ResultStatuses field of the
+// Query object
+func QueryResultStatus(status ResultStatus) QueryField { return queryFieldResultStatuses{status} }
+
 // Emitter defines the interface that emitter objects must implement
 type Emitter interface {
 	Emit(event Data) error
+	// StartSpan opens a span for the given target, nested in the parent span
+	// if not empty, and returns its ID. Events which are part of the span
+	// reference it in their ParentSpanID field.
+	StartSpan(name string, target *target.Target, parent SpanID) (SpanID, error)
+	// EndSpan closes a span opened by StartSpan.
+	EndSpan(id SpanID) error
+}
+
+// Page is a bounded page of the test events matching a query, in the order in
+// which they were stored.
+type Page struct {
+	Events []Event
+	// LastSequence is the sequence number of the last event of the page, to
+	// pass to the query for the next page. Sequence numbers are assigned by
+	// the storage layer in increasing order, so that events stored after a
+	// page was returned appear in later pages.
+	LastSequence uint64
+	// More is set if more events matched the query when the page was
+	// returned.
+	More bool
 }
 
 // Fetcher defines the interface that fetcher objects must implement
@@ -147,6 +247,56 @@ type Fetcher interface {
 	Fetch(fields ...QueryField) ([]Event, error)
 }
 
+// PageFetcher is implemented by the fetchers which can return the events
+// matching a query a page at a time.
+type PageFetcher interface {
+	// FetchPage returns at most limit events matching the query fields,
+	// stored after the event with sequence number afterSequence, or from the
+	// first one if afterSequence is 0.
+	FetchPage(afterSequence uint64, limit int, fields ...QueryField) (*Page, error)
+}
+
+// StreamPageSize is the number of events fetched at once by Stream.
+const StreamPageSize = 1000
+
+// Stream calls fn on the events matching the query fields, in the order in
+// which they were stored, and stops at the first error returned by fn, which
+// is returned as it is. If the fetcher is a PageFetcher, the events are
+// fetched a page at a time, so that reporters can go through the events of
+// large jobs without holding all of them in memory. Otherwise they are all
+// fetched at once.
+func Stream(f Fetcher, fn func(Event) error, fields ...QueryField) error {
+	pf, ok := f.(PageFetcher)
+	if !ok {
+		events, err := f.Fetch(fields...)
+		if err != nil {
+			return err
+		}
+		for _, ev := range events {
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var after uint64
+	for {
+		page, err := pf.FetchPage(after, StreamPageSize, fields...)
+		if err != nil {
+			return err
+		}
+		for _, ev := range page.Events {
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+		if !page.More || len(page.Events) == 0 {
+			return nil
+		}
+		after = page.LastSequence
+	}
+}
+
 // EmitterFetcher defines the interface that objects supporting emitting and fetching events must implement
 type EmitterFetcher interface {
 	Emitter
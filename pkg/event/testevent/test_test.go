@@ -20,7 +20,7 @@ import (
 
 func TestBuildQuery_Positive(t *testing.T) {
 	_, err := QueryFields{
-		QueryJobID(1),
+		QueryJobID("1"),
 		QueryEmittedStartTime(time.Now()),
 		QueryEmittedEndTime(time.Now()),
 	}.BuildQuery()
@@ -29,7 +29,7 @@ func TestBuildQuery_Positive(t *testing.T) {
 
 func TestBuildQuery_NoDups(t *testing.T) {
 	_, err := QueryFields{
-		QueryJobID(2),
+		QueryJobID("2"),
 		QueryEmittedStartTime(time.Now()),
 		QueryEmittedStartTime(time.Now()),
 	}.BuildQuery()
@@ -39,10 +39,36 @@ func TestBuildQuery_NoDups(t *testing.T) {
 
 func TestBuildQuery_NoZeroValues(t *testing.T) {
 	_, err := QueryFields{
-		QueryJobID(0),
+		QueryJobID(""),
 		QueryEmittedStartTime(time.Now()),
 		QueryEmittedEndTime(time.Now()),
 	}.BuildQuery()
 	assert.Error(t, err)
 	assert.True(t, errors.As(err, &event.ErrQueryFieldHasZeroValue{}))
 }
+
+// sliceFetcher is a Fetcher without pagination.
+type sliceFetcher []Event
+
+func (f sliceFetcher) Fetch(fields ...QueryField) ([]Event, error) {
+	return f, nil
+}
+
+func TestStream_WithoutPages(t *testing.T) {
+	fetcher := sliceFetcher{
+		{Data: &Data{EventName: "first"}},
+		{Data: &Data{EventName: "second"}},
+		{Data: &Data{EventName: "third"}},
+	}
+	var names []event.Name
+	errStop := errors.New("stop")
+	err := Stream(fetcher, func(ev Event) error {
+		names = append(names, ev.Data.EventName)
+		if len(names) == 2 {
+			return errStop
+		}
+		return nil
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, []event.Name{"first", "second"}, names)
+}
@@ -0,0 +1,142 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package health tracks the outcomes of the targets across jobs, and
+// quarantines the targets which keep failing, so that a single bad device
+// does not fail every job it is acquired for.
+package health
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+)
+
+var log = logging.GetLogger("pkg/health")
+
+// Tracker is a target.HealthTracker which keeps the outcomes and the health
+// records in the storage engine, so that they are shared by the servers using
+// it and survive restarts.
+type Tracker struct {
+	window         uint
+	maxFailureRate float64
+}
+
+// New returns a Tracker which quarantines a target once it failed at least
+// maxFailureRate, between 0 and 1, of its last window runs. Targets with fewer
+// runs since they were last released are never quarantined.
+func New(window uint, maxFailureRate float64) (*Tracker, error) {
+	if window == 0 {
+		return nil, errors.New("the window of the failure rate cannot be 0")
+	}
+	if maxFailureRate <= 0 || maxFailureRate > 1 {
+		return nil, fmt.Errorf("invalid maximum failure rate %v: must be greater than 0 and at most 1", maxFailureRate)
+	}
+	return &Tracker{window: window, maxFailureRate: maxFailureRate}, nil
+}
+
+// RecordOutcomes stores the outcomes of a run, then checks the failure rate
+// of the targets which failed.
+func (t *Tracker) RecordOutcomes(outcomes []target.Outcome) error {
+	if err := storage.StoreTargetOutcomes(outcomes); err != nil {
+		return err
+	}
+	checked := make(map[string]bool)
+	for _, o := range outcomes {
+		if o.Success || checked[o.TargetID] {
+			continue
+		}
+		checked[o.TargetID] = true
+		if err := t.check(o.TargetID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FailureRate returns the failure rate of a target over its last runs since
+// it was released, and the number of runs it was computed on.
+func (t *Tracker) FailureRate(h *target.Health) (float64, int, error) {
+	outcomes, err := storage.GetTargetOutcomes(h.TargetID, h.ReleaseTime, int(t.window))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(outcomes) == 0 {
+		return 0, 0, nil
+	}
+	failures := 0
+	for _, o := range outcomes {
+		if !o.Success {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(outcomes)), len(outcomes), nil
+}
+
+// check quarantines a target if its failure rate is too high.
+func (t *Tracker) check(targetID string) error {
+	h, err := storage.GetTargetHealth(targetID)
+	if err != nil {
+		return err
+	}
+	if h.Quarantined {
+		return nil
+	}
+	rate, runs, err := t.FailureRate(h)
+	if err != nil {
+		return err
+	}
+	if uint(runs) < t.window || rate < t.maxFailureRate {
+		return nil
+	}
+	h.Quarantined = true
+	h.QuarantineTime = time.Now()
+	h.FailureRate = rate
+	log.Warningf("Quarantining target %s, which failed %.0f%% of its last %d runs", targetID, rate*100, runs)
+	return storage.StoreTargetHealth(h)
+}
+
+// Quarantined returns the IDs of the quarantined targets among the given
+// ones.
+func (t *Tracker) Quarantined(targets []*target.Target) (map[string]bool, error) {
+	records, err := storage.GetQuarantinedTargets()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(targets))
+	for _, tg := range targets {
+		ids[tg.ID] = true
+	}
+	quarantined := make(map[string]bool)
+	for _, h := range records {
+		if ids[h.TargetID] {
+			quarantined[h.TargetID] = true
+		}
+	}
+	return quarantined, nil
+}
+
+// Release takes a target out of quarantine. Its failure rate starts over,
+// so that the runs which got it quarantined do not count anymore.
+func Release(targetID string) (*target.Health, error) {
+	h, err := storage.GetTargetHealth(targetID)
+	if err != nil {
+		return nil, err
+	}
+	if !h.Quarantined {
+		return nil, fmt.Errorf("target %s is not quarantined", targetID)
+	}
+	h.Quarantined = false
+	h.ReleaseTime = time.Now()
+	if err := storage.StoreTargetHealth(h); err != nil {
+		return nil, err
+	}
+	log.Infof("Released target %s from quarantine", targetID)
+	return h, nil
+}
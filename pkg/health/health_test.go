@@ -0,0 +1,80 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package health
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func outcomes(runID types.RunID, results map[string]bool) []target.Outcome {
+	var ret []target.Outcome
+	for id, success := range results {
+		ret = append(ret, target.Outcome{TargetID: id, JobID: "1", RunID: runID, Success: success, Time: time.Now()})
+	}
+	return ret
+}
+
+func TestQuarantine(t *testing.T) {
+	storage.SetStorage(memory.New())
+	tracker, err := New(4, 0.5)
+	require.NoError(t, err)
+	target.SetHealthTracker(tracker)
+	defer target.SetHealthTracker(nil)
+
+	// flaky fails half of its runs, but only once it has enough runs
+	require.NoError(t, tracker.RecordOutcomes(outcomes(1, map[string]bool{"flaky": false, "good": true})))
+	require.NoError(t, tracker.RecordOutcomes(outcomes(2, map[string]bool{"flaky": true, "good": true})))
+	require.NoError(t, tracker.RecordOutcomes(outcomes(3, map[string]bool{"flaky": true, "good": false})))
+	quarantined, err := storage.GetQuarantinedTargets()
+	require.NoError(t, err)
+	require.Empty(t, quarantined)
+	require.NoError(t, tracker.RecordOutcomes(outcomes(4, map[string]bool{"flaky": false, "good": true})))
+
+	quarantined, err = storage.GetQuarantinedTargets()
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+	require.Equal(t, "flaky", quarantined[0].TargetID)
+	require.Equal(t, 0.5, quarantined[0].FailureRate)
+
+	targets := []*target.Target{{ID: "flaky"}, {ID: "good"}}
+	healthy, err := target.FilterQuarantined(targets)
+	require.NoError(t, err)
+	require.Equal(t, []*target.Target{{ID: "good"}}, healthy)
+
+	// a released target starts over
+	_, err = Release("good")
+	require.Error(t, err)
+	h, err := Release("flaky")
+	require.NoError(t, err)
+	require.False(t, h.Quarantined)
+	healthy, err = target.FilterQuarantined(targets)
+	require.NoError(t, err)
+	require.Equal(t, targets, healthy)
+	require.NoError(t, tracker.RecordOutcomes(outcomes(5, map[string]bool{"flaky": false})))
+	rate, runs, err := tracker.FailureRate(h)
+	require.NoError(t, err)
+	require.Equal(t, 1, runs)
+	require.Equal(t, 1.0, rate)
+	h, err = storage.GetTargetHealth("flaky")
+	require.NoError(t, err)
+	require.False(t, h.Quarantined)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(0, 0.5)
+	require.Error(t, err)
+	_, err = New(10, 0)
+	require.Error(t, err)
+	_, err = New(10, 1.5)
+	require.Error(t, err)
+}
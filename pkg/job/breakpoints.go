@@ -0,0 +1,135 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// StepKey identifies a test step within a job.
+type StepKey struct {
+	TestName      string
+	TestStepLabel string
+}
+
+func (k StepKey) String() string {
+	return fmt.Sprintf("%s/%s", k.TestName, k.TestStepLabel)
+}
+
+// Breakpoints coordinates the test steps marked as breakpoints with the
+// operator resuming the job. The TestRunner holds the targets reaching a
+// breakpoint step until Release is called.
+//
+// When resuming, the parameters of the steps which have not started yet in the
+// current run can be replaced. The TestRunner delays the start of the steps
+// from the first breakpoint onwards until they receive targets, and looks
+// their parameters up with Parameters.
+type Breakpoints struct {
+	lock     sync.Mutex
+	released chan struct{}
+	held     uint
+	// params holds the updated parameters of the steps.
+	params map[StepKey]test.TestStepParameters
+	// started holds the steps which have started in the current run.
+	run     types.RunID
+	started map[StepKey]bool
+}
+
+// NewBreakpoints returns a new Breakpoints object.
+func NewBreakpoints() *Breakpoints {
+	return &Breakpoints{
+		released: make(chan struct{}),
+		params:   make(map[StepKey]test.TestStepParameters),
+		started:  make(map[StepKey]bool),
+	}
+}
+
+// Hold registers that targets are being held at a breakpoint, and returns a
+// channel that is closed when the breakpoint is released.
+func (b *Breakpoints) Hold() <-chan struct{} {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.held++
+	return b.released
+}
+
+// Held returns the number of breakpoints which are currently holding targets.
+func (b *Breakpoints) Held() uint {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.held
+}
+
+// Release releases all the breakpoints which are currently holding targets.
+// Breakpoints that are reached later will hold targets again until the next
+// call to Release.
+func (b *Breakpoints) Release() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.release()
+}
+
+func (b *Breakpoints) release() {
+	close(b.released)
+	b.released = make(chan struct{})
+	b.held = 0
+}
+
+// ReleaseWithParameters is like Release, but it first replaces the parameters
+// of the given steps. It fails without releasing anything if any of the steps
+// has already started in the current run, as its parameters were already
+// consumed.
+func (b *Breakpoints) ReleaseWithParameters(params map[StepKey]test.TestStepParameters) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var started []string
+	for key := range params {
+		if b.started[key] {
+			started = append(started, key.String())
+		}
+	}
+	if len(started) > 0 {
+		sort.Strings(started)
+		return fmt.Errorf("cannot update the parameters of steps which already started: %s", strings.Join(started, ", "))
+	}
+	for key, p := range params {
+		b.params[key] = p
+	}
+	b.release()
+	return nil
+}
+
+// Parameters returns the parameters of a step, which are either those set when
+// resuming the job or the given defaults, and marks the step as started in the
+// given run. Updated parameters are kept for the later runs of the job.
+func (b *Breakpoints) Parameters(runID types.RunID, key StepKey, defaults test.TestStepParameters) test.TestStepParameters {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if runID != b.run {
+		b.run = runID
+		b.started = make(map[StepKey]bool)
+	}
+	b.started[key] = true
+	if p, ok := b.params[key]; ok {
+		return p
+	}
+	return defaults
+}
+
+// UpdatedParameters returns the parameters of a step set when resuming the job,
+// if any.
+func (b *Breakpoints) UpdatedParameters(key StepKey) (test.TestStepParameters, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	p, ok := b.params[key]
+	return p, ok
+}
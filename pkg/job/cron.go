@@ -0,0 +1,150 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchYears bounds the search of the next time matching a cron
+// expression, which may never match, e.g. on February 30th.
+const cronSearchYears = 5
+
+// cronMacros are the shorthands accepted in place of the five fields.
+var cronMacros = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// cronField is the set of values allowed by a field of a cron expression.
+type cronField struct {
+	values map[int]bool
+	// any is set if the field is *, which matters for the days.
+	any bool
+}
+
+func (f cronField) match(v int) bool {
+	return f.values[v]
+}
+
+// cronExpr is a parsed cron expression.
+type cronExpr struct {
+	minute, hour, dom, month, dow cronField
+}
+
+// parseCronField parses a comma-separated list of values, ranges (a-b), or
+// steps (*/n or a-b/n), within min and max.
+func parseCronField(s string, min, max int) (cronField, error) {
+	f := cronField{values: make(map[int]bool), any: s == "*"}
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return f, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return f, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return f, fmt.Errorf("invalid range %q", part)
+				}
+			} else if step > 1 {
+				// a/n runs from a to the end of the range
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return f, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			f.values[v] = true
+		}
+	}
+	return f, nil
+}
+
+// parseCron parses a cron expression with five fields: minute, hour, day of
+// the month, month and day of the week, where both 0 and 7 are Sunday.
+func parseCron(expr string) (*cronExpr, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+	var (
+		c      cronExpr
+		err    error
+		ranges = []struct {
+			field    *cronField
+			name     string
+			min, max int
+		}{
+			{&c.minute, "minute", 0, 59},
+			{&c.hour, "hour", 0, 23},
+			{&c.dom, "day of month", 1, 31},
+			{&c.month, "month", 1, 12},
+			{&c.dow, "day of week", 0, 7},
+		}
+	)
+	for i, r := range ranges {
+		if *r.field, err = parseCronField(fields[i], r.min, r.max); err != nil {
+			return nil, fmt.Errorf("invalid %s in cron expression %q: %v", r.name, expr, err)
+		}
+	}
+	if c.dow.values[7] {
+		c.dow.values[0] = true
+	}
+	return &c, nil
+}
+
+// matchDay tells whether the day of t matches. As in cron, when both the day
+// of the month and the day of the week are restricted, either can match.
+func (c *cronExpr) matchDay(t time.Time) bool {
+	dom, dow := c.dom.match(t.Day()), c.dow.match(int(t.Weekday()))
+	if !c.dom.any && !c.dow.any {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// next returns the first minute after t matching the expression, in the
+// location of t, or the zero time if there is none in the next years.
+func (c *cronExpr) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !c.month.match(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.hour.match(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.minute.match(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -8,6 +8,7 @@ package job
 import (
 	"time"
 
+	"github.com/facebookincubator/contest/pkg/secrets"
 	"github.com/facebookincubator/contest/pkg/test"
 	"github.com/facebookincubator/contest/pkg/types"
 
@@ -17,12 +18,44 @@ import (
 // JobDescriptor models the JSON encoded blob which is given as input to the
 // job creation request. A JobDescriptor embeds a list of TestDescriptor.
 type JobDescriptor struct {
-	JobName         string
-	Tags            []string
+	JobName string
+	Tags    []string
+	// ExternalID is an optional, freeform identifier used by external systems
+	// to correlate jobs with their own entities.
+	ExternalID      string
 	Runs            uint
 	RunInterval     xjson.Duration
 	TestDescriptors []*test.TestDescriptor
 	Reporting       Reporting
+	// NetworkTimeouts is the optional timeout policy of the network-based
+	// test steps of the job.
+	NetworkTimeouts *NetworkTimeouts
+	// ShuffleSteps randomizes the order of the test steps marked as order
+	// independent, to detect hidden dependencies between steps. ShuffleSeed
+	// reproduces a previous order; if zero, a random seed is picked.
+	ShuffleSteps bool
+	ShuffleSeed  int64
+	// DryRun rehearses the job: the test steps with side effects do not act on
+	// the targets. See test.DryRunnable.
+	DryRun bool
+	// MinPassPercent, if set, makes the job succeed as long as at least this
+	// percentage of the non-informational targets pass, and fail otherwise.
+	MinPassPercent *float64 `json:"min_pass_percent,omitempty"`
+	// DependsOn lists the jobs this job depends on. The job is not started if
+	// any of them failed, and is cancelled if any of them fails while it runs.
+	DependsOn []types.JobID `json:"depends_on,omitempty"`
+	// Priority orders the jobs waiting for the limit of concurrent jobs of
+	// the server: higher priorities start first, and jobs of equal priority
+	// start in submission order.
+	Priority int `json:"priority,omitempty"`
+	// Secrets maps the names of the job secrets to secret references, e.g.
+	// ${env:DB_PASSWORD}, resolved when the job starts. Step parameters refer
+	// to them as secret://name, or ${secret:name} within a longer string.
+	Secrets map[string]string `json:"secrets,omitempty"`
+	// Parameters declares the parameters of the job, whose values are given
+	// when the job is submitted. The parameters of the test descriptors and
+	// of the test steps refer to them as ${param:name}.
+	Parameters map[string]Parameter `json:"parameters,omitempty"`
 }
 
 // Job is used to run a type of test job on a given set of targets.
@@ -32,6 +65,9 @@ type Job struct {
 	// a freeform list of strings that the user can provide to tag a job, and
 	// subsequently use to search and aggregate.
 	Tags []string
+	// ExternalID is an optional identifier assigned by the submitter, which is
+	// not necessarily unique.
+	ExternalID string
 
 	// done is a job-wide channel that every stage should check to know
 	// whether work should be stopped or not.
@@ -44,6 +80,10 @@ type Job struct {
 	// pause is a job-wide channel used to request and detect job pausing.
 	PauseCh chan struct{}
 
+	// Breakpoints holds targets at the test steps marked as breakpoints until
+	// an operator resumes the job.
+	Breakpoints *Breakpoints
+
 	// How many times a job has to run. 0 means infinite.
 	// A "run" is the execution of a sequence of tests. For example, setting
 	// Runs to 2 will execute all the tests defined in `Tests` once, and then
@@ -54,7 +94,33 @@ type Job struct {
 	// unlimited, are specified.
 	RunInterval time.Duration
 
+	// ShuffleSteps randomizes the order of the order independent test steps,
+	// using ShuffleSeed as seed.
+	ShuffleSteps bool
+	ShuffleSeed  int64
+
+	// DryRun rehearses the job without the side effects of the test steps.
+	DryRun bool
+
+	// MinPassPercent, if not nil, is the minimum percentage of passing
+	// targets for the job to succeed.
+	MinPassPercent *float64
+
+	// DependsOn lists the jobs whose failure aborts this job.
+	DependsOn []types.JobID
+
+	// Priority orders the queued jobs, higher first.
+	Priority int
+
+	// Secrets are the references of the job secrets, and SecretStore their
+	// values once resolved, which are never persisted.
+	Secrets     map[string]string
+	SecretStore *secrets.Store
+
 	Tests []*test.Test
+	// ResumeFrom, if not nil, is the progress the job resumes from after it
+	// was paused.
+	ResumeFrom *PauseSnapshot
 	// RunReporterBundles and FinalReporterBundles wrap the reporter instances
 	// chosen for the Job and its associated parameters, which have already
 	// gone through validation
@@ -72,6 +138,16 @@ func (j *Job) Pause() {
 	close(j.PauseCh)
 }
 
+// IsPaused returns whether the job has been paused
+func (j *Job) IsPaused() bool {
+	select {
+	case _, ok := <-j.PauseCh:
+		return !ok
+	default:
+		return false
+	}
+}
+
 // IsCancelled returns whether the job has been cancelled
 func (j *Job) IsCancelled() bool {
 	select {
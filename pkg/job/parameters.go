@@ -0,0 +1,149 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ParameterScheme is the scheme of the references to the parameters declared
+// by a job descriptor. The parameters of the test steps, target managers and
+// test fetchers refer to the parameter `name` with `${param:name}`.
+const ParameterScheme = "param"
+
+// Parameter is a parameter declared by a job descriptor, whose value is given
+// when the job is submitted.
+type Parameter struct {
+	Description string `json:"description,omitempty"`
+	// Default is the value of an optional parameter.
+	Default *string `json:"default,omitempty"`
+}
+
+var parameterRefRe = regexp.MustCompile(`\$\{` + ParameterScheme + `:([^}]*)\}`)
+
+// validateParameters checks the names of the declared parameters.
+func validateParameters(declared map[string]Parameter) error {
+	for name := range declared {
+		if !paramNameRe.MatchString(name) {
+			return fmt.Errorf("invalid job parameter name %q", name)
+		}
+	}
+	return nil
+}
+
+// ParameterValues returns the values of the parameters declared by the job
+// descriptor. Every parameter must have a value, as set by SetParameterValues
+// when the job is submitted.
+func (jd *JobDescriptor) ParameterValues() (map[string]string, error) {
+	if err := validateParameters(jd.Parameters); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(jd.Parameters))
+	var missing []string
+	for name, p := range jd.Parameters {
+		if p.Default == nil {
+			missing = append(missing, name)
+			continue
+		}
+		values[name] = *p.Default
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing values for job parameters: %s", strings.Join(missing, ", "))
+	}
+	return values, nil
+}
+
+// ExpandParameters substitutes the values of the job parameters referenced
+// by s. As s is either a JSON document or the content of a JSON string, the
+// values are inserted escaped as the content of a JSON string.
+func ExpandParameters(s string, values map[string]string) (string, error) {
+	var err error
+	expanded := parameterRefRe.ReplaceAllStringFunc(s, func(ref string) string {
+		name := parameterRefRe.FindStringSubmatch(ref)[1]
+		value, ok := values[name]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("reference to undeclared job parameter %q", name)
+			}
+			return ref
+		}
+		return escapeJSONString(value)
+	})
+	return expanded, err
+}
+
+// SetParameterValues returns the job descriptor with the values given at
+// submission time recorded as the defaults of its parameters, so that the
+// stored descriptor holds everything needed to run the job again. Values must
+// be given for all the parameters which have no default, and only for
+// declared parameters. A descriptor which declares no parameter is returned
+// as is when no value is given.
+func SetParameterValues(jobDescriptor string, values map[string]string) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(jobDescriptor), &fields); err != nil {
+		return "", fmt.Errorf("invalid job descriptor: %v", err)
+	}
+	// the descriptor is decoded case-insensitively, like encoding/json does
+	// for the struct fields
+	var key string
+	for k := range fields {
+		if strings.EqualFold(k, "parameters") {
+			key = k
+		}
+	}
+	if key == "" {
+		if len(values) == 0 {
+			return jobDescriptor, nil
+		}
+		key = "parameters"
+	}
+	var declared map[string]Parameter
+	if raw := fields[key]; len(raw) > 0 {
+		if err := json.Unmarshal(raw, &declared); err != nil {
+			return "", fmt.Errorf("invalid job parameters: %v", err)
+		}
+	}
+	if err := validateParameters(declared); err != nil {
+		return "", err
+	}
+	var unknown []string
+	for name, value := range values {
+		p, ok := declared[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		v := value
+		p.Default = &v
+		declared[name] = p
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("unknown job parameters: %s", strings.Join(unknown, ", "))
+	}
+	jd := JobDescriptor{Parameters: declared}
+	if _, err := jd.ParameterValues(); err != nil {
+		return "", err
+	}
+	if len(values) == 0 {
+		return jobDescriptor, nil
+	}
+	encoded, err := json.Marshal(declared)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode job parameters: %v", err)
+	}
+	fields[key] = encoded
+	encoded, err = json.Marshal(fields)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode job descriptor: %v", err)
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetParameterValues(t *testing.T) {
+	descriptor := `{"JobName": "fw", "Parameters": {"version": {}, "pool": {"default": "lab"}}}`
+	set, err := SetParameterValues(descriptor, map[string]string{"version": "1.2"})
+	require.NoError(t, err)
+	var jd JobDescriptor
+	require.NoError(t, json.Unmarshal([]byte(set), &jd))
+	require.Equal(t, "fw", jd.JobName)
+	values, err := jd.ParameterValues()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"version": "1.2", "pool": "lab"}, values)
+
+	// descriptors without parameters are left untouched
+	set, err = SetParameterValues(`{"JobName": "fw"}`, nil)
+	require.NoError(t, err)
+	require.Equal(t, `{"JobName": "fw"}`, set)
+
+	_, err = SetParameterValues(descriptor, nil)
+	require.EqualError(t, err, "missing values for job parameters: version")
+	_, err = SetParameterValues(`{"JobName": "fw"}`, map[string]string{"version": "1.2"})
+	require.EqualError(t, err, "unknown job parameters: version")
+	_, err = SetParameterValues(`{"parameters": {"1version": {}}}`, nil)
+	require.Error(t, err)
+}
+
+func TestExpandParameters(t *testing.T) {
+	values := map[string]string{"url": `http://images/"a"`}
+	expanded, err := ExpandParameters(`{"URL": "${param:url}", "Name": "{{ .Name }}"}`, values)
+	require.NoError(t, err)
+	require.Equal(t, `{"URL": "http://images/\"a\"", "Name": "{{ .Name }}"}`, expanded)
+
+	_, err = ExpandParameters("${param:other}", values)
+	require.EqualError(t, err, `reference to undeclared job parameter "other"`)
+}
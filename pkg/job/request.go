@@ -6,30 +6,59 @@
 package job
 
 import (
+	"context"
 	"time"
 
+	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/types"
 )
 
 // Request represents an incoming Job request which should be persisted in storage
 type Request struct {
-	JobID         types.JobID
-	JobName       string
+	JobID      types.JobID
+	JobName    string
+	ExternalID string
+	// SeriesID links the jobs submitted by the same schedule.
+	SeriesID      string
 	Requestor     string
 	RequestTime   time.Time
 	JobDescriptor string
 }
 
+// DefaultJobQueryLimit is the number of job requests returned by a JobQuery
+// without a limit.
+const DefaultJobQueryLimit = 100
+
+// JobQuery selects job requests, in the order they were requested.
+type JobQuery struct {
+	// States restricts the results to the jobs in one of these states, see
+	// StateEvents and StateQueued. All the jobs match if States is empty.
+	States []event.Name
+	// Offset is the number of matching requests to skip.
+	Offset uint
+	// Limit is the maximum number of requests to return, or
+	// DefaultJobQueryLimit if 0.
+	Limit uint
+}
+
 // RequestEmitter is an interface implemented by creator objects that
 // create Request objects
 type RequestEmitter interface {
 	Emit(request *Request) (types.JobID, error)
+	EmitMany(requests []*Request) ([]types.JobID, error)
 }
 
 // RequestFetcher is an interface implemented by fetcher objects that fetch
 // job requests objects
 type RequestFetcher interface {
 	Fetch(id types.JobID) (*Request, error)
+	// FetchWithContext is like Fetch, but returns once ctx is done even if
+	// the storage layer did not answer yet.
+	FetchWithContext(ctx context.Context, id types.JobID) (*Request, error)
+	FetchMany(query JobQuery) ([]*Request, error)
+	// FetchEventCounts returns the number of test events emitted by a job,
+	// by event name.
+	FetchEventCounts(id types.JobID) (map[event.Name]uint64, error)
 }
 
 // RequestEmitterFetcher is an interface implemented by objects that implement both
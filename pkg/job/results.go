@@ -0,0 +1,92 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"sort"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+)
+
+// ResultSummary aggregates the structured results of a measurement across
+// the targets, steps and runs of a job. Measurements reported with different
+// units are summarized separately.
+type ResultSummary struct {
+	Measurement string
+	Unit        string `json:",omitempty"`
+	Passed      int
+	Failed      int
+	Skipped     int
+	// Values is the number of results which carry a value. Min, Max and Mean
+	// are only meaningful if it is not zero.
+	Values int
+	Min    float64 `json:",omitempty"`
+	Max    float64 `json:",omitempty"`
+	Mean   float64 `json:",omitempty"`
+}
+
+// Count returns the number of results of the measurement.
+func (s *ResultSummary) Count() int {
+	return s.Passed + s.Failed + s.Skipped
+}
+
+func (s *ResultSummary) add(r testevent.Result) {
+	switch r.Status {
+	case testevent.ResultPass:
+		s.Passed++
+	case testevent.ResultFail:
+		s.Failed++
+	case testevent.ResultSkip:
+		s.Skipped++
+	}
+	if r.Value == nil {
+		return
+	}
+	v := *r.Value
+	if s.Values == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Values == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Values++
+	s.Mean += (v - s.Mean) / float64(s.Values)
+}
+
+// SummarizeResults aggregates the results of the target statuses of every
+// test step, sorted by measurement and unit.
+func SummarizeResults(runStatuses []RunStatus) []ResultSummary {
+	type key struct{ measurement, unit string }
+	summaries := make(map[key]*ResultSummary)
+	for _, run := range runStatuses {
+		for _, t := range run.TestStatuses {
+			for _, step := range t.TestStepStatuses {
+				for _, target := range step.TargetStatuses {
+					for _, r := range target.Results {
+						k := key{r.Measurement, r.Unit}
+						s, ok := summaries[k]
+						if !ok {
+							s = &ResultSummary{Measurement: r.Measurement, Unit: r.Unit}
+							summaries[k] = s
+						}
+						s.add(r)
+					}
+				}
+			}
+		}
+	}
+	ret := make([]ResultSummary, 0, len(summaries))
+	for _, s := range summaries {
+		ret = append(ret, *s)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Measurement != ret[j].Measurement {
+			return ret[i].Measurement < ret[j].Measurement
+		}
+		return ret[i].Unit < ret[j].Unit
+	})
+	return ret
+}
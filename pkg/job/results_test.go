@@ -0,0 +1,45 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"testing"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeResults(t *testing.T) {
+	result := func(measurement string, status testevent.ResultStatus, v ...float64) testevent.Result {
+		r := testevent.Result{Measurement: measurement, Unit: "s", Status: status}
+		if len(v) > 0 {
+			r.Value = &v[0]
+		}
+		return r
+	}
+	run := func(results ...[]testevent.Result) RunStatus {
+		var targets []TargetStatus
+		for _, r := range results {
+			targets = append(targets, TargetStatus{Results: r})
+		}
+		return RunStatus{TestStatuses: []TestStatus{{TestStepStatuses: []TestStepStatus{{TargetStatuses: targets}}}}}
+	}
+	runStatuses := []RunStatus{
+		run(
+			[]testevent.Result{result("boot_time", testevent.ResultPass, 10), result("link", testevent.ResultPass)},
+			[]testevent.Result{result("boot_time", testevent.ResultFail, 40)},
+		),
+		run(
+			[]testevent.Result{result("boot_time", testevent.ResultPass, 16), result("link", testevent.ResultSkip)},
+		),
+	}
+
+	require.Equal(t, []ResultSummary{
+		{Measurement: "boot_time", Unit: "s", Passed: 2, Failed: 1, Values: 3, Min: 10, Max: 40, Mean: 22},
+		{Measurement: "link", Unit: "s", Passed: 1, Skipped: 1},
+	}, SummarizeResults(runStatuses))
+	require.Empty(t, SummarizeResults(nil))
+}
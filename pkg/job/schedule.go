@@ -0,0 +1,96 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/insomniacslk/xjson"
+)
+
+// MinScheduleInterval is the shortest interval between the jobs of a
+// schedule.
+const MinScheduleInterval = time.Minute
+
+// ScheduleState is the state of a job schedule.
+type ScheduleState string
+
+// The states of a job schedule. Paused schedules can be resumed, cancelled
+// ones cannot.
+const (
+	ScheduleActive    ScheduleState = "active"
+	SchedulePaused    ScheduleState = "paused"
+	ScheduleCancelled ScheduleState = "cancelled"
+)
+
+// Recurrence tells when the jobs of a schedule are submitted: either at the
+// times matching a cron expression, evaluated in UTC, or at a fixed interval.
+type Recurrence struct {
+	// Cron is a cron expression with five fields, minute, hour, day of the
+	// month, month and day of the week, or one of @hourly, @daily, @weekly,
+	// @monthly and @yearly.
+	Cron     string         `json:",omitempty"`
+	Interval xjson.Duration `json:",omitempty"`
+}
+
+// Validate checks that exactly one of the cron expression and the interval is
+// set, and that it is valid.
+func (r Recurrence) Validate() error {
+	switch {
+	case r.Cron == "" && r.Interval == 0:
+		return errors.New("a schedule needs either a cron expression or an interval")
+	case r.Cron != "" && r.Interval != 0:
+		return errors.New("a schedule cannot have both a cron expression and an interval")
+	case r.Cron != "":
+		_, err := parseCron(r.Cron)
+		return err
+	case time.Duration(r.Interval) < MinScheduleInterval:
+		return fmt.Errorf("schedule interval must be at least %s, got %s", MinScheduleInterval, time.Duration(r.Interval))
+	}
+	return nil
+}
+
+// Next returns the first time after t when a job is due, truncated to the
+// second so that storage engines can store it exactly. The zero time is
+// returned if there is none.
+func (r Recurrence) Next(t time.Time) time.Time {
+	if r.Interval != 0 {
+		return t.Add(time.Duration(r.Interval)).Truncate(time.Second)
+	}
+	c, err := parseCron(r.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	return c.next(t.UTC())
+}
+
+// Schedule submits a job periodically. The jobs of a schedule form a series,
+// whose ID is recorded with their requests.
+type Schedule struct {
+	ID uint64
+	Recurrence
+	// JobDescriptor is the descriptor of the jobs, where the values of the job
+	// parameters are already set.
+	JobDescriptor string
+	Requestor     string
+	CreateTime    time.Time
+	State         ScheduleState
+	// NextRun is when the next job is due, and is zero once the schedule is
+	// cancelled. Runs missed while no server was running are skipped.
+	NextRun time.Time
+	// Runs is the number of jobs submitted, LastJobID the ID of the last one.
+	Runs      uint64
+	LastJobID types.JobID `json:",omitempty"`
+}
+
+// SeriesID returns the ID of the series of the jobs of the schedule.
+func (s *Schedule) SeriesID() string {
+	return "schedule-" + strconv.FormatUint(s.ID, 10)
+}
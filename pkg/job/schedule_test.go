@@ -0,0 +1,55 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/xjson"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecurrenceValidate(t *testing.T) {
+	for _, r := range []Recurrence{
+		{},
+		{Cron: "@daily", Interval: xjson.Duration(time.Hour)},
+		{Interval: xjson.Duration(time.Second)},
+		{Cron: "* * * *"},
+		{Cron: "60 * * * *"},
+		{Cron: "*/0 * * * *"},
+		{Cron: "5-1 * * * *"},
+		{Cron: "@sometimes"},
+	} {
+		require.Error(t, r.Validate(), "%+v", r)
+	}
+	require.NoError(t, Recurrence{Cron: "0,30 9-17 * * 1-5"}.Validate())
+	require.NoError(t, Recurrence{Interval: xjson.Duration(time.Hour)}.Validate())
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2020, time.January, 15, 10, 7, 30, 0, time.UTC)
+	for cron, want := range map[string]time.Time{
+		"*/15 * * * *": time.Date(2020, time.January, 15, 10, 15, 0, 0, time.UTC),
+		"@hourly":      time.Date(2020, time.January, 15, 11, 0, 0, 0, time.UTC),
+		"@daily":       time.Date(2020, time.January, 16, 0, 0, 0, 0, time.UTC),
+		"@monthly":     time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC),
+		"30 8-9 * * *": time.Date(2020, time.January, 16, 8, 30, 0, 0, time.UTC),
+		// 7 is Sunday, like 0
+		"0 0 * * 7": time.Date(2020, time.January, 19, 0, 0, 0, 0, time.UTC),
+		// either the day of the month or the day of the week
+		"0 0 20 * 5": time.Date(2020, time.January, 17, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *": time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC),
+		"0 0 30 2 *": {},
+	} {
+		require.Equal(t, want, Recurrence{Cron: cron}.Next(now), cron)
+	}
+	require.Equal(t,
+		time.Date(2020, time.January, 15, 11, 7, 30, 0, time.UTC),
+		Recurrence{Interval: xjson.Duration(time.Hour)}.Next(now.Add(time.Millisecond)),
+	)
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// StepState is the progress of the targets of a test step when its job was
+// paused.
+type StepState struct {
+	TestStepLabel string
+	// Pending are the targets waiting to enter the step.
+	Pending []*target.Target `json:",omitempty"`
+	// InFlight are the targets which entered the step and did not leave it.
+	// The step is resumed with them.
+	InFlight []*target.Target `json:",omitempty"`
+}
+
+// PauseSnapshot is the progress of a paused job, from which it is resumed
+// when the server restarts.
+type PauseSnapshot struct {
+	RunID types.RunID
+	// TestIndex is the index of the test the job was running.
+	TestIndex int
+	// ShuffleSeed is the seed the order of the steps was shuffled with.
+	ShuffleSeed int64 `json:",omitempty"`
+	// Targets are the targets acquired for the test, which stay locked while
+	// the job is paused.
+	Targets []*target.Target `json:",omitempty"`
+	// Steps is the progress of the steps of the test, in the order they run.
+	// The targets absent from all the steps completed the test. If Steps is
+	// empty, the test had not started and runs from the start.
+	Steps []StepState `json:",omitempty"`
+	// RunReports are the reports of the runs which completed before the job
+	// was paused.
+	RunReports [][]*Report `json:",omitempty"`
+}
+
+// Started tells whether the test the job was running had started.
+func (s *PauseSnapshot) Started() bool {
+	return len(s.Steps) > 0
+}
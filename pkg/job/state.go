@@ -0,0 +1,62 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"github.com/facebookincubator/contest/pkg/event"
+)
+
+// EventJobStarted indicates that a Job is beginning execution
+var EventJobStarted = event.Name("JobStateStarted")
+
+// EventJobPaused indicates that a Job was paused, e.g. because the server shut
+// down. Its payload is the PauseSnapshot the job is resumed from.
+var EventJobPaused = event.Name("JobStatePaused")
+
+// EventJobCompleted indicates that a Job has completed
+var EventJobCompleted = event.Name("JobStateCompleted")
+
+// EventJobFailed indicates that a Job has failed
+var EventJobFailed = event.Name("JobStateFailed")
+
+// EventJobCancelling indicates that a Job has received a cancellation request
+// and the JobManager is waiting for JobRunner to return
+var EventJobCancelling = event.Name("JobStateCancelling")
+
+// EventJobCancelled indicates that a Job has been cancelled
+var EventJobCancelled = event.Name("JobStateCancelled")
+
+// EventJobCancellationFailed indicates that the cancellation was not completed correctly
+var EventJobCancellationFailed = event.Name("JobStateCancelled")
+
+// EventJobAbortedByDependency indicates that a Job is being cancelled because
+// a job it depends on failed
+var EventJobAbortedByDependency = event.Name("JobAbortedByDependency")
+
+// StateQueued is the state of the jobs which did not emit any state event
+// yet.
+var StateQueued = event.Name("")
+
+// CompletionEvents gather all event that mark the end of a job
+var CompletionEvents = []event.Name{
+	EventJobCompleted,
+	EventJobFailed,
+	EventJobCancelled,
+	EventJobCancellationFailed,
+}
+
+// StateEvents gather all event names which track the state of a job. The
+// state of a job is the name of the last of these events it emitted.
+var StateEvents = []event.Name{
+	EventJobStarted,
+	EventJobPaused,
+	EventJobCompleted,
+	EventJobFailed,
+	EventJobCancelling,
+	EventJobAbortedByDependency,
+	EventJobCancelled,
+	EventJobCancellationFailed,
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
 	"github.com/facebookincubator/contest/pkg/types"
 )
 
@@ -45,6 +46,16 @@ type TargetStatus struct {
 	InTime  time.Time
 	OutTime time.Time
 	Error   string
+	// Verdict and Reason are the result of the test step for the target. The
+	// verdict is empty if the target has not left the step.
+	Verdict test.Verdict
+	Reason  string
+	// Informational is set for the targets whose failures do not count
+	// toward the outcome of the job.
+	Informational bool
+	// Results are the structured results emitted by the test step for the
+	// target, in the order they were emitted.
+	Results []testevent.Result `json:",omitempty"`
 }
 
 // TestStepStatus bundles together all the TargetStatus for a specific TestStep (represented via
@@ -78,6 +89,10 @@ type Status struct {
 	// State represents the last recorded state of a job
 	State string
 
+	// QueuePosition is the position of a queued job in the queue of the
+	// server, starting from 1 for the job which starts next.
+	QueuePosition uint `json:",omitempty"`
+
 	// StartTime indicates when the job started. A value of 0 indicates "not
 	// started yet"
 	StartTime time.Time
@@ -91,4 +106,11 @@ type Status struct {
 
 	// Job report information
 	JobReport *JobReport
+
+	// Summary counts the targets which passed and failed in all the runs.
+	Summary *Summary `json:",omitempty"`
+
+	// Results aggregates the structured results emitted by the test steps,
+	// by measurement.
+	Results []ResultSummary `json:",omitempty"`
 }
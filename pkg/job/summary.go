@@ -0,0 +1,57 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+// Summary counts the outcomes of the targets of a job. A target is counted
+// once per test and per run. Informational targets are counted separately, and
+// do not affect the success of the job.
+type Summary struct {
+	Passed              int
+	Failed              int
+	InformationalPassed int `json:",omitempty"`
+	InformationalFailed int `json:",omitempty"`
+	// PassPercent is the percentage of non-informational targets which
+	// passed, or 100 if there are none.
+	PassPercent float64
+	// MinPassPercent is the threshold applied to PassPercent, if any.
+	MinPassPercent *float64 `json:",omitempty"`
+	// Success tells whether the job succeeded: without a threshold, all the
+	// non-informational targets must pass.
+	Success bool
+}
+
+// BuildSummary builds the summary of a job from the status of its runs.
+func BuildSummary(runStatuses []RunStatus, minPassPercent *float64) Summary {
+	s := Summary{MinPassPercent: minPassPercent}
+	for _, run := range runStatuses {
+		for _, t := range run.TestStatuses {
+			for _, target := range t.TargetStatuses {
+				switch {
+				case target.Informational && target.Error != "":
+					s.InformationalFailed++
+				case target.Informational:
+					s.InformationalPassed++
+				case target.Error != "":
+					s.Failed++
+				default:
+					s.Passed++
+				}
+			}
+		}
+	}
+	total := s.Passed + s.Failed
+	s.PassPercent = 100
+	if total > 0 {
+		s.PassPercent = float64(s.Passed) * 100 / float64(total)
+	}
+	if minPassPercent == nil {
+		s.Success = s.Failed == 0
+	} else {
+		// compare the counts, rather than the rounded percentage
+		s.Success = float64(s.Passed)*100 >= *minPassPercent*float64(total)
+	}
+	return s
+}
@@ -0,0 +1,58 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newRunStatuses returns the status of a run where 10 targets were tested,
+// and the given number passed, plus a failed informational target.
+func newRunStatuses(passed int) []RunStatus {
+	var targets []TargetStatus
+	for i := 0; i < 10; i++ {
+		status := TargetStatus{}
+		if i >= passed {
+			status.Error = "failed"
+		}
+		targets = append(targets, status)
+	}
+	targets = append(targets, TargetStatus{Informational: true, Error: "failed"})
+	return []RunStatus{{TestStatuses: []TestStatus{{TargetStatuses: targets}}}}
+}
+
+func TestSummaryMinPassPercent(t *testing.T) {
+	minPassPercent := 80.0
+	for _, tc := range []struct {
+		name    string
+		passed  int
+		success bool
+	}{
+		{"below", 7, false},
+		{"at", 8, true},
+		{"above", 9, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := BuildSummary(newRunStatuses(tc.passed), &minPassPercent)
+			require.Equal(t, tc.passed, s.Passed)
+			require.Equal(t, 10-tc.passed, s.Failed)
+			require.Equal(t, 1, s.InformationalFailed)
+			require.InDelta(t, float64(tc.passed)*10, s.PassPercent, 1e-9)
+			require.Equal(t, tc.success, s.Success)
+		})
+	}
+}
+
+func TestSummaryWithoutMinPassPercent(t *testing.T) {
+	require.False(t, BuildSummary(newRunStatuses(9), nil).Success)
+	// failures of informational targets do not count
+	require.True(t, BuildSummary(newRunStatuses(10), nil).Success)
+	s := BuildSummary(nil, nil)
+	require.True(t, s.Success)
+	require.Equal(t, float64(100), s.PassPercent)
+}
@@ -0,0 +1,202 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Template is a parameterized job descriptor, stored once and used to submit
+// jobs by name. Storing a template with the name of an existing one creates a
+// new version of it.
+//
+// Placeholders in the descriptor have the form ${name}, or ${name:default} for
+// optional parameters, and $${ is a literal ${. Values are inserted escaped as
+// the content of a JSON string, so that placeholders can appear within
+// strings, or in place of numbers and booleans. Placeholders do not conflict
+// with the {{ }} templates of the test step parameters, which are expanded
+// per target, nor with the ${param:name} references to the job parameters,
+// which are left for the job descriptor to resolve.
+type Template struct {
+	Name        string
+	Version     uint
+	Descriptor  string
+	Requestor   string
+	RequestTime time.Time
+}
+
+// TemplateParameter is a parameter of a template.
+type TemplateParameter struct {
+	Name string
+	// Default is the value of an optional parameter.
+	Default *string `json:",omitempty"`
+}
+
+var (
+	templateNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	placeholderRe  = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+	paramNameRe    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ValidateTemplateName checks that a template name is valid.
+func ValidateTemplateName(name string) error {
+	if !templateNameRe.MatchString(name) {
+		return fmt.Errorf("invalid template name %q: must be 1 to 64 letters, digits, '_', '.' or '-'", name)
+	}
+	return nil
+}
+
+// placeholder is an occurrence of a parameter in a template.
+type placeholder struct {
+	start, end int
+	param      TemplateParameter
+	// literal is set for the $${ escape sequence.
+	literal bool
+	// verbatim is set for the references to job parameters, which are kept
+	// as they are.
+	verbatim bool
+}
+
+func parsePlaceholders(descriptor string) ([]placeholder, error) {
+	var placeholders []placeholder
+	for _, m := range placeholderRe.FindAllStringSubmatchIndex(descriptor, -1) {
+		p := placeholder{start: m[0], end: m[1]}
+		if m[2] < 0 {
+			p.literal = true
+			placeholders = append(placeholders, p)
+			continue
+		}
+		spec := descriptor[m[2]:m[3]]
+		if strings.HasPrefix(spec, ParameterScheme+":") {
+			p.verbatim = true
+			placeholders = append(placeholders, p)
+			continue
+		}
+		name := spec
+		if i := strings.Index(spec, ":"); i >= 0 {
+			name = spec[:i]
+			def := spec[i+1:]
+			p.param.Default = &def
+		}
+		if !paramNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid template parameter name %q", name)
+		}
+		p.param.Name = name
+		placeholders = append(placeholders, p)
+	}
+	return placeholders, nil
+}
+
+// Parameters returns the parameters of the template, sorted by name. A
+// parameter is optional only if all of its occurrences have the same default
+// value.
+func (t *Template) Parameters() ([]TemplateParameter, error) {
+	placeholders, err := parsePlaceholders(t.Descriptor)
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]TemplateParameter)
+	for _, p := range placeholders {
+		if p.literal || p.verbatim {
+			continue
+		}
+		prev, ok := params[p.param.Name]
+		if !ok {
+			params[p.param.Name] = p.param
+			continue
+		}
+		if (prev.Default == nil) != (p.param.Default == nil) || (prev.Default != nil && *prev.Default != *p.param.Default) {
+			return nil, fmt.Errorf("template parameter %q has different default values", p.param.Name)
+		}
+	}
+	var sorted []TemplateParameter
+	for _, p := range params {
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted, nil
+}
+
+// Validate checks the name and the placeholders of the template.
+func (t *Template) Validate() error {
+	if err := ValidateTemplateName(t.Name); err != nil {
+		return err
+	}
+	if strings.TrimSpace(t.Descriptor) == "" {
+		return errors.New("template descriptor cannot be empty")
+	}
+	_, err := t.Parameters()
+	return err
+}
+
+// escapeJSONString returns the value escaped as the content of a JSON string.
+func escapeJSONString(value string) string {
+	quoted, _ := json.Marshal(value)
+	return string(quoted[1 : len(quoted)-1])
+}
+
+// Render substitutes the values of the parameters in the template, and returns
+// the resulting job descriptor. All the required parameters must have a
+// value, and all the values must match a parameter.
+func (t *Template) Render(values map[string]string) (string, error) {
+	params, err := t.Parameters()
+	if err != nil {
+		return "", err
+	}
+	known := make(map[string]bool)
+	var missing []string
+	for _, p := range params {
+		known[p.Name] = true
+		if _, ok := values[p.Name]; !ok && p.Default == nil {
+			missing = append(missing, p.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing values for template parameters: %s", strings.Join(missing, ", "))
+	}
+	var unknown []string
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("unknown template parameters: %s", strings.Join(unknown, ", "))
+	}
+
+	placeholders, err := parsePlaceholders(t.Descriptor)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	last := 0
+	for _, p := range placeholders {
+		b.WriteString(t.Descriptor[last:p.start])
+		last = p.end
+		if p.literal {
+			b.WriteString("${")
+			continue
+		}
+		if p.verbatim {
+			b.WriteString(t.Descriptor[p.start:p.end])
+			continue
+		}
+		value, ok := values[p.param.Name]
+		if !ok {
+			value = *p.param.Default
+		}
+		b.WriteString(escapeJSONString(value))
+	}
+	b.WriteString(t.Descriptor[last:])
+	return b.String(), nil
+}
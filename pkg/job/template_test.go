@@ -0,0 +1,65 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateRender(t *testing.T) {
+	tmpl := Template{
+		Name:       "smoke",
+		Descriptor: `{"JobName": "smoke ${kernel}", "Runs": ${runs:1}, "Text": "{{ .Name }} $${kernel}", "Kernel": "${kernel}"}`,
+	}
+	require.NoError(t, tmpl.Validate())
+	params, err := tmpl.Parameters()
+	require.NoError(t, err)
+	require.Len(t, params, 2)
+	require.Equal(t, "kernel", params[0].Name)
+	require.Nil(t, params[0].Default)
+	require.Equal(t, "runs", params[1].Name)
+	require.Equal(t, "1", *params[1].Default)
+
+	rendered, err := tmpl.Render(map[string]string{"kernel": `5.4 "rc1"`})
+	require.NoError(t, err)
+	require.Equal(t, `{"JobName": "smoke 5.4 \"rc1\"", "Runs": 1, "Text": "{{ .Name }} ${kernel}", "Kernel": "5.4 \"rc1\""}`, rendered)
+
+	rendered, err = tmpl.Render(map[string]string{"kernel": "5.10", "runs": "3"})
+	require.NoError(t, err)
+	require.Contains(t, rendered, `"Runs": 3`)
+}
+
+func TestTemplateRenderInvalidValues(t *testing.T) {
+	tmpl := Template{Name: "smoke", Descriptor: `{"JobName": "${name}", "Runs": ${runs:1}}`}
+	_, err := tmpl.Render(nil)
+	require.EqualError(t, err, "missing values for template parameters: name")
+	_, err = tmpl.Render(map[string]string{"name": "job", "nmae": "typo"})
+	require.EqualError(t, err, "unknown template parameters: nmae")
+}
+
+func TestTemplateValidate(t *testing.T) {
+	for _, tmpl := range []Template{
+		{Name: "", Descriptor: "{}"},
+		{Name: "a/b", Descriptor: "{}"},
+		{Name: "smoke", Descriptor: " "},
+		{Name: "smoke", Descriptor: `{"JobName": "${1name}"}`},
+		{Name: "smoke", Descriptor: `{"JobName": "${name:a}", "Text": "${name:b}"}`},
+	} {
+		require.Error(t, tmpl.Validate(), tmpl.Descriptor)
+	}
+}
+
+func TestTemplateKeepsJobParameters(t *testing.T) {
+	tmpl := Template{Name: "smoke", Descriptor: `{"JobName": "${name}", "Text": "${param:version}"}`}
+	params, err := tmpl.Parameters()
+	require.NoError(t, err)
+	require.Len(t, params, 1)
+	rendered, err := tmpl.Render(map[string]string{"name": "job"})
+	require.NoError(t, err)
+	require.Equal(t, `{"JobName": "job", "Text": "${param:version}"}`, rendered)
+}
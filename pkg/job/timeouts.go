@@ -0,0 +1,76 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/test"
+
+	"github.com/insomniacslk/xjson"
+)
+
+// Names of the test step parameters carrying the network timeouts.
+const (
+	ConnectTimeoutParameter = "connect_timeout"
+	ReadTimeoutParameter    = "read_timeout"
+	WriteTimeoutParameter   = "write_timeout"
+	TotalTimeoutParameter   = "total_timeout"
+)
+
+// NetworkTimeouts is the job-wide timeout policy of the network-based test
+// steps. Each non-zero timeout is passed to every test step of the job as a
+// parameter, unless the step already sets it.
+type NetworkTimeouts struct {
+	// Connect bounds the time spent establishing a connection, including
+	// protocol handshakes.
+	Connect xjson.Duration
+	// Read and Write bound each single read or write on a connection.
+	Read  xjson.Duration
+	Write xjson.Duration
+	// Total bounds a whole operation, e.g. an HTTP request or a remote
+	// command.
+	Total xjson.Duration
+}
+
+func (nt *NetworkTimeouts) parameters() map[string]xjson.Duration {
+	return map[string]xjson.Duration{
+		ConnectTimeoutParameter: nt.Connect,
+		ReadTimeoutParameter:    nt.Read,
+		WriteTimeoutParameter:   nt.Write,
+		TotalTimeoutParameter:   nt.Total,
+	}
+}
+
+// Validate checks that no timeout is negative.
+func (nt *NetworkTimeouts) Validate() error {
+	for name, d := range nt.parameters() {
+		if d < 0 {
+			return fmt.Errorf("invalid network timeout %s: must be non-negative", name)
+		}
+	}
+	return nil
+}
+
+// Apply returns a copy of the test step parameters, with the timeouts of the
+// policy added for the ones which are not set.
+func (nt *NetworkTimeouts) Apply(params test.TestStepParameters) test.TestStepParameters {
+	ret := make(test.TestStepParameters, len(params)+4)
+	for k, v := range params {
+		ret[k] = v
+	}
+	if nt == nil {
+		return ret
+	}
+	for name, d := range nt.parameters() {
+		if _, ok := ret[name]; ok || d == 0 {
+			continue
+		}
+		ret[name] = []test.Param{*test.NewParam(time.Duration(d).String())}
+	}
+	return ret
+}
@@ -0,0 +1,148 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"sort"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// VerdictSchemaVersion is the version of the schema of Verdict. Adding fields
+// keeps the version, while any other change to the schema increases it.
+const VerdictSchemaVersion = 1
+
+// VerdictFileName is the name under which the verdict of a job is published.
+const VerdictFileName = "verdict.json"
+
+// EventJobVerdict is emitted once a job ended, with the Verdict of the job as
+// payload.
+var EventJobVerdict = event.Name("JobVerdict")
+
+// Verdict is the final, machine-readable outcome of a job, which CI systems
+// can gate on. It is produced by the framework whether or not the job has
+// reporters. The JSON field names are part of the schema.
+type Verdict struct {
+	SchemaVersion int         `json:"schema_version"`
+	JobID         types.JobID `json:"job_id"`
+	JobName       string      `json:"job_name"`
+	// State is the final state of the job, e.g. JobStateCompleted.
+	State   string `json:"state"`
+	Success bool   `json:"success"`
+	// Error is the reason why the job failed, if it did not fail because of
+	// its targets only.
+	Error  string        `json:"error,omitempty"`
+	Runs   int           `json:"runs"`
+	Counts VerdictCounts `json:"counts"`
+	// Steps summarizes the outcomes of the targets in each step, across the
+	// runs, in the order of the tests and of their steps.
+	Steps         []StepVerdict  `json:"steps"`
+	FailedTargets []FailedTarget `json:"failed_targets"`
+	Time          time.Time      `json:"time"`
+}
+
+// VerdictCounts counts the outcomes of the targets of a job, once per test and
+// per run, like Summary does.
+type VerdictCounts struct {
+	Passed              int     `json:"passed"`
+	Failed              int     `json:"failed"`
+	InformationalPassed int     `json:"informational_passed"`
+	InformationalFailed int     `json:"informational_failed"`
+	PassPercent         float64 `json:"pass_percent"`
+}
+
+// StepVerdict counts the outcomes of the targets of a test step.
+type StepVerdict struct {
+	Test    string `json:"test"`
+	Step    string `json:"step"`
+	Passed  int    `json:"passed"`
+	Failed  int    `json:"failed"`
+	Skipped int    `json:"skipped"`
+}
+
+// FailedTarget is a target which failed a test, in the step it failed.
+type FailedTarget struct {
+	Run           types.RunID `json:"run"`
+	Test          string      `json:"test"`
+	Step          string      `json:"step"`
+	TargetID      string      `json:"target_id"`
+	TargetName    string      `json:"target_name"`
+	Error         string      `json:"error"`
+	Informational bool        `json:"informational,omitempty"`
+}
+
+// BuildVerdict builds the verdict of a job from the status of its runs. state
+// is the final state of the job, and jobErr the error it failed with, if any.
+func BuildVerdict(j *Job, state event.Name, runStatuses []RunStatus, jobErr error, now time.Time) *Verdict {
+	summary := BuildSummary(runStatuses, j.MinPassPercent)
+	v := Verdict{
+		SchemaVersion: VerdictSchemaVersion,
+		JobID:         j.ID,
+		JobName:       j.Name,
+		State:         string(state),
+		Success:       summary.Success && jobErr == nil && state == EventJobCompleted,
+		Runs:          len(runStatuses),
+		Counts: VerdictCounts{
+			Passed:              summary.Passed,
+			Failed:              summary.Failed,
+			InformationalPassed: summary.InformationalPassed,
+			InformationalFailed: summary.InformationalFailed,
+			PassPercent:         summary.PassPercent,
+		},
+		Steps:         []StepVerdict{},
+		FailedTargets: []FailedTarget{},
+		Time:          now.UTC(),
+	}
+	if jobErr != nil {
+		v.Error = jobErr.Error()
+	}
+
+	steps := make(map[[2]string]int)
+	for _, run := range runStatuses {
+		for _, t := range run.TestStatuses {
+			for _, s := range t.TestStepStatuses {
+				key := [2]string{t.TestName, s.TestStepLabel}
+				idx, ok := steps[key]
+				if !ok {
+					idx = len(v.Steps)
+					steps[key] = idx
+					v.Steps = append(v.Steps, StepVerdict{Test: t.TestName, Step: s.TestStepLabel})
+				}
+				for _, target := range s.TargetStatuses {
+					switch {
+					case target.Verdict == test.VerdictFail || target.Error != "":
+						v.Steps[idx].Failed++
+					case target.Verdict == test.VerdictSkip:
+						v.Steps[idx].Skipped++
+					case target.Verdict == test.VerdictPass || !target.OutTime.IsZero():
+						v.Steps[idx].Passed++
+					}
+				}
+			}
+			var failed []FailedTarget
+			for _, target := range t.TargetStatuses {
+				if target.Error == "" || target.Target == nil {
+					continue
+				}
+				failed = append(failed, FailedTarget{
+					Run:           run.RunID,
+					Test:          t.TestName,
+					Step:          target.TestStepLabel,
+					TargetID:      target.Target.ID,
+					TargetName:    target.Target.Name,
+					Error:         target.Error,
+					Informational: target.Informational,
+				})
+			}
+			sort.Slice(failed, func(i, k int) bool { return failed[i].TargetID < failed[k].TargetID })
+			v.FailedTargets = append(v.FailedTargets, failed...)
+		}
+	}
+	return &v
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/stretchr/testify/require"
+)
+
+func stepTarget(step, id string, verdict test.Verdict, err string) TargetStatus {
+	s := TargetStatus{Target: &target.Target{ID: id, Name: "host" + id}, Verdict: verdict, Error: err, OutTime: time.Unix(1, 0)}
+	s.TestStepLabel = step
+	return s
+}
+
+// mixedRunStatuses returns a run where target 1 passes both steps, target 2
+// is skipped by the first step and fails the second one, and target 3 fails
+// the first step.
+func mixedRunStatuses() []RunStatus {
+	first := []TargetStatus{
+		stepTarget("first", "1", test.VerdictPass, ""),
+		stepTarget("first", "2", test.VerdictSkip, ""),
+		stepTarget("first", "3", test.VerdictFail, "boom"),
+	}
+	second := []TargetStatus{
+		stepTarget("second", "1", test.VerdictPass, ""),
+		stepTarget("second", "2", test.VerdictFail, "bang"),
+	}
+	ts := TestStatus{
+		TestStepStatuses: []TestStepStatus{{TargetStatuses: first}, {TargetStatuses: second}},
+		// the targets fail or pass the test in their last step
+		TargetStatuses: []TargetStatus{second[1], first[2], second[0]},
+	}
+	ts.TestName = "test"
+	ts.RunID = 1
+	for i := range ts.TestStepStatuses {
+		ts.TestStepStatuses[i].TestStepLabel = ts.TestStepStatuses[i].TargetStatuses[0].TestStepLabel
+	}
+	return []RunStatus{{RunCoordinates: RunCoordinates{JobID: "7", RunID: 1}, TestStatuses: []TestStatus{ts}}}
+}
+
+func TestVerdictMixedResults(t *testing.T) {
+	j := &Job{ID: "7", Name: "mixed"}
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	v := BuildVerdict(j, EventJobCompleted, mixedRunStatuses(), nil, now)
+
+	require.Equal(t, VerdictSchemaVersion, v.SchemaVersion)
+	require.False(t, v.Success)
+	require.Equal(t, string(EventJobCompleted), v.State)
+	require.Equal(t, 1, v.Runs)
+	require.Equal(t, VerdictCounts{Passed: 1, Failed: 2, PassPercent: 100.0 / 3}, v.Counts)
+	require.Equal(t, []StepVerdict{
+		{Test: "test", Step: "first", Passed: 1, Failed: 1, Skipped: 1},
+		{Test: "test", Step: "second", Passed: 1, Failed: 1},
+	}, v.Steps)
+	require.Equal(t, []FailedTarget{
+		{Run: 1, Test: "test", Step: "second", TargetID: "2", TargetName: "host2", Error: "bang"},
+		{Run: 1, Test: "test", Step: "first", TargetID: "3", TargetName: "host3", Error: "boom"},
+	}, v.FailedTargets)
+
+	// the JSON field names are part of the schema
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &doc))
+	for _, field := range []string{"schema_version", "job_id", "job_name", "state", "success", "runs", "counts", "steps", "failed_targets", "time"} {
+		require.Contains(t, doc, field)
+	}
+	require.NotContains(t, doc, "error")
+}
+
+func TestVerdictSuccess(t *testing.T) {
+	j := &Job{ID: "7", Name: "mixed"}
+	minPassPercent := 30.0
+	j.MinPassPercent = &minPassPercent
+	require.True(t, BuildVerdict(j, EventJobCompleted, mixedRunStatuses(), nil, time.Now()).Success)
+	require.False(t, BuildVerdict(j, EventJobCancelled, mixedRunStatuses(), nil, time.Now()).Success)
+
+	v := BuildVerdict(j, EventJobFailed, mixedRunStatuses(), errors.New("no targets"), time.Now())
+	require.False(t, v.Success)
+	require.Equal(t, "no targets", v.Error)
+
+	// jobs without runs have empty lists rather than null ones
+	v = BuildVerdict(&Job{ID: "8"}, EventJobCompleted, nil, nil, time.Now())
+	require.True(t, v.Success)
+	require.NotNil(t, v.Steps)
+	require.NotNil(t, v.FailedTargets)
+}
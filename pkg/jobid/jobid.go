@@ -0,0 +1,86 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package jobid implements the strategies allocating the IDs of new jobs:
+// sequential numbers, ULIDs or UUIDs. The strategy is selected at server
+// startup, and the storage layer stores the IDs as they are allocated.
+package jobid
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// Allocator allocates the IDs of new jobs. Implementations must be safe for
+// concurrent use, and never return the same ID twice.
+type Allocator interface {
+	NewJobID() (types.JobID, error)
+	// Parse parses a job ID received from a client, rejecting the IDs which
+	// the strategy cannot have allocated, and returns its canonical form.
+	Parse(s string) (types.JobID, error)
+}
+
+// Names of the allocation strategies.
+const (
+	StrategySequential = "sequential"
+	StrategyULID       = "ulid"
+	StrategyUUID       = "uuid"
+)
+
+// Strategies lists the names of the allocation strategies.
+var Strategies = []string{StrategySequential, StrategyULID, StrategyUUID}
+
+// New returns the allocator of the given strategy. The sequential strategy
+// allocates its IDs from next, e.g. storage.NextJobSequence.
+func New(strategy string, next func() (uint64, error)) (Allocator, error) {
+	switch strategy {
+	case StrategySequential:
+		return NewSequential(next), nil
+	case StrategyULID:
+		return NewULID(), nil
+	case StrategyUUID:
+		return NewUUID(), nil
+	default:
+		return nil, fmt.Errorf("unknown job ID strategy %q, must be one of %v", strategy, Strategies)
+	}
+}
+
+// Sequential allocates decimal job IDs from a sequence, which is shared by
+// all the servers using the same storage.
+type Sequential struct {
+	next func() (uint64, error)
+}
+
+// NewSequential returns a Sequential allocator using the values of next.
+func NewSequential(next func() (uint64, error)) *Sequential {
+	return &Sequential{next: next}
+}
+
+// NewJobID returns the next value of the sequence as a job ID.
+func (s *Sequential) NewJobID() (types.JobID, error) {
+	value, err := s.next()
+	if err != nil {
+		return "", fmt.Errorf("could not allocate sequential job ID: %v", err)
+	}
+	if value == 0 {
+		return "", fmt.Errorf("could not allocate sequential job ID: the sequence returned 0")
+	}
+	return types.JobID(strconv.FormatUint(value, 10)), nil
+}
+
+// Parse only accepts the decimal job IDs the sequence can return.
+func (s *Sequential) Parse(str string) (types.JobID, error) {
+	jobID, err := types.ParseJobID(str)
+	if err != nil {
+		return "", err
+	}
+	if _, err := strconv.ParseUint(jobID.String(), 10, 64); err != nil || !jobID.Numeric() {
+		return "", fmt.Errorf("invalid job ID %q: must be a number between 1 and %d", str, uint64(math.MaxUint64))
+	}
+	return jobID, nil
+}
@@ -0,0 +1,206 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobid
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+// allocate allocates n job IDs from each of the goroutines, and checks that
+// they are all unique valid job IDs.
+func allocate(t *testing.T, a Allocator, goroutines, n int) []types.JobID {
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		ids  []types.JobID
+	)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var allocated []types.JobID
+			for i := 0; i < n; i++ {
+				id, err := a.NewJobID()
+				require.NoError(t, err)
+				allocated = append(allocated, id)
+			}
+			lock.Lock()
+			ids = append(ids, allocated...)
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	seen := make(map[types.JobID]bool)
+	for _, id := range ids {
+		require.False(t, seen[id], "duplicate job ID %s", id)
+		seen[id] = true
+		parsed, err := types.ParseJobID(id.String())
+		require.NoError(t, err)
+		require.Equal(t, id, parsed)
+	}
+	require.Len(t, seen, goroutines*n)
+	return ids
+}
+
+func counter() func() (uint64, error) {
+	var value uint64
+	return func() (uint64, error) {
+		return atomic.AddUint64(&value, 1), nil
+	}
+}
+
+func TestSequential(t *testing.T) {
+	a := NewSequential(counter())
+	id, err := a.NewJobID()
+	require.NoError(t, err)
+	require.Equal(t, types.JobID("1"), id)
+	require.True(t, id.Numeric())
+
+	allocate(t, a, 16, 200)
+}
+
+func TestSequentialErrors(t *testing.T) {
+	_, err := NewSequential(func() (uint64, error) { return 0, errors.New("storage down") }).NewJobID()
+	require.Error(t, err)
+	_, err = NewSequential(func() (uint64, error) { return 0, nil }).NewJobID()
+	require.Error(t, err)
+}
+
+var ulidRe = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+
+func TestULID(t *testing.T) {
+	ids := allocate(t, NewULID(), 16, 200)
+	for _, id := range ids {
+		require.Regexp(t, ulidRe, id.String())
+	}
+}
+
+func TestULIDEncoding(t *testing.T) {
+	// the timestamp is encoded in the first 10 characters
+	u := &ULID{
+		now:     func() time.Time { return time.Unix(0, 1469918176385*int64(time.Millisecond)) },
+		entropy: bytes.NewReader(make([]byte, 10)),
+	}
+	id, err := u.NewJobID()
+	require.NoError(t, err)
+	require.Equal(t, types.JobID("01ARYZ6S41"+"0000000000000000"), id)
+}
+
+func TestULIDMonotonic(t *testing.T) {
+	// IDs allocated within the same millisecond, or while the clock goes
+	// backwards, are still ordered.
+	now := time.Unix(1600000000, 0)
+	u := &ULID{
+		now:     func() time.Time { return now },
+		entropy: bytes.NewReader(make([]byte, 10)),
+	}
+	first, err := u.NewJobID()
+	require.NoError(t, err)
+	now = now.Add(-time.Second)
+	second, err := u.NewJobID()
+	require.NoError(t, err)
+	require.True(t, first < second, "%s >= %s", first, second)
+
+	// the random bits were all set, the next ID of the same millisecond
+	// would overflow them.
+	u = &ULID{
+		now:     func() time.Time { return now },
+		entropy: bytes.NewReader(bytes.Repeat([]byte{0xff}, 10)),
+	}
+	_, err = u.NewJobID()
+	require.NoError(t, err)
+	_, err = u.NewJobID()
+	require.Error(t, err)
+}
+
+func TestULIDSortsByTime(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	u := &ULID{now: func() time.Time { return now }, entropy: bytes.NewReader(bytes.Repeat([]byte{0xff, 0x00}, 100))}
+	var ids []string
+	for i := 0; i < 10; i++ {
+		id, err := u.NewJobID()
+		require.NoError(t, err)
+		ids = append(ids, id.String())
+		now = now.Add(time.Millisecond)
+	}
+	require.True(t, sort.StringsAreSorted(ids))
+}
+
+var uuidRe = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestUUID(t *testing.T) {
+	ids := allocate(t, NewUUID(), 16, 200)
+	for _, id := range ids {
+		require.Regexp(t, uuidRe, id.String())
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, strategy := range Strategies {
+		a, err := New(strategy, counter())
+		require.NoError(t, err, strategy)
+		_, err = a.NewJobID()
+		require.NoError(t, err, strategy)
+	}
+	_, err := New("snowflake", counter())
+	require.Error(t, err)
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		strategy string
+		valid    map[string]types.JobID
+		invalid  []string
+	}{
+		{
+			strategy: StrategySequential,
+			valid:    map[string]types.JobID{"1": "1", "42": "42", "007": "7", "18446744073709551615": "18446744073709551615"},
+			invalid:  []string{"", "0", "-1", "1.5", "abc", "1a", "18446744073709551616", "01ARZ3NDEKTSV4RRFFQ69G5FAV", "f47ac10b-58cc-4372-a567-0e02b2c3d479"},
+		},
+		{
+			strategy: StrategyULID,
+			valid:    map[string]types.JobID{"01ARZ3NDEKTSV4RRFFQ69G5FAV": "01ARZ3NDEKTSV4RRFFQ69G5FAV", "01arz3ndektsv4rrffq69g5fav": "01ARZ3NDEKTSV4RRFFQ69G5FAV"},
+			invalid:  []string{"", "1", "01ARZ3NDEKTSV4RRFFQ69G5FA", "01ARZ3NDEKTSV4RRFFQ69G5FAVX", "81ARZ3NDEKTSV4RRFFQ69G5FAV", "01ARZ3NDEKTSV4RRFFQ69G5FAU", "01ARZ3NDEKTSV4RRFFQ69G5FA-", "f47ac10b-58cc-4372-a567-0e02b2c3d479"},
+		},
+		{
+			strategy: StrategyUUID,
+			valid:    map[string]types.JobID{"f47ac10b-58cc-4372-a567-0e02b2c3d479": "f47ac10b-58cc-4372-a567-0e02b2c3d479", "F47AC10B-58CC-4372-A567-0E02B2C3D479": "f47ac10b-58cc-4372-a567-0e02b2c3d479"},
+			invalid:  []string{"", "1", "f47ac10b58cc4372a5670e02b2c3d479", "f47ac10b-58cc-4372-a567-0e02b2c3d47", "g47ac10b-58cc-4372-a567-0e02b2c3d479", "f47ac10b_58cc_4372_a567_0e02b2c3d479", "01ARZ3NDEKTSV4RRFFQ69G5FAV"},
+		},
+	} {
+		t.Run(tc.strategy, func(t *testing.T) {
+			a, err := New(tc.strategy, counter())
+			require.NoError(t, err)
+			for s, expected := range tc.valid {
+				jobID, err := a.Parse(s)
+				require.NoError(t, err, s)
+				require.Equal(t, expected, jobID, s)
+			}
+			for _, s := range tc.invalid {
+				_, err := a.Parse(s)
+				require.Error(t, err, s)
+			}
+			// the IDs the strategy allocates are parsed back as they are
+			for i := 0; i < 10; i++ {
+				id, err := a.NewJobID()
+				require.NoError(t, err)
+				parsed, err := a.Parse(id.String())
+				require.NoError(t, err)
+				require.Equal(t, id, parsed)
+			}
+		})
+	}
+}
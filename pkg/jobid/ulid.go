@@ -0,0 +1,117 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobid
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// crockford is the base32 alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID allocates job IDs as ULIDs, see https://github.com/ulid/spec: a 48-bit
+// timestamp in milliseconds followed by 80 random bits, encoded as 26
+// characters which sort in allocation order. IDs allocated within the same
+// millisecond increment the random bits of the previous one, so that they
+// stay unique and ordered.
+type ULID struct {
+	lock    sync.Mutex
+	now     func() time.Time
+	entropy io.Reader
+	lastMs  uint64
+	last    [16]byte
+}
+
+// NewULID returns a ULID allocator using the system clock and crypto/rand.
+func NewULID() *ULID {
+	return &ULID{now: time.Now, entropy: rand.Reader}
+}
+
+// NewJobID returns a new ULID as a job ID.
+func (u *ULID) NewJobID() (types.JobID, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	ms := uint64(u.now().UnixNano() / int64(time.Millisecond))
+	if ms >= 1<<48 {
+		return "", errors.New("could not allocate ULID job ID: time out of range")
+	}
+	var id [16]byte
+	if ms <= u.lastMs {
+		// the clock did not move forward, increment the random bits of the
+		// previous ID instead.
+		id = u.last
+		i := 15
+		for ; i >= 6; i-- {
+			id[i]++
+			if id[i] != 0 {
+				break
+			}
+		}
+		if i < 6 {
+			return "", errors.New("could not allocate ULID job ID: random bits overflow")
+		}
+	} else {
+		for i := 0; i < 6; i++ {
+			id[i] = byte(ms >> uint(40-8*i))
+		}
+		if _, err := io.ReadFull(u.entropy, id[6:]); err != nil {
+			return "", fmt.Errorf("could not allocate ULID job ID: %v", err)
+		}
+		u.lastMs = ms
+	}
+	u.last = id
+	return types.JobID(encodeULID(id)), nil
+}
+
+// encodeULID encodes the 128 bits of a ULID in base32, 5 bits per character
+// starting from the most significant ones, after 2 bits of padding.
+func encodeULID(id [16]byte) string {
+	var out [26]byte
+	// accumulate the bits from the least significant end
+	var acc uint32
+	bits := uint(0)
+	j := len(out) - 1
+	for i := len(id) - 1; i >= 0; i-- {
+		acc |= uint32(id[i]) << bits
+		bits += 8
+		for bits >= 5 {
+			out[j] = crockford[acc&0x1f]
+			j--
+			acc >>= 5
+			bits -= 5
+		}
+	}
+	out[0] = crockford[acc&0x1f]
+	return string(out[:])
+}
+
+// Parse only accepts ULIDs, which are case insensitive, and returns them in
+// upper case.
+func (u *ULID) Parse(s string) (types.JobID, error) {
+	if len(s) != 26 {
+		return "", fmt.Errorf("invalid job ID %q: must be a ULID of 26 characters", s)
+	}
+	s = strings.ToUpper(s)
+	// the first character holds the 3 most significant bits of the time
+	if s[0] > '7' {
+		return "", fmt.Errorf("invalid job ID %q: ULID out of range", s)
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(crockford, c) {
+			return "", fmt.Errorf("invalid job ID %q: ULIDs only contain the characters %s", s, crockford)
+		}
+	}
+	return types.JobID(s), nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// UUID allocates job IDs as random, version 4 UUIDs (RFC 4122), in their
+// canonical lowercase form.
+type UUID struct {
+	entropy io.Reader
+}
+
+// NewUUID returns a UUID allocator using crypto/rand.
+func NewUUID() *UUID {
+	return &UUID{entropy: rand.Reader}
+}
+
+// NewJobID returns a new UUID as a job ID.
+func (u *UUID) NewJobID() (types.JobID, error) {
+	var id [16]byte
+	if _, err := io.ReadFull(u.entropy, id[:]); err != nil {
+		return "", fmt.Errorf("could not allocate UUID job ID: %v", err)
+	}
+	// version 4, variant 10
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	var out [36]byte
+	hex.Encode(out[0:8], id[0:4])
+	out[8] = '-'
+	hex.Encode(out[9:13], id[4:6])
+	out[13] = '-'
+	hex.Encode(out[14:18], id[6:8])
+	out[18] = '-'
+	hex.Encode(out[19:23], id[8:10])
+	out[23] = '-'
+	hex.Encode(out[24:], id[10:])
+	return types.JobID(out[:]), nil
+}
+
+// Parse only accepts UUIDs in their canonical form, with dashes, and returns
+// them in lower case.
+func (u *UUID) Parse(s string) (types.JobID, error) {
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return "", fmt.Errorf("invalid job ID %q: must be a UUID, as xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", s)
+	}
+	s = strings.ToLower(s)
+	if _, err := hex.DecodeString(strings.Replace(s, "-", "", -1)); err != nil {
+		return "", fmt.Errorf("invalid job ID %q: must be a UUID, as xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", s)
+	}
+	return types.JobID(s), nil
+}
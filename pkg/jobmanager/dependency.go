@@ -0,0 +1,111 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+const defaultDependencyPollInterval = 10 * time.Second
+
+// dependencyFailureEvents are the completion events of a dependency which
+// abort the jobs depending on it. Cancelled dependencies count as failed.
+var dependencyFailureEvents = map[event.Name]bool{
+	EventJobFailed:             true,
+	EventJobCancelled:          true,
+	EventJobCancellationFailed: true,
+}
+
+// OptionDependencyPollInterval sets the interval at which the state of the
+// dependencies of the running jobs is checked. The dependencies are polled
+// from the storage, so that they may be run by other JobManager instances.
+func OptionDependencyPollInterval(d time.Duration) Option {
+	return func(jm *JobManager) {
+		jm.dependencyPollInterval = d
+	}
+}
+
+// completionEvent returns the event which ended a job, or an empty name if
+// the job has not ended.
+func (jm *JobManager) completionEvent(jobID types.JobID) (event.Name, error) {
+	events, err := jm.frameworkEvManager.Fetch(
+		frameworkevent.QueryJobID(jobID),
+		frameworkevent.QueryEventNames(JobCompletionEvents),
+	)
+	if err != nil {
+		return "", err
+	}
+	if len(events) == 0 {
+		return "", nil
+	}
+	return events[len(events)-1].EventName, nil
+}
+
+// checkDependencies verifies that the dependencies of a job being submitted
+// exist, and did not fail.
+func (jm *JobManager) checkDependencies(dependsOn []types.JobID) error {
+	for _, dep := range dependsOn {
+		if _, err := jm.fetchJobRequest(dep); err != nil {
+			return fmt.Errorf("dependency job %s does not exist: %v", dep, err)
+		}
+		name, err := jm.completionEvent(dep)
+		if err != nil {
+			return fmt.Errorf("could not get state of dependency job %s: %v", dep, err)
+		}
+		if dependencyFailureEvents[name] {
+			return fmt.Errorf("dependency job %s ended with %s", dep, name)
+		}
+	}
+	return nil
+}
+
+// watchDependencies polls the state of the dependencies of a running job,
+// and cancels the job as soon as one of them fails. It returns when the job
+// is done, or when all the dependencies completed successfully.
+func (jm *JobManager) watchDependencies(j *job.Job) {
+	pending := append([]types.JobID(nil), j.DependsOn...)
+	ticker := time.NewTicker(jm.dependencyPollInterval)
+	defer ticker.Stop()
+	for len(pending) > 0 {
+		select {
+		case <-j.Done:
+			return
+		case <-ticker.C:
+		}
+		var stillPending []types.JobID
+		for _, dep := range pending {
+			name, err := jm.completionEvent(dep)
+			if err != nil {
+				log.Warningf("Could not get state of dependency job %s of job %s: %v", dep, j.ID, err)
+				stillPending = append(stillPending, dep)
+				continue
+			}
+			switch {
+			case name == "":
+				stillPending = append(stillPending, dep)
+			case dependencyFailureEvents[name]:
+				jm.abortByDependency(j, fmt.Errorf("dependency job %s ended with %s", dep, name))
+				return
+			}
+		}
+		pending = stillPending
+	}
+}
+
+func (jm *JobManager) abortByDependency(j *job.Job, err error) {
+	log.Warningf("Aborting job %s: %v", j.ID, err)
+	_ = jm.emitErrEvent(j.ID, EventJobAbortedByDependency, err)
+	if err := jm.CancelJob(j.ID); err != nil {
+		// the job was already stopped
+		log.Warningf("Could not cancel job %s: %v", j.ID, err)
+	}
+}
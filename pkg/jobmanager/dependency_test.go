@@ -0,0 +1,98 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/facebookincubator/contest/plugins/targetlocker/inmemory"
+	"github.com/stretchr/testify/require"
+)
+
+// dependentJobDescriptor returns the descriptor of a job which depends on the
+// given job, and runs long enough to be aborted.
+func dependentJobDescriptor(dependsOn types.JobID) string {
+	descriptor := fmt.Sprintf(resumeJobDescriptor, "slowecho", "9")
+	// the breakpoint holds the job until it is cancelled
+	return strings.Replace(descriptor, `"JobName": "resume",`, fmt.Sprintf(`"JobName": "dependent", "depends_on": [%q],`, dependsOn), 1)
+}
+
+// newUpstream stores the request of an upstream job, which is not run: its
+// state is set by emitting the job state events.
+func newUpstream(t *testing.T, jm *JobManager) types.JobID {
+	jobID, err := jm.jobRequestManager.Emit(&job.Request{JobName: "upstream"})
+	require.NoError(t, err)
+	require.NoError(t, jm.emitEvent(jobID, EventJobStarted))
+	return jobID
+}
+
+func TestDependencyUnknown(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+	resp := jm.startJob("test", dependentJobDescriptor("42"))
+	require.Error(t, resp.Err)
+	require.Contains(t, resp.Err.Error(), "dependency job 42 does not exist")
+}
+
+func TestDependencyFailedBeforeSubmission(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+	upstream := newUpstream(t, jm)
+	require.NoError(t, jm.emitErrEvent(upstream, EventJobFailed, errors.New("upstream failed")))
+
+	resp := jm.startJob("test", dependentJobDescriptor(upstream))
+	require.Error(t, resp.Err)
+	require.Contains(t, resp.Err.Error(), fmt.Sprintf("dependency job %s ended with %s", upstream, EventJobFailed))
+}
+
+func TestDependencyFailsWhileRunning(t *testing.T) {
+	storage.SetStorage(memory.New())
+	target.SetLocker(inmemory.New(time.Minute))
+	jm, err := New(nil, newResumeRegistry(t), OptionDependencyPollInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	upstream := newUpstream(t, jm)
+
+	resp := jm.startJob("test", dependentJobDescriptor(upstream))
+	require.NoError(t, resp.Err)
+	downstream := resp.JobID
+
+	jobEvents := func() []event.Name {
+		events, err := jm.frameworkEvManager.Fetch(
+			frameworkevent.QueryJobID(downstream),
+			frameworkevent.QueryEventNames(JobStateEvents),
+		)
+		require.NoError(t, err)
+		var names []event.Name
+		for _, ev := range events {
+			names = append(names, ev.EventName)
+		}
+		return names
+	}
+	// the downstream job keeps running as long as the upstream one does
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, []event.Name{EventJobStarted}, jobEvents())
+
+	require.NoError(t, jm.emitErrEvent(upstream, EventJobFailed, errors.New("upstream failed")))
+	require.Eventually(t, func() bool {
+		names := jobEvents()
+		return len(names) > 0 && names[len(names)-1] == EventJobCancelled
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Equal(t, []event.Name{EventJobStarted, EventJobAbortedByDependency, EventJobCancelled}, jobEvents())
+	jm.jobsWg.Wait()
+}
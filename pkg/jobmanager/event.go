@@ -6,42 +6,24 @@
 package jobmanager
 
 import (
-	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/job"
 )
 
-// EventJobStarted indicates that a Job is beginning execution
-var EventJobStarted = event.Name("JobStateStarted")
-
-// EventJobCompleted indicates that a Job has completed
-var EventJobCompleted = event.Name("JobStateCompleted")
-
-// EventJobFailed indicates that a Job has failed
-var EventJobFailed = event.Name("JobStateFailed")
-
-// EventJobCancelling indicates that a Job has received a cancellation request
-// and the JobManager is waiting for JobRunner to return
-var EventJobCancelling = event.Name("JobStateCancelling")
-
-// EventJobCancelled indicates that a Job has been cancelled
-var EventJobCancelled = event.Name("JobStateCancelled")
-
-// EventJobCancellationFailed indicates that the cancellation was not completed correctly
-var EventJobCancellationFailed = event.Name("JobStateCancelled")
+// The job state events are defined in the job package, so that the storage
+// layer can tell the state of the jobs.
+var (
+	EventJobStarted             = job.EventJobStarted
+	EventJobPaused              = job.EventJobPaused
+	EventJobCompleted           = job.EventJobCompleted
+	EventJobFailed              = job.EventJobFailed
+	EventJobCancelling          = job.EventJobCancelling
+	EventJobCancelled           = job.EventJobCancelled
+	EventJobCancellationFailed  = job.EventJobCancellationFailed
+	EventJobAbortedByDependency = job.EventJobAbortedByDependency
+)
 
 // JobCompletionEvents gather all event that mark the end of a job
-var JobCompletionEvents = []event.Name{
-	EventJobCompleted,
-	EventJobFailed,
-	EventJobCancelled,
-	EventJobCancellationFailed,
-}
+var JobCompletionEvents = job.CompletionEvents
 
 // JobStateEvents gather all event names which track the state of a job
-var JobStateEvents = []event.Name{
-	EventJobStarted,
-	EventJobCompleted,
-	EventJobFailed,
-	EventJobCancelling,
-	EventJobCancelled,
-	EventJobCancellationFailed,
-}
+var JobStateEvents = job.StateEvents
@@ -0,0 +1,25 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/storage"
+)
+
+// testEvents returns a page of the test events of a job.
+func (jm *JobManager) testEvents(ev *api.Event) *api.EventResponse {
+	msg := ev.Msg.(api.EventTestEventsMsg)
+	resp := &api.EventResponse{JobID: msg.JobID, Requestor: ev.Msg.Requestor()}
+	if _, err := jm.fetchJobRequest(msg.JobID); err != nil {
+		resp.Err = err
+		return resp
+	}
+	resp.TestEvents, resp.Err = storage.GetTestEventsPage(&testevent.Query{Query: event.Query{JobID: msg.JobID}}, msg.AfterSequence, msg.Limit)
+	return resp
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// serveAPI handles the API events with the job manager until the API events
+// channel is closed.
+func serveAPI(jm *JobManager, a *api.API) {
+	go func() {
+		for ev := range a.Events {
+			jm.handleEvent(ev)
+		}
+	}()
+}
+
+func emitTestEvents(t *testing.T, jobID types.JobID, from, to int) {
+	ev := storage.NewTestEventEmitter(testevent.Header{JobID: jobID, TestName: "test", TestStepLabel: "step"})
+	for i := from; i < to; i++ {
+		require.NoError(t, ev.Emit(testevent.Data{EventName: event.Name(fmt.Sprintf("Event%d", i))}))
+	}
+}
+
+func TestTestEventsPagination(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+	a := api.New()
+	defer close(a.Events)
+	serveAPI(jm, a)
+
+	jobID, err := jm.jobRequestManager.Emit(&job.Request{JobName: "paged"})
+	require.NoError(t, err)
+	otherJobID, err := jm.jobRequestManager.Emit(&job.Request{JobName: "other"})
+	require.NoError(t, err)
+	emitTestEvents(t, jobID, 0, 5)
+	emitTestEvents(t, otherJobID, 0, 3)
+
+	var (
+		names  []event.Name
+		cursor string
+		pages  int
+	)
+	for {
+		resp, err := a.TestEvents("test", jobID, 2, cursor)
+		require.NoError(t, err)
+		require.NoError(t, resp.Err)
+		data := resp.Data.(api.ResponseDataTestEvents)
+		require.True(t, len(data.Events) <= 2)
+		for _, ev := range data.Events {
+			require.Equal(t, jobID, ev.Header.JobID)
+			names = append(names, ev.Data.EventName)
+		}
+		cursor = data.Cursor
+		pages++
+		// events emitted while paging are returned in the later pages
+		if pages == 1 {
+			emitTestEvents(t, jobID, 5, 8)
+			emitTestEvents(t, otherJobID, 3, 6)
+		}
+		if !data.More {
+			break
+		}
+	}
+	require.Equal(t, 4, pages)
+	var expected []event.Name
+	for i := 0; i < 8; i++ {
+		expected = append(expected, event.Name(fmt.Sprintf("Event%d", i)))
+	}
+	require.Equal(t, expected, names)
+
+	// the last cursor returns the events emitted afterwards
+	resp, err := a.TestEvents("test", jobID, 0, cursor)
+	require.NoError(t, err)
+	require.Empty(t, resp.Data.(api.ResponseDataTestEvents).Events)
+	emitTestEvents(t, jobID, 8, 9)
+	resp, err = a.TestEvents("test", jobID, 0, cursor)
+	require.NoError(t, err)
+	data := resp.Data.(api.ResponseDataTestEvents)
+	require.Len(t, data.Events, 1)
+	require.Equal(t, event.Name("Event8"), data.Events[0].Data.EventName)
+	require.False(t, data.More)
+}
+
+func TestTestEventsInvalidRequests(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+	a := api.New()
+	defer close(a.Events)
+	serveAPI(jm, a)
+
+	jobID, err := jm.jobRequestManager.Emit(&job.Request{JobName: "paged"})
+	require.NoError(t, err)
+	resp, err := a.TestEvents("test", jobID, 1, "")
+	require.NoError(t, err)
+	cursor := resp.Data.(api.ResponseDataTestEvents).Cursor
+
+	_, err = a.TestEvents("test", jobID, -1, "")
+	require.Error(t, err)
+	_, err = a.TestEvents("test", jobID, api.MaxTestEventsLimit+1, "")
+	require.Error(t, err)
+	_, err = a.TestEvents("test", jobID, 1, "not a cursor")
+	require.Error(t, err)
+	// cursors are bound to their job
+	unknownJobID := types.JobID("42")
+	_, err = a.TestEvents("test", unknownJobID, 1, cursor)
+	require.Error(t, err)
+	resp, err = a.TestEvents("test", unknownJobID, 1, "")
+	require.NoError(t, err)
+	require.Error(t, resp.Err)
+}
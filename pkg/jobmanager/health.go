@@ -0,0 +1,29 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/health"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+)
+
+func (jm *JobManager) quarantinedTargets(ev *api.Event) *api.EventResponse {
+	targets, err := storage.GetQuarantinedTargets()
+	return &api.EventResponse{Requestor: ev.Msg.Requestor(), TargetHealth: targets, Err: err}
+}
+
+// releaseTarget takes a target out of quarantine. The server does not need a
+// health tracker, so that targets can be released after it is disabled.
+func (jm *JobManager) releaseTarget(ev *api.Event) *api.EventResponse {
+	msg := ev.Msg.(api.EventReleaseTargetMsg)
+	h, err := health.Release(msg.TargetID)
+	if err != nil {
+		return &api.EventResponse{Requestor: ev.Msg.Requestor(), Err: err}
+	}
+	return &api.EventResponse{Requestor: ev.Msg.Requestor(), TargetHealth: []*target.Health{h}}
+}
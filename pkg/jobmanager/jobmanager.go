@@ -6,6 +6,7 @@
 package jobmanager
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,9 +20,11 @@ import (
 	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
 	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/jobid"
 	"github.com/facebookincubator/contest/pkg/logging"
 	"github.com/facebookincubator/contest/pkg/pluginregistry"
 	"github.com/facebookincubator/contest/pkg/runner"
+	"github.com/facebookincubator/contest/pkg/secrets"
 	"github.com/facebookincubator/contest/pkg/storage"
 	"github.com/facebookincubator/contest/pkg/test"
 	"github.com/facebookincubator/contest/pkg/types"
@@ -31,6 +34,11 @@ var log = logging.GetLogger("pkg/jobmanager")
 
 var cancellationTimeout = 60 * time.Second
 
+// defaultStorageTimeout bounds the job request fetches, below the timeout of
+// the API events, so that a hung storage engine cannot block the handling of
+// the API requests.
+const defaultStorageTimeout = 2 * time.Second
+
 // errorPayload represents the payload carried by a failure event (e.g. JobStateFailed, JobStateCancelled, etc.)
 type errorPayload struct {
 	Err string
@@ -60,6 +68,64 @@ type JobManager struct {
 	apiListener    api.Listener
 	apiCancel      chan struct{}
 	pluginRegistry *pluginregistry.PluginRegistry
+
+	// uniqueExternalIDs, if true, causes jobs to be rejected if their external
+	// ID is already used by another job.
+	uniqueExternalIDs bool
+	// stateObservers are notified of the job state transitions.
+	stateObservers []JobStateObserver
+	// rateLimiter, if not nil, limits the job submissions of each requestor.
+	rateLimiter *rateLimiter
+	// dependencyPollInterval is the interval at which the state of the
+	// dependencies of the running jobs is checked.
+	dependencyPollInterval time.Duration
+	// jobIDAllocator allocates the IDs of the new jobs.
+	jobIDAllocator jobid.Allocator
+	// storageTimeout is the timeout of the job request fetches.
+	storageTimeout time.Duration
+	// schedulePollInterval is the interval at which the job schedules are
+	// checked for due jobs.
+	schedulePollInterval time.Duration
+	// pruner, if not nil, applies the retention policy every
+	// retentionInterval. pruning is set while it runs.
+	pruner            *storage.Pruner
+	retentionInterval time.Duration
+	pruning           int32
+
+	// maxConcurrentJobs, if not zero, is the number of jobs which run at once.
+	// The jobs submitted beyond it wait in queue.
+	maxConcurrentJobs uint
+	// queueMu protects runningJobs and queue.
+	queueMu     sync.Mutex
+	runningJobs uint
+	queue       jobQueue
+}
+
+// Option is used to configure optional behaviours of the JobManager.
+type Option func(*JobManager)
+
+// OptionUniqueExternalIDs requires job external IDs to be unique. Jobs using
+// an external ID which was already submitted are rejected.
+func OptionUniqueExternalIDs(unique bool) Option {
+	return func(jm *JobManager) {
+		jm.uniqueExternalIDs = unique
+	}
+}
+
+// OptionJobIDAllocator sets the strategy allocating the IDs of the new jobs.
+// Job IDs are sequential numbers by default.
+func OptionJobIDAllocator(a jobid.Allocator) Option {
+	return func(jm *JobManager) {
+		jm.jobIDAllocator = a
+	}
+}
+
+// OptionStorageTimeout sets the timeout of the job request fetches from the
+// storage layer.
+func OptionStorageTimeout(timeout time.Duration) Option {
+	return func(jm *JobManager) {
+		jm.storageTimeout = timeout
+	}
 }
 
 // NewJob creates a new Job object
@@ -82,6 +148,21 @@ func NewJob(pr *pluginregistry.PluginRegistry, jobDescriptor string) (*job.Job,
 	if jd.RunInterval < 0 {
 		return nil, errors.New("run interval must be non-negative")
 	}
+	if jd.NetworkTimeouts != nil {
+		if err := jd.NetworkTimeouts.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if jd.MinPassPercent != nil && (*jd.MinPassPercent < 0 || *jd.MinPassPercent > 100) {
+		return nil, fmt.Errorf("min_pass_percent must be between 0 and 100, got %v", *jd.MinPassPercent)
+	}
+	if err := secrets.ValidateDeclared(jd.Secrets); err != nil {
+		return nil, err
+	}
+	paramValues, err := jd.ParameterValues()
+	if err != nil {
+		return nil, err
+	}
 
 	if len(jd.Reporting.RunReporters) == 0 && len(jd.Reporting.FinalReporters) == 0 {
 		return nil, errors.New("at least one run reporter or one final reporter must be specified in a job")
@@ -117,6 +198,10 @@ func NewJob(pr *pluginregistry.PluginRegistry, jobDescriptor string) (*job.Job,
 
 	tests := make([]*test.Test, 0, len(jd.TestDescriptors))
 	for _, td := range jd.TestDescriptors {
+		td, err := expandTestDescriptor(td, paramValues)
+		if err != nil {
+			return nil, err
+		}
 		if td.TargetManagerName == "" {
 			return nil, errors.New("target manager name cannot be empty")
 		}
@@ -145,9 +230,19 @@ func NewJob(pr *pluginregistry.PluginRegistry, jobDescriptor string) (*job.Job,
 			if err != nil {
 				return nil, err
 			}
+			// the job-wide network timeouts are resolved into the parameters
+			// of each step, so that the steps validate them like any other.
+			stepDesc := *testStepDesc
+			if stepDesc.Parameters, err = expandStepParameters(stepDesc.Parameters, paramValues); err != nil {
+				return nil, fmt.Errorf("invalid parameters for test step '%s': %v", testStepDesc.Label, err)
+			}
+			stepDesc.Parameters = jd.NetworkTimeouts.Apply(stepDesc.Parameters)
+			if err := checkSecretReferences(jd.Secrets, stepDesc.Parameters); err != nil {
+				return nil, fmt.Errorf("invalid parameters for test step '%s': %v", testStepDesc.Label, err)
+			}
 			// test step index is incremented by 1 so we can use 0 to signal an
 			// anomaly.
-			tsb, err := pr.NewTestStepBundle(*testStepDesc, uint(idx)+1, tse)
+			tsb, err := pr.NewTestStepBundle(stepDesc, uint(idx)+1, tse)
 			if err != nil {
 				return nil, fmt.Errorf("NewTestStepBundle for test step '%s' with index %d failed: %w", testStepDesc.Name, idx, err)
 			}
@@ -163,6 +258,9 @@ func NewJob(pr *pluginregistry.PluginRegistry, jobDescriptor string) (*job.Job,
 			}
 			stepBundles = append(stepBundles, *tsb)
 		}
+		if err := test.ValidateFlow(stepBundles, jd.ShuffleSteps); err != nil {
+			return nil, fmt.Errorf("invalid test %s: %v", name, err)
+		}
 		test := test.Test{
 			Name:                name,
 			TargetManagerBundle: tmb,
@@ -172,17 +270,31 @@ func NewJob(pr *pluginregistry.PluginRegistry, jobDescriptor string) (*job.Job,
 		tests = append(tests, &test)
 	}
 
+	shuffleSeed := jd.ShuffleSeed
+	if jd.ShuffleSteps && shuffleSeed == 0 {
+		shuffleSeed = time.Now().UnixNano()
+	}
+
 	// Create a Job object from the above managers and parameters. The Job ID assigned
-	// is 0, and gets actually set by the JobManager after calling the persistence layer
+	// is empty, and gets actually set by the JobManager after calling the persistence layer
 	job := job.Job{
-		ID:                   types.JobID(0),
+		ID:                   types.JobID(""),
 		Name:                 jd.JobName,
 		Tags:                 jd.Tags,
+		ExternalID:           jd.ExternalID,
 		Runs:                 jd.Runs,
 		RunInterval:          time.Duration(jd.RunInterval),
+		ShuffleSteps:         jd.ShuffleSteps,
+		ShuffleSeed:          shuffleSeed,
+		DryRun:               jd.DryRun,
+		MinPassPercent:       jd.MinPassPercent,
+		DependsOn:            jd.DependsOn,
+		Priority:             jd.Priority,
+		Secrets:              jd.Secrets,
 		Tests:                tests,
 		RunReporterBundles:   runReporterBundles,
 		FinalReporterBundles: finalReporterBundles,
+		Breakpoints:          job.NewBreakpoints(),
 	}
 
 	job.Done = make(chan struct{})
@@ -193,8 +305,69 @@ func NewJob(pr *pluginregistry.PluginRegistry, jobDescriptor string) (*job.Job,
 	return &job, nil
 }
 
+// expandTestDescriptor returns a copy of the test descriptor, with the values
+// of the job parameters substituted in the parameters of its target manager
+// and test fetcher.
+func expandTestDescriptor(td *test.TestDescriptor, values map[string]string) (*test.TestDescriptor, error) {
+	expanded := *td
+	for _, field := range []struct {
+		name  string
+		value *json.RawMessage
+	}{
+		{"TargetManagerAcquireParameters", &expanded.TargetManagerAcquireParameters},
+		{"TargetManagerReleaseParameters", &expanded.TargetManagerReleaseParameters},
+		{"TestFetcherFetchParameters", &expanded.TestFetcherFetchParameters},
+	} {
+		if len(*field.value) == 0 {
+			continue
+		}
+		v, err := job.ExpandParameters(string(*field.value), values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", field.name, err)
+		}
+		*field.value = json.RawMessage(v)
+	}
+	return &expanded, nil
+}
+
+// expandStepParameters substitutes the values of the job parameters in the
+// parameters of a test step. The expressions expanded per target are left to
+// the step.
+func expandStepParameters(params test.TestStepParameters, values map[string]string) (test.TestStepParameters, error) {
+	if params == nil {
+		return nil, nil
+	}
+	expanded := make(test.TestStepParameters, len(params))
+	for name, ps := range params {
+		expanded[name] = make([]test.Param, 0, len(ps))
+		for _, p := range ps {
+			v, err := job.ExpandParameters(p.Raw(), values)
+			if err != nil {
+				return nil, fmt.Errorf("parameter '%s': %v", name, err)
+			}
+			expanded[name] = append(expanded[name], *test.NewParam(v))
+		}
+	}
+	return expanded, nil
+}
+
+// checkSecretReferences checks that the step parameters only refer to the job
+// secrets which are declared.
+func checkSecretReferences(declared map[string]string, params test.TestStepParameters) error {
+	for name, values := range params {
+		for _, p := range values {
+			for _, ref := range secrets.JobReferences(p.Raw()) {
+				if _, ok := declared[ref]; !ok {
+					return fmt.Errorf("parameter '%s' refers to undeclared job secret %q", name, ref)
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // New initializes and returns a new JobManager with the given API listener.
-func New(l api.Listener, pr *pluginregistry.PluginRegistry) (*JobManager, error) {
+func New(l api.Listener, pr *pluginregistry.PluginRegistry, opts ...Option) (*JobManager, error) {
 	if pr == nil {
 		return nil, errors.New("plugin registry cannot be nil")
 	}
@@ -213,11 +386,28 @@ func New(l api.Listener, pr *pluginregistry.PluginRegistry) (*JobManager, error)
 		frameworkEvManager: frameworkEvManager,
 		testEvManager:      testEvManager,
 		apiCancel:          make(chan struct{}),
+
+		dependencyPollInterval: defaultDependencyPollInterval,
+		storageTimeout:         defaultStorageTimeout,
+		schedulePollInterval:   defaultSchedulePollInterval,
+		jobIDAllocator:         jobid.NewSequential(storage.NextJobSequence),
+	}
+	for _, opt := range opts {
+		opt(&jm)
 	}
 	jm.jobRunner = runner.NewJobRunner()
 	return &jm, nil
 }
 
+// fetchJobRequest fetches a job request, giving up after the storage
+// timeout. Timeouts can be told from the other errors with
+// errors.Is(err, context.DeadlineExceeded).
+func (jm *JobManager) fetchJobRequest(jobID types.JobID) (*job.Request, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jm.storageTimeout)
+	defer cancel()
+	return jm.jobRequestManager.FetchWithContext(ctx, jobID)
+}
+
 func (jm *JobManager) handleEvent(ev *api.Event) {
 	var resp *api.EventResponse
 
@@ -230,6 +420,32 @@ func (jm *JobManager) handleEvent(ev *api.Event) {
 		resp = jm.stop(ev)
 	case api.EventTypeRetry:
 		resp = jm.retry(ev)
+	case api.EventTypeResume:
+		resp = jm.resume(ev)
+	case api.EventTypeReplayFailed:
+		resp = jm.replayFailed(ev)
+	case api.EventTypeStoreTemplate:
+		resp = jm.storeTemplate(ev)
+	case api.EventTypeStartTemplate:
+		resp = jm.startTemplate(ev)
+	case api.EventTypeTestEvents:
+		resp = jm.testEvents(ev)
+	case api.EventTypeVerdict:
+		resp = jm.verdict(ev)
+	case api.EventTypeCreateSchedule:
+		resp = jm.createSchedule(ev)
+	case api.EventTypeListSchedules:
+		resp = jm.listSchedules(ev)
+	case api.EventTypeGetSchedule:
+		resp = jm.getSchedule(ev)
+	case api.EventTypeSetScheduleState:
+		resp = jm.setScheduleState(ev)
+	case api.EventTypeListPlugins:
+		resp = &api.EventResponse{Requestor: ev.Msg.Requestor(), Plugins: jm.pluginRegistry.Plugins()}
+	case api.EventTypeListQuarantinedTargets:
+		resp = jm.quarantinedTargets(ev)
+	case api.EventTypeReleaseTarget:
+		resp = jm.releaseTarget(ev)
 	default:
 		resp = &api.EventResponse{
 			Requestor: ev.Msg.Requestor(),
@@ -256,6 +472,7 @@ func (jm *JobManager) handleEvent(ev *api.Event) {
 // signals, propagating the signals downwards to all jobs.
 func (jm *JobManager) Start(sigs chan os.Signal) error {
 	a := api.New()
+	a.SetJobIDParser(jm.jobIDAllocator.Parse)
 	errCh := make(chan error, 1)
 	go func() {
 		if lErr := jm.apiListener.Serve(jm.apiCancel, a); lErr != nil {
@@ -263,6 +480,22 @@ func (jm *JobManager) Start(sigs chan os.Signal) error {
 		}
 		errCh <- nil
 	}()
+	if err := jm.resumePausedJobs(); err != nil {
+		log.Warningf("Could not resume the paused jobs: %v", err)
+	}
+	if err := jm.resumeQueuedJobs(); err != nil {
+		log.Warningf("Could not resume the queued jobs: %v", err)
+	}
+	// the scheduled jobs are submitted from this loop, like the jobs
+	// requested through the API
+	schedules := time.NewTicker(jm.schedulePollInterval)
+	defer schedules.Stop()
+	var retention <-chan time.Time
+	if jm.pruner != nil {
+		ticker := time.NewTicker(jm.retentionInterval)
+		defer ticker.Stop()
+		retention = ticker.C
+	}
 loop:
 	for {
 		select {
@@ -271,6 +504,10 @@ loop:
 			log.Printf("Handling event %+v", ev)
 			// send the response, and wait for the given timeout
 			jm.handleEvent(ev)
+		case now := <-schedules.C:
+			jm.runDueSchedules(now)
+		case now := <-retention:
+			jm.pruneJobs(now)
 		// check for errors or premature termination from the listener.
 		case err := <-errCh:
 			log.Info("JobManager: API listener failed, triggering a cancellation of all jobs")
@@ -310,7 +547,7 @@ func (jm *JobManager) CancelJob(jobID types.JobID) error {
 	job, ok := jm.jobs[jobID]
 	if !ok {
 		jm.jobsMu.Unlock()
-		return fmt.Errorf("unknown job ID: %d", jobID)
+		return fmt.Errorf("unknown job ID: %s", jobID)
 	}
 	delete(jm.jobs, jobID)
 	jm.jobsMu.Unlock()
@@ -366,9 +603,12 @@ func (jm *JobManager) emitErrEvent(jobID types.JobID, eventName event.Name, err
 		EmitTime:  time.Now(),
 	}
 	if err := jm.frameworkEvManager.Emit(ev); err != nil {
-		log.Warningf("Could not emit event %s for job %d: %v", eventName, jobID, err)
+		log.Warningf("Could not emit event %s for job %s: %v", eventName, jobID, err)
 		return err
 	}
+	if state, ok := eventStates[eventName]; ok {
+		jm.notifyJobState(jobID, state, err)
+	}
 	return nil
 }
 
@@ -0,0 +1,74 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// hangingStorage is a storage engine whose job request fetches hang until
+// release is closed.
+type hangingStorage struct {
+	storage.Storage
+	release chan struct{}
+}
+
+func (s *hangingStorage) GetJobRequest(jobID types.JobID) (*job.Request, error) {
+	<-s.release
+	return s.Storage.GetJobRequest(jobID)
+}
+
+func TestFetchJobRequestTimeout(t *testing.T) {
+	hanging := &hangingStorage{Storage: memory.New(), release: make(chan struct{})}
+	defer close(hanging.release)
+	storage.SetStorage(hanging)
+	jm, err := New(nil, newResumeRegistry(t), OptionStorageTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	a := api.New()
+	defer close(a.Events)
+	serveAPI(jm, a)
+
+	jobID, err := jm.jobRequestManager.Emit(&job.Request{JobName: "hanging"})
+	require.NoError(t, err)
+	start := time.Now()
+	resp, err := a.Verdict("test", jobID)
+	require.NoError(t, err)
+	require.Error(t, resp.Err)
+	require.True(t, errors.Is(resp.Err, context.DeadlineExceeded))
+	var interrupted *storage.ErrFetchInterrupted
+	require.True(t, errors.As(resp.Err, &interrupted))
+	require.Equal(t, jobID, interrupted.JobID)
+	require.True(t, time.Since(start) < api.DefaultEventTimeout)
+}
+
+func TestFetchJobRequestNotFound(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+
+	_, err = jm.fetchJobRequest("42")
+	require.Error(t, err)
+	require.False(t, errors.Is(err, context.DeadlineExceeded))
+	var notFound *storage.ErrJobRequestNotFound
+	require.True(t, errors.As(err, &notFound))
+	require.Equal(t, types.JobID("42"), notFound.JobID)
+
+	// a context which is already done does not reach the storage layer
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = jm.jobRequestManager.FetchWithContext(ctx, "42")
+	require.True(t, errors.Is(err, context.Canceled))
+}
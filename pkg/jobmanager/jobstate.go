@@ -0,0 +1,57 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// JobState is the state of a job, as notified to the JobStateObservers.
+type JobState string
+
+// list of the job states.
+const (
+	JobStateQueued     JobState = "queued"
+	JobStateRunning    JobState = "running"
+	JobStatePaused     JobState = "paused"
+	JobStateCancelling JobState = "cancelling"
+	JobStateCancelled  JobState = "cancelled"
+	JobStateCompleted  JobState = "completed"
+	JobStateFailed     JobState = "failed"
+)
+
+// eventStates maps the job state events to the states they enter.
+var eventStates = map[event.Name]JobState{
+	EventJobStarted:    JobStateRunning,
+	EventJobPaused:     JobStatePaused,
+	EventJobCancelling: JobStateCancelling,
+	EventJobCancelled:  JobStateCancelled,
+	EventJobCompleted:  JobStateCompleted,
+	EventJobFailed:     JobStateFailed,
+}
+
+// JobStateObserver is notified of the state transitions of the jobs, whether
+// or not they have reporters. err is set for failed jobs. Observers are called
+// synchronously by the JobManager, hence must not block.
+type JobStateObserver interface {
+	JobStateChanged(jobID types.JobID, state JobState, err error)
+}
+
+// OptionJobStateObserver adds an observer of the job state transitions.
+func OptionJobStateObserver(o JobStateObserver) Option {
+	return func(jm *JobManager) {
+		jm.stateObservers = append(jm.stateObservers, o)
+	}
+}
+
+func (jm *JobManager) notifyJobState(jobID types.JobID, state JobState, err error) {
+	metrics.JobState(string(state))
+	for _, o := range jm.stateObservers {
+		o.JobStateChanged(jobID, state, err)
+	}
+}
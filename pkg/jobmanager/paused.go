@@ -0,0 +1,69 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"fmt"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+)
+
+// resumePausedJobs resumes the jobs which were paused when the server last
+// shut down, from the progress recorded when they were paused. The jobs which
+// cannot be resumed fail.
+func (jm *JobManager) resumePausedJobs() error {
+	// the paused jobs are listed before resuming any, as resuming them
+	// changes their state
+	var requests []*job.Request
+	for {
+		page, err := storage.GetJobRequests(job.JobQuery{States: []event.Name{EventJobPaused}, Offset: uint(len(requests))})
+		if err != nil {
+			return fmt.Errorf("could not list paused jobs: %v", err)
+		}
+		requests = append(requests, page...)
+		if len(page) < job.DefaultJobQueryLimit {
+			break
+		}
+	}
+	for _, request := range requests {
+		if err := jm.resumePausedJob(request); err != nil {
+			_ = jm.emitErrEvent(request.JobID, EventJobFailed, fmt.Errorf("could not resume paused job: %v", err))
+		}
+	}
+	return nil
+}
+
+// resumePausedJob rebuilds a paused job from its request, and runs it from
+// its snapshot.
+func (jm *JobManager) resumePausedJob(request *job.Request) error {
+	snapshot, err := storage.GetStepState(request.JobID)
+	if err != nil {
+		return err
+	}
+	j, err := NewJob(jm.pluginRegistry, request.JobDescriptor)
+	if err != nil {
+		return err
+	}
+	if snapshot.TestIndex < 0 || snapshot.TestIndex >= len(j.Tests) {
+		return fmt.Errorf("the job was paused at test #%d, but it has %d tests", snapshot.TestIndex, len(j.Tests))
+	}
+	j.ID = request.JobID
+	if j.ShuffleSteps {
+		j.ShuffleSeed = snapshot.ShuffleSeed
+	}
+	j.ResumeFrom = snapshot
+	log.Infof("Resuming paused job %s from test #%d of run #%d", j.ID, snapshot.TestIndex, snapshot.RunID)
+	if err := jm.emitEvent(j.ID, EventJobStarted); err != nil {
+		return err
+	}
+	// the job was running when the server shut down, so it does not wait for
+	// the queued ones
+	jm.takeSlot()
+	jm.runJob(j)
+	return nil
+}
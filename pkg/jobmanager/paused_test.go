@@ -0,0 +1,105 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/facebookincubator/contest/plugins/targetlocker/inmemory"
+	"github.com/facebookincubator/contest/plugins/teststeps/slowecho"
+	"github.com/stretchr/testify/require"
+)
+
+func jobStates(t *testing.T, jm *JobManager, jobID types.JobID) []event.Name {
+	events, err := jm.frameworkEvManager.Fetch(
+		frameworkevent.QueryJobID(jobID),
+		frameworkevent.QueryEventNames(JobStateEvents),
+	)
+	require.NoError(t, err)
+	var names []event.Name
+	for _, ev := range events {
+		names = append(names, ev.EventName)
+	}
+	return names
+}
+
+func countStepEvents(t *testing.T, jobID types.JobID, stepLabel string, name event.Name) int {
+	events, err := storage.NewTestEventFetcher().Fetch(
+		testevent.QueryJobID(jobID),
+		testevent.QueryTestStepLabel(stepLabel),
+		testevent.QueryEventName(name),
+	)
+	require.NoError(t, err)
+	return len(events)
+}
+
+func TestPausedJobResumesAfterRestart(t *testing.T) {
+	storage.SetStorage(memory.New())
+	target.SetLocker(inmemory.New(time.Minute))
+	descriptor := strings.Replace(fmt.Sprintf(resumeJobDescriptor, "slowecho", "300ms"), `, "Breakpoint": true`, "", 1)
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+
+	resp := jm.startJob("test", descriptor)
+	require.NoError(t, resp.Err)
+	jobID := resp.JobID
+	require.Eventually(t, func() bool {
+		return countStepEvents(t, jobID, "slow", slowecho.EventTargetSleeping) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	jm.Pause()
+	jm.jobsWg.Wait()
+
+	require.Equal(t, []event.Name{EventJobStarted, EventJobPaused}, jobStates(t, jm, jobID))
+	snapshot, err := storage.GetStepState(jobID)
+	require.NoError(t, err)
+	require.Equal(t, types.RunID(1), snapshot.RunID)
+	require.Equal(t, 0, snapshot.TestIndex)
+	require.Len(t, snapshot.Steps, 2)
+	require.Empty(t, snapshot.Steps[0].Pending)
+	require.Empty(t, snapshot.Steps[0].InFlight)
+	require.Equal(t, "slow", snapshot.Steps[1].TestStepLabel)
+	require.Len(t, snapshot.Steps[1].InFlight, 1)
+	require.Equal(t, "1", snapshot.Steps[1].InFlight[0].ID)
+
+	// a new JobManager resumes the job after the restart
+	jm, err = New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+	require.NoError(t, jm.resumePausedJobs())
+	jm.jobsWg.Wait()
+
+	require.Equal(t, []event.Name{EventJobStarted, EventJobPaused, EventJobStarted, EventJobCompleted}, jobStates(t, jm, jobID))
+	require.Equal(t, 1, countStepEvents(t, jobID, "first", target.EventTargetIn))
+	require.Equal(t, 1, countStepEvents(t, jobID, "slow", target.EventTargetOut))
+	report, err := jm.jobReportManager.Fetch(jobID)
+	require.NoError(t, err)
+	require.Len(t, report.RunReports, 1)
+	require.True(t, report.RunReports[0][0].Success)
+}
+
+func TestPausedJobWithoutSnapshotFails(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+	jobID, err := jm.jobRequestManager.Emit(&job.Request{JobName: "broken", JobDescriptor: "{}"})
+	require.NoError(t, err)
+	require.NoError(t, storage.StoreStepState(jobID, &job.PauseSnapshot{RunID: 1, TestIndex: 3}))
+	require.NoError(t, jm.emitEvent(jobID, EventJobPaused))
+
+	require.NoError(t, jm.resumePausedJobs())
+	jm.jobsWg.Wait()
+	require.Equal(t, []event.Name{EventJobPaused, EventJobFailed}, jobStates(t, jm, jobID))
+}
@@ -0,0 +1,186 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// StatusQueued is the state in the status of the jobs waiting for a slot.
+// They have no state event yet: their requests are stored in the
+// job.StateQueued state, which is how the queue survives restarts.
+const StatusQueued = "JobStateQueued"
+
+// OptionMaxConcurrentJobs limits the number of jobs running at once. The jobs
+// submitted beyond the limit are queued by priority, and start as the running
+// jobs end. Zero means no limit.
+func OptionMaxConcurrentJobs(n uint) Option {
+	return func(jm *JobManager) {
+		jm.maxConcurrentJobs = n
+	}
+}
+
+// jobQueue holds the jobs waiting for a slot, by decreasing priority, and in
+// submission order within the same priority.
+type jobQueue []*job.Job
+
+// push queues a job, and returns its position starting from 1.
+func (q *jobQueue) push(j *job.Job) uint {
+	i := sort.Search(len(*q), func(i int) bool {
+		return (*q)[i].Priority < j.Priority
+	})
+	*q = append(*q, nil)
+	copy((*q)[i+1:], (*q)[i:])
+	(*q)[i] = j
+	return uint(i) + 1
+}
+
+// pop removes the next job of the queue.
+func (q *jobQueue) pop() *job.Job {
+	j := (*q)[0]
+	*q = (*q)[1:]
+	return j
+}
+
+// remove removes a job from the queue, and returns it.
+func (q *jobQueue) remove(jobID types.JobID) (*job.Job, bool) {
+	for i, j := range *q {
+		if j.ID == jobID {
+			*q = append((*q)[:i], (*q)[i+1:]...)
+			return j, true
+		}
+	}
+	return nil, false
+}
+
+// find returns a job of the queue and its position, starting from 1.
+func (q jobQueue) find(jobID types.JobID) (*job.Job, uint, bool) {
+	for i, j := range q {
+		if j.ID == jobID {
+			return j, uint(i) + 1, true
+		}
+	}
+	return nil, 0, false
+}
+
+// acquireSlot takes a slot for a job if one is free, or queues the job
+// otherwise. It returns the position of a queued job.
+func (jm *JobManager) acquireSlot(j *job.Job) (uint, bool) {
+	jm.queueMu.Lock()
+	defer jm.queueMu.Unlock()
+	if jm.maxConcurrentJobs == 0 || (jm.runningJobs < jm.maxConcurrentJobs && len(jm.queue) == 0) {
+		jm.runningJobs++
+		return 0, false
+	}
+	metrics.JobQueued(1)
+	return jm.queue.push(j), true
+}
+
+// takeSlot takes a slot regardless of the limit, for the paused jobs which
+// resume after a restart: they were already running.
+func (jm *JobManager) takeSlot() {
+	jm.queueMu.Lock()
+	defer jm.queueMu.Unlock()
+	jm.runningJobs++
+}
+
+// releaseSlot frees the slot of a job which ended, and starts the next queued
+// jobs while slots are free. Once the JobManager is stopping, the queued jobs
+// stay queued until the next start.
+func (jm *JobManager) releaseSlot() {
+	var next []*job.Job
+	jm.queueMu.Lock()
+	jm.runningJobs--
+	for len(jm.queue) > 0 && jm.runningJobs < jm.maxConcurrentJobs && !jm.stopping() {
+		next = append(next, jm.queue.pop())
+		jm.runningJobs++
+	}
+	jm.queueMu.Unlock()
+	for _, j := range next {
+		metrics.JobQueued(-1)
+		jm.startQueuedJob(j)
+	}
+}
+
+func (jm *JobManager) stopping() bool {
+	select {
+	case <-jm.apiCancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// startQueuedJob runs a job which got a slot.
+func (jm *JobManager) startQueuedJob(j *job.Job) {
+	log.Infof("Starting queued job %s", j.ID)
+	if err := jm.emitEvent(j.ID, EventJobStarted); err != nil {
+		log.Errorf("Could not start queued job %s: %v", j.ID, err)
+		jm.releaseSlot()
+		return
+	}
+	jm.runJob(j)
+}
+
+// dequeue removes a job from the queue, e.g. because it is stopped before it
+// started.
+func (jm *JobManager) dequeue(jobID types.JobID) (*job.Job, bool) {
+	jm.queueMu.Lock()
+	defer jm.queueMu.Unlock()
+	j, ok := jm.queue.remove(jobID)
+	if ok {
+		metrics.JobQueued(-1)
+	}
+	return j, ok
+}
+
+// queuedJob returns a queued job and its position, starting from 1.
+func (jm *JobManager) queuedJob(jobID types.JobID) (*job.Job, uint, bool) {
+	jm.queueMu.Lock()
+	defer jm.queueMu.Unlock()
+	return jm.queue.find(jobID)
+}
+
+// resumeQueuedJobs queues again the jobs which were waiting for a slot when
+// the server last shut down, in submission order. The jobs which cannot be
+// rebuilt fail.
+func (jm *JobManager) resumeQueuedJobs() error {
+	var requests []*job.Request
+	for {
+		page, err := storage.GetJobRequests(job.JobQuery{States: []event.Name{job.StateQueued}, Offset: uint(len(requests))})
+		if err != nil {
+			return fmt.Errorf("could not list queued jobs: %v", err)
+		}
+		requests = append(requests, page...)
+		if len(page) < job.DefaultJobQueryLimit {
+			break
+		}
+	}
+	sort.SliceStable(requests, func(i, k int) bool {
+		return requests[i].RequestTime.Before(requests[k].RequestTime)
+	})
+	for _, request := range requests {
+		j, err := NewJob(jm.pluginRegistry, request.JobDescriptor)
+		if err != nil {
+			_ = jm.emitErrEvent(request.JobID, EventJobFailed, fmt.Errorf("could not resume queued job: %v", err))
+			continue
+		}
+		j.ID = request.JobID
+		if position, queued := jm.acquireSlot(j); queued {
+			log.Infof("Job %s is queued again at position %d", j.ID, position)
+			continue
+		}
+		jm.startQueuedJob(j)
+	}
+	return nil
+}
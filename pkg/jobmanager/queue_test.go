@@ -0,0 +1,177 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/facebookincubator/contest/plugins/targetlocker/inmemory"
+	"github.com/facebookincubator/contest/plugins/teststeps/slowecho"
+	"github.com/stretchr/testify/require"
+)
+
+// priorityJobDescriptor returns a job descriptor with the given priority,
+// whose targets are held at a breakpoint until released.
+func priorityJobDescriptor(name string, priority int) string {
+	descriptor := fmt.Sprintf(resumeJobDescriptor, "echo", "0")
+	return strings.Replace(descriptor, `"JobName": "resume",`, fmt.Sprintf(`"JobName": %q, "priority": %d,`, name, priority), 1)
+}
+
+// releaseWhenHeld releases the breakpoint of a running job once its target
+// reaches it.
+func releaseWhenHeld(t *testing.T, jm *JobManager, jobID types.JobID) {
+	require.Eventually(t, func() bool {
+		jm.jobsMu.Lock()
+		defer jm.jobsMu.Unlock()
+		j, ok := jm.jobs[jobID]
+		return ok && j.Breakpoints.Held() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	jm.jobsMu.Lock()
+	j := jm.jobs[jobID]
+	jm.jobsMu.Unlock()
+	j.Breakpoints.Release()
+}
+
+func TestQueuedJobsStartByPriority(t *testing.T) {
+	storage.SetStorage(memory.New())
+	target.SetLocker(inmemory.New(time.Minute))
+	jm, err := New(nil, newResumeRegistry(t), OptionMaxConcurrentJobs(1))
+	require.NoError(t, err)
+
+	running := jm.startJob("test", priorityJobDescriptor("running", 0))
+	require.NoError(t, running.Err)
+	require.Equal(t, string(EventJobStarted), running.Status.State)
+
+	low := jm.startJob("test", priorityJobDescriptor("low", 0))
+	require.NoError(t, low.Err)
+	require.Equal(t, StatusQueued, low.Status.State)
+	require.Equal(t, uint(1), low.Status.QueuePosition)
+	stopped := jm.startJob("test", priorityJobDescriptor("stopped", 0))
+	require.NoError(t, stopped.Err)
+	require.Equal(t, uint(2), stopped.Status.QueuePosition)
+	// a higher priority job goes ahead of the queue
+	high := jm.startJob("test", priorityJobDescriptor("high", 10))
+	require.NoError(t, high.Err)
+	require.Equal(t, uint(1), high.Status.QueuePosition)
+
+	status := jm.status(&api.Event{Msg: api.EventStatusMsg{JobID: low.JobID}})
+	require.NoError(t, status.Err)
+	require.Equal(t, "low", status.Status.Name)
+	require.Equal(t, StatusQueued, status.Status.State)
+	require.Equal(t, uint(2), status.Status.QueuePosition)
+	// the queued jobs are persisted as job requests without any state
+	requests, err := storage.GetJobRequests(job.JobQuery{States: []event.Name{job.StateQueued}})
+	require.NoError(t, err)
+	require.Len(t, requests, 3)
+
+	// a queued job can be stopped before it starts
+	resp := jm.stop(&api.Event{Msg: api.EventStopMsg{JobID: stopped.JobID}})
+	require.NoError(t, resp.Err)
+	require.Equal(t, []event.Name{EventJobCancelled}, jobStates(t, jm, stopped.JobID))
+
+	releaseWhenHeld(t, jm, running.JobID)
+	releaseWhenHeld(t, jm, high.JobID)
+	require.Empty(t, jobStates(t, jm, low.JobID))
+	releaseWhenHeld(t, jm, low.JobID)
+	jm.jobsWg.Wait()
+
+	for _, jobID := range []types.JobID{running.JobID, high.JobID, low.JobID} {
+		require.Equal(t, []event.Name{EventJobStarted, EventJobCompleted}, jobStates(t, jm, jobID))
+	}
+	started := func(jobID types.JobID) time.Time {
+		events, err := jm.frameworkEvManager.Fetch(
+			frameworkevent.QueryJobID(jobID),
+			frameworkevent.QueryEventName(EventJobStarted),
+		)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		return events[0].EmitTime
+	}
+	require.False(t, started(low.JobID).Before(started(high.JobID)))
+	require.Equal(t, uint(0), jm.runningJobs)
+}
+
+func TestQueuedJobsResumeAfterRestart(t *testing.T) {
+	storage.SetStorage(memory.New())
+	target.SetLocker(inmemory.New(time.Minute))
+	descriptor := strings.Replace(fmt.Sprintf(resumeJobDescriptor, "slowecho", "300ms"), `, "Breakpoint": true`, "", 1)
+	jm, err := New(nil, newResumeRegistry(t), OptionMaxConcurrentJobs(1))
+	require.NoError(t, err)
+	running := jm.startJob("test", descriptor)
+	require.NoError(t, running.Err)
+	queued := jm.startJob("test", descriptor)
+	require.NoError(t, queued.Err)
+	require.Equal(t, StatusQueued, queued.Status.State)
+	require.Eventually(t, func() bool {
+		return countStepEvents(t, running.JobID, "slow", slowecho.EventTargetSleeping) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	jm.Pause()
+	jm.jobsWg.Wait()
+	// the queued job does not start while the server stops
+	require.Equal(t, []event.Name{EventJobStarted, EventJobPaused}, jobStates(t, jm, running.JobID))
+	require.Empty(t, jobStates(t, jm, queued.JobID))
+
+	jm, err = New(nil, newResumeRegistry(t), OptionMaxConcurrentJobs(1))
+	require.NoError(t, err)
+	require.NoError(t, jm.resumePausedJobs())
+	require.NoError(t, jm.resumeQueuedJobs())
+	jm.jobsWg.Wait()
+	require.Equal(t, []event.Name{EventJobStarted, EventJobPaused, EventJobStarted, EventJobCompleted}, jobStates(t, jm, running.JobID))
+	require.Equal(t, []event.Name{EventJobStarted, EventJobCompleted}, jobStates(t, jm, queued.JobID))
+	require.Equal(t, uint(0), jm.runningJobs)
+}
+
+// stateRecorder records the job states notified to it.
+type stateRecorder struct {
+	mu     sync.Mutex
+	states map[types.JobID][]JobState
+}
+
+func (r *stateRecorder) JobStateChanged(jobID types.JobID, state JobState, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states[jobID] = append(r.states[jobID], state)
+}
+
+func (r *stateRecorder) get(jobID types.JobID) []JobState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]JobState(nil), r.states[jobID]...)
+}
+
+func TestOnlyQueuedJobsAreNotifiedAsQueued(t *testing.T) {
+	storage.SetStorage(memory.New())
+	target.SetLocker(inmemory.New(time.Minute))
+	observer := &stateRecorder{states: make(map[types.JobID][]JobState)}
+	jm, err := New(nil, newResumeRegistry(t), OptionMaxConcurrentJobs(1), OptionJobStateObserver(observer))
+	require.NoError(t, err)
+
+	running := jm.startJob("test", priorityJobDescriptor("running", 0))
+	require.NoError(t, running.Err)
+	queued := jm.startJob("test", priorityJobDescriptor("queued", 0))
+	require.NoError(t, queued.Err)
+	require.Equal(t, StatusQueued, queued.Status.State)
+	require.Equal(t, []JobState{JobStateRunning}, observer.get(running.JobID))
+	require.Equal(t, []JobState{JobStateQueued}, observer.get(queued.JobID))
+
+	releaseWhenHeld(t, jm, running.JobID)
+	releaseWhenHeld(t, jm, queued.JobID)
+	jm.jobsWg.Wait()
+	require.Equal(t, []JobState{JobStateRunning, JobStateCompleted}, observer.get(running.JobID))
+	require.Equal(t, []JobState{JobStateQueued, JobStateRunning, JobStateCompleted}, observer.get(queued.JobID))
+}
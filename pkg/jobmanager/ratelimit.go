@@ -0,0 +1,108 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+)
+
+// RateLimit is a token bucket limiting the job submissions of a requestor:
+// up to Burst jobs can be submitted at once, and one more can be submitted
+// every Every. A zero RateLimit is unlimited.
+type RateLimit struct {
+	Burst uint
+	Every time.Duration
+}
+
+// ParseRateLimit parses a rate limit in the form <jobs>/<duration>, e.g.
+// 10/1m, which allows 10 jobs per minute, in bursts of up to 10 jobs.
+func ParseRateLimit(s string) (RateLimit, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, must be <jobs>/<duration>", s)
+	}
+	jobs, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || jobs == 0 {
+		return RateLimit{}, fmt.Errorf("invalid number of jobs in rate limit %q", s)
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid duration in rate limit %q", s)
+	}
+	return RateLimit{Burst: uint(jobs), Every: period / time.Duration(jobs)}, nil
+}
+
+// bucket is the state of the token bucket of a requestor.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits the job submissions of each requestor. Requestors are
+// declared by the clients, so the limits are only as strong as the identity
+// checks done by the API listener.
+type rateLimiter struct {
+	mu           sync.Mutex
+	defaultLimit RateLimit
+	limits       map[api.EventRequestor]RateLimit
+	buckets      map[api.EventRequestor]*bucket
+	now          func() time.Time
+}
+
+func newRateLimiter(defaultLimit RateLimit, limits map[api.EventRequestor]RateLimit) *rateLimiter {
+	return &rateLimiter{
+		defaultLimit: defaultLimit,
+		limits:       limits,
+		buckets:      make(map[api.EventRequestor]*bucket),
+		now:          time.Now,
+	}
+}
+
+// reserve takes a token from the bucket of the requestor. If the bucket is
+// empty, it returns the time until the next token is available.
+func (l *rateLimiter) reserve(requestor api.EventRequestor) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit, ok := l.limits[requestor]
+	if !ok {
+		limit = l.defaultLimit
+	}
+	if limit.Burst == 0 {
+		return 0, true
+	}
+	now := l.now()
+	b, ok := l.buckets[requestor]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[requestor] = b
+	}
+	b.tokens += float64(now.Sub(b.last)) / float64(limit.Every)
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) * float64(limit.Every)), false
+}
+
+// OptionSubmissionRateLimit limits the rate at which each requestor can submit
+// jobs. Requestors listed in limits use their own limit, the others use
+// defaultLimit. Submissions over the limit are rejected with a
+// cerrors.ErrSubmissionRateLimited error.
+func OptionSubmissionRateLimit(defaultLimit RateLimit, limits map[api.EventRequestor]RateLimit) Option {
+	return func(jm *JobManager) {
+		jm.rateLimiter = newRateLimiter(defaultLimit, limits)
+	}
+}
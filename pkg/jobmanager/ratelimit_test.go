@@ -0,0 +1,134 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/pluginregistry"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/facebookincubator/contest/plugins/targetlocker/inmemory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := ParseRateLimit("10/1m")
+	require.NoError(t, err)
+	require.Equal(t, RateLimit{Burst: 10, Every: 6 * time.Second}, limit)
+
+	for _, s := range []string{"", "10", "0/1m", "-1/1m", "10/0s", "10/forever"} {
+		_, err := ParseRateLimit(s)
+		require.Error(t, err, s)
+	}
+}
+
+// rateLimitedJobDescriptor is the descriptor of a valid job, which runs
+// through without stopping at the breakpoint.
+var rateLimitedJobDescriptor = strings.Replace(fmt.Sprintf(resumeJobDescriptor, "echo", "0"), `, "Breakpoint": true`, "", 1)
+
+// newRateLimitedJobManager returns a JobManager which runs one job at a time,
+// so that the jobs it submits don't compete for the same target.
+func newRateLimitedJobManager(t *testing.T, defaultLimit RateLimit, limits map[api.EventRequestor]RateLimit) *JobManager {
+	storage.SetStorage(memory.New())
+	target.SetLocker(inmemory.New(time.Minute))
+	jm, err := New(nil, newResumeRegistry(t), OptionMaxConcurrentJobs(1), OptionSubmissionRateLimit(defaultLimit, limits))
+	require.NoError(t, err)
+	return jm
+}
+
+// submitRateLimited submits a job, and returns the rate limit error it was
+// rejected with, if any. Submissions must not fail for other reasons.
+func submitRateLimited(t *testing.T, jm *JobManager, requestor api.EventRequestor, jobDescriptor string) *cerrors.ErrSubmissionRateLimited {
+	resp := jm.startJob(requestor, jobDescriptor)
+	var rateLimited *cerrors.ErrSubmissionRateLimited
+	if errors.As(resp.Err, &rateLimited) {
+		return rateLimited
+	}
+	require.NoError(t, resp.Err)
+	return nil
+}
+
+func TestSubmissionRateLimited(t *testing.T) {
+	limits := map[api.EventRequestor]RateLimit{"ci": {Burst: 3, Every: time.Minute}}
+	jm := newRateLimitedJobManager(t, RateLimit{Burst: 2, Every: time.Minute}, limits)
+	now := time.Now()
+	jm.rateLimiter.now = func() time.Time { return now }
+
+	submit := func(requestor api.EventRequestor) *cerrors.ErrSubmissionRateLimited {
+		return submitRateLimited(t, jm, requestor, rateLimitedJobDescriptor)
+	}
+	require.Nil(t, submit("alice"))
+	require.Nil(t, submit("alice"))
+	rateLimited := submit("alice")
+	require.NotNil(t, rateLimited)
+	require.Equal(t, "alice", rateLimited.Requestor)
+	require.Equal(t, time.Minute, rateLimited.RetryAfter)
+
+	// requestors have their own buckets, and limits
+	require.Nil(t, submit("bob"))
+	for i := 0; i < 3; i++ {
+		require.Nil(t, submit("ci"))
+	}
+	require.NotNil(t, submit("ci"))
+
+	// tokens are refilled over time
+	now = now.Add(90 * time.Second)
+	require.Nil(t, submit("alice"))
+	rateLimited = submit("alice")
+	require.NotNil(t, rateLimited)
+	require.Equal(t, 30*time.Second, rateLimited.RetryAfter)
+	jm.jobsWg.Wait()
+}
+
+func TestInvalidSubmissionsNotRateLimited(t *testing.T) {
+	jm := newRateLimitedJobManager(t, RateLimit{Burst: 1, Every: time.Minute}, nil)
+	jm.uniqueExternalIDs = true
+	now := time.Now()
+	jm.rateLimiter.now = func() time.Time { return now }
+	_, err := jm.jobRequestManager.Emit(&job.Request{JobName: "job", ExternalID: "orchestrator-42"})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name          string
+		jobDescriptor string
+	}{
+		{name: "invalid descriptor", jobDescriptor: "{}"},
+		{name: "used external ID", jobDescriptor: strings.Replace(rateLimitedJobDescriptor, `"JobName": "resume",`, `"JobName": "resume", "ExternalID": "orchestrator-42",`, 1)},
+		{name: "unknown dependency", jobDescriptor: dependentJobDescriptor("42")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				resp := jm.startJob("alice", tc.jobDescriptor)
+				require.Error(t, resp.Err)
+				var rateLimited *cerrors.ErrSubmissionRateLimited
+				require.False(t, errors.As(resp.Err, &rateLimited), resp.Err)
+			}
+		})
+	}
+	// the rejected submissions left the token to the first valid one
+	require.Nil(t, submitRateLimited(t, jm, "alice", rateLimitedJobDescriptor))
+	require.NotNil(t, submitRateLimited(t, jm, "alice", rateLimitedJobDescriptor))
+	jm.jobsWg.Wait()
+}
+
+func TestSubmissionNotRateLimited(t *testing.T) {
+	jm, err := New(nil, pluginregistry.NewPluginRegistry())
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		resp := jm.startJob("alice", "{}")
+		var rateLimited *cerrors.ErrSubmissionRateLimited
+		require.False(t, errors.As(resp.Err, &rateLimited))
+	}
+}
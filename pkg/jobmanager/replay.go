@@ -0,0 +1,83 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// replayTargetManager is the name of the target manager acquiring the failed
+// targets of a replayed job. It must accept a static list of targets in its
+// acquire parameters, as the TargetList plugin does.
+const replayTargetManager = "TargetList"
+
+// replayAcquireParameters are the acquire parameters of replayTargetManager.
+type replayAcquireParameters struct {
+	Targets []*target.Target
+}
+
+// replayDescriptor returns a copy of a job descriptor where every test acquires
+// exactly the given targets, through replayTargetManager.
+func replayDescriptor(jobDescriptor string, targets []*target.Target) (string, error) {
+	var jd job.JobDescriptor
+	if err := json.Unmarshal([]byte(jobDescriptor), &jd); err != nil {
+		return "", fmt.Errorf("could not decode job descriptor: %v", err)
+	}
+	acquireParameters, err := json.Marshal(replayAcquireParameters{Targets: targets})
+	if err != nil {
+		return "", fmt.Errorf("could not encode target list: %v", err)
+	}
+	for _, td := range jd.TestDescriptors {
+		if td == nil {
+			continue
+		}
+		td.TargetManagerName = replayTargetManager
+		td.TargetManagerAcquireParameters = acquireParameters
+		td.TargetManagerReleaseParameters = json.RawMessage("{}")
+	}
+	replay, err := json.Marshal(jd)
+	if err != nil {
+		return "", fmt.Errorf("could not encode job descriptor: %v", err)
+	}
+	return string(replay), nil
+}
+
+// replayFailedDescriptor builds the descriptor of a job running the tests of
+// a prior job over the targets which failed in its last run.
+func (jm *JobManager) replayFailedDescriptor(jobID types.JobID) (string, error) {
+	request, err := jm.fetchJobRequest(jobID)
+	if err != nil {
+		return "", err
+	}
+	failed, err := storage.GetFailedTargets(jobID)
+	if err != nil {
+		return "", err
+	}
+	if len(failed) == 0 {
+		return "", fmt.Errorf("job %s has no failed targets to replay", jobID)
+	}
+	return replayDescriptor(request.JobDescriptor, failed)
+}
+
+func (jm *JobManager) replayFailed(ev *api.Event) *api.EventResponse {
+	msg := ev.Msg.(api.EventReplayFailedMsg)
+	jobDescriptor, err := jm.replayFailedDescriptor(msg.JobID)
+	if err != nil {
+		return &api.EventResponse{
+			Requestor: ev.Msg.Requestor(),
+			Err:       fmt.Errorf("could not replay failed targets of job %s: %v", msg.JobID, err),
+		}
+	}
+	log.Infof("Replaying failed targets of job %s", msg.JobID)
+	return jm.startJob(ev.Msg.Requestor(), jobDescriptor)
+}
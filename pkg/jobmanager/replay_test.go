@@ -0,0 +1,110 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/pluginregistry"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/facebookincubator/contest/plugins/targetlocker/noop"
+	"github.com/facebookincubator/contest/plugins/targetmanagers/targetlist"
+	"github.com/stretchr/testify/require"
+)
+
+const replayJobDescriptor = `{
+	"JobName": "replay",
+	"Runs": 2,
+	"RunInterval": "1s",
+	"TestDescriptors": [{
+		"TargetManagerName": "CSVFileTargetManager",
+		"TargetManagerAcquireParameters": {"FileURI": "/tmp/targets.csv", "MinNumberDevices": 3},
+		"TargetManagerReleaseParameters": {},
+		"TestFetcherName": "literal",
+		"TestFetcherFetchParameters": {"TestName": "test", "Steps": []}
+	}],
+	"Reporting": {"RunReporters": [{"Name": "TargetSuccess"}]}
+}`
+
+func emitRouting(t *testing.T, jobID types.JobID, runID types.RunID, name event.Name, tgt *target.Target) {
+	header := testevent.Header{JobID: jobID, RunID: runID, TestName: "test", TestStepLabel: "step"}
+	require.NoError(t, storage.NewTestEventEmitter(header).Emit(testevent.Data{EventName: name, Target: tgt}))
+}
+
+func TestReplayFailedTargets(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, pluginregistry.NewPluginRegistry())
+	require.NoError(t, err)
+	jobID, err := jm.jobRequestManager.Emit(&job.Request{JobName: "replay", RequestTime: time.Now(), JobDescriptor: replayJobDescriptor})
+	require.NoError(t, err)
+
+	a := &target.Target{Name: "a", ID: "1"}
+	b := &target.Target{Name: "b", ID: "2"}
+	c := &target.Target{Name: "c", ID: "3"}
+	// a only failed in the first run
+	for _, tgt := range []*target.Target{a, b, c} {
+		emitRouting(t, jobID, 1, target.EventTargetIn, tgt)
+	}
+	emitRouting(t, jobID, 1, target.EventTargetErr, a)
+	emitRouting(t, jobID, 1, target.EventTargetOut, b)
+	emitRouting(t, jobID, 1, target.EventTargetOut, c)
+	// b and c fail in the last run, c twice
+	for _, tgt := range []*target.Target{a, b, c} {
+		emitRouting(t, jobID, 2, target.EventTargetIn, tgt)
+	}
+	emitRouting(t, jobID, 2, target.EventTargetOut, a)
+	emitRouting(t, jobID, 2, target.EventTargetErr, c)
+	emitRouting(t, jobID, 2, target.EventTargetErr, b)
+	emitRouting(t, jobID, 2, target.EventTargetErr, c)
+
+	failed, err := storage.GetFailedTargets(jobID)
+	require.NoError(t, err)
+	require.Equal(t, []*target.Target{c, b}, failed)
+
+	replay, err := jm.replayFailedDescriptor(jobID)
+	require.NoError(t, err)
+	var jd job.JobDescriptor
+	require.NoError(t, json.Unmarshal([]byte(replay), &jd))
+	require.Equal(t, "replay", jd.JobName)
+	require.Equal(t, uint(2), jd.Runs)
+	require.Len(t, jd.TestDescriptors, 1)
+	td := jd.TestDescriptors[0]
+	require.Equal(t, "literal", td.TestFetcherName)
+	require.JSONEq(t, `{"TestName": "test", "Steps": []}`, string(td.TestFetcherFetchParameters))
+	require.Equal(t, targetlist.Name, td.TargetManagerName)
+
+	// the target manager of the replayed job acquires the failed targets only
+	tm := targetlist.New()
+	params, err := tm.ValidateAcquireParameters(td.TargetManagerAcquireParameters)
+	require.NoError(t, err)
+	acquired, err := tm.Acquire(types.JobID("2"), nil, params, noop.New(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []*target.Target{c, b}, acquired)
+	_, err = tm.ValidateReleaseParameters(td.TargetManagerReleaseParameters)
+	require.NoError(t, err)
+}
+
+func TestReplayWithoutFailures(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, pluginregistry.NewPluginRegistry())
+	require.NoError(t, err)
+	jobID, err := jm.jobRequestManager.Emit(&job.Request{JobName: "replay", RequestTime: time.Now(), JobDescriptor: replayJobDescriptor})
+	require.NoError(t, err)
+	tgt := &target.Target{Name: "a", ID: "1"}
+	emitRouting(t, jobID, 1, target.EventTargetIn, tgt)
+	emitRouting(t, jobID, 1, target.EventTargetOut, tgt)
+
+	_, err = jm.replayFailedDescriptor(jobID)
+	require.Error(t, err)
+}
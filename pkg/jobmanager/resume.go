@@ -0,0 +1,99 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/test"
+)
+
+// updatedParameters compares the tests of a job with the tests built from an
+// updated descriptor, and returns the parameters of the steps which changed.
+// The updated tests must have the same names, and the same steps in the same
+// order; only the step parameters are taken from them.
+func updatedParameters(j *job.Job, tests []*test.Test) (map[job.StepKey]test.TestStepParameters, error) {
+	if len(tests) != len(j.Tests) {
+		return nil, fmt.Errorf("the updated descriptor has %d tests, expected %d", len(tests), len(j.Tests))
+	}
+	params := make(map[job.StepKey]test.TestStepParameters)
+	for idx, t := range j.Tests {
+		updated := tests[idx]
+		if updated.Name != t.Name {
+			return nil, fmt.Errorf("test #%d is named '%s' in the updated descriptor, expected '%s'", idx, updated.Name, t.Name)
+		}
+		if len(updated.TestStepsBundles) != len(t.TestStepsBundles) {
+			return nil, fmt.Errorf("test '%s' has %d steps in the updated descriptor, expected %d", t.Name, len(updated.TestStepsBundles), len(t.TestStepsBundles))
+		}
+		for stepIdx, bundle := range t.TestStepsBundles {
+			updatedBundle := updated.TestStepsBundles[stepIdx]
+			if updatedBundle.TestStepLabel != bundle.TestStepLabel || updatedBundle.TestStep.Name() != bundle.TestStep.Name() {
+				return nil, fmt.Errorf("step #%d of test '%s' is %s '%s' in the updated descriptor, expected %s '%s'",
+					stepIdx, t.Name, updatedBundle.TestStep.Name(), updatedBundle.TestStepLabel, bundle.TestStep.Name(), bundle.TestStepLabel)
+			}
+			key := job.StepKey{TestName: t.Name, TestStepLabel: bundle.TestStepLabel}
+			current := bundle.Parameters
+			if p, ok := j.Breakpoints.UpdatedParameters(key); ok {
+				current = p
+			}
+			if !reflect.DeepEqual(updatedBundle.Parameters, current) {
+				params[key] = updatedBundle.Parameters
+			}
+		}
+	}
+	return params, nil
+}
+
+func (jm *JobManager) resume(ev *api.Event) *api.EventResponse {
+	msg := ev.Msg.(api.EventResumeMsg)
+	jobID := msg.JobID
+
+	jm.jobsMu.Lock()
+	j, ok := jm.jobs[jobID]
+	jm.jobsMu.Unlock()
+	if !ok {
+		return &api.EventResponse{
+			Requestor: ev.Msg.Requestor(),
+			Err:       fmt.Errorf("unknown job ID: %s", jobID),
+		}
+	}
+	if j.Breakpoints.Held() == 0 {
+		return &api.EventResponse{
+			JobID:     jobID,
+			Requestor: ev.Msg.Requestor(),
+			Err:       fmt.Errorf("job %s is not paused at a breakpoint", jobID),
+		}
+	}
+	if msg.JobDescriptor == "" {
+		log.Infof("Releasing breakpoints of job %s", jobID)
+		j.Breakpoints.Release()
+		return &api.EventResponse{
+			JobID:     jobID,
+			Requestor: ev.Msg.Requestor(),
+		}
+	}
+
+	// the updated descriptor is validated like a new job
+	updated, err := NewJob(jm.pluginRegistry, msg.JobDescriptor)
+	if err == nil {
+		var params map[job.StepKey]test.TestStepParameters
+		if params, err = updatedParameters(j, updated.Tests); err == nil {
+			log.Infof("Releasing breakpoints of job %s, updating the parameters of %d steps", jobID, len(params))
+			err = j.Breakpoints.ReleaseWithParameters(params)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot resume job %s with the updated descriptor: %v", jobID, err)
+	}
+	return &api.EventResponse{
+		JobID:     jobID,
+		Requestor: ev.Msg.Requestor(),
+		Err:       err,
+	}
+}
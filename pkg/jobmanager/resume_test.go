@@ -0,0 +1,97 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/pluginregistry"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/reporters/targetsuccess"
+	"github.com/facebookincubator/contest/plugins/targetmanagers/targetlist"
+	"github.com/facebookincubator/contest/plugins/testfetchers/literal"
+	"github.com/facebookincubator/contest/plugins/teststeps/echo"
+	"github.com/facebookincubator/contest/plugins/teststeps/slowecho"
+	"github.com/stretchr/testify/require"
+)
+
+const resumeJobDescriptor = `{
+	"JobName": "resume",
+	"Runs": 1,
+	"TestDescriptors": [{
+		"TargetManagerName": "TargetList",
+		"TargetManagerAcquireParameters": {"Targets": [{"Name": "t1", "ID": "1"}]},
+		"TargetManagerReleaseParameters": {},
+		"TestFetcherName": "literal",
+		"TestFetcherFetchParameters": {"TestName": "test", "Steps": [
+			{"name": "echo", "label": "first", "parameters": {"text": ["hello"]}},
+			{"name": %q, "label": "slow", "parameters": {"text": ["hello"], "sleep": [%q]}, "Breakpoint": true}
+		]}
+	}],
+	"Reporting": {"RunReporters": [{"Name": "TargetSuccess", "Parameters": {"SuccessExpression": ">0%%"}}]}
+}`
+
+func newResumeRegistry(t *testing.T) *pluginregistry.PluginRegistry {
+	pr := pluginregistry.NewPluginRegistry()
+	require.NoError(t, pr.RegisterTargetManager(targetlist.Load()))
+	require.NoError(t, pr.RegisterTestFetcher(literal.Load()))
+	require.NoError(t, pr.RegisterTestStep(echo.Load()))
+	require.NoError(t, pr.RegisterTestStep(slowecho.Load()))
+	require.NoError(t, pr.RegisterReporter(targetsuccess.Load()))
+	return pr
+}
+
+func TestResumeUpdatedParameters(t *testing.T) {
+	pr := newResumeRegistry(t)
+	j, err := NewJob(pr, fmt.Sprintf(resumeJobDescriptor, "slowecho", "9"))
+	require.NoError(t, err)
+
+	// an unchanged descriptor updates nothing
+	same, err := NewJob(pr, fmt.Sprintf(resumeJobDescriptor, "slowecho", "9"))
+	require.NoError(t, err)
+	params, err := updatedParameters(j, same.Tests)
+	require.NoError(t, err)
+	require.Empty(t, params)
+
+	updated, err := NewJob(pr, fmt.Sprintf(resumeJobDescriptor, "slowecho", "0"))
+	require.NoError(t, err)
+	params, err = updatedParameters(j, updated.Tests)
+	require.NoError(t, err)
+	key := job.StepKey{TestName: "test", TestStepLabel: "slow"}
+	require.Len(t, params, 1)
+	require.Equal(t, "0", params[key].GetOne("sleep").Raw())
+
+	// once applied, the updated parameters are the current ones
+	j.Breakpoints.Hold()
+	require.NoError(t, j.Breakpoints.ReleaseWithParameters(params))
+	params, err = updatedParameters(j, updated.Tests)
+	require.NoError(t, err)
+	require.Empty(t, params)
+
+	// a step that started in the current run cannot be updated
+	j.Breakpoints.Parameters(types.RunID(1), key, test.TestStepParameters{})
+	params, err = updatedParameters(j, same.Tests)
+	require.NoError(t, err)
+	require.Len(t, params, 1)
+	require.Error(t, j.Breakpoints.ReleaseWithParameters(params))
+}
+
+func TestResumeIncompatibleDescriptor(t *testing.T) {
+	pr := newResumeRegistry(t)
+	j, err := NewJob(pr, fmt.Sprintf(resumeJobDescriptor, "slowecho", "9"))
+	require.NoError(t, err)
+
+	// the steps must be the same, in the same order
+	changed, err := NewJob(pr, fmt.Sprintf(resumeJobDescriptor, "echo", "9"))
+	require.NoError(t, err)
+	_, err = updatedParameters(j, changed.Tests)
+	require.Error(t, err)
+	_, err = updatedParameters(j, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/storage"
+)
+
+// defaultRetentionInterval is the default interval at which the retention
+// policy is applied.
+const defaultRetentionInterval = time.Hour
+
+// OptionRetentionPolicy prunes the test events and the reports of the ended
+// jobs which the policy does not retain anymore, every interval, or every hour
+// if interval is not positive.
+func OptionRetentionPolicy(policy storage.RetentionPolicy, interval time.Duration) Option {
+	return func(jm *JobManager) {
+		if !policy.Enabled() {
+			return
+		}
+		jm.pruner = storage.NewPruner(policy)
+		jm.retentionInterval = interval
+		if interval <= 0 {
+			jm.retentionInterval = defaultRetentionInterval
+		}
+	}
+}
+
+// pruneJobs applies the retention policy in the background, as deleting the
+// events of large jobs takes a while, unless the previous round is still
+// running.
+func (jm *JobManager) pruneJobs(now time.Time) {
+	if !atomic.CompareAndSwapInt32(&jm.pruning, 0, 1) {
+		log.Debugf("Skipping retention round, the previous one is still running")
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&jm.pruning, 0)
+		pruned, err := jm.pruner.Prune(now)
+		if len(pruned) > 0 {
+			log.Infof("Pruned the test events and reports of %d jobs", len(pruned))
+		}
+		if err != nil {
+			log.Warningf("Could not apply the retention policy: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,154 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+)
+
+// defaultSchedulePollInterval is the default interval at which the job
+// schedules are checked for due jobs.
+const defaultSchedulePollInterval = 10 * time.Second
+
+// OptionSchedulePollInterval sets the interval at which the job schedules
+// are checked for due jobs, which bounds how late the scheduled jobs start.
+func OptionSchedulePollInterval(d time.Duration) Option {
+	return func(jm *JobManager) {
+		jm.schedulePollInterval = d
+	}
+}
+
+// createSchedule validates the job descriptor of a schedule, and stores the
+// schedule. The first job is submitted at the first time of the recurrence
+// after now.
+func (jm *JobManager) createSchedule(ev *api.Event) *api.EventResponse {
+	msg := ev.Msg.(api.EventCreateScheduleMsg)
+	requestor := ev.Msg.Requestor()
+	if err := msg.Recurrence.Validate(); err != nil {
+		return &api.EventResponse{Requestor: requestor, Err: err}
+	}
+	jobDescriptor, err := job.SetParameterValues(msg.JobDescriptor, msg.Parameters)
+	if err != nil {
+		return &api.EventResponse{Requestor: requestor, Err: err}
+	}
+	// reject invalid descriptors now rather than at each run
+	if _, err := NewJob(jm.pluginRegistry, jobDescriptor); err != nil {
+		return &api.EventResponse{Requestor: requestor, Err: fmt.Errorf("invalid job descriptor: %v", err)}
+	}
+	now := time.Now()
+	schedule := job.Schedule{
+		Recurrence:    msg.Recurrence,
+		JobDescriptor: jobDescriptor,
+		Requestor:     string(requestor),
+		CreateTime:    now,
+		State:         job.ScheduleActive,
+		NextRun:       msg.Recurrence.Next(now),
+	}
+	if schedule.NextRun.IsZero() {
+		return &api.EventResponse{Requestor: requestor, Err: fmt.Errorf("cron expression %q never matches", msg.Recurrence.Cron)}
+	}
+	id, err := storage.StoreJobSchedule(&schedule)
+	if err != nil {
+		return &api.EventResponse{Requestor: requestor, Err: err}
+	}
+	schedule.ID = id
+	log.Infof("Created job schedule %d, whose first job is due at %s", id, schedule.NextRun)
+	return &api.EventResponse{Requestor: requestor, Schedules: []*job.Schedule{&schedule}}
+}
+
+func (jm *JobManager) listSchedules(ev *api.Event) *api.EventResponse {
+	schedules, err := storage.GetJobSchedules()
+	return &api.EventResponse{Requestor: ev.Msg.Requestor(), Schedules: schedules, Err: err}
+}
+
+func (jm *JobManager) getSchedule(ev *api.Event) *api.EventResponse {
+	msg := ev.Msg.(api.EventGetScheduleMsg)
+	schedule, err := storage.GetJobSchedule(msg.ScheduleID)
+	if err != nil {
+		return &api.EventResponse{Requestor: ev.Msg.Requestor(), Err: err}
+	}
+	jobIDs, err := storage.GetJobsBySeriesID(schedule.SeriesID())
+	if err != nil {
+		return &api.EventResponse{Requestor: ev.Msg.Requestor(), Err: err}
+	}
+	return &api.EventResponse{Requestor: ev.Msg.Requestor(), Schedules: []*job.Schedule{schedule}, SeriesJobIDs: jobIDs}
+}
+
+// setScheduleState pauses, resumes or cancels a schedule. Paused and
+// cancelled schedules have no next run, resumed ones skip the runs missed
+// while they were paused.
+func (jm *JobManager) setScheduleState(ev *api.Event) *api.EventResponse {
+	msg := ev.Msg.(api.EventSetScheduleStateMsg)
+	requestor := ev.Msg.Requestor()
+	schedule, err := storage.GetJobSchedule(msg.ScheduleID)
+	if err != nil {
+		return &api.EventResponse{Requestor: requestor, Err: err}
+	}
+	if schedule.State == job.ScheduleCancelled {
+		return &api.EventResponse{Requestor: requestor, Err: fmt.Errorf("job schedule %d is cancelled", schedule.ID)}
+	}
+	var nextRun time.Time
+	switch msg.State {
+	case job.ScheduleActive:
+		nextRun = schedule.NextRun
+		if schedule.State != job.ScheduleActive {
+			nextRun = schedule.Next(time.Now())
+		}
+	case job.SchedulePaused, job.ScheduleCancelled:
+	default:
+		return &api.EventResponse{Requestor: requestor, Err: fmt.Errorf("invalid job schedule state %q", msg.State)}
+	}
+	if err := storage.SetJobScheduleState(schedule.ID, msg.State, nextRun); err != nil {
+		return &api.EventResponse{Requestor: requestor, Err: err}
+	}
+	schedule.State, schedule.NextRun = msg.State, nextRun
+	log.Infof("Job schedule %d is now %s", schedule.ID, schedule.State)
+	return &api.EventResponse{Requestor: requestor, Schedules: []*job.Schedule{schedule}}
+}
+
+// runDueSchedules submits the jobs of the active schedules which are due at
+// now. Each run is claimed in the storage layer before the job is submitted,
+// so that the servers sharing it do not submit the same job twice. A run
+// whose job cannot be submitted is skipped.
+func (jm *JobManager) runDueSchedules(now time.Time) {
+	schedules, err := storage.GetJobSchedules()
+	if err != nil {
+		log.Warningf("Could not check the job schedules: %v", err)
+		return
+	}
+	for _, schedule := range schedules {
+		if schedule.State != job.ScheduleActive || schedule.NextRun.IsZero() || schedule.NextRun.After(now) {
+			continue
+		}
+		// keep in step with the recurrence, unless runs were missed
+		following := schedule.Next(schedule.NextRun)
+		if !following.After(now) {
+			following = schedule.Next(now)
+		}
+		claimed, err := storage.ClaimJobScheduleRun(schedule.ID, schedule.NextRun, following)
+		if err != nil {
+			log.Warningf("Could not run job schedule %d: %v", schedule.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		resp := jm.submitJob(api.EventRequestor(schedule.Requestor), schedule.JobDescriptor, schedule.SeriesID())
+		if resp.Err != nil {
+			log.Errorf("Job schedule %d could not submit its job due at %s: %v", schedule.ID, schedule.NextRun, resp.Err)
+			continue
+		}
+		if err := storage.RecordJobScheduleRun(schedule.ID, resp.JobID); err != nil {
+			log.Warningf("%v", err)
+		}
+		log.Infof("Job schedule %d submitted job %s, next job due at %s", schedule.ID, resp.JobID, following)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/facebookincubator/contest/plugins/targetlocker/inmemory"
+	"github.com/insomniacslk/xjson"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateScheduleInvalid(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+	create := func(recurrence job.Recurrence, values map[string]string) error {
+		return jm.createSchedule(&api.Event{Msg: api.EventCreateScheduleMsg{
+			JobDescriptor: parametersJobDescriptor,
+			Recurrence:    recurrence,
+			Parameters:    values,
+		}}).Err
+	}
+	hourly := job.Recurrence{Cron: "@hourly"}
+	require.Error(t, create(job.Recurrence{}, map[string]string{"version": "1.2"}))
+	require.Error(t, create(job.Recurrence{Interval: xjson.Duration(time.Second)}, map[string]string{"version": "1.2"}))
+	require.EqualError(t, create(hourly, nil), "missing values for job parameters: version")
+	require.Error(t, create(job.Recurrence{Cron: "0 0 31 2 *"}, map[string]string{"version": "1.2"}))
+	schedules, err := storage.GetJobSchedules()
+	require.NoError(t, err)
+	require.Empty(t, schedules)
+}
+
+func TestScheduleRuns(t *testing.T) {
+	storage.SetStorage(memory.New())
+	target.SetLocker(inmemory.New(time.Minute))
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+
+	resp := jm.createSchedule(&api.Event{Msg: api.EventCreateScheduleMsg{
+		JobDescriptor: parametersJobDescriptor,
+		Recurrence:    job.Recurrence{Interval: xjson.Duration(time.Hour)},
+		Parameters:    map[string]string{"version": "1.2"},
+	}})
+	require.NoError(t, resp.Err)
+	require.Len(t, resp.Schedules, 1)
+	schedule := resp.Schedules[0]
+	require.Equal(t, job.ScheduleActive, schedule.State)
+	due := schedule.NextRun
+
+	// nothing is due yet
+	jm.runDueSchedules(due.Add(-time.Second))
+	jm.runDueSchedules(due)
+	// the run is claimed, it is not submitted twice
+	jm.runDueSchedules(due)
+	jm.jobsWg.Wait()
+	resp = jm.getSchedule(&api.Event{Msg: api.EventGetScheduleMsg{ScheduleID: schedule.ID}})
+	require.NoError(t, resp.Err)
+	require.Len(t, resp.SeriesJobIDs, 1)
+	schedule = resp.Schedules[0]
+	require.Equal(t, uint64(1), schedule.Runs)
+	require.Equal(t, resp.SeriesJobIDs[0], schedule.LastJobID)
+	require.Equal(t, due.Add(time.Hour), schedule.NextRun)
+	request, err := jm.jobRequestManager.Fetch(schedule.LastJobID)
+	require.NoError(t, err)
+	require.Equal(t, schedule.SeriesID(), request.SeriesID)
+
+	setState := func(state job.ScheduleState) *api.EventResponse {
+		return jm.setScheduleState(&api.Event{Msg: api.EventSetScheduleStateMsg{ScheduleID: schedule.ID, State: state}})
+	}
+	resp = setState(job.SchedulePaused)
+	require.NoError(t, resp.Err)
+	require.True(t, resp.Schedules[0].NextRun.IsZero())
+	jm.runDueSchedules(due.Add(2 * time.Hour))
+	require.Error(t, setState("stopped").Err)
+
+	// missed runs are skipped once resumed
+	resp = setState(job.ScheduleActive)
+	require.NoError(t, resp.Err)
+	require.True(t, resp.Schedules[0].NextRun.After(time.Now()))
+	resp = setState(job.ScheduleCancelled)
+	require.NoError(t, resp.Err)
+	require.Error(t, setState(job.ScheduleActive).Err)
+
+	jobIDs, err := storage.GetJobsBySeriesID(schedule.SeriesID())
+	require.NoError(t, err)
+	require.Len(t, jobIDs, 1)
+}
@@ -6,53 +6,186 @@
 package jobmanager
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/cerrors"
 	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/runner"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/pkg/storage"
 )
 
+// checkExternalID verifies that the external ID is not already used by another
+// job, if external IDs are required to be unique. Events are handled one at a
+// time, so the check cannot race with the submission of another job.
+func (jm *JobManager) checkExternalID(externalID string) error {
+	if !jm.uniqueExternalIDs || externalID == "" {
+		return nil
+	}
+	jobIDs, err := storage.GetJobsByExternalID(externalID)
+	if err != nil {
+		return fmt.Errorf("could not verify uniqueness of external ID: %v", err)
+	}
+	if len(jobIDs) > 0 {
+		return fmt.Errorf("external ID %q is already used by job %s", externalID, jobIDs[0])
+	}
+	return nil
+}
+
 func (jm *JobManager) start(ev *api.Event) *api.EventResponse {
 	msg := ev.Msg.(api.EventStartMsg)
-	j, err := NewJob(jm.pluginRegistry, msg.JobDescriptor)
+	// the values are stored with the descriptor, so that the job can be
+	// resumed or replayed without them
+	jobDescriptor, err := job.SetParameterValues(msg.JobDescriptor, msg.Parameters)
+	if err != nil {
+		return &api.EventResponse{Requestor: ev.Msg.Requestor(), Err: err}
+	}
+	return jm.startJob(ev.Msg.Requestor(), jobDescriptor)
+}
+
+// startJob validates and stores a job request, and runs the job in the
+// background.
+func (jm *JobManager) startJob(requestor api.EventRequestor, jobDescriptor string) *api.EventResponse {
+	return jm.submitJob(requestor, jobDescriptor, "")
+}
+
+// submitJob is like startJob, for a job which is part of a series if seriesID
+// is not empty.
+func (jm *JobManager) submitJob(requestor api.EventRequestor, jobDescriptor, seriesID string) *api.EventResponse {
+	j, err := NewJob(jm.pluginRegistry, jobDescriptor)
 	if err != nil {
 		return &api.EventResponse{Err: err}
 	}
-	// The job descriptor has been validated correctly, now use the JobRequestEmitter
-	// interface to obtain a JobRequest object with a valid id
+	if err := jm.checkExternalID(j.ExternalID); err != nil {
+		return &api.EventResponse{
+			Requestor: requestor,
+			Err:       err,
+		}
+	}
+	if err := jm.checkDependencies(j.DependsOn); err != nil {
+		return &api.EventResponse{
+			Requestor: requestor,
+			Err:       err,
+		}
+	}
+	// only the submissions which passed validation count towards the rate
+	// limit, so that rejected ones don't lock the requestor out
+	if jm.rateLimiter != nil {
+		if retryAfter, ok := jm.rateLimiter.reserve(requestor); !ok {
+			return &api.EventResponse{
+				Requestor: requestor,
+				Err:       &cerrors.ErrSubmissionRateLimited{Requestor: string(requestor), RetryAfter: retryAfter},
+			}
+		}
+	}
+	// The job descriptor has been validated correctly, now allocate the job ID
+	// and use the JobRequestEmitter interface to store the JobRequest object
+	jobID, err := jm.jobIDAllocator.NewJobID()
+	if err != nil {
+		return &api.EventResponse{
+			Requestor: requestor,
+			Err:       fmt.Errorf("could not create job request: %v", err)}
+	}
 	request := job.Request{
+		JobID:         jobID,
 		JobName:       j.Name,
-		Requestor:     string(ev.Msg.Requestor()),
+		ExternalID:    j.ExternalID,
+		SeriesID:      seriesID,
+		Requestor:     string(requestor),
 		RequestTime:   time.Now(),
-		JobDescriptor: msg.JobDescriptor,
+		JobDescriptor: jobDescriptor,
 	}
-	jobID, err := jm.jobRequestManager.Emit(&request)
+	jobID, err = jm.jobRequestManager.Emit(&request)
 	if err != nil {
 		return &api.EventResponse{
-			Requestor: ev.Msg.Requestor(),
+			Requestor: requestor,
 			Err:       fmt.Errorf("could not create job request: %v", err)}
 	}
 	j.ID = jobID
+	if position, queued := jm.acquireSlot(j); queued {
+		log.Infof("Job %s is queued at position %d", j.ID, position)
+		jm.notifyJobState(j.ID, JobStateQueued, nil)
+		return &api.EventResponse{
+			JobID:     j.ID,
+			Requestor: requestor,
+			Status: &job.Status{
+				Name:          j.Name,
+				State:         StatusQueued,
+				QueuePosition: position,
+			},
+		}
+	}
 	if err := jm.emitEvent(j.ID, EventJobStarted); err != nil {
+		jm.releaseSlot()
 		return &api.EventResponse{
-			Requestor: ev.Msg.Requestor(),
+			Requestor: requestor,
 			Err:       err,
 		}
 	}
 
+	jm.runJob(j)
+
+	return &api.EventResponse{
+		JobID:     j.ID,
+		Requestor: requestor,
+		Err:       nil,
+		Status: &job.Status{
+			Name:      j.Name,
+			State:     string(EventJobStarted),
+			StartTime: time.Now(),
+		},
+	}
+}
+
+// runJob runs a job in the background, and records its outcome. The job must
+// hold a slot, which is released when it ends.
+func (jm *JobManager) runJob(j *job.Job) {
+	jobID := j.ID
 	jm.jobsWg.Add(1)
 	go func() {
 		defer jm.jobsWg.Done()
+		defer jm.releaseSlot()
+		defer close(j.Done)
+		metrics.JobRunning(1)
+		defer metrics.JobRunning(-1)
 
 		jm.jobsMu.Lock()
 		jm.jobs[j.ID] = j
 		jm.jobsMu.Unlock()
+		if len(j.DependsOn) > 0 {
+			go jm.watchDependencies(j)
+		}
 
 		start := time.Now()
-		runReports, finalReports, err := jm.jobRunner.Run(j)
+		var (
+			runReports   [][]*job.Report
+			finalReports []*job.Report
+			err          error
+		)
+		// secrets are resolved before any step runs, and only kept in memory
+		if j.SecretStore, err = secrets.ResolveStore(j.Secrets); err != nil {
+			err = fmt.Errorf("could not resolve job secrets: %v", err)
+		} else {
+			runReports, finalReports, err = jm.jobRunner.Run(j)
+		}
 		duration := time.Since(start)
+		var paused *runner.ErrJobPaused
+		if errors.As(err, &paused) {
+			if err := storage.StoreStepState(jobID, paused.Snapshot); err != nil {
+				err = fmt.Errorf("could not persist the progress of paused job: %v", err)
+				_ = jm.emitErrEvent(jobID, EventJobFailed, err)
+				jm.emitVerdict(j, EventJobFailed, err)
+				return
+			}
+			log.Infof("Job %+v paused after %s at test #%d of run #%d", j, duration, paused.Snapshot.TestIndex, paused.Snapshot.RunID)
+			_ = jm.emitEvent(jobID, EventJobPaused)
+			return
+		}
 		// If the Job was cancelled, the error returned by JobRunner indicates whether
 		// the cancellatioon has been successful or failed
 		if j.IsCancelled() {
@@ -60,8 +193,10 @@ func (jm *JobManager) start(ev *api.Event) *api.EventResponse {
 				errCancellation := fmt.Errorf("Job %+v failed cancellation: %v", j, err)
 				log.Error(errCancellation)
 				_ = jm.emitErrEvent(jobID, EventJobCancellationFailed, errCancellation)
+				jm.emitVerdict(j, EventJobCancellationFailed, errCancellation)
 			} else {
 				_ = jm.emitEvent(jobID, EventJobCancelled)
+				jm.emitVerdict(j, EventJobCancelled, nil)
 			}
 			return
 		}
@@ -70,6 +205,7 @@ func (jm *JobManager) start(ev *api.Event) *api.EventResponse {
 			errMsg := fmt.Sprintf("Job %+v failed after %s : %v", j, duration, err)
 			log.Errorf(errMsg)
 			_ = jm.emitErrEvent(jobID, EventJobFailed, err)
+			jm.emitVerdict(j, EventJobFailed, err)
 		} else {
 			// If the JobManager doesn't return any error, the outcome of the Job
 			// might have been any of the following:
@@ -84,6 +220,7 @@ func (jm *JobManager) start(ev *api.Event) *api.EventResponse {
 				eventToEmit = EventJobCompleted
 			}
 			_ = jm.emitEvent(jobID, eventToEmit)
+			jm.emitVerdict(j, eventToEmit, nil)
 		}
 		jobReport := job.JobReport{
 			JobID:        j.ID,
@@ -95,15 +232,4 @@ func (jm *JobManager) start(ev *api.Event) *api.EventResponse {
 			log.Warningf("Could not emit job report: %v", err)
 		}
 	}()
-
-	return &api.EventResponse{
-		JobID:     j.ID,
-		Requestor: ev.Msg.Requestor(),
-		Err:       nil,
-		Status: &job.Status{
-			Name:      j.Name,
-			State:     string(EventJobStarted),
-			StartTime: time.Now(),
-		},
-	}
 }
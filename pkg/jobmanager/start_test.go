@@ -0,0 +1,208 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/jobid"
+	"github.com/facebookincubator/contest/pkg/pluginregistry"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/facebookincubator/contest/plugins/targetlocker/inmemory"
+	"github.com/facebookincubator/contest/plugins/targetmanagers/targetlist"
+	"github.com/stretchr/testify/require"
+)
+
+// submit stores a job request with the given external ID, as the JobManager
+// does when starting a job, after checking the external ID.
+func submit(t *testing.T, jm *JobManager, externalID string) (types.JobID, error) {
+	if err := jm.checkExternalID(externalID); err != nil {
+		return "", err
+	}
+	jobID, err := jm.jobRequestManager.Emit(&job.Request{JobName: "job", ExternalID: externalID})
+	require.NoError(t, err)
+	return jobID, nil
+}
+
+func TestExternalIDNonUnique(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, pluginregistry.NewPluginRegistry())
+	require.NoError(t, err)
+
+	first, err := submit(t, jm, "orchestrator-42")
+	require.NoError(t, err)
+	second, err := submit(t, jm, "orchestrator-42")
+	require.NoError(t, err)
+	_, err = submit(t, jm, "orchestrator-43")
+	require.NoError(t, err)
+
+	jobIDs, err := storage.GetJobsByExternalID("orchestrator-42")
+	require.NoError(t, err)
+	require.Equal(t, []types.JobID{first, second}, jobIDs)
+
+	_, err = storage.GetJobByExternalID("orchestrator-42")
+	require.Error(t, err)
+	multipleErr, ok := err.(*storage.ErrMultipleJobs)
+	require.True(t, ok)
+	require.Equal(t, []types.JobID{first, second}, multipleErr.JobIDs)
+
+	_, err = storage.GetJobByExternalID("unknown")
+	require.Error(t, err)
+}
+
+func TestExternalIDUnique(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, pluginregistry.NewPluginRegistry(), OptionUniqueExternalIDs(true))
+	require.NoError(t, err)
+
+	first, err := submit(t, jm, "orchestrator-42")
+	require.NoError(t, err)
+	_, err = submit(t, jm, "orchestrator-42")
+	require.Error(t, err)
+	// jobs without an external ID are not subject to the uniqueness check
+	_, err = submit(t, jm, "")
+	require.NoError(t, err)
+	_, err = submit(t, jm, "")
+	require.NoError(t, err)
+
+	jobID, err := storage.GetJobByExternalID("orchestrator-42")
+	require.NoError(t, err)
+	require.Equal(t, first, jobID)
+}
+
+func TestStartJobIDAllocator(t *testing.T) {
+	storage.SetStorage(memory.New())
+	target.SetLocker(inmemory.New(time.Minute))
+	jm, err := New(nil, newResumeRegistry(t), OptionJobIDAllocator(jobid.NewUUID()), OptionDependencyPollInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	// the upstream job was stored with a sequential ID
+	upstream := newUpstream(t, jm)
+
+	resp := jm.startJob("test", dependentJobDescriptor(upstream))
+	require.NoError(t, resp.Err)
+	require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, resp.JobID.String())
+	request, err := jm.jobRequestManager.Fetch(resp.JobID)
+	require.NoError(t, err)
+	require.Equal(t, resp.JobID, request.JobID)
+	require.Equal(t, "dependent", request.JobName)
+	// job IDs cannot be reused
+	_, err = jm.jobRequestManager.Emit(&job.Request{JobID: resp.JobID, JobName: "duplicate"})
+	require.Error(t, err)
+
+	require.NoError(t, jm.emitErrEvent(upstream, EventJobFailed, errors.New("upstream failed")))
+	jm.jobsWg.Wait()
+}
+
+// secretsJobDescriptor returns a job descriptor declaring the given secrets,
+// whose first step has the given text parameter.
+func secretsJobDescriptor(secrets, text string) string {
+	descriptor := fmt.Sprintf(resumeJobDescriptor, "echo", "0")
+	descriptor = strings.Replace(descriptor, `"JobName": "resume",`, fmt.Sprintf(`"JobName": "secrets", "secrets": %s,`, secrets), 1)
+	descriptor = strings.Replace(descriptor, `, "Breakpoint": true`, "", 1)
+	return strings.Replace(descriptor, `"text": ["hello"]`, fmt.Sprintf(`"text": [%q]`, text), 1)
+}
+
+func TestNewJobSecrets(t *testing.T) {
+	pr := newResumeRegistry(t)
+	j, err := NewJob(pr, secretsJobDescriptor(`{"pw": "${env:CONTEST_PASSWORD}"}`, "hello"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"pw": "${env:CONTEST_PASSWORD}"}, j.Secrets)
+	require.Nil(t, j.SecretStore)
+
+	// secrets cannot be stored in the descriptor
+	_, err = NewJob(pr, secretsJobDescriptor(`{"pw": "hunter2"}`, "hello"))
+	require.Error(t, err)
+	_, err = NewJob(pr, secretsJobDescriptor(`{"pw": "${env:CONTEST_PASSWORD}"}`, "secret://other"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `undeclared job secret "other"`)
+}
+
+func TestStartJobSecretResolutionFails(t *testing.T) {
+	storage.SetStorage(memory.New())
+	target.SetLocker(inmemory.New(time.Minute))
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+
+	resp := jm.startJob("test", secretsJobDescriptor(`{"pw": "${env:CONTEST_PASSWORD_UNSET}"}`, "secret://pw"))
+	require.NoError(t, resp.Err)
+	jm.jobsWg.Wait()
+
+	events, err := jm.frameworkEvManager.Fetch(
+		frameworkevent.QueryJobID(resp.JobID),
+		frameworkevent.QueryEventNames(JobStateEvents),
+	)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, EventJobFailed, events[1].EventName)
+	require.Contains(t, string(*events[1].Payload), "could not resolve job secrets")
+	// no step ran
+	testEvents, err := storage.NewTestEventFetcher().Fetch(testevent.QueryJobID(resp.JobID))
+	require.NoError(t, err)
+	require.Empty(t, testEvents)
+}
+
+// parametersJobDescriptor declares a required version and an optional host,
+// which the target manager and the steps refer to.
+const parametersJobDescriptor = `{
+	"JobName": "parameters",
+	"Runs": 1,
+	"parameters": {"version": {"description": "firmware version"}, "host": {"default": "t1"}},
+	"TestDescriptors": [{
+		"TargetManagerName": "TargetList",
+		"TargetManagerAcquireParameters": {"Targets": [{"Name": "${param:host}", "ID": "1"}]},
+		"TargetManagerReleaseParameters": {},
+		"TestFetcherName": "literal",
+		"TestFetcherFetchParameters": {"TestName": "test", "Steps": [
+			{"name": "echo", "label": "first", "parameters": {"text": ["flash ${param:version} on {{ .Name }}"]}}
+		]}
+	}],
+	"Reporting": {"RunReporters": [{"Name": "TargetSuccess", "Parameters": {"SuccessExpression": ">0%"}}]}
+}`
+
+func TestStartJobParameters(t *testing.T) {
+	storage.SetStorage(memory.New())
+	target.SetLocker(inmemory.New(time.Minute))
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+	start := func(values map[string]string) *api.EventResponse {
+		return jm.start(&api.Event{Msg: api.EventStartMsg{JobDescriptor: parametersJobDescriptor, Parameters: values}})
+	}
+
+	resp := start(nil)
+	require.EqualError(t, resp.Err, "missing values for job parameters: version")
+	resp = start(map[string]string{"version": "1.2", "hots": "t2"})
+	require.EqualError(t, resp.Err, "unknown job parameters: hots")
+
+	resp = start(map[string]string{"version": `1.2 "beta"`, "host": "t2"})
+	require.NoError(t, resp.Err)
+	jm.jobsWg.Wait()
+	// the stored descriptor holds the values
+	request, err := jm.jobRequestManager.Fetch(resp.JobID)
+	require.NoError(t, err)
+	j, err := NewJob(jm.pluginRegistry, request.JobDescriptor)
+	require.NoError(t, err)
+	require.Equal(t, `flash 1.2 \"beta\" on {{ .Name }}`, j.Tests[0].TestStepsBundles[0].Parameters.GetOne("text").Raw())
+	require.Equal(t, "t2", j.Tests[0].TargetManagerBundle.AcquireParameters.(targetlist.AcquireParameters).Targets[0].Name)
+}
+
+func TestNewJobUndeclaredParameter(t *testing.T) {
+	descriptor, err := job.SetParameterValues(parametersJobDescriptor, map[string]string{"version": "1.2"})
+	require.NoError(t, err)
+	_, err = NewJob(newResumeRegistry(t), strings.Replace(descriptor, "${param:version}", "${param:release}", 1))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `undeclared job parameter "release"`)
+}
@@ -40,6 +40,13 @@ func (jm *JobManager) status(ev *api.Event) *api.EventResponse {
 			Err:       fmt.Errorf("could not fetch events associated to job state: %v", err),
 		}
 	}
+	if queuedJob, position, ok := jm.queuedJob(jobID); ok {
+		return &api.EventResponse{
+			JobID:     jobID,
+			Requestor: ev.Msg.Requestor(),
+			Status:    &job.Status{Name: queuedJob.Name, State: StatusQueued, QueuePosition: position},
+		}
+	}
 	currentJob, ok := jm.jobs[jobID]
 	if !ok {
 		return &api.EventResponse{
@@ -62,11 +69,14 @@ func (jm *JobManager) status(ev *api.Event) *api.EventResponse {
 
 	for _, ev := range jobEvents {
 		if ev.EventName == EventJobStarted {
-			startTime = ev.EmitTime
+			// resumed jobs are started again, keep the first start time
+			if startTime.IsZero() {
+				startTime = ev.EmitTime
+			}
 		} else if _, ok := completionEvents[ev.EventName]; ok {
 			// A completion event has been seen for this Job. Only one completion event can be associated to the job
 			if !endTime.IsZero() {
-				log.Warningf("Job %d is associated to multiple completion events", jobID)
+				log.Warningf("Job %s is associated to multiple completion events", jobID)
 			}
 			endTime = ev.EmitTime
 		}
@@ -100,6 +110,17 @@ func (jm *JobManager) status(ev *api.Event) *api.EventResponse {
 		}
 	}
 	jobStatus.RunStatus = *runStatus
+	runStatuses, err := jm.jobRunner.BuildRunStatuses(currentJob)
+	if err != nil {
+		return &api.EventResponse{
+			JobID:     jobID,
+			Requestor: ev.Msg.Requestor(),
+			Err:       fmt.Errorf("could not rebuild the status of the runs: %v", err),
+		}
+	}
+	summary := job.BuildSummary(runStatuses, currentJob.MinPassPercent)
+	jobStatus.Summary = &summary
+	jobStatus.Results = job.SummarizeResults(runStatuses)
 	return &api.EventResponse{
 		JobID:     jobID,
 		Requestor: ev.Msg.Requestor(),
@@ -16,6 +16,20 @@ import (
 func (jm *JobManager) stop(ev *api.Event) *api.EventResponse {
 	msg := ev.Msg.(api.EventStopMsg)
 	jobID := msg.JobID
+	// a queued job has nothing to wait for
+	if j, ok := jm.dequeue(jobID); ok {
+		_ = jm.emitEvent(jobID, EventJobCancelled)
+		jm.emitVerdict(j, EventJobCancelled, nil)
+		close(j.Done)
+		return &api.EventResponse{
+			JobID:     jobID,
+			Requestor: ev.Msg.Requestor(),
+			Status: &job.Status{
+				Name:  j.Name,
+				State: string(EventJobCancelled),
+			},
+		}
+	}
 	// CancelJob is asynchronous, it closes the Job's cancellation signal which
 	// is propagated all the way down to the TestRunner. TestRunner  will wait
 	// TestRunnerShutdownTimeout before flagging the test as timed out. JobRunner
@@ -0,0 +1,52 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+)
+
+func (jm *JobManager) storeTemplate(ev *api.Event) *api.EventResponse {
+	msg := ev.Msg.(api.EventStoreTemplateMsg)
+	template := job.Template{
+		Name:        msg.Name,
+		Descriptor:  msg.Descriptor,
+		Requestor:   string(ev.Msg.Requestor()),
+		RequestTime: time.Now(),
+	}
+	version, err := storage.StoreJobTemplate(&template)
+	if err != nil {
+		return &api.EventResponse{Requestor: ev.Msg.Requestor(), Err: err}
+	}
+	template.Version = version
+	log.Infof("Stored version %d of job template %s", version, template.Name)
+	return &api.EventResponse{Requestor: ev.Msg.Requestor(), Template: &template}
+}
+
+// startTemplate starts a job with the descriptor rendered from a template.
+// The rendered descriptor is validated and stored like any other.
+func (jm *JobManager) startTemplate(ev *api.Event) *api.EventResponse {
+	msg := ev.Msg.(api.EventStartTemplateMsg)
+	template, err := storage.GetJobTemplate(msg.Name, msg.Version)
+	if err != nil {
+		return &api.EventResponse{Requestor: ev.Msg.Requestor(), Err: err}
+	}
+	jobDescriptor, err := template.Render(msg.Values)
+	if err != nil {
+		return &api.EventResponse{
+			Requestor: ev.Msg.Requestor(),
+			Err:       fmt.Errorf("could not render version %d of job template %s: %v", template.Version, template.Name, err),
+		}
+	}
+	resp := jm.startJob(ev.Msg.Requestor(), jobDescriptor)
+	resp.Template = template
+	return resp
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// templateJobDescriptor is a job template parameterized by the number of runs,
+// the text echoed by the steps and the sleep duration.
+const templateJobDescriptor = `{
+	"JobName": "template",
+	"Runs": ${runs:1},
+	"TestDescriptors": [{
+		"TargetManagerName": "TargetList",
+		"TargetManagerAcquireParameters": {"Targets": [{"Name": "t1", "ID": "1"}]},
+		"TargetManagerReleaseParameters": {},
+		"TestFetcherName": "literal",
+		"TestFetcherFetchParameters": {"TestName": "test", "Steps": [
+			{"name": "echo", "label": "first", "parameters": {"text": ["${text}"]}},
+			{"name": "slowecho", "label": "slow", "parameters": {"text": ["${text}"], "sleep": ["${sleep:0}"]}}
+		]}
+	}],
+	"Reporting": {"RunReporters": [{"Name": "TargetSuccess", "Parameters": {"SuccessExpression": ">0%"}}]}
+}`
+
+func storeTemplate(jm *JobManager, name, descriptor string) *api.EventResponse {
+	return jm.storeTemplate(&api.Event{Msg: api.EventStoreTemplateMsg{Name: name, Descriptor: descriptor}})
+}
+
+func TestStoreTemplateVersions(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+
+	resp := storeTemplate(jm, "echo", templateJobDescriptor)
+	require.NoError(t, resp.Err)
+	require.Equal(t, uint(1), resp.Template.Version)
+	resp = storeTemplate(jm, "echo", strings.Replace(templateJobDescriptor, "${sleep:0}", "${sleep:1}", 1))
+	require.NoError(t, resp.Err)
+	require.Equal(t, uint(2), resp.Template.Version)
+	require.Error(t, storeTemplate(jm, "echo", `{"JobName": "${}"}`).Err)
+
+	// the latest version is used by default
+	latest, err := storage.GetJobTemplate("echo", 0)
+	require.NoError(t, err)
+	require.Equal(t, uint(2), latest.Version)
+	first, err := storage.GetJobTemplate("echo", 1)
+	require.NoError(t, err)
+	require.Equal(t, templateJobDescriptor, first.Descriptor)
+	_, err = storage.GetJobTemplate("echo", 3)
+	require.Error(t, err)
+	_, err = storage.GetJobTemplate("unknown", 0)
+	require.Error(t, err)
+
+	// the rendered descriptors are valid jobs
+	rendered, err := latest.Render(map[string]string{"text": "hello world"})
+	require.NoError(t, err)
+	j, err := NewJob(jm.pluginRegistry, rendered)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", j.Tests[0].TestStepsBundles[0].Parameters.GetOne("text").Raw())
+	require.Equal(t, "1", j.Tests[0].TestStepsBundles[1].Parameters.GetOne("sleep").Raw())
+}
+
+func TestStartTemplateInvalidValues(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+	require.NoError(t, storeTemplate(jm, "echo", templateJobDescriptor).Err)
+
+	startTemplate := func(values map[string]string) error {
+		return jm.startTemplate(&api.Event{Msg: api.EventStartTemplateMsg{Name: "echo", Values: values}}).Err
+	}
+	err = startTemplate(map[string]string{"runs": "2"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "missing values for template parameters: text")
+	// the rendered descriptor is validated as any other
+	require.Error(t, startTemplate(map[string]string{"text": "hello", "runs": "many"}))
+	require.Error(t, startTemplate(map[string]string{"text": "hello", "sleep": "abc"}))
+	require.Error(t, jm.startTemplate(&api.Event{Msg: api.EventStartTemplateMsg{Name: "unknown"}}).Err)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
+	"github.com/facebookincubator/contest/pkg/job"
+)
+
+// emitVerdict builds the verdict of a job which ended in the given state, and
+// stores it as a framework event. Failures are only logged, as the outcome of
+// the job is already known.
+func (jm *JobManager) emitVerdict(j *job.Job, state event.Name, jobErr error) {
+	runStatuses, err := jm.jobRunner.BuildRunStatuses(j)
+	if err != nil {
+		log.Warningf("Could not build the run statuses of job %s, its verdict will not list any target: %v", j.ID, err)
+	}
+	verdict := job.BuildVerdict(j, state, runStatuses, jobErr, time.Now())
+	payload, err := json.Marshal(verdict)
+	if err != nil {
+		log.Warningf("Could not serialize the verdict of job %s: %v", j.ID, err)
+		return
+	}
+	rawPayload := json.RawMessage(payload)
+	ev := frameworkevent.Event{
+		JobID:     j.ID,
+		EventName: job.EventJobVerdict,
+		Payload:   &rawPayload,
+		EmitTime:  time.Now(),
+	}
+	if err := jm.frameworkEvManager.Emit(ev); err != nil {
+		log.Warningf("Could not emit the verdict of job %s: %v", j.ID, err)
+	}
+}
+
+func (jm *JobManager) verdict(ev *api.Event) *api.EventResponse {
+	msg := ev.Msg.(api.EventVerdictMsg)
+	resp := &api.EventResponse{JobID: msg.JobID, Requestor: ev.Msg.Requestor()}
+	if _, err := jm.fetchJobRequest(msg.JobID); err != nil {
+		resp.Err = err
+		return resp
+	}
+	events, err := jm.frameworkEvManager.Fetch(frameworkevent.QueryJobID(msg.JobID), frameworkevent.QueryEventName(job.EventJobVerdict))
+	if err != nil {
+		resp.Err = fmt.Errorf("could not fetch the verdict of job %s: %v", msg.JobID, err)
+		return resp
+	}
+	// a job which is resumed ends again: the last verdict is the current one
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Payload == nil {
+			continue
+		}
+		var verdict job.Verdict
+		if err := json.Unmarshal(*events[i].Payload, &verdict); err != nil {
+			resp.Err = fmt.Errorf("could not decode the verdict of job %s: %v", msg.JobID, err)
+			return resp
+		}
+		resp.Verdict = &verdict
+		break
+	}
+	return resp
+}
@@ -0,0 +1,51 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package jobmanager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerdict(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jm, err := New(nil, newResumeRegistry(t))
+	require.NoError(t, err)
+	a := api.New()
+	defer close(a.Events)
+	serveAPI(jm, a)
+
+	jobID, err := jm.jobRequestManager.Emit(&job.Request{JobName: "verdict"})
+	require.NoError(t, err)
+	resp, err := a.Verdict("test", jobID)
+	require.NoError(t, err)
+	require.NoError(t, resp.Err)
+	require.Nil(t, resp.Data.(api.ResponseDataVerdict).Verdict)
+
+	j := &job.Job{ID: jobID, Name: "verdict"}
+	jm.emitVerdict(j, EventJobFailed, errors.New("first end"))
+	jm.emitVerdict(j, EventJobCompleted, nil)
+	resp, err = a.Verdict("test", jobID)
+	require.NoError(t, err)
+	require.NoError(t, resp.Err)
+	verdict := resp.Data.(api.ResponseDataVerdict).Verdict
+	require.NotNil(t, verdict)
+	// the verdict of the last end of the job is returned
+	require.Equal(t, string(EventJobCompleted), verdict.State)
+	require.True(t, verdict.Success)
+	require.Equal(t, jobID, verdict.JobID)
+	require.Equal(t, job.VerdictSchemaVersion, verdict.SchemaVersion)
+
+	resp, err = a.Verdict("test", jobID+"0")
+	require.NoError(t, err)
+	require.Error(t, resp.Err)
+}
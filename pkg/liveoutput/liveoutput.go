@@ -0,0 +1,173 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package liveoutput lets test steps publish the output of the commands they
+// run on a target while they run, so that it can be streamed to API clients.
+// Sinks only live in the process running the job, and are discarded when the
+// step completes for the target.
+package liveoutput
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// Key identifies the live output of a test step for a target.
+type Key struct {
+	JobID     types.JobID
+	TargetID  string
+	StepLabel string
+}
+
+const (
+	// backlogSize is the amount of most recent output replayed to readers
+	// which subscribe after the step started writing.
+	backlogSize = 64 * 1024
+	// readerQueueSize is the number of chunks queued for a reader. Readers
+	// which fall behind by more are disconnected, so that they never block
+	// the step.
+	readerQueueSize = 256
+)
+
+// ErrNoOutput is returned by Subscribe when no step is writing live output for
+// the key.
+var ErrNoOutput = errors.New("no live output")
+
+var (
+	sinksLock sync.Mutex
+	sinks     = make(map[Key]*Sink)
+)
+
+// Sink receives the live output of a test step for a target, and forwards it
+// to the readers. It is safe for concurrent use.
+type Sink struct {
+	key     Key
+	lock    sync.Mutex
+	backlog []byte
+	readers map[*Reader]struct{}
+	closed  bool
+}
+
+// Open registers and returns the sink for the given key. An existing sink
+// for the same key is closed first. The caller must close the sink when done.
+func Open(key Key) *Sink {
+	s := &Sink{key: key, readers: make(map[*Reader]struct{})}
+	sinksLock.Lock()
+	prev := sinks[key]
+	sinks[key] = s
+	sinksLock.Unlock()
+	if prev != nil {
+		prev.close()
+	}
+	return s
+}
+
+// Write forwards the output to the readers. Writing to a closed sink
+// discards the output.
+func (s *Sink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	chunk := append([]byte(nil), p...)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return len(p), nil
+	}
+	s.backlog = append(s.backlog, chunk...)
+	if len(s.backlog) > backlogSize {
+		s.backlog = append([]byte(nil), s.backlog[len(s.backlog)-backlogSize:]...)
+	}
+	for r := range s.readers {
+		select {
+		case r.ch <- chunk:
+		default:
+			r.lagged = true
+			s.removeReader(r)
+		}
+	}
+	return len(p), nil
+}
+
+// Close unregisters the sink, and ends the streams of its readers.
+func (s *Sink) Close() error {
+	sinksLock.Lock()
+	if sinks[s.key] == s {
+		delete(sinks, s.key)
+	}
+	sinksLock.Unlock()
+	s.close()
+	return nil
+}
+
+func (s *Sink) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for r := range s.readers {
+		s.removeReader(r)
+	}
+}
+
+// removeReader must be called with the sink lock held.
+func (s *Sink) removeReader(r *Reader) {
+	delete(s.readers, r)
+	close(r.ch)
+}
+
+// Reader is a subscription to the live output of a step for a target.
+type Reader struct {
+	sink   *Sink
+	ch     chan []byte
+	lagged bool
+}
+
+// Subscribe returns a reader of the live output for the given key. The
+// output written so far, up to a limit, is delivered first.
+func Subscribe(key Key) (*Reader, error) {
+	sinksLock.Lock()
+	s := sinks[key]
+	sinksLock.Unlock()
+	if s == nil {
+		return nil, ErrNoOutput
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil, ErrNoOutput
+	}
+	r := &Reader{sink: s, ch: make(chan []byte, readerQueueSize)}
+	if len(s.backlog) > 0 {
+		r.ch <- append([]byte(nil), s.backlog...)
+	}
+	s.readers[r] = struct{}{}
+	return r, nil
+}
+
+// Chunks returns the channel of the output chunks. It is closed when the step
+// completes for the target, when the reader falls behind, or when the reader
+// is closed.
+func (r *Reader) Chunks() <-chan []byte {
+	return r.ch
+}
+
+// Lagged tells whether the reader was disconnected because it did not keep up
+// with the output.
+func (r *Reader) Lagged() bool {
+	r.sink.lock.Lock()
+	defer r.sink.lock.Unlock()
+	return r.lagged
+}
+
+// Close unsubscribes the reader.
+func (r *Reader) Close() {
+	r.sink.lock.Lock()
+	defer r.sink.lock.Unlock()
+	if _, ok := r.sink.readers[r]; ok {
+		r.sink.removeReader(r)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package liveoutput
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(r *Reader) []byte {
+	var out []byte
+	for chunk := range r.Chunks() {
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+func TestLiveOutputReaders(t *testing.T) {
+	key := Key{JobID: types.JobID("1"), TargetID: "host1", StepLabel: "step"}
+	_, err := Subscribe(key)
+	require.Equal(t, ErrNoOutput, err)
+
+	s := Open(key)
+	_, err = s.Write([]byte("early "))
+	require.NoError(t, err)
+	r1, err := Subscribe(key)
+	require.NoError(t, err)
+	r2, err := Subscribe(key)
+	require.NoError(t, err)
+	_, err = s.Write([]byte("late"))
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// both readers get the backlog, then the new output
+	require.Equal(t, "early late", string(readAll(r1)))
+	require.Equal(t, "early late", string(readAll(r2)))
+	require.False(t, r1.Lagged())
+
+	_, err = Subscribe(key)
+	require.Equal(t, ErrNoOutput, err)
+	// writes after close are discarded
+	n, err := s.Write([]byte("discarded"))
+	require.NoError(t, err)
+	require.Equal(t, 9, n)
+}
+
+func TestLiveOutputReaderClose(t *testing.T) {
+	key := Key{JobID: types.JobID("2"), TargetID: "host1", StepLabel: "step"}
+	s := Open(key)
+	defer s.Close()
+	r, err := Subscribe(key)
+	require.NoError(t, err)
+	r.Close()
+	_, err = s.Write([]byte("output"))
+	require.NoError(t, err)
+	require.Empty(t, readAll(r))
+	r.Close()
+}
+
+func TestLiveOutputSlowReader(t *testing.T) {
+	key := Key{JobID: types.JobID("3"), TargetID: "host1", StepLabel: "step"}
+	s := Open(key)
+	defer s.Close()
+	r, err := Subscribe(key)
+	require.NoError(t, err)
+	for i := 0; i <= readerQueueSize; i++ {
+		_, err := s.Write([]byte("x"))
+		require.NoError(t, err)
+	}
+	require.Len(t, readAll(r), readerQueueSize)
+	require.True(t, r.Lagged())
+}
+
+func TestLiveOutputBacklog(t *testing.T) {
+	key := Key{JobID: types.JobID("4"), TargetID: "host1", StepLabel: "step"}
+	s := Open(key)
+	defer s.Close()
+	_, err := s.Write(bytes.Repeat([]byte("a"), backlogSize))
+	require.NoError(t, err)
+	_, err = s.Write([]byte("end"))
+	require.NoError(t, err)
+	r, err := Subscribe(key)
+	require.NoError(t, err)
+	chunk := <-r.Chunks()
+	require.Len(t, chunk, backlogSize)
+	require.True(t, bytes.HasSuffix(chunk, []byte("aend")))
+}
+
+func TestLiveOutputReopen(t *testing.T) {
+	key := Key{JobID: types.JobID("5"), TargetID: "host1", StepLabel: "step"}
+	s1 := Open(key)
+	r, err := Subscribe(key)
+	require.NoError(t, err)
+	s2 := Open(key)
+	defer s2.Close()
+	// the readers of the previous sink are done, and closing it does not
+	// unregister the new one
+	require.Empty(t, readAll(r))
+	require.NoError(t, s1.Close())
+	_, err = Subscribe(key)
+	require.NoError(t, err)
+}
@@ -0,0 +1,232 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package metrics implements the registry of the metrics exposed by ConTest,
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Type is the type of a metric family.
+type Type string
+
+// The supported metric types.
+const (
+	// Counter is a value which is only incremented.
+	Counter Type = "counter"
+	// Summary tracks the count and the sum of the observed values.
+	Summary Type = "summary"
+	// Gauge is a value which goes up and down.
+	Gauge Type = "gauge"
+)
+
+// Limits bounding the cardinality of the metrics.
+const (
+	// MaxLabels is the maximum number of labels of a metric.
+	MaxLabels = 10
+	// MaxLabelValueLength is the maximum length of a label value.
+	MaxLabelValueLength = 128
+	// DefaultMaxSeries is the maximum number of label sets of a metric family
+	// in the default registry.
+	DefaultMaxSeries = 1000
+)
+
+var (
+	nameRe  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Labels are the labels of a sample, by name.
+type Labels map[string]string
+
+// names returns the sorted names of the labels.
+func (l Labels) names() []string {
+	names := make([]string, 0, len(l))
+	for k := range l {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validate checks the names and values of the labels.
+func (l Labels) validate() error {
+	if len(l) > MaxLabels {
+		return fmt.Errorf("too many labels: %d, the maximum is %d", len(l), MaxLabels)
+	}
+	for k, v := range l {
+		if !labelRe.MatchString(k) || strings.HasPrefix(k, "__") {
+			return fmt.Errorf("invalid label name %q", k)
+		}
+		if len(v) > MaxLabelValueLength {
+			return fmt.Errorf("value of label %q is longer than %d bytes", k, MaxLabelValueLength)
+		}
+	}
+	return nil
+}
+
+// series is the state of a metric for a label set.
+type series struct {
+	labels Labels
+	count  uint64
+	sum    float64
+}
+
+// family is a metric and its series. All the series of a family have the
+// same label names.
+type family struct {
+	typ        Type
+	labelNames []string
+	series     map[string]*series
+}
+
+// Registry holds the metrics. It is safe for concurrent use.
+type Registry struct {
+	mu        sync.Mutex
+	maxSeries int
+	families  map[string]*family
+}
+
+// NewRegistry returns an empty Registry, where each metric family has at most
+// maxSeries label sets.
+func NewRegistry(maxSeries int) *Registry {
+	return &Registry{maxSeries: maxSeries, families: make(map[string]*family)}
+}
+
+// Default is the registry of the ConTest server.
+var Default = NewRegistry(DefaultMaxSeries)
+
+// Inc increments a counter.
+func (r *Registry) Inc(name string, labels Labels) error {
+	return r.record(name, Counter, 1, labels)
+}
+
+// Observe adds a value to a summary.
+func (r *Registry) Observe(name string, value float64, labels Labels) error {
+	return r.record(name, Summary, value, labels)
+}
+
+// Add adds delta, which may be negative, to a gauge.
+func (r *Registry) Add(name string, delta float64, labels Labels) error {
+	return r.record(name, Gauge, delta, labels)
+}
+
+func (r *Registry) record(name string, typ Type, value float64, labels Labels) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+	if err := labels.validate(); err != nil {
+		return fmt.Errorf("invalid labels of metric %s: %v", name, err)
+	}
+	labelNames := labels.names()
+	key := seriesKey(labelNames, labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{typ: typ, labelNames: labelNames, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	if f.typ != typ {
+		return fmt.Errorf("metric %s is a %s, not a %s", name, f.typ, typ)
+	}
+	if strings.Join(f.labelNames, ",") != strings.Join(labelNames, ",") {
+		return fmt.Errorf("labels of metric %s must be [%s], got [%s]", name, strings.Join(f.labelNames, ", "), strings.Join(labelNames, ", "))
+	}
+	s, ok := f.series[key]
+	if !ok {
+		if len(f.series) >= r.maxSeries {
+			return fmt.Errorf("metric %s has reached the maximum of %d label sets", name, r.maxSeries)
+		}
+		copied := make(Labels, len(labels))
+		for k, v := range labels {
+			copied[k] = v
+		}
+		s = &series{labels: copied}
+		f.series[key] = s
+	}
+	s.count++
+	s.sum += value
+	return nil
+}
+
+// seriesKey identifies a label set within a family.
+func seriesKey(names []string, labels Labels) string {
+	var sb strings.Builder
+	for _, k := range names {
+		sb.WriteString(strconv.Quote(labels[k]))
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
+
+// escapeLabelValue escapes a label value as required by the text format.
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(v)
+}
+
+func formatLabels(names []string, labels Labels) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names))
+	for _, k := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, escapeLabelValue(labels[k])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Encode serializes the metrics in the text exposition format, sorted by
+// name and labels.
+func (r *Registry) Encode() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", name, f.typ)
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := f.series[k]
+			labels := formatLabels(f.labelNames, s.labels)
+			switch f.typ {
+			case Counter, Gauge:
+				fmt.Fprintf(&sb, "%s%s %s\n", name, labels, formatValue(s.sum))
+			case Summary:
+				fmt.Fprintf(&sb, "%s_sum%s %s\n", name, labels, formatValue(s.sum))
+				fmt.Fprintf(&sb, "%s_count%s %d\n", name, labels, s.count)
+			}
+		}
+	}
+	return []byte(sb.String())
+}
+
+// ServeHTTP serves the metrics to Prometheus.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write(r.Encode())
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryEncode(t *testing.T) {
+	r := NewRegistry(DefaultMaxSeries)
+	require.NoError(t, r.Inc("requests_total", Labels{"code": "200"}))
+	require.NoError(t, r.Inc("requests_total", Labels{"code": "200"}))
+	require.NoError(t, r.Inc("requests_total", Labels{"code": "500"}))
+	require.NoError(t, r.Observe("latency_seconds", 0.5, nil))
+	require.NoError(t, r.Observe("latency_seconds", 1.25, nil))
+	require.Equal(t, strings.Join([]string{
+		"# TYPE latency_seconds summary",
+		"latency_seconds_sum 1.75",
+		"latency_seconds_count 2",
+		"# TYPE requests_total counter",
+		`requests_total{code="200"} 2`,
+		`requests_total{code="500"} 1`,
+		"",
+	}, "\n"), string(r.Encode()))
+}
+
+func TestRegistryValidation(t *testing.T) {
+	r := NewRegistry(2)
+	require.Error(t, r.Inc("bad-name", nil))
+	require.Error(t, r.Inc("ok", Labels{"__reserved": "x"}))
+	require.Error(t, r.Inc("ok", Labels{"long": strings.Repeat("x", MaxLabelValueLength+1)}))
+
+	require.NoError(t, r.Inc("ok", Labels{"a": "1"}))
+	// the label names and the type of a metric are fixed by its first use
+	require.Error(t, r.Inc("ok", Labels{"b": "1"}))
+	require.Error(t, r.Observe("ok", 1, Labels{"a": "1"}))
+	// the number of label sets is bounded
+	require.NoError(t, r.Inc("ok", Labels{"a": "2"}))
+	require.Error(t, r.Inc("ok", Labels{"a": "3"}))
+	require.NoError(t, r.Inc("ok", Labels{"a": "1"}))
+}
+
+func TestStepMetrics(t *testing.T) {
+	r := NewRegistry(DefaultMaxSeries)
+	m := NewStepMetrics(r, "7", "fetch")
+	require.NoError(t, m.Observe("bytes", 100, map[string]string{"kind": "image"}))
+	require.NoError(t, m.Inc("retries", nil))
+	require.Error(t, m.Inc("retries", map[string]string{StepLabel: "other"}))
+	encoded := string(r.Encode())
+	require.Contains(t, encoded, `contest_step_bytes_sum{job_id="7",kind="image",step="fetch"} 100`)
+	require.Contains(t, encoded, `contest_step_retries{job_id="7",step="fetch"} 1`)
+}
+
+func TestGauge(t *testing.T) {
+	r := NewRegistry(DefaultMaxSeries)
+	require.NoError(t, r.Add("running", 3, nil))
+	require.NoError(t, r.Add("running", -1, nil))
+	require.Error(t, r.Inc("running", nil))
+	require.Equal(t, "# TYPE running gauge\nrunning 2\n", string(r.Encode()))
+}
+
+func TestServerMetrics(t *testing.T) {
+	r := NewRegistry(DefaultMaxSeries)
+	// nothing is recorded until a registry is set
+	JobState("running")
+	SetServerRegistry(r)
+	defer SetServerRegistry(nil)
+	JobState("running")
+	JobState("completed")
+	JobRunning(1)
+	JobQueued(2)
+	JobQueued(-1)
+	TargetLocks(2)
+	TargetLocks(-1)
+	StorageQuery("get_job_request", time.Now(), nil)
+	StorageQuery("get_job_request", time.Now(), errors.New("unreachable"))
+	encoded := string(r.Encode())
+	require.Contains(t, encoded, `contest_jobs_total{state="running"} 1`)
+	require.Contains(t, encoded, `contest_jobs_total{state="completed"} 1`)
+	require.Contains(t, encoded, "contest_jobs_running 1\n")
+	require.Contains(t, encoded, "contest_jobs_queued 1\n")
+	require.Contains(t, encoded, "contest_targets_locked 1\n")
+	require.Contains(t, encoded, `contest_storage_query_seconds_count{operation="get_job_request"} 2`)
+	require.Contains(t, encoded, `contest_storage_errors_total{operation="get_job_request"} 1`)
+}
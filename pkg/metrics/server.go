@@ -0,0 +1,128 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// The metrics of the server internals. They have no job label, so that their
+// cardinality does not grow with the number of jobs.
+const (
+	// JobsTotal counts the jobs entering each state, by state.
+	JobsTotal = "contest_jobs_total"
+	// JobsRunning is the number of jobs running on the server.
+	JobsRunning = "contest_jobs_running"
+	// JobsQueued is the number of jobs waiting for the limit of concurrent
+	// jobs of the server.
+	JobsQueued = "contest_jobs_queued"
+	// StepTargetsTotal counts the targets leaving the test steps, by step
+	// label and result.
+	StepTargetsTotal = "contest_runner_step_targets_total"
+	// StepTargetSeconds is the time the targets spend in the test steps, by
+	// step label.
+	StepTargetSeconds = "contest_runner_step_target_seconds"
+	// TargetsLocked is the number of targets locked by the jobs of the
+	// server.
+	TargetsLocked = "contest_targets_locked"
+	// StorageQuerySeconds is the latency of the queries to the storage
+	// engine, by operation.
+	StorageQuerySeconds = "contest_storage_query_seconds"
+	// StorageErrorsTotal counts the failed queries to the storage engine, by
+	// operation.
+	StorageErrorsTotal = "contest_storage_errors_total"
+	// EventsEmittedTotal counts the emitted events, by kind.
+	EventsEmittedTotal = "contest_events_emitted_total"
+)
+
+// The kinds of events counted by EventsEmittedTotal.
+const (
+	TestEvents      = "test"
+	FrameworkEvents = "framework"
+)
+
+// server holds the *Registry recording the metrics of the server internals.
+var server atomic.Value
+
+// SetServerRegistry makes r record the metrics of the server internals. They
+// are not recorded until it is called, or after it is called with nil.
+func SetServerRegistry(r *Registry) {
+	server.Store(r)
+}
+
+// record calls f with the registry of the server metrics, if any. Errors are
+// ignored, as they can only report a step label set which is too large, and
+// the internals must not fail because of their metrics.
+func record(f func(r *Registry) error) {
+	if r, _ := server.Load().(*Registry); r != nil {
+		_ = f(r)
+	}
+}
+
+// JobState counts a job entering a state.
+func JobState(state string) {
+	record(func(r *Registry) error {
+		return r.Inc(JobsTotal, Labels{"state": state})
+	})
+}
+
+// JobRunning adds delta to the number of running jobs.
+func JobRunning(delta int) {
+	record(func(r *Registry) error {
+		return r.Add(JobsRunning, float64(delta), nil)
+	})
+}
+
+// JobQueued adds delta to the number of queued jobs.
+func JobQueued(delta int) {
+	record(func(r *Registry) error {
+		return r.Add(JobsQueued, float64(delta), nil)
+	})
+}
+
+// StepTarget records a target which spent d in a test step, and left it with
+// or without an error.
+func StepTarget(stepLabel string, d time.Duration, failed bool) {
+	result := "pass"
+	if failed {
+		result = "fail"
+	}
+	record(func(r *Registry) error {
+		if err := r.Inc(StepTargetsTotal, Labels{"step": stepLabel, "result": result}); err != nil {
+			return err
+		}
+		return r.Observe(StepTargetSeconds, d.Seconds(), Labels{"step": stepLabel})
+	})
+}
+
+// TargetLocks adds delta to the number of locked targets.
+func TargetLocks(delta int) {
+	record(func(r *Registry) error {
+		return r.Add(TargetsLocked, float64(delta), nil)
+	})
+}
+
+// StorageQuery records a query to the storage engine, which started at start
+// and returned err.
+func StorageQuery(op string, start time.Time, err error) {
+	d := time.Since(start)
+	record(func(r *Registry) error {
+		if err != nil {
+			if err := r.Inc(StorageErrorsTotal, Labels{"operation": op}); err != nil {
+				return err
+			}
+		}
+		return r.Observe(StorageQuerySeconds, d.Seconds(), Labels{"operation": op})
+	})
+}
+
+// EventEmitted counts an event of the given kind.
+func EventEmitted(kind string) {
+	record(func(r *Registry) error {
+		return r.Inc(EventsEmittedTotal, Labels{"kind": kind})
+	})
+}
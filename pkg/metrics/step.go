@@ -0,0 +1,66 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// StepPrefix is prepended to the names of the metrics of the test steps.
+const StepPrefix = "contest_step_"
+
+// The labels added to the metrics of the test steps.
+const (
+	JobLabel  = "job_id"
+	StepLabel = "step"
+)
+
+// StepMetrics records the metrics of a test step of a job. It implements
+// test.Metrics.
+type StepMetrics struct {
+	registry *Registry
+	jobID    types.JobID
+	step     string
+}
+
+// NewStepMetrics returns the StepMetrics of a test step, recording into r.
+func NewStepMetrics(r *Registry, jobID types.JobID, stepLabel string) *StepMetrics {
+	return &StepMetrics{registry: r, jobID: jobID, step: stepLabel}
+}
+
+// Inc increments the counter contest_step_<name>.
+func (m *StepMetrics) Inc(name string, labels map[string]string) error {
+	l, err := m.labels(labels)
+	if err != nil {
+		return err
+	}
+	return m.registry.Inc(StepPrefix+name, l)
+}
+
+// Observe adds a value to the summary contest_step_<name>.
+func (m *StepMetrics) Observe(name string, value float64, labels map[string]string) error {
+	l, err := m.labels(labels)
+	if err != nil {
+		return err
+	}
+	return m.registry.Observe(StepPrefix+name, value, l)
+}
+
+// labels returns the labels of the step with the job and step ones added.
+func (m *StepMetrics) labels(labels map[string]string) (Labels, error) {
+	l := make(Labels, len(labels)+2)
+	for k, v := range labels {
+		if k == JobLabel || k == StepLabel {
+			return nil, fmt.Errorf("label %q is reserved", k)
+		}
+		l[k] = v
+	}
+	l[JobLabel] = string(m.jobID)
+	l[StepLabel] = m.step
+	return l, nil
+}
@@ -7,6 +7,7 @@ package pluginregistry
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/job"
@@ -20,18 +21,59 @@ func (r *PluginRegistry) NewTestStepBundle(testStepDescriptor test.TestStepDescr
 	if err != nil {
 		return nil, fmt.Errorf("could not get the desired TestStep (%s): %v", testStepDescriptor.Name, err)
 	}
-	if err := testStep.ValidateParameters(testStepDescriptor.Parameters); err != nil {
+	params := testStepDescriptor.Parameters
+	if s, ok := testStep.(test.ParameterSchema); ok {
+		if params, err = validateSchema(s.ParameterSchema(), params); err != nil {
+			return nil, fmt.Errorf("could not validate parameters for test step %s: %w", testStepDescriptor.Name, err)
+		}
+	}
+	if err := testStep.ValidateParameters(params); err != nil {
 		return nil, fmt.Errorf("could not validate parameters for test step %s: %v", testStepDescriptor.Name, err)
 	}
 	label := testStepDescriptor.Label
 	if label == "" {
 		return nil, ErrStepLabelIsMandatory{TestStepDescriptor: testStepDescriptor}
 	}
+	if testStepDescriptor.Retry != nil {
+		if err := testStepDescriptor.Retry.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid retry policy for test step %s: %v", label, err)
+		}
+	}
+	if batch := testStepDescriptor.Batch; batch != nil {
+		if err := batch.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid batch policy for test step %s: %v", label, err)
+		}
+		if _, ok := testStep.(test.BatchTestStep); !ok {
+			return nil, fmt.Errorf("test step %s (%s) does not support batches", label, testStepDescriptor.Name)
+		}
+		// batches would never fill up if the step holds fewer targets
+		if max := testStepDescriptor.MaxInFlight; max > 0 && batch.Window == 0 && (batch.Size == 0 || batch.Size > max) {
+			return nil, fmt.Errorf("batches of test step %s need a window, or a size not larger than max_in_flight", label)
+		}
+	}
+	if testStepDescriptor.Timeout < 0 || testStepDescriptor.TargetTimeout < 0 {
+		return nil, fmt.Errorf("timeouts of test step %s cannot be negative", label)
+	}
+	var condition *test.Condition
+	if testStepDescriptor.Condition != "" {
+		if condition, err = test.ParseCondition(testStepDescriptor.Condition); err != nil {
+			return nil, fmt.Errorf("invalid condition for test step %s: %v", label, err)
+		}
+	}
 	testStepBundle := test.TestStepBundle{
-		TestStep:      testStep,
-		TestStepLabel: label,
-		Parameters:    testStepDescriptor.Parameters,
-		AllowedEvents: allowedEvents,
+		TestStep:         testStep,
+		TestStepLabel:    label,
+		Parameters:       params,
+		AllowedEvents:    allowedEvents,
+		Breakpoint:       testStepDescriptor.Breakpoint,
+		OrderIndependent: testStepDescriptor.OrderIndependent,
+		MaxInFlight:      testStepDescriptor.MaxInFlight,
+		Retry:            testStepDescriptor.Retry,
+		Timeout:          time.Duration(testStepDescriptor.Timeout),
+		TargetTimeout:    time.Duration(testStepDescriptor.TargetTimeout),
+		OnFailure:        testStepDescriptor.OnFailure,
+		Batch:            testStepDescriptor.Batch,
+		Condition:        condition,
 	}
 	return &testStepBundle, nil
 }
@@ -0,0 +1,64 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package pluginregistry
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+)
+
+// LoadSymbol is the name of the function that shared object plugins must
+// export. Its signature must match one of the loaders of the plugin types,
+// e.g. test.TestStepLoader for test steps.
+const LoadSymbol = "Load"
+
+// RegisterPluginFile opens a Go plugin (a shared object built with
+// `go build -buildmode=plugin`), and registers the plugin returned by its
+// exported Load function. The plugin must be built with the same version of
+// ConTest and of its dependencies as the server.
+func (r *PluginRegistry) RegisterPluginFile(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open plugin %s: %v", path, err)
+	}
+	sym, err := p.Lookup(LoadSymbol)
+	if err != nil {
+		return fmt.Errorf("plugin %s does not export %s: %v", path, LoadSymbol, err)
+	}
+	switch load := sym.(type) {
+	case func() (string, test.TestStepFactory, []event.Name):
+		name, factory, events := load()
+		if factory == nil || factory() == nil {
+			return fmt.Errorf("plugin %s: test step %s has no valid factory", path, name)
+		}
+		return r.RegisterTestStep(name, factory, events)
+	case func() (string, target.TargetManagerFactory):
+		name, factory := load()
+		if factory == nil || factory() == nil {
+			return fmt.Errorf("plugin %s: target manager %s has no valid factory", path, name)
+		}
+		return r.RegisterTargetManager(name, factory)
+	case func() (string, test.TestFetcherFactory):
+		name, factory := load()
+		if factory == nil || factory() == nil {
+			return fmt.Errorf("plugin %s: test fetcher %s has no valid factory", path, name)
+		}
+		return r.RegisterTestFetcher(name, factory)
+	case func() (string, job.ReporterFactory):
+		name, factory := load()
+		if factory == nil || factory() == nil {
+			return fmt.Errorf("plugin %s: reporter %s has no valid factory", path, name)
+		}
+		return r.RegisterReporter(name, factory)
+	default:
+		return fmt.Errorf("plugin %s: %s has unsupported type %T", path, LoadSymbol, sym)
+	}
+}
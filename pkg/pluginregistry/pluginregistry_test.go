@@ -6,12 +6,21 @@
 package pluginregistry
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/facebookincubator/contest/pkg/cerrors"
 	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
 	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/targetlocker/noop"
 
 	"github.com/stretchr/testify/require"
 )
@@ -37,7 +46,7 @@ func (e AStep) Name() string {
 }
 
 // Run executes the AStep
-func (e AStep) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+func (e AStep) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
 	return nil
 }
 
@@ -47,7 +56,7 @@ func (e AStep) CanResume() bool {
 }
 
 // Resume tries to resume AStep
-func (e AStep) Resume(cancel, pause <-chan struct{}, _ test.TestStepChannels, _ test.TestStepParameters, ev testevent.EmitterFetcher) error {
+func (e AStep) Resume(ctx context.Context, pause <-chan struct{}, _ test.TestStepChannels, _ test.TestStepParameters, ev testevent.EmitterFetcher) error {
 	return &cerrors.ErrResumeNotSupported{StepName: "AStep"}
 }
 
@@ -62,3 +71,102 @@ func TestRegisterTestStepDoesNotValidate(t *testing.T) {
 	err := pr.RegisterTestStep("AStep", NewAStep, []event.Name{event.Name("Event which does not validate")})
 	require.Error(t, err)
 }
+
+func TestRegisterPluginFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building a Go plugin is slow, skipping in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not available")
+	}
+	dir, err := ioutil.TempDir("", "pluginregistry")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sampleplugin.so")
+	// the plugin must be built with the same flags as the test binary, or
+	// loading it fails
+	args := []string{"build", "-buildmode=plugin"}
+	if raceEnabled {
+		args = append(args, "-race")
+	}
+	cmd := exec.Command(goBin, append(args, "-o", path, "./testdata/sampleplugin")...)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	pr := NewPluginRegistry()
+	require.NoError(t, pr.RegisterPluginFile(path))
+	step, err := pr.NewTestStep("SampleSlowEcho")
+	require.NoError(t, err)
+	require.Equal(t, "SlowEcho", step.Name())
+	// the same plugin cannot be registered twice
+	require.Error(t, pr.RegisterPluginFile(path))
+
+	require.Error(t, pr.RegisterPluginFile(filepath.Join(dir, "missing.so")))
+}
+
+func TestRegisterTargetLocker(t *testing.T) {
+	pr := NewPluginRegistry()
+	require.NoError(t, pr.RegisterTargetLocker(noop.Load()))
+	require.Error(t, pr.RegisterTargetLocker(noop.Load()))
+	tl, err := pr.NewTargetLocker("NOOP", time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, tl)
+	_, err = pr.NewTargetLocker("etcd", time.Second)
+	require.Error(t, err)
+}
+
+// SchemaStep is a dummy TestStep declaring its parameters.
+type SchemaStep struct {
+	AStep
+	schema test.Schema
+}
+
+// ParameterSchema returns the schema of the SchemaStep
+func (e SchemaStep) ParameterSchema() test.Schema {
+	return e.schema
+}
+
+func TestParameterSchema(t *testing.T) {
+	schema := test.Schema{
+		Version: 2,
+		Parameters: []test.ParameterDefinition{
+			{Name: "host", Type: test.TypeString, Required: true},
+			{Name: "retries", Type: test.TypeInt, Default: "3"},
+		},
+	}
+	pr := NewPluginRegistry()
+	require.NoError(t, pr.RegisterTestStep("SchemaStep", func() test.TestStep { return &SchemaStep{schema: schema} }, nil))
+	require.NoError(t, pr.RegisterTestStep("AStep", NewAStep, nil))
+
+	desc := test.TestStepDescriptor{
+		Name:  "SchemaStep",
+		Label: "schema",
+		Parameters: test.TestStepParameters{
+			"host": []test.Param{*test.NewParam("a")},
+			// passed to every step by the job manager
+			job.ConnectTimeoutParameter: []test.Param{*test.NewParam("1s")},
+		},
+	}
+	bundle, err := pr.NewTestStepBundle(desc, 1, nil)
+	require.NoError(t, err)
+	require.Equal(t, "3", bundle.Parameters.GetOne("retries").Raw())
+
+	desc.Parameters = test.TestStepParameters{"retries": []test.Param{*test.NewParam("x")}}
+	_, err = pr.NewTestStepBundle(desc, 1, nil)
+	var errs test.ParameterErrors
+	require.True(t, errors.As(err, &errs))
+	require.Equal(t, test.ParameterErrors{
+		{Path: "host", Msg: "missing required parameter"},
+		{Path: "retries[0]", Msg: `invalid int "x"`},
+	}, errs)
+
+	plugins := pr.Plugins()
+	require.Equal(t, []PluginInfo{
+		{Kind: KindTestStep, Name: "astep"},
+		{Kind: KindTestStep, Name: "schemastep", Schema: &schema},
+	}, plugins)
+
+	schema.Parameters = append(schema.Parameters, schema.Parameters[0])
+	require.Error(t, pr.RegisterTestStep("BadStep", func() test.TestStep { return &SchemaStep{schema: schema} }, nil))
+}
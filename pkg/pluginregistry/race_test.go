@@ -0,0 +1,12 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build race
+
+package pluginregistry
+
+// raceEnabled tells whether the tests run with the race detector, which
+// plugins loaded by them must be built with too.
+const raceEnabled = true
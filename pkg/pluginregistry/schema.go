@@ -0,0 +1,107 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package pluginregistry
+
+import (
+	"sort"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/test"
+)
+
+// The kinds of plugins listed by Plugins.
+const (
+	KindTargetManager = "TargetManager"
+	KindTestFetcher   = "TestFetcher"
+	KindTestStep      = "TestStep"
+	KindReporter      = "Reporter"
+	KindTargetLocker  = "TargetLocker"
+)
+
+// PluginInfo describes a registered plugin.
+type PluginInfo struct {
+	Kind string
+	Name string
+	// Schema declares the parameters of the test steps implementing
+	// test.ParameterSchema.
+	Schema *test.Schema `json:",omitempty"`
+}
+
+// Plugins lists the registered plugins, by kind and name, with the parameter
+// schemas of the test steps which declare one.
+func (r *PluginRegistry) Plugins() []PluginInfo {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var plugins []PluginInfo
+	add := func(kind string, names []string) {
+		sort.Strings(names)
+		for _, name := range names {
+			info := PluginInfo{Kind: kind, Name: name}
+			if kind == KindTestStep {
+				if s, ok := r.TestSteps[name]().(test.ParameterSchema); ok {
+					schema := s.ParameterSchema()
+					info.Schema = &schema
+				}
+			}
+			plugins = append(plugins, info)
+		}
+	}
+	var names []string
+	for name := range r.TargetManagers {
+		names = append(names, name)
+	}
+	add(KindTargetManager, names)
+	names = nil
+	for name := range r.TestFetchers {
+		names = append(names, name)
+	}
+	add(KindTestFetcher, names)
+	names = nil
+	for name := range r.TestSteps {
+		names = append(names, name)
+	}
+	add(KindTestStep, names)
+	names = nil
+	for name := range r.Reporters {
+		names = append(names, name)
+	}
+	add(KindReporter, names)
+	names = nil
+	for name := range r.TargetLockers {
+		names = append(names, name)
+	}
+	add(KindTargetLocker, names)
+	return plugins
+}
+
+// networkTimeoutParameters are passed by the job manager to every test step,
+// hence are not unknown to the steps which do not declare them.
+var networkTimeoutParameters = []string{
+	job.ConnectTimeoutParameter,
+	job.ReadTimeoutParameter,
+	job.WriteTimeoutParameter,
+	job.TotalTimeoutParameter,
+}
+
+// validateSchema checks the parameters of a test step against its schema, and
+// returns them with the defaults of the schema.
+func validateSchema(schema test.Schema, params test.TestStepParameters) (test.TestStepParameters, error) {
+	params = schema.WithDefaults(params)
+	declared := make(map[string]bool, len(schema.Parameters))
+	for _, p := range schema.Parameters {
+		declared[p.Name] = true
+	}
+	checked := make(test.TestStepParameters, len(params))
+	for name, values := range params {
+		checked[name] = values
+	}
+	for _, name := range networkTimeoutParameters {
+		if !declared[name] {
+			delete(checked, name)
+		}
+	}
+	return params, schema.Validate(checked)
+}
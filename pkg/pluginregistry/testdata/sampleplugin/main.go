@@ -0,0 +1,25 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package main is a sample test step plugin, used to test the loading of
+// plugins built with `go build -buildmode=plugin`.
+package main
+
+import (
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps/slowecho"
+)
+
+// Name is the name of the sample plugin.
+var Name = "SampleSlowEcho"
+
+// Load returns the name, factory and events of the sample plugin.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	_, factory, events := slowecho.Load()
+	return Name, factory, events
+}
+
+func main() {}
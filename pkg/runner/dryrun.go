@@ -0,0 +1,68 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/test"
+)
+
+// EventTestStepSkippedDryRun indicates that a test step was skipped in a dry
+// run, as it does not implement test.DryRunnable.
+var EventTestStepSkippedDryRun = event.Name("TestStepSkippedDryRun")
+
+// stepSkippedPayload is the payload of EventTestStepSkippedDryRun.
+type stepSkippedPayload struct {
+	TestStepName string
+}
+
+// dryRunSkipReason is the reason of the targets skipped in a dry run.
+const dryRunSkipReason = "dry run: step does not support dry runs"
+
+// SetDryRun sets whether the test steps run in dry run mode, see
+// test.DryRunnable.
+func (tr *TestRunner) SetDryRun(dryRun bool) {
+	tr.dryRun = dryRun
+}
+
+// dryRunTestStep runs a test step in dry run mode. Steps which do not support
+// dry runs are skipped: the targets are forwarded with a skip verdict.
+func dryRunTestStep(ctx context.Context, pause <-chan struct{}, bundle test.TestStepBundle, ch test.TestStepChannels, ev testevent.Emitter) error {
+	if ds, ok := bundle.TestStep.(test.DryRunnable); ok {
+		return ds.DryRun(ctx, pause, ch, bundle.Parameters, ev)
+	}
+	log.Infof("Dry run: skipping step %s (%s), which does not support dry runs", bundle.TestStepLabel, bundle.TestStep.Name())
+	data, err := json.Marshal(stepSkippedPayload{TestStepName: bundle.TestStep.Name()})
+	if err != nil {
+		return fmt.Errorf("could not encode payload: %v", err)
+	}
+	rawPayload := json.RawMessage(data)
+	if err := ev.Emit(testevent.Data{EventName: EventTestStepSkippedDryRun, Payload: &rawPayload}); err != nil {
+		return fmt.Errorf("could not emit %s event: %v", EventTestStepSkippedDryRun, err)
+	}
+	cancel := test.CancelChannel(ctx)
+	output := test.NewStepOutput(cancel, pause, ch)
+	for {
+		select {
+		case t, ok := <-ch.In:
+			if !ok {
+				return nil
+			}
+			if !output.Skip(t, dryRunSkipReason) {
+				return nil
+			}
+		case <-cancel:
+			return nil
+		case <-pause:
+			return nil
+		}
+	}
+}
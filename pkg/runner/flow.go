@@ -0,0 +1,171 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+)
+
+// EventTargetSkipped is emitted when a target goes past a test step without
+// entering it, because the condition of the step is false or because the
+// target jumps to a later step.
+var EventTargetSkipped = event.Name("TargetSkipped")
+
+// TargetSkippedPayload is the payload of EventTargetSkipped.
+type TargetSkippedPayload struct {
+	Reason string
+}
+
+// flow tracks the outcomes of the steps each target went through, which the
+// routing blocks use to evaluate the conditions of the steps and to route the
+// failed targets according to the OnFailure behaviour of the step. It only
+// knows about the steps run since the test was started or resumed.
+type flow struct {
+	mu       sync.Mutex
+	outcomes map[*target.Target]map[string]test.StepOutcome
+	// jumps holds the label of the step that the failed targets skip to
+	jumps map[*target.Target]string
+	// failures holds the first error of the targets which went on after
+	// failing a step
+	failures map[*target.Target]error
+}
+
+func newFlow() *flow {
+	return &flow{
+		outcomes: make(map[*target.Target]map[string]test.StepOutcome),
+		jumps:    make(map[*target.Target]string),
+		failures: make(map[*target.Target]error),
+	}
+}
+
+// record records the outcome of a step for a target. The output the step
+// emitted for the target is kept.
+func (f *flow) record(t *target.Target, label string, outcome test.StepOutcome) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.outcomes[t] == nil {
+		f.outcomes[t] = make(map[string]test.StepOutcome)
+	}
+	outcome.Output = f.outcomes[t][label].Output
+	f.outcomes[t][label] = outcome
+}
+
+// recordOutput records the output of a step for a target, from the payload of
+// an event the step emitted.
+func (f *flow) recordOutput(t *target.Target, label string, output map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.outcomes[t] == nil {
+		f.outcomes[t] = make(map[string]test.StepOutcome)
+	}
+	outcome := f.outcomes[t][label]
+	outcome.Output = output
+	f.outcomes[t][label] = outcome
+}
+
+// outputEmitter records the payloads of the events a step emits for its
+// targets as their output, which the conditions of the next steps can refer
+// to.
+type outputEmitter struct {
+	testevent.EmitterFetcher
+	flow  *flow
+	label string
+}
+
+func (e *outputEmitter) Emit(data testevent.Data) error {
+	if err := e.EmitterFetcher.Emit(data); err != nil {
+		return err
+	}
+	if data.Target != nil && data.Payload != nil {
+		if output := test.OutputFields(*data.Payload); output != nil {
+			e.flow.recordOutput(data.Target, e.label, output)
+		}
+	}
+	return nil
+}
+
+// Header returns the header of the wrapped emitter, for the steps which
+// inspect it.
+func (e *outputEmitter) Header() testevent.Header {
+	if h, ok := e.EmitterFetcher.(interface{ Header() testevent.Header }); ok {
+		return h.Header()
+	}
+	return testevent.Header{}
+}
+
+// fail records that a target failed a step, and returns whether the target
+// goes on in the pipeline rather than leaving the test.
+func (f *flow) fail(t *target.Target, bundle test.TestStepBundle, err error) bool {
+	f.record(t, bundle.TestStepLabel, test.StepOutcome{Verdict: test.VerdictFail, Error: err.Error()})
+	switch bundle.OnFailure {
+	case "", test.FailureAbort:
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.failures[t]; !ok {
+		f.failures[t] = err
+	}
+	if bundle.OnFailure != test.FailureContinue {
+		f.jumps[t] = bundle.OnFailure
+	}
+	return true
+}
+
+// skip tells whether a target reaching a step goes past it, and why.
+func (f *flow) skip(t *target.Target, bundle test.TestStepBundle) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if label, ok := f.jumps[t]; ok {
+		if label != bundle.TestStepLabel {
+			return fmt.Sprintf("jumping to step %s", label), true
+		}
+		delete(f.jumps, t)
+	}
+	if bundle.Condition != nil && !bundle.Condition.Eval(f.outcomes[t]) {
+		return fmt.Sprintf("condition %s is false", bundle.Condition), true
+	}
+	return "", false
+}
+
+// failure returns the error of a target which completed the test after
+// failing a step, if any.
+func (f *flow) failure(t *target.Target) error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failures[t]
+}
+
+func emitTargetSkipped(ev testevent.Emitter, t *target.Target, reason string) error {
+	data, err := json.Marshal(TargetSkippedPayload{Reason: reason})
+	if err != nil {
+		return fmt.Errorf("could not encode payload: %v", err)
+	}
+	rawPayload := json.RawMessage(data)
+	return ev.Emit(testevent.Data{EventName: EventTargetSkipped, Target: t, Payload: &rawPayload})
+}
+
+// AttachArtifact forwards the artifacts of the step to the wrapped emitter.
+func (e *outputEmitter) AttachArtifact(t *target.Target, name string, r io.Reader) error {
+	a, ok := e.EmitterFetcher.(interface {
+		AttachArtifact(t *target.Target, name string, r io.Reader) error
+	})
+	if !ok {
+		return fmt.Errorf("cannot attach artifact %s: the emitter does not store artifacts", name)
+	}
+	return a.AttachArtifact(t, name, r)
+}
@@ -65,7 +65,9 @@ func (jr *JobRunner) Run(j *job.Job) ([][]*job.Report, []*job.Report, error) {
 		jobLog.Infof("Running job '%s' %d times", j.Name, j.Runs)
 	}
 	tl := target.GetLocker()
-	ev := storage.NewTestEventFetcher()
+	// the reports are built right after the events are stored, hence they
+	// must not be read from a lagging replica
+	ev := storage.NewStrongTestEventFetcher()
 
 	var (
 		runReports      []*job.Report
@@ -74,17 +76,29 @@ func (jr *JobRunner) Run(j *job.Job) ([][]*job.Report, []*job.Report, error) {
 		runErr          error
 	)
 
+	// resume is the progress a paused job resumes from, until the test it
+	// was running is resumed
+	resume := j.ResumeFrom
+	if resume != nil {
+		jobLog.Infof("Resuming job '%s' (id %v) from test #%d of run #%d", j.Name, j.ID, resume.TestIndex, resume.RunID)
+		run = uint(resume.RunID) - 1
+		allRunReports = resume.RunReports
+	}
+
 	for {
 		if j.Runs != 0 && run == j.Runs {
 			break
 		}
 
 		// If we can't emit the run start event, we ignore the error. The framework will
-		// try to rebuild the status if it detects that an event might have gone missing
-		payload := RunStartedPayload{RunID: types.RunID(run + 1)}
-		err := jr.emitEvent(j.ID, EventRunStarted, payload)
-		if err != nil {
-			jobLog.Warningf("Could not emit event run (run %d) start for job %d: %v", run+1, j.ID, err)
+		// try to rebuild the status if it detects that an event might have gone missing.
+		// A resumed run already started.
+		if resume == nil {
+			payload := RunStartedPayload{RunID: types.RunID(run + 1)}
+			err := jr.emitEvent(j.ID, EventRunStarted, payload)
+			if err != nil {
+				jobLog.Warningf("Could not emit event run (run %d) start for job %s: %v", run+1, j.ID, err)
+			}
 		}
 
 		for idx, t := range j.Tests {
@@ -92,93 +106,139 @@ func (jr *JobRunner) Run(j *job.Job) ([][]*job.Report, []*job.Report, error) {
 				jobLog.Debugf("Cancellation requested, skipping test #%d of run #%d", idx, run+1)
 				break
 			}
-			jobLog.Infof("Run #%d: fetching targets for test '%s'", run+1, t.Name)
+			if resume != nil && idx < resume.TestIndex {
+				continue
+			}
+			if j.IsPaused() {
+				jobLog.Infof("Pause requested, stopping before test #%d of run #%d", idx, run+1)
+				return nil, nil, &ErrJobPaused{Snapshot: &job.PauseSnapshot{
+					RunID:       types.RunID(run + 1),
+					TestIndex:   idx,
+					ShuffleSeed: j.ShuffleSeed,
+					RunReports:  allRunReports,
+				}}
+			}
 			bundle := t.TargetManagerBundle
-			var (
-				targets   []*target.Target
-				targetsCh = make(chan []*target.Target, 1)
-				errCh     = make(chan error, 1)
-			)
-			go func() {
-				// the Acquire semantic is synchronous, so that the implementation
-				// is simpler on the user's side. We run it in a goroutine in
-				// order to use a timeout for target acquisition.
-				targets, err := bundle.TargetManager.Acquire(j.ID, j.CancelCh, bundle.AcquireParameters, tl)
-				if err != nil {
-					errCh <- err
-					targetsCh <- nil
-					return
-				}
-				if allAreLocked, _, notLocked := tl.CheckLocks(j.ID, targets); !allAreLocked {
-					errCh <- fmt.Errorf("Could not lock %d targets out of %d are not locked: %v", len(notLocked), len(targets), notLocked)
-					targetsCh <- nil
-				}
-				errCh <- nil
-				targetsCh <- targets
-			}()
-			// wait for targets up to a certain amount of time
-			select {
-			case err := <-errCh:
-				targets = <-targetsCh
-				if err != nil {
-					jobLog.Warningf("Run #%d: cannot fetch targets for test '%s': %v", run+1, t.Name, err)
-					return nil, nil, err
+			header := testevent.Header{JobID: j.ID, RunID: types.RunID(run + 1), TestName: t.Name}
+			testEvenEmitter := storage.NewTestEventEmitter(header)
+			testRunner := NewTestRunner()
+			testRunner.SetBreakpoints(j.Breakpoints)
+			if j.ShuffleSteps {
+				testRunner.SetShuffleSeed(j.ShuffleSeed)
+			}
+			testRunner.SetDryRun(j.DryRun)
+			testRunner.SetSecrets(j.SecretStore)
+			// done signals to the goroutine that refreshes the locks that the test
+			// has completed
+			done := make(chan struct{})
+			errCh := make(chan error, 1)
+			// testTargets are the targets of the test, unless they are streamed
+			var testTargets []*target.Target
+
+			stm, streaming := bundle.TargetManager.(target.StreamingTargetManager)
+			if resume != nil && resume.Started() {
+				// the targets stayed locked while the job was paused
+				testTargets = resume.Targets
+				if err := tl.Lock(j.ID, testTargets); err != nil {
+					return nil, nil, fmt.Errorf("could not lock the targets of resumed test '%s': %v", t.Name, err)
 				}
-				// Associate the targets with the job for later retrievel
 				jr.targetLock.Lock()
-				jr.targetMap[j.ID] = targets
+				jr.targetMap[j.ID] = testTargets
 				jr.targetLock.Unlock()
-
-			case <-time.After(config.TargetManagerTimeout):
-				return nil, nil, fmt.Errorf("target manager acquire timed out after %s", config.TargetManagerTimeout)
-			case <-j.CancelCh:
-				jobLog.Infof("cancellation requested for job ID %v", j.ID)
-				return nil, nil, nil
-			}
-
-			// refresh the target locks periodically, by extending their
-			// expiration time. If the job is cancelled, the locks are released.
-			// If the job is paused (e.g. because we are migrating the ConTest
-			// instance or upgrading it), the locks are not released, because we
-			// may want to resume once the new ConTest instance starts.
-			done := make(chan struct{})
-			go func(j *job.Job, tl target.Locker, targets []*target.Target, lockTimeout time.Duration) {
-				for {
+				go jr.refreshLocks(j, tl, config.LockTimeout, done)
+				// the resumed steps fetch the events they emitted before the pause
+				testRunner.SetStrongReads(true)
+				jobLog.Infof("Run #%d: resuming test #%d for job '%s' (job ID: %s) on %d targets", run+1, idx, j.Name, j.ID, len(testTargets))
+				runErr = testRunner.Resume(j.CancelCh, j.PauseCh, t, testTargets, resume.Steps, j.ID, types.RunID(run+1))
+			} else if streaming {
+				// Targets are acquired continuously and fed into the test as they
+				// come, hence the acquisition is not subject to the target manager
+				// timeout.
+				jobLog.Infof("Run #%d: streaming targets for test '%s'", run+1, t.Name)
+				jr.targetLock.Lock()
+				jr.targetMap[j.ID] = nil
+				jr.targetLock.Unlock()
+				go jr.refreshLocks(j, tl, config.LockTimeout, done)
+				targetsCh, acquireErrCh := jr.streamTargets(j, stm, bundle.AcquireParameters, tl, testEvenEmitter)
+				jobLog.Infof("Run #%d: running test #%d for job '%s' (job ID: %s) on streamed targets", run+1, idx, j.Name, j.ID)
+				runErr = testRunner.RunStream(j.CancelCh, j.PauseCh, t, targetsCh, j.ID, types.RunID(run+1))
+				if runErr == nil {
 					select {
-					case <-j.CancelCh:
-						// unlock targets
-						if err := tl.Unlock(j.ID, targets); err != nil {
-							log.Warningf("Failed to unlock targets (%v) for job ID %d: %v", targets, j.ID, err)
-						}
-						return
-					case <-j.PauseCh:
-						// do not unlock targets, we can resume later, or let
-						// them expire
-						log.Debugf("Received pause request, NOT releasing targets so the job can be resumed")
-						return
-					case <-done:
-						if err := tl.Unlock(j.ID, targets); err != nil {
-							log.Warningf("Failed to unlock %d target(s) (%v): %v", len(targets), targets, err)
-						}
-						log.Infof("Unlocked %d target(s) for job ID %d", len(targets), j.ID)
+					case runErr = <-acquireErrCh:
+					default:
+					}
+				}
+			} else {
+				jobLog.Infof("Run #%d: fetching targets for test '%s'", run+1, t.Name)
+				var (
+					targets   []*target.Target
+					targetsCh = make(chan []*target.Target, 1)
+				)
+				go func() {
+					// the Acquire semantic is synchronous, so that the implementation
+					// is simpler on the user's side. We run it in a goroutine in
+					// order to use a timeout for target acquisition.
+					targets, err := bundle.TargetManager.Acquire(j.ID, j.CancelCh, bundle.AcquireParameters, tl)
+					if err != nil {
+						errCh <- err
+						targetsCh <- nil
 						return
-					case <-time.After(lockTimeout):
-						// refresh the locks before the timeout expires
-						if err := tl.RefreshLocks(j.ID, targets); err != nil {
-							log.Warningf("Failed to refresh %d locks for job ID %d: %v", len(targets), j.ID, err)
-						}
 					}
+					if allAreLocked, _, notLocked := tl.CheckLocks(j.ID, targets); !allAreLocked {
+						errCh <- fmt.Errorf("Could not lock %d targets out of %d are not locked: %v", len(notLocked), len(targets), notLocked)
+						targetsCh <- nil
+					}
+					errCh <- nil
+					targetsCh <- targets
+				}()
+				// wait for targets up to a certain amount of time
+				select {
+				case err := <-errCh:
+					targets = <-targetsCh
+					if err != nil {
+						jobLog.Warningf("Run #%d: cannot fetch targets for test '%s': %v", run+1, t.Name, err)
+						return nil, nil, err
+					}
+					// Associate the targets with the job for later retrievel
+					jr.targetLock.Lock()
+					jr.targetMap[j.ID] = targets
+					jr.targetLock.Unlock()
+					testTargets = targets
+
+				case <-time.After(config.TargetManagerTimeout):
+					return nil, nil, fmt.Errorf("target manager acquire timed out after %s", config.TargetManagerTimeout)
+				case <-j.CancelCh:
+					jobLog.Infof("cancellation requested for job ID %v", j.ID)
+					return nil, nil, nil
 				}
-			}(j, tl, targets, config.LockTimeout)
+				go jr.refreshLocks(j, tl, config.LockTimeout, done)
 
-			// Emit events tracking targets acquisition
-			header := testevent.Header{JobID: j.ID, RunID: types.RunID(run + 1), TestName: t.Name}
-			testEvenEmitter := storage.NewTestEventEmitter(header)
+				// Emit events tracking targets acquisition
+				if runErr = jr.emitAcquiredTargets(testEvenEmitter, targets); runErr == nil {
+					jobLog.Infof("Run #%d: running test #%d for job '%s' (job ID: %s) on %d targets", run+1, idx, j.Name, j.ID, len(targets))
+					runErr = testRunner.Run(j.CancelCh, j.PauseCh, t, targets, j.ID, types.RunID(run+1))
+				}
+			}
+			resume = nil
 
-			if runErr = jr.emitAcquiredTargets(testEvenEmitter, targets); runErr == nil {
-				jobLog.Infof("Run #%d: running test #%d for job '%s' (job ID: %d) on %d targets", run+1, idx, j.Name, j.ID, len(targets))
-				testRunner := NewTestRunner()
-				runErr = testRunner.Run(j.CancelCh, j.PauseCh, t, targets, j.ID, types.RunID(run+1))
+			// The targets of a paused test are not released, so that the job
+			// can resume with them.
+			if runErr == nil && j.IsPaused() && !j.IsCancelled() {
+				if streaming {
+					return nil, nil, fmt.Errorf("test '%s' cannot be paused, as its targets are streamed", t.Name)
+				}
+				steps, err := testRunner.Snapshot(testTargets)
+				if err != nil {
+					return nil, nil, fmt.Errorf("could not snapshot paused test '%s': %v", t.Name, err)
+				}
+				return nil, nil, &ErrJobPaused{Snapshot: &job.PauseSnapshot{
+					RunID:       types.RunID(run + 1),
+					TestIndex:   idx,
+					ShuffleSeed: j.ShuffleSeed,
+					Targets:     testTargets,
+					Steps:       steps,
+					RunReports:  allRunReports,
+				}}
 			}
 
 			// Job is done, release all the targets
@@ -219,9 +279,9 @@ func (jr *JobRunner) Run(j *job.Job) ([][]*job.Report, []*job.Report, error) {
 
 		runReports = make([]*job.Report, 0, len(j.RunReporterBundles))
 		for _, bundle := range j.RunReporterBundles {
-			runStatus, err := jr.BuildRunStatus(runCoordinates, j)
+			runStatus, err := jr.buildRunStatus(runCoordinates, j, ev)
 			if err != nil {
-				jobLog.Warningf("could not build run status for job %d: %v. Run report will not execute", j.ID, err)
+				jobLog.Warningf("could not build run status for job %s: %v. Run report will not execute", j.ID, err)
 				continue
 			}
 			success, data, err := bundle.Reporter.RunReport(j.CancelCh, bundle.Parameters, runStatus, ev)
@@ -229,9 +289,9 @@ func (jr *JobRunner) Run(j *job.Job) ([][]*job.Report, []*job.Report, error) {
 				jobLog.Warningf("Run reporter failed while calculating run results, proceeding anyway: %v", err)
 			} else {
 				if success {
-					jobLog.Printf("Run #%d of job %d considered successful according to %s", run+1, j.ID, bundle.Reporter.Name())
+					jobLog.Printf("Run #%d of job %s considered successful according to %s", run+1, j.ID, bundle.Reporter.Name())
 				} else {
-					jobLog.Errorf("Run #%d of job %d considered failed according to %s", run+1, j.ID, bundle.Reporter.Name())
+					jobLog.Errorf("Run #%d of job %s considered failed according to %s", run+1, j.ID, bundle.Reporter.Name())
 				}
 			}
 
@@ -244,6 +304,9 @@ func (jr *JobRunner) Run(j *job.Job) ([][]*job.Report, []*job.Report, error) {
 
 		}
 		allRunReports = append(allRunReports, runReports)
+		if tracker := target.GetHealthTracker(); tracker != nil && !j.DryRun && !j.IsCancelled() {
+			jr.recordOutcomes(tracker, runCoordinates, j, ev)
+		}
 
 		if j.IsCancelled() {
 			jobLog.Debugf("Cancellation requested, skipping run #%d", run+1)
@@ -266,7 +329,7 @@ func (jr *JobRunner) Run(j *job.Job) ([][]*job.Report, []*job.Report, error) {
 	for _, bundle := range j.FinalReporterBundles {
 		// Build a RunStatus object for each run that we executed. We need to check if we interrupted
 		// execution early and we did not perform all runs
-		runStatuses, err := jr.BuildRunStatuses(j)
+		runStatuses, err := jr.buildRunStatuses(j, ev)
 		if err != nil {
 			jobLog.Warningf("could not calculate run statuses: %v. Run report will not execute", err)
 			continue
@@ -277,18 +340,109 @@ func (jr *JobRunner) Run(j *job.Job) ([][]*job.Report, []*job.Report, error) {
 			jobLog.Warningf("Final reporter failed while calculating test results, proceeding anyway: %v", err)
 		} else {
 			if success {
-				jobLog.Printf("Job %d (%d runs out of %d desired) considered successful", j.ID, run, j.Runs)
+				jobLog.Printf("Job %s (%d runs out of %d desired) considered successful", j.ID, run, j.Runs)
 			} else {
-				jobLog.Errorf("Job %d (%d runs out of %d desired) considered failed", j.ID, run, j.Runs)
+				jobLog.Errorf("Job %s (%d runs out of %d desired) considered failed", j.ID, run, j.Runs)
 			}
 		}
 		r := job.Report{Success: success, ReporterName: bundle.Reporter.Name(), ReportTime: time.Now(), Data: data}
 		allFinalReports = append(allFinalReports, &r)
 	}
 
+	// With a minimum pass percentage, the outcome of the job depends on the
+	// targets rather than on the errors of the runs only.
+	if j.MinPassPercent != nil {
+		runStatuses, err := jr.buildRunStatuses(j, ev)
+		if err != nil {
+			return allRunReports, allFinalReports, fmt.Errorf("could not build run statuses to evaluate the pass percentage: %v", err)
+		}
+		summary := job.BuildSummary(runStatuses, j.MinPassPercent)
+		if !summary.Success {
+			return allRunReports, allFinalReports, fmt.Errorf("%.2f%% of the targets passed (%d out of %d), below the minimum of %.2f%%",
+				summary.PassPercent, summary.Passed, summary.Passed+summary.Failed, *j.MinPassPercent)
+		}
+		jobLog.Infof("Job %s passed with %.2f%% of the targets passing", j.ID, summary.PassPercent)
+	}
+
 	return allRunReports, allFinalReports, nil
 }
 
+// refreshLocks refreshes the locks of the targets acquired for the job
+// periodically, by extending their expiration time. If the job is cancelled or
+// done is signaled, the locks are released. If the job is paused (e.g. because
+// we are migrating the ConTest instance or upgrading it), the locks are not
+// released, because we may want to resume once the new ConTest instance starts.
+func (jr *JobRunner) refreshLocks(j *job.Job, tl target.Locker, lockTimeout time.Duration, done <-chan struct{}) {
+	for {
+		select {
+		case <-j.CancelCh:
+			// unlock targets
+			targets := jr.GetTargets(j.ID)
+			if err := tl.Unlock(j.ID, targets); err != nil {
+				log.Warningf("Failed to unlock targets (%v) for job ID %s: %v", targets, j.ID, err)
+			}
+			return
+		case <-j.PauseCh:
+			// do not unlock targets, we can resume later, or let
+			// them expire
+			log.Debugf("Received pause request, NOT releasing targets so the job can be resumed")
+			return
+		case <-done:
+			targets := jr.GetTargets(j.ID)
+			if err := tl.Unlock(j.ID, targets); err != nil {
+				log.Warningf("Failed to unlock %d target(s) (%v): %v", len(targets), targets, err)
+			}
+			log.Infof("Unlocked %d target(s) for job ID %s", len(targets), j.ID)
+			return
+		case <-time.After(lockTimeout):
+			// refresh the locks before the timeout expires
+			targets := jr.GetTargets(j.ID)
+			if err := tl.RefreshLocks(j.ID, targets); err != nil {
+				log.Warningf("Failed to refresh %d locks for job ID %s: %v", len(targets), j.ID, err)
+			}
+		}
+	}
+}
+
+// streamTargets acquires targets from a StreamingTargetManager, and forwards
+// them on the returned channel as they are acquired. Targets which are not
+// locked by the job are skipped. The returned channel is closed once the
+// target manager signals that no more targets will be acquired, or the job is
+// cancelled or paused. The acquisition error, if any, is sent on the returned
+// error channel.
+func (jr *JobRunner) streamTargets(j *job.Job, tm target.StreamingTargetManager, parameters interface{}, tl target.Locker, emitter testevent.Emitter) (<-chan *target.Target, <-chan error) {
+	acquired := make(chan *target.Target)
+	targets := make(chan *target.Target)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tm.AcquireStream(j.ID, j.CancelCh, parameters, tl, acquired)
+		close(acquired)
+	}()
+	go func() {
+		defer close(targets)
+		for t := range acquired {
+			if allAreLocked, _, _ := tl.CheckLocks(j.ID, []*target.Target{t}); !allAreLocked {
+				jobLog.Warningf("Target %s is not locked by job %s, skipping it", t, j.ID)
+				continue
+			}
+			jr.targetLock.Lock()
+			jr.targetMap[j.ID] = append(jr.targetMap[j.ID], t)
+			jr.targetLock.Unlock()
+			if err := jr.emitAcquiredTargets(emitter, []*target.Target{t}); err != nil {
+				jobLog.Warningf("Could not emit acquisition event for target %s: %v", t, err)
+			}
+			select {
+			case targets <- t:
+			case <-j.CancelCh:
+				return
+			case <-j.PauseCh:
+				return
+			}
+		}
+	}()
+	return targets, errCh
+}
+
 // emitAcquiredTargets emits test events to keep track of Target acquisition
 func (jr *JobRunner) emitAcquiredTargets(emitter testevent.Emitter, targets []*target.Target) error {
 	// The events hold a serialization of the Target in the payload
@@ -302,6 +456,43 @@ func (jr *JobRunner) emitAcquiredTargets(emitter testevent.Emitter, targets []*t
 	return nil
 }
 
+// recordOutcomes records the outcome of a run for each of its targets with
+// the health tracker. A target fails the run if it failed any test, except as
+// an informational target. Failures to record are only logged.
+func (jr *JobRunner) recordOutcomes(tracker target.HealthTracker, coordinates job.RunCoordinates, j *job.Job, ev testevent.Fetcher) {
+	runStatus, err := jr.buildRunStatus(coordinates, j, ev)
+	if err != nil {
+		jobLog.Warningf("could not build run status for job %s: %v. Target outcomes will not be recorded", j.ID, err)
+		return
+	}
+	var (
+		outcomes []target.Outcome
+		index    = make(map[string]int)
+		now      = time.Now()
+	)
+	for _, testStatus := range runStatus.TestStatuses {
+		for _, targetStatus := range testStatus.TargetStatuses {
+			// targets which did not start the test have no outcome
+			if targetStatus.Target == nil || targetStatus.Informational {
+				continue
+			}
+			id := targetStatus.Target.ID
+			i, ok := index[id]
+			if !ok {
+				i = len(outcomes)
+				index[id] = i
+				outcomes = append(outcomes, target.Outcome{TargetID: id, JobID: j.ID, RunID: coordinates.RunID, Success: true, Time: now})
+			}
+			if targetStatus.Error != "" {
+				outcomes[i].Success = false
+			}
+		}
+	}
+	if err := tracker.RecordOutcomes(outcomes); err != nil {
+		jobLog.Warningf("Could not record the outcomes of run #%d of job %s: %v", coordinates.RunID, j.ID, err)
+	}
+}
+
 // GetCurrentRun returns the run which is currently being executed
 func (jr *JobRunner) GetCurrentRun(jobID types.JobID) (types.RunID, error) {
 
@@ -312,13 +503,13 @@ func (jr *JobRunner) GetCurrentRun(jobID types.JobID) (types.RunID, error) {
 		frameworkevent.QueryEventName(EventRunStarted),
 	)
 	if err != nil {
-		return runID, fmt.Errorf("could not fetch last run id for job %d: %v", jobID, err)
+		return runID, fmt.Errorf("could not fetch last run id for job %s: %v", jobID, err)
 	}
 
 	lastEvent := runEvents[len(runEvents)-1]
 	payload := RunStartedPayload{}
 	if err := json.Unmarshal([]byte(*lastEvent.Payload), &payload); err != nil {
-		return runID, fmt.Errorf("could not fetch last run id for job %d: %v", jobID, err)
+		return runID, fmt.Errorf("could not fetch last run id for job %s: %v", jobID, err)
 	}
 	return payload.RunID, nil
 
@@ -28,21 +28,13 @@ var TargetRoutingEvents = []event.Name{
 }
 
 // buildTargetStatuses builds a list of TargetStepStatus, which represent the status of Targets within a TestStep
-func (jr *JobRunner) buildTargetStatuses(coordinates job.TestStepCoordinates) ([]job.TargetStatus, error) {
-
-	// Fetch all the events associated to Targets routing
-	routingEvents, err := jr.testEvManager.Fetch(
-		testevent.QueryJobID(coordinates.JobID),
-		testevent.QueryTestName(coordinates.TestName),
-		testevent.QueryTestStepLabel(coordinates.TestStepLabel),
-		testevent.QueryEventNames(TargetRoutingEvents),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("could not fetch events associated to target routing: %v", err)
-	}
+func (jr *JobRunner) buildTargetStatuses(coordinates job.TestStepCoordinates, ev testevent.Fetcher) ([]job.TargetStatus, error) {
 
+	// Go through the events associated to Targets routing, and their results.
+	// Steps emit several of them per target, so they are streamed rather
+	// than fetched at once.
 	var targetStatuses []job.TargetStatus
-	for _, testEvent := range routingEvents {
+	err := testevent.Stream(ev, func(testEvent testevent.Event) error {
 
 		// Update the TargetStatus object associated to the Target. If there is no TargetStatus associated yet, append it
 		var targetStatus *job.TargetStatus
@@ -55,17 +47,39 @@ func (jr *JobRunner) buildTargetStatuses(coordinates job.TestStepCoordinates) ([
 
 		if targetStatus == nil {
 			// There is no TargetStatus associated with this Target, create one
-			targetStatuses = append(targetStatuses, job.TargetStatus{TestStepCoordinates: coordinates, Target: testEvent.Data.Target})
+			targetStatuses = append(targetStatuses, job.TargetStatus{
+				TestStepCoordinates: coordinates,
+				Target:              testEvent.Data.Target,
+				Informational:       testEvent.Data.Target.Informational,
+			})
 			targetStatus = &targetStatuses[len(targetStatuses)-1]
 		}
 
 		evName := testEvent.Data.EventName
-		if evName == target.EventTargetIn {
+		if evName == testevent.EventTargetResult {
+			// results which cannot be decoded are still part of the events
+			// of the step
+			if result, err := testEvent.Data.Result(); err == nil {
+				targetStatus.Results = append(targetStatus.Results, *result)
+			}
+		} else if evName == target.EventTargetIn {
 			targetStatus.InTime = testEvent.EmitTime
 		} else if evName == target.EventTargetOut {
 			targetStatus.OutTime = testEvent.EmitTime
+			// events emitted before verdicts were recorded have no payload
+			targetStatus.Verdict = test.VerdictPass
+			if testEvent.Data.Payload != nil {
+				outPayload := target.OutPayload{}
+				if err := json.Unmarshal(*testEvent.Data.Payload, &outPayload); err != nil {
+					targetStatus.Error = fmt.Sprintf("could not unmarshal payload: %v", err)
+				} else if v := test.Verdict(outPayload.Verdict); v.Valid() {
+					targetStatus.Verdict = v
+					targetStatus.Reason = outPayload.Reason
+				}
+			}
 		} else if evName == target.EventTargetErr {
 			targetStatus.OutTime = testEvent.EmitTime
+			targetStatus.Verdict = test.VerdictFail
 			errorPayload := target.ErrPayload{}
 			jsonPayload, err := testEvent.Data.Payload.MarshalJSON()
 			if err != nil {
@@ -75,21 +89,30 @@ func (jr *JobRunner) buildTargetStatuses(coordinates job.TestStepCoordinates) ([
 					targetStatus.Error = fmt.Sprintf("could not unmarshal payload error: %v", err)
 				} else {
 					targetStatus.Error = errorPayload.Error
+					targetStatus.Reason = errorPayload.Error
 				}
 			}
 		}
+		return nil
+	},
+		testevent.QueryJobID(coordinates.JobID),
+		testevent.QueryTestName(coordinates.TestName),
+		testevent.QueryTestStepLabel(coordinates.TestStepLabel),
+		testevent.QueryEventNames(append(append([]event.Name(nil), TargetRoutingEvents...), testevent.EventTargetResult)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch events associated to target routing: %v", err)
 	}
-
 	return targetStatuses, nil
 }
 
 // buildTestStepStatus builds the status object of a test step belonging to a test
-func (jr *JobRunner) buildTestStepStatus(coordinates job.TestStepCoordinates) (*job.TestStepStatus, error) {
+func (jr *JobRunner) buildTestStepStatus(coordinates job.TestStepCoordinates, ev testevent.Fetcher) (*job.TestStepStatus, error) {
 
 	testStepStatus := job.TestStepStatus{TestStepCoordinates: coordinates}
 
 	// Fetch all Events associated to this TestStep
-	testEvents, err := jr.testEvManager.Fetch(
+	testEvents, err := ev.Fetch(
 		testevent.QueryJobID(coordinates.JobID),
 		testevent.QueryRunID(coordinates.RunID),
 		testevent.QueryTestName(coordinates.TestName),
@@ -112,7 +135,7 @@ func (jr *JobRunner) buildTestStepStatus(coordinates job.TestStepCoordinates) (*
 	}
 
 	testStepStatus.Events = filteredTestEvents
-	targetStatuses, err := jr.buildTargetStatuses(coordinates)
+	targetStatuses, err := jr.buildTargetStatuses(coordinates, ev)
 	if err != nil {
 		return nil, fmt.Errorf("could not build target status for test step %s: %v", coordinates.TestStepLabel, err)
 	}
@@ -121,7 +144,7 @@ func (jr *JobRunner) buildTestStepStatus(coordinates job.TestStepCoordinates) (*
 }
 
 // buildTestStatus builds the status of a test belonging to a specific to a test
-func (jr *JobRunner) buildTestStatus(coordinates job.TestCoordinates, currentJob *job.Job) (*job.TestStatus, error) {
+func (jr *JobRunner) buildTestStatus(coordinates job.TestCoordinates, currentJob *job.Job, ev testevent.Fetcher) (*job.TestStatus, error) {
 
 	var currentTest *test.Test
 	// Identify the test within the Job for which we are asking to calculate the status
@@ -133,7 +156,7 @@ func (jr *JobRunner) buildTestStatus(coordinates job.TestCoordinates, currentJob
 	}
 
 	if currentTest == nil {
-		return nil, fmt.Errorf("job with id %d does not include any test named %s", coordinates.JobID, coordinates.TestName)
+		return nil, fmt.Errorf("job with id %s does not include any test named %s", coordinates.JobID, coordinates.TestName)
 	}
 	testStatus := job.TestStatus{
 		TestCoordinates:  coordinates,
@@ -147,7 +170,7 @@ func (jr *JobRunner) buildTestStatus(coordinates job.TestCoordinates, currentJob
 			TestStepName:    bundle.TestStep.Name(),
 			TestStepLabel:   bundle.TestStepLabel,
 		}
-		testStepStatus, err := jr.buildTestStepStatus(testStepCoordinates)
+		testStepStatus, err := jr.buildTestStepStatus(testStepCoordinates, ev)
 		if err != nil {
 			return nil, fmt.Errorf("could not build TestStatus for test %s: %v", bundle.TestStep.Name(), err)
 		}
@@ -159,7 +182,7 @@ func (jr *JobRunner) buildTestStatus(coordinates job.TestCoordinates, currentJob
 
 	// Fetch all events signaling that a Target has been acquired. This is the source of truth
 	// indicating which Targets belong to a Test.
-	targetAcquiredEvents, err := jr.testEvManager.Fetch(
+	targetAcquiredEvents, err := ev.Fetch(
 		testevent.QueryJobID(coordinates.JobID),
 		testevent.QueryRunID(coordinates.RunID),
 		testevent.QueryTestName(coordinates.TestName),
@@ -185,7 +208,7 @@ func (jr *JobRunner) buildTestStatus(coordinates job.TestCoordinates, currentJob
 		if _, ok := targetMap[t]; !ok {
 			// This Target is not associated to any TargetStatus, we assume it has not
 			// started the test
-			targetMap[t] = job.TargetStatus{}
+			targetMap[t] = job.TargetStatus{Informational: t.Informational}
 		}
 		targetStatuses = append(targetStatuses, targetMap[t])
 	}
@@ -196,12 +219,18 @@ func (jr *JobRunner) buildTestStatus(coordinates job.TestCoordinates, currentJob
 
 // BuildRunStatus builds the status of a run with a job
 func (jr *JobRunner) BuildRunStatus(coordinates job.RunCoordinates, currentJob *job.Job) (*job.RunStatus, error) {
+	return jr.buildRunStatus(coordinates, currentJob, jr.testEvManager)
+}
+
+// buildRunStatus builds the status of a run with a job, from the test events
+// fetched with ev.
+func (jr *JobRunner) buildRunStatus(coordinates job.RunCoordinates, currentJob *job.Job, ev testevent.Fetcher) (*job.RunStatus, error) {
 
 	runStatus := job.RunStatus{RunCoordinates: coordinates, TestStatuses: make([]job.TestStatus, len(currentJob.Tests))}
 
 	for index, currentTest := range currentJob.Tests {
 		testCoordinates := job.TestCoordinates{RunCoordinates: coordinates, TestName: currentTest.Name}
-		testStatus, err := jr.buildTestStatus(testCoordinates, currentJob)
+		testStatus, err := jr.buildTestStatus(testCoordinates, currentJob, ev)
 		if err != nil {
 			return nil, fmt.Errorf("could not rebuild status for test %s: %v", currentTest.Name, err)
 		}
@@ -212,6 +241,12 @@ func (jr *JobRunner) BuildRunStatus(coordinates job.RunCoordinates, currentJob *
 
 // BuildRunStatuses builds the status of all runs belonging to the job
 func (jr *JobRunner) BuildRunStatuses(currentJob *job.Job) ([]job.RunStatus, error) {
+	return jr.buildRunStatuses(currentJob, jr.testEvManager)
+}
+
+// buildRunStatuses builds the status of all runs belonging to the job, from
+// the test events fetched with ev.
+func (jr *JobRunner) buildRunStatuses(currentJob *job.Job, ev testevent.Fetcher) ([]job.RunStatus, error) {
 
 	// Calculate the status only for the runs which effectively were executed
 	runStartEvents, err := jr.frameworkEventManager.Fetch(frameworkevent.QueryEventName(EventRunStarted))
@@ -239,7 +274,7 @@ func (jr *JobRunner) BuildRunStatuses(currentJob *job.Job) ([]job.RunStatus, err
 
 	for runID := uint(1); runID <= numRuns; runID++ {
 		runCoordinates := job.RunCoordinates{JobID: currentJob.ID, RunID: types.RunID(runID)}
-		runStatus, err := jr.BuildRunStatus(runCoordinates, currentJob)
+		runStatus, err := jr.buildRunStatus(runCoordinates, currentJob, ev)
 		if err != nil {
 			return nil, fmt.Errorf("could not rebuild run status for run %d: %v", runID, err)
 		}
@@ -0,0 +1,88 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/pkg/test"
+)
+
+// redactedSecret replaces the values of the job secrets in the events and
+// errors of the test steps.
+const redactedSecret = "<redacted>"
+
+// SetSecrets sets the resolved secrets of the job, which the test step
+// parameters refer to.
+func (tr *TestRunner) SetSecrets(s *secrets.Store) {
+	tr.secrets = s
+}
+
+// expandSecrets returns the parameters with the job secret references
+// replaced by their values. The parameters are returned as they are if they
+// do not refer to any secret.
+func (tr *TestRunner) expandSecrets(params test.TestStepParameters) (test.TestStepParameters, error) {
+	var expanded test.TestStepParameters
+	for name, values := range params {
+		for idx, p := range values {
+			if len(secrets.JobReferences(p.Raw())) == 0 {
+				continue
+			}
+			v, err := tr.secrets.Expand(p.Raw())
+			if err != nil {
+				return nil, fmt.Errorf("invalid parameter '%s': %v", name, err)
+			}
+			if expanded == nil {
+				expanded = make(test.TestStepParameters, len(params))
+				for k, vs := range params {
+					expanded[k] = append([]test.Param(nil), vs...)
+				}
+			}
+			expanded[name][idx] = *test.NewParam(v)
+		}
+	}
+	if expanded == nil {
+		return params, nil
+	}
+	return expanded, nil
+}
+
+// redactingEmitter removes the values of the job secrets from the payloads of
+// the events emitted by a test step.
+type redactingEmitter struct {
+	testevent.EmitterFetcher
+	secrets *secrets.Store
+}
+
+func (e *redactingEmitter) Emit(data testevent.Data) error {
+	if data.Payload != nil {
+		if redacted := e.secrets.Redact(string(*data.Payload), redactedSecret); redacted != string(*data.Payload) {
+			raw := json.RawMessage(redacted)
+			if !json.Valid(raw) {
+				// the secret was not within a string, keep the payload valid
+				encoded, err := json.Marshal(redacted)
+				if err != nil {
+					return fmt.Errorf("could not encode redacted payload: %v", err)
+				}
+				raw = encoded
+			}
+			data.Payload = &raw
+		}
+	}
+	return e.EmitterFetcher.Emit(data)
+}
+
+// Header returns the header of the wrapped emitter, for the steps which
+// inspect it.
+func (e *redactingEmitter) Header() testevent.Header {
+	if h, ok := e.EmitterFetcher.(interface{ Header() testevent.Header }); ok {
+		return h.Header()
+	}
+	return testevent.Header{}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/test"
+)
+
+// EventTestStepsShuffled records the seed and the resulting order of the test
+// steps, when a job shuffles its order independent steps.
+var EventTestStepsShuffled = event.Name("TestStepsShuffled")
+
+// stepsShuffledPayload is the payload of EventTestStepsShuffled.
+type stepsShuffledPayload struct {
+	Seed  int64
+	Order []string
+}
+
+// SetShuffleSeed enables the shuffling of the order independent test steps,
+// using the given seed.
+func (tr *TestRunner) SetShuffleSeed(seed int64) {
+	tr.shuffleSeed = &seed
+}
+
+// ShuffleTestSteps returns a copy of the bundles in which the steps marked as
+// order independent are permuted among the positions they occupy, while the
+// other steps keep their declared position. The permutation is determined by
+// the seed.
+func ShuffleTestSteps(bundles []test.TestStepBundle, seed int64) []test.TestStepBundle {
+	shuffled := make([]test.TestStepBundle, len(bundles))
+	copy(shuffled, bundles)
+	var positions []int
+	for idx, b := range bundles {
+		if b.OrderIndependent {
+			positions = append(positions, idx)
+		}
+	}
+	perm := rand.New(rand.NewSource(seed)).Perm(len(positions))
+	for i, p := range perm {
+		shuffled[positions[i]] = bundles[positions[p]]
+	}
+	return shuffled
+}
+
+func emitStepsShuffled(ev testevent.Emitter, seed int64, bundles []test.TestStepBundle) error {
+	payload := stepsShuffledPayload{Seed: seed}
+	for _, b := range bundles {
+		payload.Order = append(payload.Order, b.TestStepLabel)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("could not encode payload: %v", err)
+	}
+	rawPayload := json.RawMessage(data)
+	return ev.Emit(testevent.Data{EventName: EventTestStepsShuffled, Payload: &rawPayload})
+}
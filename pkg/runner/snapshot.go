@@ -0,0 +1,262 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package runner
+
+import (
+	"container/list"
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// ErrJobPaused is returned by JobRunner.Run when the job is paused. It holds
+// the progress the job resumes from.
+type ErrJobPaused struct {
+	Snapshot *job.PauseSnapshot
+}
+
+// Error returns the error string associated with the error
+func (e *ErrJobPaused) Error() string {
+	return fmt.Sprintf("job paused at test #%d of run #%d", e.Snapshot.TestIndex, e.Snapshot.RunID)
+}
+
+// routeProgress is the progress of the targets of a routing block when it
+// returns, from which the snapshot of a paused test is built.
+type routeProgress struct {
+	// pending are the targets buffered for injection
+	pending map[*target.Target]bool
+	// inFlight are the targets injected into the TestStep which did not leave
+	// it, including the one being injected, if any
+	inFlight map[*target.Target]bool
+	// out are the targets forwarded to the next routing block
+	out map[*target.Target]bool
+	// failed are the targets which left the TestStep with an error
+	failed map[*target.Target]bool
+}
+
+func newRouteProgress(buffered *list.List, injecting *target.Target, ingress, egress map[*target.Target]time.Time, failed map[*target.Target]bool) *routeProgress {
+	p := routeProgress{
+		pending:  make(map[*target.Target]bool),
+		inFlight: make(map[*target.Target]bool),
+		out:      make(map[*target.Target]bool),
+		failed:   failed,
+	}
+	for e := buffered.Front(); e != nil; e = e.Next() {
+		p.pending[e.Value.(*target.Target)] = true
+	}
+	if injecting != nil {
+		p.inFlight[injecting] = true
+	}
+	for t := range ingress {
+		if _, ok := egress[t]; !ok {
+			p.inFlight[t] = true
+		}
+	}
+	for t := range egress {
+		if !failed[t] {
+			p.out[t] = true
+		}
+	}
+	return &p
+}
+
+// recordRouting records the result of a routing block.
+func (tr *TestRunner) recordRouting(res routeResult) {
+	tr.state.SetRouting(res.bundle.TestStepLabel, res.err)
+	if res.progress != nil {
+		tr.state.progress[res.bundle.TestStepLabel] = res.progress
+	}
+}
+
+// position is the position of a target in a paused test: the index of the
+// step it is in, and whether it entered the step.
+type position struct {
+	step     int
+	inFlight bool
+}
+
+// position returns the position of a target in the pipeline, or false if the
+// target completed the test. resumedAt holds the positions the targets were
+// resumed from, if the test was resumed.
+func (tr *TestRunner) position(t *target.Target, resumedAt map[*target.Target]position) (position, bool) {
+	for i := len(tr.pipeline) - 1; i >= 0; i-- {
+		p := tr.state.progress[tr.pipeline[i]]
+		switch {
+		case p.failed[t]:
+			return position{}, false
+		case p.inFlight[t]:
+			return position{step: i, inFlight: true}, true
+		case p.pending[t]:
+			return position{step: i}, true
+		case p.out[t]:
+			if i == len(tr.pipeline)-1 {
+				return position{}, false
+			}
+			return position{step: i + 1}, true
+		}
+	}
+	// the target did not reach any routing block
+	if pos, ok := resumedAt[t]; ok {
+		return pos, true
+	}
+	if tr.resume != nil {
+		return position{}, false
+	}
+	return position{}, true
+}
+
+// Snapshot returns the progress of the targets in the steps of the last test
+// run by the TestRunner, once it returned because of a pause. targets are the
+// targets the test was run or resumed with.
+func (tr *TestRunner) Snapshot(targets []*target.Target) ([]job.StepState, error) {
+	if len(tr.pipeline) == 0 {
+		return nil, fmt.Errorf("no test was run")
+	}
+	steps := make([]job.StepState, len(tr.pipeline))
+	for i, label := range tr.pipeline {
+		if _, ok := tr.state.progress[label]; !ok {
+			return nil, fmt.Errorf("routing of step %s did not report its progress", label)
+		}
+		steps[i].TestStepLabel = label
+	}
+	resumedAt := make(map[*target.Target]position)
+	for _, s := range tr.resume {
+		for i, label := range tr.pipeline {
+			if label != s.TestStepLabel {
+				continue
+			}
+			for _, t := range s.Pending {
+				resumedAt[t] = position{step: i}
+			}
+			for _, t := range s.InFlight {
+				resumedAt[t] = position{step: i, inFlight: true}
+			}
+		}
+	}
+	for _, t := range targets {
+		pos, ok := tr.position(t, resumedAt)
+		if !ok {
+			continue
+		}
+		if pos.inFlight {
+			steps[pos.step].InFlight = append(steps[pos.step].InFlight, t)
+		} else {
+			steps[pos.step].Pending = append(steps[pos.step].Pending, t)
+		}
+	}
+	return steps, nil
+}
+
+// Resume is like Run, but resumes a paused test from the progress of its
+// steps, which refer to the targets by ID. The targets which completed the
+// test are not run again. The steps with targets in flight are resumed, hence
+// must support it, while the others are run.
+func (tr *TestRunner) Resume(cancel, pause <-chan struct{}, t *test.Test, targets []*target.Target, steps []job.StepState, jobID types.JobID, runID types.RunID) error {
+	byID := make(map[string]*target.Target, len(targets))
+	for _, tgt := range targets {
+		byID[tgt.ID] = tgt
+	}
+	lookup := func(ts []*target.Target) ([]*target.Target, error) {
+		found := make([]*target.Target, 0, len(ts))
+		for _, tgt := range ts {
+			if _, ok := byID[tgt.ID]; !ok {
+				return nil, fmt.Errorf("unknown target %s", tgt)
+			}
+			found = append(found, byID[tgt.ID])
+		}
+		return found, nil
+	}
+	bundles := make(map[string]test.TestStepBundle, len(t.TestStepsBundles))
+	for _, bundle := range t.TestStepsBundles {
+		bundles[bundle.TestStepLabel] = bundle
+	}
+	resume := make([]job.StepState, 0, len(steps))
+	for _, s := range steps {
+		bundle, ok := bundles[s.TestStepLabel]
+		if !ok {
+			return fmt.Errorf("cannot resume unknown step %s", s.TestStepLabel)
+		}
+		pending, err := lookup(s.Pending)
+		if err != nil {
+			return fmt.Errorf("cannot resume step %s: %v", s.TestStepLabel, err)
+		}
+		inFlight, err := lookup(s.InFlight)
+		if err != nil {
+			return fmt.Errorf("cannot resume step %s: %v", s.TestStepLabel, err)
+		}
+		if len(inFlight) > 0 && (!bundle.TestStep.CanResume() || bundle.Batch != nil) {
+			return &cerrors.ErrResumeNotSupported{StepName: bundle.TestStep.Name()}
+		}
+		resume = append(resume, job.StepState{TestStepLabel: s.TestStepLabel, Pending: pending, InFlight: inFlight})
+	}
+	tr.resume = resume
+
+	// all the targets are fed to the steps they were in
+	none := make(chan *target.Target)
+	close(none)
+	return tr.RunStream(cancel, pause, t, none, jobID, runID)
+}
+
+// resumedTargets returns the targets each step resumes with, by label, with
+// the targets in flight first, and their total number.
+func (tr *TestRunner) resumedTargets() (map[string][]*target.Target, int) {
+	resumed := make(map[string][]*target.Target, len(tr.resume))
+	n := 0
+	for _, s := range tr.resume {
+		resumed[s.TestStepLabel] = append(append([]*target.Target(nil), s.InFlight...), s.Pending...)
+		n += len(s.InFlight) + len(s.Pending)
+	}
+	return resumed, n
+}
+
+// resumesStep tells whether a step is resumed rather than run, i.e. whether
+// targets were in flight in it when the test was paused.
+func (tr *TestRunner) resumesStep(label string) bool {
+	for _, s := range tr.resume {
+		if s.TestStepLabel == label {
+			return len(s.InFlight) > 0
+		}
+	}
+	return false
+}
+
+// resumeInput returns the input of a routing block which resumes targets:
+// these come first, followed by the targets read from in. The returned
+// channel is closed once in is closed.
+func (tr *TestRunner) resumeInput(terminate <-chan struct{}, in <-chan *target.Target, resumed []*target.Target) chan *target.Target {
+	out := make(chan *target.Target)
+	go func() {
+		defer close(out)
+		for _, t := range resumed {
+			select {
+			case out <- t:
+			case <-terminate:
+				return
+			}
+		}
+		for {
+			select {
+			case t, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- t:
+				case <-terminate:
+					return
+				}
+			case <-terminate:
+				return
+			}
+		}
+	}()
+	return out
+}
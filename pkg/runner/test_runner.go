@@ -7,6 +7,7 @@ package runner
 
 import (
 	"container/list"
+	"context"
 	"encoding/json"
 	"fmt"
 	"runtime/debug"
@@ -15,8 +16,12 @@ import (
 
 	"github.com/facebookincubator/contest/pkg/cerrors"
 	"github.com/facebookincubator/contest/pkg/config"
+	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
 	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/secrets"
 	"github.com/facebookincubator/contest/pkg/storage"
 	"github.com/facebookincubator/contest/pkg/target"
 	"github.com/facebookincubator/contest/pkg/test"
@@ -25,6 +30,15 @@ import (
 
 var log = logging.GetLogger("pkg/test")
 
+// EventJobPausedAtBreakpoint indicates that targets are being held before
+// entering a test step marked as breakpoint, waiting for an operator to resume
+// the job.
+var EventJobPausedAtBreakpoint = event.Name("JobPausedAtBreakpoint")
+
+// EventJobResumedFromBreakpoint indicates that the targets held at a
+// breakpoint have been released.
+var EventJobResumedFromBreakpoint = event.Name("JobResumedFromBreakpoint")
+
 // TestRunnerTimeouts collects all the timeouts values that the test runner uses
 type TestRunnerTimeouts struct {
 	StepInjectTimeout   time.Duration
@@ -48,18 +62,32 @@ type routingCh struct {
 	stepIn  chan<- *target.Target
 	stepOut <-chan *target.Target
 	stepErr <-chan cerrors.TargetError
+	// stepResult carries the verdicts of the targets, sent by the TestStep
+	// before forwarding them
+	stepResult <-chan test.TargetResult
 	// targetErr connects the routing block directly to the TestRunner. Failing
 	// targets are acquired by the TestRunner via this channel
 	targetErr chan<- cerrors.TargetError
+	// stepStart, if not nil, is closed to start the TestStep, before the first
+	// target is injected or the input of the TestStep is closed
+	stepStart chan<- struct{}
 }
 
 // stepCh represents a set of bidirectional channels that a TestStep and its associated
 // routing block use to communicate. The TestRunner forces the direction of each
 // channel when connecting the TestStep to the routing block.
 type stepCh struct {
-	stepIn  chan *target.Target
-	stepOut chan *target.Target
-	stepErr chan cerrors.TargetError
+	stepIn     chan *target.Target
+	stepOut    chan *target.Target
+	stepErr    chan cerrors.TargetError
+	stepResult chan test.TargetResult
+	// start, if not nil, delays the start of the TestStep until it is closed
+	start <-chan struct{}
+	// parameters, if not nil, returns the parameters of the TestStep when it
+	// starts
+	parameters func() test.TestStepParameters
+	// metrics records the metrics emitted by the TestStep
+	metrics test.Metrics
 }
 
 type injectionCh struct {
@@ -75,8 +103,9 @@ type injectionResult struct {
 
 // routeResult represents the result of routing block, possibly carrying error information
 type routeResult struct {
-	bundle test.TestStepBundle
-	err    error
+	bundle   test.TestStepBundle
+	err      error
+	progress *routeProgress
 }
 
 // stepResult represents the result of a TestStep, possibly carrying error information
@@ -99,6 +128,55 @@ type completionCh struct {
 type TestRunner struct {
 	state    *State
 	timeouts TestRunnerTimeouts
+	// breakpoints is used to hold targets at the steps marked as breakpoints.
+	// If nil, breakpoints are ignored.
+	breakpoints *job.Breakpoints
+	// shuffleSeed, if not nil, is the seed used to shuffle the order
+	// independent steps.
+	shuffleSeed *int64
+	// dryRun, if true, runs the test steps in dry run mode.
+	dryRun bool
+	// secrets are the resolved secrets of the job, if any.
+	secrets *secrets.Store
+	// metrics is the registry of the metrics emitted by the test steps. If
+	// nil, metrics.Default is used.
+	metrics *metrics.Registry
+	// strongReads, if true, makes the test steps fetch their events from the
+	// primary storage, see storage.NewStrongTestEventEmitterFetcher.
+	strongReads bool
+	// pipeline holds the labels of the steps of the last test run, in the
+	// order they ran.
+	pipeline []string
+	// resume, if not nil, is the progress of the steps a paused test is
+	// resumed from.
+	resume []job.StepState
+	// flow tracks the outcomes of the steps of the targets of the test run
+	flow *flow
+}
+
+// SetBreakpoints sets the object used to hold targets at the test steps marked
+// as breakpoints, and to wait for them to be released.
+func (tr *TestRunner) SetBreakpoints(b *job.Breakpoints) {
+	tr.breakpoints = b
+}
+
+// SetMetrics sets the registry of the metrics emitted by the test steps.
+func (tr *TestRunner) SetMetrics(r *metrics.Registry) {
+	tr.metrics = r
+}
+
+// SetStrongReads sets whether the test steps fetch events which must include
+// all the events stored so far, e.g. the events resumed steps emitted before
+// the job was paused.
+func (tr *TestRunner) SetStrongReads(strong bool) {
+	tr.strongReads = strong
+}
+
+func (tr *TestRunner) metricsRegistry() *metrics.Registry {
+	if tr.metrics == nil {
+		return metrics.Default
+	}
+	return tr.metrics
 }
 
 // WriteTargetErrorTimeout writes a TargetError object to a TargetError channel with timeout
@@ -152,6 +230,7 @@ func (tr *TestRunner) Route(terminateRoute <-chan struct{}, bundle test.TestStep
 	tRouteIn := routingCh.routeIn
 	tStepOut := routingCh.stepOut
 	tStepErr := routingCh.stepErr
+	tStepResult := routingCh.stepResult
 
 	// Channel that the injection goroutine uses to communicate back with the
 	// main routing logic
@@ -167,6 +246,13 @@ func (tr *TestRunner) Route(terminateRoute <-chan struct{}, bundle test.TestStep
 	// egress times and perform sanity checks on the input/output of the TestStep
 	ingressTarget := make(map[*target.Target]time.Time)
 	egressTarget := make(map[*target.Target]time.Time)
+	// `failedTarget` holds the targets which left the TestStep with an error
+	failedTarget := make(map[*target.Target]bool)
+	// `skippedTarget` holds the targets which went past the TestStep
+	skippedTarget := make(map[*target.Target]bool)
+	// `results` holds the verdicts reported by the TestStep for the targets
+	// which have not left it yet
+	results := make(map[*target.Target]test.TargetResult)
 
 	var (
 		err           error
@@ -175,17 +261,60 @@ func (tr *TestRunner) Route(terminateRoute <-chan struct{}, bundle test.TestStep
 		injectionWg   sync.WaitGroup
 	)
 
+	// If the step is a breakpoint, targets are buffered but not injected until
+	// the breakpoint is released. `breakpointCh` is set when the first target
+	// is held.
+	var (
+		holding      = bundle.Breakpoint && tr.breakpoints != nil
+		breakpointCh <-chan struct{}
+	)
+
+	// startStep starts the TestStep, if it is waiting for targets
+	stepStart := routingCh.stepStart
+	startStep := func() {
+		if stepStart != nil {
+			close(stepStart)
+			stepStart = nil
+		}
+	}
+
+	// injectNext starts injecting the next buffered target, unless an injection
+	// is in progress, targets are held at a breakpoint, or the step is already
+	// processing MaxInFlight targets. In the latter case, the injection resumes
+	// when a target leaves the step.
+	injectNext := func() {
+		if targets.Len() == 0 || pendingTarget != nil || holding {
+			return
+		}
+		if bundle.MaxInFlight > 0 && uint(len(ingressTarget)-len(egressTarget)) >= bundle.MaxInFlight {
+			return
+		}
+		startStep()
+		pendingTarget = targets.Back().Value.(*target.Target)
+		targets.Remove(targets.Back())
+		injectionWg.Add(1)
+		go tr.InjectTarget(terminateInjection, pendingTarget, injectionChannels, &injectionWg)
+	}
+
 	for {
 		select {
 		case <-terminateRoute:
 			err = fmt.Errorf("termination requested")
 			break
+		case <-breakpointCh:
+			log.Printf("step %s: breakpoint released, injecting %d held targets", bundle.TestStepLabel, targets.Len())
+			holding = false
+			breakpointCh = nil
+			if err := ev.Emit(testevent.Data{EventName: EventJobResumedFromBreakpoint}); err != nil {
+				log.Warningf("Could not emit %v event: %v", EventJobResumedFromBreakpoint, err)
+			}
+			injectNext()
 		case injectionResult := <-injectResultCh:
 			ingressTarget[pendingTarget] = time.Now()
 			pendingTarget = nil
 			if injectionResult.err != nil {
 				err = fmt.Errorf("routing failed while injecting a target: %v", injectionResult.err)
-				targetInErrEv := testevent.Data{EventName: target.EventTargetInErr, Target: injectionResult.target}
+				targetInErrEv := testevent.Data{EventName: target.EventTargetInErr, Target: injectionResult.target, Severity: testevent.SeverityError}
 				if err := ev.Emit(targetInErrEv); err != nil {
 					log.Warningf("Could not emit %v event for Target: %v", targetInErrEv, *injectionResult.target)
 				}
@@ -195,28 +324,44 @@ func (tr *TestRunner) Route(terminateRoute <-chan struct{}, bundle test.TestStep
 			if err := ev.Emit(targetInEv); err != nil {
 				log.Warningf("Could not emit %v event for Target: %v", targetInEv, *injectionResult.target)
 			}
-			if targets.Len() > 0 {
-				pendingTarget = targets.Back().Value.(*target.Target)
-				targets.Remove(targets.Back())
-				injectionWg.Add(1)
-				go tr.InjectTarget(terminateInjection, pendingTarget, injectionChannels, &injectionWg)
-			}
+			injectNext()
 		case t, chanIsOpen := <-tRouteIn:
 			if !chanIsOpen {
 				// The previous routing block has closed our input channel, signaling that
 				// no more Targets will come through. Block reading from this channel
 				tRouteIn = nil
+			} else if reason, skip := tr.flow.skip(t, bundle); skip {
+				// The target goes past the step, straight to the next routing block
+				skippedTarget[t] = true
+				if err := emitTargetSkipped(ev, t, reason); err != nil {
+					log.Warningf("Could not emit %v event for Target: %v", EventTargetSkipped, *t)
+				}
+				if err := tr.WriteTargetTimeout(terminateRoute, routingCh.routeOut, t, tr.timeouts.MessageTimeout); err != nil {
+					log.Panicf("step %s: could not forward skipped target to the TestRunner: %+v", bundle.TestStepLabel, err)
+				}
 			} else {
 				// Buffer the target and check if there is already an injection in progress.
 				// If so, pending targets will be dequeued only at the next result available
 				// on `injectResultCh`.
 				targets.PushFront(t)
-				if pendingTarget == nil {
-					pendingTarget = targets.Back().Value.(*target.Target)
-					targets.Remove(targets.Back())
-					injectionWg.Add(1)
-					go tr.InjectTarget(terminateInjection, pendingTarget, injectionChannels, &injectionWg)
+				if holding {
+					if breakpointCh == nil {
+						log.Printf("step %s: breakpoint reached, holding targets", bundle.TestStepLabel)
+						breakpointCh = tr.breakpoints.Hold()
+						if err := ev.Emit(testevent.Data{EventName: EventJobPausedAtBreakpoint}); err != nil {
+							log.Warningf("Could not emit %v event: %v", EventJobPausedAtBreakpoint, err)
+						}
+					}
 				}
+				injectNext()
+			}
+		case result, chanIsOpen := <-tStepResult:
+			if !chanIsOpen {
+				tStepResult = nil
+			} else if !result.Verdict.Valid() || result.Target == nil {
+				log.Warningf("step %s reported an invalid result %+v, ignoring it", bundle.TestStepLabel, result)
+			} else {
+				results[result.Target] = result
 			}
 		case t, chanIsOpen := <-tStepOut:
 			if !chanIsOpen {
@@ -226,16 +371,36 @@ func (tr *TestRunner) Route(terminateRoute <-chan struct{}, bundle test.TestStep
 					err = fmt.Errorf("step %s returned target %+v multiple times", bundle.TestStepLabel, t)
 					break
 				}
+				// Targets forwarded without a result pass with no reason
+				outPayload := target.OutPayload{Verdict: string(test.VerdictPass)}
+				if result, ok := results[t]; ok {
+					delete(results, t)
+					if result.Verdict == test.VerdictFail {
+						log.Warningf("step %s forwarded target %v which it reported as failed, recording it as passed", bundle.TestStepLabel, t)
+					} else {
+						outPayload = target.OutPayload{Verdict: string(result.Verdict), Reason: result.Reason}
+					}
+				}
+				var rawPayload *json.RawMessage
+				if payloadEncoded, err := json.Marshal(outPayload); err != nil {
+					log.Warningf("could not encode target result %+v: %v", outPayload, err)
+				} else {
+					raw := json.RawMessage(payloadEncoded)
+					rawPayload = &raw
+				}
+				tr.flow.record(t, bundle.TestStepLabel, test.StepOutcome{Verdict: test.Verdict(outPayload.Verdict), Reason: outPayload.Reason})
 				// Emit an event signaling that the target has lef the TestStep
-				targetOutEv := testevent.Data{EventName: target.EventTargetOut, Target: t}
+				targetOutEv := testevent.Data{EventName: target.EventTargetOut, Target: t, Payload: rawPayload}
 				if err := ev.Emit(targetOutEv); err != nil {
 					log.Warningf("Could not emit %v event for Target: %v", targetOutEv, *t)
 				}
 				// Register egress time and forward target to the next routing block
 				egressTarget[t] = time.Now()
+				metrics.StepTarget(bundle.TestStepLabel, egressTarget[t].Sub(ingressTarget[t]), false)
 				if err := tr.WriteTargetTimeout(terminateRoute, routingCh.routeOut, t, tr.timeouts.MessageTimeout); err != nil {
 					log.Panicf("step %s: could not forward target to the TestRunner: %+v", bundle.TestStepLabel, err)
 				}
+				injectNext()
 			}
 		case targetError, chanIsOpen := <-tStepErr:
 			if !chanIsOpen {
@@ -245,24 +410,42 @@ func (tr *TestRunner) Route(terminateRoute <-chan struct{}, bundle test.TestStep
 					err = fmt.Errorf("step %s returned target %+v multiple times", bundle.TestStepLabel, targetError.Target)
 					break
 				}
+				// The error of a failed target is its reason
+				delete(results, targetError.Target)
+				targetError.Err = tr.secrets.RedactError(targetError.Err, redactedSecret)
 				// Emit an event signaling that the target has lef the TestStep with an error
-				targetErrPayload := target.ErrPayload{Error: targetError.Err.Error()}
+				targetErrPayload := target.ErrPayload{Error: targetError.Err.Error(), Informational: targetError.Target.Informational}
 				payloadEncoded, err := json.Marshal(targetErrPayload)
 				if err != nil {
-					log.Warningf("could not encode target error ('%s'): %v", targetErrPayload, err)
+					log.Warningf("could not encode target error (%+v): %v", targetErrPayload, err)
 				}
 
 				rawPayload := json.RawMessage(payloadEncoded)
 
-				targetErrEv := testevent.Data{EventName: target.EventTargetErr, Target: targetError.Target, Payload: &rawPayload}
+				// Failures of informational targets do not gate the outcome
+				severity := testevent.SeverityError
+				if targetError.Target.Informational {
+					severity = testevent.SeverityWarning
+				}
+				targetErrEv := testevent.Data{EventName: target.EventTargetErr, Target: targetError.Target, Payload: &rawPayload, Severity: severity}
 				if err := ev.Emit(targetErrEv); err != nil {
 					log.Warningf("Could not emit %v event for Target: %v", targetErrEv, *targetError.Target)
 				}
-				// Register egress time and forward the failing target to the TestRunner
+				// Register egress time and forward the failing target to the TestRunner,
+				// unless the step lets failed targets go on in the pipeline
 				egressTarget[targetError.Target] = time.Now()
-				if err := tr.WriteTargetErrorTimeout(terminateRoute, routingCh.targetErr, targetError, tr.timeouts.MessageTimeout); err != nil {
-					log.Panicf("step %s: could not forward target to the TestRunner: %+v", bundle.TestStepLabel, err)
+				metrics.StepTarget(bundle.TestStepLabel, egressTarget[targetError.Target].Sub(ingressTarget[targetError.Target]), true)
+				if tr.flow.fail(targetError.Target, bundle, targetError.Err) {
+					if err := tr.WriteTargetTimeout(terminateRoute, routingCh.routeOut, targetError.Target, tr.timeouts.MessageTimeout); err != nil {
+						log.Panicf("step %s: could not forward failed target to the TestRunner: %+v", bundle.TestStepLabel, err)
+					}
+				} else {
+					failedTarget[targetError.Target] = true
+					if err := tr.WriteTargetErrorTimeout(terminateRoute, routingCh.targetErr, targetError, tr.timeouts.MessageTimeout); err != nil {
+						log.Panicf("step %s: could not forward target to the TestRunner: %+v", bundle.TestStepLabel, err)
+					}
 				}
+				injectNext()
 			}
 		} // end of select statement
 
@@ -283,6 +466,7 @@ func (tr *TestRunner) Route(terminateRoute <-chan struct{}, bundle test.TestStep
 			// A TestStep is expected to always be reactive to cancellation even when
 			// acquiring targets from the input channel.
 			stepInClosed = true
+			startStep()
 			close(routingCh.stepIn)
 		}
 	}
@@ -317,8 +501,12 @@ func (tr *TestRunner) Route(terminateRoute <-chan struct{}, bundle test.TestStep
 	// the TestRunner is not responsive before `MessageTimeout`, we are either running
 	// on a system under heavy load which makes the runtime unable to properly schedule
 	// goroutines, or we are hitting a bug.
+	progress := newRouteProgress(targets, pendingTarget, ingressTarget, egressTarget, failedTarget)
+	for t := range skippedTarget {
+		progress.out[t] = true
+	}
 	select {
-	case resultCh <- routeResult{bundle: bundle, err: err}:
+	case resultCh <- routeResult{bundle: bundle, err: err, progress: progress}:
 	case <-time.After(tr.timeouts.MessageTimeout):
 		log.Panicf("could not send routing block result for step %s", bundle.TestStepLabel)
 	}
@@ -357,11 +545,46 @@ func (tr *TestRunner) RunTestStep(cancel, pause <-chan struct{}, bundle test.Tes
 	// conditions. If multiple error conditions occur, send downstream only
 	// the first error encountered.
 	channels := test.TestStepChannels{
-		In:  stepCh.stepIn,
-		Out: stepCh.stepOut,
-		Err: stepCh.stepErr,
+		In:     stepCh.stepIn,
+		Out:    stepCh.stepOut,
+		Err:    stepCh.stepErr,
+		Result: stepCh.stepResult,
+	}
+	var err error
+	if tr.waitStepStart(cancel, pause, stepCh.start) {
+		if stepCh.parameters != nil {
+			bundle.Parameters = stepCh.parameters()
+		}
+		// the bundle sent back keeps the secret references
+		stepBundle := bundle
+		stepBundle.Parameters, err = tr.expandSecrets(bundle.Parameters)
+		if err == nil {
+			ctx, ctxCancel := test.NewStepContext(context.Background(), cancel)
+			if tr.dryRun {
+				err = dryRunTestStep(ctx, pause, stepBundle, channels, ev)
+			} else {
+				if stepCh.metrics != nil {
+					ctx = test.WithMetrics(ctx, stepCh.metrics)
+				}
+				if batchStep, ok := stepBundle.TestStep.(test.BatchTestStep); ok && stepBundle.Batch != nil {
+					stepBundle.TestStep = test.WithBatching(batchStep, *stepBundle.Batch)
+				}
+				if stepBundle.Timeout > 0 || stepBundle.TargetTimeout > 0 {
+					stepBundle.TestStep = test.WithTimeout(stepBundle.TestStep, stepBundle.Timeout, stepBundle.TargetTimeout)
+				}
+				if stepBundle.Retry != nil && stepBundle.Retry.Count > 0 {
+					stepBundle.TestStep = test.WithRetry(stepBundle.TestStep, *stepBundle.Retry)
+				}
+				if tr.resumesStep(stepBundle.TestStepLabel) {
+					err = stepBundle.TestStep.Resume(ctx, pause, channels, stepBundle.Parameters, ev)
+				} else {
+					err = stepBundle.TestStep.Run(ctx, pause, channels, stepBundle.Parameters, ev)
+				}
+			}
+			ctxCancel()
+			err = tr.secrets.RedactError(err, redactedSecret)
+		}
 	}
-	err := bundle.TestStep.Run(cancel, pause, channels, bundle.Parameters, ev)
 
 	var (
 		cancellationAsserted bool
@@ -462,6 +685,21 @@ func (tr *TestRunner) RunTestStep(cancel, pause <-chan struct{}, bundle test.Tes
 	}
 }
 
+// waitStepStart waits for a delayed TestStep to be started. It returns false
+// if cancellation or pause are asserted in the meantime.
+func (tr *TestRunner) waitStepStart(cancel, pause, start <-chan struct{}) bool {
+	if start == nil {
+		return true
+	}
+	select {
+	case <-start:
+		return true
+	case <-cancel:
+	case <-pause:
+	}
+	return false
+}
+
 // WaitTestStep reads results coming from result channels until `StepShutdownTimeout`
 // occurs or an error is encountered. It then checks whether TestSteps and routing
 // blocks have all returned correctly. If not, it returns an error.
@@ -475,7 +713,7 @@ wait_test_step:
 			break wait_test_step
 		case res := <-ch.routingResultCh:
 			err = res.err
-			tr.state.SetRouting(res.bundle.TestStepLabel, res.err)
+			tr.recordRouting(res)
 		case res := <-ch.stepResultCh:
 			err = res.err
 			tr.state.SetStep(res.bundle.TestStepLabel, res.err)
@@ -515,7 +753,7 @@ func (tr *TestRunner) WaitPipelineTermination(ch completionCh, bundles []test.Te
 			}
 			return fmt.Errorf("pipeline did not return but all test steps completed")
 		case res := <-ch.routingResultCh:
-			tr.state.SetRouting(res.bundle.TestStepLabel, res.err)
+			tr.recordRouting(res)
 		case res := <-ch.stepResultCh:
 			tr.state.SetStep(res.bundle.TestStepLabel, res.err)
 		}
@@ -529,13 +767,19 @@ func (tr *TestRunner) WaitPipelineTermination(ch completionCh, bundles []test.Te
 }
 
 // WaitPipelineCompletion reads results coming from results channels until all Targets
-// have completed or an error occurs. If all Targets complete successfully, it checks
-// whether TestSteps and routing blocks have completed as well. If not, returns an
-// error. Termination is signalled via terminate channel.
-func (tr *TestRunner) WaitPipelineCompletion(terminate <-chan struct{}, ch completionCh, bundles []test.TestStepBundle, targets []*target.Target) error {
+// have completed or an error occurs. The number of Targets is received on
+// `injected` once they have all been injected into the pipeline. If all Targets
+// complete successfully, it checks whether TestSteps and routing blocks have
+// completed as well. If not, returns an error. Termination is signalled via
+// terminate channel.
+func (tr *TestRunner) WaitPipelineCompletion(terminate <-chan struct{}, ch completionCh, bundles []test.TestStepBundle, injected <-chan int) error {
 	var err error
+	// expected is the number of targets injected into the pipeline, or -1 if
+	// targets are still being injected
+	expected := -1
+	targetOut := ch.targetOut
 	for {
-		if len(tr.state.CompletedTargets()) == len(targets) {
+		if expected >= 0 && len(tr.state.CompletedTargets()) == expected {
 			break
 		}
 		if err != nil {
@@ -546,21 +790,35 @@ func (tr *TestRunner) WaitPipelineCompletion(terminate <-chan struct{}, ch compl
 			// When termination is signaled just stop WaitPipelineCompletion. It is up
 			// to the caller to decide how to further handle pipeline termination.
 			return nil
+		case n := <-injected:
+			expected = n
+			injected = nil
 		case res := <-ch.routingResultCh:
 			err = res.err
-			tr.state.SetRouting(res.bundle.TestStepLabel, res.err)
+			tr.recordRouting(res)
 		case res := <-ch.stepResultCh:
 			err = res.err
 			tr.state.SetStep(res.bundle.TestStepLabel, res.err)
 		case targetErr := <-ch.targetErr:
 			tr.state.SetTarget(targetErr.Target, targetErr.Err)
-		case target, chanIsOpen := <-ch.targetOut:
+		case target, chanIsOpen := <-targetOut:
 			if !chanIsOpen {
-				if len(tr.state.CompletedTargets()) != len(targets) {
+				targetOut = nil
+				if expected < 0 {
+					// the number of targets is always sent before closing the input of
+					// the pipeline, unless injection was terminated
+					select {
+					case expected = <-injected:
+						injected = nil
+					case <-terminate:
+						return nil
+					}
+				}
+				if len(tr.state.CompletedTargets()) != expected {
 					err = fmt.Errorf("not all targets completed, but output channel is closed")
 				}
 			} else {
-				tr.state.SetTarget(target, nil)
+				tr.state.SetTarget(target, tr.flow.failure(target))
 			}
 		}
 	}
@@ -579,10 +837,38 @@ func (tr *TestRunner) WaitPipelineCompletion(terminate <-chan struct{}, ch compl
 // Run implements the main logic of the TestRunner, i.e. the instantiation and
 // connection of the TestSteps, routing blocks and pipeline runner.
 func (tr *TestRunner) Run(cancel, pause <-chan struct{}, t *test.Test, targets []*target.Target, jobID types.JobID, runID types.RunID) error {
+	targetsCh := make(chan *target.Target, len(targets))
+	for _, target := range targets {
+		targetsCh <- target
+	}
+	close(targetsCh)
+	return tr.RunStream(cancel, pause, t, targetsCh, jobID, runID)
+}
+
+// RunStream is like Run, but the targets are read from a channel, and are fed
+// into the first TestStep as they arrive. The input channel of the first
+// TestStep is closed only once `targets` is closed, which allows to run
+// tests on targets that are acquired continuously.
+func (tr *TestRunner) RunStream(cancel, pause <-chan struct{}, t *test.Test, targets <-chan *target.Target, jobID types.JobID, runID types.RunID) error {
 	testStepBundles := t.TestStepsBundles
 	if len(testStepBundles) == 0 {
 		return fmt.Errorf("no steps to run for test")
 	}
+	if tr.shuffleSeed != nil {
+		testStepBundles = ShuffleTestSteps(testStepBundles, *tr.shuffleSeed)
+		ev := storage.NewTestEventEmitter(testevent.Header{JobID: jobID, RunID: runID, TestName: t.Name})
+		if err := emitStepsShuffled(ev, *tr.shuffleSeed, testStepBundles); err != nil {
+			log.Warningf("Could not emit %v event: %v", EventTestStepsShuffled, err)
+		}
+	}
+
+	tr.flow = newFlow()
+	tr.pipeline = make([]string, 0, len(testStepBundles))
+	for _, bundle := range testStepBundles {
+		tr.pipeline = append(tr.pipeline, bundle.TestStepLabel)
+	}
+	// the targets of a resumed test are fed to the steps they were in
+	resumed, resumedCount := tr.resumedTargets()
 
 	var (
 		cancellationAsserted bool
@@ -601,17 +887,34 @@ func (tr *TestRunner) Run(cancel, pause <-chan struct{}, t *test.Test, targets [
 	routingResultCh := make(chan routeResult)
 	stepResultCh := make(chan stepResult)
 	targetErrCh := make(chan cerrors.TargetError)
+	// injectedCh carries the number of targets fed into the pipeline, once the
+	// targets channel has been exhausted
+	injectedCh := make(chan int, 1)
 
 	var (
 		routeIn  chan *target.Target
 		routeOut chan *target.Target
 	)
 
+	// The steps from the first breakpoint onwards are started only once they
+	// receive targets, so that they use the parameters which may be updated
+	// when the job is resumed.
+	delayFrom := len(testStepBundles)
+	if tr.breakpoints != nil {
+		for r, testStepBundle := range testStepBundles {
+			if testStepBundle.Breakpoint {
+				delayFrom = r
+				break
+			}
+		}
+	}
+
 	for r, testStepBundle := range testStepBundles {
 		// Input and output channels for the TestStep
 		stepInCh := make(chan *target.Target)
 		stepOutCh := make(chan *target.Target)
 		stepErrCh := make(chan cerrors.TargetError)
+		stepResultsCh := make(chan test.TargetResult)
 
 		// Output of the current routing block
 		routeOut = make(chan *target.Target)
@@ -622,22 +925,52 @@ func (tr *TestRunner) Run(cancel, pause <-chan struct{}, t *test.Test, targets [
 			// Spawn a goroutine which injects Targets into the first routing block
 			go func(terminate <-chan struct{}, inputChannel chan<- *target.Target) {
 				defer close(inputChannel)
-				for _, target := range targets {
-					if err := tr.WriteTargetTimeout(terminate, inputChannel, target, tr.timeouts.MessageTimeout); err != nil {
-						log.Panic(fmt.Sprintf("could not inject target %+v into first routing block: %+v", target, err))
+				injected := 0
+				for {
+					select {
+					case <-terminate:
+						return
+					case target, ok := <-targets:
+						if !ok {
+							injectedCh <- injected + resumedCount
+							return
+						}
+						if err := tr.WriteTargetTimeout(terminate, inputChannel, target, tr.timeouts.MessageTimeout); err != nil {
+							log.Panic(fmt.Sprintf("could not inject target %+v into first routing block: %+v", target, err))
+						}
+						injected++
 					}
 				}
 			}(terminateInjection, routeIn)
 		}
 
-		stepChannels := stepCh{stepIn: stepInCh, stepErr: stepErrCh, stepOut: stepOutCh}
+		if ts := resumed[testStepBundle.TestStepLabel]; len(ts) > 0 {
+			routeIn = tr.resumeInput(terminateRouting, routeIn, ts)
+		}
+
+		stepChannels := stepCh{stepIn: stepInCh, stepErr: stepErrCh, stepOut: stepOutCh, stepResult: stepResultsCh}
+		stepChannels.metrics = metrics.NewStepMetrics(tr.metricsRegistry(), jobID, testStepBundle.TestStepLabel)
 		routingChannels := routingCh{
-			routeIn:   routeIn,
-			routeOut:  routeOut,
-			stepIn:    stepInCh,
-			stepErr:   stepErrCh,
-			stepOut:   stepOutCh,
-			targetErr: targetErrCh,
+			routeIn:    routeIn,
+			routeOut:   routeOut,
+			stepIn:     stepInCh,
+			stepErr:    stepErrCh,
+			stepOut:    stepOutCh,
+			stepResult: stepResultsCh,
+			targetErr:  targetErrCh,
+		}
+
+		if tr.breakpoints != nil {
+			key := job.StepKey{TestName: t.Name, TestStepLabel: testStepBundle.TestStepLabel}
+			defaults := testStepBundle.Parameters
+			stepChannels.parameters = func() test.TestStepParameters {
+				return tr.breakpoints.Parameters(runID, key, defaults)
+			}
+		}
+		if r >= delayFrom {
+			stepStartCh := make(chan struct{})
+			stepChannels.start = stepStartCh
+			routingChannels.stepStart = stepStartCh
 		}
 
 		// Build the Header that the the TestStep will be using for emitting events
@@ -647,9 +980,20 @@ func (tr *TestRunner) Run(cancel, pause <-chan struct{}, t *test.Test, targets [
 			TestName:      t.Name,
 			TestStepLabel: testStepBundle.TestStepLabel,
 		}
-		ev := storage.NewTestEventEmitterFetcher(Header)
+		var ev testevent.EmitterFetcher
+		if tr.strongReads {
+			ev = storage.NewStrongTestEventEmitterFetcher(Header)
+		} else {
+			ev = storage.NewTestEventEmitterFetcher(Header)
+		}
+		// the events of the step are its output, and are redacted first
+		var stepEv testevent.EmitterFetcher = &outputEmitter{EmitterFetcher: ev, flow: tr.flow, label: testStepBundle.TestStepLabel}
+		if tr.secrets != nil {
+			ev = &redactingEmitter{EmitterFetcher: ev, secrets: tr.secrets}
+			stepEv = &redactingEmitter{EmitterFetcher: stepEv, secrets: tr.secrets}
+		}
 		go tr.Route(terminateRouting, testStepBundle, routingChannels, routingResultCh, ev)
-		go tr.RunTestStep(cancelTestStep, pauseTestStep, testStepBundle, stepChannels, stepResultCh, ev)
+		go tr.RunTestStep(cancelTestStep, pauseTestStep, testStepBundle, stepChannels, stepResultCh, stepEv)
 		// The input of the next routing block is the output of the current routing block
 		routeIn = routeOut
 	}
@@ -674,7 +1018,7 @@ func (tr *TestRunner) Run(cancel, pause <-chan struct{}, t *test.Test, targets [
 	log.Printf("TestRunner: waiting for test to complete")
 
 	go func() {
-		errCh <- tr.WaitPipelineCompletion(terminateWaitCompletion, completionChannels, testStepBundles, targets)
+		errCh <- tr.WaitPipelineCompletion(terminateWaitCompletion, completionChannels, testStepBundles, injectedCh)
 	}()
 
 	select {
@@ -764,6 +1108,9 @@ type State struct {
 	completedSteps   map[string]error
 	completedRouting map[string]error
 	completedTargets map[*target.Target]error
+	// progress holds the progress of the targets of the routing blocks which
+	// returned
+	progress map[string]*routeProgress
 }
 
 // NewState initializes a State object.
@@ -772,6 +1119,7 @@ func NewState() *State {
 	r.completedSteps = make(map[string]error)
 	r.completedRouting = make(map[string]error)
 	r.completedTargets = make(map[*target.Target]error)
+	r.progress = make(map[string]*routeProgress)
 	return &r
 }
 
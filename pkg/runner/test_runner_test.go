@@ -0,0 +1,1086 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/reporters/targetsuccess"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/facebookincubator/contest/plugins/teststeps/cmd"
+	"github.com/facebookincubator/contest/plugins/teststeps/echo"
+	"github.com/facebookincubator/contest/plugins/teststeps/slowecho"
+	"github.com/insomniacslk/xjson"
+	"github.com/stretchr/testify/require"
+)
+
+var testTimeouts = TestRunnerTimeouts{
+	StepInjectTimeout:   5 * time.Second,
+	MessageTimeout:      2 * time.Second,
+	ShutdownTimeout:     2 * time.Second,
+	StepShutdownTimeout: 2 * time.Second,
+}
+
+func newTestTargets(names ...string) []*target.Target {
+	var targets []*target.Target
+	for _, name := range names {
+		targets = append(targets, &target.Target{Name: name, ID: name})
+	}
+	return targets
+}
+
+func newEchoBundle(label string) test.TestStepBundle {
+	return test.TestStepBundle{
+		TestStep:      echo.New(),
+		TestStepLabel: label,
+		Parameters:    test.TestStepParameters{"text": []test.Param{*test.NewParam("hello")}},
+	}
+}
+
+func countEvents(t *testing.T, jobID types.JobID, stepLabel string, name event.Name) int {
+	events, err := storage.NewTestEventFetcher().Fetch(
+		testevent.QueryJobID(jobID),
+		testevent.QueryTestStepLabel(stepLabel),
+		testevent.QueryEventName(name),
+	)
+	require.NoError(t, err)
+	return len(events)
+}
+
+func TestBreakpointHoldsAndReleasesTargets(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("1")
+
+	breakpoint := newEchoBundle("breakpoint")
+	breakpoint.Breakpoint = true
+	tst := test.Test{
+		Name:             "BreakpointTest",
+		TestStepsBundles: []test.TestStepBundle{newEchoBundle("first"), breakpoint},
+	}
+	targets := newTestTargets("t1", "t2", "t3")
+	breakpoints := job.NewBreakpoints()
+
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	tr.SetBreakpoints(breakpoints)
+	cancel, pause := make(chan struct{}), make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tr.Run(cancel, pause, &tst, targets, jobID, types.RunID(1))
+	}()
+
+	// wait for the breakpoint to be reached, and check that targets are held
+	require.Eventually(t, func() bool {
+		return countEvents(t, jobID, "breakpoint", EventJobPausedAtBreakpoint) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return countEvents(t, jobID, "first", target.EventTargetOut) == len(targets)
+	}, 5*time.Second, 10*time.Millisecond)
+	select {
+	case err := <-errCh:
+		t.Fatalf("test runner returned while targets were held at breakpoint: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	require.Equal(t, 0, countEvents(t, jobID, "breakpoint", target.EventTargetIn))
+	require.Equal(t, uint(1), breakpoints.Held())
+
+	// release the breakpoint and let the test complete
+	breakpoints.Release()
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("test runner did not complete after releasing the breakpoint")
+	}
+	require.Equal(t, len(targets), countEvents(t, jobID, "breakpoint", target.EventTargetOut))
+	require.Equal(t, 1, countEvents(t, jobID, "breakpoint", EventJobResumedFromBreakpoint))
+	for _, tgt := range targets {
+		require.NoError(t, tr.state.CompletedTargets()[tgt])
+	}
+}
+
+func TestBreakpointReleaseWithUpdatedParameters(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("12")
+
+	slow := test.TestStepBundle{
+		TestStep:      slowecho.New(),
+		TestStepLabel: "slow",
+		Parameters: test.TestStepParameters{
+			"text":  []test.Param{*test.NewParam("original")},
+			"sleep": []test.Param{*test.NewParam("9")},
+		},
+		Breakpoint: true,
+	}
+	tst := test.Test{
+		Name:             "ResumeTest",
+		TestStepsBundles: []test.TestStepBundle{newEchoBundle("first"), slow},
+	}
+	targets := newTestTargets("t1", "t2")
+	breakpoints := job.NewBreakpoints()
+
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	tr.SetBreakpoints(breakpoints)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, types.RunID(1))
+	}()
+	require.Eventually(t, func() bool {
+		return countEvents(t, jobID, "first", target.EventTargetOut) == len(targets) && breakpoints.Held() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the step before the breakpoint already started, the breakpoint step
+	// did not
+	first := job.StepKey{TestName: tst.Name, TestStepLabel: "first"}
+	err := breakpoints.ReleaseWithParameters(map[job.StepKey]test.TestStepParameters{first: newEchoBundle("first").Parameters})
+	require.Error(t, err)
+	require.Equal(t, uint(1), breakpoints.Held())
+
+	// without the updated sleep, the targets would not complete in time
+	key := job.StepKey{TestName: tst.Name, TestStepLabel: "slow"}
+	require.NoError(t, breakpoints.ReleaseWithParameters(map[job.StepKey]test.TestStepParameters{
+		key: {
+			"text":  []test.Param{*test.NewParam("updated")},
+			"sleep": []test.Param{*test.NewParam("0")},
+		},
+	}))
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("test runner did not complete with the updated parameters")
+	}
+
+	// the targets went through the first step only once
+	require.Equal(t, len(targets), countEvents(t, jobID, "first", target.EventTargetIn))
+	require.Equal(t, len(targets), countEvents(t, jobID, "first", target.EventTargetOut))
+	events, err := storage.NewTestEventFetcher().Fetch(
+		testevent.QueryJobID(jobID),
+		testevent.QueryTestStepLabel("slow"),
+		testevent.QueryEventName(slowecho.EventMessage),
+	)
+	require.NoError(t, err)
+	require.Len(t, events, len(targets))
+	for _, e := range events {
+		var payload struct{ Text string }
+		require.NoError(t, json.Unmarshal(*e.Data.Payload, &payload))
+		require.Equal(t, "updated", payload.Text)
+	}
+	for _, tgt := range targets {
+		require.NoError(t, tr.state.CompletedTargets()[tgt])
+	}
+}
+
+func TestBreakpointIgnoredWithoutController(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("2")
+
+	breakpoint := newEchoBundle("breakpoint")
+	breakpoint.Breakpoint = true
+	tst := test.Test{
+		Name:             "BreakpointTest",
+		TestStepsBundles: []test.TestStepBundle{breakpoint},
+	}
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	err := tr.Run(make(chan struct{}), make(chan struct{}), &tst, newTestTargets("t1"), jobID, types.RunID(1))
+	require.NoError(t, err)
+	require.Equal(t, 0, countEvents(t, jobID, "breakpoint", EventJobPausedAtBreakpoint))
+}
+
+func TestRunStreamTargetsInWaves(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("3")
+
+	tst := test.Test{
+		Name:             "StreamTest",
+		TestStepsBundles: []test.TestStepBundle{newEchoBundle("first"), newEchoBundle("second")},
+	}
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	targetsCh := make(chan *target.Target)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tr.RunStream(make(chan struct{}), make(chan struct{}), &tst, targetsCh, jobID, types.RunID(1))
+	}()
+
+	// first wave, wait for the targets to complete the test while the stream
+	// is still open
+	firstWave := newTestTargets("t1", "t2")
+	for _, tgt := range firstWave {
+		targetsCh <- tgt
+	}
+	require.Eventually(t, func() bool {
+		return countEvents(t, jobID, "second", target.EventTargetOut) == len(firstWave)
+	}, 5*time.Second, 10*time.Millisecond)
+	select {
+	case err := <-errCh:
+		t.Fatalf("test runner returned before the targets stream was closed: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// second wave, then signal exhaustion
+	secondWave := newTestTargets("t3", "t4", "t5")
+	for _, tgt := range secondWave {
+		targetsCh <- tgt
+	}
+	close(targetsCh)
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("test runner did not complete after the targets stream was closed")
+	}
+	require.Equal(t, len(firstWave)+len(secondWave), countEvents(t, jobID, "second", target.EventTargetOut))
+	for _, tgt := range append(firstWave, secondWave...) {
+		require.NoError(t, tr.state.CompletedTargets()[tgt])
+	}
+}
+
+func TestShuffleTestSteps(t *testing.T) {
+	// only b, d and e are order independent
+	labels := []string{"a", "b", "c", "d", "e", "f"}
+	independent := map[string]bool{"b": true, "d": true, "e": true}
+	var bundles []test.TestStepBundle
+	for _, l := range labels {
+		b := newEchoBundle(l)
+		b.OrderIndependent = independent[l]
+		bundles = append(bundles, b)
+	}
+
+	orders := make(map[string]bool)
+	for seed := int64(1); seed <= 20; seed++ {
+		shuffled := ShuffleTestSteps(bundles, seed)
+		require.Len(t, shuffled, len(bundles))
+		order := ""
+		for idx, b := range shuffled {
+			if !independent[labels[idx]] {
+				// dependent steps keep their declared position
+				require.Equal(t, labels[idx], b.TestStepLabel)
+			} else {
+				require.True(t, b.OrderIndependent)
+			}
+			order += b.TestStepLabel
+		}
+		orders[order] = true
+		// the same seed reproduces the same order
+		require.Equal(t, shuffled, ShuffleTestSteps(bundles, seed))
+	}
+	// independent steps do get permuted
+	require.Greater(t, len(orders), 1)
+	// the declared bundles are not modified
+	for idx, b := range bundles {
+		require.Equal(t, labels[idx], b.TestStepLabel)
+	}
+}
+
+func TestRunRecordsShuffleSeed(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("4")
+
+	first, second := newEchoBundle("first"), newEchoBundle("second")
+	first.OrderIndependent, second.OrderIndependent = true, true
+	tst := test.Test{
+		Name:             "ShuffleTest",
+		TestStepsBundles: []test.TestStepBundle{first, second},
+	}
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	tr.SetShuffleSeed(42)
+	err := tr.Run(make(chan struct{}), make(chan struct{}), &tst, newTestTargets("t1"), jobID, types.RunID(1))
+	require.NoError(t, err)
+
+	events, err := storage.NewTestEventFetcher().Fetch(testevent.QueryJobID(jobID), testevent.QueryEventName(EventTestStepsShuffled))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	var payload stepsShuffledPayload
+	require.NoError(t, json.Unmarshal(*events[0].Data.Payload, &payload))
+	require.Equal(t, int64(42), payload.Seed)
+	require.ElementsMatch(t, []string{"first", "second"}, payload.Order)
+	require.Equal(t, 1, countEvents(t, jobID, "second", target.EventTargetOut))
+}
+
+// verdictStep passes, fails or skips each target depending on its name.
+type verdictStep struct{}
+
+func (s verdictStep) Name() string { return "Verdict" }
+
+func (s verdictStep) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	output := test.NewStepOutput(cancel, pause, ch)
+	for t := range ch.In {
+		switch t.Name {
+		case "pass":
+			output.Pass(t, "all good")
+		case "fail":
+			output.Fail(t, "broken")
+		case "skip":
+			output.Skip(t, "not applicable")
+		default:
+			// forwarded without a result
+			ch.Out <- t
+		}
+	}
+	return nil
+}
+
+func (s verdictStep) CanResume() bool { return false }
+
+func (s verdictStep) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return nil
+}
+
+func (s verdictStep) ValidateParameters(params test.TestStepParameters) error { return nil }
+
+// statusReporter records the run status it is given.
+type statusReporter struct {
+	runStatus *job.RunStatus
+}
+
+func (r *statusReporter) ValidateRunParameters([]byte) (interface{}, error)   { return nil, nil }
+func (r *statusReporter) ValidateFinalParameters([]byte) (interface{}, error) { return nil, nil }
+func (r *statusReporter) Name() string                                        { return "Status" }
+
+func (r *statusReporter) RunReport(cancel <-chan struct{}, parameters interface{}, runStatus *job.RunStatus, ev testevent.Fetcher) (bool, interface{}, error) {
+	r.runStatus = runStatus
+	return true, nil, nil
+}
+
+func (r *statusReporter) FinalReport(cancel <-chan struct{}, parameters interface{}, runStatuses []job.RunStatus, ev testevent.Fetcher) (bool, interface{}, error) {
+	return true, nil, nil
+}
+
+func TestVerdictsReachReporter(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID, runID := types.JobID("5"), types.RunID(1)
+
+	tst := test.Test{
+		Name:             "VerdictTest",
+		TestStepsBundles: []test.TestStepBundle{{TestStep: verdictStep{}, TestStepLabel: "verdict"}},
+	}
+	targets := newTestTargets("pass", "fail", "skip", "plain")
+	// the JobRunner records the acquired targets before running the test
+	header := testevent.Header{JobID: jobID, RunID: runID, TestName: tst.Name}
+	for _, tgt := range targets {
+		require.NoError(t, storage.NewTestEventEmitter(header).Emit(testevent.Data{EventName: target.EventTargetAcquired, Target: tgt}))
+	}
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, runID))
+
+	jr := NewJobRunner()
+	runStatus, err := jr.BuildRunStatus(job.RunCoordinates{JobID: jobID, RunID: runID}, &job.Job{ID: jobID, Tests: []*test.Test{&tst}})
+	require.NoError(t, err)
+	reporter := statusReporter{}
+	_, _, err = reporter.RunReport(nil, nil, runStatus, storage.NewTestEventFetcher())
+	require.NoError(t, err)
+
+	require.Len(t, reporter.runStatus.TestStatuses, 1)
+	verdicts := make(map[string]job.TargetStatus)
+	for _, ts := range reporter.runStatus.TestStatuses[0].TargetStatuses {
+		require.NotNil(t, ts.Target)
+		verdicts[ts.Target.Name] = ts
+	}
+	require.Len(t, verdicts, 4)
+	require.Equal(t, test.VerdictPass, verdicts["pass"].Verdict)
+	require.Equal(t, "all good", verdicts["pass"].Reason)
+	require.Equal(t, test.VerdictFail, verdicts["fail"].Verdict)
+	require.Equal(t, "broken", verdicts["fail"].Reason)
+	require.Equal(t, "broken", verdicts["fail"].Error)
+	require.Equal(t, test.VerdictSkip, verdicts["skip"].Verdict)
+	require.Equal(t, "not applicable", verdicts["skip"].Reason)
+	require.Equal(t, test.VerdictPass, verdicts["plain"].Verdict)
+	require.Empty(t, verdicts["plain"].Reason)
+}
+
+func TestInformationalFailureDoesNotFailRun(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID, runID := types.JobID("6"), types.RunID(1)
+
+	tst := test.Test{
+		Name:             "InformationalTest",
+		TestStepsBundles: []test.TestStepBundle{{TestStep: verdictStep{}, TestStepLabel: "verdict"}},
+	}
+	canary := &target.Target{Name: "fail", ID: "canary", Informational: true}
+	targets := append(newTestTargets("pass"), canary)
+	header := testevent.Header{JobID: jobID, RunID: runID, TestName: tst.Name}
+	for _, tgt := range targets {
+		require.NoError(t, storage.NewTestEventEmitter(header).Emit(testevent.Data{EventName: target.EventTargetAcquired, Target: tgt}))
+	}
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, runID))
+
+	jr := NewJobRunner()
+	runStatus, err := jr.BuildRunStatus(job.RunCoordinates{JobID: jobID, RunID: runID}, &job.Job{ID: jobID, Tests: []*test.Test{&tst}})
+	require.NoError(t, err)
+	require.Len(t, runStatus.TestStatuses, 1)
+	var canaryStatus *job.TargetStatus
+	for i, ts := range runStatus.TestStatuses[0].TargetStatuses {
+		if ts.Target != nil && ts.Target.ID == canary.ID {
+			canaryStatus = &runStatus.TestStatuses[0].TargetStatuses[i]
+		}
+	}
+	// the failure is recorded
+	require.NotNil(t, canaryStatus)
+	require.True(t, canaryStatus.Informational)
+	require.Equal(t, test.VerdictFail, canaryStatus.Verdict)
+	require.Equal(t, "broken", canaryStatus.Error)
+
+	// but does not gate the run
+	reporter := targetsuccess.New()
+	params, err := reporter.ValidateRunParameters([]byte(`{"SuccessExpression": "=100%"}`))
+	require.NoError(t, err)
+	success, data, err := reporter.RunReport(nil, params, runStatus, storage.NewTestEventFetcher())
+	require.NoError(t, err)
+	require.True(t, success, "%v", data)
+	require.Contains(t, data, "Test InformationalTest: 1 informational targets out of 1 failed")
+}
+
+// sideEffectStep counts its runs, and does not support dry runs.
+type sideEffectStep struct {
+	runs int32
+}
+
+func (s *sideEffectStep) Name() string { return "SideEffect" }
+
+func (s *sideEffectStep) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	atomic.AddInt32(&s.runs, 1)
+	for t := range ch.In {
+		ch.Out <- t
+	}
+	return nil
+}
+
+func (s *sideEffectStep) CanResume() bool { return false }
+
+func (s *sideEffectStep) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return nil
+}
+
+func (s *sideEffectStep) ValidateParameters(params test.TestStepParameters) error { return nil }
+
+func TestDryRunHasNoSideEffects(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID, runID := types.JobID("7"), types.RunID(1)
+	dir, err := ioutil.TempDir("", "contest-dryrun")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sideEffect := &sideEffectStep{}
+	tst := test.Test{
+		Name: "DryRunTest",
+		TestStepsBundles: []test.TestStepBundle{
+			{
+				TestStep:      cmd.New(),
+				TestStepLabel: "touch",
+				Parameters: test.TestStepParameters{
+					"executable": []test.Param{*test.NewParam("touch")},
+					"args":       []test.Param{*test.NewParam(filepath.Join(dir, "{{ .Name }}"))},
+				},
+			},
+			{TestStep: sideEffect, TestStepLabel: "side-effect"},
+			newEchoBundle("echo"),
+		},
+	}
+	targets := newTestTargets("a", "b")
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	tr.SetDryRun(true)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, runID))
+
+	// the command was not run, and the step without dry run support was skipped
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+	require.Equal(t, int32(0), atomic.LoadInt32(&sideEffect.runs))
+	require.Equal(t, 1, countEvents(t, jobID, "side-effect", EventTestStepSkippedDryRun))
+	// all the targets went through, and read only steps ran normally
+	require.Equal(t, 2, countEvents(t, jobID, "touch", target.EventTargetOut))
+	require.Equal(t, 2, countEvents(t, jobID, "side-effect", target.EventTargetOut))
+	require.Equal(t, 2, countEvents(t, jobID, "echo", target.EventTargetOut))
+	require.Equal(t, 0, countEvents(t, jobID, "touch", target.EventTargetErr))
+}
+
+// concurrentStep processes each target in its own goroutine for a while, and
+// records the maximum number of targets processed at once.
+type concurrentStep struct {
+	inFlight, maxInFlight int32
+}
+
+func (s *concurrentStep) Name() string { return "Concurrent" }
+
+func (s *concurrentStep) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	var wg sync.WaitGroup
+	for t := range ch.In {
+		wg.Add(1)
+		go func(t *target.Target) {
+			defer wg.Done()
+			n := atomic.AddInt32(&s.inFlight, 1)
+			for {
+				max := atomic.LoadInt32(&s.maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&s.maxInFlight, max, n) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			atomic.AddInt32(&s.inFlight, -1)
+			ch.Out <- t
+		}(t)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *concurrentStep) CanResume() bool { return false }
+
+func (s *concurrentStep) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return nil
+}
+
+func (s *concurrentStep) ValidateParameters(params test.TestStepParameters) error { return nil }
+
+func TestMaxInFlightPerStep(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("8")
+
+	wide, narrow, uncapped := &concurrentStep{}, &concurrentStep{}, &concurrentStep{}
+	tst := test.Test{
+		Name: "MaxInFlightTest",
+		TestStepsBundles: []test.TestStepBundle{
+			{TestStep: uncapped, TestStepLabel: "uncapped"},
+			{TestStep: wide, TestStepLabel: "wide", MaxInFlight: 3},
+			{TestStep: narrow, TestStepLabel: "narrow", MaxInFlight: 1},
+		},
+	}
+	targets := newTestTargets("t1", "t2", "t3", "t4", "t5", "t6")
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, types.RunID(1)))
+
+	require.Equal(t, int32(len(targets)), atomic.LoadInt32(&uncapped.maxInFlight))
+	require.Equal(t, int32(3), atomic.LoadInt32(&wide.maxInFlight))
+	require.Equal(t, int32(1), atomic.LoadInt32(&narrow.maxInFlight))
+	for _, label := range []string{"uncapped", "wide", "narrow"} {
+		require.Equal(t, len(targets), countEvents(t, jobID, label, target.EventTargetOut))
+	}
+	for _, tgt := range targets {
+		require.NoError(t, tr.state.CompletedTargets()[tgt])
+	}
+}
+
+// leakyStep emits the value of its password parameter, and fails the targets
+// with an error containing it.
+type leakyStep struct {
+	password string
+}
+
+func (s *leakyStep) Name() string { return "Leaky" }
+
+func (s *leakyStep) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	s.password = params.GetOne("password").Raw()
+	for t := range ch.In {
+		payload := json.RawMessage(fmt.Sprintf(`{"Password":%q}`, s.password))
+		if err := ev.Emit(testevent.Data{EventName: "Leak", Target: t, Payload: &payload}); err != nil {
+			return err
+		}
+		ch.Err <- cerrors.TargetError{Target: t, Err: fmt.Errorf("login with %s failed", s.password)}
+	}
+	return nil
+}
+
+func (s *leakyStep) CanResume() bool { return false }
+
+func (s *leakyStep) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return nil
+}
+
+func (s *leakyStep) ValidateParameters(params test.TestStepParameters) error { return nil }
+
+func TestSecretsAreRedacted(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("9")
+	require.NoError(t, os.Setenv("CONTEST_RUNNER_TEST_SECRET", "hunter2"))
+	defer os.Unsetenv("CONTEST_RUNNER_TEST_SECRET")
+	store, err := secrets.ResolveStore(map[string]string{"pw": "${env:CONTEST_RUNNER_TEST_SECRET}"})
+	require.NoError(t, err)
+
+	leaky := &leakyStep{}
+	params := test.TestStepParameters{"password": []test.Param{*test.NewParam("secret://pw")}}
+	tst := test.Test{
+		Name:             "SecretsTest",
+		TestStepsBundles: []test.TestStepBundle{{TestStep: leaky, TestStepLabel: "leaky", Parameters: params}},
+	}
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	tr.SetSecrets(store)
+	targets := newTestTargets("t1")
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, types.RunID(1)))
+
+	// the step got the value, while the bundle kept the reference
+	require.Equal(t, "hunter2", leaky.password)
+	require.Equal(t, "secret://pw", params.GetOne("password").Raw())
+	events, err := storage.NewTestEventFetcher().Fetch(testevent.QueryJobID(jobID))
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	for _, ev := range events {
+		if ev.Data.Payload != nil {
+			require.NotContains(t, string(*ev.Data.Payload), "hunter2")
+		}
+	}
+	require.Equal(t, 1, countEvents(t, jobID, "leaky", "Leak"))
+	require.Equal(t, "login with <redacted> failed", tr.state.CompletedTargets()[targets[0]].Error())
+}
+
+func TestStepMetricsReachRegistry(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("10")
+	tst := test.Test{
+		Name: "MetricsTest",
+		TestStepsBundles: []test.TestStepBundle{{
+			TestStep:      slowecho.New(),
+			TestStepLabel: "slow",
+			Parameters: test.TestStepParameters{
+				"text":  []test.Param{*test.NewParam("hello")},
+				"sleep": []test.Param{*test.NewParam("10ms")},
+			},
+		}},
+	}
+	registry := metrics.NewRegistry(metrics.DefaultMaxSeries)
+	metrics.SetServerRegistry(registry)
+	defer metrics.SetServerRegistry(nil)
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	tr.SetMetrics(registry)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, newTestTargets("t1", "t2"), jobID, types.RunID(1)))
+
+	encoded := string(registry.Encode())
+	require.Contains(t, encoded, `contest_step_slowecho_sleep_seconds_count{job_id="10",step="slow"} 2`)
+	// the runner records the throughput and latency of the step
+	require.Contains(t, encoded, `contest_runner_step_targets_total{result="pass",step="slow"} 2`)
+	require.Contains(t, encoded, `contest_runner_step_target_seconds_count{step="slow"} 2`)
+	require.Contains(t, encoded, `contest_events_emitted_total{kind="test"}`)
+	require.Contains(t, encoded, `contest_storage_query_seconds_count{operation="store_test_event"}`)
+}
+
+func newSlowTest(sleep string) test.Test {
+	return test.Test{
+		Name: "PauseTest",
+		TestStepsBundles: []test.TestStepBundle{
+			newEchoBundle("first"),
+			{
+				TestStep:      slowecho.New(),
+				TestStepLabel: "slow",
+				Parameters: test.TestStepParameters{
+					"text":  []test.Param{*test.NewParam("hello")},
+					"sleep": []test.Param{*test.NewParam(sleep)},
+				},
+			},
+		},
+	}
+}
+
+func TestPauseSnapshotAndResume(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID, runID := types.JobID("11"), types.RunID(1)
+
+	tst := newSlowTest("1h")
+	targets := newTestTargets("t1", "t2")
+	pause := make(chan struct{})
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tr.Run(make(chan struct{}), pause, &tst, targets, jobID, runID)
+	}()
+	require.Eventually(t, func() bool {
+		return countEvents(t, jobID, "slow", slowecho.EventTargetSleeping) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	close(pause)
+	require.NoError(t, <-errCh)
+
+	steps, err := tr.Snapshot(targets)
+	require.NoError(t, err)
+	require.Equal(t, []job.StepState{
+		{TestStepLabel: "first"},
+		{TestStepLabel: "slow", InFlight: targets},
+	}, steps)
+
+	// the snapshot refers to the targets by ID, as when the server restarted
+	tst = newSlowTest("10ms")
+	resumed := newTestTargets("t1", "t2")
+	tr = NewTestRunnerWithTimeouts(testTimeouts)
+	require.NoError(t, tr.Resume(make(chan struct{}), make(chan struct{}), &tst, resumed, steps, jobID, runID))
+	require.Len(t, tr.state.CompletedTargets(), 2)
+	for _, tgt := range resumed {
+		require.NoError(t, tr.state.CompletedTargets()[tgt])
+	}
+	// the first step did not run again
+	require.Equal(t, 2, countEvents(t, jobID, "first", target.EventTargetIn))
+	require.Equal(t, 4, countEvents(t, jobID, "slow", target.EventTargetIn))
+	require.Equal(t, 2, countEvents(t, jobID, "slow", target.EventTargetOut))
+
+	// all the targets completed the test
+	steps, err = tr.Snapshot(resumed)
+	require.NoError(t, err)
+	require.Equal(t, []job.StepState{{TestStepLabel: "first"}, {TestStepLabel: "slow"}}, steps)
+}
+
+func TestResumeRequiresResumableSteps(t *testing.T) {
+	storage.SetStorage(memory.New())
+	tst := test.Test{
+		Name:             "ResumeTest",
+		TestStepsBundles: []test.TestStepBundle{{TestStep: &leakyStep{}, TestStepLabel: "leaky"}},
+	}
+	targets := newTestTargets("t1")
+	steps := []job.StepState{{TestStepLabel: "leaky", InFlight: targets}}
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	err := tr.Resume(make(chan struct{}), make(chan struct{}), &tst, targets, steps, types.JobID("12"), types.RunID(1))
+	require.IsType(t, &cerrors.ErrResumeNotSupported{}, err)
+
+	steps = []job.StepState{{TestStepLabel: "unknown", Pending: targets}}
+	require.Error(t, tr.Resume(make(chan struct{}), make(chan struct{}), &tst, targets, steps, types.JobID("12"), types.RunID(1)))
+}
+
+// flakyStep fails the targets as many times as their count in failures.
+type flakyStep struct {
+	mu       sync.Mutex
+	failures map[string]int
+	runs     int
+}
+
+func (s *flakyStep) Name() string { return "Flaky" }
+
+func (s *flakyStep) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	s.mu.Lock()
+	s.runs++
+	s.mu.Unlock()
+	for t := range ch.In {
+		s.mu.Lock()
+		fail := s.failures[t.ID] > 0
+		if fail {
+			s.failures[t.ID]--
+		}
+		s.mu.Unlock()
+		if fail {
+			ch.Err <- cerrors.TargetError{Target: t, Err: fmt.Errorf("%s is flaky", t.ID)}
+		} else {
+			ch.Out <- t
+		}
+	}
+	return nil
+}
+
+func (s *flakyStep) CanResume() bool { return false }
+
+func (s *flakyStep) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return nil
+}
+
+func (s *flakyStep) ValidateParameters(params test.TestStepParameters) error { return nil }
+
+func TestRetryPolicyRetriesFailedTargets(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("13")
+	flaky := &flakyStep{failures: map[string]int{"once": 1, "always": 10}}
+	tst := test.Test{
+		Name: "RetryTest",
+		TestStepsBundles: []test.TestStepBundle{{
+			TestStep:      flaky,
+			TestStepLabel: "flaky",
+			Retry:         &test.RetryPolicy{Count: 2, Backoff: xjson.Duration(time.Millisecond)},
+		}},
+	}
+	targets := newTestTargets("ok", "once", "always")
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, types.RunID(1)))
+
+	require.Equal(t, 3, flaky.runs)
+	completed := tr.state.CompletedTargets()
+	require.NoError(t, completed[targets[0]])
+	require.NoError(t, completed[targets[1]])
+	require.EqualError(t, completed[targets[2]], "always is flaky")
+	require.Equal(t, 2, countEvents(t, jobID, "flaky", target.EventTargetOut))
+	require.Equal(t, 1, countEvents(t, jobID, "flaky", target.EventTargetErr))
+
+	events, err := storage.NewTestEventFetcher().Fetch(testevent.QueryJobID(jobID), testevent.QueryEventName(test.EventRetryAttempt))
+	require.NoError(t, err)
+	attempts := make(map[string][]int)
+	for _, ev := range events {
+		var payload test.RetryAttemptPayload
+		require.NoError(t, json.Unmarshal(*ev.Data.Payload, &payload))
+		require.Equal(t, 3, payload.MaxAttempts)
+		attempts[ev.Data.Target.ID] = append(attempts[ev.Data.Target.ID], payload.Attempt)
+	}
+	require.Equal(t, map[string][]int{"once": {1}, "always": {1, 2}}, attempts)
+}
+
+// stuckStep forwards the targets, except those in stuck, which it holds until
+// the step is cancelled, without ever returning them.
+type stuckStep struct {
+	stuck map[string]bool
+}
+
+func (s *stuckStep) Name() string { return "Stuck" }
+
+func (s *stuckStep) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	var held []*target.Target
+	for t := range ch.In {
+		if s.stuck[t.ID] {
+			held = append(held, t)
+			continue
+		}
+		ch.Out <- t
+	}
+	if len(held) > 0 {
+		<-ctx.Done()
+	}
+	return ctx.Err()
+}
+
+func (s *stuckStep) CanResume() bool { return false }
+
+func (s *stuckStep) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return nil
+}
+
+func (s *stuckStep) ValidateParameters(params test.TestStepParameters) error { return nil }
+
+func timedOutTargets(t *testing.T, jobID types.JobID) map[string]bool {
+	events, err := storage.NewTestEventFetcher().Fetch(testevent.QueryJobID(jobID), testevent.QueryEventName(test.EventTargetTimedOut))
+	require.NoError(t, err)
+	timedOut := make(map[string]bool)
+	for _, ev := range events {
+		var payload test.TargetTimedOutPayload
+		require.NoError(t, json.Unmarshal(*ev.Data.Payload, &payload))
+		timedOut[ev.Data.Target.ID] = payload.StepTimeout
+	}
+	return timedOut
+}
+
+func TestTargetTimeout(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("14")
+	tst := test.Test{
+		Name: "TargetTimeoutTest",
+		TestStepsBundles: []test.TestStepBundle{
+			{TestStep: &stuckStep{stuck: map[string]bool{"t2": true}}, TestStepLabel: "stuck", TargetTimeout: 50 * time.Millisecond},
+			newEchoBundle("after"),
+		},
+	}
+	targets := newTestTargets("t1", "t2", "t3")
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, types.RunID(1)))
+
+	completed := tr.state.CompletedTargets()
+	require.NoError(t, completed[targets[0]])
+	require.EqualError(t, completed[targets[1]], "target did not leave step Stuck within 50ms")
+	require.NoError(t, completed[targets[2]])
+	require.Equal(t, map[string]bool{"t2": false}, timedOutTargets(t, jobID))
+	require.Equal(t, 1, countEvents(t, jobID, "stuck", target.EventTargetErr))
+	require.Equal(t, 2, countEvents(t, jobID, "after", target.EventTargetOut))
+}
+
+func TestStepTimeout(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("15")
+	tst := test.Test{
+		Name: "StepTimeoutTest",
+		TestStepsBundles: []test.TestStepBundle{
+			{TestStep: &stuckStep{stuck: map[string]bool{"t1": true, "t2": true}}, TestStepLabel: "stuck", Timeout: 50 * time.Millisecond},
+		},
+	}
+	targets := newTestTargets("t1", "t2", "t3")
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, types.RunID(1)))
+
+	completed := tr.state.CompletedTargets()
+	require.EqualError(t, completed[targets[0]], "step Stuck timed out after 50ms")
+	require.EqualError(t, completed[targets[1]], "step Stuck timed out after 50ms")
+	require.NoError(t, completed[targets[2]])
+	require.Equal(t, map[string]bool{"t1": true, "t2": true}, timedOutTargets(t, jobID))
+}
+
+func TestOnFailureJumpsToDiagnose(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("16")
+	diagnose := newEchoBundle("diagnose")
+	var err error
+	diagnose.Condition, err = test.ParseCondition(`boot.verdict == "fail"`)
+	require.NoError(t, err)
+	tst := test.Test{
+		Name: "FlowTest",
+		TestStepsBundles: []test.TestStepBundle{
+			newEchoBundle("flash"),
+			{TestStep: &flakyStep{failures: map[string]int{"bad": 1}}, TestStepLabel: "boot", OnFailure: "diagnose"},
+			newEchoBundle("run"),
+			diagnose,
+		},
+	}
+	targets := newTestTargets("good", "bad")
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, types.RunID(1)))
+
+	completed := tr.state.CompletedTargets()
+	require.Len(t, completed, 2)
+	require.NoError(t, completed[targets[0]])
+	require.EqualError(t, completed[targets[1]], "bad is flaky")
+	require.Equal(t, 1, countEvents(t, jobID, "boot", target.EventTargetErr))
+	require.Equal(t, 1, countEvents(t, jobID, "run", target.EventTargetOut))
+	require.Equal(t, 1, countEvents(t, jobID, "run", EventTargetSkipped))
+	require.Equal(t, 1, countEvents(t, jobID, "diagnose", target.EventTargetOut))
+	require.Equal(t, 1, countEvents(t, jobID, "diagnose", EventTargetSkipped))
+
+	events, err := storage.NewTestEventFetcher().Fetch(testevent.QueryJobID(jobID), testevent.QueryTestStepLabel("diagnose"), testevent.QueryEventName(target.EventTargetOut))
+	require.NoError(t, err)
+	require.Equal(t, "bad", events[0].Data.Target.ID)
+}
+
+func TestOnFailureContinue(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("17")
+	tst := test.Test{
+		Name: "ContinueTest",
+		TestStepsBundles: []test.TestStepBundle{
+			{TestStep: &flakyStep{failures: map[string]int{"t1": 1}}, TestStepLabel: "flaky", OnFailure: test.FailureContinue},
+			newEchoBundle("after"),
+		},
+	}
+	targets := newTestTargets("t1", "t2")
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, types.RunID(1)))
+
+	completed := tr.state.CompletedTargets()
+	require.EqualError(t, completed[targets[0]], "t1 is flaky")
+	require.NoError(t, completed[targets[1]])
+	require.Equal(t, 2, countEvents(t, jobID, "after", target.EventTargetOut))
+}
+
+// rackStep records the batches it is run on. It fails the targets in
+// failures, and the whole batch of the targets in down.
+type rackStep struct {
+	mu       sync.Mutex
+	batches  [][]string
+	failures map[string]bool
+	down     map[string]bool
+}
+
+func (s *rackStep) Name() string { return "Rack" }
+
+func (s *rackStep) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return fmt.Errorf("rack step only runs in batches")
+}
+
+func (s *rackStep) RunBatch(ctx context.Context, targets []*target.Target, params test.TestStepParameters, ev testevent.Emitter) (map[*target.Target]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	failures := make(map[*target.Target]error)
+	for _, t := range targets {
+		ids = append(ids, t.ID)
+		if s.failures[t.ID] {
+			failures[t] = fmt.Errorf("%s did not power cycle", t.ID)
+		}
+	}
+	s.batches = append(s.batches, ids)
+	for _, t := range targets {
+		if s.down[t.ID] {
+			return nil, fmt.Errorf("rack of %s is down", t.ID)
+		}
+	}
+	return failures, nil
+}
+
+func (s *rackStep) CanResume() bool { return false }
+
+func (s *rackStep) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return nil
+}
+
+func (s *rackStep) ValidateParameters(params test.TestStepParameters) error { return nil }
+
+func TestBatchedStep(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("18")
+	rack := &rackStep{failures: map[string]bool{"a2": true}, down: map[string]bool{"b1": true}}
+	tst := test.Test{
+		Name: "BatchTest",
+		TestStepsBundles: []test.TestStepBundle{
+			{TestStep: rack, TestStepLabel: "power-cycle", Batch: &test.BatchPolicy{Size: 2}},
+			newEchoBundle("after"),
+		},
+	}
+	targets := newTestTargets("a1", "a2", "b1", "b2", "c1")
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, types.RunID(1)))
+
+	require.ElementsMatch(t, [][]string{{"a1", "a2"}, {"b1", "b2"}, {"c1"}}, rack.batches)
+	completed := tr.state.CompletedTargets()
+	require.NoError(t, completed[targets[0]])
+	require.EqualError(t, completed[targets[1]], "a2 did not power cycle")
+	require.EqualError(t, completed[targets[2]], "rack of b1 is down")
+	require.EqualError(t, completed[targets[3]], "rack of b1 is down")
+	require.NoError(t, completed[targets[4]])
+	require.Equal(t, 2, countEvents(t, jobID, "after", target.EventTargetOut))
+}
+
+// probeStep emits an event reporting the mode of each target, named after it.
+type probeStep struct{}
+
+func (s probeStep) Name() string { return "Probe" }
+
+func (s probeStep) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	for t := range ch.In {
+		payload := json.RawMessage(fmt.Sprintf(`{"Mode": %q}`, t.ID))
+		if err := ev.Emit(testevent.Data{EventName: "Probed", Target: t, Payload: &payload}); err != nil {
+			return err
+		}
+		ch.Out <- t
+	}
+	return nil
+}
+
+func (s probeStep) CanResume() bool { return false }
+
+func (s probeStep) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return nil
+}
+
+func (s probeStep) ValidateParameters(params test.TestStepParameters) error { return nil }
+
+func TestConditionOnStepOutput(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("18")
+	recovery := newEchoBundle("recovery")
+	var err error
+	recovery.Condition, err = test.ParseCondition(`probe.output.Mode == "recovery"`)
+	require.NoError(t, err)
+	tst := test.Test{
+		Name: "OutputTest",
+		TestStepsBundles: []test.TestStepBundle{
+			{TestStep: probeStep{}, TestStepLabel: "probe"},
+			recovery,
+		},
+	}
+	targets := newTestTargets("normal", "recovery")
+	tr := NewTestRunnerWithTimeouts(testTimeouts)
+	require.NoError(t, tr.Run(make(chan struct{}), make(chan struct{}), &tst, targets, jobID, types.RunID(1)))
+
+	require.Len(t, tr.state.CompletedTargets(), 2)
+	events, err := storage.NewTestEventFetcher().Fetch(testevent.QueryJobID(jobID), testevent.QueryTestStepLabel("recovery"), testevent.QueryEventName(target.EventTargetIn))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "recovery", events[0].Data.Target.ID)
+	require.Equal(t, 1, countEvents(t, jobID, "recovery", EventTargetSkipped))
+}
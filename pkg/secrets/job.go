@@ -0,0 +1,176 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// JobScheme is the scheme of the references to the secrets declared by a job.
+// A step parameter refers to the job secret `name` either as a whole with
+// `secret://name`, or within a longer string with `${secret:name}`.
+const JobScheme = "secret"
+
+// jobURIPrefix prefixes the parameters which are a job secret as a whole.
+const jobURIPrefix = JobScheme + "://"
+
+var (
+	secretNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	// declaredRe matches the values of the declared secrets, which must be
+	// a single reference.
+	declaredRe = regexp.MustCompile(`^` + refRe.String() + `$`)
+)
+
+func init() {
+	// job secrets are resolved from the Store of the job, the resolver makes
+	// Validate and Mask handle them like any other reference.
+	resolvers[JobScheme] = ResolverFunc(func(ref string) (string, error) {
+		return "", fmt.Errorf("job secret %q can only be resolved within its job", ref)
+	})
+}
+
+// ValidateDeclared checks the secrets declared by a job descriptor, mapping
+// names to references. The values must be references to be resolved when
+// the job starts, so that no secret is stored with the descriptor.
+func ValidateDeclared(declared map[string]string) error {
+	for name, value := range declared {
+		if !secretNameRe.MatchString(name) {
+			return fmt.Errorf("invalid job secret name %q", name)
+		}
+		m := declaredRe.FindStringSubmatch(value)
+		if m == nil {
+			return fmt.Errorf("job secret %s must be a single secret reference, e.g. ${env:VARIABLE}", name)
+		}
+		if m[1] == JobScheme {
+			return fmt.Errorf("job secret %s cannot refer to another job secret", name)
+		}
+		if _, err := getResolver(m[1]); err != nil {
+			return fmt.Errorf("invalid job secret %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// JobReferences returns the names of the job secrets referenced by s.
+func JobReferences(s string) []string {
+	var names []string
+	if strings.HasPrefix(s, jobURIPrefix) {
+		return []string{strings.TrimPrefix(s, jobURIPrefix)}
+	}
+	for _, m := range refRe.FindAllStringSubmatch(s, -1) {
+		if m[1] == JobScheme {
+			names = append(names, m[2])
+		}
+	}
+	return names
+}
+
+// Store holds the resolved secrets of a job. It only lives in memory, for
+// the lifetime of the job.
+type Store struct {
+	values map[string]string
+}
+
+// ResolveStore resolves the secrets declared by a job.
+func ResolveStore(declared map[string]string) (*Store, error) {
+	if err := ValidateDeclared(declared); err != nil {
+		return nil, err
+	}
+	s := Store{values: make(map[string]string, len(declared))}
+	for name, ref := range declared {
+		v, err := Expand(ref)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve job secret %s: %v", name, err)
+		}
+		s.values[name] = v
+	}
+	return &s, nil
+}
+
+// String does not print the values, so that jobs can be logged.
+func (s *Store) String() string {
+	if s == nil {
+		return "no secrets"
+	}
+	return fmt.Sprintf("%d secrets", len(s.values))
+}
+
+func (s *Store) lookup(name string) (string, error) {
+	if s != nil {
+		if v, ok := s.values[name]; ok {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("job secret %q is not declared", name)
+}
+
+// Expand replaces the job secret references in v with their values. The
+// other references are left untouched, to be expanded by the steps.
+func (s *Store) Expand(v string) (string, error) {
+	if strings.HasPrefix(v, jobURIPrefix) {
+		return s.lookup(strings.TrimPrefix(v, jobURIPrefix))
+	}
+	var expandErr error
+	expanded := refRe.ReplaceAllStringFunc(v, func(match string) string {
+		m := refRe.FindStringSubmatch(match)
+		if m[1] != JobScheme || expandErr != nil {
+			return match
+		}
+		value, err := s.lookup(m[2])
+		if err != nil {
+			expandErr = err
+		}
+		return value
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return expanded, nil
+}
+
+// Redact replaces the values of the secrets in str, including their JSON
+// encoded form, with the given placeholder.
+func (s *Store) Redact(str, placeholder string) string {
+	if s == nil {
+		return str
+	}
+	var values []string
+	for _, v := range s.values {
+		if v == "" {
+			continue
+		}
+		values = append(values, v)
+		if encoded, err := json.Marshal(v); err == nil {
+			if quoted := string(encoded[1 : len(encoded)-1]); quoted != v {
+				values = append(values, quoted)
+			}
+		}
+	}
+	// longer values first, in case a value contains another one
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		str = strings.Replace(str, v, placeholder, -1)
+	}
+	return str
+}
+
+// RedactError returns err with the values of the secrets redacted from its
+// message, or err itself if it does not contain any.
+func (s *Store) RedactError(err error, placeholder string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if redacted := s.Redact(msg, placeholder); redacted != msg {
+		return errors.New(redacted)
+	}
+	return err
+}
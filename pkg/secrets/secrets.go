@@ -0,0 +1,130 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package secrets resolves references to secrets, so that credentials do not
+// need to be stored in job descriptors. A reference has the form
+// `${scheme:ref}`, where the scheme selects the Resolver that is used to look
+// up `ref`. The `env` and `file` schemes are always available: the former
+// reads an environment variable of the ConTest server, the latter the content
+// of a file.
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Resolver looks up the value of a secret.
+type Resolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// ResolverFunc adapts a function to the Resolver interface.
+type ResolverFunc func(ref string) (string, error)
+
+// Resolve calls f(ref).
+func (f ResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+var (
+	lock      sync.RWMutex
+	resolvers = map[string]Resolver{
+		"env":  ResolverFunc(resolveEnv),
+		"file": ResolverFunc(resolveFile),
+	}
+)
+
+// refRe matches secret references. The scheme is made of lowercase letters,
+// digits and dashes, the reference cannot contain a closing brace.
+var refRe = regexp.MustCompile(`\$\{([a-z][a-z0-9-]*):([^}]+)\}`)
+
+func resolveEnv(ref string) (string, error) {
+	v, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+	return v, nil
+}
+
+func resolveFile(ref string) (string, error) {
+	data, err := ioutil.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Register makes a Resolver available for the given scheme.
+func Register(scheme string, r Resolver) error {
+	if !refRe.MatchString("${" + scheme + ":x}") {
+		return fmt.Errorf("invalid secret scheme %q", scheme)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := resolvers[scheme]; ok {
+		return fmt.Errorf("secret scheme %q is already registered", scheme)
+	}
+	resolvers[scheme] = r
+	return nil
+}
+
+func getResolver(scheme string) (Resolver, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	r, ok := resolvers[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown secret scheme %q", scheme)
+	}
+	return r, nil
+}
+
+// Validate checks that all the secret references in s use a registered
+// scheme. Secrets are not resolved.
+func Validate(s string) error {
+	for _, m := range refRe.FindAllStringSubmatch(s, -1) {
+		if _, err := getResolver(m[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expand replaces all the secret references in s with their values.
+func Expand(s string) (string, error) {
+	var expandErr error
+	expanded := refRe.ReplaceAllStringFunc(s, func(match string) string {
+		if expandErr != nil {
+			return ""
+		}
+		m := refRe.FindStringSubmatch(match)
+		r, err := getResolver(m[1])
+		if err != nil {
+			expandErr = err
+			return ""
+		}
+		v, err := r.Resolve(m[2])
+		if err != nil {
+			expandErr = fmt.Errorf("could not resolve secret %s: %v", match, err)
+			return ""
+		}
+		return v
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return expanded, nil
+}
+
+// Mask replaces all the secret references in s with the given placeholder,
+// e.g. to validate or log strings containing references without resolving
+// them.
+func Mask(s, placeholder string) string {
+	return refRe.ReplaceAllLiteralString(s, placeholder)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package secrets
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandEnvAndFile(t *testing.T) {
+	require.NoError(t, os.Setenv("CONTEST_SECRETS_TEST", "s3cr3t"))
+	defer os.Unsetenv("CONTEST_SECRETS_TEST")
+	dir, err := ioutil.TempDir("", "secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "password")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hunter2\n"), 0600))
+
+	s, err := Expand("user:${env:CONTEST_SECRETS_TEST}@host/${file:" + path + "}")
+	require.NoError(t, err)
+	require.Equal(t, "user:s3cr3t@host/hunter2", s)
+}
+
+func TestExpandErrors(t *testing.T) {
+	_, err := Expand("${env:CONTEST_SECRETS_TEST_UNSET}")
+	require.Error(t, err)
+	_, err = Expand("${unknown:foo}")
+	require.Error(t, err)
+	require.Error(t, Validate("a ${unknown:foo} b"))
+	require.NoError(t, Validate("a ${env:foo} b"))
+}
+
+func TestRegisterAndMask(t *testing.T) {
+	require.NoError(t, Register("test-vault", ResolverFunc(func(ref string) (string, error) {
+		if ref == "db" {
+			return "pw", nil
+		}
+		return "", errors.New("not found")
+	})))
+	require.Error(t, Register("test-vault", ResolverFunc(nil)))
+	require.Error(t, Register("Invalid", ResolverFunc(nil)))
+
+	s, err := Expand("${test-vault:db}")
+	require.NoError(t, err)
+	require.Equal(t, "pw", s)
+	require.Equal(t, "u:***@h", Mask("u:${test-vault:db}@h", "***"))
+}
+
+func TestJobSecrets(t *testing.T) {
+	require.NoError(t, os.Setenv("CONTEST_SECRETS_TEST", `pa"ss`))
+	defer os.Unsetenv("CONTEST_SECRETS_TEST")
+
+	require.Error(t, ValidateDeclared(map[string]string{"db": "plaintext"}))
+	require.Error(t, ValidateDeclared(map[string]string{"db": "${env:A}${env:B}"}))
+	require.Error(t, ValidateDeclared(map[string]string{"db": "${secret:other}"}))
+	require.Error(t, ValidateDeclared(map[string]string{"d b": "${env:A}"}))
+	_, err := ResolveStore(map[string]string{"db": "${env:CONTEST_SECRETS_TEST_UNSET}"})
+	require.Error(t, err)
+
+	s, err := ResolveStore(map[string]string{"db": "${env:CONTEST_SECRETS_TEST}"})
+	require.NoError(t, err)
+	require.NotContains(t, s.String(), `pa"ss`)
+
+	v, err := s.Expand("secret://db")
+	require.NoError(t, err)
+	require.Equal(t, `pa"ss`, v)
+	v, err = s.Expand("u:${secret:db}@${env:HOST}")
+	require.NoError(t, err)
+	require.Equal(t, `u:pa"ss@${env:HOST}`, v)
+	_, err = s.Expand("secret://unknown")
+	require.Error(t, err)
+	_, err = (*Store)(nil).Expand("${secret:db}")
+	require.Error(t, err)
+
+	require.Equal(t, []string{"db", "x"}, JobReferences("${secret:db}/${env:y}/${secret:x}"))
+	require.Equal(t, []string{"db"}, JobReferences("secret://db"))
+	require.NoError(t, Validate("${secret:db}"))
+	_, err = Expand("${secret:db}")
+	require.Error(t, err)
+
+	require.Equal(t, `{"Error":"auth *** failed"}`, s.Redact(`{"Error":"auth pa\"ss failed"}`, "***"))
+	require.Equal(t, "auth *** failed", s.RedactError(errors.New(`auth pa"ss failed`), "***").Error())
+	cleanErr := errors.New("failed")
+	require.Equal(t, cleanErr, s.RedactError(cleanErr, "***"))
+}
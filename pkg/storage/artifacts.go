@@ -0,0 +1,182 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// EventArtifactAttached is emitted by AttachArtifact for the target an
+// artifact was attached to. Its payload is an ArtifactPayload.
+var EventArtifactAttached = event.Name("ArtifactAttached")
+
+// MaxArtifactNameLength is the maximum length of the name of an artifact.
+const MaxArtifactNameLength = 255
+
+// ErrArtifactNotFound is returned by the artifact stores for unknown
+// artifacts.
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// ArtifactKey identifies an artifact attached to a target by a test of a job.
+type ArtifactKey struct {
+	JobID    types.JobID
+	TestName string
+	TargetID string
+	Name     string
+}
+
+// Validate checks that the key refers to a job, a test and a target, and has
+// a name.
+func (k ArtifactKey) Validate() error {
+	switch {
+	case k.JobID == "":
+		return errors.New("artifact has no job ID")
+	case k.TestName == "":
+		return errors.New("artifact has no test name")
+	case k.TargetID == "":
+		return errors.New("artifact has no target ID")
+	case k.Name == "":
+		return errors.New("artifact has no name")
+	case len(k.Name) > MaxArtifactNameLength:
+		return fmt.Errorf("artifact name is longer than %d bytes", MaxArtifactNameLength)
+	}
+	return nil
+}
+
+// Artifact describes a stored artifact.
+type Artifact struct {
+	ArtifactKey
+	Size      int64
+	StoreTime time.Time
+}
+
+// SortArtifacts sorts artifacts by test name, target ID and name, as
+// ListArtifacts returns them.
+func SortArtifacts(artifacts []Artifact) {
+	sort.Slice(artifacts, func(i, j int) bool {
+		a, b := artifacts[i], artifacts[j]
+		if a.TestName != b.TestName {
+			return a.TestName < b.TestName
+		}
+		if a.TargetID != b.TargetID {
+			return a.TargetID < b.TargetID
+		}
+		return a.Name < b.Name
+	})
+}
+
+// ArtifactPayload is the payload of the EventArtifactAttached events.
+type ArtifactPayload struct {
+	Name string
+	Size int64
+}
+
+// ArtifactStore defines the interface that artifact storage engines must
+// implement. Artifacts are files, too large to be event payloads, which test
+// steps collect from the targets.
+type ArtifactStore interface {
+	// PutArtifact stores the content read from r, replacing any artifact
+	// with the same key, and returns its size.
+	PutArtifact(key ArtifactKey, r io.Reader) (int64, error)
+	// GetArtifact returns the content of an artifact, or ErrArtifactNotFound.
+	GetArtifact(key ArtifactKey) (io.ReadCloser, error)
+	// ListArtifacts returns the artifacts of a job, sorted by test name,
+	// target ID and name.
+	ListArtifacts(jobID types.JobID) ([]Artifact, error)
+}
+
+// artifactStore is the artifact storage engine, if any.
+var artifactStore ArtifactStore
+
+// SetArtifactStore sets the storage engine of the artifacts. Without one,
+// artifacts cannot be attached.
+func SetArtifactStore(store ArtifactStore) {
+	artifactStore = store
+}
+
+// GetArtifactStore returns the storage engine of the artifacts, or nil if
+// there is none.
+func GetArtifactStore() ArtifactStore {
+	return artifactStore
+}
+
+// StoreArtifact stores an artifact, and returns its size.
+func StoreArtifact(key ArtifactKey, r io.Reader) (int64, error) {
+	if artifactStore == nil {
+		return 0, errors.New("could not store artifact: no artifact store is configured")
+	}
+	if err := key.Validate(); err != nil {
+		return 0, fmt.Errorf("could not store artifact: %v", err)
+	}
+	start := time.Now()
+	size, err := artifactStore.PutArtifact(key, r)
+	metrics.StorageQuery("put_artifact", start, err)
+	if err != nil {
+		return 0, fmt.Errorf("could not store artifact %s: %v", key.Name, err)
+	}
+	return size, nil
+}
+
+// OpenArtifact returns the content of an artifact, which the caller must
+// close. An error wrapping ErrArtifactNotFound is returned for unknown
+// artifacts.
+func OpenArtifact(key ArtifactKey) (io.ReadCloser, error) {
+	if artifactStore == nil {
+		return nil, fmt.Errorf("could not fetch artifact: %w", ErrArtifactNotFound)
+	}
+	if err := key.Validate(); err != nil {
+		return nil, fmt.Errorf("could not fetch artifact: %v", err)
+	}
+	start := time.Now()
+	rc, err := artifactStore.GetArtifact(key)
+	metrics.StorageQuery("get_artifact", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch artifact %s: %w", key.Name, err)
+	}
+	return rc, nil
+}
+
+// ListArtifacts returns the artifacts of a job. The list is empty if there is
+// no artifact store.
+func ListArtifacts(jobID types.JobID) ([]Artifact, error) {
+	if artifactStore == nil {
+		return nil, nil
+	}
+	start := time.Now()
+	artifacts, err := artifactStore.ListArtifacts(jobID)
+	metrics.StorageQuery("list_artifacts", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not list artifacts of job %s: %v", jobID, err)
+	}
+	return artifacts, nil
+}
+
+// AttachArtifact stores an artifact of the target, under the job and the test
+// of the emitter, and emits an EventArtifactAttached event for the target.
+func (e *TestEventEmitter) AttachArtifact(t *target.Target, name string, r io.Reader) error {
+	key := ArtifactKey{JobID: e.header.JobID, TestName: e.header.TestName, TargetID: t.ID, Name: name}
+	size, err := StoreArtifact(key, r)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(ArtifactPayload{Name: name, Size: size})
+	if err != nil {
+		return fmt.Errorf("could not encode artifact payload: %v", err)
+	}
+	raw := json.RawMessage(payload)
+	return e.Emit(testevent.Data{EventName: EventArtifactAttached, Target: t, Payload: &raw})
+}
@@ -6,49 +6,199 @@
 package storage
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
 	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
 )
 
 // TestEventEmitter implements Emitter interface from the testevent package
 type TestEventEmitter struct {
 	header testevent.Header
+
+	spansLock sync.Mutex
+	// spans holds the data of the open spans, used to emit the SpanEnded
+	// events.
+	spans map[testevent.SpanID]testevent.Data
 }
 
 // TestEventFetcher implements the Fetcher interface from the testevent package
 type TestEventFetcher struct {
+	// strong, if true, reads the events from the primary of storage engines
+	// which implement StrongTestEventReader.
+	strong bool
 }
 
 // TestEventEmitterFetcher implements Emitter and Fetcher interface of the testevent package
 type TestEventEmitterFetcher struct {
-	TestEventEmitter
+	*TestEventEmitter
 	TestEventFetcher
 }
 
 // Emit emits an event using the selected storage layer
-func (e TestEventEmitter) Emit(data testevent.Data) error {
+func (e *TestEventEmitter) Emit(data testevent.Data) error {
+	if data.Severity == "" {
+		data.Severity = testevent.SeverityInfo
+	} else if !data.Severity.Valid() {
+		return fmt.Errorf("could not emit event %s: invalid severity %q", data.EventName, data.Severity)
+	}
 	event := testevent.Event{Header: &e.header, Data: &data, EmitTime: time.Now()}
-	if err := storage.StoreTestEvent(event); err != nil {
+	start := time.Now()
+	err := storage.StoreTestEvent(event)
+	metrics.StorageQuery("store_test_event", start, err)
+	if err != nil {
 		return fmt.Errorf("could not persist event data %v: %v", data, err)
 	}
+	metrics.EventEmitted(metrics.TestEvents)
+	notifyTestEventSubscribers(event)
+	return nil
+}
+
+func newSpanID() (testevent.SpanID, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate span ID: %v", err)
+	}
+	return testevent.SpanID(hex.EncodeToString(b)), nil
+}
+
+// StartSpan emits a SpanStarted event and returns the ID of the new span
+func (e *TestEventEmitter) StartSpan(name string, target *target.Target, parent testevent.SpanID) (testevent.SpanID, error) {
+	e.spansLock.Lock()
+	defer e.spansLock.Unlock()
+	if _, ok := e.spans[parent]; parent != "" && !ok {
+		return "", fmt.Errorf("could not start span %s: parent span %s is not open", name, parent)
+	}
+	id, err := newSpanID()
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(testevent.SpanPayload{Name: name})
+	if err != nil {
+		return "", fmt.Errorf("could not encode span payload: %v", err)
+	}
+	rawPayload := json.RawMessage(payload)
+	data := testevent.Data{
+		EventName:    testevent.EventSpanStarted,
+		Target:       target,
+		Payload:      &rawPayload,
+		SpanID:       id,
+		ParentSpanID: parent,
+	}
+	if err := e.Emit(data); err != nil {
+		return "", err
+	}
+	if e.spans == nil {
+		e.spans = make(map[testevent.SpanID]testevent.Data)
+	}
+	e.spans[id] = testevent.Data{EventName: testevent.EventSpanEnded, Target: target, SpanID: id, ParentSpanID: parent}
+	return id, nil
+}
+
+// EndSpan emits a SpanEnded event for a span opened with StartSpan
+func (e *TestEventEmitter) EndSpan(id testevent.SpanID) error {
+	e.spansLock.Lock()
+	defer e.spansLock.Unlock()
+	data, ok := e.spans[id]
+	if !ok {
+		return fmt.Errorf("could not end span %s: span is not open", id)
+	}
+	if err := e.Emit(data); err != nil {
+		return err
+	}
+	delete(e.spans, id)
 	return nil
 }
 
+// Header returns the header of the events emitted by this emitter.
+func (e *TestEventEmitter) Header() testevent.Header {
+	return e.header
+}
+
 // Fetch retrieves events based on QueryFields that are used to build a Query object for TestEvents
 func (ev TestEventFetcher) Fetch(queryFields ...testevent.QueryField) ([]testevent.Event, error) {
 	eventQuery, err := testevent.QueryFields(queryFields).BuildQuery()
 	if err != nil {
 		return nil, fmt.Errorf("unable to build a query: %w", err)
 	}
-	return storage.GetTestEvents(eventQuery)
+	start := time.Now()
+	var events []testevent.Event
+	if sr, ok := storage.(StrongTestEventReader); ok && ev.strong {
+		events, err = sr.GetTestEventsStrong(eventQuery)
+	} else {
+		events, err = storage.GetTestEvents(eventQuery)
+	}
+	metrics.StorageQuery("get_test_events", start, err)
+	return events, err
+}
+
+// GetTestEventsPage fetches a page of the test events matching the query,
+// stored after the event with sequence number afterSequence. A page without
+// events holds an empty, non-nil slice.
+func GetTestEventsPage(eventQuery *testevent.Query, afterSequence uint64, limit int) (*testevent.Page, error) {
+	return getTestEventsPage(eventQuery, afterSequence, limit, false)
+}
+
+func getTestEventsPage(eventQuery *testevent.Query, afterSequence uint64, limit int, strong bool) (*testevent.Page, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("could not fetch test events: invalid page size %d", limit)
+	}
+	start := time.Now()
+	var (
+		page *testevent.Page
+		err  error
+	)
+	if sr, ok := storage.(StrongTestEventReader); ok && strong {
+		page, err = sr.GetTestEventsPageStrong(eventQuery, afterSequence, limit)
+	} else {
+		page, err = storage.GetTestEventsPage(eventQuery, afterSequence, limit)
+	}
+	metrics.StorageQuery("get_test_events_page", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch test events: %v", err)
+	}
+	if page.Events == nil {
+		page.Events = []testevent.Event{}
+	}
+	return page, nil
+}
+
+// FetchPage retrieves a page of the events matching the QueryFields, stored
+// after the event with sequence number afterSequence.
+func (ev TestEventFetcher) FetchPage(afterSequence uint64, limit int, queryFields ...testevent.QueryField) (*testevent.Page, error) {
+	eventQuery, err := testevent.QueryFields(queryFields).BuildQuery()
+	if err != nil {
+		return nil, fmt.Errorf("unable to build a query: %w", err)
+	}
+	return getTestEventsPage(eventQuery, afterSequence, limit, ev.strong)
+}
+
+// StreamEvents calls fn on the test events of a job for which filter returns
+// true, in the order in which they were stored. A nil filter matches all the
+// events. The events are fetched a page at a time, so that only one page is
+// held in memory, which makes it suitable for aggregating the events of large
+// jobs. Streaming stops at the first error returned by fn, which is returned
+// as it is.
+func StreamEvents(jobID types.JobID, filter func(testevent.Event) bool, fn func(testevent.Event) error) error {
+	return testevent.Stream(TestEventFetcher{}, func(ev testevent.Event) error {
+		if filter != nil && !filter(ev) {
+			return nil
+		}
+		return fn(ev)
+	}, testevent.QueryJobID(jobID))
 }
 
 // NewTestEventEmitter creates a new Emitter object associated with a Header
 func NewTestEventEmitter(header testevent.Header) testevent.Emitter {
-	return TestEventEmitter{header: header}
+	return &TestEventEmitter{header: header}
 }
 
 // NewTestEventFetcher creates a new Fetcher object associated with a Header
@@ -56,14 +206,30 @@ func NewTestEventFetcher() testevent.Fetcher {
 	return TestEventFetcher{}
 }
 
+// NewStrongTestEventFetcher creates a new Fetcher object which observes all
+// the events stored so far, even if the storage engine serves the test event
+// reads from replicas.
+func NewStrongTestEventFetcher() testevent.Fetcher {
+	return TestEventFetcher{strong: true}
+}
+
 // NewTestEventEmitterFetcher creates a new EmitterFetcher object associated with a Header
 func NewTestEventEmitterFetcher(header testevent.Header) testevent.EmitterFetcher {
 	return TestEventEmitterFetcher{
-		TestEventEmitter{header: header},
+		&TestEventEmitter{header: header},
 		TestEventFetcher{},
 	}
 }
 
+// NewStrongTestEventEmitterFetcher creates a new EmitterFetcher object
+// associated with a Header, which fetches like NewStrongTestEventFetcher.
+func NewStrongTestEventEmitterFetcher(header testevent.Header) testevent.EmitterFetcher {
+	return TestEventEmitterFetcher{
+		&TestEventEmitter{header: header},
+		TestEventFetcher{strong: true},
+	}
+}
+
 // FrameworkEventEmitter implements Emitter interface from the frameworkevent package
 type FrameworkEventEmitter struct {
 }
@@ -80,9 +246,14 @@ type FrameworkEventEmitterFetcher struct {
 
 // Emit emits an event using the selected storage engine
 func (ev FrameworkEventEmitter) Emit(event frameworkevent.Event) error {
-	if err := storage.StoreFrameworkEvent(event); err != nil {
+	start := time.Now()
+	err := storage.StoreFrameworkEvent(event)
+	metrics.StorageQuery("store_framework_event", start, err)
+	if err != nil {
 		return fmt.Errorf("could not persist event %v: %v", event, err)
 	}
+	metrics.EventEmitted(metrics.FrameworkEvents)
+	notifyJobSubscriptions(event.JobID, JobEvent{Framework: &event})
 	return nil
 }
 
@@ -92,7 +263,10 @@ func (ev FrameworkEventFetcher) Fetch(queryFields ...frameworkevent.QueryField)
 	if err != nil {
 		return nil, fmt.Errorf("unable to build a query: %w", err)
 	}
-	return storage.GetFrameworkEvent(eventQuery)
+	start := time.Now()
+	events, err := storage.GetFrameworkEvent(eventQuery)
+	metrics.StorageQuery("get_framework_event", start, err)
+	return events, err
 }
 
 // NewFrameworkEventEmitter creates a new Emitter object for framework events
@@ -0,0 +1,54 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTestEventsPage(t *testing.T) {
+	storage.SetStorage(memory.New())
+	query := &testevent.Query{Query: event.Query{JobID: "1"}}
+	_, err := storage.GetTestEventsPage(query, 0, 0)
+	require.Error(t, err)
+
+	// a job without events has an empty page
+	page, err := storage.GetTestEventsPage(query, 0, 10)
+	require.NoError(t, err)
+	require.NotNil(t, page.Events)
+	require.Empty(t, page.Events)
+	require.False(t, page.More)
+
+	emitter := storage.NewTestEventEmitter(testevent.Header{JobID: "1", RunID: 1})
+	other := storage.NewTestEventEmitter(testevent.Header{JobID: "2", RunID: 1})
+	for i := 0; i < 5; i++ {
+		require.NoError(t, emitter.Emit(testevent.Data{EventName: "Event"}))
+		require.NoError(t, other.Emit(testevent.Data{EventName: "Other"}))
+	}
+	page, err = storage.GetTestEventsPage(query, 0, 3)
+	require.NoError(t, err)
+	require.Len(t, page.Events, 3)
+	require.True(t, page.More)
+
+	// the last page ends at the last event of the job
+	last, err := storage.GetTestEventsPage(query, page.LastSequence, 3)
+	require.NoError(t, err)
+	require.Len(t, last.Events, 2)
+	require.False(t, last.More)
+	// the cursor of the last page returns no event, and stays the same
+	page, err = storage.GetTestEventsPage(query, last.LastSequence, 3)
+	require.NoError(t, err)
+	require.NotNil(t, page.Events)
+	require.Empty(t, page.Events)
+	require.False(t, page.More)
+	require.Equal(t, last.LastSequence, page.LastSequence)
+}
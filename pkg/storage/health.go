@@ -0,0 +1,71 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/target"
+)
+
+// StoreTargetOutcomes records the outcomes of a run on its targets.
+func StoreTargetOutcomes(outcomes []target.Outcome) error {
+	start := time.Now()
+	err := storage.StoreTargetOutcomes(outcomes)
+	metrics.StorageQuery("store_target_outcomes", start, err)
+	if err != nil {
+		return fmt.Errorf("could not store target outcomes: %v", err)
+	}
+	return nil
+}
+
+// GetTargetOutcomes fetches the latest outcomes of a target recorded at or
+// after since, the latest first.
+func GetTargetOutcomes(targetID string, since time.Time, limit int) ([]target.Outcome, error) {
+	start := time.Now()
+	outcomes, err := storage.GetTargetOutcomes(targetID, since, limit)
+	metrics.StorageQuery("get_target_outcomes", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch outcomes of target %s: %v", targetID, err)
+	}
+	return outcomes, nil
+}
+
+// StoreTargetHealth creates or replaces the health record of a target.
+func StoreTargetHealth(health *target.Health) error {
+	start := time.Now()
+	err := storage.StoreTargetHealth(health)
+	metrics.StorageQuery("store_target_health", start, err)
+	if err != nil {
+		return fmt.Errorf("could not store health of target %s: %v", health.TargetID, err)
+	}
+	return nil
+}
+
+// GetTargetHealth fetches the health record of a target.
+func GetTargetHealth(targetID string) (*target.Health, error) {
+	start := time.Now()
+	health, err := storage.GetTargetHealth(targetID)
+	metrics.StorageQuery("get_target_health", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch health of target %s: %v", targetID, err)
+	}
+	return health, nil
+}
+
+// GetQuarantinedTargets fetches the health records of the quarantined
+// targets, ordered by quarantine time.
+func GetQuarantinedTargets() ([]*target.Health, error) {
+	start := time.Now()
+	targets, err := storage.GetQuarantinedTargets()
+	metrics.StorageQuery("get_quarantined_targets", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch quarantined targets: %v", err)
+	}
+	return targets, nil
+}
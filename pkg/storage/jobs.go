@@ -5,9 +5,13 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"time"
 
+	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/metrics"
 	"github.com/facebookincubator/contest/pkg/types"
 )
 
@@ -29,20 +33,215 @@ type JobRequestEmitterFetcher struct {
 // Emit persists a new job request into storage
 func (rc JobRequestEmitter) Emit(request *job.Request) (types.JobID, error) {
 	var jobID types.JobID
+	start := time.Now()
 	jobID, err := storage.StoreJobRequest(request)
+	metrics.StorageQuery("store_job_request", start, err)
 	if err != nil {
 		return jobID, fmt.Errorf("could not store job request: %v", err)
 	}
 	return jobID, nil
 }
 
+// ErrBatchRolledBack is returned by EmitMany when a batch of job requests
+// could not be stored, in which case none of them was.
+type ErrBatchRolledBack struct {
+	Size int
+	Err  error
+}
+
+func (e *ErrBatchRolledBack) Error() string {
+	return fmt.Sprintf("could not store batch of %d job requests, the whole batch was rolled back: %v", e.Size, e.Err)
+}
+
+// EmitMany persists new job requests into storage atomically, and returns
+// their job IDs in the same order
+func (rc JobRequestEmitter) EmitMany(requests []*job.Request) ([]types.JobID, error) {
+	if len(requests) == 0 {
+		return []types.JobID{}, nil
+	}
+	start := time.Now()
+	jobIDs, err := storage.StoreJobRequests(requests)
+	metrics.StorageQuery("store_job_requests", start, err)
+	if err != nil {
+		return nil, &ErrBatchRolledBack{Size: len(requests), Err: err}
+	}
+	return jobIDs, nil
+}
+
+// ErrJobRequestNotFound is returned by the storage engines when there is no
+// job request with the requested job ID.
+type ErrJobRequestNotFound struct {
+	JobID types.JobID
+}
+
+func (e *ErrJobRequestNotFound) Error() string {
+	return fmt.Sprintf("could not find job request with id %v", e.JobID)
+}
+
+// ErrFetchInterrupted is returned by the context-aware fetchers when the
+// context is done before the storage engine answered. Err is the error of the
+// context, so that errors.Is(err, context.DeadlineExceeded) tells timeouts,
+// which are worth retrying, from not found and storage errors.
+type ErrFetchInterrupted struct {
+	JobID types.JobID
+	Err   error
+}
+
+func (e *ErrFetchInterrupted) Error() string {
+	return fmt.Sprintf("could not fetch job request with id %v: %v", e.JobID, e.Err)
+}
+
+// Unwrap returns the error of the context.
+func (e *ErrFetchInterrupted) Unwrap() error {
+	return e.Err
+}
+
 // Fetch fetches a Job request from storage based on job id
 func (rf JobRequestFetcher) Fetch(jobID types.JobID) (*job.Request, error) {
-	request, err := storage.GetJobRequest(jobID)
+	return rf.FetchWithContext(context.Background(), jobID)
+}
+
+// FetchWithContext fetches a Job request from storage based on job id. If ctx
+// is done first, an *ErrFetchInterrupted is returned right away, while the
+// storage call completes in the background. Errors of the storage engine are
+// wrapped, e.g. an *ErrJobRequestNotFound can be told with errors.As.
+func (rf JobRequestFetcher) FetchWithContext(ctx context.Context, jobID types.JobID) (*job.Request, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &ErrFetchInterrupted{JobID: jobID, Err: err}
+	}
+	type result struct {
+		request *job.Request
+		err     error
+	}
+	// buffered, so that a late answer does not block the storage call
+	resultCh := make(chan result, 1)
+	engine := storage
+	go func() {
+		start := time.Now()
+		request, err := engine.GetJobRequest(jobID)
+		metrics.StorageQuery("get_job_request", start, err)
+		resultCh <- result{request: request, err: err}
+	}()
+	select {
+	case r := <-resultCh:
+		if r.err != nil {
+			return nil, fmt.Errorf("could not fetch job request: %w", r.err)
+		}
+		return r.request, nil
+	case <-ctx.Done():
+		return nil, &ErrFetchInterrupted{JobID: jobID, Err: ctx.Err()}
+	}
+}
+
+// FetchEventCounts returns the number of test events emitted by a job, by
+// event name, as counted by the storage engine. The map is empty if the job
+// did not emit any event yet, and an *ErrJobRequestNotFound is returned if
+// the job does not exist.
+func (rf JobRequestFetcher) FetchEventCounts(jobID types.JobID) (map[event.Name]uint64, error) {
+	start := time.Now()
+	_, err := storage.GetJobRequest(jobID)
+	metrics.StorageQuery("get_job_request", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch job request: %w", err)
+	}
+	start = time.Now()
+	counts, err := storage.CountTestEvents(jobID)
+	metrics.StorageQuery("count_test_events", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not count test events of job %v: %v", jobID, err)
+	}
+	return counts, nil
+}
+
+// FetchMany fetches the Job requests matching a query from storage
+func (rf JobRequestFetcher) FetchMany(query job.JobQuery) ([]*job.Request, error) {
+	return GetJobRequests(query)
+}
+
+// GetJobRequests returns the job requests matching the query, ordered by
+// request time. It returns an empty slice if no request matches.
+func GetJobRequests(query job.JobQuery) ([]*job.Request, error) {
+	if query.Limit == 0 {
+		query.Limit = job.DefaultJobQueryLimit
+	}
+	start := time.Now()
+	requests, err := storage.GetJobRequests(query)
+	metrics.StorageQuery("get_job_requests", start, err)
 	if err != nil {
-		return nil, fmt.Errorf("could not fetch job request: %v", err)
+		return nil, fmt.Errorf("could not fetch job requests: %v", err)
+	}
+	if requests == nil {
+		requests = []*job.Request{}
+	}
+	return requests, nil
+}
+
+// NextJobSequence returns the next value of the job sequence, which the
+// sequential job IDs are allocated from.
+func NextJobSequence() (uint64, error) {
+	start := time.Now()
+	value, err := storage.NextJobSequence()
+	metrics.StorageQuery("next_job_sequence", start, err)
+	if err != nil {
+		return 0, fmt.Errorf("could not get next job sequence value: %v", err)
+	}
+	return value, nil
+}
+
+// ErrMultipleJobs is returned by GetJobByExternalID when more than one job
+// was submitted with the requested external ID.
+type ErrMultipleJobs struct {
+	ExternalID string
+	JobIDs     []types.JobID
+}
+
+func (e *ErrMultipleJobs) Error() string {
+	return fmt.Sprintf("found %d jobs with external ID %q: %v", len(e.JobIDs), e.ExternalID, e.JobIDs)
+}
+
+// GetJobsByExternalID returns the IDs of all the jobs submitted with the given
+// external ID, in submission order.
+func GetJobsByExternalID(externalID string) ([]types.JobID, error) {
+	if externalID == "" {
+		return nil, fmt.Errorf("external ID cannot be empty")
+	}
+	start := time.Now()
+	jobIDs, err := storage.GetJobIDsByExternalID(externalID)
+	metrics.StorageQuery("get_job_ids_by_external_id", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch jobs by external ID: %v", err)
+	}
+	return jobIDs, nil
+}
+
+// GetJobsBySeriesID returns the IDs of the jobs of a series, in submission
+// order.
+func GetJobsBySeriesID(seriesID string) ([]types.JobID, error) {
+	start := time.Now()
+	jobIDs, err := storage.GetJobIDsBySeriesID(seriesID)
+	metrics.StorageQuery("get_job_ids_by_series_id", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch jobs by series ID: %v", err)
+	}
+	return jobIDs, nil
+}
+
+// GetJobByExternalID returns the ID of the job submitted with the given
+// external ID. If more than one job matches, an *ErrMultipleJobs holding all
+// the matching job IDs is returned.
+func GetJobByExternalID(externalID string) (types.JobID, error) {
+	jobIDs, err := GetJobsByExternalID(externalID)
+	if err != nil {
+		return "", err
+	}
+	switch len(jobIDs) {
+	case 0:
+		return "", fmt.Errorf("no job found with external ID %q", externalID)
+	case 1:
+		return jobIDs[0], nil
+	default:
+		return "", &ErrMultipleJobs{ExternalID: externalID, JobIDs: jobIDs}
 	}
-	return request, nil
 }
 
 // NewJobRequestEmitter creates a JobRequestEmitter object
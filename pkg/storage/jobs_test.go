@@ -0,0 +1,88 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func newJobRequest(name string, jobID types.JobID) *job.Request {
+	return &job.Request{JobID: jobID, JobName: name, Requestor: "test", RequestTime: time.Now(), JobDescriptor: "{}"}
+}
+
+func TestEmitManyRollsBackTheWholeBatch(t *testing.T) {
+	storage.SetStorage(memory.New())
+	emitter := storage.NewJobRequestEmitter()
+	_, err := emitter.Emit(newJobRequest("existing", "taken"))
+	require.NoError(t, err)
+
+	// the last request reuses a job ID, which makes the batch invalid
+	batch := []*job.Request{newJobRequest("A", ""), newJobRequest("B", "custom"), newJobRequest("C", "taken")}
+	_, err = emitter.EmitMany(batch)
+	var rolledBack *storage.ErrBatchRolledBack
+	require.True(t, errors.As(err, &rolledBack), err)
+	require.Equal(t, 3, rolledBack.Size)
+
+	// nothing from the batch was stored
+	requests, err := storage.GetJobRequests(job.JobQuery{})
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	require.Equal(t, "existing", requests[0].JobName)
+	_, err = storage.NewJobRequestFetcher().Fetch("custom")
+	require.Error(t, err)
+	require.Equal(t, types.JobID(""), batch[0].JobID)
+
+	// the valid requests can be stored again
+	jobIDs, err := emitter.EmitMany(batch[:2])
+	require.NoError(t, err)
+	require.Equal(t, []types.JobID{"1", "custom"}, jobIDs)
+}
+
+func TestGetJobRequestsPaging(t *testing.T) {
+	storage.SetStorage(memory.New())
+	// no request matches yet
+	requests, err := storage.GetJobRequests(job.JobQuery{})
+	require.NoError(t, err)
+	require.NotNil(t, requests)
+	require.Empty(t, requests)
+
+	const total = job.DefaultJobQueryLimit + 5
+	emitter := storage.NewJobRequestEmitter()
+	for i := 0; i < total; i++ {
+		_, err := emitter.Emit(newJobRequest("job", ""))
+		require.NoError(t, err)
+	}
+	// a zero limit returns the default number of requests
+	requests, err = storage.GetJobRequests(job.JobQuery{})
+	require.NoError(t, err)
+	require.Len(t, requests, job.DefaultJobQueryLimit)
+	require.Equal(t, types.JobID("1"), requests[0].JobID)
+
+	// the last page holds the remaining requests, and the next one is empty
+	requests, err = storage.NewJobRequestFetcher().FetchMany(job.JobQuery{Offset: job.DefaultJobQueryLimit, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, requests, 5)
+	require.Equal(t, types.JobID("105"), requests[4].JobID)
+	requests, err = storage.GetJobRequests(job.JobQuery{Offset: total, Limit: 10})
+	require.NoError(t, err)
+	require.NotNil(t, requests)
+	require.Empty(t, requests)
+
+	// a state no job is in matches nothing
+	requests, err = storage.GetJobRequests(job.JobQuery{States: []event.Name{job.EventJobCompleted}})
+	require.NoError(t, err)
+	require.NotNil(t, requests)
+	require.Empty(t, requests)
+}
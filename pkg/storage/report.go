@@ -7,8 +7,10 @@ package storage
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/metrics"
 	"github.com/facebookincubator/contest/pkg/types"
 )
 
@@ -28,7 +30,10 @@ type JobReportEmitterFetcher struct {
 
 // Emit emits the report using the selected storage layer
 func (e JobReportEmitter) Emit(jobReport *job.JobReport) error {
-	if err := storage.StoreJobReport(jobReport); err != nil {
+	start := time.Now()
+	err := storage.StoreJobReport(jobReport)
+	metrics.StorageQuery("store_job_report", start, err)
+	if err != nil {
 		return fmt.Errorf("could not persist job report: %v", err)
 	}
 	return nil
@@ -36,7 +41,9 @@ func (e JobReportEmitter) Emit(jobReport *job.JobReport) error {
 
 // Fetch retrieves job report objects based on JobID
 func (ev JobReportFetcher) Fetch(jobID types.JobID) (*job.JobReport, error) {
+	start := time.Now()
 	report, err := storage.GetJobReport(jobID)
+	metrics.StorageQuery("get_job_report", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,98 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// RetentionPolicy bounds the test events and the reports kept by the storage
+// layer. The data of a job which ended is pruned once it was requested more
+// than MaxAge ago, or once MaxJobs more recent jobs ended. A zero value
+// disables the corresponding bound.
+type RetentionPolicy struct {
+	MaxAge  time.Duration
+	MaxJobs uint
+}
+
+// Enabled tells whether the policy prunes anything.
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxJobs > 0
+}
+
+// DeleteJobData deletes the test events and the reports of a job. Its request
+// and its framework events, which hold its state and its verdict, are kept.
+func DeleteJobData(jobID types.JobID) error {
+	start := time.Now()
+	err := storage.DeleteJobData(jobID)
+	metrics.StorageQuery("delete_job_data", start, err)
+	if err != nil {
+		return fmt.Errorf("could not delete the data of job %s: %v", jobID, err)
+	}
+	return nil
+}
+
+// pruneQueryLimit is the number of job requests fetched at once when looking
+// for the jobs to prune.
+const pruneQueryLimit = 1000
+
+// Pruner applies a retention policy to the jobs which ended. It remembers the
+// jobs it pruned, so that their data is not deleted again at each round.
+type Pruner struct {
+	policy RetentionPolicy
+	pruned map[types.JobID]bool
+}
+
+// NewPruner returns a Pruner applying the given policy.
+func NewPruner(policy RetentionPolicy) *Pruner {
+	return &Pruner{policy: policy, pruned: make(map[types.JobID]bool)}
+}
+
+// Prune deletes the data of the ended jobs which the policy does not retain
+// anymore at time now, and returns their IDs.
+func (p *Pruner) Prune(now time.Time) ([]types.JobID, error) {
+	if !p.policy.Enabled() {
+		return nil, nil
+	}
+	// only the IDs and the request times of the ended jobs are kept, as
+	// there may be many of them.
+	type endedJob struct {
+		id          types.JobID
+		requestTime time.Time
+	}
+	var ended []endedJob
+	for offset := uint(0); ; offset += pruneQueryLimit {
+		requests, err := GetJobRequests(job.JobQuery{States: job.CompletionEvents, Offset: offset, Limit: pruneQueryLimit})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range requests {
+			ended = append(ended, endedJob{id: r.JobID, requestTime: r.RequestTime})
+		}
+		if len(requests) < pruneQueryLimit {
+			break
+		}
+	}
+	var pruned []types.JobID
+	for i, j := range ended {
+		tooMany := p.policy.MaxJobs > 0 && uint(len(ended)-i) > p.policy.MaxJobs
+		tooOld := p.policy.MaxAge > 0 && now.Sub(j.requestTime) > p.policy.MaxAge
+		if p.pruned[j.id] || (!tooMany && !tooOld) {
+			continue
+		}
+		if err := DeleteJobData(j.id); err != nil {
+			return pruned, err
+		}
+		p.pruned[j.id] = true
+		pruned = append(pruned, j.id)
+	}
+	return pruned, nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// storeJob stores a job requested at the given time, with a test event and a
+// report, and ends it if ended is set.
+func storeJob(t *testing.T, requestTime time.Time, ended bool) types.JobID {
+	jobID, err := storage.NewJobRequestEmitter().Emit(&job.Request{JobName: "job", Requestor: "test", RequestTime: requestTime, JobDescriptor: "{}"})
+	require.NoError(t, err)
+	require.NoError(t, storage.NewTestEventEmitter(testevent.Header{JobID: jobID, RunID: 1}).Emit(testevent.Data{EventName: "Event"}))
+	require.NoError(t, storage.NewJobReportEmitter().Emit(&job.JobReport{JobID: jobID, FinalReports: []*job.Report{{ReporterName: "noop"}}}))
+	if ended {
+		require.NoError(t, storage.NewFrameworkEventEmitter().Emit(frameworkevent.Event{JobID: jobID, EventName: job.EventJobCompleted, EmitTime: requestTime}))
+	}
+	return jobID
+}
+
+func countEvents(t *testing.T, jobID types.JobID) int {
+	events, err := storage.NewTestEventFetcher().Fetch(testevent.QueryJobID(jobID))
+	require.NoError(t, err)
+	return len(events)
+}
+
+func TestPrune(t *testing.T) {
+	storage.SetStorage(memory.New())
+	now := time.Now()
+	old := storeJob(t, now.Add(-48*time.Hour), true)
+	running := storeJob(t, now.Add(-47*time.Hour), false)
+	second := storeJob(t, now.Add(-2*time.Hour), true)
+	third := storeJob(t, now.Add(-time.Hour), true)
+	latest := storeJob(t, now, true)
+
+	pruner := storage.NewPruner(storage.RetentionPolicy{MaxAge: 24 * time.Hour, MaxJobs: 2})
+	pruned, err := pruner.Prune(now)
+	require.NoError(t, err)
+	// the jobs which did not end are never pruned
+	require.Equal(t, []types.JobID{old, second}, pruned)
+	for _, jobID := range []types.JobID{old, second} {
+		require.Zero(t, countEvents(t, jobID))
+		report, err := storage.NewJobReportFetcher().Fetch(jobID)
+		require.NoError(t, err)
+		require.Empty(t, report.FinalReports)
+		// the job itself is kept
+		_, err = storage.NewJobRequestFetcher().Fetch(jobID)
+		require.NoError(t, err)
+	}
+	for _, jobID := range []types.JobID{running, third, latest} {
+		require.Equal(t, 1, countEvents(t, jobID))
+	}
+
+	// pruned jobs are not pruned again
+	pruned, err = pruner.Prune(now)
+	require.NoError(t, err)
+	require.Empty(t, pruned)
+
+	pruned, err = storage.NewPruner(storage.RetentionPolicy{}).Prune(now)
+	require.NoError(t, err)
+	require.Empty(t, pruned)
+}
+
+func TestStreamEventsAfterPrune(t *testing.T) {
+	storage.SetStorage(memory.New())
+	pruned := storeJob(t, time.Now(), true)
+	jobID := types.JobID("42")
+	emitter := storage.NewTestEventEmitter(testevent.Header{JobID: jobID, RunID: 1})
+	for i := 0; i < 2*testevent.StreamPageSize+1; i++ {
+		require.NoError(t, emitter.Emit(testevent.Data{EventName: "Event"}))
+	}
+	require.NoError(t, storage.DeleteJobData(pruned))
+
+	count := 0
+	require.NoError(t, testevent.Stream(storage.NewTestEventFetcher(), func(ev testevent.Event) error {
+		require.Equal(t, jobID, ev.Header.JobID)
+		count++
+		return nil
+	}, testevent.QueryJobID(jobID)))
+	require.Equal(t, 2*testevent.StreamPageSize+1, count)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// StoreJobSchedule validates the recurrence of a job schedule and stores it,
+// and returns its ID.
+func StoreJobSchedule(schedule *job.Schedule) (uint64, error) {
+	if err := schedule.Recurrence.Validate(); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	id, err := storage.StoreJobSchedule(schedule)
+	metrics.StorageQuery("store_job_schedule", start, err)
+	if err != nil {
+		return 0, fmt.Errorf("could not store job schedule: %v", err)
+	}
+	return id, nil
+}
+
+// GetJobSchedule fetches a job schedule.
+func GetJobSchedule(id uint64) (*job.Schedule, error) {
+	start := time.Now()
+	schedule, err := storage.GetJobSchedule(id)
+	metrics.StorageQuery("get_job_schedule", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch job schedule: %v", err)
+	}
+	return schedule, nil
+}
+
+// GetJobSchedules fetches all the job schedules, ordered by ID.
+func GetJobSchedules() ([]*job.Schedule, error) {
+	start := time.Now()
+	schedules, err := storage.GetJobSchedules()
+	metrics.StorageQuery("get_job_schedules", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch job schedules: %v", err)
+	}
+	return schedules, nil
+}
+
+// ClaimJobScheduleRun claims the next run of an active schedule, due at
+// nextRun, and sets its next run to followingRun. Only one of the servers
+// sharing the storage engine can claim a run.
+func ClaimJobScheduleRun(id uint64, nextRun, followingRun time.Time) (bool, error) {
+	start := time.Now()
+	claimed, err := storage.ClaimJobScheduleRun(id, nextRun, followingRun)
+	metrics.StorageQuery("claim_job_schedule_run", start, err)
+	if err != nil {
+		return false, fmt.Errorf("could not claim run of job schedule %d: %v", id, err)
+	}
+	return claimed, nil
+}
+
+// RecordJobScheduleRun records a job submitted by a schedule.
+func RecordJobScheduleRun(id uint64, jobID types.JobID) error {
+	start := time.Now()
+	err := storage.RecordJobScheduleRun(id, jobID)
+	metrics.StorageQuery("record_job_schedule_run", start, err)
+	if err != nil {
+		return fmt.Errorf("could not record job %s of schedule %d: %v", jobID, id, err)
+	}
+	return nil
+}
+
+// SetJobScheduleState sets the state and the next run of a schedule.
+func SetJobScheduleState(id uint64, state job.ScheduleState, nextRun time.Time) error {
+	start := time.Now()
+	err := storage.SetJobScheduleState(id, state, nextRun)
+	metrics.StorageQuery("set_job_schedule_state", start, err)
+	if err != nil {
+		return fmt.Errorf("could not set state of job schedule %d: %v", id, err)
+	}
+	return nil
+}
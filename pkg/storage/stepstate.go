@@ -0,0 +1,42 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// StoreStepState stores the progress of the steps of a paused job, which it
+// is resumed from.
+func StoreStepState(jobID types.JobID, state *job.PauseSnapshot) error {
+	start := time.Now()
+	err := storage.StoreStepState(jobID, state)
+	metrics.StorageQuery("store_step_state", start, err)
+	if err != nil {
+		return fmt.Errorf("could not store step state of job %s: %v", jobID, err)
+	}
+	return nil
+}
+
+// GetStepState fetches the progress of the steps of a job when it was last
+// paused.
+func GetStepState(jobID types.JobID) (*job.PauseSnapshot, error) {
+	start := time.Now()
+	state, err := storage.GetStepState(jobID)
+	metrics.StorageQuery("get_step_state", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch step state of job %s: %v", jobID, err)
+	}
+	if state == nil {
+		return nil, fmt.Errorf("job %s has no step state", jobID)
+	}
+	return state, nil
+}
@@ -6,9 +6,13 @@
 package storage
 
 import (
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
 	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/target"
 	"github.com/facebookincubator/contest/pkg/types"
 )
 
@@ -22,23 +26,111 @@ type Storage interface {
 	// Test events storage interface
 	StoreTestEvent(event testevent.Event) error
 	GetTestEvents(eventQuery *testevent.Query) ([]testevent.Event, error)
+	// GetTestEventsPage returns at most limit test events matching the
+	// query, stored after the event with sequence number afterSequence, or
+	// from the first event if afterSequence is 0.
+	GetTestEventsPage(eventQuery *testevent.Query, afterSequence uint64, limit int) (*testevent.Page, error)
+	// CountTestEvents returns the number of test events of a job, by event
+	// name, counting the repeats of collapsed events. Event names the job did
+	// not emit are not in the map.
+	CountTestEvents(jobID types.JobID) (map[event.Name]uint64, error)
 
 	// Framework events storage interface
 	StoreFrameworkEvent(event frameworkevent.Event) error
 	GetFrameworkEvent(eventQuery *frameworkevent.Query) ([]frameworkevent.Event, error)
 
 	// Job request interface
+	// NextJobSequence returns the next value of the job sequence, starting at
+	// 1, which the sequential job IDs are allocated from.
+	NextJobSequence() (uint64, error)
+	// StoreJobRequest stores a job request with the job ID it was allocated.
+	// Requests without a job ID are assigned the next value of the job
+	// sequence. The job IDs must be unique.
 	StoreJobRequest(request *job.Request) (types.JobID, error)
+	// StoreJobRequests stores job requests atomically: either all of them are
+	// stored and their job IDs are returned in order, or none is.
+	StoreJobRequests(requests []*job.Request) ([]types.JobID, error)
 	GetJobRequest(jobID types.JobID) (*job.Request, error)
+	// GetJobRequests returns the job requests matching the query, ordered by
+	// request time. The query limit is never 0.
+	GetJobRequests(query job.JobQuery) ([]*job.Request, error)
+	// GetJobIDsByExternalID returns the IDs of the jobs submitted with the
+	// given external ID, in submission order.
+	GetJobIDsByExternalID(externalID string) ([]types.JobID, error)
+	// GetJobIDsBySeriesID returns the IDs of the jobs of a series, in
+	// submission order.
+	GetJobIDsBySeriesID(seriesID string) ([]types.JobID, error)
 
 	// Job report interface
 	StoreJobReport(report *job.JobReport) error
 	GetJobReport(jobID types.JobID) (*job.JobReport, error)
 
+	// Job template interface
+	// StoreJobTemplate stores a new version of a job template, and returns
+	// its version number, starting at 1.
+	StoreJobTemplate(template *job.Template) (uint, error)
+	// GetJobTemplate returns a version of a job template, or its latest
+	// version if version is 0.
+	GetJobTemplate(name string, version uint) (*job.Template, error)
+
+	// Job schedule interface
+	// StoreJobSchedule stores a new job schedule, and returns its ID,
+	// starting at 1.
+	StoreJobSchedule(schedule *job.Schedule) (uint64, error)
+	GetJobSchedule(id uint64) (*job.Schedule, error)
+	// GetJobSchedules returns all the job schedules, ordered by ID.
+	GetJobSchedules() ([]*job.Schedule, error)
+	// ClaimJobScheduleRun moves the next run of an active schedule from
+	// nextRun to followingRun. It returns false if the schedule is no longer
+	// active, or if its next run is no longer nextRun, e.g. because another
+	// server claimed it first.
+	ClaimJobScheduleRun(id uint64, nextRun, followingRun time.Time) (bool, error)
+	// RecordJobScheduleRun records a job submitted by a schedule.
+	RecordJobScheduleRun(id uint64, jobID types.JobID) error
+	// SetJobScheduleState sets the state and the next run of a schedule.
+	SetJobScheduleState(id uint64, state job.ScheduleState, nextRun time.Time) error
+
+	// Target health interface
+	// StoreTargetOutcomes records the outcomes of a run on its targets.
+	StoreTargetOutcomes(outcomes []target.Outcome) error
+	// GetTargetOutcomes returns the latest outcomes of a target, at most
+	// limit, recorded at or after since. The latest outcome comes first.
+	GetTargetOutcomes(targetID string, since time.Time, limit int) ([]target.Outcome, error)
+	// StoreTargetHealth creates or replaces the health record of a target.
+	StoreTargetHealth(health *target.Health) error
+	// GetTargetHealth returns the health record of a target, or a record
+	// with only the target ID if there is none.
+	GetTargetHealth(targetID string) (*target.Health, error)
+	// GetQuarantinedTargets returns the health records of the quarantined
+	// targets, ordered by quarantine time.
+	GetQuarantinedTargets() ([]*target.Health, error)
+
+	// Step state interface
+	// StoreStepState creates or replaces the progress of the steps of a
+	// paused job.
+	StoreStepState(jobID types.JobID, state *job.PauseSnapshot) error
+	// GetStepState returns the progress of the steps of a job when it was
+	// last paused, or nil if it never was.
+	GetStepState(jobID types.JobID) (*job.PauseSnapshot, error)
+
+	// Retention interface
+	// DeleteJobData deletes the test events, the reports and the step state
+	// of a job. Its request and its framework events are kept.
+	DeleteJobData(jobID types.JobID) error
+
 	// Reset clears the state of the storage layer
 	Reset() error
 }
 
+// StrongTestEventReader is implemented by the storage engines which may serve
+// test event reads from replicas. Its methods read from the primary instead,
+// so that the reader observes all the events stored so far, regardless of the
+// read consistency the engine is configured with.
+type StrongTestEventReader interface {
+	GetTestEventsStrong(eventQuery *testevent.Query) ([]testevent.Event, error)
+	GetTestEventsPageStrong(eventQuery *testevent.Query, afterSequence uint64, limit int) (*testevent.Page, error)
+}
+
 // SetStorage sets the desired storage engine for events. Switching to a new
 // storage engine implies garbage collecting the old one, with possible loss of
 // pending events if not flushed correctly
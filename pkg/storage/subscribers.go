@@ -0,0 +1,146 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage
+
+import (
+	"sync"
+
+	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// TestEventSubscriber is notified of every test event persisted by the
+// emitters of this package, e.g. to forward them to external systems.
+// TestEventEmitted is called synchronously by Emit, hence it must not block.
+type TestEventSubscriber interface {
+	TestEventEmitted(event testevent.Event)
+}
+
+var (
+	subscribersLock      sync.RWMutex
+	testEventSubscribers []TestEventSubscriber
+)
+
+// AddTestEventSubscriber registers a subscriber of the emitted test events.
+func AddTestEventSubscriber(s TestEventSubscriber) {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	testEventSubscribers = append(testEventSubscribers, s)
+}
+
+func notifyTestEventSubscribers(event testevent.Event) {
+	subscribersLock.RLock()
+	defer subscribersLock.RUnlock()
+	for _, s := range testEventSubscribers {
+		s.TestEventEmitted(event)
+	}
+	if event.Header != nil {
+		notifyJobSubscriptions(event.Header.JobID, JobEvent{Test: &event})
+	}
+}
+
+// jobSubscriptionQueueSize is the number of events queued for a subscription.
+// Subscriptions which fall behind by more are ended, so that they never block
+// the emitters.
+const jobSubscriptionQueueSize = 1024
+
+// JobEvent is an event of a job delivered to a JobSubscription: either a test
+// event or a framework event, e.g. a change of the state of the job.
+type JobEvent struct {
+	Test      *testevent.Event
+	Framework *frameworkevent.Event
+}
+
+// JobSubscription delivers the events of a job as they are emitted by the
+// emitters of this package in this process. Events emitted before subscribing
+// are not delivered.
+type JobSubscription struct {
+	jobID  types.JobID
+	lock   sync.Mutex
+	ch     chan JobEvent
+	closed bool
+	lagged bool
+}
+
+var (
+	jobSubscriptionsLock sync.Mutex
+	jobSubscriptions     = make(map[types.JobID]map[*JobSubscription]struct{})
+)
+
+// SubscribeJob returns a subscription to the events of a job. The caller must
+// close it when done.
+func SubscribeJob(jobID types.JobID) *JobSubscription {
+	s := &JobSubscription{jobID: jobID, ch: make(chan JobEvent, jobSubscriptionQueueSize)}
+	jobSubscriptionsLock.Lock()
+	defer jobSubscriptionsLock.Unlock()
+	if jobSubscriptions[jobID] == nil {
+		jobSubscriptions[jobID] = make(map[*JobSubscription]struct{})
+	}
+	jobSubscriptions[jobID][s] = struct{}{}
+	return s
+}
+
+// Events returns the channel of the events of the job. It is closed when the
+// subscription falls behind, or is closed.
+func (s *JobSubscription) Events() <-chan JobEvent {
+	return s.ch
+}
+
+// Lagged tells whether the subscription was ended because it did not keep up
+// with the events.
+func (s *JobSubscription) Lagged() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lagged
+}
+
+// Close unsubscribes from the events of the job.
+func (s *JobSubscription) Close() {
+	jobSubscriptionsLock.Lock()
+	delete(jobSubscriptions[s.jobID], s)
+	if len(jobSubscriptions[s.jobID]) == 0 {
+		delete(jobSubscriptions, s.jobID)
+	}
+	jobSubscriptionsLock.Unlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.close()
+}
+
+// close must be called with the subscription lock held.
+func (s *JobSubscription) close() {
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+func (s *JobSubscription) deliver(ev JobEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- ev:
+	default:
+		s.lagged = true
+		s.close()
+	}
+}
+
+func notifyJobSubscriptions(jobID types.JobID, ev JobEvent) {
+	jobSubscriptionsLock.Lock()
+	subs := make([]*JobSubscription, 0, len(jobSubscriptions[jobID]))
+	for s := range jobSubscriptions[jobID] {
+		subs = append(subs, s)
+	}
+	jobSubscriptionsLock.Unlock()
+	for _, s := range subs {
+		s.deliver(ev)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeJob(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("1")
+	sub := storage.SubscribeJob(jobID)
+	defer sub.Close()
+
+	require.NoError(t, storage.NewTestEventEmitter(testevent.Header{JobID: "2", RunID: 1}).Emit(testevent.Data{EventName: "Other"}))
+	require.NoError(t, storage.NewTestEventEmitter(testevent.Header{JobID: jobID, RunID: 1}).Emit(testevent.Data{EventName: "Mine"}))
+	require.NoError(t, storage.NewFrameworkEventEmitter().Emit(frameworkevent.Event{JobID: jobID, EventName: job.EventJobCompleted}))
+
+	ev := <-sub.Events()
+	require.NotNil(t, ev.Test)
+	require.Equal(t, "Mine", string(ev.Test.Data.EventName))
+	ev = <-sub.Events()
+	require.NotNil(t, ev.Framework)
+	require.Equal(t, job.EventJobCompleted, ev.Framework.EventName)
+
+	sub.Close()
+	_, ok := <-sub.Events()
+	require.False(t, ok)
+	require.False(t, sub.Lagged())
+}
+
+func TestSubscribeJobLagging(t *testing.T) {
+	storage.SetStorage(memory.New())
+	jobID := types.JobID("1")
+	sub := storage.SubscribeJob(jobID)
+	defer sub.Close()
+
+	// a subscription which is not read from is ended rather than blocking
+	emitter := storage.NewTestEventEmitter(testevent.Header{JobID: jobID, RunID: 1})
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, emitter.Emit(testevent.Data{EventName: "Flood"}))
+	}
+	count := 0
+	for range sub.Events() {
+		count++
+	}
+	require.True(t, sub.Lagged())
+	require.Less(t, count, 2000)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// GetFailedTargets returns the targets which failed a test step in the last
+// run of a job, in the order they first failed. A target failing several
+// steps or tests is only returned once.
+func GetFailedTargets(jobID types.JobID) ([]*target.Target, error) {
+	query, err := testevent.QueryFields{
+		testevent.QueryJobID(jobID),
+		testevent.QueryEventNames([]event.Name{target.EventTargetIn, target.EventTargetErr}),
+	}.BuildQuery()
+	if err != nil {
+		return nil, fmt.Errorf("could not build query: %v", err)
+	}
+	start := time.Now()
+	events, err := storage.GetTestEvents(query)
+	metrics.StorageQuery("get_test_events", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch events of job %s: %v", jobID, err)
+	}
+	var lastRun types.RunID
+	for _, ev := range events {
+		if ev.Header != nil && ev.Header.RunID > lastRun {
+			lastRun = ev.Header.RunID
+		}
+	}
+	var (
+		failed []*target.Target
+		seen   = make(map[target.Target]bool)
+	)
+	for _, ev := range events {
+		if ev.Header == nil || ev.Header.RunID != lastRun || ev.Data == nil || ev.Data.Target == nil {
+			continue
+		}
+		if ev.Data.EventName != target.EventTargetErr || seen[*ev.Data.Target] {
+			continue
+		}
+		seen[*ev.Data.Target] = true
+		t := *ev.Data.Target
+		failed = append(failed, &t)
+	}
+	return failed, nil
+}
@@ -0,0 +1,41 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/metrics"
+)
+
+// StoreJobTemplate validates and stores a new version of a job template, and
+// returns its version number.
+func StoreJobTemplate(template *job.Template) (uint, error) {
+	if err := template.Validate(); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	version, err := storage.StoreJobTemplate(template)
+	metrics.StorageQuery("store_job_template", start, err)
+	if err != nil {
+		return 0, fmt.Errorf("could not store job template: %v", err)
+	}
+	return version, nil
+}
+
+// GetJobTemplate fetches a version of a job template, or its latest version
+// if version is 0.
+func GetJobTemplate(name string, version uint) (*job.Template, error) {
+	start := time.Now()
+	template, err := storage.GetJobTemplate(name, version)
+	metrics.StorageQuery("get_job_template", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch job template: %v", err)
+	}
+	return template, nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package target
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// healthTracker defines the health tracker used by ConTest, if any.
+var healthTracker HealthTracker
+
+// Outcome is the result of a run of a job on a target.
+type Outcome struct {
+	TargetID string
+	JobID    types.JobID
+	RunID    types.RunID
+	Success  bool
+	Time     time.Time
+}
+
+// Health is the health record of a target.
+type Health struct {
+	TargetID string
+	// Quarantined targets are skipped by the target managers which consult
+	// the health tracker.
+	Quarantined bool
+	// QuarantineTime and FailureRate are when the target was last
+	// quarantined, and the rolling failure rate which got it quarantined.
+	QuarantineTime time.Time
+	FailureRate    float64
+	// ReleaseTime is when the target was last released from quarantine. The
+	// outcomes recorded before do not count toward its failure rate.
+	ReleaseTime time.Time
+}
+
+// HealthTracker records the outcomes of the targets across jobs, and
+// quarantines the targets which fail too often.
+type HealthTracker interface {
+	// RecordOutcomes records the outcomes of a run, and quarantines the
+	// targets whose failure rate got too high.
+	RecordOutcomes(outcomes []Outcome) error
+	// Quarantined returns the IDs of the quarantined targets among the given
+	// ones.
+	Quarantined(targets []*Target) (map[string]bool, error)
+}
+
+// SetHealthTracker sets the health tracker consulted by the target managers.
+// Without one, no target is ever quarantined.
+func SetHealthTracker(tracker HealthTracker) {
+	healthTracker = tracker
+}
+
+// GetHealthTracker returns the health tracker, or nil if there is none.
+func GetHealthTracker() HealthTracker {
+	return healthTracker
+}
+
+// FilterQuarantined returns the targets which are not quarantined, in order.
+// Target managers call it in Acquire before selecting the targets of a job.
+func FilterQuarantined(targets []*Target) ([]*Target, error) {
+	if healthTracker == nil {
+		return targets, nil
+	}
+	quarantined, err := healthTracker.Quarantined(targets)
+	if err != nil {
+		return nil, fmt.Errorf("could not check the health of the targets: %v", err)
+	}
+	if len(quarantined) == 0 {
+		return targets, nil
+	}
+	healthy := make([]*Target, 0, len(targets))
+	for _, t := range targets {
+		if !quarantined[t.ID] {
+			healthy = append(healthy, t)
+		}
+	}
+	return healthy, nil
+}
@@ -18,6 +18,10 @@ var locker Locker
 // a Locker.
 type LockerFactory func(time.Duration) Locker
 
+// LockerLoader is a type representing a function which returns all the
+// needed things to be able to load a Locker.
+type LockerLoader func() (string, LockerFactory)
+
 // Locker defines an interface to lock and unlock targets. It is passed
 // to TargetManager's Acquire and Release methods, and the target manager
 // implementation is required to lock all the returned targets.
@@ -52,3 +56,46 @@ func SetLocker(targetLocker Locker) {
 func GetLocker() Locker {
 	return locker
 }
+
+// namespacedLocker locks the namespaced version of the targets passed to it.
+type namespacedLocker struct {
+	locker    Locker
+	namespace string
+}
+
+// NewNamespacedLocker returns a Locker that namespaces the targets before
+// passing them to the given locker. It is passed to the Acquire method of
+// target managers whose targets are namespaced after acquisition, so that the
+// locks match the namespaced targets.
+func NewNamespacedLocker(locker Locker, namespace string) Locker {
+	return &namespacedLocker{locker: locker, namespace: namespace}
+}
+
+func (l *namespacedLocker) namespaced(targets []*Target) []*Target {
+	nt := make([]*Target, 0, len(targets))
+	for _, t := range targets {
+		nt = append(nt, t.Namespaced(l.namespace))
+	}
+	return nt
+}
+
+// Lock locks the namespaced targets.
+func (l *namespacedLocker) Lock(jobID types.JobID, targets []*Target) error {
+	return l.locker.Lock(jobID, l.namespaced(targets))
+}
+
+// Unlock unlocks the namespaced targets.
+func (l *namespacedLocker) Unlock(jobID types.JobID, targets []*Target) error {
+	return l.locker.Unlock(jobID, l.namespaced(targets))
+}
+
+// RefreshLocks refreshes the locks of the namespaced targets.
+func (l *namespacedLocker) RefreshLocks(jobID types.JobID, targets []*Target) error {
+	return l.locker.RefreshLocks(jobID, l.namespaced(targets))
+}
+
+// CheckLocks checks the locks of the namespaced targets. The returned targets
+// are namespaced.
+func (l *namespacedLocker) CheckLocks(jobID types.JobID, targets []*Target) (bool, []*Target, []*Target) {
+	return l.locker.CheckLocks(jobID, l.namespaced(targets))
+}
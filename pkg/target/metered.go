@@ -0,0 +1,73 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package target
+
+import (
+	"sync"
+
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// meteredLocker counts the targets locked through it.
+type meteredLocker struct {
+	Locker
+	mu     sync.Mutex
+	locked map[types.JobID]map[string]bool
+}
+
+// NewMeteredLocker returns a Locker which keeps the metrics.TargetsLocked
+// gauge up to date with the targets locked and unlocked through the given
+// locker. Locks which expire because they were not refreshed are still
+// counted, as the locker does not tell about them.
+func NewMeteredLocker(locker Locker) Locker {
+	return &meteredLocker{Locker: locker, locked: make(map[types.JobID]map[string]bool)}
+}
+
+// Lock locks the targets, and counts those the job did not lock yet.
+func (l *meteredLocker) Lock(jobID types.JobID, targets []*Target) error {
+	if err := l.Locker.Lock(jobID, targets); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ids := l.locked[jobID]
+	if ids == nil {
+		ids = make(map[string]bool)
+		l.locked[jobID] = ids
+	}
+	added := 0
+	for _, t := range targets {
+		if !ids[t.ID] {
+			ids[t.ID] = true
+			added++
+		}
+	}
+	metrics.TargetLocks(added)
+	return nil
+}
+
+// Unlock unlocks the targets, and stops counting them.
+func (l *meteredLocker) Unlock(jobID types.JobID, targets []*Target) error {
+	if err := l.Locker.Unlock(jobID, targets); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ids := l.locked[jobID]
+	removed := 0
+	for _, t := range targets {
+		if ids[t.ID] {
+			delete(ids, t.ID)
+			removed++
+		}
+	}
+	if len(ids) == 0 {
+		delete(l.locked, jobID)
+	}
+	metrics.TargetLocks(-removed)
+	return nil
+}
@@ -29,6 +29,16 @@ var EventTargetAcquired = event.Name("TargetAcquired")
 // ErrPayload represents the payload associated with a TargetErr event
 type ErrPayload struct {
 	Error string
+	// Informational is set if the failure does not count toward the outcome
+	// of the job.
+	Informational bool `json:",omitempty"`
+}
+
+// OutPayload represents the payload associated with a TargetOut event: the
+// verdict of the step, pass or skip, and its reason.
+type OutPayload struct {
+	Verdict string
+	Reason  string `json:",omitempty"`
 }
 
 // Target represents a target to run tests on
@@ -36,8 +46,25 @@ type Target struct {
 	Name string
 	ID   string
 	FQDN string
+	// Informational targets, e.g. canaries, are tested and reported like any
+	// other target, but their failures do not count toward the outcome of the
+	// job.
+	Informational bool `json:",omitempty"`
 }
 
 func (t *Target) String() string {
 	return fmt.Sprintf("Target{Name: \"%s\", ID: \"%s\", FQDN: \"%s\"}", t.Name, t.ID, t.FQDN)
 }
+
+// NamespaceSeparator separates the namespace from the ID of a namespaced
+// target.
+const NamespaceSeparator = "/"
+
+// Namespaced returns a copy of the target, with its ID scoped by the given
+// namespace. Target managers use it to keep the IDs assigned by different
+// sources unique within a job.
+func (t *Target) Namespaced(namespace string) *Target {
+	nt := *t
+	nt.ID = namespace + NamespaceSeparator + t.ID
+	return &nt
+}
@@ -24,6 +24,18 @@ type TargetManager interface {
 	Release(jobID types.JobID, cancel <-chan struct{}, parameters interface{}) error
 }
 
+// StreamingTargetManager is implemented by the TargetManagers which acquire
+// targets continuously, rather than all at once. The TestRunner feeds the
+// targets into the test as they are acquired.
+type StreamingTargetManager interface {
+	TargetManager
+	// AcquireStream sends the acquired and locked targets on the `targets`
+	// channel as they become available, and returns once no more targets will
+	// be acquired. Sending on `targets` must not block past cancellation. The
+	// channel is closed by the caller when AcquireStream returns.
+	AcquireStream(jobID types.JobID, cancel <-chan struct{}, parameters interface{}, tl Locker, targets chan<- *Target) error
+}
+
 // TargetManagerBundle bundles the selected TargetManager together with its
 // acquire and release parameters based on the content of the job descriptor
 type TargetManagerBundle struct {
@@ -0,0 +1,168 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/insomniacslk/xjson"
+)
+
+// BatchPolicy tells how the targets are grouped before being handed to a
+// BatchTestStep. A batch is run once it holds Size targets, once Window has
+// elapsed since its first target arrived, or once the step has received all
+// of its targets, whichever comes first.
+type BatchPolicy struct {
+	// Size is the maximum number of targets of a batch. If zero, batches are
+	// only bounded by the window.
+	Size uint `json:"size,omitempty"`
+	// Window is the longest time a batch waits for more targets. If zero,
+	// batches wait until they are full.
+	Window xjson.Duration `json:"window,omitempty"`
+}
+
+// Validate checks that a batch policy is within bounds.
+func (p *BatchPolicy) Validate() error {
+	if p.Window < 0 {
+		return fmt.Errorf("batch window cannot be negative, got %s", time.Duration(p.Window))
+	}
+	return nil
+}
+
+// BatchTestStep is implemented by the steps which act on groups of targets at
+// once, e.g. power cycling a rack. They are still run as regular steps when
+// the descriptor does not ask for batches.
+type BatchTestStep interface {
+	TestStep
+	// RunBatch runs the step on a batch of targets. It returns the errors of
+	// the targets it failed, while the other targets of the batch pass. If it
+	// returns an error, all the targets of the batch fail with it. Batches may
+	// run concurrently.
+	RunBatch(ctx context.Context, targets []*target.Target, params TestStepParameters, ev testevent.Emitter) (map[*target.Target]error, error)
+}
+
+// batchStep reads the targets of the step it wraps from the input channel,
+// groups them according to the batch policy, and fans the outcome of every
+// batch out on the output and error channels.
+type batchStep struct {
+	inner  BatchTestStep
+	size   int
+	window time.Duration
+}
+
+// WithBatching returns a test step which groups targets in batches as the
+// policy says, and runs step on every batch.
+func WithBatching(step BatchTestStep, policy BatchPolicy) TestStep {
+	return &batchStep{inner: step, size: int(policy.Size), window: time.Duration(policy.Window)}
+}
+
+// Name returns the name of the wrapped step.
+func (s *batchStep) Name() string {
+	return s.inner.Name()
+}
+
+// ValidateParameters validates the parameters of the wrapped step.
+func (s *batchStep) ValidateParameters(params TestStepParameters) error {
+	return s.inner.ValidateParameters(params)
+}
+
+// CanResume returns false: there is no way to resume a batch halfway.
+func (s *batchStep) CanResume() bool {
+	return false
+}
+
+// Resume is not supported by batched steps.
+func (s *batchStep) Resume(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: s.Name()}
+}
+
+// Run collects the targets in batches and runs the wrapped step on them.
+func (s *batchStep) Run(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.Emitter) error {
+	var (
+		wg      sync.WaitGroup
+		batch   []*target.Target
+		timer   *time.Timer
+		timeout <-chan time.Time
+	)
+	stopTimer := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+	}
+	flush := func() {
+		stopTimer()
+		if len(batch) == 0 {
+			return
+		}
+		wg.Add(1)
+		go s.runBatch(ctx, pause, ch, params, ev, batch, &wg)
+		batch = nil
+	}
+	defer wg.Wait()
+	for in := ch.In; in != nil; {
+		select {
+		case tgt, ok := <-in:
+			if !ok {
+				in = nil
+				flush()
+				break
+			}
+			batch = append(batch, tgt)
+			if s.size > 0 && len(batch) >= s.size {
+				flush()
+			} else if len(batch) == 1 && s.window > 0 {
+				timer = time.NewTimer(s.window)
+				timeout = timer.C
+			}
+		case <-timeout:
+			flush()
+		case <-ctx.Done():
+			stopTimer()
+			return nil
+		case <-pause:
+			stopTimer()
+			return nil
+		}
+	}
+	return nil
+}
+
+// runBatch runs the wrapped step on a batch, then forwards the targets of the
+// batch according to their outcome.
+func (s *batchStep) runBatch(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.Emitter, batch []*target.Target, wg *sync.WaitGroup) {
+	defer wg.Done()
+	log.Debugf("%s: running a batch of %d targets", s.Name(), len(batch))
+	failures, err := s.inner.RunBatch(ctx, batch, params, ev)
+	for _, tgt := range batch {
+		targetErr := failures[tgt]
+		if err != nil {
+			targetErr = err
+		}
+		if targetErr != nil {
+			select {
+			case ch.Err <- cerrors.TargetError{Target: tgt, Err: targetErr}:
+				continue
+			case <-ctx.Done():
+			case <-pause:
+			}
+			return
+		}
+		select {
+		case ch.Out <- tgt:
+			continue
+		case <-ctx.Done():
+		case <-pause:
+		}
+		return
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/insomniacslk/xjson"
+	"github.com/stretchr/testify/require"
+)
+
+// sizeStep sends the size of every batch it runs on batches.
+type sizeStep struct {
+	batches chan int
+}
+
+func (s *sizeStep) Name() string { return "Size" }
+
+func (s *sizeStep) Run(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.Emitter) error {
+	return nil
+}
+
+func (s *sizeStep) RunBatch(ctx context.Context, targets []*target.Target, params TestStepParameters, ev testevent.Emitter) (map[*target.Target]error, error) {
+	s.batches <- len(targets)
+	return nil, nil
+}
+
+func (s *sizeStep) CanResume() bool { return true }
+
+func (s *sizeStep) Resume(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.EmitterFetcher) error {
+	return nil
+}
+
+func (s *sizeStep) ValidateParameters(params TestStepParameters) error { return nil }
+
+func TestBatchWindow(t *testing.T) {
+	inner := &sizeStep{batches: make(chan int, 10)}
+	step := WithBatching(inner, BatchPolicy{Size: 3, Window: xjson.Duration(20 * time.Millisecond)})
+	require.False(t, step.CanResume())
+
+	in := make(chan *target.Target)
+	out := make(chan *target.Target, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- step.Run(context.Background(), nil, TestStepChannels{In: in, Out: out, Err: make(chan cerrors.TargetError)}, nil, nil)
+	}()
+
+	// the window flushes a batch which is not full
+	in <- &target.Target{ID: "1"}
+	require.Equal(t, 1, <-inner.batches)
+	for _, id := range []string{"2", "3", "4", "5"} {
+		in <- &target.Target{ID: id}
+	}
+	require.Equal(t, 3, <-inner.batches)
+	// the last batch is flushed when the input is closed
+	close(in)
+	require.Equal(t, 1, <-inner.batches)
+	require.NoError(t, <-done)
+	require.Len(t, out, 5)
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"context"
+)
+
+// CancelChannel returns the channel which is closed when a test step is
+// cancelled, i.e. when the context passed to Run or Resume is done. Pausing is
+// not a cancellation, hence never closes it.
+func CancelChannel(ctx context.Context) <-chan struct{} {
+	return ctx.Done()
+}
+
+// NewStepContext returns the context passed to the test steps, which is
+// cancelled when cancel is closed, or when the returned CancelFunc is called.
+func NewStepContext(parent context.Context, cancel <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, ctxCancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-cancel:
+		case <-ctx.Done():
+		}
+		ctxCancel()
+	}()
+	return ctx, ctxCancel
+}
@@ -0,0 +1,375 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Behaviours of a test step for the targets it fails, besides jumping to the
+// step with the given label.
+const (
+	// FailureAbort makes the failed targets leave the test. It is the default.
+	FailureAbort = "abort"
+	// FailureContinue forwards the failed targets to the next step. They are
+	// still reported as failed once they complete the test.
+	FailureContinue = "continue"
+)
+
+// StepOutcome is what a target got from a test step it went through, as
+// reported in the payload of its TargetOut or TargetErr event.
+type StepOutcome struct {
+	Verdict Verdict
+	Reason  string
+	Error   string
+	// Output holds the top-level fields of the payload of the last event the
+	// step emitted for the target. Strings are unquoted, other values are in
+	// JSON.
+	Output map[string]string
+}
+
+// field returns the value of a field of the outcome in a condition, where key
+// is the name of the output field.
+func (o StepOutcome) field(name, key string) string {
+	switch name {
+	case "verdict":
+		return string(o.Verdict)
+	case "reason":
+		return o.Reason
+	case "output":
+		return o.Output[key]
+	default:
+		return o.Error
+	}
+}
+
+// OutputFields decodes the fields of an event payload into the Output of a
+// StepOutcome. It returns nil if the payload is not a JSON object.
+func OutputFields(payload []byte) map[string]string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil || fields == nil {
+		return nil
+	}
+	output := make(map[string]string, len(fields))
+	for k, v := range fields {
+		var str string
+		if err := json.Unmarshal(v, &str); err == nil {
+			output[k] = str
+		} else {
+			output[k] = string(v)
+		}
+	}
+	return output
+}
+
+// Condition is a boolean expression on the outcomes of the steps a target
+// went through, which tells whether the target enters a step. Comparisons
+// have the form `<label>.<field> == "<value>"` or `!=`, where the field is
+// one of verdict, reason and error, or `output.<name>` for a field of the
+// output of the step, and are combined with `!`, `&&`, `||` and parentheses.
+// The fields of a step which the target did not go through are empty, e.g.
+// `boot.verdict == "fail" || boot.verdict == ""`.
+type Condition struct {
+	expr string
+	root conditionNode
+}
+
+// ParseCondition parses a condition expression.
+func ParseCondition(expr string) (*Condition, error) {
+	var p conditionParser
+	if err := p.tokenize(expr); err != nil {
+		return nil, fmt.Errorf("invalid condition %q: %v", expr, err)
+	}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid condition %q: %v", expr, err)
+	}
+	return &Condition{expr: expr, root: root}, nil
+}
+
+// String returns the expression of the condition.
+func (c *Condition) String() string {
+	return c.expr
+}
+
+// Labels returns the labels of the steps the condition refers to.
+func (c *Condition) Labels() []string {
+	var labels []string
+	c.root.labels(&labels)
+	return labels
+}
+
+// Eval evaluates the condition against the outcomes of the steps a target
+// went through, indexed by step label.
+func (c *Condition) Eval(outcomes map[string]StepOutcome) bool {
+	return c.root.eval(outcomes)
+}
+
+type conditionNode interface {
+	eval(outcomes map[string]StepOutcome) bool
+	labels(labels *[]string)
+}
+
+type notNode struct{ x conditionNode }
+
+func (n notNode) eval(o map[string]StepOutcome) bool { return !n.x.eval(o) }
+func (n notNode) labels(l *[]string)                 { n.x.labels(l) }
+
+type binaryNode struct {
+	and  bool
+	x, y conditionNode
+}
+
+func (n binaryNode) eval(o map[string]StepOutcome) bool {
+	if n.and {
+		return n.x.eval(o) && n.y.eval(o)
+	}
+	return n.x.eval(o) || n.y.eval(o)
+}
+
+func (n binaryNode) labels(l *[]string) {
+	n.x.labels(l)
+	n.y.labels(l)
+}
+
+type compareNode struct {
+	label, field, key, value string
+	equal                    bool
+}
+
+func (n compareNode) eval(o map[string]StepOutcome) bool {
+	return (o[n.label].field(n.field, n.key) == n.value) == n.equal
+}
+
+func (n compareNode) labels(l *[]string) { *l = append(*l, n.label) }
+
+// conditionParser is a recursive descent parser of conditions.
+type conditionParser struct {
+	tokens []string
+	pos    int
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
+
+func (p *conditionParser) tokenize(expr string) error {
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == '.':
+			p.tokens = append(p.tokens, expr[i:i+1])
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"),
+			strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "!="):
+			p.tokens = append(p.tokens, expr[i:i+2])
+			i += 2
+		case c == '!':
+			p.tokens = append(p.tokens, "!")
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(expr) && expr[j] != '"'; j++ {
+				if expr[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(expr) {
+				return fmt.Errorf("unterminated string at offset %d", i)
+			}
+			p.tokens = append(p.tokens, expr[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(expr) && isIdentRune(rune(expr[j])) {
+				j++
+			}
+			if j == i {
+				return fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			p.tokens = append(p.tokens, expr[i:j])
+			i = j
+		}
+	}
+	if len(p.tokens) == 0 {
+		return fmt.Errorf("empty expression")
+	}
+	return nil
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	x, err := p.parseAnd()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var y conditionNode
+		if y, err = p.parseAnd(); err == nil {
+			x = binaryNode{x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	x, err := p.parseUnary()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var y conditionNode
+		if y, err = p.parseUnary(); err == nil {
+			x = binaryNode{and: true, x: x, y: y}
+		}
+	}
+	return x, err
+}
+
+func (p *conditionParser) parseUnary() (conditionNode, error) {
+	switch p.peek() {
+	case "!":
+		p.pos++
+		x, err := p.parseUnary()
+		return notNode{x: x}, err
+	case "(":
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, err := p.next(); err != nil || tok != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return x, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (conditionNode, error) {
+	var toks [3]string
+	for i := range toks {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		toks[i] = tok
+	}
+	label, dot, field := toks[0], toks[1], toks[2]
+	if !isIdentRune(rune(label[0])) || dot != "." {
+		return nil, fmt.Errorf("expected <label>.<field>, got %q", label+dot+field)
+	}
+	var key string
+	switch field {
+	case "verdict", "reason", "error":
+	case "output":
+		if tok, err := p.next(); err != nil || tok != "." {
+			return nil, fmt.Errorf("expected %s.output.<name>", label)
+		}
+		tok, err := p.next()
+		if err != nil || !isIdentRune(rune(tok[0])) {
+			return nil, fmt.Errorf("expected %s.output.<name>", label)
+		}
+		key = tok
+	default:
+		return nil, fmt.Errorf("unknown field %q, must be verdict, reason, error or output", field)
+	}
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if op != "==" && op != "!=" {
+		return nil, fmt.Errorf("expected == or !=, got %q", op)
+	}
+	if value[0] != '"' {
+		return nil, fmt.Errorf("expected a quoted string, got %q", value)
+	}
+	unquoted, err := strconv.Unquote(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid string %s: %v", value, err)
+	}
+	return compareNode{label: label, field: field, key: key, value: unquoted, equal: op == "=="}, nil
+}
+
+// ValidateFlow checks the control flow of the steps of a test: the steps
+// failed targets jump to must follow the step they fail, and conditions can
+// only refer to the steps which precede them. If the order independent steps
+// are shuffled, this must hold for every order they can be shuffled to.
+func ValidateFlow(bundles []TestStepBundle, shuffle bool) error {
+	index := make(map[string]int, len(bundles))
+	for i, b := range bundles {
+		index[b.TestStepLabel] = i
+	}
+	// first and last are the positions of the order independent steps
+	first, last := -1, -1
+	for i, b := range bundles {
+		if shuffle && b.OrderIndependent {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	// positions returns the first and last positions a step can run at
+	positions := func(i int) (int, int) {
+		if shuffle && bundles[i].OrderIndependent {
+			return first, last
+		}
+		return i, i
+	}
+	for i, b := range bundles {
+		fromFirst, fromLast := positions(i)
+		switch b.OnFailure {
+		case "", FailureAbort, FailureContinue:
+		default:
+			j, ok := index[b.OnFailure]
+			if !ok {
+				return fmt.Errorf("step %s jumps to unknown step %s on failure", b.TestStepLabel, b.OnFailure)
+			}
+			if j <= i {
+				return fmt.Errorf("step %s can only jump to a later step on failure, but %s precedes it", b.TestStepLabel, b.OnFailure)
+			}
+			if toFirst, _ := positions(j); toFirst <= fromLast {
+				return fmt.Errorf("step %s jumps to %s on failure, which shuffling the order independent steps can move before it", b.TestStepLabel, b.OnFailure)
+			}
+		}
+		if b.Condition == nil {
+			continue
+		}
+		for _, label := range b.Condition.Labels() {
+			j, ok := index[label]
+			if !ok || j >= i {
+				return fmt.Errorf("condition of step %s refers to %s, which is not a previous step", b.TestStepLabel, label)
+			}
+			if _, toLast := positions(j); toLast >= fromFirst {
+				return fmt.Errorf("condition of step %s refers to %s, which shuffling the order independent steps can move after it", b.TestStepLabel, label)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCondition(t *testing.T) {
+	outcomes := map[string]StepOutcome{
+		"flash": {Verdict: VerdictPass},
+		"boot":  {Verdict: VerdictFail, Error: "no \"login\" prompt"},
+		"probe": {Verdict: VerdictPass, Output: OutputFields([]byte(`{"Stdout": "ok", "ExitStatus": 3, "Nested": {"a": 1}}`))},
+	}
+	for expr, want := range map[string]bool{
+		`boot.verdict == "fail"`:                                                  true,
+		`boot.verdict != "fail"`:                                                  false,
+		`!(boot.verdict == "fail")`:                                               false,
+		`flash.verdict == "pass" && boot.verdict == "pass"`:                       false,
+		`flash.verdict == "pass" || boot.verdict == "pass"`:                       true,
+		`boot.error == "no \"login\" prompt"`:                                     true,
+		`run-1.verdict == "" && !(flash.reason != "")`:                            true,
+		`boot.verdict == "pass" || flash.verdict == "pass" && boot.reason == "x"`: false,
+		`probe.output.Stdout == "ok"`:                                             true,
+		`probe.output.ExitStatus == "3"`:                                          true,
+		`probe.output.Nested == "{\"a\": 1}"`:                                     true,
+		`probe.output.Missing == "" && boot.output.Stdout == ""`:                  true,
+	} {
+		c, err := ParseCondition(expr)
+		require.NoError(t, err, expr)
+		require.Equal(t, want, c.Eval(outcomes), expr)
+	}
+	for _, expr := range []string{
+		``,
+		`boot`,
+		`boot.verdict`,
+		`boot.status == "fail"`,
+		`boot.verdict = "fail"`,
+		`boot.verdict == fail`,
+		`(boot.verdict == "fail"`,
+		`boot.verdict == "fail" &&`,
+		`boot.verdict == "fail`,
+		`boot.verdict == "fail" boot.verdict == "pass"`,
+		`boot.output == "ok"`,
+		`boot.output. == "ok"`,
+		`boot.verdict.x == "ok"`,
+	} {
+		_, err := ParseCondition(expr)
+		require.Error(t, err, expr)
+	}
+}
+
+func TestValidateFlow(t *testing.T) {
+	cond, err := ParseCondition(`boot.verdict == "fail"`)
+	require.NoError(t, err)
+	bundles := []TestStepBundle{
+		{TestStepLabel: "flash"},
+		{TestStepLabel: "boot", OnFailure: "diagnose"},
+		{TestStepLabel: "diagnose", Condition: cond},
+	}
+	require.NoError(t, ValidateFlow(bundles, false))
+
+	bundles[1].OnFailure = "flash"
+	require.Error(t, ValidateFlow(bundles, false))
+	bundles[1].OnFailure = "missing"
+	require.Error(t, ValidateFlow(bundles, false))
+	bundles[1].OnFailure = FailureContinue
+	require.NoError(t, ValidateFlow(bundles, false))
+	bundles[0].Condition = cond
+	require.Error(t, ValidateFlow(bundles, false))
+}
+
+func TestValidateFlowWithShuffle(t *testing.T) {
+	cond, err := ParseCondition(`flash.verdict == "pass"`)
+	require.NoError(t, err)
+	bundles := []TestStepBundle{
+		{TestStepLabel: "flash", OnFailure: "diagnose"},
+		{TestStepLabel: "a", OrderIndependent: true},
+		{TestStepLabel: "b", OrderIndependent: true},
+		{TestStepLabel: "diagnose", Condition: cond},
+	}
+	// the references do not cross the shuffled steps
+	require.NoError(t, ValidateFlow(bundles, true))
+
+	bundles[1].OnFailure = "b"
+	require.NoError(t, ValidateFlow(bundles, false))
+	require.Error(t, ValidateFlow(bundles, true))
+	bundles[1].OnFailure = "diagnose"
+	require.NoError(t, ValidateFlow(bundles, true))
+
+	cond, err = ParseCondition(`a.verdict == "pass"`)
+	require.NoError(t, err)
+	bundles[2].Condition = cond
+	require.NoError(t, ValidateFlow(bundles, false))
+	require.Error(t, ValidateFlow(bundles, true))
+	bundles[2].Condition = nil
+	bundles[3].Condition = cond
+	require.NoError(t, ValidateFlow(bundles, true))
+
+	// steps which run after the shuffled ones cannot jump into them
+	bundles[1].OnFailure = ""
+	bundles[3].Condition = nil
+	bundles = append(bundles, TestStepBundle{TestStepLabel: "c", OrderIndependent: true})
+	bundles[3].OnFailure = "c"
+	require.NoError(t, ValidateFlow(bundles, false))
+	require.Error(t, ValidateFlow(bundles, true))
+}
@@ -0,0 +1,43 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"context"
+)
+
+// Metrics is the handle used by the test steps to expose their own metrics.
+// The names are prefixed with contest_step_, and the job and step labels
+// are added. Errors report invalid names or label sets, or a metric which has
+// too many of them; steps usually log them rather than failing.
+type Metrics interface {
+	// Inc increments a counter.
+	Inc(name string, labels map[string]string) error
+	// Observe adds a value to a summary.
+	Observe(name string, value float64, labels map[string]string) error
+}
+
+type metricsKey struct{}
+
+// WithMetrics returns a context carrying the Metrics handle of a test step.
+func WithMetrics(ctx context.Context, m Metrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, m)
+}
+
+// MetricsFromContext returns the Metrics handle passed to a test step. When
+// there is none, e.g. in dry runs, the metrics are discarded.
+func MetricsFromContext(ctx context.Context) Metrics {
+	if m, ok := ctx.Value(metricsKey{}).(Metrics); ok {
+		return m
+	}
+	return noMetrics{}
+}
+
+type noMetrics struct{}
+
+func (noMetrics) Inc(string, map[string]string) error { return nil }
+
+func (noMetrics) Observe(string, float64, map[string]string) error { return nil }
@@ -0,0 +1,110 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"errors"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/target"
+)
+
+// Verdict is the outcome of a test step for a target.
+type Verdict string
+
+// list of the verdicts of a test step.
+const (
+	// VerdictPass means that the target passed the step, and continues to the
+	// next one.
+	VerdictPass Verdict = "pass"
+	// VerdictFail means that the target failed the step, and leaves the test.
+	VerdictFail Verdict = "fail"
+	// VerdictSkip means that the step did not apply to the target, which
+	// continues to the next step.
+	VerdictSkip Verdict = "skip"
+)
+
+// Valid tells whether a verdict is one of the known verdicts.
+func (v Verdict) Valid() bool {
+	switch v {
+	case VerdictPass, VerdictFail, VerdictSkip:
+		return true
+	}
+	return false
+}
+
+// TargetResult is the verdict of a test step for a target, with a human
+// readable reason. It is read by the TestRunner and recorded in the TargetOut
+// and TargetErr events, so that reporters get consistent verdicts.
+type TargetResult struct {
+	Target  *target.Target
+	Verdict Verdict
+	Reason  string
+}
+
+// StepOutput is used by test steps to report the result of a target, and to
+// forward it accordingly: passed and skipped targets are sent to the Out
+// channel, and failed ones to the Err channel.
+type StepOutput struct {
+	cancel, pause <-chan struct{}
+	ch            TestStepChannels
+}
+
+// NewStepOutput returns a StepOutput for the channels of a test step. Reports
+// are aborted when the cancel or pause signals are received.
+func NewStepOutput(cancel, pause <-chan struct{}, ch TestStepChannels) *StepOutput {
+	return &StepOutput{cancel: cancel, pause: pause, ch: ch}
+}
+
+// Report sends the result of a target to the TestRunner and forwards the
+// target. It returns false if it was interrupted by a cancellation or pause
+// signal.
+func (o *StepOutput) Report(r TargetResult) bool {
+	if o.ch.Result != nil {
+		select {
+		case o.ch.Result <- r:
+		case <-o.cancel:
+			return false
+		case <-o.pause:
+			return false
+		}
+	}
+	if r.Verdict == VerdictFail {
+		reason := r.Reason
+		if reason == "" {
+			reason = "failed without reason"
+		}
+		select {
+		case o.ch.Err <- cerrors.TargetError{Target: r.Target, Err: errors.New(reason)}:
+			return true
+		case <-o.cancel:
+		case <-o.pause:
+		}
+		return false
+	}
+	select {
+	case o.ch.Out <- r.Target:
+		return true
+	case <-o.cancel:
+	case <-o.pause:
+	}
+	return false
+}
+
+// Pass reports that a target passed the step.
+func (o *StepOutput) Pass(t *target.Target, reason string) bool {
+	return o.Report(TargetResult{Target: t, Verdict: VerdictPass, Reason: reason})
+}
+
+// Fail reports that a target failed the step.
+func (o *StepOutput) Fail(t *target.Target, reason string) bool {
+	return o.Report(TargetResult{Target: t, Verdict: VerdictFail, Reason: reason})
+}
+
+// Skip reports that the step did not apply to a target.
+func (o *StepOutput) Skip(t *target.Target, reason string) bool {
+	return o.Report(TargetResult{Target: t, Verdict: VerdictSkip, Reason: reason})
+}
@@ -0,0 +1,265 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/config"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/insomniacslk/xjson"
+)
+
+var log = logging.GetLogger("pkg/test")
+
+// EventRetryAttempt is emitted when a target failed an attempt of a step with
+// a retry policy, and is going to be injected into the step again.
+const EventRetryAttempt = event.Name("RetryAttempt")
+
+// MaxRetryCount is the maximum number of retries of a retry policy.
+const MaxRetryCount = 100
+
+// RetryPolicy tells how many times the targets failed by a test step are
+// injected into the step again before being reported as failed.
+type RetryPolicy struct {
+	// Count is the number of retries, i.e. a target is tried Count+1 times.
+	Count uint `json:"count"`
+	// Backoff is the time waited before retrying the failed targets.
+	Backoff xjson.Duration `json:"backoff,omitempty"`
+}
+
+// Validate checks that a retry policy is within bounds.
+func (p *RetryPolicy) Validate() error {
+	if p.Count > MaxRetryCount {
+		return fmt.Errorf("retry count %d is larger than %d", p.Count, MaxRetryCount)
+	}
+	if p.Backoff < 0 {
+		return fmt.Errorf("retry backoff cannot be negative, got %s", time.Duration(p.Backoff))
+	}
+	return nil
+}
+
+// RetryAttemptPayload is the payload of EventRetryAttempt.
+type RetryAttemptPayload struct {
+	// Attempt is the number of the failed attempt, starting from 1.
+	Attempt     int
+	MaxAttempts int
+	Error       string
+}
+
+// retryStep is a test step which retries the targets failed by the step it
+// wraps. Every attempt is a new run of the wrapped step, fed with the targets
+// which failed the previous attempt only, so that the targets which succeeded
+// are forwarded right away and never processed again. Runs of the wrapped
+// step never overlap, hence steps which keep state in their structure while
+// running can be wrapped too.
+type retryStep struct {
+	inner       TestStep
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// WithRetry returns a test step which runs step, and runs it again on the
+// targets it failed as the policy says.
+func WithRetry(step TestStep, policy RetryPolicy) TestStep {
+	return &retryStep{inner: step, maxAttempts: int(policy.Count) + 1, backoff: time.Duration(policy.Backoff)}
+}
+
+// Name returns the name of the wrapped step.
+func (s *retryStep) Name() string {
+	return s.inner.Name()
+}
+
+// ValidateParameters validates the parameters of the wrapped step.
+func (s *retryStep) ValidateParameters(params TestStepParameters) error {
+	return s.inner.ValidateParameters(params)
+}
+
+// CanResume tells whether the wrapped step is able to resume.
+func (s *retryStep) CanResume() bool {
+	return s.inner.CanResume()
+}
+
+// Run runs the wrapped step, retrying the targets it fails.
+func (s *retryStep) Run(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.Emitter) error {
+	first := func(inner TestStepChannels) error {
+		return s.inner.Run(ctx, pause, inner, params, ev)
+	}
+	return s.run(ctx, pause, ch, params, ev, first)
+}
+
+// Resume resumes the wrapped step. The targets it fails are then retried with
+// new runs of the wrapped step.
+func (s *retryStep) Resume(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.EmitterFetcher) error {
+	first := func(inner TestStepChannels) error {
+		return s.inner.Resume(ctx, pause, inner, params, ev)
+	}
+	return s.run(ctx, pause, ch, params, ev, first)
+}
+
+func (s *retryStep) run(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.Emitter, first func(TestStepChannels) error) error {
+	in := ch.In
+	attemptFunc := first
+	for attempt := 1; ; attempt++ {
+		failed, err := s.attempt(ctx, pause, ch, in, attempt, ev, attemptFunc)
+		if err != nil || len(failed) == 0 {
+			return err
+		}
+		log.Warningf("%s: %d targets failed attempt %d of %d, retrying in %s", s.Name(), len(failed), attempt, s.maxAttempts, s.backoff)
+		timer := time.NewTimer(s.backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-pause:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		retryIn := make(chan *target.Target, len(failed))
+		for _, tgt := range failed {
+			retryIn <- tgt
+		}
+		close(retryIn)
+		in = retryIn
+		attemptFunc = func(inner TestStepChannels) error {
+			return s.inner.Run(ctx, pause, inner, params, ev)
+		}
+	}
+}
+
+// emitRetryAttempt records that a target failed an attempt and will be
+// retried. Failing to emit the event does not prevent the retry.
+func (s *retryStep) emitRetryAttempt(ev testevent.Emitter, terr cerrors.TargetError, attempt int) {
+	payload := RetryAttemptPayload{Attempt: attempt, MaxAttempts: s.maxAttempts}
+	if terr.Err != nil {
+		payload.Error = terr.Err.Error()
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		log.Warningf("could not encode retry attempt %+v: %v", payload, err)
+		return
+	}
+	raw := json.RawMessage(encoded)
+	data := testevent.Data{EventName: EventRetryAttempt, Target: terr.Target, Payload: &raw, Severity: testevent.SeverityWarning}
+	if err := ev.Emit(data); err != nil {
+		log.Warningf("Could not emit %v event for Target: %v", EventRetryAttempt, terr.Target)
+	}
+}
+
+// attempt runs the wrapped step once on the targets read from in. Succeeded
+// targets are forwarded, and so are the failed ones if this is the last
+// attempt; otherwise the failed targets are returned to be retried. The
+// verdicts of the wrapped step are only forwarded along with the targets,
+// so that the verdicts of the failed attempts are dropped.
+func (s *retryStep) attempt(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, in <-chan *target.Target, attempt int, ev testevent.Emitter, f func(TestStepChannels) error) ([]*target.Target, error) {
+	var (
+		out     = make(chan *target.Target)
+		errCh   = make(chan cerrors.TargetError)
+		results = make(chan TargetResult)
+		done    = make(chan error, 1)
+		last    = attempt >= s.maxAttempts
+	)
+	go func() {
+		done <- f(TestStepChannels{In: in, Out: out, Err: errCh, Result: results})
+	}()
+
+	verdicts := make(map[*target.Target]TargetResult)
+	// forward sends the verdict of a target, if any, then the target itself.
+	forward := func(tgt *target.Target, send func() bool) bool {
+		if r, ok := verdicts[tgt]; ok {
+			delete(verdicts, tgt)
+			if ch.Result != nil {
+				select {
+				case ch.Result <- r:
+				case <-ctx.Done():
+					return false
+				case <-pause:
+					return false
+				}
+			}
+		}
+		return send()
+	}
+	var failed []*target.Target
+	for {
+		select {
+		case r := <-results:
+			verdicts[r.Target] = r
+		case tgt := <-out:
+			sent := forward(tgt, func() bool {
+				select {
+				case ch.Out <- tgt:
+					return true
+				case <-ctx.Done():
+				case <-pause:
+				}
+				return false
+			})
+			if !sent {
+				return nil, s.wait(out, errCh, results, done)
+			}
+		case terr := <-errCh:
+			if !last {
+				log.Debugf("%s: target %s failed, will retry: %v", s.Name(), terr.Target, terr.Err)
+				s.emitRetryAttempt(ev, terr, attempt)
+				delete(verdicts, terr.Target)
+				failed = append(failed, terr.Target)
+				continue
+			}
+			sent := forward(terr.Target, func() bool {
+				select {
+				case ch.Err <- terr:
+					return true
+				case <-ctx.Done():
+				case <-pause:
+				}
+				return false
+			})
+			if !sent {
+				return nil, s.wait(out, errCh, results, done)
+			}
+		case err := <-done:
+			if err != nil {
+				return nil, err
+			}
+			return failed, nil
+		case <-ctx.Done():
+			return nil, s.wait(out, errCh, results, done)
+		case <-pause:
+			return nil, s.wait(out, errCh, results, done)
+		}
+	}
+}
+
+// wait waits for the wrapped step to return once an attempt is interrupted,
+// discarding what it sends, and returns its error. A step which does not
+// return within config.TestRunnerStepShutdownTimeout is left to return on its
+// own, so that an interrupted retry step returns in a bounded time.
+func (s *retryStep) wait(out <-chan *target.Target, errCh <-chan cerrors.TargetError, results <-chan TargetResult, done <-chan error) error {
+	timer := time.NewTimer(config.TestRunnerStepShutdownTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-out:
+		case <-errCh:
+		case <-results:
+		case err := <-done:
+			return err
+		case <-timer.C:
+			log.Warningf("%s: step did not return within %s of being interrupted", s.Name(), config.TestRunnerStepShutdownTimeout)
+			go drain(out, errCh, results, done)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/config"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/stretchr/testify/require"
+)
+
+// lingeringStep holds its targets until it is cancelled, then returns err
+// after linger, or once release is closed if set.
+type lingeringStep struct {
+	linger   time.Duration
+	release  chan struct{}
+	err      error
+	returned int32
+}
+
+func (s *lingeringStep) Name() string { return "Lingering" }
+
+func (s *lingeringStep) Run(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.Emitter) error {
+	<-ctx.Done()
+	if s.release != nil {
+		<-s.release
+	}
+	time.Sleep(s.linger)
+	atomic.StoreInt32(&s.returned, 1)
+	return s.err
+}
+
+func (s *lingeringStep) CanResume() bool { return false }
+
+func (s *lingeringStep) Resume(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.EmitterFetcher) error {
+	return nil
+}
+
+func (s *lingeringStep) ValidateParameters(params TestStepParameters) error { return nil }
+
+func runCancelledRetry(t *testing.T, inner TestStep) error {
+	step := WithRetry(inner, RetryPolicy{Count: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan *target.Target, 1)
+	in <- &target.Target{ID: "1"}
+	done := make(chan error, 1)
+	go func() {
+		done <- step.Run(ctx, nil, TestStepChannels{In: in, Out: make(chan *target.Target), Err: make(chan cerrors.TargetError)}, nil, nil)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry step did not return")
+	}
+	return nil
+}
+
+func TestRetryWaitsForTheCancelledStep(t *testing.T) {
+	inner := &lingeringStep{linger: 50 * time.Millisecond, err: errors.New("cancelled")}
+	require.EqualError(t, runCancelledRetry(t, inner), "cancelled")
+	require.Equal(t, int32(1), atomic.LoadInt32(&inner.returned))
+}
+
+func TestRetryWaitForTheCancelledStepIsBounded(t *testing.T) {
+	timeout := config.TestRunnerStepShutdownTimeout
+	config.TestRunnerStepShutdownTimeout = 50 * time.Millisecond
+	defer func() { config.TestRunnerStepShutdownTimeout = timeout }()
+	inner := &lingeringStep{release: make(chan struct{}), err: errors.New("cancelled")}
+	defer close(inner.release)
+	require.NoError(t, runCancelledRetry(t, inner))
+	require.Equal(t, int32(0), atomic.LoadInt32(&inner.returned))
+}
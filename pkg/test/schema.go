@@ -0,0 +1,202 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParameterType is the type of the values of a test step parameter.
+type ParameterType string
+
+// The parameter types.
+const (
+	TypeString ParameterType = "string"
+	TypeInt    ParameterType = "int"
+	TypeBool   ParameterType = "bool"
+	// TypeDuration values are Go durations, e.g. 1m30s.
+	TypeDuration ParameterType = "duration"
+)
+
+// ParameterDefinition declares a parameter of a test step.
+type ParameterDefinition struct {
+	Name string        `json:"name"`
+	Type ParameterType `json:"type"`
+	// Required parameters must have at least one value.
+	Required bool `json:"required,omitempty"`
+	// Repeated parameters take any number of values, the others at most one.
+	Repeated bool `json:"repeated,omitempty"`
+	// Default is the value used when the parameter is not set.
+	Default     string `json:"default,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Schema declares the parameters of a test step.
+type Schema struct {
+	// Version is increased whenever the parameters change, so that tools can
+	// tell which descriptors they support.
+	Version    uint                  `json:"version"`
+	Parameters []ParameterDefinition `json:"parameters"`
+}
+
+// ParameterSchema is implemented by the test steps which declare their
+// parameters. The plugin registry checks the parameters of such steps against
+// the schema, and fills in the defaults, before calling ValidateParameters,
+// which is left with the checks a schema cannot express.
+type ParameterSchema interface {
+	ParameterSchema() Schema
+}
+
+// ParameterError is the failure of a parameter to match its definition. Path
+// is the parameter name, followed by the index of the offending value if
+// any, e.g. sleep[1].
+type ParameterError struct {
+	Path string `json:"path"`
+	Msg  string `json:"msg"`
+}
+
+func (e ParameterError) Error() string {
+	return e.Path + ": " + e.Msg
+}
+
+// ParameterErrors lists all the failures found when validating parameters.
+type ParameterErrors []ParameterError
+
+func (e ParameterErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// parseValue holds the parser of the values of each parameter type.
+var parseValue = map[ParameterType]func(string) error{
+	TypeString: func(string) error { return nil },
+	TypeInt: func(v string) error {
+		_, err := strconv.ParseInt(v, 10, 64)
+		return err
+	},
+	TypeBool: func(v string) error {
+		_, err := strconv.ParseBool(v)
+		return err
+	},
+	TypeDuration: func(v string) error {
+		_, err := time.ParseDuration(v)
+		return err
+	},
+}
+
+// checkValue checks a value against a parameter type. Values with template
+// expressions are only known once expanded on a target, so the steps check
+// them at run time.
+func checkValue(t ParameterType, value string) error {
+	if strings.Contains(value, "{{") {
+		return nil
+	}
+	if err := parseValue[t](value); err != nil {
+		return fmt.Errorf("invalid %s %q", t, value)
+	}
+	return nil
+}
+
+// isUnset tells whether a parameter has no value other than empty strings.
+func isUnset(values []Param) bool {
+	for _, v := range values {
+		if !v.IsEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+// Check verifies that the schema itself is consistent: parameter names are
+// unique and their defaults match their types.
+func (s Schema) Check() error {
+	names := make(map[string]bool)
+	for _, p := range s.Parameters {
+		if p.Name == "" {
+			return errors.New("parameter with empty name")
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate parameter %s", p.Name)
+		}
+		names[p.Name] = true
+		if _, ok := parseValue[p.Type]; !ok {
+			return fmt.Errorf("parameter %s: unknown type %q", p.Name, p.Type)
+		}
+		if p.Default != "" {
+			if err := checkValue(p.Type, p.Default); err != nil {
+				return fmt.Errorf("parameter %s: invalid default: %v", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the parameters against the schema. It returns
+// ParameterErrors listing every parameter which is missing, unknown, or has
+// invalid values.
+func (s Schema) Validate(params TestStepParameters) error {
+	var errs ParameterErrors
+	defined := make(map[string]bool, len(s.Parameters))
+	for _, def := range s.Parameters {
+		defined[def.Name] = true
+		values := params[def.Name]
+		if isUnset(values) {
+			if def.Required {
+				errs = append(errs, ParameterError{Path: def.Name, Msg: "missing required parameter"})
+			}
+			continue
+		}
+		if !def.Repeated && len(values) > 1 {
+			errs = append(errs, ParameterError{Path: def.Name, Msg: fmt.Sprintf("takes one value, got %d", len(values))})
+			continue
+		}
+		for i, v := range values {
+			if v.IsEmpty() {
+				continue
+			}
+			if err := checkValue(def.Type, v.Raw()); err != nil {
+				errs = append(errs, ParameterError{Path: fmt.Sprintf("%s[%d]", def.Name, i), Msg: err.Error()})
+			}
+		}
+	}
+	var unknown []string
+	for name := range params {
+		if !defined[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, ParameterError{Path: name, Msg: "unknown parameter"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// WithDefaults returns a copy of the parameters, with the defaults of the
+// schema added for the parameters which are not set.
+func (s Schema) WithDefaults(params TestStepParameters) TestStepParameters {
+	ret := make(TestStepParameters, len(params))
+	for k, v := range params {
+		ret[k] = v
+	}
+	for _, def := range s.Parameters {
+		if _, ok := ret[def.Name]; !ok && def.Default != "" {
+			ret[def.Name] = []Param{*NewParam(def.Default)}
+		}
+	}
+	return ret
+}
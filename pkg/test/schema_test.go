@@ -0,0 +1,74 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testSchema = Schema{
+	Version: 1,
+	Parameters: []ParameterDefinition{
+		{Name: "host", Type: TypeString, Required: true},
+		{Name: "retries", Type: TypeInt, Default: "3"},
+		{Name: "timeout", Type: TypeDuration, Repeated: true},
+		{Name: "verbose", Type: TypeBool},
+	},
+}
+
+func params(values map[string][]string) TestStepParameters {
+	ret := make(TestStepParameters)
+	for name, vals := range values {
+		for _, v := range vals {
+			ret[name] = append(ret[name], *NewParam(v))
+		}
+	}
+	return ret
+}
+
+func TestSchemaValidate(t *testing.T) {
+	require.NoError(t, testSchema.Validate(params(map[string][]string{
+		"host":    {"{{ .FQDN }}"},
+		"timeout": {"1m", "{{ .Timeout }}"},
+		"verbose": {"true"},
+	})))
+
+	err := testSchema.Validate(params(map[string][]string{
+		"retries": {"1", "2"},
+		"timeout": {"1m", "soon"},
+		"verbos":  {"true"},
+	}))
+	var errs ParameterErrors
+	require.True(t, errors.As(err, &errs))
+	require.Equal(t, ParameterErrors{
+		{Path: "host", Msg: "missing required parameter"},
+		{Path: "retries", Msg: "takes one value, got 2"},
+		{Path: "timeout[1]", Msg: `invalid duration "soon"`},
+		{Path: "verbos", Msg: "unknown parameter"},
+	}, errs)
+}
+
+func TestSchemaWithDefaults(t *testing.T) {
+	p := testSchema.WithDefaults(params(map[string][]string{"host": {"a"}}))
+	require.Equal(t, "3", p.GetOne("retries").Raw())
+	p = testSchema.WithDefaults(params(map[string][]string{"retries": {"1"}}))
+	require.Equal(t, "1", p.GetOne("retries").Raw())
+}
+
+func TestSchemaCheck(t *testing.T) {
+	require.NoError(t, testSchema.Check())
+	for _, s := range []Schema{
+		{Parameters: []ParameterDefinition{{Name: "a", Type: TypeInt}, {Name: "a", Type: TypeInt}}},
+		{Parameters: []ParameterDefinition{{Name: "a", Type: "float"}}},
+		{Parameters: []ParameterDefinition{{Name: "a", Type: TypeBool, Default: "maybe"}}},
+		{Parameters: []ParameterDefinition{{Type: TypeString}}},
+	} {
+		require.Error(t, s.Check())
+	}
+}
@@ -6,14 +6,17 @@
 package test
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/facebookincubator/contest/pkg/cerrors"
 	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/insomniacslk/xjson"
 )
 
 // TestStepParameters represents the parameters that a TestStep should consume
@@ -73,15 +76,56 @@ type TestStepDescriptor struct {
 	Name       string
 	Label      string
 	Parameters TestStepParameters
+	// Breakpoint marks the step as a breakpoint: targets are held before
+	// entering the step until an operator resumes the job.
+	Breakpoint bool
+	// OrderIndependent marks the step as safe to be run in any position
+	// relative to the other order independent steps, when the job shuffles
+	// the steps.
+	OrderIndependent bool
+	// MaxInFlight, if not zero, is the maximum number of targets that the step
+	// processes concurrently. Further targets wait before entering the step,
+	// so steps which only forward targets once they got all of them must not
+	// be capped.
+	MaxInFlight uint `json:"max_in_flight,omitempty"`
+	// Retry, if set, injects the targets failed by the step into it again,
+	// so that transient failures do not fail the targets.
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// Timeout, if not zero, bounds the duration of every attempt of the step.
+	// The step is then cancelled, and the targets it holds are failed.
+	Timeout xjson.Duration `json:"timeout,omitempty"`
+	// TargetTimeout, if not zero, fails the targets which do not leave the
+	// step in time, while the step keeps processing the other targets. The
+	// step is cancelled once it only holds targets which timed out.
+	TargetTimeout xjson.Duration `json:"target_timeout,omitempty"`
+	// OnFailure tells what happens to the targets failed by the step: they
+	// leave the test ("abort", the default), go on to the next step
+	// ("continue"), or skip to the later step with the given label.
+	OnFailure string `json:"on_failure,omitempty"`
+	// Batch, if set, hands the targets to the step in groups rather than one
+	// at a time. The step must implement BatchTestStep.
+	Batch *BatchPolicy `json:"batch,omitempty"`
+	// Condition, if set, is evaluated for every target reaching the step, see
+	// Condition. The targets for which it is false skip the step.
+	Condition string `json:"condition,omitempty"`
 }
 
 // TestStepBundle bundles the selected TestStep together with its parameters as
 // specified in the Test descriptor fetched by the TestFetcher
 type TestStepBundle struct {
-	TestStep      TestStep
-	TestStepLabel string
-	Parameters    TestStepParameters
-	AllowedEvents map[event.Name]bool
+	TestStep         TestStep
+	TestStepLabel    string
+	Parameters       TestStepParameters
+	AllowedEvents    map[event.Name]bool
+	Breakpoint       bool
+	OrderIndependent bool
+	MaxInFlight      uint
+	Retry            *RetryPolicy
+	Timeout          time.Duration
+	TargetTimeout    time.Duration
+	OnFailure        string
+	Batch            *BatchPolicy
+	Condition        *Condition
 }
 
 // TestStepChannels represents the input and output  channels used by a TestStep
@@ -90,6 +134,9 @@ type TestStepChannels struct {
 	In  <-chan *target.Target
 	Out chan<- *target.Target
 	Err chan<- cerrors.TargetError
+	// Result optionally carries the verdict of a target and its reason,
+	// sent before the target is forwarded on Out or Err. See StepOutput.
+	Result chan<- TargetResult
 }
 
 // TestStep is the interface that all steps need to implement to be executed
@@ -98,14 +145,27 @@ type TestStep interface {
 	// Name returns the name of the step
 	Name() string
 	// Run runs the test step. The test step is expected to be synchronous.
-	Run(cancel, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.Emitter) error
+	// The context is cancelled when the step is cancelled, and may carry a
+	// deadline. The pause channel is closed when the job is paused, in which
+	// case resumable steps are expected to return so that they can be resumed
+	// later.
+	Run(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.Emitter) error
 	// CanResume signals whether a test step can be resumed.
 	CanResume() bool
 	// Resume is called if a test step resume is requested, and CanResume
 	// returns true. If resume is not supported, this method should return
 	// ErrResumeNotSupported.
-	Resume(cancel, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.EmitterFetcher) error
+	Resume(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.EmitterFetcher) error
 	// ValidateParameters checks that the parameters are correct before passing
 	// them to Run.
 	ValidateParameters(params TestStepParameters) error
 }
+
+// DryRunnable is implemented by the test steps which support dry runs. In a
+// dry run, DryRun is called instead of Run: steps with side effects log what
+// they would do and forward the targets without acting on them, while read
+// only steps run normally. Steps which do not implement DryRunnable are
+// skipped in dry runs.
+type DryRunnable interface {
+	DryRun(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.Emitter) error
+}
@@ -0,0 +1,350 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+)
+
+// EventTargetTimedOut is emitted when a target is failed because it stayed in
+// a step longer than the timeouts of the step allow.
+const EventTargetTimedOut = event.Name("TargetTimedOut")
+
+// TargetTimedOutPayload is the payload of EventTargetTimedOut.
+type TargetTimedOutPayload struct {
+	Timeout string
+	// StepTimeout tells that the whole step timed out, rather than the
+	// target.
+	StepTimeout bool
+}
+
+// timeoutStep enforces the timeouts of the step it wraps. The wrapped step
+// runs on channels relayed by timeoutStep, so that the targets which time out
+// can be failed while the wrapped step still holds them. When the step itself
+// times out, its context is cancelled, and the targets it holds or has not
+// received yet are failed.
+type timeoutStep struct {
+	inner         TestStep
+	stepTimeout   time.Duration
+	targetTimeout time.Duration
+}
+
+// WithTimeout returns a test step which runs step for at most stepTimeout,
+// and fails the targets which do not leave it within targetTimeout. A zero
+// timeout is not enforced.
+func WithTimeout(step TestStep, stepTimeout, targetTimeout time.Duration) TestStep {
+	return &timeoutStep{inner: step, stepTimeout: stepTimeout, targetTimeout: targetTimeout}
+}
+
+// Name returns the name of the wrapped step.
+func (s *timeoutStep) Name() string {
+	return s.inner.Name()
+}
+
+// ValidateParameters validates the parameters of the wrapped step.
+func (s *timeoutStep) ValidateParameters(params TestStepParameters) error {
+	return s.inner.ValidateParameters(params)
+}
+
+// CanResume tells whether the wrapped step is able to resume.
+func (s *timeoutStep) CanResume() bool {
+	return s.inner.CanResume()
+}
+
+// Run runs the wrapped step, enforcing the timeouts.
+func (s *timeoutStep) Run(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.Emitter) error {
+	return s.run(ctx, pause, ch, ev, func(stepCtx context.Context, inner TestStepChannels) error {
+		return s.inner.Run(stepCtx, pause, inner, params, ev)
+	})
+}
+
+// Resume resumes the wrapped step, enforcing the timeouts. The timeouts start
+// over when resuming.
+func (s *timeoutStep) Resume(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, params TestStepParameters, ev testevent.EmitterFetcher) error {
+	return s.run(ctx, pause, ch, ev, func(stepCtx context.Context, inner TestStepChannels) error {
+		return s.inner.Resume(stepCtx, pause, inner, params, ev)
+	})
+}
+
+func (s *timeoutStep) run(ctx context.Context, pause <-chan struct{}, ch TestStepChannels, ev testevent.Emitter, f func(context.Context, TestStepChannels) error) error {
+	stepCtx, cancelStep := context.WithCancel(ctx)
+	defer cancelStep()
+	var (
+		in      = make(chan *target.Target)
+		out     = make(chan *target.Target)
+		errCh   = make(chan cerrors.TargetError)
+		results = make(chan TargetResult)
+		done    = make(chan error, 1)
+	)
+	go func() {
+		done <- f(stepCtx, TestStepChannels{In: in, Out: out, Err: errCh, Result: results})
+	}()
+	// a wrapped step which outlives the relay must not block on its channels
+	defer func() {
+		if done != nil {
+			go drain(out, errCh, results, done)
+		}
+	}()
+
+	var stepDeadline <-chan time.Time
+	if s.stepTimeout > 0 {
+		timer := time.NewTimer(s.stepTimeout)
+		defer timer.Stop()
+		stepDeadline = timer.C
+	}
+	var (
+		targetTimer    *time.Timer
+		targetDeadline <-chan time.Time
+	)
+	defer func() {
+		if targetTimer != nil {
+			targetTimer.Stop()
+		}
+	}()
+
+	var (
+		outerIn = ch.In
+		stop    = ctx.Done()
+		paused  = pause
+		// queue holds the targets waiting to be fed to the wrapped step
+		queue []*target.Target
+		// inFlight maps the targets held by the wrapped step to their
+		// deadline, and order lists them by deadline
+		inFlight = make(map[*target.Target]time.Time)
+		order    []*target.Target
+		// timedOut holds the failed targets which the wrapped step still holds
+		timedOut = make(map[*target.Target]bool)
+		inClosed bool
+		expired  bool
+		stopping bool
+		innerErr error
+	)
+	// sendErr, sendOut and sendResult forward to the runner, unless the step is
+	// cancelled or paused
+	sendErr := func(terr cerrors.TargetError) bool {
+		select {
+		case ch.Err <- terr:
+			return true
+		case <-ctx.Done():
+		case <-pause:
+		}
+		return false
+	}
+	sendOut := func(t *target.Target) bool {
+		select {
+		case ch.Out <- t:
+			return true
+		case <-ctx.Done():
+		case <-pause:
+		}
+		return false
+	}
+	sendResult := func(r TargetResult) bool {
+		select {
+		case ch.Result <- r:
+			return true
+		case <-ctx.Done():
+		case <-pause:
+		}
+		return false
+	}
+	fail := func(t *target.Target, timeout time.Duration, wholeStep bool) bool {
+		s.emitTimedOut(ev, t, timeout, wholeStep)
+		var err error
+		if wholeStep {
+			err = fmt.Errorf("step %s timed out after %s", s.Name(), timeout)
+		} else {
+			err = fmt.Errorf("target did not leave step %s within %s", s.Name(), timeout)
+		}
+		return sendErr(cerrors.TargetError{Target: t, Err: err})
+	}
+	// arm sets the timer to the earliest deadline of the targets in flight
+	arm := func() {
+		for len(order) > 0 {
+			if _, ok := inFlight[order[0]]; ok {
+				break
+			}
+			order = order[1:]
+		}
+		targetDeadline = nil
+		if len(order) > 0 {
+			targetTimer = time.NewTimer(time.Until(inFlight[order[0]]))
+			targetDeadline = targetTimer.C
+		}
+	}
+
+	for {
+		if !inClosed && (outerIn == nil || expired) && len(queue) == 0 {
+			inClosed = true
+			close(in)
+		}
+		if expired && (outerIn == nil || stopping) {
+			// the error returned by the step once cancelled is not a failure
+			return nil
+		}
+		if done != nil && outerIn == nil && len(queue) == 0 && len(inFlight) == 0 && len(timedOut) > 0 {
+			// the step only holds targets which timed out, which it is not
+			// given the time to return
+			log.Warningf("%s: cancelling the step, which holds %d targets which timed out", s.Name(), len(timedOut))
+			cancelStep()
+			return nil
+		}
+		if done == nil && (outerIn == nil || stopping) {
+			return innerErr
+		}
+		var (
+			feed chan<- *target.Target
+			next *target.Target
+		)
+		if !inClosed && len(queue) > 0 {
+			feed, next = in, queue[0]
+		}
+		select {
+		case t, ok := <-outerIn:
+			if !ok {
+				outerIn = nil
+				continue
+			}
+			if expired {
+				if !fail(t, s.stepTimeout, true) {
+					return nil
+				}
+				continue
+			}
+			queue = append(queue, t)
+		case feed <- next:
+			queue = queue[1:]
+			if s.targetTimeout > 0 {
+				inFlight[next] = time.Now().Add(s.targetTimeout)
+				order = append(order, next)
+				if targetDeadline == nil {
+					arm()
+				}
+			} else {
+				inFlight[next] = time.Time{}
+			}
+		case r := <-results:
+			if timedOut[r.Target] || ch.Result == nil {
+				continue
+			}
+			if !sendResult(r) {
+				return nil
+			}
+		case t := <-out:
+			if timedOut[t] {
+				delete(timedOut, t)
+				log.Warningf("%s: dropping target %s, which left the step after timing out", s.Name(), t)
+				continue
+			}
+			delete(inFlight, t)
+			if !sendOut(t) {
+				return nil
+			}
+		case terr := <-errCh:
+			if timedOut[terr.Target] {
+				delete(timedOut, terr.Target)
+				log.Warningf("%s: dropping target %s, which failed after timing out: %v", s.Name(), terr.Target, terr.Err)
+				continue
+			}
+			delete(inFlight, terr.Target)
+			if !sendErr(terr) {
+				return nil
+			}
+		case err := <-done:
+			done = nil
+			innerErr = err
+		case <-targetDeadline:
+			now := time.Now()
+			for len(order) > 0 {
+				t := order[0]
+				deadline, ok := inFlight[t]
+				if ok && deadline.After(now) {
+					break
+				}
+				order = order[1:]
+				if !ok {
+					continue
+				}
+				delete(inFlight, t)
+				timedOut[t] = true
+				if !fail(t, s.targetTimeout, false) {
+					return nil
+				}
+			}
+			arm()
+		case <-stepDeadline:
+			log.Warningf("%s: step timed out after %s, failing %d targets", s.Name(), s.stepTimeout, len(inFlight)+len(queue))
+			stepDeadline = nil
+			expired = true
+			cancelStep()
+			for _, t := range order {
+				if _, ok := inFlight[t]; ok {
+					delete(inFlight, t)
+					timedOut[t] = true
+					if !fail(t, s.stepTimeout, true) {
+						return nil
+					}
+				}
+			}
+			order = nil
+			// without a target timeout, the targets in flight are not ordered
+			for t := range inFlight {
+				delete(inFlight, t)
+				timedOut[t] = true
+				if !fail(t, s.stepTimeout, true) {
+					return nil
+				}
+			}
+			for _, t := range queue {
+				if !fail(t, s.stepTimeout, true) {
+					return nil
+				}
+			}
+			queue = nil
+		case <-stop:
+			stop, stopping = nil, true
+		case <-paused:
+			paused, stopping = nil, true
+		}
+	}
+}
+
+// emitTimedOut records that a target timed out. Failing to emit the event
+// does not prevent failing the target.
+func (s *timeoutStep) emitTimedOut(ev testevent.Emitter, t *target.Target, timeout time.Duration, wholeStep bool) {
+	payload := TargetTimedOutPayload{Timeout: timeout.String(), StepTimeout: wholeStep}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		log.Warningf("could not encode timeout %+v: %v", payload, err)
+		return
+	}
+	raw := json.RawMessage(encoded)
+	data := testevent.Data{EventName: EventTargetTimedOut, Target: t, Payload: &raw, Severity: testevent.SeverityError}
+	if err := ev.Emit(data); err != nil {
+		log.Warningf("Could not emit %v event for Target: %v", EventTargetTimedOut, t)
+	}
+}
+
+// drain discards what a wrapped step sends until it returns.
+func drain(out <-chan *target.Target, errCh <-chan cerrors.TargetError, results <-chan TargetResult, done <-chan error) {
+	for {
+		select {
+		case <-out:
+		case <-errCh:
+		case <-results:
+		case <-done:
+			return
+		}
+	}
+}
@@ -5,8 +5,94 @@
 
 package types
 
-// JobID represents a unique job identifier
-type JobID uint64
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// MaxJobIDLength is the maximum length of a job ID.
+const MaxJobIDLength = 64
+
+// JobID represents a unique job identifier. Its representation depends on
+// the strategy which allocated it, e.g. a decimal number, a ULID or a UUID,
+// see the jobid package. The empty JobID is not a valid job ID, as it is used
+// to mean any job in queries.
+type JobID string
+
+// String returns the representation of the job ID.
+func (j JobID) String() string {
+	return string(j)
+}
+
+// Numeric tells whether the job ID is a decimal number, as allocated by the
+// sequential strategy.
+func (j JobID) Numeric() bool {
+	if j == "" || j[0] == '0' {
+		return false
+	}
+	for _, c := range j {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON marshals numeric job IDs as JSON numbers, as they were before
+// job IDs could be allocated by other strategies, and the others as strings.
+func (j JobID) MarshalJSON() ([]byte, error) {
+	if j.Numeric() {
+		return []byte(j), nil
+	}
+	return json.Marshal(string(j))
+}
+
+// UnmarshalJSON unmarshals a job ID from a JSON number or string.
+func (j *JobID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*j = JobID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid job ID %s: must be a number or a string", data)
+	}
+	*j = JobID(n.String())
+	return nil
+}
+
+// ParseJobID parses the representation of a job ID, which is made of up to
+// MaxJobIDLength letters, digits, '-' and '_'. Zero is not a valid job ID, and
+// numeric job IDs are normalized, e.g. "007" to "7". The check is syntactic
+// only: whether the ID is one the server could have allocated depends on its
+// allocation strategy, see jobid.Allocator.Parse.
+func ParseJobID(s string) (JobID, error) {
+	if s == "" {
+		return "", errors.New("job ID cannot be empty")
+	}
+	if len(s) > MaxJobIDLength {
+		return "", fmt.Errorf("invalid job ID %q: longer than %d characters", s, MaxJobIDLength)
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && c != '-' && c != '_' {
+			return "", fmt.Errorf("invalid job ID %q: must only contain letters, digits, '-' and '_'", s)
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return JobID(s), nil
+	}
+	if v == 0 {
+		return "", fmt.Errorf("invalid job ID %q: must not be zero", s)
+	}
+	return JobID(strconv.FormatUint(v, 10)), nil
+}
 
 // RunID represents the id of a run within the Job
 type RunID uint64
@@ -0,0 +1,61 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package types
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJobID(t *testing.T) {
+	for _, s := range []string{
+		"1",
+		"42",
+		"18446744073709551616",
+		"01ARZ3NDEKTSV4RRFFQ69G5FAV",
+		"f47ac10b-58cc-4372-a567-0e02b2c3d479",
+		strings.Repeat("a", MaxJobIDLength),
+	} {
+		jobID, err := ParseJobID(s)
+		require.NoError(t, err, s)
+		require.Equal(t, s, jobID.String())
+	}
+}
+
+func TestParseJobIDNormalizesNumbers(t *testing.T) {
+	for s, expected := range map[string]JobID{
+		"7":                    "7",
+		"007":                  "7",
+		"18446744073709551615": "18446744073709551615",
+	} {
+		jobID, err := ParseJobID(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, jobID)
+	}
+}
+
+func TestJobIDJSON(t *testing.T) {
+	for jobID, encoded := range map[JobID]string{
+		"42":                         `42`,
+		"042":                        `"042"`,
+		"01ARZ3NDEKTSV4RRFFQ69G5FAV": `"01ARZ3NDEKTSV4RRFFQ69G5FAV"`,
+	} {
+		data, err := json.Marshal(jobID)
+		require.NoError(t, err)
+		require.Equal(t, encoded, string(data))
+		var decoded JobID
+		require.NoError(t, json.Unmarshal(data, &decoded))
+		require.Equal(t, jobID, decoded)
+	}
+	// numeric job IDs are accepted as strings too
+	var decoded JobID
+	require.NoError(t, json.Unmarshal([]byte(`"42"`), &decoded))
+	require.Equal(t, JobID("42"), decoded)
+	require.Error(t, json.Unmarshal([]byte(`true`), &decoded))
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package grpclistener
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/api/grpc"
+	"github.com/facebookincubator/contest/pkg/logging"
+)
+
+var log = logging.GetLogger("listeners/grpclistener")
+
+// GRPCListener implements the api.Listener interface, serving the ConTest
+// service of pkg/api/grpc. gRPC runs over HTTP/2, which the listener
+// negotiates through TLS, hence it needs a certificate.
+type GRPCListener struct {
+	addr     string
+	certFile string
+	keyFile  string
+}
+
+// New returns a GRPCListener listening on addr, e.g. ":8081", with the TLS
+// certificate and key in the given PEM files.
+func New(addr, certFile, keyFile string) (*GRPCListener, error) {
+	if addr == "" {
+		return nil, errors.New("the address of the gRPC listener cannot be empty")
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("the gRPC listener needs a TLS certificate and key")
+	}
+	return &GRPCListener{addr: addr, certFile: certFile, keyFile: keyFile}, nil
+}
+
+// Serve implements the api.Listener.Serve interface method.
+func (l *GRPCListener) Serve(cancel <-chan struct{}, a *api.API) error {
+	if a == nil {
+		return errors.New("API object is nil")
+	}
+	s := http.Server{
+		Addr:         l.addr,
+		Handler:      grpc.NewHandler(a),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.ListenAndServeTLS(l.certFile, l.keyFile)
+	}()
+	log.Infof("Started gRPC API listener on %s", s.Addr)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("gRPC listener failed: %v", err)
+	case <-cancel:
+		log.Printf("Received server shut down request")
+		if err := s.Close(); err != nil {
+			return fmt.Errorf("could not shut down the gRPC listener: %v", err)
+		}
+	}
+	log.Printf("gRPC server shut down successfully.")
+	return nil
+}
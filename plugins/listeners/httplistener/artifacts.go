@@ -0,0 +1,98 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package httplistener
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// artifactsPathSegment follows the job ID in the artifact paths:
+// /jobs/{id}/artifacts lists the artifacts of a job, and
+// /jobs/{id}/artifacts/{test}/{targetID}/{name} downloads one of them. Test
+// names, target IDs and artifact names are path-escaped.
+const artifactsPathSegment = "artifacts"
+
+// artifactPath parses the path of an artifact request, with parse parsing the
+// job ID. ok is false if the path is not an artifact path, and key.Name is
+// empty for listings.
+func artifactPath(escapedPath string, parse func(string) (types.JobID, error)) (key storage.ArtifactKey, ok bool, err error) {
+	parts := strings.Split(strings.TrimPrefix(escapedPath, outputPathPrefix), "/")
+	if len(parts) < 2 || parts[1] != artifactsPathSegment {
+		return key, false, nil
+	}
+	if len(parts) != 2 && len(parts) != 5 {
+		return key, true, errors.New("invalid path, must be /jobs/{id}/artifacts or /jobs/{id}/artifacts/{test}/{targetID}/{name}")
+	}
+	for i, p := range parts {
+		if parts[i], err = url.PathUnescape(p); err != nil {
+			return key, true, fmt.Errorf("invalid path: %v", err)
+		}
+	}
+	if key.JobID, err = parse(parts[0]); err != nil {
+		return key, true, err
+	}
+	if len(parts) == 5 {
+		key.TestName, key.TargetID, key.Name = parts[2], parts[3], parts[4]
+	}
+	return key, true, nil
+}
+
+// artifactsHandler lists and serves the artifacts attached by the test steps.
+type artifactsHandler struct {
+	api *api.API
+}
+
+func (h *artifactsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		reply(w, http.StatusMethodNotAllowed, "Only GET requests are supported")
+		return
+	}
+	key, _, err := artifactPath(r.URL.EscapedPath(), h.api.ParseJobID)
+	if err != nil {
+		reply(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if key.Name == "" {
+		artifacts, err := storage.ListArtifacts(key.JobID)
+		if err != nil {
+			reply(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if artifacts == nil {
+			artifacts = []storage.Artifact{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(artifacts)
+		return
+	}
+	rc, err := storage.OpenArtifact(key)
+	if errors.Is(err, storage.ErrArtifactNotFound) {
+		reply(w, http.StatusNotFound, fmt.Sprintf("No artifact %s for target %s in test %s of job %s", key.Name, key.TargetID, key.TestName, key.JobID))
+		return
+	}
+	if err != nil {
+		reply(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", key.Name))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Debugf("Cannot send artifact %s to client: %v", key.Name, err)
+	}
+}
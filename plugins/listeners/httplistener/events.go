@@ -0,0 +1,155 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package httplistener
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// eventsPathSuffix is the suffix of the event stream paths, which have the
+// form /jobs/{id}/events.
+const eventsPathSuffix = "/events"
+
+// eventsKeepAlive is the interval of the comments sent on idle event streams,
+// so that proxies do not drop them and dead clients are noticed.
+const eventsKeepAlive = 15 * time.Second
+
+// Names of the server-sent events of the event streams. Test events carry a
+// testevent.Event, job events a frameworkevent.Event, and the end event the
+// final state of the job.
+const (
+	sseTestEvent = "test"
+	sseJobEvent  = "job"
+	sseEnd       = "end"
+	sseLagged    = "lagged"
+)
+
+// StreamEnd is the data of the end event of an event stream.
+type StreamEnd struct {
+	State string
+}
+
+// eventsJobID parses the path of an event stream request, with parse parsing
+// the job ID. ok is false if the path is not an event stream path.
+func eventsJobID(path string, parse func(string) (types.JobID, error)) (jobID types.JobID, ok bool, err error) {
+	parts := strings.Split(strings.TrimPrefix(path, outputPathPrefix), "/")
+	if len(parts) != 2 || "/"+parts[1] != eventsPathSuffix {
+		return "", false, nil
+	}
+	jobID, err = parse(parts[0])
+	return jobID, true, err
+}
+
+// isCompletion tells whether a job state is the end of the job.
+func isCompletion(state string) bool {
+	for _, name := range job.CompletionEvents {
+		if string(name) == state {
+			return true
+		}
+	}
+	return false
+}
+
+// eventsHandler streams the test events and the state changes of a job as
+// server-sent events, from the time of the request until the job ends. The
+// events emitted earlier are available with the events verb.
+type eventsHandler struct {
+	api *api.API
+}
+
+func (h *eventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		reply(w, http.StatusMethodNotAllowed, "Only GET requests are supported")
+		return
+	}
+	jobID, _, err := eventsJobID(r.URL.Path, h.api.ParseJobID)
+	if err != nil {
+		reply(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// subscribe before looking at the state, so that the end of the job cannot
+	// be missed
+	sub := storage.SubscribeJob(jobID)
+	defer sub.Close()
+	resp, err := h.api.Status(api.EventRequestor(r.URL.Query().Get("requestor")), jobID)
+	if err != nil {
+		reply(w, http.StatusInternalServerError, fmt.Sprintf("Status failed: %v", err))
+		return
+	}
+	if resp.Err != nil {
+		reply(w, http.StatusNotFound, fmt.Sprintf("Status failed: %v", resp.Err))
+		return
+	}
+	var state string
+	if data, ok := resp.Data.(api.ResponseDataStatus); ok && data.Status != nil {
+		state = data.Status.State
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(name string, data interface{}) bool {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			log.Warningf("Cannot encode %s event of job %s: %v", name, jobID, err)
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded); err != nil {
+			log.Debugf("Cannot write event stream to client: %v", err)
+			return false
+		}
+		return true
+	}
+	if isCompletion(state) {
+		send(sseEnd, StreamEnd{State: state})
+		return
+	}
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				if sub.Lagged() {
+					send(sseLagged, struct{}{})
+				}
+				return
+			}
+			if ev.Test != nil {
+				if !send(sseTestEvent, ev.Test) {
+					return
+				}
+				continue
+			}
+			if !send(sseJobEvent, ev.Framework) {
+				return
+			}
+			if isCompletion(string(ev.Framework.EventName)) {
+				send(sseEnd, StreamEnd{State: string(ev.Framework.EventName)})
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
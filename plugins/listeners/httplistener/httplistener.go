@@ -10,18 +10,25 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/job"
 	"github.com/facebookincubator/contest/pkg/logging"
-	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/pkg/metrics"
+	"github.com/insomniacslk/xjson"
 )
 
 var log = logging.GetLogger("listeners/httplistener")
 
+// apiWriteTimeout bounds the handling of the API verbs.
+const apiWriteTimeout = 10 * time.Second
+
 // HTTPListener implements the api.Listener interface.
 type HTTPListener struct {
 }
@@ -63,17 +70,6 @@ type HTTPAPIError struct {
 	Msg string
 }
 
-func strToJobID(s string) (types.JobID, error) {
-	if strings.TrimSpace(s) == "" {
-		return 0, errors.New("job ID cannot be empty")
-	}
-	jobIDInt, err := strconv.ParseInt(s, 10, 64)
-	if err != nil {
-		return 0, err
-	}
-	return types.JobID(jobIDInt), nil
-}
-
 type apiHandler struct {
 	api *api.API
 }
@@ -85,6 +81,54 @@ func reply(w http.ResponseWriter, status int, msg string) {
 	}
 }
 
+// parameterValues parses the optional parameter values of a start or
+// starttemplate request, which are passed as a JSON object of strings.
+func parameterValues(r *http.Request) (map[string]string, error) {
+	values := make(map[string]string)
+	if v := r.PostFormValue("values"); v != "" {
+		if err := json.Unmarshal([]byte(v), &values); err != nil {
+			return nil, fmt.Errorf("invalid parameter values: %v", err)
+		}
+	}
+	return values, nil
+}
+
+// templateArgs parses the optional template version, and the parameter
+// values of a starttemplate request.
+func templateArgs(r *http.Request) (uint, map[string]string, error) {
+	var version uint64
+	if v := r.PostFormValue("version"); v != "" {
+		var err error
+		if version, err = strconv.ParseUint(v, 10, 32); err != nil {
+			return 0, nil, fmt.Errorf("invalid template version %q: %v", v, err)
+		}
+	}
+	values, err := parameterValues(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return uint(version), values, nil
+}
+
+// scheduleArgs parses the recurrence and the parameter values of a
+// createschedule request. The recurrence is either a cron expression or an
+// interval, such as 1h.
+func scheduleArgs(r *http.Request) (job.Recurrence, map[string]string, error) {
+	recurrence := job.Recurrence{Cron: r.PostFormValue("cron")}
+	if v := r.PostFormValue("interval"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return recurrence, nil, fmt.Errorf("invalid interval %q: %v", v, err)
+		}
+		recurrence.Interval = xjson.Duration(interval)
+	}
+	values, err := parameterValues(r)
+	if err != nil {
+		return recurrence, nil, err
+	}
+	return recurrence, values, nil
+}
+
 func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	verb := strings.TrimLeft(r.URL.Path, "/")
 	var (
@@ -93,8 +137,10 @@ func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		errMsg     string
 		err        error
 	)
-	// This is only used by status, stop, and reply. Ignored for other
-	// methods. If not set by the client, this is an empty string.
+	// This is only used by status, stop, retry, resume, replayfailed and
+	// events. Ignored for other methods. If not set by the client, this is an
+	// empty string. The job description is used by start, storetemplate and
+	// createschedule, and optionally by resume.
 	if r.Method != "POST" {
 		reply(w, http.StatusBadRequest, "Only POST requests are supported")
 		return
@@ -110,12 +156,18 @@ func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			errMsg = "Missing job description"
 			break
 		}
-		if resp, err = h.api.Start(requestor, jobDesc); err != nil {
+		values, err := parameterValues(r)
+		if err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("Start failed: %v", err)
+			break
+		}
+		if resp, err = h.api.StartWithParameters(requestor, jobDesc, values); err != nil {
 			httpStatus = http.StatusBadRequest
 			errMsg = fmt.Sprintf("Start failed: %v", err)
 		}
 	case "status":
-		jobID, err := strToJobID(jobIDStr)
+		jobID, err := h.api.ParseJobID(jobIDStr)
 		if err != nil {
 			httpStatus = http.StatusBadRequest
 			errMsg = fmt.Sprintf("Status failed: %v", err)
@@ -126,7 +178,7 @@ func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			errMsg = fmt.Sprintf("Status failed: %v", err)
 		}
 	case "stop":
-		jobID, err := strToJobID(jobIDStr)
+		jobID, err := h.api.ParseJobID(jobIDStr)
 		if err != nil {
 			httpStatus = http.StatusBadRequest
 			errMsg = fmt.Sprintf("Stop failed: %v", err)
@@ -137,7 +189,7 @@ func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			errMsg = fmt.Sprintf("Stop failed: %v", err)
 		}
 	case "retry":
-		jobID, err := strToJobID(jobIDStr)
+		jobID, err := h.api.ParseJobID(jobIDStr)
 		if err != nil {
 			httpStatus = http.StatusBadRequest
 			errMsg = fmt.Sprintf("Retry failed: %v", err)
@@ -147,12 +199,138 @@ func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			httpStatus = http.StatusBadRequest
 			errMsg = fmt.Sprintf("Retry failed: %v", err)
 		}
+	case "resume":
+		jobID, err := h.api.ParseJobID(jobIDStr)
+		if err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("Resume failed: %v", err)
+			break
+		}
+		if resp, err = h.api.Resume(requestor, jobID, jobDesc); err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("Resume failed: %v", err)
+		}
+	case "replayfailed":
+		jobID, err := h.api.ParseJobID(jobIDStr)
+		if err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("ReplayFailed failed: %v", err)
+			break
+		}
+		if resp, err = h.api.ReplayFailed(requestor, jobID); err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("ReplayFailed failed: %v", err)
+		}
+	case "storetemplate":
+		if jobDesc == "" {
+			httpStatus = http.StatusBadRequest
+			errMsg = "Missing job description"
+			break
+		}
+		if resp, err = h.api.StoreTemplate(requestor, r.PostFormValue("template"), jobDesc); err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("StoreTemplate failed: %v", err)
+		}
+	case "starttemplate":
+		version, values, err := templateArgs(r)
+		if err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("StartTemplate failed: %v", err)
+			break
+		}
+		if resp, err = h.api.StartTemplate(requestor, r.PostFormValue("template"), version, values); err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("StartTemplate failed: %v", err)
+		}
+	case "events":
+		jobID, err := h.api.ParseJobID(jobIDStr)
+		if err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("Events failed: %v", err)
+			break
+		}
+		var limit int
+		if v := r.PostFormValue("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil {
+				httpStatus = http.StatusBadRequest
+				errMsg = fmt.Sprintf("Events failed: invalid limit %q", v)
+				break
+			}
+		}
+		if resp, err = h.api.TestEvents(requestor, jobID, limit, r.PostFormValue("cursor")); err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("Events failed: %v", err)
+		}
+	case "createschedule":
+		if jobDesc == "" {
+			httpStatus = http.StatusBadRequest
+			errMsg = "Missing job description"
+			break
+		}
+		recurrence, values, err := scheduleArgs(r)
+		if err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("CreateSchedule failed: %v", err)
+			break
+		}
+		if resp, err = h.api.CreateSchedule(requestor, jobDesc, recurrence, values); err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("CreateSchedule failed: %v", err)
+		}
+	case "schedules":
+		if resp, err = h.api.Schedules(requestor); err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("Schedules failed: %v", err)
+		}
+	case "plugins":
+		if resp, err = h.api.Plugins(requestor); err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("Plugins failed: %v", err)
+		}
+	case "quarantined":
+		if resp, err = h.api.QuarantinedTargets(requestor); err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("QuarantinedTargets failed: %v", err)
+		}
+	case "releasetarget":
+		if resp, err = h.api.ReleaseTarget(requestor, r.PostFormValue("targetID")); err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("ReleaseTarget failed: %v", err)
+		}
+	case "schedule", "pauseschedule", "resumeschedule", "cancelschedule":
+		id, err := strconv.ParseUint(r.PostFormValue("scheduleID"), 10, 64)
+		if err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("invalid schedule ID %q", r.PostFormValue("scheduleID"))
+			break
+		}
+		switch verb {
+		case "schedule":
+			resp, err = h.api.Schedule(requestor, id)
+		case "pauseschedule":
+			resp, err = h.api.PauseSchedule(requestor, id)
+		case "resumeschedule":
+			resp, err = h.api.ResumeSchedule(requestor, id)
+		default:
+			resp, err = h.api.CancelSchedule(requestor, id)
+		}
+		if err != nil {
+			httpStatus = http.StatusBadRequest
+			errMsg = fmt.Sprintf("%s failed: %v", verb, err)
+		}
 	case "version":
 		resp = h.api.Version()
 	default:
 		errMsg = fmt.Sprintf("unknown verb: %s", verb)
 		httpStatus = http.StatusBadRequest
 	}
+	// rejected submissions tell the client when to retry
+	var rateLimited *cerrors.ErrSubmissionRateLimited
+	if httpStatus == http.StatusOK && errors.As(resp.Err, &rateLimited) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateLimited.RetryAfter.Seconds()))))
+		httpStatus = http.StatusTooManyRequests
+		errMsg = rateLimited.Error()
+	}
 	if httpStatus != http.StatusOK {
 		errResp := HTTPAPIError{
 			Msg: errMsg,
@@ -203,11 +381,22 @@ func (h *HTTPListener) Serve(cancel <-chan struct{}, a *api.API) error {
 	if a == nil {
 		return errors.New("API object is nil")
 	}
+	// the write timeout applies to the API verbs and verdicts only, as the
+	// live output and event streams last as long as the steps and jobs run,
+	// and artifacts may be large.
+	mux := http.NewServeMux()
+	mux.Handle("/", http.TimeoutHandler(&apiHandler{api: a}, apiWriteTimeout, ""))
+	mux.Handle(outputPathPrefix, &jobsHandler{
+		verdict:   http.TimeoutHandler(&verdictHandler{api: a}, apiWriteTimeout, ""),
+		events:    &eventsHandler{api: a},
+		artifacts: &artifactsHandler{api: a},
+		output:    &outputHandler{api: a},
+	})
+	mux.Handle("/metrics", http.TimeoutHandler(metrics.Default, apiWriteTimeout, ""))
 	s := http.Server{
-		Addr:         ":8080",
-		Handler:      &apiHandler{api: a},
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		Addr:        ":8080",
+		Handler:     mux,
+		ReadTimeout: 10 * time.Second,
 	}
 	if err := listenWithCancellation(cancel, &s); err != nil {
 		return fmt.Errorf("HTTP listener failed: %v", err)
@@ -0,0 +1,93 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package httplistener
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/liveoutput"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// outputPathPrefix is the prefix of the live output stream paths, which have
+// the form /jobs/{id}/targets/{targetID}/steps/{step}/output. Target IDs and
+// step labels are path-escaped.
+const outputPathPrefix = "/jobs/"
+
+// outputKey parses the path of a live output stream request, with parse
+// parsing the job ID.
+func outputKey(escapedPath string, parse func(string) (types.JobID, error)) (liveoutput.Key, error) {
+	var key liveoutput.Key
+	parts := strings.Split(strings.TrimPrefix(escapedPath, outputPathPrefix), "/")
+	if len(parts) != 6 || parts[1] != "targets" || parts[3] != "steps" || parts[5] != "output" {
+		return key, errors.New("invalid path, must be /jobs/{id}/targets/{targetID}/steps/{step}/output")
+	}
+	for i, p := range parts {
+		unescaped, err := url.PathUnescape(p)
+		if err != nil {
+			return key, fmt.Errorf("invalid path: %v", err)
+		}
+		parts[i] = unescaped
+	}
+	jobID, err := parse(parts[0])
+	if err != nil {
+		return key, err
+	}
+	return liveoutput.Key{JobID: jobID, TargetID: parts[2], StepLabel: parts[4]}, nil
+}
+
+// outputHandler streams the live output of a running step for a target,
+// until the step completes for the target or the client goes away.
+type outputHandler struct {
+	api *api.API
+}
+
+func (h *outputHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		reply(w, http.StatusMethodNotAllowed, "Only GET requests are supported")
+		return
+	}
+	key, err := outputKey(r.URL.EscapedPath(), h.api.ParseJobID)
+	if err != nil {
+		reply(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reader, err := liveoutput.Subscribe(key)
+	if err != nil {
+		reply(w, http.StatusNotFound, fmt.Sprintf("No live output for target %s in step %s of job %s", key.TargetID, key.StepLabel, key.JobID))
+		return
+	}
+	defer reader.Close()
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case chunk, ok := <-reader.Chunks():
+			if !ok {
+				if reader.Lagged() {
+					_, _ = fmt.Fprint(w, "\n[live output interrupted: client too slow]\n")
+				}
+				return
+			}
+			if _, err := w.Write(chunk); err != nil {
+				log.Debugf("Cannot write live output to client: %v", err)
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
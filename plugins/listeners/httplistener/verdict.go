@@ -0,0 +1,104 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package httplistener
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/api"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// verdictPathSuffix is the suffix of the verdict paths, which have the form
+// /jobs/{id}/verdict.
+const verdictPathSuffix = "/verdict"
+
+// verdictJobID parses the path of a verdict request, with parse parsing the
+// job ID. ok is false if the path is not a verdict path.
+func verdictJobID(path string, parse func(string) (types.JobID, error)) (jobID types.JobID, ok bool, err error) {
+	parts := strings.Split(strings.TrimPrefix(path, outputPathPrefix), "/")
+	if len(parts) != 2 || "/"+parts[1] != verdictPathSuffix {
+		return "", false, nil
+	}
+	jobID, err = parse(parts[0])
+	return jobID, true, err
+}
+
+// verdictHandler serves the verdict of a job, as the canonical verdict.json
+// document, once the job ended.
+type verdictHandler struct {
+	api *api.API
+}
+
+func (h *verdictHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		reply(w, http.StatusMethodNotAllowed, "Only GET requests are supported")
+		return
+	}
+	jobID, _, err := verdictJobID(r.URL.Path, h.api.ParseJobID)
+	if err != nil {
+		reply(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp, err := h.api.Verdict(api.EventRequestor(r.URL.Query().Get("requestor")), jobID)
+	if err != nil {
+		reply(w, http.StatusInternalServerError, fmt.Sprintf("Verdict failed: %v", err))
+		return
+	}
+	if resp.Err != nil {
+		reply(w, http.StatusNotFound, fmt.Sprintf("Verdict failed: %v", resp.Err))
+		return
+	}
+	data, _ := resp.Data.(api.ResponseDataVerdict)
+	if data.Verdict == nil {
+		reply(w, http.StatusNotFound, fmt.Sprintf("Job %s did not end yet, its verdict is not available", jobID))
+		return
+	}
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data.Verdict); err != nil {
+		reply(w, http.StatusInternalServerError, fmt.Sprintf("cannot marshal verdict: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", job.VerdictFileName))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buffer.Bytes())
+}
+
+// jobsHandler routes the requests under /jobs/ to the verdict, to the event
+// stream, to the artifacts or to the live output handler.
+type jobsHandler struct {
+	verdict   http.Handler
+	events    http.Handler
+	artifacts http.Handler
+	output    http.Handler
+}
+
+func (h *jobsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the routing only depends on the shape of the path, the handlers
+	// validate the job IDs
+	if _, ok, _ := verdictJobID(r.URL.Path, types.ParseJobID); ok {
+		h.verdict.ServeHTTP(w, r)
+		return
+	}
+	if _, ok, _ := eventsJobID(r.URL.Path, types.ParseJobID); ok {
+		h.events.ServeHTTP(w, r)
+		return
+	}
+	if _, ok, _ := artifactPath(r.URL.EscapedPath(), types.ParseJobID); ok {
+		h.artifacts.ServeHTTP(w, r)
+		return
+	}
+	h.output.ServeHTTP(w, r)
+}
@@ -0,0 +1,382 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package nats
+
+// Package nats publishes the test events to a subject of a NATS server, as
+// they are emitted. Events are queued in a bounded buffer and published in
+// batches by a background goroutine. Each batch is followed by a PING, and is
+// only considered delivered once the server answers with a PONG, which it does
+// after processing all the preceding messages: batches which are not
+// acknowledged are published again on a new connection, hence the delivery is
+// at-least-once. When the buffer is full, e.g. because the server is
+// unreachable, new events are dropped and counted.
+//
+// Only the subset of the NATS client protocol needed to publish is
+// implemented, without TLS.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/logging"
+)
+
+var log = logging.GetLogger("publishers/nats")
+
+const (
+	// DefaultPort is the port used when the broker URL does not specify one.
+	DefaultPort = 4222
+	// DefaultBufferSize is the default number of events which can be queued
+	// while waiting to be published.
+	DefaultBufferSize = 4096
+
+	defaultTimeout       = 10 * time.Second
+	defaultRetryInterval = 5 * time.Second
+	maxBatchSize         = 128
+)
+
+// Publisher publishes the test events to a NATS subject. It implements the
+// storage.TestEventSubscriber interface.
+type Publisher struct {
+	// counters are accessed atomically, keep them first for alignment.
+	dropped   uint64
+	published uint64
+
+	addr          string
+	connectInfo   []byte
+	subject       string
+	buf           chan []byte
+	timeout       time.Duration
+	retryInterval time.Duration
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// Option is used to configure a Publisher.
+type Option func(*Publisher)
+
+// OptionBufferSize sets the number of events which can be queued.
+func OptionBufferSize(size int) Option {
+	return func(p *Publisher) {
+		if size > 0 {
+			p.buf = make(chan []byte, size)
+		}
+	}
+}
+
+// OptionTimeout sets the timeout of connections and acknowledgements.
+func OptionTimeout(d time.Duration) Option {
+	return func(p *Publisher) {
+		p.timeout = d
+	}
+}
+
+// OptionRetryInterval sets the interval between connection attempts.
+func OptionRetryInterval(d time.Duration) Option {
+	return func(p *Publisher) {
+		p.retryInterval = d
+	}
+}
+
+// ValidateSubject checks that a subject is suitable for publishing: dot
+// separated, non-empty tokens without whitespace nor wildcards.
+func ValidateSubject(subject string) error {
+	if subject == "" {
+		return errors.New("subject cannot be empty")
+	}
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" {
+			return fmt.Errorf("invalid subject %q: empty token", subject)
+		}
+		if token == "*" || token == ">" {
+			return fmt.Errorf("invalid subject %q: wildcards cannot be used for publishing", subject)
+		}
+		if strings.ContainsAny(token, " \t\r\n") {
+			return fmt.Errorf("invalid subject %q: contains whitespace", subject)
+		}
+	}
+	return nil
+}
+
+// New validates the broker URL, of the form nats://[user[:password]@]host[:port]
+// or nats://token@host[:port], and the subject, and returns a Publisher. The
+// publisher does not connect until Start is called.
+func New(brokerURL, subject string, opts ...Option) (*Publisher, error) {
+	u, err := url.Parse(brokerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %v", err)
+	}
+	if u.Scheme != "nats" {
+		return nil, fmt.Errorf("invalid broker URL %s: scheme must be nats", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid broker URL: missing host")
+	}
+	port := DefaultPort
+	if u.Port() != "" {
+		if port, err = strconv.Atoi(u.Port()); err != nil || port <= 0 || port > 0xffff {
+			return nil, fmt.Errorf("invalid broker URL: invalid port %q", u.Port())
+		}
+	}
+	if err := ValidateSubject(subject); err != nil {
+		return nil, err
+	}
+	info := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "contest",
+		"lang":     "go",
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			info["user"], info["pass"] = u.User.Username(), pass
+		} else {
+			info["auth_token"] = u.User.Username()
+		}
+	}
+	connectInfo, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode connection options: %v", err)
+	}
+	p := Publisher{
+		addr:          net.JoinHostPort(u.Hostname(), strconv.Itoa(port)),
+		connectInfo:   connectInfo,
+		subject:       subject,
+		buf:           make(chan []byte, DefaultBufferSize),
+		timeout:       defaultTimeout,
+		retryInterval: defaultRetryInterval,
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return &p, nil
+}
+
+// TestEventEmitted queues an event for publishing. If the buffer is full, the
+// event is dropped.
+func (p *Publisher) TestEventEmitted(ev testevent.Event) {
+	msg, err := json.Marshal(ev)
+	if err != nil {
+		log.Warningf("Could not encode event %v: %v", ev, err)
+		atomic.AddUint64(&p.dropped, 1)
+		return
+	}
+	select {
+	case p.buf <- msg:
+	default:
+		if dropped := atomic.AddUint64(&p.dropped, 1); dropped == 1 || dropped%1000 == 0 {
+			log.Warningf("Event buffer full, %d events dropped so far", dropped)
+		}
+	}
+}
+
+// Dropped returns the number of events which could not be published.
+func (p *Publisher) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// Published returns the number of events acknowledged by the server.
+func (p *Publisher) Published() uint64 {
+	return atomic.LoadUint64(&p.published)
+}
+
+// Start starts publishing the queued events in the background.
+func (p *Publisher) Start() {
+	go p.run()
+}
+
+// Close stops the publisher, waiting up to timeout for the queued events to be
+// published.
+func (p *Publisher) Close(timeout time.Duration) error {
+	close(p.done)
+	select {
+	case <-p.stopped:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out publishing the %d queued events", len(p.buf))
+	}
+}
+
+// next returns the next batch of events, or nil if the publisher is closed and
+// the buffer is empty.
+func (p *Publisher) next() [][]byte {
+	var batch [][]byte
+	select {
+	case msg := <-p.buf:
+		batch = append(batch, msg)
+	default:
+		select {
+		case msg := <-p.buf:
+			batch = append(batch, msg)
+		case <-p.done:
+			return nil
+		}
+	}
+	for len(batch) < maxBatchSize {
+		select {
+		case msg := <-p.buf:
+			batch = append(batch, msg)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (p *Publisher) run() {
+	defer close(p.stopped)
+	var (
+		c     *conn
+		batch [][]byte
+		err   error
+	)
+	defer func() {
+		if c != nil {
+			_ = c.Close()
+		}
+	}()
+	for {
+		if batch == nil {
+			if batch = p.next(); batch == nil {
+				return
+			}
+		}
+		if c == nil {
+			c, err = p.connect()
+		}
+		if err == nil {
+			if err = c.publish(p.subject, batch, p.timeout); err == nil {
+				atomic.AddUint64(&p.published, uint64(len(batch)))
+				batch = nil
+				continue
+			}
+			_ = c.Close()
+			c = nil
+		}
+		log.Warningf("Could not publish %d events to %s: %v", len(batch), p.addr, err)
+		select {
+		case <-time.After(p.retryInterval):
+		case <-p.done:
+			dropped := uint64(len(batch) + len(p.buf))
+			atomic.AddUint64(&p.dropped, dropped)
+			log.Warningf("Publisher closed, dropping %d unpublished events", dropped)
+			return
+		}
+	}
+}
+
+// conn is a connection to a NATS server.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (p *Publisher) connect() (*conn, error) {
+	nc, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if err := c.handshake(p.connectInfo, p.timeout); err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *conn) handshake(connectInfo []byte, timeout time.Duration) error {
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return fmt.Errorf("could not read server info: %v", err)
+	}
+	if !strings.HasPrefix(line, "INFO") {
+		return fmt.Errorf("unexpected server greeting %q", line)
+	}
+	if _, err := fmt.Fprintf(c.w, "CONNECT %s\r\nPING\r\n", connectInfo); err != nil {
+		return err
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	return c.waitPong()
+}
+
+// publish sends the messages, and waits for the server to acknowledge them.
+func (c *conn) publish(subject string, msgs [][]byte, timeout time.Duration) error {
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		if _, err := fmt.Fprintf(c.w, "PUB %s %d\r\n", subject, len(msg)); err != nil {
+			return err
+		}
+		if _, err := c.w.Write(msg); err != nil {
+			return err
+		}
+		if _, err := c.w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	if _, err := c.w.WriteString("PING\r\n"); err != nil {
+		return err
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	return c.waitPong()
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			return "", errors.New("connection closed by server")
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// waitPong reads the server messages until a PONG is received.
+func (c *conn) waitPong() error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := c.w.WriteString("PONG\r\n"); err != nil {
+				return err
+			}
+			if err := c.w.Flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and INFO messages are ignored
+	}
+}
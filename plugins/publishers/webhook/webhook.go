@@ -0,0 +1,301 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package webhook
+
+// Package webhook posts the state transitions of the jobs to an HTTP endpoint,
+// independently of the reporters configured for the jobs. Each transition is
+// POSTed as a small JSON document, whose body is signed with HMAC-SHA256 in
+// the X-Contest-Signature header, as "sha256=<hex digest>", so that the
+// receiver can authenticate it.
+//
+// Transitions are queued in a bounded buffer, and delivered one at a time and
+// in order by a background goroutine. Failed deliveries, i.e. network errors
+// and 408, 429 and 5xx responses, are retried with an exponential backoff,
+// after which the transition is dropped. When the buffer is full, new
+// transitions are dropped and counted.
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/jobmanager"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/runner"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+var log = logging.GetLogger("publishers/webhook")
+
+const (
+	// SignatureHeader is the header carrying the signature of the body.
+	SignatureHeader = "X-Contest-Signature"
+	// MinSecretLength is the minimum length of the signing secret, in bytes.
+	MinSecretLength = 16
+	// DefaultBufferSize is the default number of transitions which can be
+	// queued while waiting to be delivered.
+	DefaultBufferSize = 1024
+	// DefaultRetries is the default number of times a delivery is retried.
+	DefaultRetries = 3
+
+	defaultTimeout       = 10 * time.Second
+	defaultRetryInterval = time.Second
+)
+
+// Transition is the body posted for each state transition of a job.
+type Transition struct {
+	JobID types.JobID
+	State jobmanager.JobState
+	Time  time.Time
+	Error string `json:",omitempty"`
+}
+
+// Webhook posts the job state transitions to a URL. It implements the
+// jobmanager.JobStateObserver interface, and the storage.TestEventSubscriber
+// interface to observe the jobs pausing at and resuming from breakpoints.
+type Webhook struct {
+	// counters are accessed atomically, keep them first for alignment.
+	dropped   uint64
+	delivered uint64
+
+	url           string
+	secret        []byte
+	client        *http.Client
+	buf           chan []byte
+	retries       int
+	retryInterval time.Duration
+
+	done    chan struct{}
+	stopped chan struct{}
+	// ctx is cancelled to abort the pending deliveries.
+	ctx   context.Context
+	abort context.CancelFunc
+}
+
+// Option is used to configure a Webhook.
+type Option func(*Webhook)
+
+// OptionBufferSize sets the number of transitions which can be queued.
+func OptionBufferSize(size int) Option {
+	return func(w *Webhook) {
+		if size > 0 {
+			w.buf = make(chan []byte, size)
+		}
+	}
+}
+
+// OptionTimeout sets the timeout of each request.
+func OptionTimeout(d time.Duration) Option {
+	return func(w *Webhook) {
+		w.client.Timeout = d
+	}
+}
+
+// OptionRetries sets the number of times a failed delivery is retried.
+func OptionRetries(n int) Option {
+	return func(w *Webhook) {
+		if n >= 0 {
+			w.retries = n
+		}
+	}
+}
+
+// OptionRetryInterval sets the interval before the first retry, which doubles
+// on each subsequent retry.
+func OptionRetryInterval(d time.Duration) Option {
+	return func(w *Webhook) {
+		w.retryInterval = d
+	}
+}
+
+// New validates the URL, which must be an http or https URL, and the signing
+// secret, and returns a Webhook. Transitions are not delivered until Start is
+// called.
+func New(rawURL, secret string, opts ...Option) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid webhook URL: scheme must be http or https, got %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid webhook URL: missing host")
+	}
+	if len(secret) < MinSecretLength {
+		return nil, fmt.Errorf("invalid webhook secret: must be at least %d bytes long", MinSecretLength)
+	}
+	w := Webhook{
+		url:           u.String(),
+		secret:        []byte(secret),
+		client:        &http.Client{Timeout: defaultTimeout},
+		buf:           make(chan []byte, DefaultBufferSize),
+		retries:       DefaultRetries,
+		retryInterval: defaultRetryInterval,
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	w.ctx, w.abort = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(&w)
+	}
+	return &w, nil
+}
+
+// Sign returns the signature of a body, as set in the SignatureHeader.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// JobStateChanged queues a job state transition for delivery. If the buffer
+// is full, the transition is dropped.
+func (w *Webhook) JobStateChanged(jobID types.JobID, state jobmanager.JobState, err error) {
+	t := Transition{JobID: jobID, State: state, Time: time.Now()}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	body, err := json.Marshal(t)
+	if err != nil {
+		log.Warningf("Could not encode transition %+v: %v", t, err)
+		atomic.AddUint64(&w.dropped, 1)
+		return
+	}
+	select {
+	case w.buf <- body:
+	default:
+		if dropped := atomic.AddUint64(&w.dropped, 1); dropped == 1 || dropped%100 == 0 {
+			log.Warningf("Transition buffer full, %d transitions dropped so far", dropped)
+		}
+	}
+}
+
+// TestEventEmitted translates the breakpoint events of the test runner into
+// the paused and running states. Other events are ignored.
+func (w *Webhook) TestEventEmitted(ev testevent.Event) {
+	if ev.Header == nil || ev.Data == nil {
+		return
+	}
+	switch ev.Data.EventName {
+	case runner.EventJobPausedAtBreakpoint:
+		w.JobStateChanged(ev.Header.JobID, jobmanager.JobStatePaused, nil)
+	case runner.EventJobResumedFromBreakpoint:
+		w.JobStateChanged(ev.Header.JobID, jobmanager.JobStateRunning, nil)
+	}
+}
+
+// Dropped returns the number of transitions which could not be delivered.
+func (w *Webhook) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Delivered returns the number of transitions accepted by the endpoint.
+func (w *Webhook) Delivered() uint64 {
+	return atomic.LoadUint64(&w.delivered)
+}
+
+// Start starts delivering the queued transitions in the background.
+func (w *Webhook) Start() {
+	go w.run()
+}
+
+// Close stops the webhook, waiting up to timeout for the queued transitions to
+// be delivered. The pending retries are abandoned after the timeout.
+func (w *Webhook) Close(timeout time.Duration) error {
+	close(w.done)
+	select {
+	case <-w.stopped:
+		return nil
+	case <-time.After(timeout):
+		w.abort()
+		return fmt.Errorf("timed out delivering the %d queued transitions", len(w.buf))
+	}
+}
+
+func (w *Webhook) run() {
+	defer close(w.stopped)
+	for {
+		if w.ctx.Err() != nil {
+			dropped := uint64(len(w.buf))
+			atomic.AddUint64(&w.dropped, dropped)
+			log.Warningf("Webhook closed, dropping %d undelivered transitions", dropped)
+			return
+		}
+		var body []byte
+		select {
+		case body = <-w.buf:
+		default:
+			select {
+			case body = <-w.buf:
+			case <-w.done:
+				return
+			}
+		}
+		if err := w.deliver(body); err != nil {
+			atomic.AddUint64(&w.dropped, 1)
+			log.Warningf("Could not deliver transition %s to %s, dropping it: %v", body, w.url, err)
+			continue
+		}
+		atomic.AddUint64(&w.delivered, 1)
+	}
+}
+
+// deliver posts a body, retrying failed attempts until the webhook is
+// aborted.
+func (w *Webhook) deliver(body []byte) error {
+	interval := w.retryInterval
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(body)
+		if err == nil || !retry || attempt >= w.retries {
+			return err
+		}
+		log.Debugf("Delivery attempt %d to %s failed, retrying in %s: %v", attempt+1, w.url, interval, err)
+		select {
+		case <-time.After(interval):
+		case <-w.ctx.Done():
+			return fmt.Errorf("webhook closed after %d attempts: %v", attempt+1, err)
+		}
+		interval *= 2
+	}
+}
+
+// post sends a body once, and tells whether a failure can be retried.
+func (w *Webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(w.ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(w.secret, body))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	// drain the body so that the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
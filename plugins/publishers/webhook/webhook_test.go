@@ -0,0 +1,128 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/jobmanager"
+	"github.com/facebookincubator/contest/pkg/runner"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "0123456789abcdef"
+
+// receiver records the transitions posted to it, answering the first
+// failures requests with a 503.
+type receiver struct {
+	t        *testing.T
+	failures int
+
+	mu          sync.Mutex
+	requests    int
+	transitions []Transition
+}
+
+func (r *receiver) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	require.NoError(r.t, err)
+	require.Equal(r.t, http.MethodPost, req.Method)
+	require.Equal(r.t, Sign([]byte(testSecret), body), req.Header.Get(SignatureHeader))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	if r.requests <= r.failures {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var tr Transition
+	require.NoError(r.t, json.Unmarshal(body, &tr))
+	r.transitions = append(r.transitions, tr)
+}
+
+func (r *receiver) states() []jobmanager.JobState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var states []jobmanager.JobState
+	for _, tr := range r.transitions {
+		states = append(states, tr.State)
+	}
+	return states
+}
+
+func TestDeliversInOrder(t *testing.T) {
+	r := receiver{t: t, failures: 2}
+	srv := httptest.NewServer(&r)
+	defer srv.Close()
+	w, err := New(srv.URL, testSecret, OptionRetryInterval(time.Millisecond))
+	require.NoError(t, err)
+	w.Start()
+
+	w.JobStateChanged("1", jobmanager.JobStateQueued, nil)
+	w.JobStateChanged("1", jobmanager.JobStateRunning, nil)
+	w.TestEventEmitted(testevent.Event{
+		Header: &testevent.Header{JobID: "1"},
+		Data:   &testevent.Data{EventName: runner.EventJobPausedAtBreakpoint},
+	})
+	w.TestEventEmitted(testevent.Event{
+		Header: &testevent.Header{JobID: "1"},
+		Data:   &testevent.Data{EventName: event.Name("TargetIn")},
+	})
+	w.TestEventEmitted(testevent.Event{
+		Header: &testevent.Header{JobID: "1"},
+		Data:   &testevent.Data{EventName: runner.EventJobResumedFromBreakpoint},
+	})
+	w.JobStateChanged("1", jobmanager.JobStateFailed, errors.New("boom"))
+	require.NoError(t, w.Close(5*time.Second))
+
+	require.Equal(t, []jobmanager.JobState{
+		jobmanager.JobStateQueued,
+		jobmanager.JobStateRunning,
+		jobmanager.JobStatePaused,
+		jobmanager.JobStateRunning,
+		jobmanager.JobStateFailed,
+	}, r.states())
+	require.Equal(t, "boom", r.transitions[4].Error)
+	require.Equal(t, uint64(5), w.Delivered())
+	require.Equal(t, uint64(0), w.Dropped())
+}
+
+func TestDropsAfterRetries(t *testing.T) {
+	r := receiver{t: t, failures: 100}
+	srv := httptest.NewServer(&r)
+	defer srv.Close()
+	w, err := New(srv.URL, testSecret, OptionRetries(2), OptionRetryInterval(time.Millisecond))
+	require.NoError(t, err)
+	w.Start()
+	w.JobStateChanged("1", jobmanager.JobStateRunning, nil)
+	require.NoError(t, w.Close(5*time.Second))
+
+	require.Equal(t, 3, r.requests)
+	require.Equal(t, uint64(0), w.Delivered())
+	require.Equal(t, uint64(1), w.Dropped())
+}
+
+func TestValidation(t *testing.T) {
+	for _, u := range []string{"", "localhost:8080", "ftp://example.com/hook", "http:///hook", "http://[::1"} {
+		_, err := New(u, testSecret)
+		require.Error(t, err, u)
+	}
+	_, err := New("https://example.com/hook", "")
+	require.Error(t, err)
+	_, err = New("https://example.com/hook", "short")
+	require.Error(t, err)
+	_, err = New("https://example.com/hook", testSecret)
+	require.NoError(t, err)
+}
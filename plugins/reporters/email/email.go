@@ -0,0 +1,327 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package email
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/plugins/reporters"
+)
+
+// Name defines the name of the reporter used within the plugin registry
+var Name = "Email"
+
+var log = logging.GetLogger("reporters/" + strings.ToLower(Name))
+
+const (
+	defaultTimeout = 30 * time.Second
+	defaultRetries = 3
+	// retryInterval is the wait before the first retry, doubled at every
+	// subsequent attempt.
+	retryInterval = 5 * time.Second
+)
+
+const defaultSubject = `[ConTest] Job {{.JobID}} {{if .Success}}passed{{else}}failed{{end}}`
+
+const defaultTemplate = `<html><body>
+<p>Job {{.JobID}} {{if .Success}}passed{{else}}failed{{end}}: {{.Passed}} targets passed and {{.Failed}} failed in {{.Runs}} runs.</p>
+{{if .Links.Verdict}}<p><a href="{{.Links.Verdict}}">Verdict</a> - <a href="{{.Links.Artifacts}}">Artifacts</a></p>{{end}}
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Run</th><th>Test</th><th>Target</th><th>Result</th><th>Error</th></tr>
+{{range .Targets}}<tr><td>{{.RunID}}</td><td>{{.Test}}</td><td>{{.Target}}</td><td>{{if .Passed}}pass{{else}}fail{{end}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+</body></html>
+`
+
+// Parameters contains the parameters of the final reporter.
+type Parameters struct {
+	// Server is the address of the SMTP server, as host:port. STARTTLS is
+	// used if the server supports it.
+	Server string
+	// TLS connects to the server over TLS, e.g. on port 465.
+	TLS  bool
+	From string
+	To   []string
+	// Subject is a Go text/template and Template a Go html/template, which
+	// render the subject and the body of the email from the reporters.Summary
+	// of the job.
+	Subject  string
+	Template string
+	// BaseURL is the address of the HTTP API of the server, which the links
+	// of the summary point to.
+	BaseURL string
+	// Username and Password authenticate to the server, if set. The password
+	// must be a secret reference, e.g. ${env:SMTP_PASSWORD}.
+	Username string
+	Password string
+	// Timeout of each attempt at sending the email, as a Go duration string.
+	Timeout string
+	// Retries is the number of times sending is retried on transient
+	// failures.
+	Retries *int
+}
+
+// validatedParameters holds the parsed Parameters.
+type validatedParameters struct {
+	server  string
+	host    string
+	tls     bool
+	from    string
+	to      []string
+	subject *texttemplate.Template
+	body    *template.Template
+	baseURL string
+	auth    smtp.Auth
+	timeout time.Duration
+	retries int
+}
+
+// Email is a reporter which mails an HTML summary of a job. The email is sent
+// asynchronously, so it never delays the completion of the job, and failures
+// are only logged.
+type Email struct {
+}
+
+// ValidateRunParameters rejects run reporting, which is not supported
+func (e *Email) ValidateRunParameters(params []byte) (interface{}, error) {
+	return nil, fmt.Errorf("%s is only a final reporter", Name)
+}
+
+// ValidateFinalParameters validates the parameters for the final reporter
+func (e *Email) ValidateFinalParameters(params []byte) (interface{}, error) {
+	var p Parameters
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(p.Server)
+	if err != nil || host == "" {
+		return nil, fmt.Errorf("invalid Server %q: must be host:port", p.Server)
+	}
+	vp := validatedParameters{server: p.Server, host: host, tls: p.TLS, baseURL: p.BaseURL, timeout: defaultTimeout, retries: defaultRetries}
+	from, err := mail.ParseAddress(p.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid From %q: %v", p.From, err)
+	}
+	vp.from = from.Address
+	if len(p.To) == 0 {
+		return nil, errors.New("missing To")
+	}
+	for _, to := range p.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid To %q: %v", to, err)
+		}
+		vp.to = append(vp.to, addr.Address)
+	}
+	if err := reporters.ValidateBaseURL(p.BaseURL); err != nil {
+		return nil, err
+	}
+	subject, body := p.Subject, p.Template
+	if subject == "" {
+		subject = defaultSubject
+	}
+	if body == "" {
+		body = defaultTemplate
+	}
+	if vp.subject, err = texttemplate.New("subject").Parse(subject); err != nil {
+		return nil, fmt.Errorf("invalid Subject: %v", err)
+	}
+	if vp.body, err = template.New("body").Parse(body); err != nil {
+		return nil, fmt.Errorf("invalid Template: %v", err)
+	}
+	if p.Username != "" {
+		if secrets.Mask(p.Password, "") == p.Password {
+			return nil, errors.New("invalid Password: must be a secret reference, e.g. ${env:SMTP_PASSWORD}")
+		}
+		password, err := secrets.Expand(p.Password)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Password: %v", err)
+		}
+		vp.auth = smtp.PlainAuth("", p.Username, password, host)
+	}
+	if p.Timeout != "" {
+		if vp.timeout, err = time.ParseDuration(p.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid Timeout: %v", err)
+		}
+		if vp.timeout <= 0 {
+			return nil, fmt.Errorf("invalid Timeout: must be positive")
+		}
+	}
+	if p.Retries != nil {
+		if *p.Retries < 0 {
+			return nil, fmt.Errorf("invalid Retries: cannot be negative")
+		}
+		vp.retries = *p.Retries
+	}
+	return vp, nil
+}
+
+// Name returns the Name of the reporter
+func (e *Email) Name() string {
+	return Name
+}
+
+// message renders the email.
+func message(vp validatedParameters, summary reporters.Summary) ([]byte, error) {
+	var subject, body bytes.Buffer
+	if err := vp.subject.Execute(&subject, summary); err != nil {
+		return nil, fmt.Errorf("could not render the subject: %v", err)
+	}
+	if err := vp.body.Execute(&body, summary); err != nil {
+		return nil, fmt.Errorf("could not render the body: %v", err)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", vp.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(vp.to, ", "))
+	// line breaks in the subject would start new headers
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(subject.String()), " ")))
+	fmt.Fprintf(&msg, "Date: %s\r\n", summary.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=\"utf-8\"\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write(body.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// deliver sends the message once.
+func deliver(vp validatedParameters, msg []byte) error {
+	dialer := &net.Dialer{Timeout: vp.timeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if vp.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", vp.server, &tls.Config{ServerName: vp.host})
+	} else {
+		conn, err = dialer.Dial("tcp", vp.server)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(vp.timeout)); err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, vp.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && !vp.tls {
+		if err := c.StartTLS(&tls.Config{ServerName: vp.host}); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+	if vp.auth != nil {
+		if err := c.Auth(vp.auth); err != nil {
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+	}
+	if err := c.Mail(vp.from); err != nil {
+		return err
+	}
+	for _, to := range vp.to {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %v", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// permanent tells whether the server rejected the email for good.
+func permanent(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500
+}
+
+// send sends the message, retrying transient failures with exponential
+// backoff until the retries are exhausted or cancel is closed.
+func send(cancel <-chan struct{}, vp validatedParameters, msg []byte) error {
+	wait := retryInterval
+	for attempt := 0; ; attempt++ {
+		err := deliver(vp, msg)
+		if err == nil || permanent(err) || attempt >= vp.retries {
+			return err
+		}
+		log.Warningf("Transient failure sending email through %s (attempt %d of %d): %v", vp.server, attempt+1, vp.retries+1, err)
+		select {
+		case <-cancel:
+			return fmt.Errorf("cancelled while retrying: %v", err)
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// RunReport is not supported, see ValidateRunParameters.
+func (e *Email) RunReport(cancel <-chan struct{}, parameters interface{}, runStatus *job.RunStatus, ev testevent.Fetcher) (bool, interface{}, error) {
+	return false, nil, fmt.Errorf("%s is only a final reporter", Name)
+}
+
+// FinalReport mails the summary of the whole job.
+func (e *Email) FinalReport(cancel <-chan struct{}, parameters interface{}, runStatuses []job.RunStatus, ev testevent.Fetcher) (bool, interface{}, error) {
+	vp, ok := parameters.(validatedParameters)
+	if !ok {
+		return false, nil, fmt.Errorf("report parameters should be of type validatedParameters")
+	}
+	summary := reporters.NewSummary(runStatuses, vp.baseURL, time.Now())
+	msg, err := message(vp, summary)
+	if err != nil {
+		return false, nil, err
+	}
+	go func() {
+		if err := send(cancel, vp, msg); err != nil {
+			log.Errorf("Failed to email the summary of job %s to %s: %v", summary.JobID, strings.Join(vp.to, ", "), err)
+			return
+		}
+		log.Infof("Emailed the summary of job %s to %s", summary.JobID, strings.Join(vp.to, ", "))
+	}()
+	return summary.Success, fmt.Sprintf("The summary of %d runs is being emailed to %s", len(runStatuses), strings.Join(vp.to, ", ")), nil
+}
+
+// New builds a new Email reporter
+func New() job.Reporter {
+	return &Email{}
+}
+
+// Load returns the name and factory which are needed to register the Reporter
+func Load() (string, job.ReporterFactory) {
+	return Name, New
+}
@@ -0,0 +1,66 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package email
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFinalParameters(t *testing.T) {
+	require.NoError(t, os.Setenv("EMAIL_TEST_PASSWORD", "password"))
+	defer os.Unsetenv("EMAIL_TEST_PASSWORD")
+	for _, tc := range []struct {
+		name   string
+		params string
+		valid  bool
+	}{
+		{"minimal", `{"Server": "smtp.example.com:25", "From": "contest@example.com", "To": ["team@example.com"]}`, true},
+		{"complete", `{"Server": "smtp.example.com:465", "TLS": true, "From": "ConTest <contest@example.com>", "To": ["a@example.com", "B <b@example.com>"], "Subject": "{{.JobID}}", "Template": "<p>{{.JobID}}</p>", "BaseURL": "https://contest.example.com", "Username": "contest", "Password": "${env:EMAIL_TEST_PASSWORD}", "Timeout": "10s", "Retries": 0}`, true},
+		{"not JSON", `[`, false},
+		{"missing server", `{"From": "contest@example.com", "To": ["team@example.com"]}`, false},
+		{"server without port", `{"Server": "smtp.example.com", "From": "contest@example.com", "To": ["team@example.com"]}`, false},
+		{"invalid from", `{"Server": "smtp.example.com:25", "From": "contest", "To": ["team@example.com"]}`, false},
+		{"missing to", `{"Server": "smtp.example.com:25", "From": "contest@example.com"}`, false},
+		{"invalid to", `{"Server": "smtp.example.com:25", "From": "contest@example.com", "To": ["team"]}`, false},
+		{"invalid base URL", `{"Server": "smtp.example.com:25", "From": "contest@example.com", "To": ["team@example.com"], "BaseURL": "contest"}`, false},
+		{"invalid subject", `{"Server": "smtp.example.com:25", "From": "contest@example.com", "To": ["team@example.com"], "Subject": "{{.JobID"}`, false},
+		{"invalid template", `{"Server": "smtp.example.com:25", "From": "contest@example.com", "To": ["team@example.com"], "Template": "{{.JobID"}`, false},
+		{"plain password", `{"Server": "smtp.example.com:25", "From": "contest@example.com", "To": ["team@example.com"], "Username": "contest", "Password": "password"}`, false},
+		{"unset password", `{"Server": "smtp.example.com:25", "From": "contest@example.com", "To": ["team@example.com"], "Username": "contest", "Password": "${env:EMAIL_TEST_UNSET}"}`, false},
+		{"invalid timeout", `{"Server": "smtp.example.com:25", "From": "contest@example.com", "To": ["team@example.com"], "Timeout": "soon"}`, false},
+		{"zero timeout", `{"Server": "smtp.example.com:25", "From": "contest@example.com", "To": ["team@example.com"], "Timeout": "0s"}`, false},
+		{"negative retries", `{"Server": "smtp.example.com:25", "From": "contest@example.com", "To": ["team@example.com"], "Retries": -1}`, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New().ValidateFinalParameters([]byte(tc.params))
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateFinalParametersAddresses(t *testing.T) {
+	params, err := New().ValidateFinalParameters([]byte(`{"Server": "smtp.example.com:25", "From": "ConTest <contest@example.com>", "To": ["A <a@example.com>", "b@example.com"]}`))
+	require.NoError(t, err)
+	vp := params.(validatedParameters)
+	require.Equal(t, "smtp.example.com", vp.host)
+	require.Equal(t, "contest@example.com", vp.from)
+	require.Equal(t, []string{"a@example.com", "b@example.com"}, vp.to)
+	require.Nil(t, vp.auth)
+	require.Equal(t, defaultTimeout, vp.timeout)
+	require.Equal(t, defaultRetries, vp.retries)
+}
+
+func TestValidateRunParameters(t *testing.T) {
+	_, err := New().ValidateRunParameters([]byte(`{"Server": "smtp.example.com:25"}`))
+	require.Error(t, err)
+}
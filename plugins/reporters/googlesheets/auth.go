@@ -0,0 +1,178 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package googlesheets
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTokenURL = "https://oauth2.googleapis.com/token"
+	scope           = "https://www.googleapis.com/auth/spreadsheets"
+	// tokenLifetime is the lifetime requested for the access tokens, the
+	// maximum accepted by Google.
+	tokenLifetime = time.Hour
+)
+
+// serviceAccount holds the fields of a service account key file which are
+// needed to obtain access tokens.
+type serviceAccount struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	key *rsa.PrivateKey
+}
+
+// parseServiceAccount parses a service account key file, as downloaded from
+// the Google Cloud console.
+func parseServiceAccount(data []byte) (*serviceAccount, error) {
+	var sa serviceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return nil, fmt.Errorf("cannot decode service account key: %v", err)
+	}
+	if sa.Type != "service_account" {
+		return nil, fmt.Errorf("invalid service account key: type is %q, expected service_account", sa.Type)
+	}
+	if sa.ClientEmail == "" || sa.PrivateKey == "" {
+		return nil, errors.New("invalid service account key: client_email and private_key are required")
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = defaultTokenURL
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid service account key: private_key is not PEM-encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("invalid service account key: cannot parse private_key: %v", err)
+		}
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("invalid service account key: private_key is not an RSA key")
+	}
+	sa.key = rsaKey
+	return &sa, nil
+}
+
+// assertion returns the signed JWT exchanged for an access token, see
+// https://developers.google.com/identity/protocols/oauth2/service-account.
+func (sa *serviceAccount) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": scope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(tokenLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, sa.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("cannot sign token request: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// token obtains an access token for the service account.
+func (sa *serviceAccount) token(client *http.Client) (string, error) {
+	assertion, err := sa.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequest(http.MethodPost, sa.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := do(client, req)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("cannot decode token response: %v", err)
+	}
+	if resp.AccessToken == "" {
+		return "", errors.New("token response has no access_token")
+	}
+	return resp.AccessToken, nil
+}
+
+// errTransient marks failures which are worth retrying. retryAfter is the
+// wait requested by the server, if any.
+type errTransient struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e errTransient) Error() string {
+	return e.err.Error()
+}
+
+func (e errTransient) Unwrap() error {
+	return e.err
+}
+
+// do performs a request and returns the body of a successful response.
+func do(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errTransient{err: err}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, errTransient{err: fmt.Errorf("cannot read response: %v", err)}
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return body, nil
+	}
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	err = fmt.Errorf("%s %s returned %s: %s", req.Method, req.URL.Host+req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		transient := errTransient{err: err}
+		if s, err := time.ParseDuration(resp.Header.Get("Retry-After") + "s"); err == nil && s > 0 {
+			transient.retryAfter = s
+		}
+		return nil, transient
+	}
+	return nil, err
+}
@@ -0,0 +1,335 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package googlesheets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/secrets"
+)
+
+// Name defines the name of the reporter used within the plugin registry
+var Name = "GoogleSheets"
+
+var log = logging.GetLogger("reporters/" + strings.ToLower(Name))
+
+const (
+	// ModeTarget appends a row per target and test.
+	ModeTarget = "target"
+	// ModeSummary appends a row per run, and a row per job for the final
+	// report.
+	ModeSummary = "summary"
+
+	defaultEndpoint = "https://sheets.googleapis.com"
+	defaultSheet    = "Sheet1"
+	defaultTimeout  = 10 * time.Second
+	defaultRetries  = 5
+	// retryInterval is the wait before the first retry, doubled at every
+	// subsequent attempt, unless the API asks for a longer wait.
+	retryInterval = 2 * time.Second
+	// maxResponseSize bounds the size of the API responses.
+	maxResponseSize = 1024 * 1024
+)
+
+var spreadsheetIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{20,128}$`)
+
+// Parameters contains the parameters of both the run and the final reporter.
+type Parameters struct {
+	SpreadsheetID string
+	// Sheet is the name of the sheet the rows are appended to.
+	Sheet string
+	// Credentials is a secret reference, e.g. ${file:/etc/contest/sa.json},
+	// to the key of the service account writing to the spreadsheet.
+	Credentials string
+	// Mode is ModeTarget or ModeSummary.
+	Mode string
+	// Endpoint overrides the address of the Sheets API.
+	Endpoint string
+	// Timeout of each request to the API, as a Go duration string.
+	Timeout string
+	// Retries is the number of times a request is retried on rate limits and
+	// transient failures.
+	Retries *int
+}
+
+// validatedParameters holds the parsed Parameters.
+type validatedParameters struct {
+	appendURL     string
+	spreadsheetID string
+	mode          string
+	account       *serviceAccount
+	timeout       time.Duration
+	retries       int
+}
+
+// GoogleSheets is a reporter which appends the results of a job to a Google
+// Sheet. Rows are appended asynchronously, so they never delay the completion
+// of the job, and failures are only logged.
+type GoogleSheets struct {
+}
+
+func validateParameters(params []byte) (interface{}, error) {
+	var p Parameters
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	if !spreadsheetIDRe.MatchString(p.SpreadsheetID) {
+		return nil, fmt.Errorf("invalid or missing SpreadsheetID %q", p.SpreadsheetID)
+	}
+	if p.Sheet == "" {
+		p.Sheet = defaultSheet
+	}
+	if strings.ContainsAny(p.Sheet, "[]*?:/\\") {
+		return nil, fmt.Errorf("invalid Sheet %q: cannot contain any of []*?:/\\", p.Sheet)
+	}
+	vp := validatedParameters{spreadsheetID: p.SpreadsheetID, timeout: defaultTimeout, retries: defaultRetries}
+	switch p.Mode {
+	case "", ModeTarget:
+		vp.mode = ModeTarget
+	case ModeSummary:
+		vp.mode = ModeSummary
+	default:
+		return nil, fmt.Errorf("invalid Mode %q: must be %s or %s", p.Mode, ModeTarget, ModeSummary)
+	}
+
+	// the key is loaded now, so that missing credentials fail the job
+	// submission rather than the report
+	if p.Credentials == "" {
+		return nil, errors.New("missing Credentials")
+	}
+	if secrets.Mask(p.Credentials, "") == p.Credentials {
+		return nil, errors.New("invalid Credentials: must be a secret reference, e.g. ${file:/path/to/key.json}")
+	}
+	key, err := secrets.Expand(p.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Credentials: %v", err)
+	}
+	if vp.account, err = parseServiceAccount([]byte(key)); err != nil {
+		return nil, fmt.Errorf("invalid Credentials: %v", err)
+	}
+
+	endpoint := defaultEndpoint
+	if p.Endpoint != "" {
+		u, err := url.Parse(p.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid Endpoint %q: must be a http or https URL", p.Endpoint)
+		}
+		endpoint = strings.TrimSuffix(p.Endpoint, "/")
+	}
+	// the sheet name is quoted, so that names with spaces are valid ranges
+	sheetRange := "'" + strings.Replace(p.Sheet, "'", "''", -1) + "'!A1"
+	vp.appendURL = fmt.Sprintf("%s/v4/spreadsheets/%s/values/%s:append?valueInputOption=RAW&insertDataOption=INSERT_ROWS",
+		endpoint, p.SpreadsheetID, url.PathEscape(sheetRange))
+
+	if p.Timeout != "" {
+		if vp.timeout, err = time.ParseDuration(p.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid Timeout: %v", err)
+		}
+		if vp.timeout <= 0 {
+			return nil, fmt.Errorf("invalid Timeout: must be positive")
+		}
+	}
+	if p.Retries != nil {
+		if *p.Retries < 0 {
+			return nil, fmt.Errorf("invalid Retries: cannot be negative")
+		}
+		vp.retries = *p.Retries
+	}
+	return vp, nil
+}
+
+// ValidateRunParameters validates the parameters for the run reporter
+func (gs *GoogleSheets) ValidateRunParameters(params []byte) (interface{}, error) {
+	return validateParameters(params)
+}
+
+// ValidateFinalParameters validates the parameters for the final reporter
+func (gs *GoogleSheets) ValidateFinalParameters(params []byte) (interface{}, error) {
+	return validateParameters(params)
+}
+
+// Name returns the Name of the reporter
+func (gs *GoogleSheets) Name() string {
+	return Name
+}
+
+// withRetries calls f, retrying transient failures with exponential backoff
+// until the retries are exhausted or cancel is closed.
+func withRetries(cancel <-chan struct{}, retries int, f func() error) error {
+	wait := retryInterval
+	for attempt := 0; ; attempt++ {
+		err := f()
+		var transient errTransient
+		if err == nil || !errors.As(err, &transient) || attempt >= retries {
+			return err
+		}
+		delay := wait
+		if transient.retryAfter > delay {
+			delay = transient.retryAfter
+		}
+		log.Warningf("Transient failure appending to a Google Sheet (attempt %d of %d), retrying in %s: %v", attempt+1, retries+1, delay, err)
+		select {
+		case <-cancel:
+			return fmt.Errorf("cancelled while retrying: %v", err)
+		case <-time.After(delay):
+		}
+		wait *= 2
+	}
+}
+
+// appendRows appends rows to the sheet.
+func appendRows(cancel <-chan struct{}, vp validatedParameters, rows [][]interface{}) error {
+	client := &http.Client{Timeout: vp.timeout}
+	var token string
+	err := withRetries(cancel, vp.retries, func() error {
+		var err error
+		token, err = vp.account.token(client)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not get access token: %v", err)
+	}
+	body, err := json.Marshal(map[string]interface{}{"values": rows})
+	if err != nil {
+		return err
+	}
+	err = withRetries(cancel, vp.retries, func() error {
+		req, err := http.NewRequest(http.MethodPost, vp.appendURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, err = do(client, req)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not append rows: %v", err)
+	}
+	return nil
+}
+
+func appendAsync(cancel <-chan struct{}, vp validatedParameters, rows [][]interface{}) {
+	go func() {
+		if err := appendRows(cancel, vp, rows); err != nil {
+			log.Errorf("Failed to append %d rows to spreadsheet %s: %v", len(rows), vp.spreadsheetID, err)
+			return
+		}
+		log.Infof("Appended %d rows to spreadsheet %s", len(rows), vp.spreadsheetID)
+	}()
+}
+
+// targetCounts returns the number of passed and failed targets of a run.
+func targetCounts(rs job.RunStatus) (passed, failed int) {
+	for _, ts := range rs.TestStatuses {
+		for _, t := range ts.TargetStatuses {
+			if t.Error != "" {
+				failed++
+			} else {
+				passed++
+			}
+		}
+	}
+	return passed, failed
+}
+
+// targetRows returns a row per target and test of the runs: job ID, run ID,
+// test, target, result, error and time.
+func targetRows(runStatuses []job.RunStatus, now time.Time) [][]interface{} {
+	rows := [][]interface{}{}
+	for _, rs := range runStatuses {
+		for _, ts := range rs.TestStatuses {
+			for _, t := range ts.TargetStatuses {
+				if t.Target == nil {
+					continue
+				}
+				result := "pass"
+				if t.Error != "" {
+					result = "fail"
+				}
+				rows = append(rows, []interface{}{rs.JobID.String(), uint64(rs.RunID), ts.TestName, t.Target.Name, result, t.Error, now.UTC().Format(time.RFC3339)})
+			}
+		}
+	}
+	return rows
+}
+
+// summaryRow returns a row with the job ID, the run, the numbers of passed
+// and failed targets and the time.
+func summaryRow(run interface{}, runStatuses []job.RunStatus, now time.Time) []interface{} {
+	var passed, failed int
+	jobID := ""
+	for _, rs := range runStatuses {
+		p, f := targetCounts(rs)
+		passed += p
+		failed += f
+		jobID = rs.JobID.String()
+	}
+	return []interface{}{jobID, run, passed, failed, now.UTC().Format(time.RFC3339)}
+}
+
+func success(runStatuses []job.RunStatus) bool {
+	for _, rs := range runStatuses {
+		if _, failed := targetCounts(rs); failed > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RunReport appends the results of a job run.
+func (gs *GoogleSheets) RunReport(cancel <-chan struct{}, parameters interface{}, runStatus *job.RunStatus, ev testevent.Fetcher) (bool, interface{}, error) {
+	vp, ok := parameters.(validatedParameters)
+	if !ok {
+		return false, nil, fmt.Errorf("report parameters should be of type validatedParameters")
+	}
+	runStatuses := []job.RunStatus{*runStatus}
+	rows := [][]interface{}{summaryRow(uint64(runStatus.RunID), runStatuses, time.Now())}
+	if vp.mode == ModeTarget {
+		rows = targetRows(runStatuses, time.Now())
+	}
+	if len(rows) > 0 {
+		appendAsync(cancel, vp, rows)
+	}
+	return success(runStatuses), fmt.Sprintf("Results of run %d are being appended to spreadsheet %s", runStatus.RunID, vp.spreadsheetID), nil
+}
+
+// FinalReport appends the results of the whole job.
+func (gs *GoogleSheets) FinalReport(cancel <-chan struct{}, parameters interface{}, runStatuses []job.RunStatus, ev testevent.Fetcher) (bool, interface{}, error) {
+	vp, ok := parameters.(validatedParameters)
+	if !ok {
+		return false, nil, fmt.Errorf("report parameters should be of type validatedParameters")
+	}
+	rows := [][]interface{}{summaryRow("all", runStatuses, time.Now())}
+	if vp.mode == ModeTarget {
+		rows = targetRows(runStatuses, time.Now())
+	}
+	if len(rows) > 0 {
+		appendAsync(cancel, vp, rows)
+	}
+	return success(runStatuses), fmt.Sprintf("Results of %d runs are being appended to spreadsheet %s", len(runStatuses), vp.spreadsheetID), nil
+}
+
+// New builds a new GoogleSheets reporter
+func New() job.Reporter {
+	return &GoogleSheets{}
+}
+
+// Load returns the name and factory which are needed to register the Reporter
+func Load() (string, job.ReporterFactory) {
+	return Name, New
+}
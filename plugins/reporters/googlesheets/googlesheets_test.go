@@ -0,0 +1,90 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package googlesheets
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const spreadsheetID = "1BxiMVs0XRA5nFMdKvBdBZjgmUUqptlbs74OgvE2upms"
+
+// writeKeyFile writes a service account key file in dir, and returns the
+// secret reference to it.
+func writeKeyFile(t *testing.T, dir, name string, account map[string]string) string {
+	data, err := json.Marshal(account)
+	require.NoError(t, err)
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	return "${file:" + path + "}"
+}
+
+func TestValidateParameters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "googlesheets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	privateKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	valid := writeKeyFile(t, dir, "valid.json", map[string]string{"type": "service_account", "client_email": "contest@example.iam.gserviceaccount.com", "private_key": privateKey})
+	user := writeKeyFile(t, dir, "user.json", map[string]string{"type": "authorized_user", "client_email": "contest@example.com", "private_key": privateKey})
+	notPEM := writeKeyFile(t, dir, "notpem.json", map[string]string{"type": "service_account", "client_email": "contest@example.iam.gserviceaccount.com", "private_key": "key"})
+
+	for _, tc := range []struct {
+		name   string
+		params string
+		valid  bool
+	}{
+		{"minimal", fmt.Sprintf(`{"SpreadsheetID": %q, "Credentials": %q}`, spreadsheetID, valid), true},
+		{"complete", fmt.Sprintf(`{"SpreadsheetID": %q, "Sheet": "Job results", "Credentials": %q, "Mode": "summary", "Endpoint": "http://localhost:8080/", "Timeout": "5s", "Retries": 0}`, spreadsheetID, valid), true},
+		{"not JSON", `[`, false},
+		{"missing spreadsheet", fmt.Sprintf(`{"Credentials": %q}`, valid), false},
+		{"invalid spreadsheet", fmt.Sprintf(`{"SpreadsheetID": "a/b", "Credentials": %q}`, valid), false},
+		{"invalid sheet", fmt.Sprintf(`{"SpreadsheetID": %q, "Sheet": "a:b", "Credentials": %q}`, spreadsheetID, valid), false},
+		{"invalid mode", fmt.Sprintf(`{"SpreadsheetID": %q, "Credentials": %q, "Mode": "rows"}`, spreadsheetID, valid), false},
+		{"missing credentials", fmt.Sprintf(`{"SpreadsheetID": %q}`, spreadsheetID), false},
+		{"plain credentials", fmt.Sprintf(`{"SpreadsheetID": %q, "Credentials": "{}"}`, spreadsheetID), false},
+		{"missing key file", fmt.Sprintf(`{"SpreadsheetID": %q, "Credentials": %q}`, spreadsheetID, "${file:"+filepath.Join(dir, "missing.json")+"}"), false},
+		{"not a service account", fmt.Sprintf(`{"SpreadsheetID": %q, "Credentials": %q}`, spreadsheetID, user), false},
+		{"key not PEM", fmt.Sprintf(`{"SpreadsheetID": %q, "Credentials": %q}`, spreadsheetID, notPEM), false},
+		{"invalid endpoint", fmt.Sprintf(`{"SpreadsheetID": %q, "Credentials": %q, "Endpoint": "localhost"}`, spreadsheetID, valid), false},
+		{"invalid timeout", fmt.Sprintf(`{"SpreadsheetID": %q, "Credentials": %q, "Timeout": "soon"}`, spreadsheetID, valid), false},
+		{"zero timeout", fmt.Sprintf(`{"SpreadsheetID": %q, "Credentials": %q, "Timeout": "0s"}`, spreadsheetID, valid), false},
+		{"negative retries", fmt.Sprintf(`{"SpreadsheetID": %q, "Credentials": %q, "Retries": -1}`, spreadsheetID, valid), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gs := New()
+			_, runErr := gs.ValidateRunParameters([]byte(tc.params))
+			_, finalErr := gs.ValidateFinalParameters([]byte(tc.params))
+			if tc.valid {
+				require.NoError(t, runErr)
+				require.NoError(t, finalErr)
+			} else {
+				require.Error(t, runErr)
+				require.Error(t, finalErr)
+			}
+		})
+	}
+
+	params, err := New().ValidateRunParameters([]byte(fmt.Sprintf(`{"SpreadsheetID": %q, "Sheet": "Job results", "Credentials": %q}`, spreadsheetID, valid)))
+	require.NoError(t, err)
+	vp := params.(validatedParameters)
+	require.Equal(t, defaultEndpoint+"/v4/spreadsheets/"+spreadsheetID+"/values/%27Job%20results%27%21A1:append?valueInputOption=RAW&insertDataOption=INSERT_ROWS", vp.appendURL)
+	require.Equal(t, ModeTarget, vp.mode)
+	require.Equal(t, defaultTokenURL, vp.account.TokenURI)
+	require.Equal(t, defaultTimeout, vp.timeout)
+	require.Equal(t, defaultRetries, vp.retries)
+}
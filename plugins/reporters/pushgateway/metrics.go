@@ -0,0 +1,174 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package pushgateway
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/job"
+)
+
+// metric is a single sample, in the Prometheus text exposition format.
+type metric struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels map[string]string
+	Value  float64
+}
+
+// metrics is a set of samples to be pushed to a Pushgateway.
+type metrics []metric
+
+func (m *metrics) add(name, help, typ string, value float64, labels ...string) {
+	l := make(map[string]string, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		l[labels[i]] = labels[i+1]
+	}
+	*m = append(*m, metric{Name: name, Help: help, Type: typ, Labels: l, Value: value})
+}
+
+// escapeLabelValue escapes a label value as required by the text format.
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(v)
+}
+
+// Encode serializes the metrics in the text exposition format, grouping the
+// samples of each metric family under its HELP and TYPE lines.
+func (m metrics) Encode() []byte {
+	var (
+		sb       strings.Builder
+		families []string
+		samples  = make(map[string][]metric)
+	)
+	for _, s := range m {
+		if _, ok := samples[s.Name]; !ok {
+			families = append(families, s.Name)
+		}
+		samples[s.Name] = append(samples[s.Name], s)
+	}
+	for _, name := range families {
+		first := samples[name][0]
+		fmt.Fprintf(&sb, "# HELP %s %s\n", name, first.Help)
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", name, first.Type)
+		for _, s := range samples[name] {
+			sb.WriteString(name)
+			if len(s.Labels) > 0 {
+				keys := make([]string, 0, len(s.Labels))
+				for k := range s.Labels {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				pairs := make([]string, 0, len(keys))
+				for _, k := range keys {
+					pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, escapeLabelValue(s.Labels[k])))
+				}
+				sb.WriteString("{" + strings.Join(pairs, ",") + "}")
+			}
+			sb.WriteString(" " + strconv.FormatFloat(s.Value, 'g', -1, 64) + "\n")
+		}
+	}
+	return []byte(sb.String())
+}
+
+// targetCounts returns the number of passed and failed targets of a test.
+func targetCounts(ts job.TestStatus) (passed, failed int) {
+	for _, t := range ts.TargetStatuses {
+		if t.Error != "" {
+			failed++
+		} else {
+			passed++
+		}
+	}
+	return passed, failed
+}
+
+// stepDuration returns the time elapsed between the first target entering
+// and the last target leaving a test step, or false if it's not known.
+func stepDuration(ss job.TestStepStatus) (time.Duration, bool) {
+	var in, out time.Time
+	for _, t := range ss.TargetStatuses {
+		if t.InTime.IsZero() || t.OutTime.IsZero() {
+			continue
+		}
+		if in.IsZero() || t.InTime.Before(in) {
+			in = t.InTime
+		}
+		if t.OutTime.After(out) {
+			out = t.OutTime
+		}
+	}
+	if in.IsZero() {
+		return 0, false
+	}
+	return out.Sub(in), true
+}
+
+// runMetrics returns the metrics describing a completed run.
+func runMetrics(rs *job.RunStatus, now time.Time) metrics {
+	var m metrics
+	runID := strconv.FormatUint(uint64(rs.RunID), 10)
+	m.add("contest_run_id", "ID of the last completed run.", "gauge", float64(rs.RunID))
+	if !rs.StartTime.IsZero() {
+		m.add("contest_run_duration_seconds", "Duration of the run.", "gauge", now.Sub(rs.StartTime).Seconds())
+	}
+	m.add("contest_run_completion_timestamp_seconds", "Time of completion of the run.", "gauge", float64(now.Unix()))
+	for _, ts := range rs.TestStatuses {
+		passed, failed := targetCounts(ts)
+		m.add("contest_targets_passed", "Number of targets which passed the test.", "gauge", float64(passed), "test", ts.TestName, "run_id", runID)
+		m.add("contest_targets_failed", "Number of targets which failed the test.", "gauge", float64(failed), "test", ts.TestName, "run_id", runID)
+		for _, ss := range ts.TestStepStatuses {
+			if d, ok := stepDuration(ss); ok {
+				m.add("contest_step_duration_seconds", "Time between the first target entering and the last target leaving the step.", "gauge", d.Seconds(),
+					"test", ts.TestName, "step", ss.TestStepLabel, "run_id", runID)
+			}
+		}
+	}
+	return m
+}
+
+// finalMetrics returns the metrics describing all the runs of a job.
+func finalMetrics(runStatuses []job.RunStatus, now time.Time) metrics {
+	var (
+		m              metrics
+		passed, failed int
+		start          time.Time
+	)
+	for _, rs := range runStatuses {
+		if !rs.StartTime.IsZero() && (start.IsZero() || rs.StartTime.Before(start)) {
+			start = rs.StartTime
+		}
+		for _, ts := range rs.TestStatuses {
+			p, f := targetCounts(ts)
+			passed += p
+			failed += f
+		}
+	}
+	m.add("contest_runs_total", "Number of runs executed by the job.", "counter", float64(len(runStatuses)))
+	m.add("contest_target_results_total", "Number of target results across all runs.", "counter", float64(passed), "result", "pass")
+	m.add("contest_target_results_total", "Number of target results across all runs.", "counter", float64(failed), "result", "fail")
+	if !start.IsZero() {
+		m.add("contest_job_duration_seconds", "Duration of the job.", "gauge", now.Sub(start).Seconds())
+	}
+	m.add("contest_job_completion_timestamp_seconds", "Time of completion of the job.", "gauge", float64(now.Unix()))
+	for _, s := range job.SummarizeResults(runStatuses) {
+		labels := []string{"measurement", s.Measurement, "unit", s.Unit}
+		m.add("contest_measurement_results_total", "Number of structured results of a measurement across all runs.", "counter", float64(s.Passed), append(labels, "status", "pass")...)
+		m.add("contest_measurement_results_total", "Number of structured results of a measurement across all runs.", "counter", float64(s.Failed), append(labels, "status", "fail")...)
+		m.add("contest_measurement_results_total", "Number of structured results of a measurement across all runs.", "counter", float64(s.Skipped), append(labels, "status", "skip")...)
+		if s.Values == 0 {
+			continue
+		}
+		m.add("contest_measurement_min", "Lowest value of a measurement across all runs.", "gauge", s.Min, labels...)
+		m.add("contest_measurement_max", "Highest value of a measurement across all runs.", "gauge", s.Max, labels...)
+		m.add("contest_measurement_mean", "Mean value of a measurement across all runs.", "gauge", s.Mean, labels...)
+	}
+	return m
+}
@@ -0,0 +1,274 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package pushgateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/logging"
+)
+
+// Name defines the name of the reporter used within the plugin registry
+var Name = "Pushgateway"
+
+var log = logging.GetLogger("reporters/" + strings.ToLower(Name))
+
+const (
+	defaultTimeout = 10 * time.Second
+	defaultRetries = 3
+	// retryInterval is the wait before the first retry, doubled at every
+	// subsequent attempt.
+	retryInterval = time.Second
+	contentType   = "text/plain; version=0.0.4"
+)
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels cannot be used in the grouping key specified by the user.
+var reservedLabels = map[string]bool{"job": true, "report": true}
+
+// Parameters contains the parameters of both the run and the final reporter.
+// Run metrics are pushed to the group identified by Job and GroupingKey, final
+// metrics to the same group with the additional label report="final".
+type Parameters struct {
+	GatewayURL  string
+	Job         string
+	GroupingKey map[string]string
+	// Timeout of each request to the Pushgateway, as a Go duration string.
+	Timeout string
+	// Retries is the number of times a push is retried on transient failures.
+	Retries *int
+}
+
+// validatedParameters holds the parsed Parameters.
+type validatedParameters struct {
+	groupURL string
+	timeout  time.Duration
+	retries  int
+}
+
+// Pushgateway is a reporter which pushes the metrics of a job to a Prometheus
+// Pushgateway. Pushes are performed asynchronously, so they never delay the
+// completion of the job, and failures are only logged.
+type Pushgateway struct {
+}
+
+func validateLabelValue(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("value of label %s cannot be empty", name)
+	}
+	if strings.Contains(value, "/") {
+		return fmt.Errorf("value of label %s cannot contain '/'", name)
+	}
+	return nil
+}
+
+func validateParameters(params []byte, extraKey ...string) (interface{}, error) {
+	var p Parameters
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(p.GatewayURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GatewayURL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid GatewayURL %q: must be a http or https URL", p.GatewayURL)
+	}
+	if err := validateLabelValue("job", p.Job); err != nil {
+		return nil, fmt.Errorf("invalid Job: %v", err)
+	}
+	groupURL := strings.TrimSuffix(u.String(), "/") + "/metrics/job/" + url.PathEscape(p.Job)
+	keys := make([]string, 0, len(p.GroupingKey))
+	for k := range p.GroupingKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !labelNameRe.MatchString(k) || reservedLabels[k] {
+			return nil, fmt.Errorf("invalid label name %q in GroupingKey", k)
+		}
+		if err := validateLabelValue(k, p.GroupingKey[k]); err != nil {
+			return nil, fmt.Errorf("invalid GroupingKey: %v", err)
+		}
+		groupURL += "/" + k + "/" + url.PathEscape(p.GroupingKey[k])
+	}
+	for i := 0; i+1 < len(extraKey); i += 2 {
+		groupURL += "/" + extraKey[i] + "/" + url.PathEscape(extraKey[i+1])
+	}
+	vp := validatedParameters{groupURL: groupURL, timeout: defaultTimeout, retries: defaultRetries}
+	if p.Timeout != "" {
+		if vp.timeout, err = time.ParseDuration(p.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid Timeout: %v", err)
+		}
+		if vp.timeout <= 0 {
+			return nil, fmt.Errorf("invalid Timeout: must be positive")
+		}
+	}
+	if p.Retries != nil {
+		if *p.Retries < 0 {
+			return nil, fmt.Errorf("invalid Retries: cannot be negative")
+		}
+		vp.retries = *p.Retries
+	}
+	return vp, nil
+}
+
+// ValidateRunParameters validates the parameters for the run reporter
+func (pg *Pushgateway) ValidateRunParameters(params []byte) (interface{}, error) {
+	return validateParameters(params)
+}
+
+// ValidateFinalParameters validates the parameters for the final reporter
+func (pg *Pushgateway) ValidateFinalParameters(params []byte) (interface{}, error) {
+	return validateParameters(params, "report", "final")
+}
+
+// Name returns the Name of the reporter
+func (pg *Pushgateway) Name() string {
+	return Name
+}
+
+// errTransient marks failures which are worth retrying.
+type errTransient struct {
+	err error
+}
+
+func (e errTransient) Error() string {
+	return e.err.Error()
+}
+
+func (e errTransient) Unwrap() error {
+	return e.err
+}
+
+func do(client *http.Client, method, u string, body []byte) error {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errTransient{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("%s %s returned %s: %s", method, u, resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return errTransient{err}
+	}
+	return err
+}
+
+// doWithRetries performs a request, retrying transient failures with
+// exponential backoff until the retries are exhausted or cancel is closed.
+func doWithRetries(cancel <-chan struct{}, client *http.Client, retries int, method, u string, body []byte) error {
+	wait := retryInterval
+	for attempt := 0; ; attempt++ {
+		err := do(client, method, u, body)
+		var transient errTransient
+		if err == nil || !errors.As(err, &transient) || attempt >= retries {
+			return err
+		}
+		log.Warningf("Transient failure pushing to %s (attempt %d of %d): %v", u, attempt+1, retries+1, err)
+		select {
+		case <-cancel:
+			return fmt.Errorf("cancelled while retrying: %v", err)
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// push replaces the group with the given metrics. The group is deleted first,
+// so that series which are not part of the new metrics do not go stale.
+func push(cancel <-chan struct{}, vp validatedParameters, m metrics) error {
+	client := &http.Client{Timeout: vp.timeout}
+	if err := doWithRetries(cancel, client, vp.retries, http.MethodDelete, vp.groupURL, nil); err != nil {
+		return fmt.Errorf("could not delete group: %v", err)
+	}
+	if err := doWithRetries(cancel, client, vp.retries, http.MethodPut, vp.groupURL, m.Encode()); err != nil {
+		return fmt.Errorf("could not push metrics: %v", err)
+	}
+	return nil
+}
+
+func pushAsync(cancel <-chan struct{}, vp validatedParameters, m metrics) {
+	go func() {
+		if err := push(cancel, vp, m); err != nil {
+			log.Errorf("Failed to push metrics to %s: %v", vp.groupURL, err)
+			return
+		}
+		log.Infof("Pushed %d metrics to %s", len(m), vp.groupURL)
+	}()
+}
+
+// RunReport pushes the metrics of a job run.
+func (pg *Pushgateway) RunReport(cancel <-chan struct{}, parameters interface{}, runStatus *job.RunStatus, ev testevent.Fetcher) (bool, interface{}, error) {
+	vp, ok := parameters.(validatedParameters)
+	if !ok {
+		return false, nil, fmt.Errorf("report parameters should be of type validatedParameters")
+	}
+	success := true
+	for _, ts := range runStatus.TestStatuses {
+		if _, failed := targetCounts(ts); failed > 0 {
+			success = false
+		}
+	}
+	pushAsync(cancel, vp, runMetrics(runStatus, time.Now()))
+	return success, fmt.Sprintf("Metrics of run %d are being pushed to %s", runStatus.RunID, vp.groupURL), nil
+}
+
+// FinalReport pushes the metrics of the whole job.
+func (pg *Pushgateway) FinalReport(cancel <-chan struct{}, parameters interface{}, runStatuses []job.RunStatus, ev testevent.Fetcher) (bool, interface{}, error) {
+	vp, ok := parameters.(validatedParameters)
+	if !ok {
+		return false, nil, fmt.Errorf("report parameters should be of type validatedParameters")
+	}
+	success := true
+	for _, rs := range runStatuses {
+		for _, ts := range rs.TestStatuses {
+			if _, failed := targetCounts(ts); failed > 0 {
+				success = false
+			}
+		}
+	}
+	pushAsync(cancel, vp, finalMetrics(runStatuses, time.Now()))
+	return success, fmt.Sprintf("Metrics of %d runs are being pushed to %s", len(runStatuses), vp.groupURL), nil
+}
+
+// New builds a new Pushgateway reporter
+func New() job.Reporter {
+	return &Pushgateway{}
+}
+
+// Load returns the name and factory which are needed to register the Reporter
+func Load() (string, job.ReporterFactory) {
+	return Name, New
+}
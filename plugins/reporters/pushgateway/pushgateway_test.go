@@ -0,0 +1,70 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package pushgateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateParameters(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		params string
+		valid  bool
+	}{
+		{"minimal", `{"GatewayURL": "http://pushgateway:9091", "Job": "contest"}`, true},
+		{"complete", `{"GatewayURL": "https://pushgateway:9091/", "Job": "contest", "GroupingKey": {"lab": "lab1", "_pool": "a b"}, "Timeout": "5s", "Retries": 0}`, true},
+		{"not JSON", `[`, false},
+		{"missing URL", `{"Job": "contest"}`, false},
+		{"unsupported scheme", `{"GatewayURL": "ftp://pushgateway:9091", "Job": "contest"}`, false},
+		{"missing host", `{"GatewayURL": "http://", "Job": "contest"}`, false},
+		{"unparsable URL", `{"GatewayURL": "http://pushgateway:port", "Job": "contest"}`, false},
+		{"missing job", `{"GatewayURL": "http://pushgateway:9091"}`, false},
+		{"job with a slash", `{"GatewayURL": "http://pushgateway:9091", "Job": "a/b"}`, false},
+		{"invalid label name", `{"GatewayURL": "http://pushgateway:9091", "Job": "contest", "GroupingKey": {"1lab": "lab1"}}`, false},
+		{"reserved label", `{"GatewayURL": "http://pushgateway:9091", "Job": "contest", "GroupingKey": {"report": "x"}}`, false},
+		{"empty label value", `{"GatewayURL": "http://pushgateway:9091", "Job": "contest", "GroupingKey": {"lab": ""}}`, false},
+		{"label value with a slash", `{"GatewayURL": "http://pushgateway:9091", "Job": "contest", "GroupingKey": {"lab": "a/b"}}`, false},
+		{"invalid timeout", `{"GatewayURL": "http://pushgateway:9091", "Job": "contest", "Timeout": "soon"}`, false},
+		{"zero timeout", `{"GatewayURL": "http://pushgateway:9091", "Job": "contest", "Timeout": "0s"}`, false},
+		{"negative retries", `{"GatewayURL": "http://pushgateway:9091", "Job": "contest", "Retries": -1}`, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pg := New()
+			_, runErr := pg.ValidateRunParameters([]byte(tc.params))
+			_, finalErr := pg.ValidateFinalParameters([]byte(tc.params))
+			if tc.valid {
+				require.NoError(t, runErr)
+				require.NoError(t, finalErr)
+			} else {
+				require.Error(t, runErr)
+				require.Error(t, finalErr)
+			}
+		})
+	}
+}
+
+func TestValidateParametersGroupURL(t *testing.T) {
+	params := []byte(`{"GatewayURL": "http://pushgateway:9091/", "Job": "con test", "GroupingKey": {"pool": "a", "lab": "lab1"}, "Timeout": "5s", "Retries": 1}`)
+	run, err := New().ValidateRunParameters(params)
+	require.NoError(t, err)
+	require.Equal(t, validatedParameters{
+		groupURL: "http://pushgateway:9091/metrics/job/con%20test/lab/lab1/pool/a",
+		timeout:  5 * time.Second,
+		retries:  1,
+	}, run)
+	final, err := New().ValidateFinalParameters(params)
+	require.NoError(t, err)
+	require.Equal(t, "http://pushgateway:9091/metrics/job/con%20test/lab/lab1/pool/a/report/final", final.(validatedParameters).groupURL)
+
+	run, err = New().ValidateRunParameters([]byte(`{"GatewayURL": "http://pushgateway:9091", "Job": "contest"}`))
+	require.NoError(t, err)
+	require.Equal(t, defaultTimeout, run.(validatedParameters).timeout)
+	require.Equal(t, defaultRetries, run.(validatedParameters).retries)
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package reporters
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// Summary is the outcome of the runs of a job, as handed to the templates of
+// the reporters which send messages about it.
+type Summary struct {
+	JobID types.JobID
+	Runs  int
+	// Success is false if any target which is not informational failed.
+	Success bool
+	Passed  int
+	Failed  int
+	Targets []TargetResult
+	Links   Links
+	Time    time.Time
+}
+
+// TargetResult is the outcome of a target in a test of a run.
+type TargetResult struct {
+	RunID         types.RunID
+	Test          string
+	Target        string
+	TargetID      string
+	Passed        bool
+	Informational bool
+	Error         string
+}
+
+// Links point to the pages of the job on the HTTP API of the server. They are
+// empty if the reporter has no base URL.
+type Links struct {
+	Verdict   string
+	Artifacts string
+}
+
+// NewSummary summarizes the runs of a job. baseURL, if not empty, is the
+// address of the HTTP API the links are built on.
+func NewSummary(runStatuses []job.RunStatus, baseURL string, now time.Time) Summary {
+	s := Summary{Runs: len(runStatuses), Success: true, Targets: []TargetResult{}, Time: now}
+	for _, rs := range runStatuses {
+		s.JobID = rs.JobID
+		for _, ts := range rs.TestStatuses {
+			for _, t := range ts.TargetStatuses {
+				if t.Target == nil {
+					continue
+				}
+				r := TargetResult{
+					RunID:         rs.RunID,
+					Test:          ts.TestName,
+					Target:        t.Target.Name,
+					TargetID:      t.Target.ID,
+					Passed:        t.Error == "",
+					Informational: t.Informational,
+					Error:         t.Error,
+				}
+				if r.Passed {
+					s.Passed++
+				} else {
+					s.Failed++
+					if !r.Informational {
+						s.Success = false
+					}
+				}
+				s.Targets = append(s.Targets, r)
+			}
+		}
+	}
+	if baseURL != "" && s.JobID != "" {
+		jobURL := strings.TrimSuffix(baseURL, "/") + "/jobs/" + url.PathEscape(s.JobID.String())
+		s.Links = Links{Verdict: jobURL + "/verdict", Artifacts: jobURL + "/artifacts"}
+	}
+	return s
+}
+
+// ValidateBaseURL checks the base URL of the links of a summary.
+func ValidateBaseURL(baseURL string) error {
+	if baseURL == "" {
+		return nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid BaseURL %q: must be a http or https URL", baseURL)
+	}
+	return nil
+}
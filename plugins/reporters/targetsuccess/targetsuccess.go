@@ -91,7 +91,17 @@ func (ts *TargetSuccessReporter) RunReport(cancel <-chan struct{}, parameters in
 		fail = 0
 		success = 0
 
+		// informational targets are reported, but do not count toward the
+		// success of the test
+		var informational, informationalFail int
 		for _, t := range t.TargetStatuses {
+			if t.Informational {
+				informational++
+				if t.Error != "" {
+					informationalFail++
+				}
+				continue
+			}
 			if t.Error != "" {
 				fail++
 			} else {
@@ -99,7 +109,14 @@ func (ts *TargetSuccessReporter) RunReport(cancel <-chan struct{}, parameters in
 			}
 
 		}
+		if informationalFail > 0 {
+			testReports = append(testReports, fmt.Sprintf("Test %s: %d informational targets out of %d failed", t.TestCoordinates.TestName, informationalFail, informational))
+		}
 
+		if success+fail == 0 && informational > 0 {
+			testReports = append(testReports, fmt.Sprintf("Test %s only has informational targets", t.TestCoordinates.TestName))
+			continue
+		}
 		if success+fail == 0 {
 			return false, nil, fmt.Errorf("overall count of success and failures is zero for test %s", t.TestCoordinates.TestName)
 		}
@@ -0,0 +1,251 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/plugins/reporters"
+)
+
+// Name defines the name of the reporter used within the plugin registry
+var Name = "Webhook"
+
+var log = logging.GetLogger("reporters/" + strings.ToLower(Name))
+
+const (
+	defaultTimeout = 10 * time.Second
+	defaultRetries = 3
+	// retryInterval is the wait before the first retry, doubled at every
+	// subsequent attempt.
+	retryInterval = time.Second
+)
+
+// defaultTemplate is the template of the message when none is given.
+const defaultTemplate = `Job {{.JobID}} {{if .Success}}passed{{else}}failed{{end}}: ` +
+	`{{.Passed}} targets passed and {{.Failed}} failed in {{.Runs}} runs.` +
+	`{{if .Links.Verdict}} {{.Links.Verdict}}{{end}}`
+
+// Parameters contains the parameters of the final reporter.
+type Parameters struct {
+	// URL is the address the summary is POSTed to. It can contain secret
+	// references, as webhook URLs often embed a token.
+	URL string
+	// Template is a Go text/template rendering the message from the
+	// reporters.Summary of the job.
+	Template string
+	// BaseURL is the address of the HTTP API of the server, which the links
+	// of the summary point to.
+	BaseURL string
+	// Headers are added to the request. Their values can be secret references.
+	Headers map[string]string
+	// Timeout of each request, as a Go duration string.
+	Timeout string
+	// Retries is the number of times a request is retried on transient
+	// failures.
+	Retries *int
+}
+
+// validatedParameters holds the parsed Parameters.
+type validatedParameters struct {
+	url      string
+	host     string
+	template *template.Template
+	baseURL  string
+	headers  map[string]string
+	timeout  time.Duration
+	retries  int
+}
+
+// payload is the body of the request: the summary of the job and the
+// rendered message in the text field, which is what Slack incoming webhooks
+// display.
+type payload struct {
+	Text string `json:"text"`
+	reporters.Summary
+}
+
+// Webhook is a reporter which POSTs a JSON summary of a job to a URL. The
+// request is sent asynchronously, so it never delays the completion of the
+// job, and failures are only logged.
+type Webhook struct {
+}
+
+// ValidateRunParameters rejects run reporting, which is not supported
+func (w *Webhook) ValidateRunParameters(params []byte) (interface{}, error) {
+	return nil, fmt.Errorf("%s is only a final reporter", Name)
+}
+
+// ValidateFinalParameters validates the parameters for the final reporter
+func (w *Webhook) ValidateFinalParameters(params []byte) (interface{}, error) {
+	var p Parameters
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	rawURL, err := secrets.Expand(p.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		// the URL is not printed, as it may hold a secret
+		return nil, errors.New("invalid or missing URL: must be a http or https URL")
+	}
+	if err := reporters.ValidateBaseURL(p.BaseURL); err != nil {
+		return nil, err
+	}
+	vp := validatedParameters{url: rawURL, host: u.Host, baseURL: p.BaseURL, headers: make(map[string]string), timeout: defaultTimeout, retries: defaultRetries}
+	text := p.Template
+	if text == "" {
+		text = defaultTemplate
+	}
+	if vp.template, err = template.New(Name).Parse(text); err != nil {
+		return nil, fmt.Errorf("invalid Template: %v", err)
+	}
+	for k, v := range p.Headers {
+		if vp.headers[k], err = secrets.Expand(v); err != nil {
+			return nil, fmt.Errorf("invalid header %s: %v", k, err)
+		}
+	}
+	if p.Timeout != "" {
+		if vp.timeout, err = time.ParseDuration(p.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid Timeout: %v", err)
+		}
+		if vp.timeout <= 0 {
+			return nil, fmt.Errorf("invalid Timeout: must be positive")
+		}
+	}
+	if p.Retries != nil {
+		if *p.Retries < 0 {
+			return nil, fmt.Errorf("invalid Retries: cannot be negative")
+		}
+		vp.retries = *p.Retries
+	}
+	return vp, nil
+}
+
+// Name returns the Name of the reporter
+func (w *Webhook) Name() string {
+	return Name
+}
+
+// errTransient marks failures which are worth retrying.
+type errTransient struct {
+	err error
+}
+
+func (e errTransient) Error() string {
+	return e.err.Error()
+}
+
+func (e errTransient) Unwrap() error {
+	return e.err
+}
+
+func post(client *http.Client, vp validatedParameters, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, vp.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range vp.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// the error of the client holds the URL, which may hold a secret
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return errTransient{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("POST to %s returned %s: %s", vp.host, resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return errTransient{err}
+	}
+	return err
+}
+
+// send posts the body, retrying transient failures with exponential backoff
+// until the retries are exhausted or cancel is closed.
+func send(cancel <-chan struct{}, vp validatedParameters, body []byte) error {
+	client := &http.Client{Timeout: vp.timeout}
+	wait := retryInterval
+	for attempt := 0; ; attempt++ {
+		err := post(client, vp, body)
+		var transient errTransient
+		if err == nil || !errors.As(err, &transient) || attempt >= vp.retries {
+			return err
+		}
+		log.Warningf("Transient failure posting to %s (attempt %d of %d): %v", vp.host, attempt+1, vp.retries+1, err)
+		select {
+		case <-cancel:
+			return fmt.Errorf("cancelled while retrying: %v", err)
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// RunReport is not supported, see ValidateRunParameters.
+func (w *Webhook) RunReport(cancel <-chan struct{}, parameters interface{}, runStatus *job.RunStatus, ev testevent.Fetcher) (bool, interface{}, error) {
+	return false, nil, fmt.Errorf("%s is only a final reporter", Name)
+}
+
+// FinalReport posts the summary of the whole job.
+func (w *Webhook) FinalReport(cancel <-chan struct{}, parameters interface{}, runStatuses []job.RunStatus, ev testevent.Fetcher) (bool, interface{}, error) {
+	vp, ok := parameters.(validatedParameters)
+	if !ok {
+		return false, nil, fmt.Errorf("report parameters should be of type validatedParameters")
+	}
+	summary := reporters.NewSummary(runStatuses, vp.baseURL, time.Now())
+	var text strings.Builder
+	if err := vp.template.Execute(&text, summary); err != nil {
+		return false, nil, fmt.Errorf("could not render the message: %v", err)
+	}
+	body, err := json.Marshal(payload{Text: text.String(), Summary: summary})
+	if err != nil {
+		return false, nil, fmt.Errorf("could not encode the summary: %v", err)
+	}
+	go func() {
+		if err := send(cancel, vp, body); err != nil {
+			log.Errorf("Failed to post the summary of job %s to %s: %v", summary.JobID, vp.host, err)
+			return
+		}
+		log.Infof("Posted the summary of job %s to %s", summary.JobID, vp.host)
+	}()
+	return summary.Success, fmt.Sprintf("The summary of %d runs is being posted to %s", len(runStatuses), vp.host), nil
+}
+
+// New builds a new Webhook reporter
+func New() job.Reporter {
+	return &Webhook{}
+}
+
+// Load returns the name and factory which are needed to register the Reporter
+func Load() (string, job.ReporterFactory) {
+	return Name, New
+}
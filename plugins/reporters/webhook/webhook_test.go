@@ -0,0 +1,67 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package webhook
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFinalParameters(t *testing.T) {
+	require.NoError(t, os.Setenv("WEBHOOK_TEST_TOKEN", "token"))
+	defer os.Unsetenv("WEBHOOK_TEST_TOKEN")
+	for _, tc := range []struct {
+		name   string
+		params string
+		valid  bool
+	}{
+		{"minimal", `{"URL": "https://hooks.example.com/x"}`, true},
+		{"complete", `{"URL": "http://hooks.example.com/${env:WEBHOOK_TEST_TOKEN}", "Template": "{{.JobID}}", "BaseURL": "https://contest.example.com", "Headers": {"Authorization": "Bearer ${env:WEBHOOK_TEST_TOKEN}"}, "Timeout": "5s", "Retries": 0}`, true},
+		{"not JSON", `[`, false},
+		{"missing URL", `{}`, false},
+		{"unsupported scheme", `{"URL": "ftp://hooks.example.com/x"}`, false},
+		{"missing host", `{"URL": "https:///x"}`, false},
+		{"unset secret in URL", `{"URL": "https://hooks.example.com/${env:WEBHOOK_TEST_UNSET}"}`, false},
+		{"unset secret in header", `{"URL": "https://hooks.example.com/x", "Headers": {"Authorization": "${env:WEBHOOK_TEST_UNSET}"}}`, false},
+		{"invalid base URL", `{"URL": "https://hooks.example.com/x", "BaseURL": "contest"}`, false},
+		{"invalid template", `{"URL": "https://hooks.example.com/x", "Template": "{{.JobID"}`, false},
+		{"invalid timeout", `{"URL": "https://hooks.example.com/x", "Timeout": "soon"}`, false},
+		{"zero timeout", `{"URL": "https://hooks.example.com/x", "Timeout": "0s"}`, false},
+		{"negative retries", `{"URL": "https://hooks.example.com/x", "Retries": -1}`, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New().ValidateFinalParameters([]byte(tc.params))
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateFinalParametersDefaults(t *testing.T) {
+	params, err := New().ValidateFinalParameters([]byte(`{"URL": "https://hooks.example.com/x"}`))
+	require.NoError(t, err)
+	vp := params.(validatedParameters)
+	require.Equal(t, "hooks.example.com", vp.host)
+	require.Equal(t, defaultTimeout, vp.timeout)
+	require.Equal(t, defaultRetries, vp.retries)
+
+	params, err = New().ValidateFinalParameters([]byte(`{"URL": "https://hooks.example.com/x", "Timeout": "5s", "Retries": 0}`))
+	require.NoError(t, err)
+	vp = params.(validatedParameters)
+	require.Equal(t, 5*time.Second, vp.timeout)
+	require.Equal(t, 0, vp.retries)
+}
+
+func TestValidateRunParameters(t *testing.T) {
+	_, err := New().ValidateRunParameters([]byte(`{"URL": "https://hooks.example.com/x"}`))
+	require.Error(t, err)
+}
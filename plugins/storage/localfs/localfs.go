@@ -0,0 +1,159 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package localfs implements an artifact store which keeps the artifacts in a
+// directory of the local filesystem, as
+// <root>/<job ID>/<test name>/<target ID>/<name>.
+package localfs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// tempPrefix is the prefix of the files being written, which escaped path
+// components never start with.
+const tempPrefix = ".tmp-"
+
+// LocalFS stores the artifacts in a directory. Artifacts are written to a
+// temporary file which is renamed once complete, so that readers never see
+// partial artifacts.
+type LocalFS struct {
+	root string
+}
+
+// New returns a LocalFS storing the artifacts under root, which is created if
+// needed.
+func New(root string) (*LocalFS, error) {
+	if root == "" {
+		return nil, fmt.Errorf("artifact directory cannot be empty")
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create artifact directory: %v", err)
+	}
+	return &LocalFS{root: root}, nil
+}
+
+// escape turns a key component into a file name. Separators are escaped, and
+// so is a leading dot, so that no component is . or .., or a temporary file.
+func escape(s string) string {
+	e := url.PathEscape(s)
+	if strings.HasPrefix(e, ".") {
+		e = "%2E" + e[1:]
+	}
+	return e
+}
+
+func (l *LocalFS) dir(jobID types.JobID, components ...string) string {
+	parts := []string{l.root, escape(string(jobID))}
+	for _, c := range components {
+		parts = append(parts, escape(c))
+	}
+	return filepath.Join(parts...)
+}
+
+func (l *LocalFS) path(key storage.ArtifactKey) string {
+	return l.dir(key.JobID, key.TestName, key.TargetID, key.Name)
+}
+
+// PutArtifact writes an artifact to its file.
+func (l *LocalFS) PutArtifact(key storage.ArtifactKey, r io.Reader) (int64, error) {
+	dir := l.dir(key.JobID, key.TestName, key.TargetID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("cannot create artifact directory: %v", err)
+	}
+	f, err := ioutil.TempFile(dir, tempPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("cannot create artifact file: %v", err)
+	}
+	size, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), l.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return 0, fmt.Errorf("cannot write artifact file: %v", err)
+	}
+	return size, nil
+}
+
+// GetArtifact opens the file of an artifact.
+func (l *LocalFS) GetArtifact(key storage.ArtifactKey) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(key))
+	if os.IsNotExist(err) {
+		return nil, storage.ErrArtifactNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot open artifact file: %v", err)
+	}
+	return f, nil
+}
+
+// unescape turns file names back into key components.
+func unescape(names ...string) ([]string, error) {
+	components := make([]string, 0, len(names))
+	for _, name := range names {
+		c, err := url.PathUnescape(name)
+		if err != nil {
+			return nil, err
+		}
+		components = append(components, c)
+	}
+	return components, nil
+}
+
+// readDir returns the entries of a directory, or none if it does not exist.
+func readDir(dir string) ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// ListArtifacts walks the directory of a job.
+func (l *LocalFS) ListArtifacts(jobID types.JobID) ([]storage.Artifact, error) {
+	var artifacts []storage.Artifact
+	tests, err := readDir(l.dir(jobID))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list artifact directory: %v", err)
+	}
+	for _, test := range tests {
+		targets, err := readDir(filepath.Join(l.dir(jobID), test.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("cannot list artifact directory: %v", err)
+		}
+		for _, tgt := range targets {
+			files, err := readDir(filepath.Join(l.dir(jobID), test.Name(), tgt.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("cannot list artifact directory: %v", err)
+			}
+			for _, f := range files {
+				if f.IsDir() || strings.HasPrefix(f.Name(), tempPrefix) {
+					continue
+				}
+				names, err := unescape(test.Name(), tgt.Name(), f.Name())
+				if err != nil {
+					return nil, fmt.Errorf("invalid artifact file %s: %v", f.Name(), err)
+				}
+				key := storage.ArtifactKey{JobID: jobID, TestName: names[0], TargetID: names[1], Name: names[2]}
+				artifacts = append(artifacts, storage.Artifact{ArtifactKey: key, Size: f.Size(), StoreTime: f.ModTime()})
+			}
+		}
+	}
+	storage.SortArtifacts(artifacts)
+	return artifacts, nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package localfs
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	"github.com/stretchr/testify/require"
+)
+
+func newLocalFS(t *testing.T) (*LocalFS, func()) {
+	root, err := ioutil.TempDir("", "contest-artifacts-")
+	require.NoError(t, err)
+	l, err := New(filepath.Join(root, "artifacts"))
+	require.NoError(t, err)
+	return l, func() { _ = os.RemoveAll(root) }
+}
+
+func read(t *testing.T, l *LocalFS, key storage.ArtifactKey) string {
+	rc, err := l.GetArtifact(key)
+	require.NoError(t, err)
+	defer rc.Close()
+	content, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestPutGetList(t *testing.T) {
+	l, done := newLocalFS(t)
+	defer done()
+
+	dmesg := storage.ArtifactKey{JobID: "1", TestName: "boot", TargetID: "host/1", Name: "dmesg.log"}
+	size, err := l.PutArtifact(dmesg, strings.NewReader("kernel panic"))
+	require.NoError(t, err)
+	require.Equal(t, int64(12), size)
+	require.Equal(t, "kernel panic", read(t, l, dmesg))
+	// artifacts are replaced
+	_, err = l.PutArtifact(dmesg, strings.NewReader("all good"))
+	require.NoError(t, err)
+	require.Equal(t, "all good", read(t, l, dmesg))
+
+	// names cannot escape the directory of the target
+	dots := storage.ArtifactKey{JobID: "1", TestName: "boot", TargetID: "..", Name: ".."}
+	_, err = l.PutArtifact(dots, strings.NewReader("x"))
+	require.NoError(t, err)
+	require.Equal(t, "x", read(t, l, dots))
+
+	_, err = l.GetArtifact(storage.ArtifactKey{JobID: "1", TestName: "boot", TargetID: "host2", Name: "dmesg.log"})
+	require.True(t, errors.Is(err, storage.ErrArtifactNotFound))
+
+	artifacts, err := l.ListArtifacts("1")
+	require.NoError(t, err)
+	require.Len(t, artifacts, 2)
+	require.Equal(t, dots, artifacts[0].ArtifactKey)
+	require.Equal(t, dmesg, artifacts[1].ArtifactKey)
+	require.Equal(t, int64(8), artifacts[1].Size)
+	artifacts, err = l.ListArtifacts("2")
+	require.NoError(t, err)
+	require.Empty(t, artifacts)
+}
+
+func TestAttachArtifact(t *testing.T) {
+	l, done := newLocalFS(t)
+	defer done()
+	storage.SetStorage(memory.New())
+	storage.SetArtifactStore(l)
+	defer storage.SetArtifactStore(nil)
+
+	ev := storage.NewTestEventEmitterFetcher(testevent.Header{JobID: "3", RunID: 1, TestName: "flash", TestStepLabel: "collect"})
+	tgt := &target.Target{ID: "7", Name: "host7"}
+	require.NoError(t, teststeps.AttachArtifact(ev, tgt, "console.log", strings.NewReader("login:")))
+	require.Error(t, teststeps.AttachArtifact(ev, tgt, "", strings.NewReader("login:")))
+
+	artifacts, err := storage.ListArtifacts("3")
+	require.NoError(t, err)
+	require.Len(t, artifacts, 1)
+	require.Equal(t, storage.ArtifactKey{JobID: "3", TestName: "flash", TargetID: "7", Name: "console.log"}, artifacts[0].ArtifactKey)
+	events, err := ev.Fetch(testevent.QueryEventName(storage.EventArtifactAttached))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.JSONEq(t, `{"Name": "console.log", "Size": 6}`, string(*events[0].Data.Payload))
+	require.Equal(t, tgt, events[0].Data.Target)
+}
@@ -6,7 +6,10 @@
 package memory
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -15,23 +18,39 @@ import (
 	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/job"
 	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
 	"github.com/facebookincubator/contest/pkg/types"
 )
 
-// Memory implements a storage engine which stores everything in memory. This
-// storage engine is very inefficient and should be used only for testing
-// purposes.
+// Memory implements a storage engine which stores everything in memory. It
+// implements the whole Storage interface, so that tests and standalone servers
+// can run without a database, but it is inefficient and everything it stores
+// is lost when the process exits. Job requests and reports are copied when
+// they are stored and fetched, so that callers do not share them.
 type Memory struct {
 	lock            *sync.Mutex
 	testEvents      []testevent.Event
 	frameworkEvents []frameworkevent.Event
-	jobIDCounter    types.JobID
+	jobSequence     uint64
 	jobRequests     map[types.JobID]*job.Request
-	jobReports      map[types.JobID]*job.JobReport
+	// jobIDs holds the IDs of the job requests, in submission order.
+	jobIDs     []types.JobID
+	jobReports map[types.JobID]*job.JobReport
+	// jobTemplates holds the versions of each template, in order.
+	jobTemplates map[string][]*job.Template
+	// jobSchedules holds the job schedules, whose IDs are their index plus
+	// one.
+	jobSchedules []*job.Schedule
+	// targetOutcomes holds the outcomes of each target, in the order they
+	// were recorded.
+	targetOutcomes map[string][]target.Outcome
+	targetHealth   map[string]*target.Health
+	// stepStates holds the encoded step state of each paused job.
+	stepStates map[types.JobID][]byte
 }
 
 func emptyEventQuery(eventQuery *event.Query) bool {
-	return eventQuery.JobID == 0 && len(eventQuery.EventNames) == 0 && eventQuery.EmittedStartTime.IsZero() && eventQuery.EmittedEndTime.IsZero()
+	return eventQuery.JobID == "" && len(eventQuery.EventNames) == 0 && eventQuery.EmittedStartTime.IsZero() && eventQuery.EmittedEndTime.IsZero()
 
 }
 
@@ -46,7 +65,8 @@ func emptyFrameworkEventQuery(eventQuery *frameworkevent.Query) bool {
 // values. If so, the Query is considered "empty" and doesn't result in
 // any lookup in the database
 func emptyTestEventQuery(eventQuery *testevent.Query) bool {
-	return emptyEventQuery(&eventQuery.Query) && eventQuery.TestName == "" && eventQuery.TestStepLabel == ""
+	return emptyEventQuery(&eventQuery.Query) && eventQuery.RunID == 0 && eventQuery.TestName == "" && eventQuery.TestStepLabel == "" && len(eventQuery.Severities) == 0 &&
+		len(eventQuery.Measurements) == 0 && len(eventQuery.ResultStatuses) == 0
 }
 
 // Reset resets the content of the in-memory storage.
@@ -57,7 +77,13 @@ func (m *Memory) Reset() error {
 	m.frameworkEvents = []frameworkevent.Event{}
 	m.jobRequests = make(map[types.JobID]*job.Request)
 	m.jobReports = make(map[types.JobID]*job.JobReport)
-	m.jobIDCounter = 1
+	m.jobTemplates = make(map[string][]*job.Template)
+	m.jobSchedules = nil
+	m.targetOutcomes = make(map[string][]target.Outcome)
+	m.targetHealth = make(map[string]*target.Health)
+	m.stepStates = make(map[types.JobID][]byte)
+	m.jobIDs = nil
+	m.jobSequence = 0
 	return nil
 }
 
@@ -70,7 +96,7 @@ func (m *Memory) StoreTestEvent(event testevent.Event) error {
 }
 
 func eventJobMatch(queryJobID types.JobID, jobID types.JobID) bool {
-	if queryJobID != 0 && jobID != queryJobID {
+	if queryJobID != "" && jobID != queryJobID {
 		return false
 	}
 	return true
@@ -100,6 +126,10 @@ func eventTimeMatch(queryStartTime, queryEndTime time.Time, emittedTime time.Tim
 	return true
 }
 
+func eventRunMatch(queryRunID, runID types.RunID) bool {
+	return queryRunID == 0 || runID == queryRunID
+}
+
 func eventTestMatch(queryTestName, testName string) bool {
 	if queryTestName != "" && testName != queryTestName {
 		return false
@@ -114,6 +144,45 @@ func eventTestStepMatch(queryTestStepLabel, testStepLabel string) bool {
 	return true
 }
 
+func eventSeverityMatch(querySeverities []testevent.Severity, severity testevent.Severity) bool {
+	if len(querySeverities) == 0 {
+		return true
+	}
+	for _, candidate := range querySeverities {
+		if severity == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// eventResultMatch tells whether an event is a result with one of the
+// queried measurements and statuses, if any are queried.
+func eventResultMatch(measurements []string, statuses []testevent.ResultStatus, data *testevent.Data) bool {
+	if len(measurements) == 0 && len(statuses) == 0 {
+		return true
+	}
+	result, err := data.Result()
+	if err != nil {
+		return false
+	}
+	measurementMatch := len(measurements) == 0
+	for _, candidate := range measurements {
+		if result.Measurement == candidate {
+			measurementMatch = true
+			break
+		}
+	}
+	statusMatch := len(statuses) == 0
+	for _, candidate := range statuses {
+		if result.Status == candidate {
+			statusMatch = true
+			break
+		}
+	}
+	return measurementMatch && statusMatch
+}
+
 // GetTestEvents returns all test events that match the given query.
 func (m *Memory) GetTestEvents(eventQuery *testevent.Query) ([]testevent.Event, error) {
 	m.lock.Lock()
@@ -126,37 +195,204 @@ func (m *Memory) GetTestEvents(eventQuery *testevent.Query) ([]testevent.Event,
 	}
 
 	for _, event := range m.testEvents {
-		if eventJobMatch(eventQuery.JobID, event.Header.JobID) &&
-			eventNameMatch(eventQuery.EventNames, event.Data.EventName) &&
-			eventTimeMatch(eventQuery.EmittedStartTime, eventQuery.EmittedEndTime, event.EmitTime) &&
-			eventTestMatch(eventQuery.TestName, event.Header.TestName) &&
-			eventTestStepMatch(eventQuery.TestStepLabel, event.Header.TestStepLabel) {
+		if testEventMatch(eventQuery, event) {
 			matchingTestEvents = append(matchingTestEvents, event)
 		}
 	}
 	return matchingTestEvents, nil
 }
 
-// StoreJobRequest stores a new job request
+func testEventMatch(eventQuery *testevent.Query, event testevent.Event) bool {
+	// deleted events have no header
+	return event.Header != nil &&
+		eventJobMatch(eventQuery.JobID, event.Header.JobID) &&
+		eventNameMatch(eventQuery.EventNames, event.Data.EventName) &&
+		eventTimeMatch(eventQuery.EmittedStartTime, eventQuery.EmittedEndTime, event.EmitTime) &&
+		eventRunMatch(eventQuery.RunID, event.Header.RunID) &&
+		eventTestMatch(eventQuery.TestName, event.Header.TestName) &&
+		eventTestStepMatch(eventQuery.TestStepLabel, event.Header.TestStepLabel) &&
+		eventSeverityMatch(eventQuery.Severities, event.Data.Severity) &&
+		eventResultMatch(eventQuery.Measurements, eventQuery.ResultStatuses, event.Data)
+}
+
+// GetTestEventsPage returns a page of the test events that match the given
+// query. The sequence number of an event is its position in the storage,
+// starting at 1.
+func (m *Memory) GetTestEventsPage(eventQuery *testevent.Query, afterSequence uint64, limit int) (*testevent.Page, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	page := testevent.Page{LastSequence: afterSequence}
+	if emptyTestEventQuery(eventQuery) {
+		return &page, nil
+	}
+	for i := afterSequence; i < uint64(len(m.testEvents)); i++ {
+		event := m.testEvents[i]
+		if !testEventMatch(eventQuery, event) {
+			continue
+		}
+		if len(page.Events) == limit {
+			page.More = true
+			break
+		}
+		page.Events = append(page.Events, event)
+		page.LastSequence = i + 1
+	}
+	return &page, nil
+}
+
+// CountTestEvents returns the number of test events of a job, by event name.
+func (m *Memory) CountTestEvents(jobID types.JobID) (map[event.Name]uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	counts := make(map[event.Name]uint64)
+	for _, event := range m.testEvents {
+		if event.Header != nil && event.Header.JobID == jobID {
+			counts[event.Data.EventName] += event.Data.Count()
+		}
+	}
+	return counts, nil
+}
+
+// NextJobSequence returns the next value of the job sequence.
+func (m *Memory) NextJobSequence() (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.jobSequence++
+	return m.jobSequence, nil
+}
+
+// StoreJobRequest stores a new job request. Requests without a job ID are
+// assigned the next value of the job sequence.
 func (m *Memory) StoreJobRequest(request *job.Request) (types.JobID, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	request.JobID = m.jobIDCounter
-	m.jobIDCounter++
-	m.jobRequests[request.JobID] = request
+	if request.JobID == "" {
+		m.jobSequence++
+		request.JobID = types.JobID(strconv.FormatUint(m.jobSequence, 10))
+	}
+	if _, ok := m.jobRequests[request.JobID]; ok {
+		return "", fmt.Errorf("job ID %s is already in use", request.JobID)
+	}
+	m.jobRequests[request.JobID] = copyRequest(request)
+	m.jobIDs = append(m.jobIDs, request.JobID)
 	return request.JobID, nil
 }
 
+// StoreJobRequests stores new job requests, either all of them or none.
+func (m *Memory) StoreJobRequests(requests []*job.Request) ([]types.JobID, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sequence := m.jobSequence
+	jobIDs := make([]types.JobID, 0, len(requests))
+	batch := make(map[types.JobID]bool)
+	for _, request := range requests {
+		jobID := request.JobID
+		if jobID == "" {
+			sequence++
+			jobID = types.JobID(strconv.FormatUint(sequence, 10))
+		}
+		if _, ok := m.jobRequests[jobID]; ok || batch[jobID] {
+			return nil, fmt.Errorf("job ID %s is already in use", jobID)
+		}
+		batch[jobID] = true
+		jobIDs = append(jobIDs, jobID)
+	}
+	m.jobSequence = sequence
+	for i, request := range requests {
+		request.JobID = jobIDs[i]
+		m.jobRequests[request.JobID] = copyRequest(request)
+		m.jobIDs = append(m.jobIDs, request.JobID)
+	}
+	return jobIDs, nil
+}
+
+// copyRequest returns a copy of a job request, whose fields are all plain
+// values.
+func copyRequest(request *job.Request) *job.Request {
+	r := *request
+	return &r
+}
+
 // GetJobRequest retrieves a job request from the in memory list
 func (m *Memory) GetJobRequest(jobID types.JobID) (*job.Request, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	r, ok := m.jobRequests[jobID]
 	if !ok {
-		return nil, fmt.Errorf("could not find job request with id %v", jobID)
+		return nil, &storage.ErrJobRequestNotFound{JobID: jobID}
+	}
+	return copyRequest(r), nil
+}
+
+// GetJobRequests returns the job requests matching the query, ordered by
+// request time
+func (m *Memory) GetJobRequests(query job.JobQuery) ([]*job.Request, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var states map[types.JobID]event.Name
+	if len(query.States) > 0 {
+		stateEvents := make(map[event.Name]bool)
+		for _, name := range job.StateEvents {
+			stateEvents[name] = true
+		}
+		states = make(map[types.JobID]event.Name)
+		for _, ev := range m.frameworkEvents {
+			if stateEvents[ev.EventName] {
+				states[ev.JobID] = ev.EventName
+			}
+		}
+	}
+	var requests []*job.Request
+	for _, jobID := range m.jobIDs {
+		if states != nil && !eventNameMatch(query.States, states[jobID]) {
+			continue
+		}
+		requests = append(requests, copyRequest(m.jobRequests[jobID]))
 	}
-	return r, nil
+	sort.SliceStable(requests, func(i, j int) bool {
+		return requests[i].RequestTime.Before(requests[j].RequestTime)
+	})
+	if query.Offset >= uint(len(requests)) {
+		return nil, nil
+	}
+	requests = requests[query.Offset:]
+	if query.Limit < uint(len(requests)) {
+		requests = requests[:query.Limit]
+	}
+	return requests, nil
+}
+
+// GetJobIDsByExternalID returns the IDs of the jobs submitted with the given
+// external ID
+func (m *Memory) GetJobIDsByExternalID(externalID string) ([]types.JobID, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var jobIDs []types.JobID
+	for _, jobID := range m.jobIDs {
+		if m.jobRequests[jobID].ExternalID == externalID {
+			jobIDs = append(jobIDs, jobID)
+		}
+	}
+	return jobIDs, nil
+}
+
+// GetJobIDsBySeriesID returns the IDs of the jobs of a series, in submission
+// order
+func (m *Memory) GetJobIDsBySeriesID(seriesID string) ([]types.JobID, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var jobIDs []types.JobID
+	for _, jobID := range m.jobIDs {
+		if m.jobRequests[jobID].SeriesID == seriesID {
+			jobIDs = append(jobIDs, jobID)
+		}
+	}
+	return jobIDs, nil
 }
 
 // StoreJobReport stores a report associated to a job. Returns an error if there is
@@ -168,7 +404,8 @@ func (m *Memory) StoreJobReport(report *job.JobReport) error {
 	if ok {
 		return fmt.Errorf("job report already present for job id %v", report.JobID)
 	}
-	m.jobReports[report.JobID] = report
+	r := *report
+	m.jobReports[report.JobID] = &r
 	return nil
 }
 
@@ -176,11 +413,235 @@ func (m *Memory) StoreJobReport(report *job.JobReport) error {
 func (m *Memory) GetJobReport(jobID types.JobID) (*job.JobReport, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	if _, ok := m.jobReports[jobID]; !ok {
+	report, ok := m.jobReports[jobID]
+	if !ok {
 		// return a job report with no results
 		return &job.JobReport{JobID: jobID}, nil
 	}
-	return m.jobReports[jobID], nil
+	r := *report
+	return &r, nil
+}
+
+// StoreStepState creates or replaces the step state of a job. It is stored
+// encoded, so that callers do not share it.
+func (m *Memory) StoreStepState(jobID types.JobID, state *job.PauseSnapshot) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("could not encode step state: %v", err)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stepStates[jobID] = data
+	return nil
+}
+
+// GetStepState returns the step state of a job, or nil if there is none
+func (m *Memory) GetStepState(jobID types.JobID) (*job.PauseSnapshot, error) {
+	m.lock.Lock()
+	data, ok := m.stepStates[jobID]
+	m.lock.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	var state job.PauseSnapshot
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("could not decode step state: %v", err)
+	}
+	return &state, nil
+}
+
+// DeleteJobData deletes the test events, the report and the step state of a
+// job. The deleted
+// events leave empty slots, so that the sequence numbers of the other events
+// do not change.
+func (m *Memory) DeleteJobData(jobID types.JobID) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, event := range m.testEvents {
+		if event.Header != nil && event.Header.JobID == jobID {
+			m.testEvents[i] = testevent.Event{}
+		}
+	}
+	delete(m.jobReports, jobID)
+	delete(m.stepStates, jobID)
+	return nil
+}
+
+// StoreJobTemplate stores a new version of a job template
+func (m *Memory) StoreJobTemplate(template *job.Template) (uint, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t := *template
+	t.Version = uint(len(m.jobTemplates[t.Name])) + 1
+	m.jobTemplates[t.Name] = append(m.jobTemplates[t.Name], &t)
+	return t.Version, nil
+}
+
+// GetJobTemplate returns a version of a job template, or its latest version
+// if version is 0
+func (m *Memory) GetJobTemplate(name string, version uint) (*job.Template, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	versions := m.jobTemplates[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("could not find job template %q", name)
+	}
+	if version == 0 {
+		version = uint(len(versions))
+	}
+	if version > uint(len(versions)) {
+		return nil, fmt.Errorf("could not find version %d of job template %q", version, name)
+	}
+	t := *versions[version-1]
+	return &t, nil
+}
+
+// StoreJobSchedule stores a new job schedule
+func (m *Memory) StoreJobSchedule(schedule *job.Schedule) (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s := *schedule
+	s.ID = uint64(len(m.jobSchedules)) + 1
+	m.jobSchedules = append(m.jobSchedules, &s)
+	return s.ID, nil
+}
+
+// schedule returns the job schedule with the given ID. The lock must be held.
+func (m *Memory) schedule(id uint64) (*job.Schedule, error) {
+	if id == 0 || id > uint64(len(m.jobSchedules)) {
+		return nil, fmt.Errorf("could not find job schedule %d", id)
+	}
+	return m.jobSchedules[id-1], nil
+}
+
+// GetJobSchedule returns a job schedule
+func (m *Memory) GetJobSchedule(id uint64) (*job.Schedule, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.schedule(id)
+	if err != nil {
+		return nil, err
+	}
+	copied := *s
+	return &copied, nil
+}
+
+// GetJobSchedules returns all the job schedules
+func (m *Memory) GetJobSchedules() ([]*job.Schedule, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	schedules := make([]*job.Schedule, 0, len(m.jobSchedules))
+	for _, s := range m.jobSchedules {
+		copied := *s
+		schedules = append(schedules, &copied)
+	}
+	return schedules, nil
+}
+
+// ClaimJobScheduleRun moves the next run of an active schedule
+func (m *Memory) ClaimJobScheduleRun(id uint64, nextRun, followingRun time.Time) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.schedule(id)
+	if err != nil {
+		return false, err
+	}
+	if s.State != job.ScheduleActive || !s.NextRun.Equal(nextRun) {
+		return false, nil
+	}
+	s.NextRun = followingRun
+	return true, nil
+}
+
+// RecordJobScheduleRun records a job submitted by a schedule
+func (m *Memory) RecordJobScheduleRun(id uint64, jobID types.JobID) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.schedule(id)
+	if err != nil {
+		return err
+	}
+	s.Runs++
+	s.LastJobID = jobID
+	return nil
+}
+
+// SetJobScheduleState sets the state and the next run of a schedule
+func (m *Memory) SetJobScheduleState(id uint64, state job.ScheduleState, nextRun time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.schedule(id)
+	if err != nil {
+		return err
+	}
+	s.State = state
+	s.NextRun = nextRun
+	return nil
+}
+
+// StoreTargetOutcomes records the outcomes of a run on its targets
+func (m *Memory) StoreTargetOutcomes(outcomes []target.Outcome) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, o := range outcomes {
+		m.targetOutcomes[o.TargetID] = append(m.targetOutcomes[o.TargetID], o)
+	}
+	return nil
+}
+
+// GetTargetOutcomes returns the latest outcomes of a target
+func (m *Memory) GetTargetOutcomes(targetID string, since time.Time, limit int) ([]target.Outcome, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var outcomes []target.Outcome
+	all := m.targetOutcomes[targetID]
+	for i := len(all) - 1; i >= 0 && len(outcomes) < limit; i-- {
+		if !all[i].Time.Before(since) {
+			outcomes = append(outcomes, all[i])
+		}
+	}
+	return outcomes, nil
+}
+
+// StoreTargetHealth creates or replaces the health record of a target
+func (m *Memory) StoreTargetHealth(health *target.Health) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h := *health
+	m.targetHealth[h.TargetID] = &h
+	return nil
+}
+
+// GetTargetHealth returns the health record of a target
+func (m *Memory) GetTargetHealth(targetID string) (*target.Health, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, ok := m.targetHealth[targetID]
+	if !ok {
+		return &target.Health{TargetID: targetID}, nil
+	}
+	copied := *h
+	return &copied, nil
+}
+
+// GetQuarantinedTargets returns the health records of the quarantined targets
+func (m *Memory) GetQuarantinedTargets() ([]*target.Health, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var targets []*target.Health
+	for _, h := range m.targetHealth {
+		if h.Quarantined {
+			copied := *h
+			targets = append(targets, &copied)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if !targets[i].QuarantineTime.Equal(targets[j].QuarantineTime) {
+			return targets[i].QuarantineTime.Before(targets[j].QuarantineTime)
+		}
+		return targets[i].TargetID < targets[j].TargetID
+	})
+	return targets, nil
 }
 
 // StoreFrameworkEvent stores a framework event into the database
@@ -216,6 +677,9 @@ func New() storage.Storage {
 	m := Memory{lock: &sync.Mutex{}}
 	m.jobRequests = make(map[types.JobID]*job.Request)
 	m.jobReports = make(map[types.JobID]*job.JobReport)
-	m.jobIDCounter = 1
+	m.jobTemplates = make(map[string][]*job.Template)
+	m.targetOutcomes = make(map[string][]target.Outcome)
+	m.targetHealth = make(map[string]*target.Health)
+	m.stepStates = make(map[types.JobID][]byte)
 	return &m
 }
@@ -10,6 +10,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
@@ -39,7 +40,7 @@ func buildEventQuery(baseQuery bytes.Buffer, eventQuery *event.Query) ([]string,
 	selectClauses := []string{}
 	fields := []interface{}{}
 
-	if eventQuery != nil && eventQuery.JobID != 0 {
+	if eventQuery != nil && eventQuery.JobID != "" {
 		selectClauses = append(selectClauses, "job_id=?")
 		fields = append(fields, eventQuery.JobID)
 	}
@@ -103,6 +104,24 @@ func buildTestEventQuery(baseQuery bytes.Buffer, testEventQuery *testevent.Query
 		selectClauses = append(selectClauses, "test_step_label=?")
 		fields = append(fields, testEventQuery.TestStepLabel)
 	}
+	if testEventQuery != nil && len(testEventQuery.Severities) != 0 {
+		selectClauses = append(selectClauses, fmt.Sprintf("severity in (?%s)", strings.Repeat(", ?", len(testEventQuery.Severities)-1)))
+		for _, severity := range testEventQuery.Severities {
+			fields = append(fields, severity)
+		}
+	}
+	if testEventQuery != nil && len(testEventQuery.Measurements) != 0 {
+		selectClauses = append(selectClauses, fmt.Sprintf("measurement in (?%s)", strings.Repeat(", ?", len(testEventQuery.Measurements)-1)))
+		for _, measurement := range testEventQuery.Measurements {
+			fields = append(fields, measurement)
+		}
+	}
+	if testEventQuery != nil && len(testEventQuery.ResultStatuses) != 0 {
+		selectClauses = append(selectClauses, fmt.Sprintf("result_status in (?%s)", strings.Repeat(", ?", len(testEventQuery.ResultStatuses)-1)))
+		for _, status := range testEventQuery.ResultStatuses {
+			fields = append(fields, status)
+		}
+	}
 	query, err := assembleQuery(baseQuery, selectClauses)
 	if err != nil {
 		return "", nil, fmt.Errorf("could not assemble query for framework events: %v", err)
@@ -170,6 +189,69 @@ func TestEventTargetID(ev testevent.Event) interface{} {
 	return ev.Data.Target.ID
 }
 
+// TestEventSpanID returns the span ID from an events.TestEvent object
+func TestEventSpanID(ev testevent.Event) interface{} {
+	if ev.Data == nil || ev.Data.SpanID == "" {
+		return nil
+	}
+	return ev.Data.SpanID
+}
+
+// TestEventParentSpanID returns the parent span ID from an events.TestEvent object
+func TestEventParentSpanID(ev testevent.Event) interface{} {
+	if ev.Data == nil || ev.Data.ParentSpanID == "" {
+		return nil
+	}
+	return ev.Data.ParentSpanID
+}
+
+// TestEventSeverity returns the severity from an events.TestEvent object
+func TestEventSeverity(ev testevent.Event) interface{} {
+	if ev.Data == nil || ev.Data.Severity == "" {
+		return nil
+	}
+	return ev.Data.Severity
+}
+
+// TestEventRepeat returns the repeat count from an events.TestEvent object
+func TestEventRepeat(ev testevent.Event) interface{} {
+	if ev.Data == nil || ev.Data.Repeat == 0 {
+		return nil
+	}
+	return ev.Data.Repeat
+}
+
+// testEventResult returns the result carried by an events.TestEvent object,
+// or nil if it is not a valid result event
+func testEventResult(ev testevent.Event) *testevent.Result {
+	if ev.Data == nil || ev.Data.EventName != testevent.EventTargetResult {
+		return nil
+	}
+	result, err := ev.Data.Result()
+	if err != nil {
+		return nil
+	}
+	return result
+}
+
+// TestEventMeasurement returns the measurement of the result carried by an
+// events.TestEvent object
+func TestEventMeasurement(ev testevent.Event) interface{} {
+	if result := testEventResult(ev); result != nil {
+		return result.Measurement
+	}
+	return nil
+}
+
+// TestEventResultStatus returns the status of the result carried by an
+// events.TestEvent object
+func TestEventResultStatus(ev testevent.Event) interface{} {
+	if result := testEventResult(ev); result != nil {
+		return result.Status
+	}
+	return nil
+}
+
 // TestEventPayload returns the payload from an events.TestEvent object
 func TestEventPayload(ev testevent.Event) interface{} {
 	if ev.Data == nil {
@@ -214,7 +296,7 @@ func (r *RDBMS) FlushTestEvents() error {
 		return nil
 	}
 
-	insertStatement := "insert into test_events (job_id, run_id, test_name, test_step_label, event_name, target_name, target_id, payload, emit_time) values (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	insertStatement := "insert into test_events (job_id, run_id, test_name, test_step_label, event_name, target_name, target_id, span_id, parent_span_id, severity, repeat_count, measurement, result_status, payload, emit_time) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 	for _, event := range r.buffTestEvents {
 		_, err := r.db.Exec(
 			insertStatement,
@@ -225,6 +307,12 @@ func (r *RDBMS) FlushTestEvents() error {
 			TestEventName(event),
 			TestEventTargetName(event),
 			TestEventTargetID(event),
+			TestEventSpanID(event),
+			TestEventParentSpanID(event),
+			TestEventSeverity(event),
+			TestEventRepeat(event),
+			TestEventMeasurement(event),
+			TestEventResultStatus(event),
 			TestEventPayload(event),
 			TestEventEmitTime(event))
 		if err != nil {
@@ -237,6 +325,10 @@ func (r *RDBMS) FlushTestEvents() error {
 
 // GetTestEvents retrieves test events matching the query fields provided
 func (r *RDBMS) GetTestEvents(eventQuery *testevent.Query) ([]testevent.Event, error) {
+	return r.getTestEvents(eventQuery, r.testEventReadConsistency)
+}
+
+func (r *RDBMS) getTestEvents(eventQuery *testevent.Query, c ReadConsistency) ([]testevent.Event, error) {
 
 	if err := r.init(); err != nil {
 		return nil, fmt.Errorf("could not initialize database: %v", err)
@@ -252,17 +344,115 @@ func (r *RDBMS) GetTestEvents(eventQuery *testevent.Query) ([]testevent.Event, e
 	defer r.testEventsLock.Unlock()
 
 	baseQuery := bytes.Buffer{}
-	baseQuery.WriteString("select event_id, job_id, run_id, test_name, test_step_label, event_name, target_name, target_id, payload, emit_time from test_events")
+	baseQuery.WriteString(selectTestEvents)
+	query, fields, err := buildTestEventQuery(baseQuery, eventQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not execute select query for test events: %v", err)
+	}
+	results, _, err := r.queryTestEvents(c, query, fields)
+	return results, err
+}
+
+// GetTestEventsPage retrieves a page of the test events matching the query
+// fields provided. The sequence numbers of the events are their IDs in the
+// database, which are assigned in increasing order at insertion.
+func (r *RDBMS) GetTestEventsPage(eventQuery *testevent.Query, afterSequence uint64, limit int) (*testevent.Page, error) {
+	return r.getTestEventsPage(eventQuery, afterSequence, limit, r.testEventReadConsistency)
+}
+
+func (r *RDBMS) getTestEventsPage(eventQuery *testevent.Query, afterSequence uint64, limit int, c ReadConsistency) (*testevent.Page, error) {
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("could not initialize database: %v", err)
+	}
+
+	// Flush pending events before Get operations
+	if err := r.FlushTestEvents(); err != nil {
+		return nil, fmt.Errorf("could not flush events before reading events: %v", err)
+	}
+
+	r.testEventsLock.Lock()
+	defer r.testEventsLock.Unlock()
+
+	baseQuery := bytes.Buffer{}
+	baseQuery.WriteString(selectTestEvents)
 	query, fields, err := buildTestEventQuery(baseQuery, eventQuery)
 	if err != nil {
 		return nil, fmt.Errorf("could not execute select query for test events: %v", err)
 	}
+	// the query has at least one clause, and is ordered by event_id, so the
+	// cursor clause is inserted before the ordering.
+	query = strings.Replace(query, " order by event_id", " and event_id>? order by event_id limit ?", 1)
+	// one more event is fetched to tell whether there are more
+	fields = append(fields, afterSequence, limit+1)
+	results, ids, err := r.queryTestEvents(c, query, fields)
+	if err != nil {
+		return nil, err
+	}
+	page := testevent.Page{Events: results, LastSequence: afterSequence}
+	if len(results) > limit {
+		page.Events, page.More = results[:limit], true
+	}
+	if len(page.Events) > 0 {
+		page.LastSequence = ids[len(page.Events)-1]
+	}
+	return &page, nil
+}
+
+// CountTestEvents returns the number of test events of a job, by event name.
+// The events are counted by the database, including the repeats of the
+// collapsed events.
+func (r *RDBMS) CountTestEvents(jobID types.JobID) (map[event.Name]uint64, error) {
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("could not initialize database: %v", err)
+	}
+
+	// Flush pending events before Get operations
+	if err := r.FlushTestEvents(); err != nil {
+		return nil, fmt.Errorf("could not flush events before reading events: %v", err)
+	}
+
+	r.testEventsLock.Lock()
+	defer r.testEventsLock.Unlock()
+
+	query := "select event_name, sum(coalesce(repeat_count, 1)) from test_events where job_id=? group by event_name"
+	log.Debugf("Executing query: %s", query)
+	rows, err := r.reader(r.testEventReadConsistency).Query(query, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("could not count test events: %v", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Warningf("Failed to close rows from Query statement: %v", err)
+		}
+	}()
+	counts := make(map[event.Name]uint64)
+	for rows.Next() {
+		var (
+			name  event.Name
+			count uint64
+		)
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, fmt.Errorf("could not read test event count: %v", err)
+		}
+		counts[name] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read test event counts: %v", err)
+	}
+	return counts, nil
+}
 
+const selectTestEvents = "select event_id, job_id, run_id, test_name, test_step_label, event_name, target_name, target_id, span_id, parent_span_id, severity, repeat_count, payload, emit_time from test_events"
+
+// queryTestEvents runs a select query on the test events with the requested
+// read consistency, and returns them with their IDs.
+func (r *RDBMS) queryTestEvents(c ReadConsistency, query string, fields []interface{}) ([]testevent.Event, []uint64, error) {
 	results := []testevent.Event{}
+	var ids []uint64
 	log.Debugf("Executing query: %s", query)
-	rows, err := r.db.Query(query, fields...)
+	rows, err := r.reader(c).Query(query, fields...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	defer func() {
@@ -273,9 +463,13 @@ func (r *RDBMS) GetTestEvents(eventQuery *testevent.Query) ([]testevent.Event, e
 
 	// TargetName and TargetID might be null, so a type which supports null should be used with Scan
 	var (
-		targetName sql.NullString
-		targetID   sql.NullString
-		payload    sql.NullString
+		targetName   sql.NullString
+		targetID     sql.NullString
+		spanID       sql.NullString
+		parentSpanID sql.NullString
+		severity     sql.NullString
+		repeat       sql.NullInt64
+		payload      sql.NullString
 	)
 
 	for rows.Next() {
@@ -283,7 +477,7 @@ func (r *RDBMS) GetTestEvents(eventQuery *testevent.Query) ([]testevent.Event, e
 		header := testevent.Header{}
 		event := testevent.New(&header, &data)
 
-		var eventID int
+		var eventID uint64
 		err := rows.Scan(
 			&eventID,
 			&header.JobID,
@@ -293,17 +487,32 @@ func (r *RDBMS) GetTestEvents(eventQuery *testevent.Query) ([]testevent.Event, e
 			&data.EventName,
 			&targetName,
 			&targetID,
+			&spanID,
+			&parentSpanID,
+			&severity,
+			&repeat,
 			&payload,
 			&event.EmitTime,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("could not read results from db: %v", err)
+			return nil, nil, fmt.Errorf("could not read results from db: %v", err)
 		}
 		if targetName.Valid || targetID.Valid {
 			t := target.Target{Name: targetName.String, ID: targetID.String}
 			data.Target = &t
 		}
 
+		data.SpanID = testevent.SpanID(spanID.String)
+		data.ParentSpanID = testevent.SpanID(parentSpanID.String)
+		// events stored before severities were introduced are informational
+		data.Severity = testevent.SeverityInfo
+		if severity.Valid {
+			data.Severity = testevent.Severity(severity.String)
+		}
+		if repeat.Valid {
+			data.Repeat = uint64(repeat.Int64)
+		}
+
 		if payload.Valid {
 			rawPayload := json.RawMessage(payload.String)
 			data.Payload = &rawPayload
@@ -311,8 +520,9 @@ func (r *RDBMS) GetTestEvents(eventQuery *testevent.Query) ([]testevent.Event, e
 		}
 
 		results = append(results, event)
+		ids = append(ids, eventID)
 	}
-	return results, nil
+	return results, ids, nil
 }
 
 // FrameworkEventField is a function type which retrieves information from a FrameworkEvent object
@@ -0,0 +1,151 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rdbms
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/target"
+)
+
+const selectTargetHealth = "select target_id, quarantined, quarantine_time, failure_rate, release_time from target_health"
+
+func scanTargetHealth(row scanner) (*target.Health, error) {
+	var (
+		h              target.Health
+		quarantineTime sql.NullTime
+		releaseTime    sql.NullTime
+	)
+	if err := row.Scan(&h.TargetID, &h.Quarantined, &quarantineTime, &h.FailureRate, &releaseTime); err != nil {
+		return nil, err
+	}
+	h.QuarantineTime = quarantineTime.Time
+	h.ReleaseTime = releaseTime.Time
+	return &h, nil
+}
+
+// StoreTargetOutcomes stores the outcomes of a run in the database, with a
+// single statement
+func (r *RDBMS) StoreTargetOutcomes(outcomes []target.Outcome) error {
+	if len(outcomes) == 0 {
+		return nil
+	}
+	if err := r.init(); err != nil {
+		return fmt.Errorf("could not initialize database: %v", err)
+	}
+	values := make([]string, 0, len(outcomes))
+	args := make([]interface{}, 0, 5*len(outcomes))
+	for _, o := range outcomes {
+		values = append(values, "(?, ?, ?, ?, ?)")
+		args = append(args, o.TargetID, o.JobID, o.RunID, o.Success, o.Time)
+	}
+	insertStatement := "insert into target_outcomes (target_id, job_id, run_id, success, outcome_time) values " + strings.Join(values, ", ")
+	if _, err := r.db.Exec(insertStatement, args...); err != nil {
+		return fmt.Errorf("could not store target outcomes in database: %v", err)
+	}
+	return nil
+}
+
+// GetTargetOutcomes retrieves the latest outcomes of a target from the
+// database
+func (r *RDBMS) GetTargetOutcomes(targetID string, since time.Time, limit int) ([]target.Outcome, error) {
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("could not initialize database: %v", err)
+	}
+	selectStatement := "select target_id, job_id, run_id, success, outcome_time from target_outcomes where target_id = ? and outcome_time >= ? order by outcome_id desc limit ?"
+	log.Debugf("Executing query: %s", selectStatement)
+	rows, err := r.db.Query(selectStatement, targetID, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not get outcomes of target %s: %v", targetID, err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Warningf("failed to close rows from query statement: %v", err)
+		}
+	}()
+	var outcomes []target.Outcome
+	for rows.Next() {
+		var o target.Outcome
+		if err := rows.Scan(&o.TargetID, &o.JobID, &o.RunID, &o.Success, &o.Time); err != nil {
+			return nil, fmt.Errorf("could not get outcomes of target %s: %v", targetID, err)
+		}
+		outcomes = append(outcomes, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get outcomes of target %s: %v", targetID, err)
+	}
+	return outcomes, nil
+}
+
+// StoreTargetHealth creates or replaces the health record of a target in the
+// database
+func (r *RDBMS) StoreTargetHealth(health *target.Health) error {
+	if err := r.init(); err != nil {
+		return fmt.Errorf("could not initialize database: %v", err)
+	}
+	insertStatement := "replace into target_health (target_id, quarantined, quarantine_time, failure_rate, release_time) values (?, ?, ?, ?, ?)"
+	if _, err := r.db.Exec(insertStatement,
+		health.TargetID,
+		health.Quarantined,
+		nullTime(health.QuarantineTime),
+		health.FailureRate,
+		nullTime(health.ReleaseTime),
+	); err != nil {
+		return fmt.Errorf("could not store health of target %s in database: %v", health.TargetID, err)
+	}
+	return nil
+}
+
+// GetTargetHealth retrieves the health record of a target from the database
+func (r *RDBMS) GetTargetHealth(targetID string) (*target.Health, error) {
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("could not initialize database: %v", err)
+	}
+	selectStatement := selectTargetHealth + " where target_id = ?"
+	log.Debugf("Executing query: %s", selectStatement)
+	h, err := scanTargetHealth(r.db.QueryRow(selectStatement, targetID))
+	if err == sql.ErrNoRows {
+		return &target.Health{TargetID: targetID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get health of target %s: %v", targetID, err)
+	}
+	return h, nil
+}
+
+// GetQuarantinedTargets retrieves the health records of the quarantined
+// targets from the database
+func (r *RDBMS) GetQuarantinedTargets() ([]*target.Health, error) {
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("could not initialize database: %v", err)
+	}
+	selectStatement := selectTargetHealth + " where quarantined = true order by quarantine_time, target_id"
+	log.Debugf("Executing query: %s", selectStatement)
+	rows, err := r.db.Query(selectStatement)
+	if err != nil {
+		return nil, fmt.Errorf("could not get quarantined targets: %v", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Warningf("failed to close rows from query statement: %v", err)
+		}
+	}()
+	var targets []*target.Health
+	for rows.Next() {
+		h, err := scanTargetHealth(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not get quarantined targets: %v", err)
+		}
+		targets = append(targets, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get quarantined targets: %v", err)
+	}
+	return targets, nil
+}
@@ -46,6 +46,14 @@ type RDBMS struct {
 
 	dbURI string
 
+	// Read replicas of the primary database. Writes always go to the
+	// primary, reads are routed based on the requested consistency.
+	replicas                  []*sql.DB
+	replicaURIs               []string
+	replicaIdx                uint64
+	jobRequestReadConsistency ReadConsistency
+	testEventReadConsistency  ReadConsistency
+
 	// Events are buffered internally before being flushed to the database.
 	// Buffer size and flush interval are defined per-buffer, as there is
 	// a separate buffer for TestEvent and FrameworkEvent
@@ -83,6 +91,30 @@ func (r *RDBMS) Reset() error {
 	if err != nil {
 		return fmt.Errorf("could not truncate table final_reports: %v", err)
 	}
+	_, err = r.db.Exec("truncate job_templates")
+	if err != nil {
+		return fmt.Errorf("could not truncate table job_templates: %v", err)
+	}
+	_, err = r.db.Exec("truncate job_schedules")
+	if err != nil {
+		return fmt.Errorf("could not truncate table job_schedules: %v", err)
+	}
+	_, err = r.db.Exec("truncate target_outcomes")
+	if err != nil {
+		return fmt.Errorf("could not truncate table target_outcomes: %v", err)
+	}
+	_, err = r.db.Exec("truncate target_health")
+	if err != nil {
+		return fmt.Errorf("could not truncate table target_health: %v", err)
+	}
+	_, err = r.db.Exec("truncate step_states")
+	if err != nil {
+		return fmt.Errorf("could not truncate table step_states: %v", err)
+	}
+	_, err = r.db.Exec("truncate job_sequence")
+	if err != nil {
+		return fmt.Errorf("could not truncate table job_sequence: %v", err)
+	}
 	return nil
 }
 
@@ -97,6 +129,13 @@ func (r *RDBMS) init() error {
 			return fmt.Errorf("could not initialize database for events: %v", err)
 		}
 		r.db = db
+		for _, replicaURI := range r.replicaURIs {
+			replica, err := sql.Open(driverName, replicaURI)
+			if err != nil {
+				return fmt.Errorf("could not initialize read replica database: %v", err)
+			}
+			r.replicas = append(r.replicas, replica)
+		}
 		// Background goroutines for flushing pending events. The lifetime of the
 		// goroutines correspond to the lifetime of the framework.
 		go func() {
@@ -180,6 +219,7 @@ func New(dbURI string, opts ...Opt) storage.Storage {
 		testEventsFlushInterval:      defaultFlushInterval,
 		frameworkEventsFlushSize:     defaultFlushSize,
 		frameworkEventsFlushInterval: defaultFlushInterval,
+		jobRequestReadConsistency:    StrongConsistency,
 	}
 	for _, Opt := range opts {
 		Opt(&backend)
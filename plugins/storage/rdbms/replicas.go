@@ -0,0 +1,87 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rdbms
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+)
+
+// ReadConsistency defines which database a read operation is allowed to hit.
+type ReadConsistency int
+
+const (
+	// EventualConsistency allows a read to be served by one of the read replicas,
+	// tolerating replication lag. If no replica is configured, the primary is used.
+	EventualConsistency ReadConsistency = iota
+	// StrongConsistency forces a read to be served by the primary database.
+	StrongConsistency
+)
+
+// ParseReadConsistency parses a read consistency, either "eventual" or
+// "strong".
+func ParseReadConsistency(s string) (ReadConsistency, error) {
+	switch s {
+	case "eventual":
+		return EventualConsistency, nil
+	case "strong":
+		return StrongConsistency, nil
+	}
+	return 0, fmt.Errorf("invalid read consistency %q, must be eventual or strong", s)
+}
+
+// ReadReplicas configures the URIs of read replicas of the primary database.
+// Reads which tolerate replication lag are distributed across the replicas
+// in round-robin order, while writes always go to the primary database.
+func ReadReplicas(dbURIs ...string) Opt {
+	return func(rdbms *RDBMS) {
+		rdbms.replicaURIs = append(rdbms.replicaURIs, dbURIs...)
+	}
+}
+
+// JobRequestReadConsistency defines whether job request reads can be served
+// by a read replica or must be routed to the primary database. They go to the
+// primary by default, since the job manager reads the requests it just stored,
+// e.g. to report the status of a job right after starting it.
+func JobRequestReadConsistency(c ReadConsistency) Opt {
+	return func(rdbms *RDBMS) {
+		rdbms.jobRequestReadConsistency = c
+	}
+}
+
+// TestEventReadConsistency defines whether test event reads can be served
+// by a read replica or must be routed to the primary database.
+func TestEventReadConsistency(c ReadConsistency) Opt {
+	return func(rdbms *RDBMS) {
+		rdbms.testEventReadConsistency = c
+	}
+}
+
+// GetTestEventsStrong retrieves the test events matching the query from the
+// primary database, whatever the test event read consistency is. It is meant
+// for the reads which must observe the events the caller just stored.
+func (r *RDBMS) GetTestEventsStrong(eventQuery *testevent.Query) ([]testevent.Event, error) {
+	return r.getTestEvents(eventQuery, StrongConsistency)
+}
+
+// GetTestEventsPageStrong retrieves a page of the test events matching the
+// query from the primary database, like GetTestEventsStrong.
+func (r *RDBMS) GetTestEventsPageStrong(eventQuery *testevent.Query, afterSequence uint64, limit int) (*testevent.Page, error) {
+	return r.getTestEventsPage(eventQuery, afterSequence, limit, StrongConsistency)
+}
+
+// reader returns the database that should serve a read with the requested
+// consistency. Replicas are selected in round-robin order.
+func (r *RDBMS) reader(c ReadConsistency) *sql.DB {
+	if c == StrongConsistency || len(r.replicas) == 0 {
+		return r.db
+	}
+	idx := atomic.AddUint64(&r.replicaIdx, 1) - 1
+	return r.replicas[idx%uint64(len(r.replicas))]
+}
@@ -0,0 +1,251 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rdbms
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingDriver is a database/sql driver which does not talk to any
+// database, but records which DSN every statement was sent to.
+type recordingDriver struct {
+	lock    sync.Mutex
+	queries map[string]int
+	execs   map[string]int
+	// failArg makes the statements with this argument fail, if not empty.
+	failArg   string
+	commits   int
+	rollbacks int
+}
+
+func (d *recordingDriver) Open(dsn string) (driver.Conn, error) {
+	return &recordingConn{driver: d, dsn: dsn}, nil
+}
+
+func (d *recordingDriver) record(m map[string]int, dsn string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	m[dsn]++
+}
+
+func (d *recordingDriver) reset() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.queries = make(map[string]int)
+	d.execs = make(map[string]int)
+	d.failArg = ""
+	d.commits, d.rollbacks = 0, 0
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+	dsn    string
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{conn: c}, nil
+}
+
+func (c *recordingConn) Close() error { return nil }
+
+func (c *recordingConn) Begin() (driver.Tx, error) { return c, nil }
+
+func (c *recordingConn) Commit() error {
+	c.driver.lock.Lock()
+	defer c.driver.lock.Unlock()
+	c.driver.commits++
+	return nil
+}
+
+func (c *recordingConn) Rollback() error {
+	c.driver.lock.Lock()
+	defer c.driver.lock.Unlock()
+	c.driver.rollbacks++
+	return nil
+}
+
+type recordingStmt struct {
+	conn *recordingConn
+}
+
+func (s *recordingStmt) Close() error { return nil }
+
+func (s *recordingStmt) NumInput() int { return -1 }
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.driver.record(s.conn.driver.execs, s.conn.dsn)
+	s.conn.driver.lock.Lock()
+	defer s.conn.driver.lock.Unlock()
+	for _, arg := range args {
+		if failArg := s.conn.driver.failArg; failArg != "" && arg == failArg {
+			return nil, errors.New("statement failed")
+		}
+	}
+	return recordingResult{}, nil
+}
+
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.conn.driver.record(s.conn.driver.queries, s.conn.dsn)
+	return emptyRows{}, nil
+}
+
+type recordingResult struct{}
+
+func (recordingResult) LastInsertId() (int64, error) { return 1, nil }
+
+func (recordingResult) RowsAffected() (int64, error) { return 1, nil }
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string { return nil }
+
+func (emptyRows) Close() error { return nil }
+
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+var testDriver = &recordingDriver{}
+
+func init() {
+	testDriver.reset()
+	sql.Register("rdbms_recording", testDriver)
+}
+
+func jobQuery(t *testing.T) *testevent.Query {
+	query, err := testevent.BuildQuery(testevent.QueryJobID("1"))
+	require.NoError(t, err)
+	return query
+}
+
+func newTestRDBMS(opts ...Opt) *RDBMS {
+	testDriver.reset()
+	opts = append([]Opt{DriverName("rdbms_recording")}, opts...)
+	return New("primary", opts...).(*RDBMS)
+}
+
+func TestReadsWithoutReplicasGoToPrimary(t *testing.T) {
+	r := newTestRDBMS()
+
+	_, err := r.GetTestEvents(&testevent.Query{})
+	require.Error(t, err)
+	_, err = r.GetTestEvents(jobQuery(t))
+	require.NoError(t, err)
+	_, err = r.GetJobRequest(types.JobID("1"))
+	require.Error(t, err)
+
+	require.Equal(t, map[string]int{"primary": 2}, testDriver.queries)
+}
+
+func TestReadsAreDistributedAcrossReplicas(t *testing.T) {
+	r := newTestRDBMS(ReadReplicas("replica1", "replica2"), JobRequestReadConsistency(EventualConsistency))
+
+	for i := 0; i < 4; i++ {
+		_, err := r.GetTestEvents(jobQuery(t))
+		require.NoError(t, err)
+	}
+	for i := 0; i < 2; i++ {
+		_, _ = r.GetJobRequest(types.JobID("1"))
+	}
+	require.Equal(t, map[string]int{"replica1": 3, "replica2": 3}, testDriver.queries)
+}
+
+func TestWritesGoToPrimary(t *testing.T) {
+	r := newTestRDBMS(ReadReplicas("replica1", "replica2"), TestEventsFlushSize(1))
+
+	_, err := r.StoreJobRequest(&job.Request{JobID: "1", JobName: "test", RequestTime: time.Now()})
+	require.NoError(t, err)
+	header := testevent.Header{JobID: "1", RunID: 1}
+	require.NoError(t, r.StoreTestEvent(testevent.New(&header, &testevent.Data{EventName: "TestEvent"})))
+
+	require.Equal(t, map[string]int{"primary": 2}, testDriver.execs)
+	require.Empty(t, testDriver.queries)
+}
+
+func TestJobRequestReadsGoToPrimaryByDefault(t *testing.T) {
+	r := newTestRDBMS(ReadReplicas("replica1"))
+
+	_, _ = r.GetJobRequest(types.JobID("1"))
+	_, err := r.GetTestEvents(jobQuery(t))
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]int{"primary": 1, "replica1": 1}, testDriver.queries)
+}
+
+func TestTestEventStrongConsistency(t *testing.T) {
+	r := newTestRDBMS(
+		ReadReplicas("replica1"),
+		JobRequestReadConsistency(EventualConsistency),
+		TestEventReadConsistency(StrongConsistency),
+	)
+
+	_, err := r.GetTestEvents(jobQuery(t))
+	require.NoError(t, err)
+	_, _ = r.GetJobRequest(types.JobID("1"))
+
+	require.Equal(t, map[string]int{"primary": 1, "replica1": 1}, testDriver.queries)
+}
+
+func TestStrongReadsGoToPrimary(t *testing.T) {
+	r := newTestRDBMS(ReadReplicas("replica1", "replica2"), TestEventReadConsistency(EventualConsistency))
+
+	_, err := r.GetTestEventsStrong(jobQuery(t))
+	require.NoError(t, err)
+	_, err = r.GetTestEventsPageStrong(jobQuery(t), 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"primary": 2}, testDriver.queries)
+
+	_, err = r.GetTestEvents(jobQuery(t))
+	require.NoError(t, err)
+	_, err = r.GetTestEventsPage(jobQuery(t), 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"primary": 2, "replica1": 1, "replica2": 1}, testDriver.queries)
+}
+
+func TestStrongFetchersGoToPrimary(t *testing.T) {
+	r := newTestRDBMS(ReadReplicas("replica1"), TestEventReadConsistency(EventualConsistency))
+	storage.SetStorage(r)
+	noop := func(testevent.Event) error { return nil }
+
+	for _, tc := range []struct {
+		name    string
+		fetcher testevent.Fetcher
+		want    map[string]int
+	}{
+		{name: "eventual", fetcher: storage.NewTestEventFetcher(), want: map[string]int{"replica1": 2}},
+		{name: "strong", fetcher: storage.NewStrongTestEventFetcher(), want: map[string]int{"primary": 2}},
+		{name: "strong emitter", fetcher: storage.NewStrongTestEventEmitterFetcher(testevent.Header{JobID: "1"}), want: map[string]int{"primary": 2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testDriver.reset()
+			_, err := tc.fetcher.Fetch(testevent.QueryJobID("1"))
+			require.NoError(t, err)
+			require.NoError(t, testevent.Stream(tc.fetcher, noop, testevent.QueryJobID("1")))
+			require.Equal(t, tc.want, testDriver.queries)
+		})
+	}
+}
+
+func TestParseReadConsistency(t *testing.T) {
+	c, err := ParseReadConsistency("strong")
+	require.NoError(t, err)
+	require.Equal(t, StrongConsistency, c)
+	c, err = ParseReadConsistency("eventual")
+	require.NoError(t, err)
+	require.Equal(t, EventualConsistency, c)
+	_, err = ParseReadConsistency("Strong")
+	require.Error(t, err)
+}
@@ -78,7 +78,7 @@ func (r *RDBMS) GetJobReport(jobID types.JobID) (*job.JobReport, error) {
 	var lastRunID, currentRunID uint
 	for rows.Next() {
 		if err := rows.Err(); err != nil {
-			return nil, fmt.Errorf("could not fetch run report for job %d: %v", jobID, err)
+			return nil, fmt.Errorf("could not fetch run report for job %s: %v", jobID, err)
 		}
 		var (
 			report job.Report
@@ -92,7 +92,7 @@ func (r *RDBMS) GetJobReport(jobID types.JobID) (*job.JobReport, error) {
 			&data,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan row while fetching run report for job %d: %v", jobID, err)
+			return nil, fmt.Errorf("failed to scan row while fetching run report for job %s: %v", jobID, err)
 		}
 		if err := json.Unmarshal([]byte(data), &report.Data); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal run report JSON data: %v", err)
@@ -105,7 +105,7 @@ func (r *RDBMS) GetJobReport(jobID types.JobID) (*job.JobReport, error) {
 			return nil, errors.New("invalid run_id in database, cannot be zero")
 		}
 		if currentRunID < lastRunID || currentRunID > lastRunID+1 {
-			return nil, fmt.Errorf("invalid run_id retrieved from database: either it is not ordered, or there is a gap in run numbers in the database for job %d. Current run number: %d, last run number: %d",
+			return nil, fmt.Errorf("invalid run_id retrieved from database: either it is not ordered, or there is a gap in run numbers in the database for job %s. Current run number: %d, last run number: %d",
 				jobID, currentRunID, lastRunID,
 			)
 		}
@@ -137,7 +137,7 @@ func (r *RDBMS) GetJobReport(jobID types.JobID) (*job.JobReport, error) {
 	}()
 	for rows.Next() {
 		if err := rows.Err(); err != nil {
-			return nil, fmt.Errorf("could not fetch final report for job %d: %v", jobID, err)
+			return nil, fmt.Errorf("could not fetch final report for job %s: %v", jobID, err)
 		}
 		var (
 			report job.Report
@@ -150,7 +150,7 @@ func (r *RDBMS) GetJobReport(jobID types.JobID) (*job.JobReport, error) {
 			&data,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan row while fetching final report for job %d: %v", jobID, err)
+			return nil, fmt.Errorf("failed to scan row while fetching final report for job %s: %v", jobID, err)
 		}
 		if err := json.Unmarshal([]byte(data), &report.Data); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal final report JSON data: %v", err)
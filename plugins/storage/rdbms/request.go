@@ -6,31 +6,113 @@
 package rdbms
 
 import (
+	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/storage"
 	"github.com/facebookincubator/contest/pkg/types"
 )
 
-// StoreJobRequest stores a new job request in the database
+// execer is implemented by both the database and its transactions.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// NextJobSequence returns the next value of the job sequence, which is shared
+// by all the servers using the database.
+func (r *RDBMS) NextJobSequence() (uint64, error) {
+	if err := r.init(); err != nil {
+		return 0, fmt.Errorf("could not initialize database: %v", err)
+	}
+	return nextJobSequence(r.db)
+}
+
+func nextJobSequence(db execer) (uint64, error) {
+	result, err := db.Exec("insert into job_sequence () values ()")
+	if err != nil {
+		return 0, fmt.Errorf("could not increment job sequence: %v", err)
+	}
+	value, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("could not extract value of job sequence: %v", err)
+	}
+	return uint64(value), nil
+}
+
+// StoreJobRequest stores a new job request in the database. Requests without
+// a job ID are assigned the next value of the job sequence.
 func (r *RDBMS) StoreJobRequest(request *job.Request) (types.JobID, error) {
 
-	var jobID types.JobID
+	if err := r.init(); err != nil {
+		return "", fmt.Errorf("could not initialize database: %v", err)
+	}
+	if err := storeJobRequest(r.db, request); err != nil {
+		return "", err
+	}
+	return request.JobID, nil
+}
+
+// StoreJobRequests stores new job requests in a single transaction.
+func (r *RDBMS) StoreJobRequests(requests []*job.Request) ([]types.JobID, error) {
 
 	if err := r.init(); err != nil {
-		return jobID, fmt.Errorf("could not initialize database: %v", err)
+		return nil, fmt.Errorf("could not initialize database: %v", err)
 	}
-	insertStatement := "insert into jobs (name, descriptor, requestor, request_time) values (?, ?, ?, ?)"
-	result, err := r.db.Exec(insertStatement, request.JobName, request.JobDescriptor, request.Requestor, request.RequestTime)
+	tx, err := r.db.Begin()
 	if err != nil {
-		return jobID, fmt.Errorf("could not store job request in database: %v", err)
+		return nil, fmt.Errorf("could not begin transaction: %v", err)
 	}
-	lastID, err := result.LastInsertId()
-	if err != nil {
-		return jobID, fmt.Errorf("could not extract id of last request inserted into db")
+	var assigned []*job.Request
+	reset := func() {
+		// the sequence values allocated within the transaction are skipped
+		for _, request := range assigned {
+			request.JobID = ""
+		}
 	}
-	jobID = types.JobID(lastID)
-	return jobID, nil
+	rollback := func(err error) ([]types.JobID, error) {
+		reset()
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return nil, fmt.Errorf("%v (could not roll back transaction: %v)", err, rbErr)
+		}
+		return nil, err
+	}
+	jobIDs := make([]types.JobID, 0, len(requests))
+	for _, request := range requests {
+		if request.JobID == "" {
+			assigned = append(assigned, request)
+		}
+		if err := storeJobRequest(tx, request); err != nil {
+			return rollback(err)
+		}
+		jobIDs = append(jobIDs, request.JobID)
+	}
+	if err := tx.Commit(); err != nil {
+		reset()
+		return nil, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return jobIDs, nil
+}
+
+func storeJobRequest(db execer, request *job.Request) error {
+	if request.JobID == "" {
+		value, err := nextJobSequence(db)
+		if err != nil {
+			return err
+		}
+		request.JobID = types.JobID(strconv.FormatUint(value, 10))
+	}
+	// the external and series IDs are optional, store NULL rather than an
+	// empty string
+	externalID := sql.NullString{String: request.ExternalID, Valid: request.ExternalID != ""}
+	seriesID := sql.NullString{String: request.SeriesID, Valid: request.SeriesID != ""}
+	insertStatement := "insert into jobs (job_id, name, external_id, series_id, descriptor, requestor, request_time) values (?, ?, ?, ?, ?, ?, ?)"
+	if _, err := db.Exec(insertStatement, request.JobID, request.JobName, externalID, seriesID, request.JobDescriptor, request.Requestor, request.RequestTime); err != nil {
+		return fmt.Errorf("could not store job request in database: %v", err)
+	}
+	return nil
 }
 
 // GetJobRequest retrieves a JobRequest from the database
@@ -40,9 +122,9 @@ func (r *RDBMS) GetJobRequest(jobID types.JobID) (*job.Request, error) {
 		return nil, fmt.Errorf("could not initialize database: %v", err)
 	}
 
-	selectStatement := "select job_id, name, requestor, request_time, descriptor from jobs where job_id = ?"
+	selectStatement := "select job_id, name, external_id, series_id, requestor, request_time, descriptor from jobs where job_id = ?"
 	log.Debugf("Executing query: %s", selectStatement)
-	rows, err := r.db.Query(selectStatement, jobID)
+	rows, err := r.reader(r.jobRequestReadConsistency).Query(selectStatement, jobID)
 	if err != nil {
 		return nil, fmt.Errorf("could not get job request with id %v: %v", jobID, err)
 	}
@@ -60,9 +142,12 @@ func (r *RDBMS) GetJobRequest(jobID types.JobID) (*job.Request, error) {
 			return nil, fmt.Errorf("multiple requests found with job id %v", jobID)
 		}
 		currRequest := job.Request{}
+		var externalID, seriesID sql.NullString
 		err := rows.Scan(
 			&currRequest.JobID,
 			&currRequest.JobName,
+			&externalID,
+			&seriesID,
 			&currRequest.Requestor,
 			&currRequest.RequestTime,
 			&currRequest.JobDescriptor,
@@ -70,11 +155,122 @@ func (r *RDBMS) GetJobRequest(jobID types.JobID) (*job.Request, error) {
 		if err != nil {
 			return nil, fmt.Errorf("could not get job request with job id %v: %v", jobID, err)
 		}
+		currRequest.ExternalID = externalID.String
+		currRequest.SeriesID = seriesID.String
 		req = &currRequest
 	}
 
 	if req == nil {
-		return nil, fmt.Errorf("could not find request with JobID %d", jobID)
+		return nil, &storage.ErrJobRequestNotFound{JobID: jobID}
 	}
 	return req, nil
 }
+
+// GetJobRequests retrieves the job requests matching the query from the
+// database, ordered by request time. The state of a job is the name of its
+// last state event in the framework events.
+func (r *RDBMS) GetJobRequests(query job.JobQuery) ([]*job.Request, error) {
+
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("could not initialize database: %v", err)
+	}
+
+	selectStatement := "select j.job_id, j.name, j.external_id, j.series_id, j.requestor, j.request_time, j.descriptor from jobs j"
+	var fields []interface{}
+	if len(query.States) > 0 {
+		selectStatement += fmt.Sprintf(
+			" where coalesce((select e.event_name from framework_events e where e.job_id = j.job_id and e.event_name in (?%s) order by e.event_id desc limit 1), '') in (?%s)",
+			strings.Repeat(", ?", len(job.StateEvents)-1),
+			strings.Repeat(", ?", len(query.States)-1),
+		)
+		for _, name := range job.StateEvents {
+			fields = append(fields, name)
+		}
+		for _, state := range query.States {
+			fields = append(fields, state)
+		}
+	}
+	selectStatement += " order by j.request_time, j.submission_id limit ? offset ?"
+	fields = append(fields, query.Limit, query.Offset)
+	log.Debugf("Executing query: %s, fields: %v", selectStatement, fields)
+	rows, err := r.reader(r.jobRequestReadConsistency).Query(selectStatement, fields...)
+	if err != nil {
+		return nil, fmt.Errorf("could not get job requests: %v", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Warningf("failed to close rows from query statement: %v", err)
+		}
+	}()
+
+	var requests []*job.Request
+	for rows.Next() {
+		req := job.Request{}
+		var externalID, seriesID sql.NullString
+		err := rows.Scan(
+			&req.JobID,
+			&req.JobName,
+			&externalID,
+			&seriesID,
+			&req.Requestor,
+			&req.RequestTime,
+			&req.JobDescriptor,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not get job requests: %v", err)
+		}
+		req.ExternalID = externalID.String
+		req.SeriesID = seriesID.String
+		requests = append(requests, &req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get job requests: %v", err)
+	}
+	return requests, nil
+}
+
+// GetJobIDsByExternalID retrieves the IDs of the jobs submitted with the given
+// external ID, in submission order
+func (r *RDBMS) GetJobIDsByExternalID(externalID string) ([]types.JobID, error) {
+	return r.jobIDsBy("external_id", "external id", externalID)
+}
+
+// GetJobIDsBySeriesID retrieves the IDs of the jobs of a series, in submission
+// order
+func (r *RDBMS) GetJobIDsBySeriesID(seriesID string) ([]types.JobID, error) {
+	return r.jobIDsBy("series_id", "series id", seriesID)
+}
+
+// jobIDsBy retrieves the IDs of the jobs whose column has the given value, in
+// submission order. column is never user input.
+func (r *RDBMS) jobIDsBy(column, description, value string) ([]types.JobID, error) {
+
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("could not initialize database: %v", err)
+	}
+
+	selectStatement := "select job_id from jobs where " + column + " = ? order by submission_id"
+	log.Debugf("Executing query: %s", selectStatement)
+	rows, err := r.reader(r.jobRequestReadConsistency).Query(selectStatement, value)
+	if err != nil {
+		return nil, fmt.Errorf("could not get jobs with %s %q: %v", description, value, err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Warningf("failed to close rows from query statement: %v", err)
+		}
+	}()
+
+	var jobIDs []types.JobID
+	for rows.Next() {
+		var jobID types.JobID
+		if err := rows.Scan(&jobID); err != nil {
+			return nil, fmt.Errorf("could not get jobs with %s %q: %v", description, value, err)
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get jobs with %s %q: %v", description, value, err)
+	}
+	return jobIDs, nil
+}
@@ -0,0 +1,39 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rdbms
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreJobRequestsRollsBackOnFailure(t *testing.T) {
+	r := newTestRDBMS()
+	testDriver.failArg = "invalid"
+	batch := []*job.Request{
+		{JobName: "A", RequestTime: time.Now()},
+		{JobID: "custom", JobName: "B", RequestTime: time.Now()},
+		{JobName: "invalid", RequestTime: time.Now()},
+	}
+	_, err := r.StoreJobRequests(batch)
+	require.Error(t, err)
+	// nothing is committed, and the sequence values are not kept
+	require.Equal(t, 0, testDriver.commits)
+	require.Equal(t, 1, testDriver.rollbacks)
+	require.Equal(t, types.JobID(""), batch[0].JobID)
+	require.Equal(t, types.JobID("custom"), batch[1].JobID)
+	require.Equal(t, types.JobID(""), batch[2].JobID)
+
+	testDriver.failArg = ""
+	_, err = r.StoreJobRequests(batch[:2])
+	require.NoError(t, err)
+	require.Equal(t, 1, testDriver.commits)
+	require.Equal(t, 1, testDriver.rollbacks)
+}
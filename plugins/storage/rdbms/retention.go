@@ -0,0 +1,52 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rdbms
+
+import (
+	"fmt"
+
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// deleteBatchSize bounds the number of test events deleted by each
+// statement, so that pruning a large job does not hold long locks on the
+// table.
+const deleteBatchSize = 10000
+
+// DeleteJobData deletes the test events, the reports and the step state of a
+// job.
+func (r *RDBMS) DeleteJobData(jobID types.JobID) error {
+	if err := r.init(); err != nil {
+		return fmt.Errorf("could not initialize database: %v", err)
+	}
+	// the pending events of the job would be stored after the deletion
+	if err := r.FlushTestEvents(); err != nil {
+		return fmt.Errorf("could not flush events before deleting events: %v", err)
+	}
+	for {
+		res, err := r.db.Exec("delete from test_events where job_id = ? limit ?", jobID, deleteBatchSize)
+		if err != nil {
+			return fmt.Errorf("could not delete test events of job %s: %v", jobID, err)
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("could not delete test events of job %s: %v", jobID, err)
+		}
+		if deleted < deleteBatchSize {
+			break
+		}
+	}
+	if _, err := r.db.Exec("delete from run_reports where job_id = ?", jobID); err != nil {
+		return fmt.Errorf("could not delete run reports of job %s: %v", jobID, err)
+	}
+	if _, err := r.db.Exec("delete from final_reports where job_id = ?", jobID); err != nil {
+		return fmt.Errorf("could not delete final reports of job %s: %v", jobID, err)
+	}
+	if _, err := r.db.Exec("delete from step_states where job_id = ?", jobID); err != nil {
+		return fmt.Errorf("could not delete step state of job %s: %v", jobID, err)
+	}
+	return nil
+}
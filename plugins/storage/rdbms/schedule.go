@@ -0,0 +1,162 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rdbms
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/insomniacslk/xjson"
+)
+
+const selectJobSchedules = "select schedule_id, cron, run_interval, descriptor, requestor, create_time, state, next_run, runs, last_job_id from job_schedules"
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// scanner is implemented by both sql.Row and sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJobSchedule(row scanner) (*job.Schedule, error) {
+	var (
+		s         job.Schedule
+		cron      sql.NullString
+		interval  sql.NullInt64
+		nextRun   sql.NullTime
+		lastJobID sql.NullString
+		state     string
+	)
+	if err := row.Scan(&s.ID, &cron, &interval, &s.JobDescriptor, &s.Requestor, &s.CreateTime, &state, &nextRun, &s.Runs, &lastJobID); err != nil {
+		return nil, err
+	}
+	s.Cron = cron.String
+	s.Interval = xjson.Duration(interval.Int64)
+	s.State = job.ScheduleState(state)
+	s.NextRun = nextRun.Time
+	s.LastJobID = types.JobID(lastJobID.String)
+	return &s, nil
+}
+
+// StoreJobSchedule stores a new job schedule in the database
+func (r *RDBMS) StoreJobSchedule(schedule *job.Schedule) (uint64, error) {
+	if err := r.init(); err != nil {
+		return 0, fmt.Errorf("could not initialize database: %v", err)
+	}
+	insertStatement := "insert into job_schedules (cron, run_interval, descriptor, requestor, create_time, state, next_run) values (?, ?, ?, ?, ?, ?, ?)"
+	result, err := r.db.Exec(insertStatement,
+		sql.NullString{String: schedule.Cron, Valid: schedule.Cron != ""},
+		sql.NullInt64{Int64: int64(schedule.Interval), Valid: schedule.Interval != 0},
+		schedule.JobDescriptor,
+		schedule.Requestor,
+		schedule.CreateTime,
+		schedule.State,
+		nullTime(schedule.NextRun),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("could not store job schedule in database: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("could not extract id of last schedule inserted into db")
+	}
+	return uint64(id), nil
+}
+
+// GetJobSchedule retrieves a job schedule from the database
+func (r *RDBMS) GetJobSchedule(id uint64) (*job.Schedule, error) {
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("could not initialize database: %v", err)
+	}
+	selectStatement := selectJobSchedules + " where schedule_id = ?"
+	log.Debugf("Executing query: %s", selectStatement)
+	s, err := scanJobSchedule(r.db.QueryRow(selectStatement, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("could not find job schedule %d", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get job schedule %d: %v", id, err)
+	}
+	return s, nil
+}
+
+// GetJobSchedules retrieves all the job schedules from the database
+func (r *RDBMS) GetJobSchedules() ([]*job.Schedule, error) {
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("could not initialize database: %v", err)
+	}
+	selectStatement := selectJobSchedules + " order by schedule_id"
+	log.Debugf("Executing query: %s", selectStatement)
+	rows, err := r.db.Query(selectStatement)
+	if err != nil {
+		return nil, fmt.Errorf("could not get job schedules: %v", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Warningf("failed to close rows from query statement: %v", err)
+		}
+	}()
+	var schedules []*job.Schedule
+	for rows.Next() {
+		s, err := scanJobSchedule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not get job schedules: %v", err)
+		}
+		schedules = append(schedules, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get job schedules: %v", err)
+	}
+	return schedules, nil
+}
+
+// ClaimJobScheduleRun moves the next run of an active schedule. The update is
+// conditional, so that only one server claims each run.
+func (r *RDBMS) ClaimJobScheduleRun(id uint64, nextRun, followingRun time.Time) (bool, error) {
+	if err := r.init(); err != nil {
+		return false, fmt.Errorf("could not initialize database: %v", err)
+	}
+	updateStatement := "update job_schedules set next_run = ? where schedule_id = ? and state = ? and next_run = ?"
+	result, err := r.db.Exec(updateStatement, nullTime(followingRun), id, job.ScheduleActive, nextRun)
+	if err != nil {
+		return false, fmt.Errorf("could not update job schedule %d: %v", id, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not get number of job schedules updated: %v", err)
+	}
+	return updated == 1, nil
+}
+
+// RecordJobScheduleRun records a job submitted by a schedule
+func (r *RDBMS) RecordJobScheduleRun(id uint64, jobID types.JobID) error {
+	if err := r.init(); err != nil {
+		return fmt.Errorf("could not initialize database: %v", err)
+	}
+	updateStatement := "update job_schedules set runs = runs + 1, last_job_id = ? where schedule_id = ?"
+	if _, err := r.db.Exec(updateStatement, jobID, id); err != nil {
+		return fmt.Errorf("could not update job schedule %d: %v", id, err)
+	}
+	return nil
+}
+
+// SetJobScheduleState sets the state and the next run of a schedule
+func (r *RDBMS) SetJobScheduleState(id uint64, state job.ScheduleState, nextRun time.Time) error {
+	if err := r.init(); err != nil {
+		return fmt.Errorf("could not initialize database: %v", err)
+	}
+	updateStatement := "update job_schedules set state = ?, next_run = ? where schedule_id = ?"
+	if _, err := r.db.Exec(updateStatement, state, nullTime(nextRun), id); err != nil {
+		return fmt.Errorf("could not update job schedule %d: %v", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rdbms
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// StoreStepState creates or replaces the step state of a job in the
+// database
+func (r *RDBMS) StoreStepState(jobID types.JobID, state *job.PauseSnapshot) error {
+	if err := r.init(); err != nil {
+		return fmt.Errorf("could not initialize database: %v", err)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("could not encode step state of job %s: %v", jobID, err)
+	}
+	insertStatement := "replace into step_states (job_id, state, update_time) values (?, ?, ?)"
+	if _, err := r.db.Exec(insertStatement, jobID, data, time.Now()); err != nil {
+		return fmt.Errorf("could not store step state of job %s in database: %v", jobID, err)
+	}
+	return nil
+}
+
+// GetStepState retrieves the step state of a job from the database. It is
+// read from the primary, since it is read right after a restart.
+func (r *RDBMS) GetStepState(jobID types.JobID) (*job.PauseSnapshot, error) {
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("could not initialize database: %v", err)
+	}
+	selectStatement := "select state from step_states where job_id = ?"
+	log.Debugf("Executing query: %s", selectStatement)
+	var data []byte
+	err := r.db.QueryRow(selectStatement, jobID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get step state of job %s: %v", jobID, err)
+	}
+	var state job.PauseSnapshot
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("could not decode step state of job %s: %v", jobID, err)
+	}
+	return &state, nil
+}
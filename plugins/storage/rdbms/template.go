@@ -0,0 +1,70 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rdbms
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/facebookincubator/contest/pkg/job"
+)
+
+// StoreJobTemplate stores a new version of a job template in the database
+func (r *RDBMS) StoreJobTemplate(template *job.Template) (uint, error) {
+	if err := r.init(); err != nil {
+		return 0, fmt.Errorf("could not initialize database: %v", err)
+	}
+	// the version is computed by the insert itself. Concurrent inserts of the
+	// same version are rejected by the unique index on name and version.
+	insertStatement := "insert into job_templates (name, version, descriptor, requestor, request_time) " +
+		"select ?, coalesce(max(version), 0) + 1, ?, ?, ? from job_templates where name = ?"
+	result, err := r.db.Exec(insertStatement, template.Name, template.Descriptor, template.Requestor, template.RequestTime, template.Name)
+	if err != nil {
+		return 0, fmt.Errorf("could not store job template in database: %v", err)
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("could not extract id of last template inserted into db")
+	}
+	var version uint
+	if err := r.db.QueryRow("select version from job_templates where template_id = ?", lastID).Scan(&version); err != nil {
+		return 0, fmt.Errorf("could not get version of job template %q: %v", template.Name, err)
+	}
+	return version, nil
+}
+
+// GetJobTemplate retrieves a version of a job template from the database, or
+// its latest version if version is 0
+func (r *RDBMS) GetJobTemplate(name string, version uint) (*job.Template, error) {
+	if err := r.init(); err != nil {
+		return nil, fmt.Errorf("could not initialize database: %v", err)
+	}
+	selectStatement := "select name, version, descriptor, requestor, request_time from job_templates where name = ? and version = ?"
+	args := []interface{}{name, version}
+	if version == 0 {
+		selectStatement = "select name, version, descriptor, requestor, request_time from job_templates where name = ? order by version desc limit 1"
+		args = args[:1]
+	}
+	log.Debugf("Executing query: %s", selectStatement)
+	var t job.Template
+	err := r.reader(r.jobRequestReadConsistency).QueryRow(selectStatement, args...).Scan(
+		&t.Name,
+		&t.Version,
+		&t.Descriptor,
+		&t.Requestor,
+		&t.RequestTime,
+	)
+	if err == sql.ErrNoRows {
+		if version == 0 {
+			return nil, fmt.Errorf("could not find job template %q", name)
+		}
+		return nil, fmt.Errorf("could not find version %d of job template %q", version, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get job template %q: %v", name, err)
+	}
+	return &t, nil
+}
@@ -0,0 +1,351 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package s3 implements an artifact store which keeps the artifacts in an S3
+// bucket, or in a bucket of a compatible service such as MinIO, as objects
+// named <prefix><job ID>/<test name>/<target ID>/<name>. It talks to the S3
+// REST API directly, signing the requests with AWS Signature Version 4.
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// DefaultRequestTimeout is the default timeout of the requests to S3.
+const DefaultRequestTimeout = 5 * time.Minute
+
+const (
+	amzDateFormat  = "20060102T150405Z"
+	signingService = "s3"
+	signingAlgo    = "AWS4-HMAC-SHA256"
+)
+
+// Config is the configuration of the S3 artifact store.
+type Config struct {
+	// Endpoint is the URL of the service, e.g.
+	// https://s3.us-east-1.amazonaws.com. The bucket is part of the path of
+	// the requests, so that compatible services work too.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the names of the objects.
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is set for temporary credentials only.
+	SessionToken string
+	// RequestTimeout bounds the requests, including the transfer of the
+	// artifacts. DefaultRequestTimeout is used if 0.
+	RequestTimeout time.Duration
+}
+
+// S3 stores the artifacts in a bucket.
+type S3 struct {
+	config   Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// New returns an S3 artifact store.
+func New(config Config) (*S3, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q, must be an http or https URL", config.Endpoint)
+	}
+	switch {
+	case config.Region == "":
+		return nil, errors.New("S3 region cannot be empty")
+	case config.Bucket == "":
+		return nil, errors.New("S3 bucket cannot be empty")
+	case config.AccessKeyID == "" || config.SecretAccessKey == "":
+		return nil, errors.New("S3 credentials cannot be empty")
+	case config.RequestTimeout < 0:
+		return nil, fmt.Errorf("invalid S3 request timeout %s", config.RequestTimeout)
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = DefaultRequestTimeout
+	}
+	if config.Prefix != "" && !strings.HasSuffix(config.Prefix, "/") {
+		config.Prefix += "/"
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+	return &S3{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: config.RequestTimeout},
+		now:      time.Now,
+	}, nil
+}
+
+// objectName returns the name of the object of an artifact. The components
+// are escaped, so that they cannot contain the separator.
+func (s *S3) objectName(key storage.ArtifactKey) string {
+	parts := []string{string(key.JobID), key.TestName, key.TargetID, key.Name}
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return s.config.Prefix + strings.Join(parts, "/")
+}
+
+// uriEncode encodes s as required by the signature: everything but the
+// unreserved characters, and the slashes if encodeSlash is false.
+func uriEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '.', b == '_', b == '~':
+			sb.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign adds the Signature Version 4 authorization to a request, whose body
+// has the given SHA-256 hash. All the headers set so far are signed.
+func (s *S3) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.Join([]string{t.Format("20060102"), s.config.Region, signingService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{signingAlgo, amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretAccessKey), t.Format("20060102"))
+	for _, part := range []string{s.config.Region, signingService, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgo, s.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// newRequest returns a request for an object of the bucket, or for the bucket
+// itself if object is empty.
+func (s *S3) newRequest(method, object string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = s.endpoint.Path + "/" + s.config.Bucket
+	if object != "" {
+		u.Path += "/" + object
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = query.Encode()
+	return http.NewRequest(method, u.String(), body)
+}
+
+// s3Error is the body of the error responses.
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+// responseError returns the error of a failed response.
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	var e s3Error
+	if err := xml.Unmarshal(body, &e); err == nil && e.Code != "" {
+		return fmt.Errorf("S3 returned %s: %s: %s", resp.Status, e.Code, e.Message)
+	}
+	return fmt.Errorf("S3 returned %s", resp.Status)
+}
+
+// PutArtifact uploads an artifact. The content is spooled to a temporary
+// file first, as S3 needs its size and its hash before the upload.
+func (s *S3) PutArtifact(key storage.ArtifactKey, r io.Reader) (int64, error) {
+	f, err := ioutil.TempFile("", "contest-artifact-")
+	if err != nil {
+		return 0, fmt.Errorf("cannot create temporary file: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read artifact: %v", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("cannot read temporary file: %v", err)
+	}
+	req, err := s.newRequest(http.MethodPut, s.objectName(key), nil, f)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.sign(req, hex.EncodeToString(hash.Sum(nil)), s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("cannot upload artifact: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, responseError(resp)
+	}
+	return size, nil
+}
+
+// emptyHash is the hash of an empty body.
+var emptyHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+// GetArtifact downloads an artifact.
+func (s *S3) GetArtifact(key storage.ArtifactKey) (io.ReadCloser, error) {
+	req, err := s.newRequest(http.MethodGet, s.objectName(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, emptyHash, s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot download artifact: %v", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, storage.ErrArtifactNotFound
+	}
+	defer resp.Body.Close()
+	return nil, responseError(resp)
+}
+
+// listedObject is an object of a ListObjectsV2 response.
+type listedObject struct {
+	Key          string
+	LastModified time.Time
+	Size         int64
+}
+
+// listBucketResult is the body of the ListObjectsV2 responses.
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []listedObject
+}
+
+// ListArtifacts lists the objects of a job.
+func (s *S3) ListArtifacts(jobID types.JobID) ([]storage.Artifact, error) {
+	prefix := s.config.Prefix + url.PathEscape(string(jobID)) + "/"
+	var (
+		artifacts []storage.Artifact
+		token     string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		s.sign(req, emptyHash, s.now())
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list artifacts: %v", err)
+		}
+		var result listBucketResult
+		if resp.StatusCode != http.StatusOK {
+			err = responseError(resp)
+		} else if decodeErr := xml.NewDecoder(resp.Body).Decode(&result); decodeErr != nil {
+			err = fmt.Errorf("cannot decode object list: %v", decodeErr)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range result.Contents {
+			parts := strings.Split(strings.TrimPrefix(o.Key, prefix), "/")
+			if len(parts) != 3 {
+				continue
+			}
+			for i, p := range parts {
+				if parts[i], err = url.PathUnescape(p); err != nil {
+					return nil, fmt.Errorf("invalid artifact object %s: %v", o.Key, err)
+				}
+			}
+			artifacts = append(artifacts, storage.Artifact{
+				ArtifactKey: storage.ArtifactKey{JobID: jobID, TestName: parts[0], TargetID: parts[1], Name: parts[2]},
+				Size:        o.Size,
+				StoreTime:   o.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	storage.SortArtifacts(artifacts)
+	return artifacts, nil
+}
@@ -0,0 +1,188 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxErrorBodySize bounds the part of an error response which is reported.
+const maxErrorBodySize = 1024
+
+// number is a 64-bit integer of the gateway messages, which encodes them as
+// strings.
+type number int64
+
+// MarshalJSON encodes the number as a string.
+func (n number) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(strconv.FormatInt(int64(n), 10))), nil
+}
+
+// UnmarshalJSON decodes a number encoded as a string or as a JSON number.
+func (n *number) UnmarshalJSON(b []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(b), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %s: %v", b, err)
+	}
+	*n = number(v)
+	return nil
+}
+
+// the messages of the etcd v3 API used by the locker. Keys and values are
+// base64-encoded, which encoding/json does for byte slices.
+type keyValue struct {
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision number `json:"create_revision"`
+	Lease          number `json:"lease"`
+}
+
+type compare struct {
+	Key    []byte `json:"key"`
+	Target string `json:"target"`
+	Result string `json:"result"`
+	// only one of these is set, depending on Target
+	CreateRevision number `json:"create_revision,omitempty"`
+	Value          []byte `json:"value,omitempty"`
+}
+
+type rangeRequest struct {
+	Key []byte `json:"key"`
+}
+
+type putRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease number `json:"lease,omitempty"`
+}
+
+type requestOp struct {
+	RequestRange       *rangeRequest `json:"request_range,omitempty"`
+	RequestPut         *putRequest   `json:"request_put,omitempty"`
+	RequestDeleteRange *rangeRequest `json:"request_delete_range,omitempty"`
+}
+
+type txnRequest struct {
+	Compare []compare   `json:"compare"`
+	Success []requestOp `json:"success"`
+	Failure []requestOp `json:"failure"`
+}
+
+type rangeResponse struct {
+	Kvs []keyValue `json:"kvs"`
+}
+
+type txnResponse struct {
+	Succeeded bool `json:"succeeded"`
+	Responses []struct {
+		ResponseRange *rangeResponse `json:"response_range"`
+	} `json:"responses"`
+}
+
+type lease struct {
+	ID  number `json:"ID"`
+	TTL number `json:"TTL,omitempty"`
+}
+
+type keepAliveResponse struct {
+	Result lease `json:"result"`
+}
+
+// client calls the etcd v3 API through the JSON gateway which etcd serves on
+// its client URLs, so that no gRPC client is needed.
+type client struct {
+	endpoints []string
+	http      *http.Client
+}
+
+// call posts a request to the first endpoint which answers. The following
+// endpoints are only tried when the request could not be sent, or when etcd
+// is not available.
+func (c *client) call(path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("cannot encode request: %v", err)
+	}
+	var lastErr error
+	for _, endpoint := range c.endpoints {
+		r, err := c.http.Post(endpoint+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		err = decode(r, resp)
+		r.Body.Close()
+		if err == nil {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s%s: %v", endpoint, path, err)
+		if r.StatusCode < http.StatusInternalServerError {
+			break
+		}
+	}
+	return lastErr
+}
+
+func decode(r *http.Response, resp interface{}) error {
+	if r.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(r.Body, maxErrorBodySize))
+		return fmt.Errorf("%s: %s", r.Status, bytes.TrimSpace(msg))
+	}
+	// the keep-alive responses are streamed, only the first one is read
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("cannot decode response: %v", err)
+	}
+	return nil
+}
+
+func (c *client) txn(req txnRequest) (*txnResponse, error) {
+	var resp txnResponse
+	if err := c.call("/v3/kv/txn", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *client) get(key []byte) (*keyValue, error) {
+	var resp rangeResponse
+	if err := c.call("/v3/kv/range", rangeRequest{Key: key}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	return &resp.Kvs[0], nil
+}
+
+func (c *client) grant(ttl int64) (number, error) {
+	var resp lease
+	if err := c.call("/v3/lease/grant", lease{TTL: number(ttl)}, &resp); err != nil {
+		return 0, err
+	}
+	return resp.ID, nil
+}
+
+// keepAlive refreshes a lease, and returns its new TTL, which is not positive
+// if the lease expired.
+func (c *client) keepAlive(id number) (int64, error) {
+	var resp keepAliveResponse
+	if err := c.call("/v3/lease/keepalive", lease{ID: id}, &resp); err != nil {
+		return 0, err
+	}
+	return int64(resp.Result.TTL), nil
+}
+
+func (c *client) revoke(id number) error {
+	var resp struct{}
+	return c.call("/v3/lease/revoke", lease{ID: id}, &resp)
+}
@@ -0,0 +1,376 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package etcd implements a target locker backed by etcd, so that several
+// ConTest servers sharing a pool of targets never acquire the same target.
+//
+// Every target is locked by creating a key named after its ID, whose value
+// is the ID of the owning job. The keys of a job are attached to a lease,
+// which the locker keeps alive while the job owns targets, so that the locks
+// of a server which goes away expire after the lock timeout. The etcd v3 API
+// is called through its JSON gateway, served by etcd on its client URLs.
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "Etcd"
+
+var log = logging.GetLogger("targetlocker/" + strings.ToLower(Name))
+
+// defaults of the configuration.
+const (
+	DefaultPrefix         = "/contest/locks/"
+	DefaultRequestTimeout = 5 * time.Second
+)
+
+// Config configures the connection to etcd.
+type Config struct {
+	// Endpoints are the client URLs of the etcd cluster, which are tried in
+	// order, e.g. http://etcd1:2379.
+	Endpoints []string
+	// Prefix is prepended to the target IDs to make the keys of the locks.
+	// It defaults to DefaultPrefix.
+	Prefix string
+	// RequestTimeout bounds every call to etcd. It defaults to
+	// DefaultRequestTimeout.
+	RequestTimeout time.Duration
+}
+
+func (c *Config) validate() error {
+	if len(c.Endpoints) == 0 {
+		return errors.New("no etcd endpoint specified")
+	}
+	endpoints := make([]string, 0, len(c.Endpoints))
+	for _, endpoint := range c.Endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid etcd endpoint %q: %v", endpoint, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("etcd endpoint %q must be an http or https URL", endpoint)
+		}
+		endpoints = append(endpoints, strings.TrimRight(endpoint, "/"))
+	}
+	c.Endpoints = endpoints
+	if c.Prefix == "" {
+		c.Prefix = DefaultPrefix
+	}
+	if c.RequestTimeout < 0 {
+		return fmt.Errorf("request timeout cannot be negative, got %s", c.RequestTimeout)
+	}
+	if c.RequestTimeout == 0 {
+		c.RequestTimeout = DefaultRequestTimeout
+	}
+	return nil
+}
+
+// jobLease is the lease holding the locks of a job.
+type jobLease struct {
+	id number
+	// targets are the keys locked by the job.
+	targets map[string]bool
+	stop    chan struct{}
+}
+
+// Etcd is a target locker storing the locks in etcd.
+type Etcd struct {
+	client  *client
+	prefix  string
+	timeout time.Duration
+
+	lock   sync.Mutex
+	leases map[types.JobID]*jobLease
+}
+
+// New returns an etcd target locker which holds the locks for the given
+// timeout, unless they are refreshed.
+func New(config Config, timeout time.Duration) (*Etcd, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return newEtcd(config, timeout), nil
+}
+
+func newEtcd(config Config, timeout time.Duration) *Etcd {
+	return &Etcd{
+		client: &client{
+			endpoints: config.Endpoints,
+			http:      &http.Client{Timeout: config.RequestTimeout},
+		},
+		prefix:  config.Prefix,
+		timeout: timeout,
+		leases:  make(map[types.JobID]*jobLease),
+	}
+}
+
+// NewLoader returns the loader registering the etcd locker with the given
+// configuration.
+func NewLoader(config Config) (target.LockerLoader, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return func() (string, target.LockerFactory) {
+		return Name, func(timeout time.Duration) target.Locker {
+			return newEtcd(config, timeout)
+		}
+	}, nil
+}
+
+func (tl *Etcd) key(t *target.Target) string {
+	id := t.ID
+	if id == "" {
+		id = t.Name
+	}
+	return tl.prefix + url.PathEscape(id)
+}
+
+// ttl is the lock timeout in seconds, the unit of the etcd leases.
+func (tl *Etcd) ttl() int64 {
+	ttl := int64(math.Ceil(tl.timeout.Seconds()))
+	if ttl < 1 {
+		ttl = 1
+	}
+	return ttl
+}
+
+// jobLease returns the lease of a job, granting it if the job has none. The
+// lock of the locker must be held.
+func (tl *Etcd) jobLease(jobID types.JobID) (*jobLease, error) {
+	if l, ok := tl.leases[jobID]; ok {
+		return l, nil
+	}
+	id, err := tl.client.grant(tl.ttl())
+	if err != nil {
+		return nil, fmt.Errorf("cannot grant lease for job %s: %v", jobID, err)
+	}
+	l := &jobLease{id: id, targets: make(map[string]bool), stop: make(chan struct{})}
+	tl.leases[jobID] = l
+	go tl.keepAlive(jobID, l)
+	return l, nil
+}
+
+// keepAlive refreshes the lease of a job until it is released, a third of the
+// lock timeout before it expires.
+func (tl *Etcd) keepAlive(jobID types.JobID, l *jobLease) {
+	interval := tl.timeout / 3
+	if interval <= 0 {
+		interval = time.Second / 3
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		ttl, err := tl.client.keepAlive(l.id)
+		if err != nil {
+			log.Warningf("Cannot refresh the locks of job %s: %v", jobID, err)
+			continue
+		}
+		if ttl <= 0 {
+			log.Warningf("The locks of job %s expired", jobID)
+			tl.lock.Lock()
+			if tl.leases[jobID] == l {
+				delete(tl.leases, jobID)
+			}
+			tl.lock.Unlock()
+			return
+		}
+	}
+}
+
+// release forgets the lease of a job which owns no target anymore. The lock
+// of the locker must be held.
+func (tl *Etcd) release(jobID types.JobID, l *jobLease) {
+	if len(l.targets) > 0 || tl.leases[jobID] != l {
+		return
+	}
+	delete(tl.leases, jobID)
+	close(l.stop)
+	if err := tl.client.revoke(l.id); err != nil {
+		log.Warningf("Cannot revoke the lease of job %s: %v", jobID, err)
+	}
+}
+
+// lockTarget locks a target for a job. acquired tells whether the target was
+// not locked by the job yet.
+func (tl *Etcd) lockTarget(jobID types.JobID, l *jobLease, t *target.Target) (bool, error) {
+	key := []byte(tl.key(t))
+	owner := []byte(jobID)
+	resp, err := tl.client.txn(txnRequest{
+		Compare: []compare{{Key: key, Target: "CREATE", Result: "EQUAL", CreateRevision: 0}},
+		Success: []requestOp{{RequestPut: &putRequest{Key: key, Value: owner, Lease: l.id}}},
+		Failure: []requestOp{{RequestRange: &rangeRequest{Key: key}}},
+	})
+	if err != nil {
+		return false, fmt.Errorf("cannot lock target %s: %v", t.ID, err)
+	}
+	if resp.Succeeded {
+		return true, nil
+	}
+	var current *keyValue
+	if len(resp.Responses) > 0 && resp.Responses[0].ResponseRange != nil && len(resp.Responses[0].ResponseRange.Kvs) > 0 {
+		current = &resp.Responses[0].ResponseRange.Kvs[0]
+	}
+	if current == nil {
+		// the lock expired in the meantime
+		return tl.lockTarget(jobID, l, t)
+	}
+	if string(current.Value) != string(jobID) {
+		return false, fmt.Errorf("target already locked by job %s: %+v", current.Value, t)
+	}
+	if current.Lease != l.id {
+		// the job locked the target with a lease which expired
+		resp, err := tl.client.txn(txnRequest{
+			Compare: []compare{{Key: key, Target: "VALUE", Result: "EQUAL", Value: owner}},
+			Success: []requestOp{{RequestPut: &putRequest{Key: key, Value: owner, Lease: l.id}}},
+		})
+		if err != nil {
+			return false, fmt.Errorf("cannot refresh the lock of target %s: %v", t.ID, err)
+		}
+		if !resp.Succeeded {
+			return false, fmt.Errorf("lost the lock of target %s", t.ID)
+		}
+	}
+	return false, nil
+}
+
+// unlockTarget deletes the lock of a target if the job owns it.
+func (tl *Etcd) unlockTarget(jobID types.JobID, t *target.Target) error {
+	key := []byte(tl.key(t))
+	resp, err := tl.client.txn(txnRequest{
+		Compare: []compare{{Key: key, Target: "VALUE", Result: "EQUAL", Value: []byte(jobID)}},
+		Success: []requestOp{{RequestDeleteRange: &rangeRequest{Key: key}}},
+		Failure: []requestOp{{RequestRange: &rangeRequest{Key: key}}},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot unlock target %s: %v", t.ID, err)
+	}
+	if resp.Succeeded {
+		return nil
+	}
+	if len(resp.Responses) > 0 && resp.Responses[0].ResponseRange != nil && len(resp.Responses[0].ResponseRange.Kvs) > 0 {
+		return fmt.Errorf("target %s is locked by job %s, not by job %s", t.ID, resp.Responses[0].ResponseRange.Kvs[0].Value, jobID)
+	}
+	log.Debugf("Target is not locked, but received unlock request: %+v", t)
+	return nil
+}
+
+// Lock locks the targets for a job. If a target is locked by another job, the
+// targets locked by this call are unlocked and an error is returned.
+func (tl *Etcd) Lock(jobID types.JobID, targets []*target.Target) error {
+	log.Infof("Trying to lock %d targets for job %s", len(targets), jobID)
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	l, err := tl.jobLease(jobID)
+	if err != nil {
+		return err
+	}
+	var acquired []*target.Target
+	for _, t := range targets {
+		isNew, err := tl.lockTarget(jobID, l, t)
+		if err != nil {
+			for _, a := range acquired {
+				if err := tl.unlockTarget(jobID, a); err != nil {
+					log.Warningf("Cannot roll back the lock of target %s: %v", a.ID, err)
+				}
+				delete(l.targets, tl.key(a))
+			}
+			tl.release(jobID, l)
+			return err
+		}
+		if isNew {
+			acquired = append(acquired, t)
+		}
+		l.targets[tl.key(t)] = true
+	}
+	return nil
+}
+
+// Unlock unlocks the targets of a job. Targets locked by other jobs are left
+// locked, and reported in the returned error.
+func (tl *Etcd) Unlock(jobID types.JobID, targets []*target.Target) error {
+	log.Infof("Trying to unlock %d targets for job %s", len(targets), jobID)
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	var errs []string
+	for _, t := range targets {
+		if err := tl.unlockTarget(jobID, t); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if l, ok := tl.leases[jobID]; ok {
+			delete(l.targets, tl.key(t))
+		}
+	}
+	if l, ok := tl.leases[jobID]; ok {
+		tl.release(jobID, l)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot unlock %d targets: %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// RefreshLocks refreshes the lease of the job, and locks the targets again if
+// the lease expired. The lease is also refreshed in the background while the
+// job owns targets.
+func (tl *Etcd) RefreshLocks(jobID types.JobID, targets []*target.Target) error {
+	log.Infof("Trying to refresh locks on %d targets for job %s", len(targets), jobID)
+	tl.lock.Lock()
+	l, ok := tl.leases[jobID]
+	tl.lock.Unlock()
+	if ok {
+		ttl, err := tl.client.keepAlive(l.id)
+		if err != nil {
+			return fmt.Errorf("cannot refresh the locks of job %s: %v", jobID, err)
+		}
+		if ttl <= 0 {
+			tl.lock.Lock()
+			if tl.leases[jobID] == l {
+				delete(tl.leases, jobID)
+				close(l.stop)
+			}
+			tl.lock.Unlock()
+		}
+	}
+	return tl.Lock(jobID, targets)
+}
+
+// CheckLocks tells whether all the targets are locked by the job, and returns
+// the targets which are and the ones which are not.
+func (tl *Etcd) CheckLocks(jobID types.JobID, targets []*target.Target) (bool, []*target.Target, []*target.Target) {
+	log.Infof("Checking if %d target(s) are locked by job ID %s", len(targets), jobID)
+	locked := make([]*target.Target, 0)
+	notLocked := make([]*target.Target, 0)
+	for _, t := range targets {
+		kv, err := tl.client.get([]byte(tl.key(t)))
+		if err != nil {
+			log.Warningf("Cannot check the lock of target %s: %v", t.ID, err)
+		}
+		if err == nil && kv != nil && string(kv.Value) == string(jobID) {
+			locked = append(locked, t)
+		} else {
+			notLocked = append(notLocked, t)
+		}
+	}
+	return len(notLocked) == 0, locked, notLocked
+}
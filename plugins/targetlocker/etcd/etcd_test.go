@@ -0,0 +1,222 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package etcd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEtcd implements the part of the etcd JSON gateway used by the locker.
+// Leases only expire when expire is called.
+type fakeEtcd struct {
+	mu        sync.Mutex
+	kvs       map[string]keyValue
+	leases    map[number]bool
+	nextLease number
+	revision  number
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{kvs: make(map[string]keyValue), leases: make(map[number]bool)}
+}
+
+func (f *fakeEtcd) expire(id number) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.revoke(id)
+}
+
+func (f *fakeEtcd) revoke(id number) {
+	delete(f.leases, id)
+	for k, kv := range f.kvs {
+		if kv.Lease == id {
+			delete(f.kvs, k)
+		}
+	}
+}
+
+func (f *fakeEtcd) apply(op requestOp) interface{} {
+	switch {
+	case op.RequestPut != nil:
+		f.revision++
+		kv := keyValue{Key: op.RequestPut.Key, Value: op.RequestPut.Value, Lease: op.RequestPut.Lease, CreateRevision: f.revision}
+		if old, ok := f.kvs[string(kv.Key)]; ok {
+			kv.CreateRevision = old.CreateRevision
+		}
+		f.kvs[string(kv.Key)] = kv
+		return map[string]interface{}{"response_put": struct{}{}}
+	case op.RequestDeleteRange != nil:
+		delete(f.kvs, string(op.RequestDeleteRange.Key))
+		return map[string]interface{}{"response_delete_range": struct{}{}}
+	default:
+		resp := rangeResponse{}
+		if kv, ok := f.kvs[string(op.RequestRange.Key)]; ok {
+			resp.Kvs = append(resp.Kvs, kv)
+		}
+		return map[string]interface{}{"response_range": resp}
+	}
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var resp interface{}
+	switch r.URL.Path {
+	case "/v3/kv/txn":
+		var req txnRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		succeeded := true
+		for _, c := range req.Compare {
+			kv := f.kvs[string(c.Key)]
+			switch c.Target {
+			case "CREATE":
+				succeeded = succeeded && kv.CreateRevision == c.CreateRevision
+			case "VALUE":
+				succeeded = succeeded && string(kv.Value) == string(c.Value)
+			}
+		}
+		ops := req.Failure
+		if succeeded {
+			ops = req.Success
+		}
+		for _, op := range ops {
+			if op.RequestPut != nil && op.RequestPut.Lease != 0 && !f.leases[op.RequestPut.Lease] {
+				http.Error(w, `{"error": "etcdserver: requested lease not found"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		var responses []interface{}
+		for _, op := range ops {
+			responses = append(responses, f.apply(op))
+		}
+		resp = map[string]interface{}{"succeeded": succeeded, "responses": responses}
+	case "/v3/kv/range":
+		var req rangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp = f.apply(requestOp{RequestRange: &req}).(map[string]interface{})["response_range"]
+	case "/v3/lease/grant":
+		f.nextLease++
+		f.leases[f.nextLease] = true
+		resp = lease{ID: f.nextLease, TTL: 10}
+	case "/v3/lease/keepalive":
+		var req lease
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl := number(0)
+		if f.leases[req.ID] {
+			ttl = 10
+		}
+		resp = keepAliveResponse{Result: lease{ID: req.ID, TTL: ttl}}
+	case "/v3/lease/revoke":
+		var req lease
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.revoke(req.ID)
+		resp = struct{}{}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newLocker(t *testing.T, f *fakeEtcd) (*Etcd, func()) {
+	srv := httptest.NewServer(f)
+	// the first endpoint is down, the locker fails over to the second one
+	tl, err := New(Config{Endpoints: []string{"http://127.0.0.1:1", srv.URL + "/"}}, time.Minute)
+	require.NoError(t, err)
+	return tl, srv.Close
+}
+
+func TestLockUnlock(t *testing.T) {
+	f := newFakeEtcd()
+	tl, done := newLocker(t, f)
+	defer done()
+	// a second server sharing the etcd cluster
+	other, doneOther := newLocker(t, f)
+	defer doneOther()
+
+	targets := []*target.Target{{ID: "1", Name: "host1"}, {ID: "2", Name: "host2"}}
+	require.NoError(t, tl.Lock(types.JobID("1"), targets))
+	// locking again is a no-op
+	require.NoError(t, tl.Lock(types.JobID("1"), targets))
+	allLocked, locked, notLocked := tl.CheckLocks(types.JobID("1"), targets)
+	require.True(t, allLocked)
+	require.Len(t, locked, 2)
+	require.Empty(t, notLocked)
+
+	// another job cannot lock the targets, and does not keep the free ones
+	spare := &target.Target{ID: "3", Name: "host3"}
+	err := other.Lock(types.JobID("2"), []*target.Target{spare, targets[1]})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "locked by job 1")
+	allLocked, _, notLocked = other.CheckLocks(types.JobID("2"), []*target.Target{spare})
+	require.False(t, allLocked)
+	require.Len(t, notLocked, 1)
+	require.Error(t, other.Unlock(types.JobID("2"), targets))
+
+	require.NoError(t, tl.Unlock(types.JobID("1"), targets))
+	require.Empty(t, tl.leases)
+	require.NoError(t, other.Lock(types.JobID("2"), targets))
+	allLocked, _, _ = tl.CheckLocks(types.JobID("1"), targets)
+	require.False(t, allLocked)
+}
+
+func TestRefreshLocksAfterExpiry(t *testing.T) {
+	f := newFakeEtcd()
+	tl, done := newLocker(t, f)
+	defer done()
+
+	targets := []*target.Target{{ID: "1", Name: "host1"}}
+	require.NoError(t, tl.Lock(types.JobID("1"), targets))
+	first := tl.leases[types.JobID("1")].id
+	f.expire(first)
+	allLocked, _, _ := tl.CheckLocks(types.JobID("1"), targets)
+	require.False(t, allLocked)
+
+	// refreshing locks the targets again with a new lease
+	require.NoError(t, tl.RefreshLocks(types.JobID("1"), targets))
+	allLocked, _, _ = tl.CheckLocks(types.JobID("1"), targets)
+	require.True(t, allLocked)
+	require.NotEqual(t, first, tl.leases[types.JobID("1")].id)
+}
+
+func TestConfig(t *testing.T) {
+	for _, c := range []Config{
+		{},
+		{Endpoints: []string{"etcd:2379"}},
+		{Endpoints: []string{"http://etcd:2379"}, RequestTimeout: -time.Second},
+	} {
+		_, err := NewLoader(c)
+		require.Error(t, err, "%+v", c)
+	}
+	loader, err := NewLoader(Config{Endpoints: []string{"http://etcd:2379"}})
+	require.NoError(t, err)
+	name, factory := loader()
+	require.Equal(t, Name, name)
+	tl := factory(time.Second).(*Etcd)
+	require.Equal(t, DefaultPrefix, tl.prefix)
+	require.Equal(t, "/contest/locks/a%2Fb", tl.key(&target.Target{ID: "a/b"}))
+}
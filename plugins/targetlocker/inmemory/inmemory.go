@@ -178,7 +178,7 @@ func (tl *InMemory) RefreshLocks(jobID types.JobID, targets []*target.Target) er
 // CheckLocks tells whether all the targets are locked. They all are, always. It
 // also returns the ones that are not locked.
 func (tl *InMemory) CheckLocks(jobID types.JobID, targets []*target.Target) (bool, []*target.Target, []*target.Target) {
-	log.Infof("Checking if %d target(s) are locked by job ID %d", len(targets), jobID)
+	log.Infof("Checking if %d target(s) are locked by job ID %s", len(targets), jobID)
 	req := newReq(targets)
 	tl.checkLocksRequests <- req
 	if err := <-req.err; err != nil {
@@ -206,3 +206,8 @@ func New(timeout time.Duration) target.Locker {
 		timeout:            timeout,
 	}
 }
+
+// Load returns the name and factory which are needed to register the locker.
+func Load() (string, target.LockerFactory) {
+	return Name, New
+}
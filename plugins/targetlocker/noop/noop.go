@@ -54,3 +54,8 @@ func (tl Noop) RefreshLocks(jobID types.JobID, targets []*target.Target) error {
 func New(_ time.Duration) target.Locker {
 	return &Noop{}
 }
+
+// Load returns the name and factory which are needed to register the locker.
+func Load() (string, target.LockerFactory) {
+	return Name, New
+}
@@ -25,7 +25,7 @@ func TestNoopLock(t *testing.T) {
 	// we don't enforce that at least one target is passed, as checking on
 	// non-zero targets is the framework's responsibility, not the plugin.
 	// So, zero targets is OK.
-	jobID := types.JobID(123)
+	jobID := types.JobID("123")
 	require.Nil(t, tl.Lock(jobID, nil))
 	require.Nil(t, tl.Lock(jobID, []*target.Target{}))
 	require.Nil(t, tl.Lock(jobID, []*target.Target{
@@ -42,7 +42,7 @@ func TestNoopUnlock(t *testing.T) {
 	// we don't enforce that at least one target is passed, as checking on
 	// non-zero targets is the framework's responsibility, not the plugin.
 	// So, zero targets is OK.
-	jobID := types.JobID(123)
+	jobID := types.JobID("123")
 	require.Nil(t, tl.Unlock(jobID, nil))
 	require.Nil(t, tl.Unlock(jobID, []*target.Target{}))
 	require.Nil(t, tl.Unlock(jobID, []*target.Target{
@@ -59,7 +59,7 @@ func TestNoopCheckLocks(t *testing.T) {
 	// we don't enforce that at least one target is passed, as checking on
 	// non-zero targets is the framework's responsibility, not the plugin.
 	// So, zero targets is OK.
-	jobID := types.JobID(123)
+	jobID := types.JobID("123")
 	allAreLocked, locked, notLocked := tl.CheckLocks(jobID, nil)
 	require.True(t, allAreLocked)
 	require.Nil(t, locked)
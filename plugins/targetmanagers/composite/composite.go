@@ -0,0 +1,271 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package composite implements a target manager that acquires targets from
+// several other target managers at once, and presents the union of them as a
+// single target set. Use it as follows in a job descriptor:
+// "TargetManagerName": "Composite",
+// "TargetManagerAcquireParameters": {
+//     "Managers": [
+//         {
+//             "TargetManagerName": "TargetList",
+//             "TargetManagerAcquireParameters": {
+//                 "Targets": [{"Name": "hostname1.example.com", "ID": "id1"}]
+//             },
+//             "TargetManagerReleaseParameters": {},
+//             "Namespace": "lab1"
+//         },
+//         {
+//             "TargetManagerName": "CSVFileTargetManager",
+//             "TargetManagerAcquireParameters": {
+//                 "FileURI": "hosts.csv"
+//             },
+//             "TargetManagerReleaseParameters": {}
+//         }
+//     ],
+//     "Dedup": true,
+//     "BestEffort": false,
+//     "NamespaceIDs": true
+// },
+// "TargetManagerReleaseParameters": {}
+//
+// Each target is released through the manager it was acquired from. If
+// BestEffort is true, a sub-manager failing to acquire targets is skipped,
+// otherwise the acquisition fails and the targets acquired so far are
+// released.
+//
+// Different sources may assign the same IDs to different targets. To keep
+// them apart, the IDs of the targets of a sub-manager with a Namespace are
+// prefixed with it at acquisition, e.g. "lab1/id1". If NamespaceIDs is true,
+// the sub-managers without a Namespace use their TargetManagerName instead.
+// The namespaced IDs are the ones that are locked, and that appear in the
+// events and job reports.
+package composite
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+)
+
+// Name defined the name of the plugin
+var (
+	Name = "Composite"
+)
+
+var log = logging.GetLogger("targetmanagers/" + strings.ToLower(Name))
+
+// Registry creates instances of target managers by name. It is implemented by
+// the plugin registry.
+type Registry interface {
+	NewTargetManager(pluginName string) (target.TargetManager, error)
+}
+
+// ManagerConfig is the configuration of a single sub-manager.
+type ManagerConfig struct {
+	TargetManagerName              string
+	TargetManagerAcquireParameters json.RawMessage
+	TargetManagerReleaseParameters json.RawMessage
+	// Namespace, if not empty, scopes the IDs of the targets of this
+	// sub-manager.
+	Namespace string
+}
+
+// AcquireParameters contains the parameters necessary to acquire targets.
+type AcquireParameters struct {
+	Managers   []ManagerConfig
+	Dedup      bool
+	BestEffort bool
+	// NamespaceIDs scopes the target IDs of the sub-managers without a
+	// Namespace by their TargetManagerName.
+	NamespaceIDs bool
+}
+
+// ReleaseParameters contains the parameters necessary to release targets.
+// The release parameters of each sub-manager are part of its ManagerConfig.
+type ReleaseParameters struct {
+}
+
+// subManager is a validated sub-manager, with its instance and parameters.
+type subManager struct {
+	name              string
+	namespace         string
+	targetManager     target.TargetManager
+	acquireParameters interface{}
+	releaseParameters interface{}
+}
+
+// validatedParameters is what ValidateAcquireParameters returns, and what
+// Acquire expects.
+type validatedParameters struct {
+	managers   []*subManager
+	dedup      bool
+	bestEffort bool
+}
+
+// Composite implements the contest.TargetManager interface, merging the
+// targets of multiple target managers.
+type Composite struct {
+	registry Registry
+	// acquired holds the sub-managers which successfully acquired targets and
+	// have to be released.
+	acquired []*subManager
+}
+
+// rawOrEmpty returns an empty JSON object for unspecified parameters, so that
+// sub-managers can unmarshal them.
+func rawOrEmpty(raw json.RawMessage) []byte {
+	if len(raw) == 0 {
+		return []byte("{}")
+	}
+	return raw
+}
+
+// ValidateAcquireParameters performs sanity checks on the fields of the
+// parameters that will be passed to Acquire. The acquire and release
+// parameters of every sub-manager are validated by the sub-manager itself.
+func (c Composite) ValidateAcquireParameters(params []byte) (interface{}, error) {
+	var ap AcquireParameters
+	if err := json.Unmarshal(params, &ap); err != nil {
+		return nil, err
+	}
+	if len(ap.Managers) == 0 {
+		return nil, errors.New("at least one target manager must be specified")
+	}
+	if c.registry == nil {
+		return nil, errors.New("no target manager registry available")
+	}
+	vp := validatedParameters{dedup: ap.Dedup, bestEffort: ap.BestEffort}
+	namespaces := make(map[string]int)
+	for idx, mc := range ap.Managers {
+		if strings.TrimSpace(mc.TargetManagerName) == "" {
+			return nil, fmt.Errorf("target manager #%d: missing TargetManagerName", idx)
+		}
+		namespace := mc.Namespace
+		if namespace == "" && ap.NamespaceIDs {
+			namespace = mc.TargetManagerName
+		}
+		if namespace != "" {
+			if strings.Contains(namespace, target.NamespaceSeparator) {
+				return nil, fmt.Errorf("target manager #%d: namespace %q must not contain %q", idx, namespace, target.NamespaceSeparator)
+			}
+			if other, ok := namespaces[namespace]; ok {
+				return nil, fmt.Errorf("target manager #%d: namespace %q is already used by target manager #%d", idx, namespace, other)
+			}
+			namespaces[namespace] = idx
+		}
+		tm, err := c.registry.NewTargetManager(mc.TargetManagerName)
+		if err != nil {
+			return nil, fmt.Errorf("target manager #%d: %v", idx, err)
+		}
+		acquireParameters, err := tm.ValidateAcquireParameters(rawOrEmpty(mc.TargetManagerAcquireParameters))
+		if err != nil {
+			return nil, fmt.Errorf("target manager #%d (%s): invalid acquire parameters: %v", idx, mc.TargetManagerName, err)
+		}
+		releaseParameters, err := tm.ValidateReleaseParameters(rawOrEmpty(mc.TargetManagerReleaseParameters))
+		if err != nil {
+			return nil, fmt.Errorf("target manager #%d (%s): invalid release parameters: %v", idx, mc.TargetManagerName, err)
+		}
+		vp.managers = append(vp.managers, &subManager{
+			name:              mc.TargetManagerName,
+			namespace:         namespace,
+			targetManager:     tm,
+			acquireParameters: acquireParameters,
+			releaseParameters: releaseParameters,
+		})
+	}
+	return vp, nil
+}
+
+// ValidateReleaseParameters performs sanity checks on the fields of the
+// parameters that will be passed to Release.
+func (c Composite) ValidateReleaseParameters(params []byte) (interface{}, error) {
+	var rp ReleaseParameters
+	if err := json.Unmarshal(params, &rp); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+// Acquire implements contest.TargetManager.Acquire, acquiring targets from
+// every sub-manager and returning their union.
+func (c *Composite) Acquire(jobID types.JobID, cancel <-chan struct{}, parameters interface{}, tl target.Locker) ([]*target.Target, error) {
+	vp, ok := parameters.(validatedParameters)
+	if !ok {
+		return nil, fmt.Errorf("Acquire expects %T object, got %T", vp, parameters)
+	}
+
+	var (
+		targets []*target.Target
+		seen    = make(map[target.Target]bool)
+	)
+	for _, sm := range vp.managers {
+		smLocker := tl
+		if sm.namespace != "" {
+			smLocker = target.NewNamespacedLocker(tl, sm.namespace)
+		}
+		smTargets, err := sm.targetManager.Acquire(jobID, cancel, sm.acquireParameters, smLocker)
+		if err != nil {
+			if vp.bestEffort {
+				log.Warningf("Target manager %s failed to acquire targets, skipping it: %v", sm.name, err)
+				continue
+			}
+			if releaseErr := c.Release(jobID, cancel, ReleaseParameters{}); releaseErr != nil {
+				log.Warningf("Failed to release targets after acquisition failure: %v", releaseErr)
+			}
+			return nil, fmt.Errorf("target manager %s failed to acquire targets: %v", sm.name, err)
+		}
+		c.acquired = append(c.acquired, sm)
+		for _, t := range smTargets {
+			if sm.namespace != "" {
+				t = t.Namespaced(sm.namespace)
+			}
+			if vp.dedup {
+				if seen[*t] {
+					log.Debugf("Skipping duplicate target %s from target manager %s", t, sm.name)
+					continue
+				}
+				seen[*t] = true
+			}
+			targets = append(targets, t)
+		}
+		log.Infof("Acquired %d targets from target manager %s", len(smTargets), sm.name)
+	}
+	if len(c.acquired) == 0 {
+		return nil, errors.New("no target manager could acquire targets")
+	}
+	return targets, nil
+}
+
+// Release releases the targets through the sub-managers that acquired them.
+// All the sub-managers are released even if some of them fail.
+func (c *Composite) Release(jobID types.JobID, cancel <-chan struct{}, params interface{}) error {
+	var errs []string
+	for _, sm := range c.acquired {
+		if err := sm.targetManager.Release(jobID, cancel, sm.releaseParameters); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", sm.name, err))
+		}
+	}
+	c.acquired = nil
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to release targets: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// NewLoader returns a loader for the Composite target manager, which creates
+// its sub-managers through the given registry.
+func NewLoader(registry Registry) target.TargetManagerLoader {
+	return func() (string, target.TargetManagerFactory) {
+		return Name, func() target.TargetManager {
+			return &Composite{registry: registry}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package composite
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/targetlocker/inmemory"
+	"github.com/facebookincubator/contest/plugins/targetmanagers/targetlist"
+	"github.com/stretchr/testify/require"
+)
+
+type registry struct{}
+
+func (r registry) NewTargetManager(pluginName string) (target.TargetManager, error) {
+	if pluginName != targetlist.Name {
+		return nil, fmt.Errorf("unknown target manager %s", pluginName)
+	}
+	return targetlist.New(), nil
+}
+
+// sameIDParameters returns acquire parameters with two target lists, which
+// both assign the ID "1" to different hosts.
+func sameIDParameters(namespaces string) string {
+	return fmt.Sprintf(`{
+		"Managers": [
+			{
+				"TargetManagerName": "TargetList",
+				"TargetManagerAcquireParameters": {"Targets": [{"Name": "host1.lab1", "ID": "1"}]},
+				"Namespace": "lab1"
+			},
+			{
+				"TargetManagerName": "TargetList",
+				"TargetManagerAcquireParameters": {"Targets": [{"Name": "host1.lab2", "ID": "1"}]}
+			}
+		],
+		%s
+	}`, namespaces)
+}
+
+func TestNamespacedIDs(t *testing.T) {
+	for _, tc := range []struct {
+		namespaceIDs bool
+		ids          []string
+	}{
+		{false, []string{"lab1/1", "1"}},
+		{true, []string{"lab1/1", "TargetList/1"}},
+	} {
+		t.Run(fmt.Sprintf("NamespaceIDs=%v", tc.namespaceIDs), func(t *testing.T) {
+			_, factory := NewLoader(registry{})()
+			tm := factory()
+			params, err := tm.ValidateAcquireParameters([]byte(sameIDParameters(fmt.Sprintf(`"NamespaceIDs": %v`, tc.namespaceIDs))))
+			require.NoError(t, err)
+			tl := inmemory.New(time.Minute)
+			jobID := types.JobID("1")
+			targets, err := tm.Acquire(jobID, nil, params, tl)
+			require.NoError(t, err)
+			require.Equal(t, []*target.Target{
+				{Name: "host1.lab1", ID: tc.ids[0]},
+				{Name: "host1.lab2", ID: tc.ids[1]},
+			}, targets)
+			// the locks are held on the targets as seen by the job
+			allLocked, _, notLocked := tl.CheckLocks(jobID, targets)
+			require.True(t, allLocked, "not locked: %v", notLocked)
+			require.NoError(t, tm.Release(jobID, nil, ReleaseParameters{}))
+		})
+	}
+}
+
+func TestInvalidNamespace(t *testing.T) {
+	for _, managers := range []string{
+		`{"TargetManagerName": "TargetList", "TargetManagerAcquireParameters": {"Targets": []}, "Namespace": "a/b"}`,
+		`{"TargetManagerName": "TargetList", "TargetManagerAcquireParameters": {"Targets": []}, "Namespace": "a"},
+		 {"TargetManagerName": "TargetList", "TargetManagerAcquireParameters": {"Targets": []}, "Namespace": "a"}`,
+	} {
+		_, factory := NewLoader(registry{})()
+		_, err := factory().ValidateAcquireParameters([]byte(`{"Managers": [` + managers + `]}`))
+		require.Error(t, err)
+	}
+}
@@ -12,6 +12,8 @@
 // In other words, two fields: the first containing a host name (fully qualified
 // or not), and the second containin the IP address of the target (this field is
 // optional).
+//
+// Quarantined hosts are skipped before the number of devices is checked.
 package csvtargetmanager
 
 import (
@@ -136,6 +138,11 @@ func (tf *CSVFileTargetManager) Acquire(jobID types.JobID, cancel <-chan struct{
 			}
 		}
 	}
+	// quarantined hosts are skipped, so that healthy ones replace them
+	hosts, err = target.FilterQuarantined(hosts)
+	if err != nil {
+		return nil, err
+	}
 	if uint32(len(hosts)) < acquireParameters.MinNumberDevices {
 		return nil, fmt.Errorf("not enough hosts found in CSV file '%s', want %d, got %d",
 			acquireParameters.FileURI.Path,
@@ -0,0 +1,566 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package httpinventory implements a target manager that discovers the
+// targets by querying an inventory service over HTTP. Use it as follows in a
+// job descriptor:
+// "TargetManagerName": "HTTPInventory",
+// "TargetManagerAcquireParameters": {
+//     "Endpoint": "https://inventory.example.com/api/hosts?pool=lab1",
+//     "Headers": {"Authorization": "Bearer ${env:INVENTORY_TOKEN}"},
+//     "ItemsField": "data.hosts",
+//     "IDField": "asset_id",
+//     "NameField": "hostname",
+//     "FQDNField": "network.fqdn",
+//     "NextField": "links.next",
+//     "MinNumberDevices": 1,
+//     "MaxNumberDevices": 10
+// },
+// "TargetManagerReleaseParameters": {
+//     "Endpoint": "https://inventory.example.com/api/release",
+//     "Headers": {"Authorization": "Bearer ${env:INVENTORY_TOKEN}"}
+// }
+//
+// The fields are dotted paths into the JSON responses, with an optional
+// leading "$.", and where numeric components index arrays. If ItemsField is
+// empty, the response is the list of targets itself. The pages are followed
+// through the URL found at NextField, relative to the current page, or
+// through the "next" Link header if NextField is empty.
+//
+// Header values may contain secret references, such as ${env:NAME}, which are
+// resolved right before each request. Requests failing with a network error,
+// a 429 or a 5xx status are retried.
+//
+// If a release Endpoint is set, the acquired targets are posted to it as
+// {"JobID": <id>, "Targets": [...]} when they are released.
+//
+// Quarantined targets are skipped, and do not count toward MinNumberDevices
+// and MaxNumberDevices.
+package httpinventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/insomniacslk/xjson"
+)
+
+// Name defined the name of the plugin
+var (
+	Name = "HTTPInventory"
+)
+
+var log = logging.GetLogger("targetmanagers/" + strings.ToLower(Name))
+
+const (
+	defaultTimeout       = 30 * time.Second
+	defaultRetries       = 3
+	defaultRetryInterval = time.Second
+	defaultMaxPages      = 100
+	defaultIDField       = "id"
+	defaultNameField     = "name"
+	// maxResponseSize bounds the size of a page of the inventory.
+	maxResponseSize = 32 << 20
+)
+
+// RequestParameters are the parameters of the requests to the inventory,
+// common to acquisition and release.
+type RequestParameters struct {
+	Endpoint string
+	// Headers are added to the requests. Their values may contain secret
+	// references.
+	Headers map[string]string
+	// Timeout bounds each request, including the reading of the response.
+	Timeout xjson.Duration
+	// Retries is the number of times a failed request is retried. A negative
+	// value disables the retries.
+	Retries       int
+	RetryInterval xjson.Duration
+}
+
+// AcquireParameters contains the parameters necessary to acquire targets.
+type AcquireParameters struct {
+	RequestParameters
+	ItemsField string
+	IDField    string
+	NameField  string
+	// FQDNField is optional. If it is empty, the targets have no FQDN.
+	FQDNField string
+	NextField string
+	// MaxPages bounds the number of pages which are fetched.
+	MaxPages         int
+	MinNumberDevices uint32
+	MaxNumberDevices uint32
+}
+
+// ReleaseParameters contains the parameters necessary to release targets. If
+// the Endpoint is empty, the targets are not reported to the inventory.
+type ReleaseParameters struct {
+	RequestParameters
+	// Method is the HTTP method of the release callback, POST by default.
+	Method string
+}
+
+// releasePayload is the body of the release callback.
+type releasePayload struct {
+	JobID   types.JobID
+	Targets []*target.Target
+}
+
+// HTTPInventory implements the contest.TargetManager interface, fetching the
+// targets from an inventory service.
+type HTTPInventory struct {
+	// acquired are the targets reported to the release callback.
+	acquired []*target.Target
+}
+
+var fieldRe = regexp.MustCompile(`^[^.]+(\.[^.]+)*$`)
+
+// validatePath checks a dotted field path, and returns it without the
+// optional JSONPath root.
+func validatePath(path, name string, required bool) (string, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		if required {
+			return "", fmt.Errorf("%s cannot be empty", name)
+		}
+		return "", nil
+	}
+	if !fieldRe.MatchString(path) {
+		return "", fmt.Errorf("invalid %s %q", name, path)
+	}
+	return path, nil
+}
+
+// validate checks the request parameters and fills in the defaults.
+func (rp *RequestParameters) validate(required bool) error {
+	if rp.Endpoint == "" {
+		if required {
+			return errors.New("endpoint not specified")
+		}
+		return nil
+	}
+	u, err := url.Parse(rp.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint %q: %v", rp.Endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported endpoint scheme %q, only http and https are accepted", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("endpoint %q has no host", rp.Endpoint)
+	}
+	for name, value := range rp.Headers {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("header names cannot be empty")
+		}
+		if err := secrets.Validate(value); err != nil {
+			return fmt.Errorf("invalid value for header %s: %v", name, err)
+		}
+	}
+	if rp.Timeout < 0 || rp.RetryInterval < 0 {
+		return errors.New("timeout and retry interval cannot be negative")
+	}
+	if rp.Timeout == 0 {
+		rp.Timeout = xjson.Duration(defaultTimeout)
+	}
+	if rp.Retries == 0 {
+		rp.Retries = defaultRetries
+	} else if rp.Retries < 0 {
+		rp.Retries = 0
+	}
+	if rp.RetryInterval == 0 {
+		rp.RetryInterval = xjson.Duration(defaultRetryInterval)
+	}
+	return nil
+}
+
+// ValidateAcquireParameters performs sanity checks on the fields of the
+// parameters that will be passed to Acquire.
+func (hi HTTPInventory) ValidateAcquireParameters(params []byte) (interface{}, error) {
+	var ap AcquireParameters
+	if err := json.Unmarshal(params, &ap); err != nil {
+		return nil, err
+	}
+	if err := ap.validate(true); err != nil {
+		return nil, err
+	}
+	if ap.IDField == "" {
+		ap.IDField = defaultIDField
+	}
+	if ap.NameField == "" {
+		ap.NameField = defaultNameField
+	}
+	var err error
+	if ap.ItemsField, err = validatePath(ap.ItemsField, "ItemsField", false); err != nil {
+		return nil, err
+	}
+	if ap.IDField, err = validatePath(ap.IDField, "IDField", true); err != nil {
+		return nil, err
+	}
+	if ap.NameField, err = validatePath(ap.NameField, "NameField", true); err != nil {
+		return nil, err
+	}
+	if ap.FQDNField, err = validatePath(ap.FQDNField, "FQDNField", false); err != nil {
+		return nil, err
+	}
+	if ap.NextField, err = validatePath(ap.NextField, "NextField", false); err != nil {
+		return nil, err
+	}
+	if ap.MaxPages < 0 {
+		return nil, errors.New("MaxPages cannot be negative")
+	}
+	if ap.MaxPages == 0 {
+		ap.MaxPages = defaultMaxPages
+	}
+	if ap.MaxNumberDevices != 0 && ap.MaxNumberDevices < ap.MinNumberDevices {
+		return nil, fmt.Errorf("MaxNumberDevices (%d) cannot be lower than MinNumberDevices (%d)", ap.MaxNumberDevices, ap.MinNumberDevices)
+	}
+	return ap, nil
+}
+
+// ValidateReleaseParameters performs sanity checks on the fields of the
+// parameters that will be passed to Release.
+func (hi HTTPInventory) ValidateReleaseParameters(params []byte) (interface{}, error) {
+	var rp ReleaseParameters
+	if err := json.Unmarshal(params, &rp); err != nil {
+		return nil, err
+	}
+	if err := rp.validate(false); err != nil {
+		return nil, err
+	}
+	if rp.Method == "" {
+		rp.Method = http.MethodPost
+	}
+	rp.Method = strings.ToUpper(rp.Method)
+	return rp, nil
+}
+
+// cancelContext returns a context which is done when cancel is closed.
+func cancelContext(cancel <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-cancel:
+			cancelCtx()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancelCtx
+}
+
+// errPermanent wraps the errors of the requests which are not retried.
+type errPermanent struct {
+	err error
+}
+
+func (e *errPermanent) Error() string {
+	return e.err.Error()
+}
+
+// do sends a request to the inventory, retrying it on transient failures, and
+// returns the body of the response and its headers.
+func (hi *HTTPInventory) do(ctx context.Context, rp RequestParameters, method, rawURL string, body []byte) ([]byte, http.Header, error) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var (
+			data   []byte
+			header http.Header
+		)
+		data, header, err = hi.doOnce(ctx, rp, method, rawURL, body)
+		if err == nil {
+			return data, header, nil
+		}
+		var permanent *errPermanent
+		if errors.As(err, &permanent) || ctx.Err() != nil || attempt >= rp.Retries {
+			break
+		}
+		log.Warningf("Request %s %s failed, retrying in %s (%d/%d): %v", method, rawURL, time.Duration(rp.RetryInterval), attempt+1, rp.Retries, err)
+		select {
+		case <-time.After(time.Duration(rp.RetryInterval)):
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	return nil, nil, err
+}
+
+func (hi *HTTPInventory) doOnce(ctx context.Context, rp RequestParameters, method, rawURL string, body []byte) ([]byte, http.Header, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(rp.Timeout))
+	defer cancel()
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, reader)
+	if err != nil {
+		return nil, nil, &errPermanent{fmt.Errorf("cannot create request: %v", err)}
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range rp.Headers {
+		v, err := secrets.Expand(value)
+		if err != nil {
+			return nil, nil, &errPermanent{fmt.Errorf("cannot resolve header %s: %v", name, err)}
+		}
+		req.Header.Set(name, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("request to %s failed: %v", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read response from %s: %v", req.URL.Host, err)
+	}
+	if len(data) > maxResponseSize {
+		return nil, nil, &errPermanent{fmt.Errorf("response from %s exceeds %d bytes", req.URL.Host, maxResponseSize)}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err := fmt.Errorf("%s %s returned status %d", method, req.URL.Host+req.URL.Path, resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return nil, nil, err
+		}
+		return nil, nil, &errPermanent{err}
+	}
+	return data, resp.Header, nil
+}
+
+// lookup returns the value at a dotted path in a decoded JSON document.
+func lookup(doc interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return doc, true
+	}
+	cur := doc
+	for _, key := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return nil, false
+			}
+			cur = v[idx]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// lookupString returns the scalar at a dotted path as a string.
+func lookupString(doc interface{}, path string) (string, error) {
+	v, ok := lookup(doc, path)
+	if !ok || v == nil {
+		return "", fmt.Errorf("field %s not found", path)
+	}
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case json.Number:
+		return s.String(), nil
+	case bool:
+		return strconv.FormatBool(s), nil
+	default:
+		return "", fmt.Errorf("field %s is not a scalar", path)
+	}
+}
+
+// linkNext returns the URL of the next page from Link headers, e.g.
+// `<https://inventory.example.com/hosts?page=2>; rel="next"`.
+func linkNext(links []string) string {
+	for _, header := range links {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			u := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(u, "<") || !strings.HasSuffix(u, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				if strings.TrimSpace(param) == `rel="next"` || strings.TrimSpace(param) == "rel=next" {
+					return u[1 : len(u)-1]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// parsePage decodes a page of the inventory, and returns its targets and the
+// URL of the next page, if any.
+func parsePage(ap AcquireParameters, pageURL *url.URL, data []byte, header http.Header) ([]*target.Target, *url.URL, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("cannot decode response: %v", err)
+	}
+	items, ok := lookup(doc, ap.ItemsField)
+	if !ok {
+		return nil, nil, fmt.Errorf("field %s not found in response", ap.ItemsField)
+	}
+	list, ok := items.([]interface{})
+	if !ok {
+		if items != nil {
+			return nil, nil, errors.New("targets are not a list")
+		}
+		// some services return null for an empty page
+		list = nil
+	}
+	targets := make([]*target.Target, 0, len(list))
+	for idx, item := range list {
+		var (
+			t   target.Target
+			err error
+		)
+		if t.ID, err = lookupString(item, ap.IDField); err != nil || t.ID == "" {
+			return nil, nil, fmt.Errorf("target #%d has no ID: %v", idx, err)
+		}
+		if t.Name, err = lookupString(item, ap.NameField); err != nil {
+			return nil, nil, fmt.Errorf("target %s: %v", t.ID, err)
+		}
+		if ap.FQDNField != "" {
+			if t.FQDN, err = lookupString(item, ap.FQDNField); err != nil {
+				return nil, nil, fmt.Errorf("target %s: %v", t.ID, err)
+			}
+		}
+		targets = append(targets, &t)
+	}
+
+	var next string
+	if ap.NextField != "" {
+		if v, ok := lookup(doc, ap.NextField); ok && v != nil {
+			s, ok := v.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("field %s is not a URL", ap.NextField)
+			}
+			next = s
+		}
+	} else {
+		next = linkNext(header["Link"])
+	}
+	if next == "" {
+		return targets, nil, nil
+	}
+	nextURL, err := pageURL.Parse(next)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid next page URL %q: %v", next, err)
+	}
+	return targets, nextURL, nil
+}
+
+// Acquire implements contest.TargetManager.Acquire, fetching the pages of the
+// inventory and locking the targets they list.
+func (hi *HTTPInventory) Acquire(jobID types.JobID, cancel <-chan struct{}, parameters interface{}, tl target.Locker) ([]*target.Target, error) {
+	ap, ok := parameters.(AcquireParameters)
+	if !ok {
+		return nil, fmt.Errorf("Acquire expects %T object, got %T", ap, parameters)
+	}
+	ctx, cancelCtx := cancelContext(cancel)
+	defer cancelCtx()
+
+	pageURL, err := url.Parse(ap.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %v", ap.Endpoint, err)
+	}
+	var targets []*target.Target
+	seen := make(map[string]bool)
+	for page := 1; pageURL != nil; page++ {
+		if page > ap.MaxPages {
+			return nil, fmt.Errorf("inventory has more than %d pages", ap.MaxPages)
+		}
+		data, header, err := hi.do(ctx, ap.RequestParameters, http.MethodGet, pageURL.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch page %d of the inventory: %v", page, err)
+		}
+		var pageTargets []*target.Target
+		pageTargets, pageURL, err = parsePage(ap, pageURL, data, header)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page %d of the inventory: %v", page, err)
+		}
+		for _, t := range pageTargets {
+			if seen[t.ID] {
+				return nil, fmt.Errorf("duplicate target ID %q in the inventory", t.ID)
+			}
+			seen[t.ID] = true
+		}
+		if pageTargets, err = target.FilterQuarantined(pageTargets); err != nil {
+			return nil, err
+		}
+		targets = append(targets, pageTargets...)
+		if ap.MaxNumberDevices != 0 && uint32(len(targets)) >= ap.MaxNumberDevices {
+			break
+		}
+	}
+	if uint32(len(targets)) < ap.MinNumberDevices {
+		return nil, fmt.Errorf("not enough targets found in the inventory, want %d, got %d", ap.MinNumberDevices, len(targets))
+	}
+	if ap.MaxNumberDevices != 0 && uint32(len(targets)) > ap.MaxNumberDevices {
+		targets = targets[:ap.MaxNumberDevices]
+	}
+	log.Infof("Found %d targets in the inventory for job %v", len(targets), jobID)
+
+	if err := tl.Lock(jobID, targets); err != nil {
+		return nil, fmt.Errorf("failed to lock %d targets: %v", len(targets), err)
+	}
+	hi.acquired = targets
+	return targets, nil
+}
+
+// Release reports the acquired targets to the release callback, if any.
+func (hi *HTTPInventory) Release(jobID types.JobID, cancel <-chan struct{}, params interface{}) error {
+	rp, ok := params.(ReleaseParameters)
+	if !ok {
+		return fmt.Errorf("Release expects %T object, got %T", rp, params)
+	}
+	if rp.Endpoint == "" || len(hi.acquired) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(releasePayload{JobID: jobID, Targets: hi.acquired})
+	if err != nil {
+		return fmt.Errorf("cannot encode release payload: %v", err)
+	}
+	ctx, cancelCtx := cancelContext(cancel)
+	defer cancelCtx()
+	if _, _, err := hi.do(ctx, rp.RequestParameters, rp.Method, rp.Endpoint, body); err != nil {
+		return fmt.Errorf("could not release %d targets: %v", len(hi.acquired), err)
+	}
+	hi.acquired = nil
+	return nil
+}
+
+// New builds an HTTPInventory target manager.
+func New() target.TargetManager {
+	return &HTTPInventory{}
+}
+
+// Load returns the name and factory which are needed to register the
+// TargetManager.
+func Load() (string, target.TargetManagerFactory) {
+	return Name, New
+}
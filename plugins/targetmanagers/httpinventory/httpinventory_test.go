@@ -0,0 +1,179 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package httpinventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/types"
+	"github.com/facebookincubator/contest/plugins/targetlocker/inmemory"
+	"github.com/stretchr/testify/require"
+)
+
+// inventory serves two pages of hosts, failing the first request of the
+// second page with a 503.
+type inventory struct {
+	mu       sync.Mutex
+	failed   bool
+	released []byte
+}
+
+func (inv *inventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path + "?" + r.URL.RawQuery {
+	case "/hosts?":
+		fmt.Fprint(w, `{"data": {"hosts": [
+			{"asset_id": 101, "hostname": "host1", "network": {"fqdn": "host1.lab"}},
+			{"asset_id": 102, "hostname": "host2", "network": {"fqdn": "host2.lab"}}
+		]}, "links": {"next": "/hosts?page=2"}}`)
+	case "/hosts?page=2":
+		if !inv.failed {
+			inv.failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"data": {"hosts": [
+			{"asset_id": 103, "hostname": "host3", "network": {"fqdn": "host3.lab"}}
+		]}, "links": {"next": null}}`)
+	case "/release?":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		inv.released, _ = ioutil.ReadAll(r.Body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func validate(t *testing.T, acquire, release string) (interface{}, interface{}) {
+	hi := New()
+	ap, err := hi.ValidateAcquireParameters([]byte(acquire))
+	require.NoError(t, err)
+	rp, err := hi.ValidateReleaseParameters([]byte(release))
+	require.NoError(t, err)
+	return ap, rp
+}
+
+func TestAcquireAndRelease(t *testing.T) {
+	require.NoError(t, os.Setenv("HTTPINVENTORY_TEST_TOKEN", "s3cr3t"))
+	defer os.Unsetenv("HTTPINVENTORY_TEST_TOKEN")
+	inv := &inventory{}
+	srv := httptest.NewServer(inv)
+	defer srv.Close()
+
+	headers := `"Headers": {"Authorization": "Bearer ${env:HTTPINVENTORY_TEST_TOKEN}"}`
+	ap, rp := validate(t, fmt.Sprintf(`{
+		"Endpoint": "%s/hosts", %s,
+		"ItemsField": "$.data.hosts",
+		"IDField": "asset_id",
+		"NameField": "hostname",
+		"FQDNField": "network.fqdn",
+		"NextField": "links.next",
+		"RetryInterval": "10ms",
+		"MinNumberDevices": 3
+	}`, srv.URL, headers), fmt.Sprintf(`{"Endpoint": "%s/release", %s}`, srv.URL, headers))
+
+	tm := New()
+	tl := inmemory.New(time.Minute)
+	targets, err := tm.Acquire(types.JobID("7"), nil, ap, tl)
+	require.NoError(t, err)
+	require.Equal(t, []*target.Target{
+		{ID: "101", Name: "host1", FQDN: "host1.lab"},
+		{ID: "102", Name: "host2", FQDN: "host2.lab"},
+		{ID: "103", Name: "host3", FQDN: "host3.lab"},
+	}, targets)
+	require.True(t, inv.failed)
+	// the targets are locked for the job
+	_, _, notLocked := tl.CheckLocks(types.JobID("7"), targets)
+	require.Empty(t, notLocked)
+
+	require.NoError(t, tm.Release(types.JobID("7"), nil, rp))
+	var released releasePayload
+	require.NoError(t, json.Unmarshal(inv.released, &released))
+	require.Equal(t, types.JobID("7"), released.JobID)
+	require.Equal(t, targets, released.Targets)
+}
+
+func TestAcquireLinkHeaderAndLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			w.Header().Set("Link", `</list?page=2>; rel="next"`)
+			fmt.Fprint(w, `[{"id": "a", "name": "a"}, {"id": "b", "name": "b"}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"id": "c", "name": "c"}]`)
+	}))
+	defer srv.Close()
+
+	ap, _ := validate(t, fmt.Sprintf(`{"Endpoint": "%s/list", "MaxNumberDevices": 3}`, srv.URL), `{}`)
+	targets, err := New().Acquire(types.JobID("1"), nil, ap, inmemory.New(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, targets, 3)
+	require.Equal(t, "c", targets[2].ID)
+
+	ap, _ = validate(t, fmt.Sprintf(`{"Endpoint": "%s/list", "MaxNumberDevices": 1}`, srv.URL), `{}`)
+	targets, err = New().Acquire(types.JobID("2"), nil, ap, inmemory.New(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+
+	ap, _ = validate(t, fmt.Sprintf(`{"Endpoint": "%s/list", "MinNumberDevices": 4}`, srv.URL), `{}`)
+	_, err = New().Acquire(types.JobID("3"), nil, ap, inmemory.New(time.Minute))
+	require.Error(t, err)
+}
+
+func TestAcquireDoesNotRetryClientErrors(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	ap, _ := validate(t, fmt.Sprintf(`{"Endpoint": "%s", "RetryInterval": "10ms"}`, srv.URL), `{}`)
+	_, err := New().Acquire(types.JobID("1"), nil, ap, inmemory.New(time.Minute))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "403")
+	require.Equal(t, 1, requests)
+}
+
+func TestValidateParameters(t *testing.T) {
+	hi := New()
+	for _, params := range []string{
+		`{}`,
+		`{"Endpoint": "ftp://inventory/hosts"}`,
+		`{"Endpoint": "https://inventory/hosts", "IDField": "a..b"}`,
+		`{"Endpoint": "https://inventory/hosts", "Headers": {"X-Token": "${nope:x}"}}`,
+		`{"Endpoint": "https://inventory/hosts", "MinNumberDevices": 3, "MaxNumberDevices": 2}`,
+	} {
+		_, err := hi.ValidateAcquireParameters([]byte(params))
+		require.Error(t, err, params)
+	}
+	rp, err := hi.ValidateReleaseParameters([]byte(`{}`))
+	require.NoError(t, err)
+	// without a release endpoint, releasing does nothing
+	require.NoError(t, hi.Release(types.JobID("1"), nil, rp))
+}
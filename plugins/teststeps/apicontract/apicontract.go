@@ -0,0 +1,346 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package apicontract
+
+// The APIContract plugin calls an API on each target, and validates the
+// response against a JSON schema. Targets whose responses do not have the
+// expected status, are not JSON, or violate the schema fail the step.
+//
+// The schema is either given inline, or read from a file, which can be an
+// OpenAPI document: schema_pointer then selects the schema of the response
+// within the document, e.g. "#/components/schemas/Pet", and references to the
+// other components are resolved.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "APIContract"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventContractOK        = event.Name("TargetContractOK")
+	EventContractViolation = event.Name("TargetContractViolation")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventContractOK, EventContractViolation}
+
+const (
+	defaultRequestTimeout = 30 * time.Second
+	// maxResponseSize bounds the size of the API responses.
+	maxResponseSize = 16 * 1024 * 1024
+	// maxViolations bounds the number of violations reported per target.
+	maxViolations = 50
+	// secretPlaceholder replaces the secrets during validation.
+	secretPlaceholder = "secret"
+)
+
+// methods are the supported request methods.
+var methods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// contractEventPayload is the payload of the events emitted by this step.
+type contractEventPayload struct {
+	Method     string
+	Status     int
+	Violations []string `json:",omitempty"`
+	// Total is the number of violations, which may be more than reported.
+	Total int `json:",omitempty"`
+}
+
+// APIContract verifies that the API responses of the targets match a schema.
+type APIContract struct {
+	URL            *test.Param
+	Method         string
+	Body           *test.Param
+	Token          *test.Param
+	ExpectedStatus int
+	RequestTimeout time.Duration
+	Insecure       bool
+	Timeouts       teststeps.NetworkTimeouts
+
+	schema *schema
+}
+
+// Name returns the plugin name.
+func (ts APIContract) Name() string {
+	return Name
+}
+
+// call sends the request, and returns the status and body of the response.
+func (ts *APIContract) call(ctx context.Context, client *http.Client, u, token, body string) (int, []byte, error) {
+	rctx, cancel := context.WithTimeout(ctx, ts.RequestTimeout)
+	defer cancel()
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = bytes.NewReader([]byte(body))
+	}
+	req, err := http.NewRequest(ts.Method, u, reqBody)
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(rctx)
+	req.Header.Set("Accept", "application/json")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, nil, teststeps.ErrInterrupted
+		}
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, nil, teststeps.ErrInterrupted
+		}
+		return 0, nil, fmt.Errorf("cannot read response: %v", err)
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+// violations returns the contract violations of a response.
+func (ts *APIContract) violations(status int, body []byte) *violations {
+	vs := violations{limit: maxViolations}
+	if status != ts.ExpectedStatus {
+		vs.add("status", "got %d, expected %d", status, ts.ExpectedStatus)
+		return &vs
+	}
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		vs.add("$", "response is not valid JSON: %v", err)
+		return &vs
+	}
+	ts.schema.validate(doc, "$", &vs)
+	return &vs
+}
+
+func (ts *APIContract) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	u, err := ts.URL.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand url parameter: %v", err)
+	}
+	if u, err = secrets.Expand(u); err != nil {
+		return err
+	}
+	token, err := ts.Token.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand token parameter: %v", err)
+	}
+	if token, err = secrets.Expand(token); err != nil {
+		return err
+	}
+	body, err := ts.Body.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand body parameter: %v", err)
+	}
+
+	ctx, ctxCancel := teststeps.NewContext(cancel, pause)
+	defer ctxCancel()
+	client := teststeps.NewHTTPClient(teststeps.HTTPClientOptions{Timeouts: ts.Timeouts, InsecureSkipVerify: ts.Insecure})
+	defer client.CloseIdleConnections()
+	status, respBody, err := ts.call(ctx, client, u, token, body)
+	if err != nil {
+		if errors.Is(err, teststeps.ErrInterrupted) {
+			return err
+		}
+		// do not include the URL, which may contain secrets
+		return fmt.Errorf("API request failed: %v", err)
+	}
+
+	vs := ts.violations(status, respBody)
+	payload := contractEventPayload{Method: ts.Method, Status: status, Violations: vs.list, Total: vs.total}
+	if vs.total > 0 {
+		if err := teststeps.EmitTargetEvent(ev, EventContractViolation, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("response violates the contract in %d places: %s", vs.total, strings.Join(vs.list, "; "))
+	}
+	return teststeps.EmitTargetEvent(ev, EventContractOK, target, payload)
+}
+
+// Run executes the API contract step.
+func (ts *APIContract) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun validates the responses of GET requests normally, and only logs the
+// requests of the other methods, which may change the state of the targets.
+func (ts *APIContract) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	if ts.Method == http.MethodGet {
+		f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+			return ts.check(cancel, pause, target, ev)
+		}
+		return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+	}
+	describe := func(target *target.Target) (string, error) {
+		u, err := ts.URL.Expand(target)
+		if err != nil {
+			return "", fmt.Errorf("cannot expand url parameter: %v", err)
+		}
+		return fmt.Sprintf("send a %s request to %s", ts.Method, secrets.Mask(u, secretPlaceholder)), nil
+	}
+	return teststeps.DryRunEachTarget(Name, cancel, pause, ch, describe)
+}
+
+// loadSchema loads and compiles the schema, either inline or from a file.
+func loadSchema(params test.TestStepParameters) (*schema, error) {
+	inline := params.GetOne("schema").Raw()
+	file := params.GetOne("schema_file").Raw()
+	var data []byte
+	switch {
+	case inline != "" && file != "":
+		return nil, errors.New("'schema' and 'schema_file' parameters are mutually exclusive")
+	case inline != "":
+		data = []byte(inline)
+	case file != "":
+		var err error
+		if data, err = ioutil.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("cannot read schema file: %v", err)
+		}
+	default:
+		return nil, errors.New("missing 'schema' or 'schema_file' parameter")
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("cannot decode schema: %v", err)
+	}
+	pointer := params.GetOne("schema_pointer").Raw()
+	if pointer != "" && !strings.HasPrefix(pointer, "#") {
+		pointer = "#" + pointer
+	}
+	s, err := compileSchema(doc, pointer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	return s, nil
+}
+
+func (ts *APIContract) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.URL = params.GetOne("url")
+	if ts.URL.IsEmpty() {
+		return errors.New("invalid or missing 'url' parameter, must be exactly one string")
+	}
+	u, err := ts.URL.Expand(&target.Target{Name: "validation.example.com", ID: "validation"})
+	if err != nil {
+		return fmt.Errorf("invalid 'url' parameter: %v", err)
+	}
+	if err := secrets.Validate(u); err != nil {
+		return fmt.Errorf("invalid 'url' parameter: %v", err)
+	}
+	if err := teststeps.ValidateURL(secrets.Mask(u, secretPlaceholder), "url"); err != nil {
+		return err
+	}
+	ts.Method = strings.ToUpper(params.GetOne("method").Raw())
+	if ts.Method == "" {
+		ts.Method = http.MethodGet
+	}
+	if !methods[ts.Method] {
+		return fmt.Errorf("invalid 'method' parameter %q", ts.Method)
+	}
+	ts.Body = params.GetOne("body")
+	if !ts.Body.IsEmpty() {
+		if ts.Method == http.MethodGet {
+			return errors.New("'body' parameter cannot be used with the GET method")
+		}
+		if _, err := ts.Body.Expand(&target.Target{Name: "validation.example.com", ID: "validation"}); err != nil {
+			return fmt.Errorf("invalid 'body' parameter: %v", err)
+		}
+	}
+	ts.Token = params.GetOne("token")
+	if err := secrets.Validate(ts.Token.Raw()); err != nil {
+		return fmt.Errorf("invalid 'token' parameter: %v", err)
+	}
+	ts.ExpectedStatus = http.StatusOK
+	if s := params.GetOne("expected_status").Raw(); s != "" {
+		if ts.ExpectedStatus, err = strconv.Atoi(s); err != nil || ts.ExpectedStatus < 100 || ts.ExpectedStatus > 599 {
+			return fmt.Errorf("invalid 'expected_status' parameter %q, must be an HTTP status code", s)
+		}
+	}
+	if ts.schema, err = loadSchema(params); err != nil {
+		return err
+	}
+	if ts.RequestTimeout, err = teststeps.DurationParam(params, "request_timeout", defaultRequestTimeout); err != nil {
+		return err
+	}
+	if ts.Insecure, err = teststeps.BoolParam(params, "insecure", false); err != nil {
+		return err
+	}
+	if ts.Timeouts, err = teststeps.NewNetworkTimeouts(params); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *APIContract) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. APIContract
+// cannot resume.
+func (ts *APIContract) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *APIContract) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new APIContract test step.
+func New() test.TestStep {
+	return &APIContract{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
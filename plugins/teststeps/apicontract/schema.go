@@ -0,0 +1,658 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package apicontract
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// schema is a compiled JSON schema. The validation keywords of JSON Schema and
+// of the OpenAPI schema objects are supported, except for the formats, which
+// are annotations only. References must be local to the document, so that
+// the schemas of an OpenAPI document can refer to its components.
+type schema struct {
+	// always is set for the boolean schemas.
+	always *bool
+	ref    *schema
+
+	types    []string
+	nullable bool
+	enum     []interface{}
+	consts   []interface{}
+
+	properties           map[string]*schema
+	patternProperties    map[*regexp.Regexp]*schema
+	additionalProperties *schema
+	required             []string
+	minProperties        int
+	maxProperties        int
+
+	items           *schema
+	tupleItems      []*schema
+	additionalItems *schema
+	minItems        int
+	maxItems        int
+	uniqueItems     bool
+
+	minLength int
+	maxLength int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       float64
+
+	allOf []*schema
+	anyOf []*schema
+	oneOf []*schema
+	not   *schema
+}
+
+var jsonTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+// compiler compiles the schemas of a document, resolving the references.
+type compiler struct {
+	root interface{}
+	refs map[string]*schema
+}
+
+// compileSchema compiles the schema found at the given JSON pointer of a
+// decoded document, or the whole document if the pointer is empty.
+func compileSchema(doc interface{}, pointer string) (*schema, error) {
+	c := compiler{root: doc, refs: make(map[string]*schema)}
+	if pointer == "" {
+		return c.compile(doc, "#")
+	}
+	return c.resolve(pointer)
+}
+
+// resolve compiles the schema which a local reference points to. Schemas are
+// compiled once per reference, which allows recursive schemas.
+func (c *compiler) resolve(ref string) (*schema, error) {
+	if s, ok := c.refs[ref]; ok {
+		return s, nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported reference %q: only local references are supported", ref)
+	}
+	node := c.root
+	pointer, err := url.PathUnescape(strings.TrimPrefix(ref, "#"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference %q: %v", ref, err)
+	}
+	if pointer != "" {
+		if !strings.HasPrefix(pointer, "/") {
+			return nil, fmt.Errorf("invalid reference %q: must be a JSON pointer", ref)
+		}
+		for _, token := range strings.Split(pointer[1:], "/") {
+			token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+			switch n := node.(type) {
+			case map[string]interface{}:
+				var ok bool
+				if node, ok = n[token]; !ok {
+					return nil, fmt.Errorf("unresolvable reference %q", ref)
+				}
+			case []interface{}:
+				i, err := strconv.Atoi(token)
+				if err != nil || i < 0 || i >= len(n) {
+					return nil, fmt.Errorf("unresolvable reference %q", ref)
+				}
+				node = n[i]
+			default:
+				return nil, fmt.Errorf("unresolvable reference %q", ref)
+			}
+		}
+	}
+	s := &schema{}
+	c.refs[ref] = s
+	compiled, err := c.compile(node, ref)
+	if err != nil {
+		return nil, err
+	}
+	*s = *compiled
+	return s, nil
+}
+
+func (c *compiler) compile(node interface{}, path string) (*schema, error) {
+	if b, ok := node.(bool); ok {
+		return &schema{always: &b}, nil
+	}
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or a boolean", path)
+	}
+	s := schema{maxProperties: -1, maxItems: -1, maxLength: -1}
+	if ref, ok := obj["$ref"]; ok {
+		r, ok := ref.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: $ref must be a string", path)
+		}
+		var err error
+		if s.ref, err = c.resolve(r); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		// the keywords next to a reference are ignored
+		return &s, nil
+	}
+	kw := keywords{c: c, obj: obj, path: path}
+	kw.types(&s.types)
+	kw.bool("nullable", &s.nullable)
+	kw.list("enum", &s.enum)
+	if v, ok := obj["const"]; ok {
+		s.consts = []interface{}{v}
+	}
+	kw.schemaMap("properties", &s.properties)
+	kw.patternMap("patternProperties", &s.patternProperties)
+	kw.schema("additionalProperties", &s.additionalProperties)
+	kw.strings("required", &s.required)
+	kw.count("minProperties", &s.minProperties)
+	kw.count("maxProperties", &s.maxProperties)
+	if _, ok := obj["items"].([]interface{}); ok {
+		kw.schemaList("items", &s.tupleItems)
+	} else {
+		kw.schema("items", &s.items)
+	}
+	kw.schema("additionalItems", &s.additionalItems)
+	kw.count("minItems", &s.minItems)
+	kw.count("maxItems", &s.maxItems)
+	kw.bool("uniqueItems", &s.uniqueItems)
+	kw.count("minLength", &s.minLength)
+	kw.count("maxLength", &s.maxLength)
+	kw.regexp("pattern", &s.pattern)
+	kw.number("minimum", &s.minimum)
+	kw.number("maximum", &s.maximum)
+	// exclusive bounds are booleans modifying minimum and maximum in draft 4
+	// and OpenAPI 3.0, and numbers in later drafts
+	kw.exclusive("exclusiveMinimum", &s.minimum, &s.exclusiveMinimum)
+	kw.exclusive("exclusiveMaximum", &s.maximum, &s.exclusiveMaximum)
+	var multipleOf *float64
+	kw.number("multipleOf", &multipleOf)
+	if multipleOf != nil {
+		if *multipleOf <= 0 {
+			kw.fail("multipleOf", "must be strictly positive")
+		} else {
+			s.multipleOf = *multipleOf
+		}
+	}
+	kw.schemaList("allOf", &s.allOf)
+	kw.schemaList("anyOf", &s.anyOf)
+	kw.schemaList("oneOf", &s.oneOf)
+	kw.schema("not", &s.not)
+	if kw.err != nil {
+		return nil, kw.err
+	}
+	return &s, nil
+}
+
+// keywords parses the keywords of a schema object, recording the first
+// error.
+type keywords struct {
+	c    *compiler
+	obj  map[string]interface{}
+	path string
+	err  error
+}
+
+func (k *keywords) fail(name, msg string) {
+	if k.err == nil {
+		k.err = fmt.Errorf("%s: invalid %s: %s", k.path, name, msg)
+	}
+}
+
+func (k *keywords) get(name string) (interface{}, bool) {
+	if k.err != nil {
+		return nil, false
+	}
+	v, ok := k.obj[name]
+	return v, ok
+}
+
+func (k *keywords) types(dst *[]string) {
+	v, ok := k.get("type")
+	if !ok {
+		return
+	}
+	var names []string
+	switch t := v.(type) {
+	case string:
+		names = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			name, ok := item.(string)
+			if !ok {
+				k.fail("type", "must be a string or a list of strings")
+				return
+			}
+			names = append(names, name)
+		}
+	default:
+		k.fail("type", "must be a string or a list of strings")
+		return
+	}
+	for _, name := range names {
+		if !jsonTypes[name] {
+			k.fail("type", fmt.Sprintf("unknown type %q", name))
+			return
+		}
+	}
+	*dst = names
+}
+
+func (k *keywords) bool(name string, dst *bool) {
+	v, ok := k.get(name)
+	if !ok {
+		return
+	}
+	b, ok := v.(bool)
+	if !ok {
+		k.fail(name, "must be a boolean")
+		return
+	}
+	*dst = b
+}
+
+func (k *keywords) list(name string, dst *[]interface{}) {
+	v, ok := k.get(name)
+	if !ok {
+		return
+	}
+	l, ok := v.([]interface{})
+	if !ok {
+		k.fail(name, "must be a list")
+		return
+	}
+	*dst = l
+}
+
+func (k *keywords) strings(name string, dst *[]string) {
+	var l []interface{}
+	k.list(name, &l)
+	for _, item := range l {
+		s, ok := item.(string)
+		if !ok {
+			k.fail(name, "must be a list of strings")
+			return
+		}
+		*dst = append(*dst, s)
+	}
+}
+
+func (k *keywords) number(name string, dst **float64) {
+	v, ok := k.get(name)
+	if !ok {
+		return
+	}
+	f, ok := v.(float64)
+	if !ok {
+		k.fail(name, "must be a number")
+		return
+	}
+	*dst = &f
+}
+
+func (k *keywords) count(name string, dst *int) {
+	var f *float64
+	k.number(name, &f)
+	if f == nil {
+		return
+	}
+	if *f < 0 || *f != math.Trunc(*f) {
+		k.fail(name, "must be a non-negative integer")
+		return
+	}
+	*dst = int(*f)
+}
+
+func (k *keywords) exclusive(name string, bound **float64, dst **float64) {
+	v, ok := k.get(name)
+	if !ok {
+		return
+	}
+	switch e := v.(type) {
+	case bool:
+		if e {
+			if *bound == nil {
+				k.fail(name, "requires the corresponding bound")
+				return
+			}
+			*dst, *bound = *bound, nil
+		}
+	case float64:
+		*dst = &e
+	default:
+		k.fail(name, "must be a boolean or a number")
+	}
+}
+
+func (k *keywords) regexp(name string, dst **regexp.Regexp) {
+	v, ok := k.get(name)
+	if !ok {
+		return
+	}
+	p, ok := v.(string)
+	if !ok {
+		k.fail(name, "must be a string")
+		return
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		k.fail(name, err.Error())
+		return
+	}
+	*dst = re
+}
+
+func (k *keywords) schema(name string, dst **schema) {
+	v, ok := k.get(name)
+	if !ok {
+		return
+	}
+	s, err := k.c.compile(v, k.path+"/"+name)
+	if err != nil {
+		k.err = err
+		return
+	}
+	*dst = s
+}
+
+func (k *keywords) schemaList(name string, dst *[]*schema) {
+	var l []interface{}
+	k.list(name, &l)
+	for i, item := range l {
+		s, err := k.c.compile(item, fmt.Sprintf("%s/%s/%d", k.path, name, i))
+		if err != nil {
+			k.err = err
+			return
+		}
+		*dst = append(*dst, s)
+	}
+}
+
+func (k *keywords) schemaMap(name string, dst *map[string]*schema) {
+	v, ok := k.get(name)
+	if !ok {
+		return
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		k.fail(name, "must be an object")
+		return
+	}
+	*dst = make(map[string]*schema, len(m))
+	for key, item := range m {
+		s, err := k.c.compile(item, k.path+"/"+name+"/"+key)
+		if err != nil {
+			k.err = err
+			return
+		}
+		(*dst)[key] = s
+	}
+}
+
+func (k *keywords) patternMap(name string, dst *map[*regexp.Regexp]*schema) {
+	var m map[string]*schema
+	k.schemaMap(name, &m)
+	if len(m) == 0 {
+		return
+	}
+	*dst = make(map[*regexp.Regexp]*schema, len(m))
+	for p, s := range m {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			k.fail(name, err.Error())
+			return
+		}
+		(*dst)[re] = s
+	}
+}
+
+// typeOf returns the JSON type of a decoded value.
+func typeOf(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case float64:
+		if n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func hasType(types []string, t string) bool {
+	for _, name := range types {
+		if name == t || (name == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if reflect.DeepEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// violations collects the violations found by the validation, up to a limit.
+type violations struct {
+	list  []string
+	limit int
+	total int
+}
+
+func (vs *violations) add(path, format string, args ...interface{}) {
+	vs.total++
+	if len(vs.list) < vs.limit {
+		vs.list = append(vs.list, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// matches tells whether a value is valid against a schema, without
+// recording the violations.
+func (s *schema) matches(v interface{}) bool {
+	vs := violations{limit: 0}
+	s.validate(v, "$", &vs)
+	return vs.total == 0
+}
+
+// validate records the violations of a value, found at the given path of
+// the document.
+func (s *schema) validate(v interface{}, path string, vs *violations) {
+	if s.always != nil {
+		if !*s.always {
+			vs.add(path, "no value is allowed")
+		}
+		return
+	}
+	if s.ref != nil {
+		s.ref.validate(v, path, vs)
+		return
+	}
+	t := typeOf(v)
+	if v == nil && s.nullable {
+		return
+	}
+	if len(s.types) > 0 && !hasType(s.types, t) {
+		vs.add(path, "expected %s, got %s", strings.Join(s.types, " or "), t)
+		return
+	}
+	if s.enum != nil && !contains(s.enum, v) {
+		vs.add(path, "value %v is not one of the allowed values", v)
+	}
+	if s.consts != nil && !contains(s.consts, v) {
+		vs.add(path, "value %v is not the expected constant", v)
+	}
+	switch value := v.(type) {
+	case map[string]interface{}:
+		s.validateObject(value, path, vs)
+	case []interface{}:
+		s.validateArray(value, path, vs)
+	case string:
+		s.validateString(value, path, vs)
+	case float64:
+		s.validateNumber(value, path, vs)
+	}
+	for _, sub := range s.allOf {
+		sub.validate(v, path, vs)
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if sub.matches(v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			vs.add(path, "value does not match any of the anyOf schemas")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.matches(v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			vs.add(path, "value matches %d of the oneOf schemas, expected exactly 1", matched)
+		}
+	}
+	if s.not != nil && s.not.matches(v) {
+		vs.add(path, "value matches the schema it must not match")
+	}
+}
+
+func (s *schema) validateObject(obj map[string]interface{}, path string, vs *violations) {
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			vs.add(path, "missing required property %q", name)
+		}
+	}
+	if len(obj) < s.minProperties {
+		vs.add(path, "has %d properties, expected at least %d", len(obj), s.minProperties)
+	}
+	if s.maxProperties >= 0 && len(obj) > s.maxProperties {
+		vs.add(path, "has %d properties, expected at most %d", len(obj), s.maxProperties)
+	}
+	// sort the keys for the violations to be reported in a stable order
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := obj[key]
+		propPath := path + "." + key
+		matched := false
+		if sub, ok := s.properties[key]; ok {
+			matched = true
+			sub.validate(value, propPath, vs)
+		}
+		for re, sub := range s.patternProperties {
+			if re.MatchString(key) {
+				matched = true
+				sub.validate(value, propPath, vs)
+			}
+		}
+		if !matched && s.additionalProperties != nil {
+			if a := s.additionalProperties; a.always != nil && !*a.always {
+				vs.add(path, "unexpected property %q", key)
+			} else {
+				a.validate(value, propPath, vs)
+			}
+		}
+	}
+}
+
+func (s *schema) validateArray(arr []interface{}, path string, vs *violations) {
+	if len(arr) < s.minItems {
+		vs.add(path, "has %d items, expected at least %d", len(arr), s.minItems)
+	}
+	if s.maxItems >= 0 && len(arr) > s.maxItems {
+		vs.add(path, "has %d items, expected at most %d", len(arr), s.maxItems)
+	}
+	for i, item := range arr {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case s.items != nil:
+			s.items.validate(item, itemPath, vs)
+		case i < len(s.tupleItems):
+			s.tupleItems[i].validate(item, itemPath, vs)
+		case s.tupleItems != nil && s.additionalItems != nil:
+			s.additionalItems.validate(item, itemPath, vs)
+		}
+	}
+	if s.uniqueItems {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if reflect.DeepEqual(arr[i], arr[j]) {
+					vs.add(path, "items %d and %d are equal, expected unique items", i, j)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *schema) validateString(str string, path string, vs *violations) {
+	// lengths are counted in characters
+	n := len([]rune(str))
+	if n < s.minLength {
+		vs.add(path, "has length %d, expected at least %d", n, s.minLength)
+	}
+	if s.maxLength >= 0 && n > s.maxLength {
+		vs.add(path, "has length %d, expected at most %d", n, s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		vs.add(path, "%q does not match pattern %q", str, s.pattern.String())
+	}
+}
+
+func (s *schema) validateNumber(f float64, path string, vs *violations) {
+	if s.minimum != nil && f < *s.minimum {
+		vs.add(path, "%v is less than the minimum %v", f, *s.minimum)
+	}
+	if s.maximum != nil && f > *s.maximum {
+		vs.add(path, "%v is greater than the maximum %v", f, *s.maximum)
+	}
+	if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+		vs.add(path, "%v is not greater than %v", f, *s.exclusiveMinimum)
+	}
+	if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+		vs.add(path, "%v is not less than %v", f, *s.exclusiveMaximum)
+	}
+	if s.multipleOf > 0 {
+		if q := f / s.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+			vs.add(path, "%v is not a multiple of %v", f, s.multipleOf)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package apicontract
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, data string) interface{} {
+	var v interface{}
+	require.NoError(t, json.Unmarshal([]byte(data), &v), data)
+	return v
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		schema     string
+		value      string
+		violations []string
+	}{
+		// types
+		{"type mismatch", `{"type": "integer"}`, `"1"`, []string{"$: expected integer, got string"}},
+		{"integer is a number", `{"type": "number"}`, `3`, nil},
+		{"number is not an integer", `{"type": "integer"}`, `1.5`, []string{"$: expected integer, got number"}},
+		{"type list", `{"type": ["string", "null"]}`, `null`, nil},
+		{"type list mismatch", `{"type": ["string", "null"]}`, `true`, []string{"$: expected string or null, got boolean"}},
+		{"nullable", `{"type": "object", "nullable": true}`, `null`, nil},
+		{"not nullable", `{"type": "object"}`, `null`, []string{"$: expected object, got null"}},
+		{"type mismatch skips the other keywords", `{"type": "string", "minLength": 5}`, `3`, []string{"$: expected string, got integer"}},
+
+		// objects
+		{"required present", `{"required": ["id", "name"]}`, `{"id": 1, "name": "a"}`, nil},
+		{"required missing", `{"required": ["id", "name"]}`, `{"name": "a"}`, []string{`$: missing required property "id"`}},
+		{"required null is present", `{"required": ["id"]}`, `{"id": null}`, nil},
+		{
+			"nested property type",
+			`{"properties": {"owner": {"type": "object", "required": ["email"], "properties": {"email": {"type": "string"}}}}}`,
+			`{"owner": {"email": 3}}`,
+			[]string{"$.owner.email: expected string, got integer"},
+		},
+		{
+			"nested required",
+			`{"properties": {"owner": {"type": "object", "required": ["email"], "properties": {"email": {"type": "string"}}}}}`,
+			`{"owner": {}}`,
+			[]string{`$.owner: missing required property "email"`},
+		},
+		{"additional properties forbidden", `{"properties": {"id": {}}, "additionalProperties": false}`, `{"id": 1, "extra": 2}`, []string{`$: unexpected property "extra"`}},
+		{"additional properties schema", `{"additionalProperties": {"type": "string"}}`, `{"a": "x", "b": 1}`, []string{"$.b: expected string, got integer"}},
+		{
+			"pattern properties",
+			`{"patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": false}`,
+			`{"x-a": "1", "x-b": 2, "y": 3}`,
+			[]string{"$.x-b: expected string, got integer", `$: unexpected property "y"`},
+		},
+		{"min properties", `{"minProperties": 2}`, `{"a": 1}`, []string{"$: has 1 properties, expected at least 2"}},
+		{"max properties", `{"maxProperties": 1}`, `{"a": 1, "b": 2}`, []string{"$: has 2 properties, expected at most 1"}},
+
+		// arrays
+		{"items", `{"type": "array", "items": {"type": "integer"}}`, `[1, "2", 3]`, []string{"$[1]: expected integer, got string"}},
+		{
+			"nested arrays and objects",
+			`{"type": "array", "items": {"type": "object", "required": ["id"], "properties": {"tags": {"type": "array", "items": {"type": "string"}}}}}`,
+			`[{"id": 1, "tags": ["a", 2]}, {"tags": []}]`,
+			[]string{"$[0].tags[1]: expected string, got integer", `$[1]: missing required property "id"`},
+		},
+		{"tuple items", `{"items": [{"type": "string"}, {"type": "integer"}]}`, `[1, "a"]`, []string{"$[0]: expected string, got integer", "$[1]: expected integer, got string"}},
+		{"tuple allows additional items", `{"items": [{"type": "string"}]}`, `["a", 1]`, nil},
+		{"tuple forbids additional items", `{"items": [{"type": "string"}], "additionalItems": false}`, `["a", 1]`, []string{"$[1]: no value is allowed"}},
+		{"min items", `{"minItems": 2, "maxItems": 3}`, `[1]`, []string{"$: has 1 items, expected at least 2"}},
+		{"max items", `{"minItems": 2, "maxItems": 3}`, `[1, 2, 3, 4]`, []string{"$: has 4 items, expected at most 3"}},
+		{"unique items", `{"uniqueItems": true}`, `[1, {"a": 1}, {"a": 1}]`, []string{"$: items 1 and 2 are equal, expected unique items"}},
+		{"distinct items", `{"uniqueItems": true}`, `[1, "1", {"a": 1}, {"a": 2}]`, nil},
+
+		// strings
+		{"length in characters", `{"maxLength": 2}`, `"éé"`, nil},
+		{"min length", `{"minLength": 3}`, `"éé"`, []string{"$: has length 2, expected at least 3"}},
+		{"max length", `{"maxLength": 1}`, `"ab"`, []string{"$: has length 2, expected at most 1"}},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"abc1"`, []string{`$: "abc1" does not match pattern "^[a-z]+$"`}},
+		{"pattern is not anchored", `{"pattern": "[0-9]"}`, `"abc1"`, nil},
+
+		// numbers
+		{"minimum", `{"minimum": 1, "maximum": 10}`, `0`, []string{"$: 0 is less than the minimum 1"}},
+		{"maximum", `{"minimum": 1, "maximum": 10}`, `11`, []string{"$: 11 is greater than the maximum 10"}},
+		{"inclusive bounds", `{"minimum": 1, "maximum": 10}`, `10`, nil},
+		{"boolean exclusive minimum", `{"minimum": 1, "exclusiveMinimum": true}`, `1`, []string{"$: 1 is not greater than 1"}},
+		{"boolean exclusive maximum off", `{"maximum": 1, "exclusiveMaximum": false}`, `1`, nil},
+		{"numeric exclusive maximum", `{"exclusiveMaximum": 10}`, `10`, []string{"$: 10 is not less than 10"}},
+		{"decimal multiple", `{"multipleOf": 0.1}`, `0.3`, nil},
+		{"not a multiple", `{"multipleOf": 0.1}`, `0.35`, []string{"$: 0.35 is not a multiple of 0.1"}},
+
+		// values
+		{"enum", `{"enum": ["a", 1]}`, `1`, nil},
+		{"not in enum", `{"enum": ["a", 1]}`, `"b"`, []string{"$: value b is not one of the allowed values"}},
+		{"const", `{"const": {"a": [1]}}`, `{"a": [1]}`, nil},
+		{"not the const", `{"const": {"a": [1]}}`, `{"a": [2]}`, []string{"$: value map[a:[2]] is not the expected constant"}},
+
+		// combinations
+		{"all of", `{"allOf": [{"required": ["a"]}, {"required": ["b"]}]}`, `{}`, []string{`$: missing required property "a"`, `$: missing required property "b"`}},
+		{"any of", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `2`, nil},
+		{"none of any of", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `true`, []string{"$: value does not match any of the anyOf schemas"}},
+		{"one of", `{"oneOf": [{"type": "string"}, {"type": "integer"}]}`, `2`, nil},
+		{"several of one of", `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, `1`, []string{"$: value matches 2 of the oneOf schemas, expected exactly 1"}},
+		{"not", `{"not": {"type": "null"}}`, `null`, []string{"$: value matches the schema it must not match"}},
+		{"true schema", `true`, `{"a": 1}`, nil},
+		{"false schema", `false`, `1`, []string{"$: no value is allowed"}},
+
+		// references
+		{
+			"reference",
+			`{"definitions": {"id": {"type": "integer", "minimum": 1}}, "properties": {"id": {"$ref": "#/definitions/id"}}}`,
+			`{"id": 0}`,
+			[]string{"$.id: 0 is less than the minimum 1"},
+		},
+		{
+			"recursive reference",
+			`{"definitions": {"node": {"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#/definitions/node"}}, "value": {"type": "integer"}}}}, "$ref": "#/definitions/node"}`,
+			`{"children": [{"children": [{"value": "x"}]}]}`,
+			[]string{"$.children[0].children[0].value: expected integer, got string"},
+		},
+		{
+			"escaped reference",
+			`{"definitions": {"a/b": {"type": "string"}, "c~d": {"type": "integer"}}, "properties": {"x": {"$ref": "#/definitions/a~1b"}, "y": {"$ref": "#/definitions/c~0d"}}}`,
+			`{"x": 1, "y": "1"}`,
+			[]string{"$.x: expected string, got integer", "$.y: expected integer, got string"},
+		},
+		{
+			"keywords next to a reference",
+			`{"definitions": {"s": {"type": "string"}}, "properties": {"x": {"$ref": "#/definitions/s", "minLength": 5}}}`,
+			`{"x": "ab"}`,
+			nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := compileSchema(decode(t, tc.schema), "")
+			require.NoError(t, err)
+			vs := violations{limit: 10}
+			s.validate(decode(t, tc.value), "$", &vs)
+			require.Equal(t, tc.violations, vs.list)
+			require.Equal(t, len(tc.violations), vs.total)
+			require.Equal(t, tc.violations == nil, s.matches(decode(t, tc.value)))
+		})
+	}
+}
+
+func TestCompileMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		schema string
+		err    string
+	}{
+		{"not an object", `"string"`, "#: schema must be an object or a boolean"},
+		{"unknown type", `{"type": "int"}`, `#: invalid type: unknown type "int"`},
+		{"type not a string", `{"type": 3}`, "#: invalid type: must be a string or a list of strings"},
+		{"type list not strings", `{"type": ["string", 3]}`, "#: invalid type: must be a string or a list of strings"},
+		{"nullable not a boolean", `{"nullable": "yes"}`, "#: invalid nullable: must be a boolean"},
+		{"enum not a list", `{"enum": "a"}`, "#: invalid enum: must be a list"},
+		{"required not strings", `{"required": [1]}`, "#: invalid required: must be a list of strings"},
+		{"properties not an object", `{"properties": []}`, "#: invalid properties: must be an object"},
+		{"negative count", `{"minItems": -1}`, "#: invalid minItems: must be a non-negative integer"},
+		{"fractional count", `{"maxLength": 1.5}`, "#: invalid maxLength: must be a non-negative integer"},
+		{"minimum not a number", `{"minimum": "1"}`, "#: invalid minimum: must be a number"},
+		{"zero multiple", `{"multipleOf": 0}`, "#: invalid multipleOf: must be strictly positive"},
+		{"exclusive bound without bound", `{"exclusiveMinimum": true}`, "#: invalid exclusiveMinimum: requires the corresponding bound"},
+		{"exclusive bound not a number", `{"exclusiveMaximum": "1"}`, "#: invalid exclusiveMaximum: must be a boolean or a number"},
+		{"invalid pattern", `{"pattern": "("}`, "#: invalid pattern: error parsing regexp"},
+		{"pattern not a string", `{"pattern": 1}`, "#: invalid pattern: must be a string"},
+		{"invalid pattern property", `{"patternProperties": {"(": {}}}`, "#: invalid patternProperties: error parsing regexp"},
+		{"nested malformed schema", `{"properties": {"a": {"items": {"type": "x"}}}}`, `#/properties/a/items: invalid type: unknown type "x"`},
+		{"malformed schema in a list", `{"allOf": [{}, 1]}`, "#/allOf/1: schema must be an object or a boolean"},
+		{"malformed tuple item", `{"items": [{"minItems": "a"}]}`, "#/items/0: invalid minItems: must be a number"},
+		{"reference not a string", `{"$ref": 1}`, "#: $ref must be a string"},
+		{"remote reference", `{"$ref": "http://example.com/schema.json"}`, `#: unsupported reference "http://example.com/schema.json"`},
+		{"unresolvable reference", `{"$ref": "#/definitions/missing"}`, `#: unresolvable reference "#/definitions/missing"`},
+		{"reference out of a list", `{"allOf": [{"$ref": "#/allOf/5"}]}`, `#/allOf/0: unresolvable reference "#/allOf/5"`},
+		{"reference not a pointer", `{"$ref": "#definitions"}`, `#: invalid reference "#definitions": must be a JSON pointer`},
+		{"reference to a malformed schema", `{"definitions": {"a": 1}, "$ref": "#/definitions/a"}`, "#: #/definitions/a: schema must be an object or a boolean"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := compileSchema(decode(t, tc.schema), "")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestCompilePointer(t *testing.T) {
+	doc := decode(t, `{
+		"components": {
+			"schemas": {
+				"Pet": {
+					"type": "object",
+					"required": ["name"],
+					"properties": {
+						"name": {"type": "string"},
+						"tags": {"type": "array", "items": {"$ref": "#/components/schemas/Tag"}}
+					}
+				},
+				"Tag": {"type": "string", "minLength": 1}
+			}
+		}
+	}`)
+	s, err := compileSchema(doc, "#/components/schemas/Pet")
+	require.NoError(t, err)
+	vs := violations{limit: 10}
+	s.validate(decode(t, `{"tags": ["a", ""]}`), "$", &vs)
+	require.Equal(t, []string{`$: missing required property "name"`, "$.tags[1]: has length 0, expected at least 1"}, vs.list)
+
+	_, err = compileSchema(doc, "#/components/schemas/Owner")
+	require.EqualError(t, err, `unresolvable reference "#/components/schemas/Owner"`)
+}
+
+func TestViolationsLimit(t *testing.T) {
+	s, err := compileSchema(decode(t, `{"items": {"type": "string"}}`), "")
+	require.NoError(t, err)
+	vs := violations{limit: 2}
+	s.validate(decode(t, `[1, 2, 3]`), "$", &vs)
+	require.Equal(t, []string{"$[0]: expected string, got integer", "$[1]: expected string, got integer"}, vs.list)
+	require.Equal(t, 3, vs.total)
+}
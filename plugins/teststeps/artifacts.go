@@ -0,0 +1,32 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package teststeps
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+)
+
+// artifactAttacher is implemented by the emitters which can store artifacts.
+type artifactAttacher interface {
+	AttachArtifact(t *target.Target, name string, r io.Reader) error
+}
+
+// AttachArtifact stores the content read from r, e.g. a log or a crash dump
+// collected from the target, as the artifact name of the target, which
+// reporters and API clients can download once the step is done with it. An
+// artifact with the same name is replaced. It fails if the emitter cannot
+// store artifacts, e.g. in dry runs.
+func AttachArtifact(ev testevent.Emitter, target *target.Target, name string, r io.Reader) error {
+	a, ok := ev.(artifactAttacher)
+	if !ok {
+		return fmt.Errorf("cannot attach artifact %s: the emitter does not store artifacts", name)
+	}
+	return a.AttachArtifact(target, name, r)
+}
@@ -0,0 +1,381 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package auditcheck
+
+// The AuditCheck plugin verifies over SSH that the kernel audit subsystem of
+// each target is enabled, that an audit daemon such as auditd is receiving
+// its records, and that the loaded audit rules include the required ones.
+// Targets without the required configuration fail with a
+// TargetAuditMisconfigured event.
+//
+// Rules are written as passed to auditctl, e.g. "-w /etc/passwd -p wa -k
+// identity" or "-a always,exit -F arch=b64 -S settimeofday -k time-change".
+// They are compared with the output of "auditctl -l" regardless of the order
+// of their options, and of the syscalls and permissions in their lists.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "AuditCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventAuditConfigured    = event.Name("TargetAuditConfigured")
+	EventAuditMisconfigured = event.Name("TargetAuditMisconfigured")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventAuditConfigured, EventAuditMisconfigured}
+
+const (
+	defaultAuditctl = "auditctl"
+	// separator separates the status from the rules in the command output.
+	separator = "--- rules ---"
+	// enabledImmutable is the enabled status of a locked configuration,
+	// which cannot change until reboot.
+	enabledImmutable = 2
+)
+
+// ruleOptions are the auditctl options allowed in rules, and whether they
+// are repeatable.
+var ruleOptions = map[string]bool{
+	"-w": false, "-p": false, "-k": true,
+	"-a": false, "-A": false, "-S": true, "-F": true, "-C": true,
+}
+
+// canonicalRule returns the canonical form of an audit rule, in which the
+// options are sorted, as well as the values of the list options.
+func canonicalRule(rule string) (string, error) {
+	args, err := shellquote.Split(rule)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse rule %q: %v", rule, err)
+	}
+	if len(args) == 0 {
+		return "", errors.New("empty rule")
+	}
+	values := make(map[string][]string)
+	for i := 0; i < len(args); i += 2 {
+		opt := args[i]
+		repeatable, ok := ruleOptions[opt]
+		if !ok {
+			return "", fmt.Errorf("unsupported option %q in rule %q", opt, rule)
+		}
+		if i+1 >= len(args) {
+			return "", fmt.Errorf("missing value for option %s in rule %q", opt, rule)
+		}
+		if _, seen := values[opt]; seen && !repeatable {
+			return "", fmt.Errorf("option %s specified more than once in rule %q", opt, rule)
+		}
+		value := args[i+1]
+		switch opt {
+		case "-a", "-A":
+			// the list and action can be written in either order
+			parts := strings.Split(value, ",")
+			sort.Strings(parts)
+			value = strings.Join(parts, ",")
+		case "-S":
+			// syscalls can be given as a list, or as repeated options
+			values[opt] = append(values[opt], strings.Split(value, ",")...)
+			continue
+		case "-p":
+			if strings.Trim(value, "rwxa") != "" {
+				return "", fmt.Errorf("invalid permissions %q in rule %q, must be a combination of r, w, x and a", value, rule)
+			}
+			perms := strings.Split(value, "")
+			sort.Strings(perms)
+			value = strings.Join(perms, "")
+		case "-k":
+			// keys can also be set as the key field
+			opt = "-F"
+			value = "key=" + value
+		}
+		values[opt] = append(values[opt], value)
+	}
+	_, watch := values["-w"]
+	_, appendRule := values["-a"]
+	_, prependRule := values["-A"]
+	switch {
+	case watch && (appendRule || prependRule):
+		return "", fmt.Errorf("rule %q cannot both watch a file and add a syscall rule", rule)
+	case !watch && !appendRule && !prependRule:
+		return "", fmt.Errorf("rule %q must either watch a file (-w) or add a syscall rule (-a)", rule)
+	case appendRule && prependRule:
+		return "", fmt.Errorf("rule %q cannot use both -a and -A", rule)
+	}
+	if prependRule {
+		// the position of the rule is not part of the loaded rules
+		values["-a"] = values["-A"]
+		delete(values, "-A")
+	}
+	var opts []string
+	for opt := range values {
+		opts = append(opts, opt)
+	}
+	// the watched path or the list come first
+	sort.Slice(opts, func(i, j int) bool {
+		if (opts[i] == "-w" || opts[i] == "-a") != (opts[j] == "-w" || opts[j] == "-a") {
+			return opts[i] == "-w" || opts[i] == "-a"
+		}
+		return opts[i] < opts[j]
+	})
+	var canonical []string
+	for _, opt := range opts {
+		v := values[opt]
+		sort.Strings(v)
+		if opt == "-S" {
+			v = []string{strings.Join(v, ",")}
+		}
+		for _, value := range v {
+			canonical = append(canonical, opt, shellquote.Join(value))
+		}
+	}
+	return strings.Join(canonical, " "), nil
+}
+
+// auditStatus is the status of the audit subsystem, as reported by
+// "auditctl -s".
+type auditStatus struct {
+	Enabled int
+	PID     int
+}
+
+// parseStatus parses the output of "auditctl -s", which is either one
+// "key value" pair per line, or "key=value" pairs for older versions.
+func parseStatus(out string) (*auditStatus, error) {
+	var (
+		status             auditStatus
+		hasEnabled, hasPID bool
+	)
+	fields := strings.Fields(strings.Replace(out, "=", " ", -1))
+	for i := 0; i+1 < len(fields); i++ {
+		var err error
+		switch fields[i] {
+		case "enabled":
+			status.Enabled, err = strconv.Atoi(fields[i+1])
+			hasEnabled = true
+		case "pid":
+			status.PID, err = strconv.Atoi(fields[i+1])
+			hasPID = true
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse audit status %q: %v", fields[i+1], err)
+		}
+		i++
+	}
+	if !hasEnabled || !hasPID {
+		return nil, fmt.Errorf("cannot parse audit status: %q", out)
+	}
+	return &status, nil
+}
+
+// auditEventPayload is the payload of the events emitted by this step.
+type auditEventPayload struct {
+	Enabled int
+	PID     int
+	// Missing lists the required rules which are not loaded.
+	Missing []string `json:",omitempty"`
+	// Extra lists the loaded rules which are not required, if only the
+	// required rules are allowed.
+	Extra    []string `json:",omitempty"`
+	Problems []string `json:",omitempty"`
+}
+
+// AuditCheck verifies the audit configuration of the targets.
+type AuditCheck struct {
+	*teststeps.SSHParams
+	Auditctl string
+	Sudo     bool
+	// Rules are the required rules, in canonical form.
+	Rules []string
+	// Exact, if true, fails the targets with rules other than the required
+	// ones.
+	Exact     bool
+	Immutable bool
+}
+
+// Name returns the plugin name.
+func (ts AuditCheck) Name() string {
+	return Name
+}
+
+func (ts *AuditCheck) command() string {
+	auditctl := shellquote.Join(ts.Auditctl)
+	if ts.Sudo {
+		auditctl = "sudo -n " + auditctl
+	}
+	return fmt.Sprintf("%s -s && echo %s && %s -l", auditctl, shellquote.Join(separator), auditctl)
+}
+
+func (ts *AuditCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	cmd := ts.command()
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	parts := strings.SplitN(string(result.Stdout), separator+"\n", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("unexpected output of command '%s': %q", cmd, result.Stdout)
+	}
+	status, err := parseStatus(parts[0])
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]bool)
+	for _, line := range strings.Split(parts[1], "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == "No rules" {
+			continue
+		}
+		rule, err := canonicalRule(line)
+		if err != nil {
+			// rules using options not supported here cannot be required,
+			// keep them as printed
+			rule = line
+		}
+		loaded[rule] = true
+	}
+
+	payload := auditEventPayload{Enabled: status.Enabled, PID: status.PID}
+	switch {
+	case status.Enabled == 0:
+		payload.Problems = append(payload.Problems, "auditing is disabled")
+	case ts.Immutable && status.Enabled != enabledImmutable:
+		payload.Problems = append(payload.Problems, "audit configuration is not immutable")
+	}
+	if status.PID == 0 {
+		payload.Problems = append(payload.Problems, "no audit daemon is running")
+	}
+	required := make(map[string]bool)
+	for _, rule := range ts.Rules {
+		required[rule] = true
+		if !loaded[rule] {
+			payload.Missing = append(payload.Missing, rule)
+		}
+	}
+	if len(payload.Missing) > 0 {
+		payload.Problems = append(payload.Problems, fmt.Sprintf("%d required rules are not loaded", len(payload.Missing)))
+	}
+	if ts.Exact {
+		for rule := range loaded {
+			if !required[rule] {
+				payload.Extra = append(payload.Extra, rule)
+			}
+		}
+		sort.Strings(payload.Extra)
+		if len(payload.Extra) > 0 {
+			payload.Problems = append(payload.Problems, fmt.Sprintf("%d loaded rules are not required", len(payload.Extra)))
+		}
+	}
+	if len(payload.Problems) == 0 {
+		return teststeps.EmitTargetEvent(ev, EventAuditConfigured, target, payload)
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventAuditMisconfigured, target, payload); err != nil {
+		return err
+	}
+	return fmt.Errorf("audit is misconfigured: %s", strings.Join(payload.Problems, ", "))
+}
+
+// Run executes the audit check step.
+func (ts *AuditCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the step normally, as it only reads the audit configuration.
+func (ts *AuditCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *AuditCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	ts.Auditctl = params.GetOne("auditctl").Raw()
+	if ts.Auditctl == "" {
+		ts.Auditctl = defaultAuditctl
+	}
+	if ts.Sudo, err = teststeps.BoolParam(params, "sudo", false); err != nil {
+		return err
+	}
+	if ts.Exact, err = teststeps.BoolParam(params, "exact", false); err != nil {
+		return err
+	}
+	if ts.Immutable, err = teststeps.BoolParam(params, "immutable", false); err != nil {
+		return err
+	}
+	ts.Rules = nil
+	seen := make(map[string]string)
+	for _, p := range params.Get("rule") {
+		rule, err := canonicalRule(p.Raw())
+		if err != nil {
+			return fmt.Errorf("invalid 'rule' parameter: %v", err)
+		}
+		if other, ok := seen[rule]; ok {
+			return fmt.Errorf("invalid 'rule' parameter: rule %q is the same as %q", p.Raw(), other)
+		}
+		seen[rule] = p.Raw()
+		ts.Rules = append(ts.Rules, rule)
+	}
+	if len(ts.Rules) == 0 && ts.Exact {
+		return errors.New("'exact' requires at least one 'rule' parameter")
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *AuditCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. AuditCheck
+// cannot resume.
+func (ts *AuditCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *AuditCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new AuditCheck test step.
+func New() test.TestStep {
+	return &AuditCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
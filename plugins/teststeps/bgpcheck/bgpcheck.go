@@ -0,0 +1,335 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package bgpcheck
+
+// The BGPCheck plugin connects to each network target over SSH, retrieves the
+// state of its BGP neighbors in one VRF, and fails the targets with neighbors
+// which are not in the Established state. If a list of expected peers is
+// given, only those peers are checked, and they must all be configured.
+//
+// Two platforms are supported: frr, whose neighbors are read with vtysh, and
+// eos, whose CLI is expected to be the login shell.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "BGPCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventBgpOK   = event.Name("TargetBgpOK")
+	EventBgpDown = event.Name("TargetBgpDown")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventBgpOK, EventBgpDown}
+
+// supported platforms.
+const (
+	PlatformFRR = "frr"
+	PlatformEOS = "eos"
+)
+
+// supported transports.
+const (
+	TransportSSH  = "ssh"
+	TransportGNMI = "gnmi"
+)
+
+const (
+	// established is the state of the sessions which are up.
+	established = "Established"
+	// stateMissing is reported for the expected peers which are not
+	// configured.
+	stateMissing = "NotConfigured"
+	defaultVRF   = "default"
+)
+
+var vrfRe = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// peer is the state of a BGP neighbor.
+type peer struct {
+	Address  string
+	State    string
+	RemoteAS string `json:",omitempty"`
+}
+
+// bgpEventPayload is the payload of the events emitted by this step.
+type bgpEventPayload struct {
+	Platform string
+	VRF      string
+	Peers    int
+	Down     []peer `json:",omitempty"`
+}
+
+// BGPCheck verifies that the BGP sessions of the targets are established.
+type BGPCheck struct {
+	*teststeps.SSHParams
+	Platform string
+	VRF      string
+	Sudo     bool
+	// Expected is the set of peers to check, keyed by their normalized
+	// address. If empty, all the neighbors are checked.
+	Expected map[string]bool
+}
+
+// Name returns the plugin name.
+func (ts BGPCheck) Name() string {
+	return Name
+}
+
+// command returns the command printing the neighbors as JSON.
+func (ts *BGPCheck) command() string {
+	switch ts.Platform {
+	case PlatformEOS:
+		return fmt.Sprintf("show ip bgp summary vrf %s | json", ts.VRF)
+	default:
+		cmd := shellquote.Join("vtysh", "-c", fmt.Sprintf("show bgp vrf %s neighbors json", ts.VRF))
+		if ts.Sudo {
+			cmd = "sudo -n " + cmd
+		}
+		return cmd
+	}
+}
+
+// jsonString returns the string form of a JSON number or string.
+func jsonString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// parseFRR parses the output of "show bgp neighbors json".
+func parseFRR(out []byte) ([]peer, error) {
+	var neighbors map[string]json.RawMessage
+	if err := json.Unmarshal(out, &neighbors); err != nil {
+		return nil, fmt.Errorf("cannot decode neighbors: %v", err)
+	}
+	var peers []peer
+	for addr, raw := range neighbors {
+		var n struct {
+			BGPState string          `json:"bgpState"`
+			RemoteAS json.RawMessage `json:"remoteAs"`
+		}
+		// other keys, such as warnings, are not neighbors
+		if err := json.Unmarshal(raw, &n); err != nil || n.BGPState == "" {
+			continue
+		}
+		p := peer{Address: addr, State: n.BGPState}
+		if len(n.RemoteAS) > 0 {
+			p.RemoteAS = jsonString(n.RemoteAS)
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+// parseEOS parses the output of "show ip bgp summary | json".
+func parseEOS(out []byte, vrf string) ([]peer, error) {
+	var summary struct {
+		VRFs map[string]struct {
+			Peers map[string]struct {
+				PeerState string          `json:"peerState"`
+				ASN       json.RawMessage `json:"asn"`
+			} `json:"peers"`
+		} `json:"vrfs"`
+	}
+	if err := json.Unmarshal(out, &summary); err != nil {
+		return nil, fmt.Errorf("cannot decode BGP summary: %v", err)
+	}
+	v, ok := summary.VRFs[vrf]
+	if !ok {
+		return nil, fmt.Errorf("VRF %s not found in BGP summary", vrf)
+	}
+	var peers []peer
+	for addr, n := range v.Peers {
+		p := peer{Address: addr, State: n.PeerState}
+		if len(n.ASN) > 0 {
+			p.RemoteAS = jsonString(n.ASN)
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+// normalizeAddress returns the canonical form of an IP address, or the string
+// itself for unnumbered peers, which are named after their interface.
+func normalizeAddress(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr
+}
+
+// down returns the checked peers which are not established, including the
+// expected peers which are not configured.
+func (ts *BGPCheck) down(peers []peer) []peer {
+	var down []peer
+	seen := make(map[string]bool)
+	for _, p := range peers {
+		addr := normalizeAddress(p.Address)
+		if len(ts.Expected) > 0 && !ts.Expected[addr] {
+			continue
+		}
+		seen[addr] = true
+		if p.State != established {
+			down = append(down, p)
+		}
+	}
+	for addr := range ts.Expected {
+		if !seen[addr] {
+			down = append(down, peer{Address: addr, State: stateMissing})
+		}
+	}
+	sort.Slice(down, func(i, j int) bool { return down[i].Address < down[j].Address })
+	return down
+}
+
+func (ts *BGPCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	result, err := ts.RunSSHCommand(cancel, pause, target, ts.command())
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command exited with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	var peers []peer
+	switch ts.Platform {
+	case PlatformEOS:
+		peers, err = parseEOS(result.Stdout, ts.VRF)
+	default:
+		peers, err = parseFRR(result.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	payload := bgpEventPayload{Platform: ts.Platform, VRF: ts.VRF, Peers: len(peers), Down: ts.down(peers)}
+	if len(payload.Down) == 0 {
+		if len(peers) == 0 {
+			return fmt.Errorf("no BGP neighbors configured in VRF %s", ts.VRF)
+		}
+		return teststeps.EmitTargetEvent(ev, EventBgpOK, target, payload)
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventBgpDown, target, payload); err != nil {
+		return err
+	}
+	failing := make([]string, 0, len(payload.Down))
+	for _, p := range payload.Down {
+		failing = append(failing, fmt.Sprintf("%s (%s)", p.Address, p.State))
+	}
+	return fmt.Errorf("%d BGP peers not established: %s", len(failing), strings.Join(failing, ", "))
+}
+
+// Run executes the BGP check step.
+func (ts *BGPCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as it only reads the neighbor states.
+func (ts *BGPCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *BGPCheck) validateAndPopulate(params test.TestStepParameters) error {
+	switch transport := params.GetOne("transport").Raw(); transport {
+	case "", TransportSSH:
+	case TransportGNMI:
+		return fmt.Errorf("invalid 'transport' parameter: %s is not supported yet, use %s", TransportGNMI, TransportSSH)
+	default:
+		return fmt.Errorf("invalid 'transport' parameter %q, must be %s", transport, TransportSSH)
+	}
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	if ts.PrivateKeyFile.IsEmpty() && ts.Password.IsEmpty() {
+		return errors.New("missing credentials: one of the 'private_key_file' and 'password' parameters is required")
+	}
+	ts.Platform = params.GetOne("platform").Raw()
+	switch ts.Platform {
+	case "":
+		ts.Platform = PlatformFRR
+	case PlatformFRR, PlatformEOS:
+	default:
+		return fmt.Errorf("invalid 'platform' parameter %q, must be %s or %s", ts.Platform, PlatformFRR, PlatformEOS)
+	}
+	ts.VRF = params.GetOne("vrf").Raw()
+	if ts.VRF == "" {
+		ts.VRF = defaultVRF
+	}
+	if !vrfRe.MatchString(ts.VRF) {
+		return fmt.Errorf("invalid 'vrf' parameter %q", ts.VRF)
+	}
+	if ts.Sudo, err = teststeps.BoolParam(params, "sudo", false); err != nil {
+		return err
+	}
+	if ts.Sudo && ts.Platform != PlatformFRR {
+		return fmt.Errorf("'sudo' is only supported on %s", PlatformFRR)
+	}
+	ts.Expected = make(map[string]bool)
+	for _, p := range params.Get("peers") {
+		addr := strings.TrimSpace(p.Raw())
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid 'peers' entry %q: not an IP address", addr)
+		}
+		ts.Expected[normalizeAddress(addr)] = true
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *BGPCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. BGPCheck cannot
+// resume.
+func (ts *BGPCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *BGPCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new BGPCheck test step.
+func New() test.TestStep {
+	return &BGPCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
@@ -0,0 +1,325 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package cgroupcheck
+
+// The CgroupCheck plugin reads the CPU and memory cgroup limits of a container
+// over SSH, either by running a command inside a container of the target host
+// through the container runtime, or directly on the target if it is the
+// container itself. Both the cgroup v1 and v2 layouts of /sys/fs/cgroup are
+// supported. Targets whose limits differ from the expected ones are failed.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "CgroupCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventCgroupMatch    = event.Name("TargetCgroupMatch")
+	EventCgroupMismatch = event.Name("TargetCgroupMismatch")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventCgroupMatch, EventCgroupMismatch}
+
+// Unlimited is the value of a limit which is not set.
+const Unlimited = "unlimited"
+
+// readCommand prints the cgroup version and the raw CPU and memory limits, one
+// key=value per line.
+const readCommand = `if [ -f /sys/fs/cgroup/cgroup.controllers ]; then
+echo version=2
+echo cpu=$(cat /sys/fs/cgroup/cpu.max 2>/dev/null)
+echo memory=$(cat /sys/fs/cgroup/memory.max 2>/dev/null)
+else
+echo version=1
+echo cpu=$(cat /sys/fs/cgroup/cpu/cpu.cfs_quota_us 2>/dev/null) $(cat /sys/fs/cgroup/cpu/cpu.cfs_period_us 2>/dev/null)
+echo memory=$(cat /sys/fs/cgroup/memory/memory.limit_in_bytes 2>/dev/null)
+fi`
+
+const (
+	// v1Unlimited is the threshold above which a cgroup v1 memory limit is
+	// considered unset: the kernel reports the largest page aligned int64.
+	v1Unlimited = int64(1) << 62
+	// cpuEpsilon is the tolerance when comparing CPU limits.
+	cpuEpsilon = 0.001
+)
+
+var memorySuffixes = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+}
+
+// limits holds CPU and memory limits. A negative value means unlimited, and
+// a nil pointer that the limit is not checked.
+type limits struct {
+	// CPU is the number of CPUs.
+	CPU *float64
+	// Memory is in bytes.
+	Memory *int64
+}
+
+func formatCPU(v float64) string {
+	if v < 0 {
+		return Unlimited
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatMemory(v int64) string {
+	if v < 0 {
+		return Unlimited
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// cgroupEventPayload is the payload of the events emitted by this step.
+type cgroupEventPayload struct {
+	Version        int
+	ExpectedCPU    string   `json:",omitempty"`
+	ActualCPU      string   `json:",omitempty"`
+	ExpectedMemory string   `json:",omitempty"`
+	ActualMemory   string   `json:",omitempty"`
+	Mismatches     []string `json:",omitempty"`
+}
+
+// CgroupCheck verifies the cgroup limits of the targets.
+type CgroupCheck struct {
+	*teststeps.SSHParams
+	*teststeps.ContainerParams
+	Expected limits
+}
+
+// Name returns the plugin name.
+func (ts CgroupCheck) Name() string {
+	return Name
+}
+
+// parseCPU parses the CPU limit of the command output, either "quota period"
+// or "max period" for cgroup v2, or "quota period" with quota -1 for v1.
+func parseCPU(s string) (float64, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("cannot parse CPU limit %q, is the cpu controller enabled?", s)
+	}
+	if fields[0] == "max" || fields[0] == "-1" {
+		return -1, nil
+	}
+	quota, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || quota <= 0 {
+		return 0, fmt.Errorf("cannot parse CPU quota %q", fields[0])
+	}
+	period, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("cannot parse CPU period %q", fields[1])
+	}
+	return float64(quota) / float64(period), nil
+}
+
+// parseMemoryLimit parses the memory limit of the command output.
+func parseMemoryLimit(s string) (int64, error) {
+	if s == "max" {
+		return -1, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("cannot parse memory limit %q, is the memory controller enabled?", s)
+	}
+	if v >= v1Unlimited {
+		return -1, nil
+	}
+	return v, nil
+}
+
+// parseOutput parses the output of readCommand.
+func parseOutput(out []byte) (int, limits, error) {
+	values := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if kv := strings.SplitN(s.Text(), "=", 2); len(kv) == 2 {
+			values[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	var l limits
+	version, err := strconv.Atoi(values["version"])
+	if err != nil || (version != 1 && version != 2) {
+		return 0, l, fmt.Errorf("cannot determine cgroup version from output %q", out)
+	}
+	cpu, err := parseCPU(values["cpu"])
+	if err != nil {
+		return 0, l, err
+	}
+	memory, err := parseMemoryLimit(values["memory"])
+	if err != nil {
+		return 0, l, err
+	}
+	return version, limits{CPU: &cpu, Memory: &memory}, nil
+}
+
+func (ts *CgroupCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	cmd, err := ts.ExecCommand(target, readCommand)
+	if err != nil {
+		return err
+	}
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command exited with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	version, actual, err := parseOutput(result.Stdout)
+	if err != nil {
+		return err
+	}
+	payload := cgroupEventPayload{Version: version}
+	if ts.Expected.CPU != nil {
+		payload.ExpectedCPU, payload.ActualCPU = formatCPU(*ts.Expected.CPU), formatCPU(*actual.CPU)
+		if (*ts.Expected.CPU < 0) != (*actual.CPU < 0) || math.Abs(*ts.Expected.CPU-*actual.CPU) > cpuEpsilon {
+			payload.Mismatches = append(payload.Mismatches, fmt.Sprintf("CPU limit is %s, expected %s", payload.ActualCPU, payload.ExpectedCPU))
+		}
+	}
+	if ts.Expected.Memory != nil {
+		payload.ExpectedMemory, payload.ActualMemory = formatMemory(*ts.Expected.Memory), formatMemory(*actual.Memory)
+		if *ts.Expected.Memory != *actual.Memory {
+			payload.Mismatches = append(payload.Mismatches, fmt.Sprintf("memory limit is %s, expected %s", payload.ActualMemory, payload.ExpectedMemory))
+		}
+	}
+	if len(payload.Mismatches) > 0 {
+		if err := teststeps.EmitTargetEvent(ev, EventCgroupMismatch, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("cgroup limits mismatch: %s", strings.Join(payload.Mismatches, "; "))
+	}
+	return teststeps.EmitTargetEvent(ev, EventCgroupMatch, target, payload)
+}
+
+// Run executes the cgroup check step.
+func (ts *CgroupCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as reading the cgroup limits has no
+// side effects.
+func (ts *CgroupCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// parseExpectedCPU parses an expected number of CPUs, or unlimited.
+func parseExpectedCPU(s string) (float64, error) {
+	if s == Unlimited {
+		return -1, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid 'cpu' parameter %q: must be a positive number of CPUs or %s", s, Unlimited)
+	}
+	return v, nil
+}
+
+// parseExpectedMemory parses an expected memory limit in bytes, optionally
+// with a binary suffix (Ki, Mi, Gi, Ti), or unlimited.
+func parseExpectedMemory(s string) (int64, error) {
+	if s == Unlimited {
+		return -1, nil
+	}
+	num, multiplier := s, int64(1)
+	for _, m := range memorySuffixes {
+		if strings.HasSuffix(s, m.suffix) {
+			num, multiplier = strings.TrimSuffix(s, m.suffix), m.multiplier
+			break
+		}
+	}
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v <= 0 || v > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid 'memory' parameter %q: must be a positive size in bytes, with an optional Ki, Mi, Gi or Ti suffix, or %s", s, Unlimited)
+	}
+	return v * multiplier, nil
+}
+
+func (ts *CgroupCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	if ts.ContainerParams, err = teststeps.NewContainerParams(params); err != nil {
+		return err
+	}
+	ts.Expected = limits{}
+	if s := strings.TrimSpace(params.GetOne("cpu").Raw()); s != "" {
+		cpu, err := parseExpectedCPU(s)
+		if err != nil {
+			return err
+		}
+		ts.Expected.CPU = &cpu
+	}
+	if s := strings.TrimSpace(params.GetOne("memory").Raw()); s != "" {
+		memory, err := parseExpectedMemory(s)
+		if err != nil {
+			return err
+		}
+		ts.Expected.Memory = &memory
+	}
+	if ts.Expected.CPU == nil && ts.Expected.Memory == nil {
+		return errors.New("at least one of the 'cpu' and 'memory' parameters is required")
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *CgroupCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. CgroupCheck
+// cannot resume.
+func (ts *CgroupCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *CgroupCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new CgroupCheck test step.
+func New() test.TestStep {
+	return &CgroupCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
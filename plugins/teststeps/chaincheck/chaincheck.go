@@ -0,0 +1,342 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package chaincheck
+
+// The ChainCheck plugin connects over TLS to each target and verifies that
+// the server sends a complete and correctly ordered certificate chain, from
+// the leaf certificate up to one of the roots of a configured CA bundle.
+// Targets with missing intermediates, or with certificates sent in the wrong
+// order, fail with a TargetChainIncomplete event. Clients which do not fetch
+// missing intermediates on their own reject such chains even if browsers
+// accept them.
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "ChainCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventChainComplete   = event.Name("TargetChainComplete")
+	EventChainIncomplete = event.Name("TargetChainIncomplete")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventChainComplete, EventChainIncomplete}
+
+const (
+	defaultPort             = "443"
+	defaultHandshakeTimeout = 10 * time.Second
+)
+
+// certInfo identifies a certificate in the event payloads.
+type certInfo struct {
+	Subject string
+	Issuer  string
+}
+
+func newCertInfo(cert *x509.Certificate) certInfo {
+	return certInfo{Subject: cert.Subject.String(), Issuer: cert.Issuer.String()}
+}
+
+// chainEventPayload is the payload of the events emitted by this step.
+type chainEventPayload struct {
+	Address string
+	// Chain is the list of certificates sent by the server, in order.
+	Chain []certInfo
+	// Root is the subject of the root of the CA bundle the chain leads to.
+	Root string `json:",omitempty"`
+	// Missing lists the certificates which the server should have sent.
+	// They are identified by subject if they are in the CA bundle, and by
+	// the issuer of the last certificate of the chain otherwise.
+	Missing []string `json:",omitempty"`
+	// Misordered lists the certificates which the server sent out of order.
+	Misordered []string `json:",omitempty"`
+	// Unused lists the certificates which the server sent, but which are not
+	// part of the chain. They do not make the chain incomplete.
+	Unused []string `json:",omitempty"`
+}
+
+// ChainCheck verifies the certificate chains sent by the targets.
+type ChainCheck struct {
+	Address    *test.Param
+	ServerName *test.Param
+	// Bundle holds the certificates of the CA bundle. Its roots are the
+	// self-signed certificates, the other ones are known intermediates.
+	Bundle           []*x509.Certificate
+	HandshakeTimeout time.Duration
+	Timeouts         teststeps.NetworkTimeouts
+}
+
+// Name returns the plugin name.
+func (ts ChainCheck) Name() string {
+	return Name
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// findIssuer returns the index of the first candidate which issued the
+// certificate, or -1.
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) int {
+	for i, c := range candidates {
+		if bytes.Equal(cert.RawIssuer, c.RawSubject) && cert.CheckSignatureFrom(c) == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+func inBundle(cert *x509.Certificate, bundle []*x509.Certificate) bool {
+	for _, c := range bundle {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// analyze walks the chain from the leaf certificate up to a root of the
+// bundle, and fills in the payload. It returns whether the chain is complete
+// and correctly ordered.
+func analyze(sent, bundle []*x509.Certificate, payload *chainEventPayload) bool {
+	for _, cert := range sent {
+		payload.Chain = append(payload.Chain, newCertInfo(cert))
+	}
+	used := make([]bool, len(sent))
+	used[0] = true
+	// position is the index at which the next certificate from the server is
+	// expected.
+	position := 1
+	cur := sent[0]
+	// every certificate is visited at most once, which bounds the walk in
+	// case of cycles.
+	for steps := 0; steps <= len(sent)+len(bundle); steps++ {
+		if isSelfSigned(cur) {
+			if inBundle(cur, bundle) {
+				payload.Root = cur.Subject.String()
+			}
+			break
+		}
+		if i := findIssuer(cur, sent); i >= 0 && !used[i] {
+			if i != position {
+				payload.Misordered = append(payload.Misordered, fmt.Sprintf("%s at position %d, expected %d", sent[i].Subject, i, position))
+			}
+			used[i] = true
+			position++
+			cur = sent[i]
+			continue
+		}
+		i := findIssuer(cur, bundle)
+		if i < 0 {
+			payload.Missing = append(payload.Missing, fmt.Sprintf("issuer %s of %s", cur.Issuer, cur.Subject))
+			break
+		}
+		if isSelfSigned(bundle[i]) {
+			// servers do not need to send the root
+			payload.Root = bundle[i].Subject.String()
+			break
+		}
+		payload.Missing = append(payload.Missing, bundle[i].Subject.String())
+		cur = bundle[i]
+	}
+	for i, cert := range sent {
+		if !used[i] {
+			payload.Unused = append(payload.Unused, cert.Subject.String())
+		}
+	}
+	return payload.Root != "" && len(payload.Missing) == 0 && len(payload.Misordered) == 0
+}
+
+func (ts *ChainCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	addr, err := ts.Address.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand address parameter: %v", err)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, addr = addr, net.JoinHostPort(addr, defaultPort)
+	}
+	serverName, err := ts.ServerName.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand server_name parameter: %v", err)
+	}
+	if serverName == "" {
+		serverName = host
+	}
+
+	ctx, ctxCancel := teststeps.NewContext(cancel, pause)
+	defer ctxCancel()
+	dialCtx, dialCancel := context.WithTimeout(ctx, ts.HandshakeTimeout)
+	defer dialCancel()
+	// the chain is verified below, so that all of its problems are reported
+	// rather than the first one found by crypto/tls.
+	conn, err := ts.Timeouts.DialTLS(dialCtx, addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			return teststeps.ErrInterrupted
+		case dialCtx.Err() != nil:
+			return fmt.Errorf("TLS handshake with %s timed out after %v", addr, ts.HandshakeTimeout)
+		}
+		return fmt.Errorf("TLS handshake with %s failed: %v", addr, err)
+	}
+	sent := conn.ConnectionState().PeerCertificates
+	conn.Close()
+	if len(sent) == 0 {
+		return fmt.Errorf("%s presented no certificate", addr)
+	}
+
+	payload := chainEventPayload{Address: addr}
+	if analyze(sent, ts.Bundle, &payload) {
+		return teststeps.EmitTargetEvent(ev, EventChainComplete, target, payload)
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventChainIncomplete, target, payload); err != nil {
+		return err
+	}
+	var problems []string
+	if len(payload.Missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(payload.Missing, ", "))
+	}
+	if len(payload.Misordered) > 0 {
+		problems = append(problems, "misordered "+strings.Join(payload.Misordered, ", "))
+	}
+	if payload.Root == "" && len(payload.Missing) == 0 {
+		problems = append(problems, "chain does not lead to a root of the CA bundle")
+	}
+	return fmt.Errorf("certificate chain of %s is incomplete: %s", addr, strings.Join(problems, "; "))
+}
+
+// Run executes the chain check step.
+func (ts *ChainCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the step normally, as it only performs TLS handshakes.
+func (ts *ChainCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// loadBundle parses the CA bundle, which must contain at least one root, and
+// only CA certificates.
+func loadBundle(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA bundle: %v", err)
+	}
+	var (
+		certs []*x509.Certificate
+		roots int
+	)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse certificate in CA bundle %s: %v", path, err)
+		}
+		if !cert.IsCA {
+			return nil, fmt.Errorf("certificate %s in CA bundle %s is not a CA certificate", cert.Subject, path)
+		}
+		if isSelfSigned(cert) {
+			roots++
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("CA bundle %s contains no certificate", path)
+	}
+	if roots == 0 {
+		return nil, fmt.Errorf("CA bundle %s contains no root certificate", path)
+	}
+	return certs, nil
+}
+
+func (ts *ChainCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.Address = params.GetOne("address")
+	if ts.Address.IsEmpty() {
+		return errors.New("invalid or missing 'address' parameter, must be exactly one string")
+	}
+	ts.ServerName = params.GetOne("server_name")
+	caFile := params.GetOne("ca_file")
+	if caFile.IsEmpty() {
+		return errors.New("invalid or missing 'ca_file' parameter, must be exactly one string")
+	}
+	if ts.Bundle, err = loadBundle(caFile.Raw()); err != nil {
+		return err
+	}
+	if ts.HandshakeTimeout, err = teststeps.DurationParam(params, "handshake_timeout", defaultHandshakeTimeout); err != nil {
+		return err
+	}
+	if ts.HandshakeTimeout <= 0 {
+		return errors.New("invalid 'handshake_timeout' parameter: must be positive")
+	}
+	if ts.Timeouts, err = teststeps.NewNetworkTimeouts(params); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *ChainCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. ChainCheck
+// cannot resume.
+func (ts *ChainCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *ChainCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new ChainCheck test step.
+func New() test.TestStep {
+	return &ChainCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
@@ -0,0 +1,349 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package chaos
+
+// The Chaos plugin injects a fault on each target over SSH for a given
+// duration, then removes it and forwards the target. Supported faults are CPU
+// stress, network latency and packet loss, the latter two being implemented
+// with tc netem.
+//
+// The fault is removed even if the step is cancelled or paused. As a network
+// fault may also prevent the cleanup command from reaching the target, the
+// targets are additionally instructed to revert the fault on their own once
+// the duration expires.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "Chaos"
+
+var log = logging.GetLogger("teststeps/" + strings.ToLower(Name))
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventFaultInjected      = event.Name("TargetFaultInjected")
+	EventFaultRemoved       = event.Name("TargetFaultRemoved")
+	EventFaultCleanupFailed = event.Name("TargetFaultCleanupFailed")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventFaultInjected, EventFaultRemoved, EventFaultCleanupFailed}
+
+// supported faults.
+const (
+	FaultCPUStress      = "cpu_stress"
+	FaultNetworkLatency = "network_latency"
+	FaultPacketLoss     = "packet_loss"
+)
+
+// destructiveFaults may make the targets unreachable, and require an explicit
+// confirmation.
+var destructiveFaults = map[string]bool{
+	FaultNetworkLatency: true,
+	FaultPacketLoss:     true,
+}
+
+const (
+	defaultWorkers        = 1
+	maxWorkers            = 1024
+	defaultCleanupTimeout = time.Minute
+	// revertGrace is the delay after the fault duration at which the targets
+	// revert the fault on their own, leaving time for the regular cleanup.
+	revertGrace = 30 * time.Second
+)
+
+var (
+	interfaceRe = regexp.MustCompile(`^[A-Za-z0-9_.:@-]{1,15}$`)
+	unsafeRe    = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+)
+
+// faultEventPayload is the payload of the events emitted by this step.
+type faultEventPayload struct {
+	Fault    string
+	Duration string
+	Params   map[string]string
+	Error    string `json:",omitempty"`
+}
+
+// Chaos injects faults on the targets.
+type Chaos struct {
+	*teststeps.SSHParams
+	Fault          string
+	Duration       time.Duration
+	CleanupTimeout time.Duration
+	Sudo           bool
+	// fault parameters
+	Workers     int
+	Interface   string
+	Latency     time.Duration
+	Jitter      time.Duration
+	LossPercent float64
+}
+
+// Name returns the plugin name.
+func (ts Chaos) Name() string {
+	return Name
+}
+
+// seconds formats a duration as a number of seconds for shell commands,
+// rounding up.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// milliseconds formats a duration for tc.
+func milliseconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Millisecond), 10) + "ms"
+}
+
+func (ts *Chaos) prefix() string {
+	if ts.Sudo {
+		return "sudo -n "
+	}
+	return ""
+}
+
+// pidFile is where the PIDs of the CPU stress workers are recorded, so that
+// they can be killed by the cleanup command.
+func pidFile(target *target.Target) string {
+	return shellquote.Join("/tmp/contest-chaos-" + unsafeRe.ReplaceAllString(target.ID, "_") + ".pids")
+}
+
+// commands returns the commands injecting and removing the fault.
+func (ts *Chaos) commands(target *target.Target) (inject, cleanup string) {
+	p := ts.prefix()
+	revertAfter := seconds(ts.Duration + revertGrace)
+	switch ts.Fault {
+	case FaultCPUStress:
+		// each worker is bounded by timeout, which acts as automatic revert
+		pids := pidFile(target)
+		inject = fmt.Sprintf("rm -f %s; for i in $(seq %d); do nohup timeout %s sh -c 'while :; do :; done' >/dev/null 2>&1 & echo $! >> %s; done",
+			pids, ts.Workers, revertAfter, pids)
+		cleanup = fmt.Sprintf("if [ -f %s ]; then kill $(cat %s) 2>/dev/null; rm -f %s; fi; true", pids, pids, pids)
+	case FaultNetworkLatency, FaultPacketLoss:
+		dev := shellquote.Join(ts.Interface)
+		netem := "delay " + milliseconds(ts.Latency)
+		if ts.Jitter > 0 {
+			netem += " " + milliseconds(ts.Jitter)
+		}
+		if ts.Fault == FaultPacketLoss {
+			netem = "loss " + strconv.FormatFloat(ts.LossPercent, 'f', -1, 64) + "%"
+		}
+		del := fmt.Sprintf("%stc qdisc del dev %s root netem", p, dev)
+		inject = fmt.Sprintf("%stc qdisc add dev %s root netem %s && (nohup sh -c %s >/dev/null 2>&1 &)",
+			p, dev, netem, shellquote.Join("sleep "+revertAfter+"; "+del))
+		cleanup = del + " 2>/dev/null; true"
+	}
+	return inject, cleanup
+}
+
+func (ts *Chaos) params() map[string]string {
+	params := make(map[string]string)
+	switch ts.Fault {
+	case FaultCPUStress:
+		params["workers"] = strconv.Itoa(ts.Workers)
+	case FaultNetworkLatency:
+		params["interface"] = ts.Interface
+		params["latency"] = ts.Latency.String()
+		if ts.Jitter > 0 {
+			params["jitter"] = ts.Jitter.String()
+		}
+	case FaultPacketLoss:
+		params["interface"] = ts.Interface
+		params["loss_percent"] = strconv.FormatFloat(ts.LossPercent, 'f', -1, 64)
+	}
+	return params
+}
+
+// cleanup removes the fault. It does not honor the cancellation and pause
+// signals of the step, as targets must not be left degraded, and is bounded
+// by the cleanup timeout instead.
+func (ts *Chaos) cleanup(target *target.Target, cmd string, ev testevent.Emitter, payload faultEventPayload) error {
+	abort := make(chan struct{})
+	timer := time.AfterFunc(ts.CleanupTimeout, func() { close(abort) })
+	defer timer.Stop()
+	result, err := ts.RunSSHCommand(abort, nil, target, cmd)
+	if err == nil && result.ExitStatus != 0 {
+		err = fmt.Errorf("cleanup command exited with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	if errors.Is(err, teststeps.ErrInterrupted) {
+		err = fmt.Errorf("cleanup did not complete within %s", ts.CleanupTimeout)
+	}
+	if err != nil {
+		log.Errorf("Could not remove fault %s from target %s, it will revert on its own after %s: %v", ts.Fault, target, ts.Duration+revertGrace, err)
+		payload.Error = err.Error()
+		if emitErr := teststeps.EmitTargetEvent(ev, EventFaultCleanupFailed, target, payload); emitErr != nil {
+			log.Warningf("%v", emitErr)
+		}
+		return err
+	}
+	return teststeps.EmitTargetEvent(ev, EventFaultRemoved, target, payload)
+}
+
+func (ts *Chaos) inject(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) (err error) {
+	injectCmd, cleanupCmd := ts.commands(target)
+	payload := faultEventPayload{Fault: ts.Fault, Duration: ts.Duration.String(), Params: ts.params()}
+	// from here on, the fault may be in place: always clean up, and report
+	// the first error
+	defer func() {
+		if cleanupErr := ts.cleanup(target, cleanupCmd, ev, payload); err == nil && cleanupErr != nil {
+			err = cleanupErr
+		}
+	}()
+	log.Infof("Injecting fault %s on target %s for %s", ts.Fault, target, ts.Duration)
+	result, err := ts.RunSSHCommand(cancel, pause, target, injectCmd)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("could not inject fault %s, command exited with status %d: %s", ts.Fault, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventFaultInjected, target, payload); err != nil {
+		return err
+	}
+	return teststeps.Sleep(cancel, pause, ts.Duration)
+}
+
+// Run executes the chaos step.
+func (ts *Chaos) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.inject(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun logs the fault that would be injected in each target, along with the
+// command injecting it, without connecting to the targets.
+func (ts *Chaos) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	describe := func(target *target.Target) (string, error) {
+		injectCmd, _ := ts.commands(target)
+		return fmt.Sprintf("inject fault %s for %s with '%s'", ts.Fault, ts.Duration, injectCmd), nil
+	}
+	return teststeps.DryRunEachTarget(Name, cancel, pause, ch, describe)
+}
+
+// requiredDuration parses a mandatory, positive duration parameter.
+func requiredDuration(params test.TestStepParameters, name string) (time.Duration, error) {
+	if params.GetOne(name).IsEmpty() {
+		return 0, fmt.Errorf("missing '%s' parameter", name)
+	}
+	return teststeps.DurationParam(params, name, 0)
+}
+
+func (ts *Chaos) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.SSHParams, err = teststeps.NewSSHParams(params)
+	if err != nil {
+		return err
+	}
+	ts.Fault = params.GetOne("fault").Raw()
+	switch ts.Fault {
+	case FaultCPUStress, FaultNetworkLatency, FaultPacketLoss:
+	default:
+		return fmt.Errorf("invalid or missing 'fault' parameter %q, must be one of %s, %s, %s", ts.Fault, FaultCPUStress, FaultNetworkLatency, FaultPacketLoss)
+	}
+	confirmed, err := teststeps.BoolParam(params, "confirm_destructive", false)
+	if err != nil {
+		return err
+	}
+	if destructiveFaults[ts.Fault] && !confirmed {
+		return fmt.Errorf("fault %s may make the targets unreachable, set 'confirm_destructive' to true to allow it", ts.Fault)
+	}
+	if ts.Duration, err = requiredDuration(params, "duration"); err != nil {
+		return err
+	}
+	if ts.CleanupTimeout, err = teststeps.DurationParam(params, "cleanup_timeout", defaultCleanupTimeout); err != nil {
+		return err
+	}
+	if ts.Sudo, err = teststeps.BoolParam(params, "sudo", false); err != nil {
+		return err
+	}
+
+	switch ts.Fault {
+	case FaultCPUStress:
+		ts.Workers = defaultWorkers
+		if p := params.GetOne("workers"); !p.IsEmpty() {
+			if ts.Workers, err = strconv.Atoi(p.Raw()); err != nil || ts.Workers < 1 || ts.Workers > maxWorkers {
+				return fmt.Errorf("invalid 'workers' parameter %q: must be an integer between 1 and %d", p.Raw(), maxWorkers)
+			}
+		}
+	case FaultNetworkLatency, FaultPacketLoss:
+		ts.Interface = params.GetOne("interface").Raw()
+		if !interfaceRe.MatchString(ts.Interface) {
+			return fmt.Errorf("invalid or missing 'interface' parameter %q", ts.Interface)
+		}
+		if ts.Fault == FaultNetworkLatency {
+			if ts.Latency, err = requiredDuration(params, "latency"); err != nil {
+				return err
+			}
+			if ts.Latency < time.Millisecond {
+				return errors.New("invalid 'latency' parameter: must be at least 1ms")
+			}
+			if ts.Jitter, err = teststeps.DurationParam(params, "jitter", 0); err != nil {
+				return err
+			}
+		} else {
+			p := params.GetOne("loss_percent")
+			if ts.LossPercent, err = strconv.ParseFloat(p.Raw(), 64); err != nil || ts.LossPercent <= 0 || ts.LossPercent > 100 {
+				return fmt.Errorf("invalid or missing 'loss_percent' parameter %q: must be a number in (0, 100]", p.Raw())
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *Chaos) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. Chaos cannot
+// resume.
+func (ts *Chaos) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *Chaos) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new Chaos test step.
+func New() test.TestStep {
+	return &Chaos{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
@@ -0,0 +1,319 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package cmclean
+
+// The CMClean plugin triggers a no-op configuration management run on each
+// target over SSH, and fails the targets which would be changed by a real run,
+// i.e. whose configuration drifted. Two tools are supported:
+//
+// * puppet: the agent runs with --noop and --detailed-exitcodes, and its JSON
+//   log is printed on the standard output. Resources with pending changes are
+//   the sources of the "(noop)" log entries.
+// * chef: the client runs in why-run mode with the doc formatter, whose
+//   resource lines followed by "Would ..." actions are the pending changes.
+//
+// A custom command can be provided, as long as it runs the tool in no-op mode
+// and produces the same output.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "CMClean"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventConfigClean = event.Name("TargetConfigClean")
+	EventConfigDrift = event.Name("TargetConfigDrift")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventConfigClean, EventConfigDrift}
+
+// supported configuration management tools.
+const (
+	ToolPuppet = "puppet"
+	ToolChef   = "chef"
+)
+
+const (
+	// puppetCommand writes the JSON log to a temporary file, as puppet only
+	// selects the JSON format for files, and prints it.
+	puppetCommand = `d=$(mktemp -d) && { %spuppet agent --test --noop --detailed-exitcodes --color=false --logdest "$d/log.json" >/dev/null 2>&1; rc=$?; cat "$d/log.json" 2>/dev/null; rm -rf "$d"; exit $rc; }`
+	chefCommand   = "%schef-client --why-run --no-color --format doc"
+
+	// maxResources bounds the number of resources reported in the events.
+	maxResources = 100
+)
+
+// puppet detailed exit codes.
+const (
+	puppetChanges  = 2
+	puppetFailures = 4
+)
+
+var (
+	// puppetResourceRe matches the resource references of a log source, e.g.
+	// File[/etc/ntp.conf] in /Stage[main]/Ntp/File[/etc/ntp.conf]/content.
+	puppetResourceRe = regexp.MustCompile(`[A-Z][A-Za-z0-9_:]*\[[^\]]*\]`)
+	chefResourceRe   = regexp.MustCompile(`^\s*\* (\S+\[.*\]) action \S+`)
+	chefWouldRe      = regexp.MustCompile(`^\s+- [Ww]ould `)
+	noopFlags        = map[string][]string{
+		ToolPuppet: {"--noop"},
+		ToolChef:   {"--why-run", "-W"},
+	}
+)
+
+// driftEventPayload is the payload of the events emitted by this step.
+type driftEventPayload struct {
+	Tool      string
+	Count     int
+	Resources []string `json:",omitempty"`
+	Truncated bool     `json:",omitempty"`
+}
+
+// CMClean verifies that a configuration management run is a no-op.
+type CMClean struct {
+	*teststeps.SSHParams
+	Tool    string
+	Command *test.Param
+}
+
+// Name returns the plugin name.
+func (ts CMClean) Name() string {
+	return Name
+}
+
+// addResource appends a resource to a list if it is not there yet.
+func addResource(resources []string, seen map[string]bool, resource string) []string {
+	if seen[resource] {
+		return resources
+	}
+	seen[resource] = true
+	return append(resources, resource)
+}
+
+// puppetLogEntry is an entry of the puppet JSON log.
+type puppetLogEntry struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	Source  string `json:"source"`
+}
+
+// parsePuppet returns the resources with pending changes from the puppet JSON
+// log. Entries are parsed one per line, which accommodates both the array and
+// the JSON lines formats, as well as logs truncated by an interrupted run.
+func parsePuppet(out []byte) []string {
+	var (
+		resources []string
+		seen      = make(map[string]bool)
+	)
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		line = strings.TrimSuffix(strings.TrimPrefix(line, "["), "]")
+		line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+		if line == "" {
+			continue
+		}
+		var entry puppetLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			continue
+		}
+		if !strings.Contains(entry.Message, "(noop)") {
+			continue
+		}
+		refs := puppetResourceRe.FindAllString(entry.Source, -1)
+		if len(refs) == 0 {
+			continue
+		}
+		// the last reference is the resource, the others are containers
+		resources = addResource(resources, seen, refs[len(refs)-1])
+	}
+	return resources
+}
+
+// parseChef returns the resources with pending changes from the output of the
+// chef doc formatter.
+func parseChef(out []byte) []string {
+	var (
+		resources []string
+		seen      = make(map[string]bool)
+		current   string
+	)
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		if m := chefResourceRe.FindStringSubmatch(line); m != nil {
+			current = m[1]
+			continue
+		}
+		if current != "" && chefWouldRe.MatchString(line) {
+			resources = addResource(resources, seen, current)
+		}
+	}
+	return resources
+}
+
+func (ts *CMClean) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	cmd, err := ts.Command.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand command parameter: %v", err)
+	}
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	var resources []string
+	switch ts.Tool {
+	case ToolPuppet:
+		if result.ExitStatus != 0 && result.ExitStatus&^(puppetChanges|puppetFailures) != 0 {
+			return fmt.Errorf("puppet run failed with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+		}
+		if result.ExitStatus&puppetFailures != 0 {
+			return fmt.Errorf("puppet run reported resource failures (status %d)", result.ExitStatus)
+		}
+		resources = parsePuppet(result.Stdout)
+		if result.ExitStatus&puppetChanges != 0 && len(resources) == 0 {
+			// the changes are reported by the exit code even if the log
+			// could not be parsed
+			resources = []string{"unknown"}
+		}
+	case ToolChef:
+		if result.ExitStatus != 0 {
+			return fmt.Errorf("chef run failed with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+		}
+		resources = parseChef(result.Stdout)
+	}
+	payload := driftEventPayload{Tool: ts.Tool, Count: len(resources), Resources: resources}
+	if len(resources) > maxResources {
+		payload.Resources, payload.Truncated = resources[:maxResources], true
+	}
+	if len(resources) > 0 {
+		if err := teststeps.EmitTargetEvent(ev, EventConfigDrift, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("configuration drift: %d resources would be changed: %s", len(resources), strings.Join(payload.Resources, ", "))
+	}
+	return teststeps.EmitTargetEvent(ev, EventConfigClean, target, payload)
+}
+
+// Run executes the configuration management check step.
+func (ts *CMClean) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as the configuration management run is
+// already a no-op run.
+func (ts *CMClean) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// hasNoopFlag tells whether a command runs the tool in no-op mode.
+func hasNoopFlag(tool, cmd string) bool {
+	words, err := shellquote.Split(cmd)
+	if err != nil {
+		// fall back to a plain search for commands using shell constructs
+		words = strings.Fields(cmd)
+	}
+	for _, w := range words {
+		for _, flag := range noopFlags[tool] {
+			if w == flag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ts *CMClean) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	ts.Tool = params.GetOne("tool").Raw()
+	if _, ok := noopFlags[ts.Tool]; !ok {
+		return fmt.Errorf("invalid or missing 'tool' parameter %q, must be %s or %s", ts.Tool, ToolPuppet, ToolChef)
+	}
+	sudo, err := teststeps.BoolParam(params, "sudo", false)
+	if err != nil {
+		return err
+	}
+	ts.Command = params.GetOne("command")
+	if ts.Command.IsEmpty() {
+		prefix := ""
+		if sudo {
+			prefix = "sudo -n "
+		}
+		switch ts.Tool {
+		case ToolPuppet:
+			ts.Command = test.NewParam(fmt.Sprintf(puppetCommand, prefix))
+		case ToolChef:
+			ts.Command = test.NewParam(fmt.Sprintf(chefCommand, prefix))
+		}
+		return nil
+	}
+	if sudo {
+		return errors.New("'sudo' cannot be used with a custom 'command'")
+	}
+	// a command without the no-op flag would apply the changes
+	if !hasNoopFlag(ts.Tool, ts.Command.Raw()) {
+		return fmt.Errorf("invalid 'command' parameter: a %s clean run must use one of %s", ts.Tool, strings.Join(noopFlags[ts.Tool], ", "))
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *CMClean) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. CMClean cannot
+// resume.
+func (ts *CMClean) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *CMClean) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new CMClean test step.
+func New() test.TestStep {
+	return &CMClean{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
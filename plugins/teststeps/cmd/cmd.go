@@ -10,6 +10,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -35,6 +36,9 @@ var Events = []event.Name{event.Name("CmdStart"), event.Name("CmdEnd")}
 type Cmd struct {
 	executable string
 	args       []test.Param
+	// idempotent tells whether the command is safe to run twice on a target,
+	// which resuming the step requires.
+	idempotent bool
 }
 
 // Name returns the plugin name.
@@ -43,25 +47,23 @@ func (ts Cmd) Name() string {
 }
 
 // Run executes the cmd step.
-func (ts *Cmd) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+func (ts *Cmd) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
 	if err := ts.validateAndPopulate(params); err != nil {
 		return err
 	}
 	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
 		ctx, ctxCancel := context.WithCancel(context.Background())
 		defer ctxCancel()
-		// expand args
-		var args []string
-		for _, arg := range ts.args {
-			expArg, err := arg.Expand(target)
-			if err != nil {
-				return fmt.Errorf("failed to expand argument '%s': %v", arg.Raw(), err)
-			}
-			args = append(args, expArg)
+		args, err := ts.expandArgs(target)
+		if err != nil {
+			return err
 		}
 		cmd := exec.CommandContext(ctx, ts.executable, args...)
 		var stdout, stderr bytes.Buffer
-		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		output := teststeps.OpenLiveOutput(ev, target)
+		defer output.Close()
+		cmd.Stdout, cmd.Stderr = io.MultiWriter(&stdout, output), io.MultiWriter(&stderr, output)
 		log.Printf("Running command '%+v'", cmd)
 		errCh := make(chan error)
 		go func() {
@@ -81,6 +83,36 @@ func (ts *Cmd) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, para
 	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
 }
 
+// DryRun logs the command that would be run for each target, without running
+// it.
+func (ts *Cmd) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	describe := func(target *target.Target) (string, error) {
+		args, err := ts.expandArgs(target)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("run '%s' with args %q", ts.executable, args), nil
+	}
+	return teststeps.DryRunEachTarget(Name, cancel, pause, ch, describe)
+}
+
+// expandArgs expands the arguments of the command for a target.
+func (ts *Cmd) expandArgs(target *target.Target) ([]string, error) {
+	var args []string
+	for _, arg := range ts.args {
+		expArg, err := arg.Expand(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand argument '%s': %v", arg.Raw(), err)
+		}
+		args = append(args, expArg)
+	}
+	return args, nil
+}
+
 func (ts *Cmd) validateAndPopulate(params test.TestStepParameters) error {
 	ex := params.GetOne("executable")
 	if ex.IsEmpty() {
@@ -98,6 +130,10 @@ func (ts *Cmd) validateAndPopulate(params test.TestStepParameters) error {
 		ts.executable = p
 	}
 	ts.args = params.Get("args")
+	var err error
+	if ts.idempotent, err = teststeps.BoolParam(params, "idempotent", false); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -106,15 +142,23 @@ func (ts *Cmd) ValidateParameters(params test.TestStepParameters) error {
 	return ts.validateAndPopulate(params)
 }
 
-// Resume tries to resume a previously interrupted test step. Cmd cannot
+// Resume resumes an interrupted step: the command runs again on the targets
+// it was interrupted for. Only steps whose command is declared idempotent
 // resume.
-func (ts *Cmd) Resume(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
-	return &cerrors.ErrResumeNotSupported{StepName: Name}
+func (ts *Cmd) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	if !ts.idempotent {
+		return &cerrors.ErrResumeNotSupported{StepName: Name}
+	}
+	return ts.Run(ctx, pause, ch, params, ev)
 }
 
-// CanResume tells whether this step is able to resume.
+// CanResume tells whether this step is able to resume, i.e. whether its
+// parameters declare the command idempotent.
 func (ts *Cmd) CanResume() bool {
-	return false
+	return ts.idempotent
 }
 
 // New initializes and returns a new Cmd test step.
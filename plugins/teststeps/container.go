@@ -0,0 +1,83 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package teststeps
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// container runtimes supported by ContainerParams.
+const (
+	// RuntimeNone runs the commands directly on the target, which is then
+	// expected to be the container itself.
+	RuntimeNone   = "none"
+	RuntimeDocker = "docker"
+	RuntimePodman = "podman"
+	RuntimeCrictl = "crictl"
+
+	// DefaultRuntime is the runtime used when a step does not specify one.
+	DefaultRuntime = RuntimeDocker
+)
+
+var containerRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ContainerParams holds the parameters shared by the test steps that operate
+// on a container running on the target host: the container runtime and the
+// container name or ID, which is expanded against each target.
+type ContainerParams struct {
+	Runtime   string
+	Container *test.Param
+}
+
+// ContainerRuntimeParam validates and returns the container_runtime parameter,
+// which defaults to DefaultRuntime.
+func ContainerRuntimeParam(params test.TestStepParameters) (string, error) {
+	runtime := params.GetOne("container_runtime").Raw()
+	switch runtime {
+	case "":
+		return DefaultRuntime, nil
+	case RuntimeNone, RuntimeDocker, RuntimePodman, RuntimeCrictl:
+		return runtime, nil
+	}
+	return "", fmt.Errorf("invalid 'container_runtime' parameter %q, must be one of %s, %s, %s, %s", runtime, RuntimeNone, RuntimeDocker, RuntimePodman, RuntimeCrictl)
+}
+
+// NewContainerParams validates and returns the container parameters found in
+// the test step parameters: container_runtime and container.
+func NewContainerParams(params test.TestStepParameters) (*ContainerParams, error) {
+	runtime, err := ContainerRuntimeParam(params)
+	if err != nil {
+		return nil, err
+	}
+	p := ContainerParams{Runtime: runtime}
+	p.Container = params.GetOne("container")
+	if p.Runtime != RuntimeNone && p.Container.IsEmpty() {
+		return nil, errors.New("missing 'container' parameter")
+	}
+	return &p, nil
+}
+
+// ExecCommand returns the command running the given shell command inside the
+// target's container.
+func (p *ContainerParams) ExecCommand(target *target.Target, cmd string) (string, error) {
+	if p.Runtime == RuntimeNone {
+		return cmd, nil
+	}
+	container, err := p.Container.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand container parameter: %v", err)
+	}
+	if !containerRe.MatchString(container) {
+		return "", fmt.Errorf("invalid container name %q", container)
+	}
+	return shellquote.Join(p.Runtime, "exec", container, "sh", "-c", cmd), nil
+}
@@ -0,0 +1,281 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package depcheck
+
+// The DepCheck plugin verifies that each target can open TCP connections to
+// the host:port dependencies it needs, e.g. databases and APIs. The checks
+// run on the target over SSH, with bash's /dev/tcp or with nc. Targets which
+// cannot reach a required dependency fail with a TargetDependencyUnreachable
+// event naming it. Optional dependencies are checked and reported, but do not
+// fail the target.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "DepCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventDependenciesReachable = event.Name("TargetDependenciesReachable")
+	EventDependencyUnreachable = event.Name("TargetDependencyUnreachable")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventDependenciesReachable, EventDependencyUnreachable}
+
+const (
+	defaultConnectTimeout = 5 * time.Second
+	methodBash            = "bash"
+	methodNC              = "nc"
+	// timeoutExitStatus is the exit status of timeout(1) when the command
+	// timed out.
+	timeoutExitStatus = 124
+)
+
+// hostRe restricts the dependency hosts to host names and IP addresses, which
+// keeps them safe to use in the /dev/tcp path.
+var hostRe = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// dependency is a host:port which the targets must be able to connect to.
+type dependency struct {
+	addr     *test.Param
+	required bool
+}
+
+// parseAddr validates an expanded host:port dependency.
+func parseAddr(addr string) (string, string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid dependency %q: %v", addr, err)
+	}
+	if !hostRe.MatchString(host) {
+		return "", "", fmt.Errorf("invalid dependency %q: invalid host", addr)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 0xffff {
+		return "", "", fmt.Errorf("invalid dependency %q: port must be in range 1-65535", addr)
+	}
+	return host, port, nil
+}
+
+// depResult is the outcome of the check of a dependency.
+type depResult struct {
+	Dependency string
+	Required   bool
+	// Error is set for unreachable dependencies.
+	Error string `json:",omitempty"`
+}
+
+// depEventPayload is the payload of the events emitted by this step.
+type depEventPayload struct {
+	Reachable   []depResult `json:",omitempty"`
+	Unreachable []depResult `json:",omitempty"`
+}
+
+// DepCheck verifies that the targets can reach their dependencies.
+type DepCheck struct {
+	*teststeps.SSHParams
+	Dependencies   []dependency
+	Method         string
+	ConnectTimeout time.Duration
+}
+
+// Name returns the plugin name.
+func (ts DepCheck) Name() string {
+	return Name
+}
+
+// checkCommand returns the command which tries to connect to host:port from
+// the target, bounded by the connect timeout.
+func (ts *DepCheck) checkCommand(host, port string) string {
+	timeout := strconv.FormatFloat(ts.ConnectTimeout.Seconds(), 'f', -1, 64)
+	if ts.Method == methodNC {
+		return shellquote.Join("timeout", timeout, "nc", "-z", host, port)
+	}
+	// /dev/tcp wants IPv6 addresses without brackets
+	return shellquote.Join("timeout", timeout, "bash", "-c", "exec 3<>/dev/tcp/"+host+"/"+port)
+}
+
+func (ts *DepCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	var (
+		addrs []string
+		cmds  []string
+	)
+	for _, d := range ts.Dependencies {
+		addr, err := d.addr.Expand(target)
+		if err != nil {
+			return fmt.Errorf("cannot expand dependency '%s': %v", d.addr.Raw(), err)
+		}
+		host, port, err := parseAddr(addr)
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, addr)
+		// each check prints its exit status on its own line
+		cmds = append(cmds, ts.checkCommand(host, port)+" >/dev/null 2>&1; echo $?")
+	}
+	cmd := strings.Join(cmds, "; ")
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	var statuses []int
+	s := bufio.NewScanner(bytes.NewReader(result.Stdout))
+	for s.Scan() {
+		status, err := strconv.Atoi(strings.TrimSpace(s.Text()))
+		if err != nil {
+			return fmt.Errorf("unexpected output of the dependency checks: %q", s.Text())
+		}
+		statuses = append(statuses, status)
+	}
+	if len(statuses) != len(addrs) {
+		return fmt.Errorf("expected %d dependency check results, got %d", len(addrs), len(statuses))
+	}
+
+	var (
+		payload depEventPayload
+		failed  []string
+	)
+	for i, addr := range addrs {
+		r := depResult{Dependency: addr, Required: ts.Dependencies[i].required}
+		switch statuses[i] {
+		case 0:
+			payload.Reachable = append(payload.Reachable, r)
+			continue
+		case timeoutExitStatus:
+			r.Error = fmt.Sprintf("connection timed out after %v", ts.ConnectTimeout)
+		default:
+			r.Error = fmt.Sprintf("connection failed, %s exited with status %d", ts.Method, statuses[i])
+		}
+		payload.Unreachable = append(payload.Unreachable, r)
+		if r.Required {
+			failed = append(failed, fmt.Sprintf("%s (%s)", addr, r.Error))
+		}
+	}
+	if len(failed) == 0 {
+		return teststeps.EmitTargetEvent(ev, EventDependenciesReachable, target, payload)
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventDependencyUnreachable, target, payload); err != nil {
+		return err
+	}
+	return fmt.Errorf("required dependencies unreachable: %s", strings.Join(failed, ", "))
+}
+
+// Run executes the dependency check step.
+func (ts *DepCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the step normally, as it only opens connections from the
+// targets.
+func (ts *DepCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *DepCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	ts.Dependencies = nil
+	seen := make(map[string]bool)
+	for _, spec := range []struct {
+		name     string
+		required bool
+	}{{"dependency", true}, {"optional_dependency", false}} {
+		for _, p := range params.Get(spec.name) {
+			p := p
+			if p.IsEmpty() {
+				return fmt.Errorf("invalid '%s' parameter: cannot be empty", spec.name)
+			}
+			if seen[p.Raw()] {
+				return fmt.Errorf("invalid '%s' parameter: dependency %s specified more than once", spec.name, p.Raw())
+			}
+			seen[p.Raw()] = true
+			// dependencies containing template expressions are validated
+			// after expansion, at run time.
+			if !strings.Contains(p.Raw(), "{{") {
+				if _, _, err := parseAddr(p.Raw()); err != nil {
+					return fmt.Errorf("invalid '%s' parameter: %v", spec.name, err)
+				}
+			}
+			ts.Dependencies = append(ts.Dependencies, dependency{addr: &p, required: spec.required})
+		}
+	}
+	if len(ts.Dependencies) == 0 {
+		return errors.New("missing 'dependency' parameter, at least one dependency must be specified")
+	}
+	ts.Method = methodBash
+	if m := params.GetOne("method"); !m.IsEmpty() {
+		ts.Method = m.Raw()
+	}
+	if ts.Method != methodBash && ts.Method != methodNC {
+		return fmt.Errorf("invalid 'method' parameter %q: must be %s or %s", ts.Method, methodBash, methodNC)
+	}
+	if ts.ConnectTimeout, err = teststeps.DurationParam(params, "connect_timeout", defaultConnectTimeout); err != nil {
+		return err
+	}
+	if ts.ConnectTimeout <= 0 {
+		return errors.New("invalid 'connect_timeout' parameter: must be positive")
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *DepCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. DepCheck cannot
+// resume.
+func (ts *DepCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *DepCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new DepCheck test step.
+func New() test.TestStep {
+	return &DepCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
@@ -0,0 +1,246 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package dmesg
+
+// The Dmesg plugin captures the kernel ring buffer of each target over SSH,
+// and stores its most recent part in a TargetDmesgCaptured event for later
+// diagnostics. If error patterns are configured, the targets whose messages
+// match any of them, e.g. OOM kills or machine check exceptions, fail with a
+// TargetKernelError event.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "Dmesg"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventDmesgCaptured = event.Name("TargetDmesgCaptured")
+	EventKernelError   = event.Name("TargetKernelError")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventDmesgCaptured, EventKernelError}
+
+const (
+	defaultCommand = "dmesg"
+	// defaultMaxSize is the default number of bytes of dmesg that are kept.
+	defaultMaxSize = 64 * 1024
+	// maxMatches bounds the number of matching lines reported in the events.
+	maxMatches = 20
+)
+
+// capturedEventPayload is the payload of the TargetDmesgCaptured event.
+type capturedEventPayload struct {
+	Lines     int
+	Truncated bool `json:",omitempty"`
+	Dmesg     string
+}
+
+// kernelErrorEventPayload is the payload of the TargetKernelError event.
+type kernelErrorEventPayload struct {
+	Count   int
+	Matches []string
+}
+
+// Dmesg captures the kernel messages of the targets.
+type Dmesg struct {
+	*teststeps.SSHParams
+	Command *test.Param
+	// Lines, if not zero, is the number of most recent lines to keep.
+	Lines int
+	// MaxSize is the maximum number of bytes to keep.
+	MaxSize  int
+	Patterns []*regexp.Regexp
+}
+
+// Name returns the plugin name.
+func (ts Dmesg) Name() string {
+	return Name
+}
+
+// tail returns the last lines of the output, within the given number of lines
+// and bytes. Lines that do not fit entirely are dropped.
+func tail(out []byte, lines, maxSize int) ([]string, bool) {
+	var all []string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		all = append(all, s.Text())
+	}
+	truncated := false
+	if lines > 0 && len(all) > lines {
+		all, truncated = all[len(all)-lines:], true
+	}
+	size := 0
+	for i := len(all) - 1; i >= 0; i-- {
+		size += len(all[i]) + 1
+		if size > maxSize {
+			return all[i+1:], true
+		}
+	}
+	return all, truncated
+}
+
+// matches returns the lines matching any of the error patterns.
+func (ts *Dmesg) matches(lines []string) []string {
+	var matching []string
+	for _, line := range lines {
+		for _, re := range ts.Patterns {
+			if re.MatchString(line) {
+				matching = append(matching, line)
+				break
+			}
+		}
+	}
+	return matching
+}
+
+func (ts *Dmesg) capture(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	cmd, err := ts.Command.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand command parameter: %v", err)
+	}
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	lines, truncated := tail(result.Stdout, ts.Lines, ts.MaxSize)
+	payload := capturedEventPayload{Lines: len(lines), Truncated: truncated, Dmesg: strings.Join(lines, "\n")}
+	if err := teststeps.EmitTargetEvent(ev, EventDmesgCaptured, target, payload); err != nil {
+		return err
+	}
+	matching := ts.matches(lines)
+	if len(matching) == 0 {
+		return nil
+	}
+	errPayload := kernelErrorEventPayload{Count: len(matching), Matches: matching}
+	if len(matching) > maxMatches {
+		errPayload.Matches = matching[:maxMatches]
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventKernelError, target, errPayload); err != nil {
+		return err
+	}
+	return fmt.Errorf("%d kernel messages match the error patterns, first: %s", len(matching), matching[0])
+}
+
+// Run executes the dmesg step.
+func (ts *Dmesg) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.capture(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the step normally, as reading the kernel messages does not
+// clear them.
+func (ts *Dmesg) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// intParam returns the value of a non-negative integer parameter, or the
+// default value if it is not set.
+func intParam(params test.TestStepParameters, name string, defaultValue int) (int, error) {
+	if params.GetOne(name).IsEmpty() {
+		return defaultValue, nil
+	}
+	v, err := params.GetInt(name)
+	if err != nil {
+		return 0, fmt.Errorf("invalid '%s' parameter: %v", name, err)
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("invalid '%s' parameter: must not be negative", name)
+	}
+	return int(v), nil
+}
+
+func (ts *Dmesg) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	sudo, err := teststeps.BoolParam(params, "sudo", false)
+	if err != nil {
+		return err
+	}
+	ts.Command = params.GetOne("command")
+	if ts.Command.IsEmpty() {
+		cmd := defaultCommand
+		if sudo {
+			cmd = "sudo -n " + cmd
+		}
+		ts.Command = test.NewParam(cmd)
+	} else if sudo {
+		return errors.New("'sudo' cannot be used with a custom 'command'")
+	}
+	if ts.Lines, err = intParam(params, "lines", 0); err != nil {
+		return err
+	}
+	if ts.MaxSize, err = intParam(params, "max_size", defaultMaxSize); err != nil {
+		return err
+	}
+	if ts.MaxSize == 0 {
+		return errors.New("invalid 'max_size' parameter: must be positive")
+	}
+	ts.Patterns = nil
+	for _, p := range params.Get("error_pattern") {
+		re, err := regexp.Compile(p.Raw())
+		if err != nil {
+			return fmt.Errorf("invalid 'error_pattern' parameter %q: %v", p.Raw(), err)
+		}
+		ts.Patterns = append(ts.Patterns, re)
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *Dmesg) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. Dmesg cannot
+// resume.
+func (ts *Dmesg) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *Dmesg) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new Dmesg test step.
+func New() test.TestStep {
+	return &Dmesg{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
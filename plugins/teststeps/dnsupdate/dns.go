@@ -0,0 +1,591 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package dnsupdate
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// DNS constants, see RFC 1035, RFC 2136 and RFC 8945.
+const (
+	typeA     uint16 = 1
+	typeCNAME uint16 = 5
+	typeSOA   uint16 = 6
+	typeTXT   uint16 = 16
+	typeAAAA  uint16 = 28
+	typeTSIG  uint16 = 250
+
+	classINET uint16 = 1
+	classANY  uint16 = 255
+
+	opcodeQuery  = 0
+	opcodeUpdate = 5
+
+	flagResponse  = 1 << 15
+	flagTruncated = 1 << 9
+	flagRecursion = 1 << 8
+
+	headerLen = 12
+	// tsigFudge is the clock skew, in seconds, that the server may accept.
+	tsigFudge = 300
+)
+
+// recordTypes are the supported record types.
+var recordTypes = map[string]uint16{
+	"A":     typeA,
+	"AAAA":  typeAAAA,
+	"CNAME": typeCNAME,
+	"TXT":   typeTXT,
+}
+
+var rcodes = map[int]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+	16: "BADSIG",
+	17: "BADKEY",
+	18: "BADTIME",
+}
+
+func rcodeName(rcode int) string {
+	if name, ok := rcodes[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// tsigAlgorithms are the supported TSIG algorithms, by name.
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1":   sha1.New,
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// tsigKey is a TSIG key signing the updates.
+type tsigKey struct {
+	name      string
+	algorithm string
+	secret    []byte
+}
+
+// fqdn returns the name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// validateName checks the syntax of a domain name, without the trailing dot.
+func validateName(name string) error {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return errors.New("empty name")
+	}
+	if len(name) > 253 {
+		return fmt.Errorf("name %q is longer than 253 characters", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("name %q has an empty label or a label longer than 63 characters", name)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("name %q contains invalid character %q", name, c)
+			}
+		}
+	}
+	return nil
+}
+
+// appendName appends a domain name, uncompressed, in lowercase so that it is
+// in canonical form for the TSIG computations.
+func appendName(b []byte, name string) []byte {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendRR appends a resource record.
+func appendRR(b []byte, name string, rrtype, class uint16, ttl uint32, rdata []byte) []byte {
+	b = appendName(b, name)
+	b = appendUint16(b, rrtype)
+	b = appendUint16(b, class)
+	b = appendUint32(b, ttl)
+	b = appendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+// encodeRData returns the data of a record of the given type and value.
+func encodeRData(rrtype uint16, value string) ([]byte, error) {
+	switch rrtype {
+	case typeA:
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() == nil || strings.Contains(value, ":") {
+			return nil, fmt.Errorf("%q is not an IPv4 address", value)
+		}
+		return ip.To4(), nil
+	case typeAAAA:
+		ip := net.ParseIP(value)
+		if ip == nil || !strings.Contains(value, ":") {
+			return nil, fmt.Errorf("%q is not an IPv6 address", value)
+		}
+		return ip.To16(), nil
+	case typeCNAME:
+		if err := validateName(value); err != nil {
+			return nil, err
+		}
+		return appendName(nil, value), nil
+	case typeTXT:
+		// long values are split in strings of at most 255 bytes
+		var b []byte
+		for len(value) > 255 {
+			b = append(append(b, 255), value[:255]...)
+			value = value[255:]
+		}
+		b = append(append(b, byte(len(value))), value...)
+		if len(b) > 65535 {
+			return nil, errors.New("TXT value is too long")
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unsupported record type %d", rrtype)
+}
+
+// newID returns a random message ID.
+func newID() (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return 0, fmt.Errorf("cannot generate message ID: %v", err)
+	}
+	return binary.BigEndian.Uint16(b[:]), nil
+}
+
+// appendHeader appends a message header.
+func appendHeader(b []byte, id, flags, qdcount, ancount, nscount, arcount uint16) []byte {
+	for _, v := range []uint16{id, flags, qdcount, ancount, nscount, arcount} {
+		b = appendUint16(b, v)
+	}
+	return b
+}
+
+// newUpdate returns an update message replacing the records of the given
+// name and type in the zone with a single record.
+func newUpdate(id uint16, zone, name string, rrtype uint16, ttl uint32, rdata []byte) []byte {
+	b := appendHeader(make([]byte, 0, 512), id, opcodeUpdate<<11, 1, 0, 2, 0)
+	// zone section
+	b = appendName(b, zone)
+	b = appendUint16(b, typeSOA)
+	b = appendUint16(b, classINET)
+	// update section: delete the RRset, then add the record
+	b = appendRR(b, name, rrtype, classANY, 0, nil)
+	return appendRR(b, name, rrtype, classINET, ttl, rdata)
+}
+
+// newQuery returns a query for the records of the given name and type.
+func newQuery(id uint16, name string, rrtype uint16) []byte {
+	b := appendHeader(make([]byte, 0, 512), id, opcodeQuery<<11|flagRecursion, 1, 0, 0, 0)
+	b = appendName(b, name)
+	b = appendUint16(b, rrtype)
+	return appendUint16(b, classINET)
+}
+
+// tsigMAC returns the MAC of a message and of the TSIG variables following it,
+// see RFC 8945 section 4.3. prefix is the MAC of the request when signing a
+// response, and empty for requests.
+func tsigMAC(key *tsigKey, prefix, msg []byte, timeSigned uint64, fudge uint16, tsigErr uint16, other []byte) []byte {
+	mac := hmac.New(tsigAlgorithms[key.algorithm], key.secret)
+	mac.Write(prefix)
+	mac.Write(msg)
+	variables := appendName(nil, key.name)
+	variables = appendUint16(variables, classANY)
+	variables = appendUint32(variables, 0)
+	variables = appendName(variables, key.algorithm)
+	variables = appendTime(variables, timeSigned, fudge)
+	variables = appendUint16(variables, tsigErr)
+	variables = appendUint16(variables, uint16(len(other)))
+	variables = append(variables, other...)
+	mac.Write(variables)
+	return mac.Sum(nil)
+}
+
+// appendTime appends the 48-bit time signed and the fudge of a TSIG record.
+func appendTime(b []byte, timeSigned uint64, fudge uint16) []byte {
+	b = append(b, byte(timeSigned>>40), byte(timeSigned>>32), byte(timeSigned>>24), byte(timeSigned>>16), byte(timeSigned>>8), byte(timeSigned))
+	return appendUint16(b, fudge)
+}
+
+// sign appends a TSIG record to a message, signed at the given time. It
+// returns the signed message and its MAC, which signs the response too.
+func sign(msg []byte, key *tsigKey, now time.Time) ([]byte, []byte) {
+	timeSigned := uint64(now.Unix())
+	sum := tsigMAC(key, nil, msg, timeSigned, tsigFudge, 0, nil)
+
+	rdata := appendName(nil, key.algorithm)
+	rdata = appendTime(rdata, timeSigned, tsigFudge)
+	rdata = appendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	// original ID, error and other data length
+	rdata = append(rdata, msg[0], msg[1])
+	rdata = appendUint16(rdata, 0)
+	rdata = appendUint16(rdata, 0)
+
+	signed := append([]byte{}, msg...)
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(msg[10:])+1)
+	return appendRR(signed, key.name, typeTSIG, classANY, 0, rdata), sum
+}
+
+// record is a resource record of a response.
+type record struct {
+	name   string
+	rrtype uint16
+	// value is the textual value of the supported record types.
+	value string
+	rdata []byte
+}
+
+// tsigRecord is the TSIG record of a response.
+type tsigRecord struct {
+	name       string
+	algorithm  string
+	timeSigned uint64
+	fudge      uint16
+	mac        []byte
+	originalID uint16
+	err        int
+	other      []byte
+	// offset is the offset of the record in the message.
+	offset int
+}
+
+// response is a parsed DNS response.
+type response struct {
+	id      uint16
+	flags   uint16
+	rcode   int
+	answers []record
+	// tsig is the TSIG record of the response, if any.
+	tsig *tsigRecord
+	// msg is the raw response, which the TSIG record signs.
+	msg []byte
+}
+
+// tsigError returns the error of the TSIG record of the response, if any.
+func (r *response) tsigError() int {
+	if r.tsig == nil {
+		return 0
+	}
+	return r.tsig.err
+}
+
+var errMalformed = errors.New("malformed DNS message")
+
+// readName reads a possibly compressed name at the given offset, and returns
+// it with the offset following it.
+func readName(msg []byte, off int) (string, int, error) {
+	var (
+		labels []string
+		end    = -1
+	)
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errMalformed
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 64 {
+				return "", 0, errMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		case l&0xc0 != 0:
+			return "", 0, errMalformed
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// decodeValue returns the textual value of a record.
+func decodeValue(msg []byte, rrtype uint16, rdataOff, rdataLen int) (string, error) {
+	rdata := msg[rdataOff : rdataOff+rdataLen]
+	switch rrtype {
+	case typeA, typeAAAA:
+		if len(rdata) != net.IPv4len && len(rdata) != net.IPv6len {
+			return "", errMalformed
+		}
+		return net.IP(rdata).String(), nil
+	case typeCNAME:
+		name, _, err := readName(msg, rdataOff)
+		return name, err
+	case typeTXT:
+		var value strings.Builder
+		for len(rdata) > 0 {
+			l := int(rdata[0])
+			if 1+l > len(rdata) {
+				return "", errMalformed
+			}
+			value.Write(rdata[1 : 1+l])
+			rdata = rdata[1+l:]
+		}
+		return value.String(), nil
+	}
+	return "", nil
+}
+
+// parseTSIG parses the data of a TSIG record: the algorithm, the times, the
+// MAC, the original ID, the error and the other data.
+func parseTSIG(msg []byte, off, rdataLen int) (*tsigRecord, error) {
+	end := off + rdataLen
+	algorithm, off, err := readName(msg, off)
+	if err != nil {
+		return nil, err
+	}
+	if off+10 > end {
+		return nil, errMalformed
+	}
+	tsig := &tsigRecord{algorithm: algorithm}
+	for _, b := range msg[off : off+6] {
+		tsig.timeSigned = tsig.timeSigned<<8 | uint64(b)
+	}
+	tsig.fudge = binary.BigEndian.Uint16(msg[off+6:])
+	macLen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if off+macLen+6 > end {
+		return nil, errMalformed
+	}
+	tsig.mac = msg[off : off+macLen]
+	off += macLen
+	tsig.originalID = binary.BigEndian.Uint16(msg[off:])
+	tsig.err = int(binary.BigEndian.Uint16(msg[off+2:]))
+	otherLen := int(binary.BigEndian.Uint16(msg[off+4:]))
+	off += 6
+	if off+otherLen != end {
+		return nil, errMalformed
+	}
+	tsig.other = msg[off:end]
+	return tsig, nil
+}
+
+// verifyResponse checks the TSIG record of a response to a request signed
+// with key and requestMAC, see RFC 8945 section 5.3. Truncated MACs are not
+// accepted, as they are never sent to the requests of this step.
+func verifyResponse(resp *response, key *tsigKey, requestMAC []byte, now time.Time) error {
+	tsig := resp.tsig
+	if tsig == nil {
+		return errors.New("the response is not signed")
+	}
+	if !strings.EqualFold(fqdn(tsig.name), fqdn(key.name)) || !strings.EqualFold(fqdn(tsig.algorithm), fqdn(key.algorithm)) {
+		return fmt.Errorf("the response is signed with key %s (%s), expected %s (%s)", tsig.name, tsig.algorithm, fqdn(key.name), key.algorithm)
+	}
+	// the MAC covers the response without its TSIG record, as it was before
+	// the record was added
+	unsigned := append([]byte{}, resp.msg[:tsig.offset]...)
+	binary.BigEndian.PutUint16(unsigned, tsig.originalID)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:])-1)
+	prefix := appendUint16(nil, uint16(len(requestMAC)))
+	prefix = append(prefix, requestMAC...)
+	expected := tsigMAC(key, prefix, unsigned, tsig.timeSigned, tsig.fudge, uint16(tsig.err), tsig.other)
+	if !hmac.Equal(tsig.mac, expected) {
+		return errors.New("the MAC of the response is invalid")
+	}
+	signed := int64(tsig.timeSigned)
+	if d := now.Unix() - signed; d > int64(tsig.fudge) || -d > int64(tsig.fudge) {
+		return fmt.Errorf("the response was signed at %s, outside of the %ds fudge", time.Unix(signed, 0).UTC(), tsig.fudge)
+	}
+	return nil
+}
+
+// parseResponse parses the header, the answers and the TSIG record of a
+// response.
+func parseResponse(msg []byte) (*response, error) {
+	if len(msg) < headerLen {
+		return nil, errMalformed
+	}
+	resp := &response{
+		id:    binary.BigEndian.Uint16(msg),
+		flags: binary.BigEndian.Uint16(msg[2:]),
+		msg:   msg,
+	}
+	resp.rcode = int(resp.flags & 0xf)
+	counts := [4]int{}
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(msg[4+2*i:]))
+	}
+	off := headerLen
+	for i := 0; i < counts[0]; i++ {
+		var err error
+		if _, off, err = readName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+4 > len(msg) {
+			return nil, errMalformed
+		}
+		off += 4
+	}
+	for section := 1; section < 4; section++ {
+		for i := 0; i < counts[section]; i++ {
+			start := off
+			name, next, err := readName(msg, off)
+			if err != nil {
+				return nil, err
+			}
+			off = next
+			if off+10 > len(msg) {
+				return nil, errMalformed
+			}
+			r := record{name: name, rrtype: binary.BigEndian.Uint16(msg[off:])}
+			rdataLen := int(binary.BigEndian.Uint16(msg[off+8:]))
+			off += 10
+			if off+rdataLen > len(msg) {
+				return nil, errMalformed
+			}
+			r.rdata = msg[off : off+rdataLen]
+			switch {
+			case section == 1:
+				if r.value, err = decodeValue(msg, r.rrtype, off, rdataLen); err != nil {
+					return nil, err
+				}
+				resp.answers = append(resp.answers, r)
+			case section == 3 && r.rrtype == typeTSIG:
+				// the TSIG record must be the last one
+				if i != counts[3]-1 {
+					return nil, errMalformed
+				}
+				if resp.tsig, err = parseTSIG(msg, off, rdataLen); err != nil {
+					return nil, err
+				}
+				resp.tsig.name = name
+				resp.tsig.offset = start
+			}
+			off += rdataLen
+		}
+	}
+	return resp, nil
+}
+
+// exchange sends a message to a server over UDP, or over TCP if the response
+// is truncated, and returns the parsed response. The context bounds the whole
+// exchange.
+func exchange(ctx context.Context, timeouts teststeps.NetworkTimeouts, server string, msg []byte) (*response, error) {
+	resp, err := exchangeOver(ctx, timeouts, "udp", server, msg)
+	if err == nil && resp.flags&flagTruncated != 0 {
+		resp, err = exchangeOver(ctx, timeouts, "tcp", server, msg)
+	}
+	return resp, err
+}
+
+func exchangeOver(ctx context.Context, timeouts teststeps.NetworkTimeouts, network, server string, msg []byte) (*response, error) {
+	conn, err := timeouts.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	// unblock the reads when the context is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	id := binary.BigEndian.Uint16(msg)
+	if network == "tcp" {
+		framed := appendUint16(make([]byte, 0, 2+len(msg)), uint16(len(msg)))
+		if _, err := conn.Write(append(framed, msg...)); err != nil {
+			return nil, err
+		}
+		var l [2]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+		resp, err := parseResponse(buf)
+		if err != nil {
+			return nil, err
+		}
+		if resp.id != id || resp.flags&flagResponse == 0 {
+			return nil, errors.New("unexpected response")
+		}
+		return resp, nil
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := parseResponse(buf[:n])
+		// ignore the stray datagrams
+		if err != nil || resp.id != id || resp.flags&flagResponse == 0 {
+			continue
+		}
+		return resp, nil
+	}
+}
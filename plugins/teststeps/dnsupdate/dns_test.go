@@ -0,0 +1,243 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package dnsupdate
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The vectors below were computed independently of this package, following
+// the layout of RFC 8945 section 4.3.3: an update of host.example.com to
+// 192.0.2.1 with ID 0x1234, signed at 1600000000 with a fudge of 300 by the
+// key update-key.example.com, whose secret is the bytes 0 to 31.
+const (
+	vectorSecret = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+	vectorUpdate = "123428000001000000020000076578616d706c6503636f6d000006000104686f7374076578616d706c6503636f6d00000100ff" +
+		"00000000000004686f7374076578616d706c6503636f6d00000100010000012c0004c0000201"
+	vectorSigned = "123428000001000000020001076578616d706c6503636f6d000006000104686f7374076578616d706c6503636f6d00000100ff" +
+		"00000000000004686f7374076578616d706c6503636f6d00000100010000012c0004c00002010a7570646174652d6b6579076578616d706c6503636f6d" +
+		"0000fa00ff00000000003d0b686d61632d7368613235360000005f5e1000012c00202d0b4a8c978a311b49e519c24dae4a79d8cfd4b30719d3821d458378" +
+		"dfb40c01123400000000"
+	// vectorResponse is the NOERROR response to vectorSigned, signed at
+	// 1600000010.
+	vectorResponse = "1234a8000001000000000001076578616d706c6503636f6d00000600010a7570646174652d6b6579076578616d706c6503636f6d" +
+		"0000fa00ff00000000003d0b686d61632d7368613235360000005f5e100a012c00205a40844e74a9eb13950e805e2200a7c73c1eb1058a29f5a53266e2" +
+		"bbb672c847123400000000"
+)
+
+var vectorTime = time.Unix(1600000000, 0)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func vectorKey(t *testing.T, algorithm string) *tsigKey {
+	secret, err := base64.StdEncoding.DecodeString(vectorSecret)
+	require.NoError(t, err)
+	return &tsigKey{name: "update-key.example.com", algorithm: algorithm, secret: secret}
+}
+
+func TestNewUpdate(t *testing.T) {
+	msg := newUpdate(0x1234, "example.com", "host.example.com.", typeA, 300, []byte{192, 0, 2, 1})
+	require.Equal(t, vectorUpdate, hex.EncodeToString(msg))
+}
+
+func TestSign(t *testing.T) {
+	msg := decodeHex(t, vectorUpdate)
+	for _, tc := range []struct {
+		algorithm string
+		mac       string
+	}{
+		{"hmac-sha1", "7b98b2081c9d2a19875b0b145ff7a632c58a9312"},
+		{"hmac-sha256", "2d0b4a8c978a311b49e519c24dae4a79d8cfd4b30719d3821d458378dfb40c01"},
+		{"hmac-sha512", "0d79be5457078b1b4409e90ecd497e23ed39b20d3b106cbcc633bbd150ff01d0e28cba6e174c13b21db5756f0cf3350c426edacbc438b373c5752612ceff6f9c"},
+	} {
+		t.Run(tc.algorithm, func(t *testing.T) {
+			signed, mac := sign(msg, vectorKey(t, tc.algorithm), vectorTime)
+			require.Equal(t, tc.mac, hex.EncodeToString(mac))
+			// the message is left untouched, and the signed one carries the MAC
+			require.Equal(t, vectorUpdate, hex.EncodeToString(msg))
+			require.Contains(t, hex.EncodeToString(signed), tc.mac)
+			require.Equal(t, uint16(1), binary.BigEndian.Uint16(signed[10:]))
+		})
+	}
+	signed, _ := sign(msg, vectorKey(t, "hmac-sha256"), vectorTime)
+	require.Equal(t, vectorSigned, hex.EncodeToString(signed))
+}
+
+func TestVerifyResponse(t *testing.T) {
+	key := vectorKey(t, "hmac-sha256")
+	_, requestMAC := sign(decodeHex(t, vectorUpdate), key, vectorTime)
+	parse := func(t *testing.T, msg []byte) *response {
+		resp, err := parseResponse(msg)
+		require.NoError(t, err)
+		return resp
+	}
+	resp := parse(t, decodeHex(t, vectorResponse))
+	require.Equal(t, 0, resp.tsigError())
+	require.NoError(t, verifyResponse(resp, key, requestMAC, vectorTime))
+
+	// the MAC of the response depends on the MAC of the request
+	otherMAC := append([]byte{}, requestMAC...)
+	otherMAC[0] ^= 1
+	require.Error(t, verifyResponse(resp, key, otherMAC, vectorTime))
+	// and on the key
+	otherKey := vectorKey(t, "hmac-sha256")
+	otherKey.secret[0] ^= 1
+	require.Error(t, verifyResponse(resp, otherKey, requestMAC, vectorTime))
+	otherKey = vectorKey(t, "hmac-sha256")
+	otherKey.name = "other-key.example.com"
+	require.Error(t, verifyResponse(resp, otherKey, requestMAC, vectorTime))
+	// the signature must be recent
+	require.NoError(t, verifyResponse(resp, key, requestMAC, vectorTime.Add(300*time.Second)))
+	require.Error(t, verifyResponse(resp, key, requestMAC, vectorTime.Add(400*time.Second)))
+	require.Error(t, verifyResponse(resp, key, requestMAC, vectorTime.Add(-300*time.Second)))
+
+	// a forged rcode, or a tampered ID, invalidates the MAC
+	tampered := decodeHex(t, vectorResponse)
+	tampered[3] |= 5
+	require.Error(t, verifyResponse(parse(t, tampered), key, requestMAC, vectorTime))
+	tampered = decodeHex(t, vectorResponse)
+	tampered[len(tampered)-6] ^= 1
+	require.Error(t, verifyResponse(parse(t, tampered), key, requestMAC, vectorTime))
+
+	// unsigned responses are rejected
+	unsigned := decodeHex(t, vectorResponse)[:29]
+	unsigned[11] = 0
+	require.EqualError(t, verifyResponse(parse(t, unsigned), key, requestMAC, vectorTime), "the response is not signed")
+}
+
+// header returns a response header with the given counts.
+func header(qdcount, ancount, nscount, arcount uint16) []byte {
+	return appendHeader(nil, 0x1234, flagResponse, qdcount, ancount, nscount, arcount)
+}
+
+func TestParseResponse(t *testing.T) {
+	// the question is host.example.com, whose name is at offset 12
+	question := appendUint16(appendUint16(appendName(header(1, 1, 0, 0), "host.example.com"), typeA), classINET)
+	answer := func(b []byte, name []byte, rrtype uint16, rdata []byte) []byte {
+		b = append(append([]byte{}, b...), name...)
+		b = appendUint16(b, rrtype)
+		b = appendUint16(b, classINET)
+		b = appendUint32(b, 60)
+		b = appendUint16(b, uint16(len(rdata)))
+		return append(b, rdata...)
+	}
+	pointer := func(off uint16) []byte { return []byte{0xc0 | byte(off>>8), byte(off)} }
+
+	for _, tc := range []struct {
+		name   string
+		msg    []byte
+		answer record
+	}{
+		{
+			name:   "uncompressed A",
+			msg:    answer(question, appendName(nil, "host.example.com"), typeA, []byte{192, 0, 2, 1}),
+			answer: record{name: "host.example.com.", rrtype: typeA, value: "192.0.2.1"},
+		},
+		{
+			name:   "compressed name",
+			msg:    answer(question, pointer(12), typeAAAA, make([]byte, 16)),
+			answer: record{name: "host.example.com.", rrtype: typeAAAA, value: "::"},
+		},
+		{
+			// www followed by a pointer to example.com in the question
+			name:   "label then pointer",
+			msg:    answer(question, append([]byte{3, 'w', 'w', 'w'}, pointer(17)...), typeA, []byte{192, 0, 2, 2}),
+			answer: record{name: "www.example.com.", rrtype: typeA, value: "192.0.2.2"},
+		},
+		{
+			name:   "compressed CNAME target",
+			msg:    answer(question, pointer(12), typeCNAME, append([]byte{5, 'a', 'l', 'i', 'a', 's'}, pointer(17)...)),
+			answer: record{name: "host.example.com.", rrtype: typeCNAME, value: "alias.example.com."},
+		},
+		{
+			name:   "TXT strings",
+			msg:    answer(question, pointer(12), typeTXT, []byte{2, 'a', 'b', 0, 1, 'c'}),
+			answer: record{name: "host.example.com.", rrtype: typeTXT, value: "abc"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := parseResponse(tc.msg)
+			require.NoError(t, err)
+			require.Len(t, resp.answers, 1)
+			require.Equal(t, tc.answer.name, resp.answers[0].name)
+			require.Equal(t, tc.answer.rrtype, resp.answers[0].rrtype)
+			require.Equal(t, tc.answer.value, resp.answers[0].value)
+		})
+	}
+}
+
+func TestParseResponseMalformed(t *testing.T) {
+	question := appendUint16(appendUint16(appendName(header(1, 1, 0, 0), "host.example.com"), typeA), classINET)
+	rr := func(name []byte, rrtype uint16, rdataLen uint16, rdata []byte) []byte {
+		b := append(append([]byte{}, question...), name...)
+		b = appendUint16(b, rrtype)
+		b = appendUint16(b, classINET)
+		b = appendUint32(b, 60)
+		b = appendUint16(b, rdataLen)
+		return append(b, rdata...)
+	}
+	selfPointer := func() []byte {
+		// a pointer to itself, right after the question
+		off := len(question)
+		return []byte{0xc0 | byte(off>>8), byte(off)}
+	}
+	loop := func() []byte {
+		// two pointers to each other
+		off := len(question)
+		return []byte{0xc0 | byte((off+2)>>8), byte(off + 2), 0xc0 | byte(off>>8), byte(off)}
+	}
+	signed := decodeHex(t, vectorResponse)
+
+	for _, tc := range []struct {
+		name string
+		msg  []byte
+	}{
+		{"short header", header(0, 0, 0, 0)[:11]},
+		{"missing question", header(1, 0, 0, 0)},
+		{"truncated label", append(header(1, 0, 0, 0), 5, 'h', 'o')},
+		{"pointer to itself", rr(selfPointer(), typeA, 4, []byte{192, 0, 2, 1})},
+		{"pointer loop", rr(loop(), typeA, 4, []byte{192, 0, 2, 1})},
+		{"pointer out of the message", rr([]byte{0xff, 0xff}, typeA, 4, []byte{192, 0, 2, 1})},
+		{"truncated pointer", append(header(1, 0, 0, 0), 0xc0)},
+		{"truncated question", append(appendName(header(1, 0, 0, 0), "host"), 0, 1)},
+		{"reserved label type", rr([]byte{0x40}, typeA, 4, []byte{192, 0, 2, 1})},
+		{"truncated record", rr(appendName(nil, "host"), typeA, 4, nil)[:len(question)+9]},
+		{"truncated rdata", rr(appendName(nil, "host"), typeA, 4, []byte{192, 0})},
+		{"oversized rdata length", rr(appendName(nil, "host"), typeA, 0xffff, []byte{192, 0, 2, 1})},
+		{"A of the wrong size", rr(appendName(nil, "host"), typeA, 3, []byte{192, 0, 2})},
+		{"truncated TXT string", rr(appendName(nil, "host"), typeTXT, 3, []byte{5, 'a', 'b'})},
+		{"CNAME pointer loop", rr(appendName(nil, "host"), typeCNAME, 2, []byte{0xc0, byte(len(question) + 16)})},
+		{"truncated TSIG", append([]byte{}, signed[:len(signed)-10]...)},
+		{"TSIG MAC longer than the record", func() []byte {
+			b := append([]byte{}, signed...)
+			// the MAC size precedes the MAC, the original ID, the error
+			// and the other data length
+			macSizeOff := len(b) - 6 - 32 - 2
+			binary.BigEndian.PutUint16(b[macSizeOff:], 64)
+			return b
+		}()},
+		{"TSIG not last", func() []byte {
+			b := append([]byte{}, signed...)
+			binary.BigEndian.PutUint16(b[10:], 2)
+			return append(b, appendRR(nil, "example.com", typeA, classINET, 0, []byte{192, 0, 2, 1})...)
+		}()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseResponse(tc.msg)
+			require.Equal(t, errMalformed, err)
+		})
+	}
+}
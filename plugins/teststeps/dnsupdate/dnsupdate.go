@@ -0,0 +1,478 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package dnsupdate
+
+// The DNSUpdate plugin sets a record derived from each target with a dynamic
+// DNS update (RFC 2136) sent to the primary server of the zone, optionally
+// signed with a TSIG key, then polls the resolvers until they all return the
+// new value. The update replaces all the records of the same name and type.
+// A, AAAA, CNAME and TXT records are supported. Record names are relative to
+// the zone, unless they have a trailing dot. When the update is signed, the
+// TSIG record of a successful response is verified too.
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "DNSUpdate"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventDnsUpdated            = event.Name("TargetDnsUpdated")
+	EventDnsPropagated         = event.Name("TargetDnsPropagated")
+	EventDnsPropagationTimeout = event.Name("TargetDnsPropagationTimeout")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventDnsUpdated, EventDnsPropagated, EventDnsPropagationTimeout}
+
+const (
+	defaultRecord             = "{{ .Name }}"
+	defaultType               = "A"
+	defaultTTL                = 60
+	defaultTSIGAlgorithm      = "hmac-sha256"
+	defaultPort               = "53"
+	defaultRequestTimeout     = 5 * time.Second
+	defaultPropagationTimeout = 60 * time.Second
+	defaultPollInterval       = 2 * time.Second
+)
+
+// dnsEventPayload is the payload of the events emitted by this step.
+type dnsEventPayload struct {
+	Record string
+	Type   string
+	Value  string
+	// Pending maps the resolvers which did not return the value yet to the
+	// values they returned.
+	Pending map[string][]string `json:",omitempty"`
+	Elapsed string              `json:",omitempty"`
+}
+
+// DNSUpdate updates a DNS record for each target and waits for it to
+// propagate.
+type DNSUpdate struct {
+	Server             string
+	Zone               string
+	Record             *test.Param
+	Type               string
+	Value              *test.Param
+	TTL                uint32
+	TSIGKey            string
+	TSIGSecret         *test.Param
+	TSIGAlgorithm      string
+	Resolvers          []string
+	RequestTimeout     time.Duration
+	PropagationTimeout time.Duration
+	PollInterval       time.Duration
+	Timeouts           teststeps.NetworkTimeouts
+}
+
+// Name returns the plugin name.
+func (ts DNSUpdate) Name() string {
+	return Name
+}
+
+// recordName returns the fully qualified record name, which is relative to
+// the zone unless it has a trailing dot.
+func (ts *DNSUpdate) recordName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if !strings.HasSuffix(name, ".") {
+		name = name + "." + ts.Zone
+	}
+	if err := validateName(name); err != nil {
+		return "", err
+	}
+	if lower := strings.ToLower(name); lower != ts.Zone && !strings.HasSuffix(lower, "."+ts.Zone) {
+		return "", fmt.Errorf("record %s is not in zone %s", name, ts.Zone)
+	}
+	return name, nil
+}
+
+// expand returns the record name and value of a target.
+func (ts *DNSUpdate) expand(target *target.Target) (string, string, error) {
+	name, err := ts.Record.Expand(target)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot expand record parameter: %v", err)
+	}
+	if name, err = ts.recordName(name); err != nil {
+		return "", "", fmt.Errorf("invalid record: %v", err)
+	}
+	value, err := ts.Value.Expand(target)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot expand value parameter: %v", err)
+	}
+	if _, err := encodeRData(recordTypes[ts.Type], value); err != nil {
+		return "", "", fmt.Errorf("invalid value for %s record: %v", ts.Type, err)
+	}
+	return name, value, nil
+}
+
+// sameValue tells whether a value returned by a resolver is the expected one.
+func (ts *DNSUpdate) sameValue(got, want string) bool {
+	switch ts.Type {
+	case "A", "AAAA":
+		return net.ParseIP(got).Equal(net.ParseIP(want))
+	case "CNAME":
+		return strings.EqualFold(fqdn(got), fqdn(want))
+	}
+	return got == want
+}
+
+func (ts *DNSUpdate) update(ctx context.Context, name, value string) error {
+	key, err := ts.tsigKey()
+	if err != nil {
+		return err
+	}
+	rrtype := recordTypes[ts.Type]
+	rdata, err := encodeRData(rrtype, value)
+	if err != nil {
+		return err
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	msg := newUpdate(id, ts.Zone, name, rrtype, ts.TTL, rdata)
+	var mac []byte
+	if key != nil {
+		msg, mac = sign(msg, key, time.Now())
+	}
+	rctx, cancel := context.WithTimeout(ctx, ts.RequestTimeout)
+	defer cancel()
+	resp, err := exchange(rctx, ts.Timeouts, ts.Server, msg)
+	if err != nil {
+		if ctx.Err() != nil {
+			return teststeps.ErrInterrupted
+		}
+		return fmt.Errorf("update of %s %s on %s failed: %v", name, ts.Type, ts.Server, err)
+	}
+	if resp.rcode != 0 || resp.tsigError() != 0 {
+		rcode := rcodeName(resp.rcode)
+		if resp.tsigError() != 0 {
+			rcode += "/" + rcodeName(resp.tsigError())
+		}
+		return fmt.Errorf("update of %s %s was rejected by %s: %s", name, ts.Type, ts.Server, rcode)
+	}
+	// rejections are reported whether or not they are signed, as servers do
+	// not sign some of them, but a success must come from the server
+	if key != nil {
+		if err := verifyResponse(resp, key, mac, time.Now()); err != nil {
+			return fmt.Errorf("update of %s %s on %s failed: invalid response: %v", name, ts.Type, ts.Server, err)
+		}
+	}
+	return nil
+}
+
+// tsigKey returns the key signing the updates, or nil if they are not signed.
+func (ts *DNSUpdate) tsigKey() (*tsigKey, error) {
+	if ts.TSIGKey == "" {
+		return nil, nil
+	}
+	encoded, err := secrets.Expand(ts.TSIGSecret.Raw())
+	if err != nil {
+		return nil, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		// do not include the secret
+		return nil, errors.New("invalid TSIG secret: must be base64-encoded")
+	}
+	return &tsigKey{name: ts.TSIGKey, algorithm: ts.TSIGAlgorithm, secret: secret}, nil
+}
+
+// query returns the values of the record returned by a resolver.
+func (ts *DNSUpdate) query(ctx context.Context, resolver, name string) ([]string, error) {
+	rrtype := recordTypes[ts.Type]
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	rctx, cancel := context.WithTimeout(ctx, ts.RequestTimeout)
+	defer cancel()
+	resp, err := exchange(rctx, ts.Timeouts, resolver, newQuery(id, name, rrtype))
+	if err != nil {
+		return nil, err
+	}
+	switch rcodeName(resp.rcode) {
+	case "NOERROR", "NXDOMAIN":
+	default:
+		return nil, fmt.Errorf("query failed with %s", rcodeName(resp.rcode))
+	}
+	values := []string{}
+	for _, r := range resp.answers {
+		if r.rrtype == rrtype && strings.EqualFold(r.name, fqdn(name)) {
+			values = append(values, r.value)
+		}
+	}
+	return values, nil
+}
+
+// pending returns the resolvers which do not return the expected value only,
+// with the values they returned.
+func (ts *DNSUpdate) pending(ctx context.Context, name, value string) map[string][]string {
+	pending := make(map[string][]string)
+	for _, resolver := range ts.Resolvers {
+		values, err := ts.query(ctx, resolver, name)
+		if err != nil {
+			pending[resolver] = []string{"error: " + err.Error()}
+			continue
+		}
+		if len(values) != 1 || !ts.sameValue(values[0], value) {
+			pending[resolver] = values
+		}
+	}
+	return pending
+}
+
+func (ts *DNSUpdate) updateAndWait(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	name, value, err := ts.expand(target)
+	if err != nil {
+		return err
+	}
+	ctx, ctxCancel := teststeps.NewContext(cancel, pause)
+	defer ctxCancel()
+	if err := ts.update(ctx, name, value); err != nil {
+		return err
+	}
+	payload := dnsEventPayload{Record: name, Type: ts.Type, Value: value}
+	if err := teststeps.EmitTargetEvent(ev, EventDnsUpdated, target, payload); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	deadline := time.NewTimer(ts.PropagationTimeout)
+	defer deadline.Stop()
+	for {
+		pending := ts.pending(ctx, name, value)
+		if ctx.Err() != nil {
+			return teststeps.ErrInterrupted
+		}
+		payload.Pending = pending
+		payload.Elapsed = time.Since(start).Round(time.Millisecond).String()
+		if len(pending) == 0 {
+			return teststeps.EmitTargetEvent(ev, EventDnsPropagated, target, payload)
+		}
+		select {
+		case <-ctx.Done():
+			return teststeps.ErrInterrupted
+		case <-deadline.C:
+			if err := teststeps.EmitTargetEvent(ev, EventDnsPropagationTimeout, target, payload); err != nil {
+				return err
+			}
+			var resolvers []string
+			for r := range pending {
+				resolvers = append(resolvers, r)
+			}
+			sort.Strings(resolvers)
+			return fmt.Errorf("%s %s did not resolve to %s on %s within %s", name, ts.Type, value, strings.Join(resolvers, ", "), ts.PropagationTimeout)
+		case <-time.After(ts.PollInterval):
+		}
+	}
+}
+
+// Run executes the DNS update step.
+func (ts *DNSUpdate) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.updateAndWait(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun only logs the updates, which change the zone.
+func (ts *DNSUpdate) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	describe := func(target *target.Target) (string, error) {
+		name, value, err := ts.expand(target)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("set %s %s to %s on %s", name, ts.Type, value, ts.Server), nil
+	}
+	return teststeps.DryRunEachTarget(Name, cancel, pause, ch, describe)
+}
+
+// serverParam parses a host, with an optional port.
+func serverParam(name, s string) (string, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		host, port = strings.Trim(s, "[]"), defaultPort
+	}
+	if host == "" {
+		return "", fmt.Errorf("invalid '%s' parameter %q: missing host", name, s)
+	}
+	if net.ParseIP(host) == nil {
+		if err := validateName(host); err != nil {
+			return "", fmt.Errorf("invalid '%s' parameter %q: %v", name, s, err)
+		}
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("invalid '%s' parameter %q: invalid port", name, s)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func (ts *DNSUpdate) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	server := params.GetOne("server").Raw()
+	if server == "" {
+		return errors.New("missing 'server' parameter, must be the primary server of the zone")
+	}
+	if ts.Server, err = serverParam("server", server); err != nil {
+		return err
+	}
+	ts.Zone = strings.ToLower(fqdn(strings.TrimSpace(params.GetOne("zone").Raw())))
+	if ts.Zone == "." {
+		return errors.New("missing 'zone' parameter")
+	}
+	if err := validateName(ts.Zone); err != nil {
+		return fmt.Errorf("invalid 'zone' parameter: %v", err)
+	}
+
+	ts.Type = defaultType
+	if t := params.GetOne("type"); !t.IsEmpty() {
+		ts.Type = strings.ToUpper(t.Raw())
+	}
+	if _, ok := recordTypes[ts.Type]; !ok {
+		return fmt.Errorf("invalid 'type' parameter %q: must be A, AAAA, CNAME or TXT", ts.Type)
+	}
+	ts.Record = params.GetOne("record")
+	if ts.Record.IsEmpty() {
+		ts.Record = test.NewParam(defaultRecord)
+	}
+	if !strings.Contains(ts.Record.Raw(), "{{") {
+		if _, err := ts.recordName(ts.Record.Raw()); err != nil {
+			return fmt.Errorf("invalid 'record' parameter: %v", err)
+		}
+	}
+	ts.Value = params.GetOne("value")
+	if ts.Value.IsEmpty() {
+		return errors.New("missing 'value' parameter, must be the expected value of the record")
+	}
+	if !strings.Contains(ts.Value.Raw(), "{{") {
+		if _, err := encodeRData(recordTypes[ts.Type], ts.Value.Raw()); err != nil {
+			return fmt.Errorf("invalid 'value' parameter for %s record: %v", ts.Type, err)
+		}
+	}
+	ts.TTL = defaultTTL
+	if t := params.GetOne("ttl"); !t.IsEmpty() {
+		ttl, err := strconv.ParseUint(t.Raw(), 10, 31)
+		if err != nil {
+			return fmt.Errorf("invalid 'ttl' parameter %q: must be a number of seconds", t.Raw())
+		}
+		ts.TTL = uint32(ttl)
+	}
+
+	ts.TSIGKey = strings.TrimSpace(params.GetOne("tsig_key").Raw())
+	ts.TSIGSecret = params.GetOne("tsig_secret")
+	ts.TSIGAlgorithm = defaultTSIGAlgorithm
+	if a := params.GetOne("tsig_algorithm"); !a.IsEmpty() {
+		ts.TSIGAlgorithm = strings.ToLower(strings.TrimSuffix(a.Raw(), "."))
+	}
+	if _, ok := tsigAlgorithms[ts.TSIGAlgorithm]; !ok {
+		return fmt.Errorf("invalid 'tsig_algorithm' parameter %q: must be hmac-sha1, hmac-sha256 or hmac-sha512", ts.TSIGAlgorithm)
+	}
+	if (ts.TSIGKey == "") != ts.TSIGSecret.IsEmpty() {
+		return errors.New("the 'tsig_key' and 'tsig_secret' parameters must be specified together")
+	}
+	if ts.TSIGKey != "" {
+		if err := validateName(ts.TSIGKey); err != nil {
+			return fmt.Errorf("invalid 'tsig_key' parameter: %v", err)
+		}
+		if err := secrets.Validate(ts.TSIGSecret.Raw()); err != nil {
+			return fmt.Errorf("invalid 'tsig_secret' parameter: %v", err)
+		}
+	}
+
+	ts.Resolvers = nil
+	seen := make(map[string]bool)
+	for _, p := range params.Get("resolver") {
+		resolver, err := serverParam("resolver", p.Raw())
+		if err != nil {
+			return err
+		}
+		if seen[resolver] {
+			return fmt.Errorf("invalid 'resolver' parameter: %s specified more than once", resolver)
+		}
+		seen[resolver] = true
+		ts.Resolvers = append(ts.Resolvers, resolver)
+	}
+	if len(ts.Resolvers) == 0 {
+		ts.Resolvers = []string{ts.Server}
+	}
+
+	if ts.RequestTimeout, err = teststeps.DurationParam(params, "request_timeout", defaultRequestTimeout); err != nil {
+		return err
+	}
+	if ts.PropagationTimeout, err = teststeps.DurationParam(params, "propagation_timeout", defaultPropagationTimeout); err != nil {
+		return err
+	}
+	if ts.PollInterval, err = teststeps.DurationParam(params, "poll_interval", defaultPollInterval); err != nil {
+		return err
+	}
+	for name, d := range map[string]time.Duration{"request_timeout": ts.RequestTimeout, "propagation_timeout": ts.PropagationTimeout, "poll_interval": ts.PollInterval} {
+		if d <= 0 {
+			return fmt.Errorf("invalid '%s' parameter: must be positive", name)
+		}
+	}
+	if ts.PollInterval > ts.PropagationTimeout {
+		return errors.New("invalid 'poll_interval' parameter: must not exceed 'propagation_timeout'")
+	}
+	if ts.Timeouts, err = teststeps.NewNetworkTimeouts(params); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *DNSUpdate) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. DNSUpdate cannot
+// resume.
+func (ts *DNSUpdate) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *DNSUpdate) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new DNSUpdate test step.
+func New() test.TestStep {
+	return &DNSUpdate{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
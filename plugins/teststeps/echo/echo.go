@@ -6,10 +6,10 @@
 package echo
 
 import (
+	"context"
 	"errors"
 	"strings"
 
-	"github.com/facebookincubator/contest/pkg/cerrors"
 	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/logging"
@@ -53,7 +53,8 @@ func (e Step) Name() string {
 }
 
 // Run executes the step
-func (e Step) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+func (e Step) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
 	for {
 		select {
 		case target := <-ch.In:
@@ -71,13 +72,18 @@ func (e Step) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, param
 	}
 }
 
+// DryRun runs the step normally, as echoing has no side effects.
+func (e Step) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return e.Run(ctx, pause, ch, params, ev)
+}
+
 // CanResume tells whether this step is able to resume.
 func (e Step) CanResume() bool {
-	return false
+	return true
 }
 
-// Resume tries to resume a previously interrupted test step. EchoStep cannot
-// resume.
-func (e Step) Resume(cancel, pause <-chan struct{}, _ test.TestStepChannels, _ test.TestStepParameters, ev testevent.EmitterFetcher) error {
-	return &cerrors.ErrResumeNotSupported{StepName: Name}
+// Resume resumes an interrupted step. Echoing has no side effects, so the
+// targets are simply echoed again.
+func (e Step) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return e.Run(ctx, pause, ch, params, ev)
 }
@@ -0,0 +1,336 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package envcheck
+
+// The EnvCheck plugin reads the environment of a process on each target over
+// SSH, and verifies that the required variables are present with the expected
+// values. Values of variables that look like secrets are redacted in the
+// emitted events.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "EnvCheck"
+
+var log = logging.GetLogger("teststeps/" + strings.ToLower(Name))
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventEnvMatch    = event.Name("TargetEnvMatch")
+	EventEnvMismatch = event.Name("TargetEnvMismatch")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventEnvMatch, EventEnvMismatch}
+
+const (
+	defaultPID = "1"
+	// redactedValue replaces the values of secret-looking variables.
+	redactedValue = "<redacted>"
+)
+
+// defaultSecretPattern matches the names of variables whose values should
+// not be reported in events.
+var defaultSecretPattern = `(?i)(secret|token|passw(or)?d|credential|api_?key|private_?key|auth)`
+
+var varNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// requirement is a parsed entry of the 'require' parameter. The accepted
+// forms are NAME (presence only), NAME=value (exact match) and NAME=~regex
+// (regular expression match on the value).
+type requirement struct {
+	Name  string
+	Value string
+	Regex *regexp.Regexp
+	// Exact is true if the value must match exactly.
+	Exact bool
+}
+
+func parseRequirement(spec string) (*requirement, error) {
+	spec = strings.TrimSpace(spec)
+	name, value := spec, ""
+	hasValue := false
+	if idx := strings.Index(spec, "="); idx >= 0 {
+		name, value, hasValue = spec[:idx], spec[idx+1:], true
+	}
+	if !varNameRe.MatchString(name) {
+		return nil, fmt.Errorf("invalid variable name %q in requirement %q", name, spec)
+	}
+	r := requirement{Name: name}
+	if !hasValue {
+		return &r, nil
+	}
+	if strings.HasPrefix(value, "~") {
+		re, err := regexp.Compile(value[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression in requirement %q: %v", spec, err)
+		}
+		r.Regex = re
+		return &r, nil
+	}
+	r.Value = value
+	r.Exact = true
+	return &r, nil
+}
+
+// String returns the expected condition in a human-readable form.
+func (r *requirement) String() string {
+	switch {
+	case r.Regex != nil:
+		return "matches " + r.Regex.String()
+	case r.Exact:
+		return "equals " + strconv.Quote(r.Value)
+	default:
+		return "present"
+	}
+}
+
+// check tells whether the requirement is satisfied by the given environment,
+// and if not, the reason why.
+func (r *requirement) check(env map[string]string) (string, bool) {
+	value, ok := env[r.Name]
+	if !ok {
+		return "missing", false
+	}
+	if r.Regex != nil && !r.Regex.MatchString(value) {
+		return "value does not match", false
+	}
+	if r.Exact && value != r.Value {
+		return "unexpected value", false
+	}
+	return "", true
+}
+
+// parseEnv parses an environment listing made of NAME=value entries
+// separated by NUL bytes, as found in /proc/<pid>/environ, or by newlines,
+// as printed by `env`.
+func parseEnv(data []byte) map[string]string {
+	sep := []byte{0}
+	if !bytes.Contains(data, sep) {
+		sep = []byte{'\n'}
+	}
+	env := make(map[string]string)
+	for _, entry := range bytes.Split(data, sep) {
+		kv := strings.SplitN(string(entry), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		env[kv[0]] = kv[1]
+	}
+	return env
+}
+
+// mismatch describes a requirement which is not satisfied. Found is redacted
+// for secret-looking variables.
+type mismatch struct {
+	Name     string
+	Expected string
+	Found    string `json:",omitempty"`
+	Reason   string
+}
+
+// envEventPayload is the payload of the events emitted by this step.
+type envEventPayload struct {
+	Command    string
+	Checked    int
+	Mismatches []mismatch `json:",omitempty"`
+}
+
+// EnvCheck verifies the environment of a process on the targets.
+type EnvCheck struct {
+	*teststeps.SSHParams
+	PID           *test.Param
+	Command       *test.Param
+	Require       []test.Param
+	SecretPattern *regexp.Regexp
+}
+
+// Name returns the plugin name.
+func (ts EnvCheck) Name() string {
+	return Name
+}
+
+// redact hides the value of the variables whose name looks like a secret.
+func (ts *EnvCheck) redact(name, value string) string {
+	if ts.SecretPattern.MatchString(name) {
+		return redactedValue
+	}
+	return value
+}
+
+func (ts *EnvCheck) command(target *target.Target) (string, error) {
+	if !ts.Command.IsEmpty() {
+		cmd, err := ts.Command.Expand(target)
+		if err != nil {
+			return "", fmt.Errorf("cannot expand command parameter: %v", err)
+		}
+		return cmd, nil
+	}
+	pid, err := ts.PID.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand pid parameter: %v", err)
+	}
+	if _, err := strconv.ParseUint(pid, 10, 32); err != nil {
+		return "", fmt.Errorf("invalid pid %q: %v", pid, err)
+	}
+	return "cat /proc/" + pid + "/environ", nil
+}
+
+// Run executes the environment check step.
+func (ts *EnvCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		var reqs []*requirement
+		for _, p := range ts.Require {
+			spec, err := p.Expand(target)
+			if err != nil {
+				return fmt.Errorf("cannot expand require parameter: %v", err)
+			}
+			r, err := parseRequirement(spec)
+			if err != nil {
+				return err
+			}
+			reqs = append(reqs, r)
+		}
+		cmd, err := ts.command(target)
+		if err != nil {
+			return err
+		}
+		result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+		if err != nil {
+			return err
+		}
+		if result.ExitStatus != 0 {
+			return fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+		}
+		env := parseEnv(result.Stdout)
+
+		payload := envEventPayload{Command: cmd, Checked: len(reqs)}
+		for _, r := range reqs {
+			reason, ok := r.check(env)
+			if ok {
+				continue
+			}
+			m := mismatch{Name: r.Name, Expected: r.String(), Reason: reason}
+			if r.Exact {
+				m.Expected = "equals " + strconv.Quote(ts.redact(r.Name, r.Value))
+			}
+			if value, found := env[r.Name]; found {
+				m.Found = ts.redact(r.Name, value)
+			}
+			payload.Mismatches = append(payload.Mismatches, m)
+		}
+		if len(payload.Mismatches) == 0 {
+			log.Debugf("Environment of target %s satisfies %d requirements", target, len(reqs))
+			return teststeps.EmitTargetEvent(ev, EventEnvMatch, target, payload)
+		}
+		if err := teststeps.EmitTargetEvent(ev, EventEnvMismatch, target, payload); err != nil {
+			return err
+		}
+		names := make([]string, 0, len(payload.Mismatches))
+		for _, m := range payload.Mismatches {
+			names = append(names, m.Name)
+		}
+		return fmt.Errorf("environment mismatch on variables: %s", strings.Join(names, ", "))
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as it only reads the process
+// environment.
+func (ts *EnvCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *EnvCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.SSHParams, err = teststeps.NewSSHParams(params)
+	if err != nil {
+		return err
+	}
+	ts.PID = params.GetOne("pid")
+	ts.Command = params.GetOne("command")
+	if !ts.PID.IsEmpty() && !ts.Command.IsEmpty() {
+		return errors.New("parameters 'pid' and 'command' are mutually exclusive")
+	}
+	if ts.PID.IsEmpty() {
+		ts.PID = test.NewParam(defaultPID)
+	}
+	ts.Require = params.Get("require")
+	if len(ts.Require) == 0 {
+		return errors.New("missing 'require' parameter, at least one variable must be specified")
+	}
+	seen := make(map[string]bool)
+	for _, p := range ts.Require {
+		// requirements containing template expressions can only be fully
+		// validated after expansion, at run time.
+		if strings.Contains(p.Raw(), "{{") {
+			continue
+		}
+		r, err := parseRequirement(p.Raw())
+		if err != nil {
+			return fmt.Errorf("invalid 'require' parameter: %v", err)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("invalid 'require' parameter: variable %s specified more than once", r.Name)
+		}
+		seen[r.Name] = true
+	}
+	pattern := defaultSecretPattern
+	if sp := params.GetOne("secret_pattern"); !sp.IsEmpty() {
+		pattern = sp.Raw()
+	}
+	if ts.SecretPattern, err = regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("invalid 'secret_pattern' parameter: %v", err)
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *EnvCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. EnvCheck cannot
+// resume.
+func (ts *EnvCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *EnvCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new EnvCheck test step.
+func New() test.TestStep {
+	return &EnvCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
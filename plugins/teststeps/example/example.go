@@ -6,6 +6,7 @@
 package example
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -48,7 +49,8 @@ func (ts Step) Name() string {
 }
 
 // Run executes the example step.
-func (ts *Step) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, _ test.TestStepParameters, ev testevent.Emitter) error {
+func (ts *Step) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, _ test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
 	for {
 
 		r := rand.Intn(3)
@@ -98,15 +100,15 @@ func (ts *Step) ValidateParameters(_ test.TestStepParameters) error {
 	return nil
 }
 
-// Resume tries to resume a previously interrupted test step. ExampleTestStep
-// cannot resume.
-func (ts *Step) Resume(cancel, pause <-chan struct{}, ch test.TestStepChannels, _ test.TestStepParameters, ev testevent.EmitterFetcher) error {
-	return &cerrors.ErrResumeNotSupported{StepName: Name}
+// Resume resumes an interrupted step by running it again on the targets it was
+// resumed with.
+func (ts *Step) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return ts.Run(ctx, pause, ch, params, ev)
 }
 
 // CanResume tells whether this step is able to resume.
 func (ts *Step) CanResume() bool {
-	return false
+	return true
 }
 
 // New initializes and returns a new ExampleTestStep.
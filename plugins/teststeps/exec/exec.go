@@ -0,0 +1,307 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package exec
+
+// The Exec plugin runs a local command for each target. The executable, the
+// arguments, the environment variables and the working directory are Go
+// templates expanded on the target, e.g. "--host={{ .FQDN }}". The command
+// passes if it exits with the expected status (0 by default) within the
+// timeout, if any. Each target gets a TargetCommandSucceeded or
+// TargetCommandFailed event with the exit status and the captured output, so
+// that reporters can inspect them.
+//
+// Parameters:
+// * executable: the command to run, looked up in PATH if not absolute.
+// * args: the arguments of the command.
+// * env: environment variables as NAME=value, added to the environment of the
+//   server.
+// * dir: the working directory of the command.
+// * timeout: how long the command may run before it is killed, e.g. "5m".
+// * exit_code: the expected exit status.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "Exec"
+
+var log = logging.GetLogger("teststeps/" + strings.ToLower(Name))
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventCommandSucceeded = event.Name("TargetCommandSucceeded")
+	EventCommandFailed    = event.Name("TargetCommandFailed")
+)
+
+// Events is used by the framework to determine which events this plugin will
+// emit. Any emitted event that is not registered here will cause the plugin to
+// fail.
+var Events = []event.Name{EventCommandSucceeded, EventCommandFailed}
+
+// maxCapturedOutput bounds the stdout and stderr reported in the event
+// payloads. The whole output is streamed as live output events.
+const maxCapturedOutput = 4096
+
+// commandEventPayload is the payload of the events emitted by this step.
+type commandEventPayload struct {
+	Command string
+	Dir     string `json:",omitempty"`
+	// ExitStatus is -1 if the command did not start, or was killed.
+	ExitStatus int
+	Duration   string
+	Stdout     string `json:",omitempty"`
+	Stderr     string `json:",omitempty"`
+	// Truncated tells whether stdout or stderr were cut to maxCapturedOutput.
+	Truncated bool   `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+// captured returns an output as reported in the event payloads, and whether it
+// was truncated.
+func captured(output []byte) (string, bool) {
+	if len(output) > maxCapturedOutput {
+		return string(output[:maxCapturedOutput]), true
+	}
+	return string(output), false
+}
+
+// command is a command expanded for a target.
+type command struct {
+	executable string
+	args       []string
+	env        []string
+	dir        string
+}
+
+func (c command) String() string {
+	return shellquote.Join(append([]string{c.executable}, c.args...)...)
+}
+
+// Exec runs local commands as test steps.
+type Exec struct {
+	executable *test.Param
+	args       []test.Param
+	env        []test.Param
+	dir        *test.Param
+	timeout    time.Duration
+	exitCode   int
+}
+
+// Name returns the plugin name.
+func (ts Exec) Name() string {
+	return Name
+}
+
+// Run executes the command for each target.
+func (ts *Exec) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		cmd, err := ts.command(target)
+		if err != nil {
+			return err
+		}
+		payload := commandEventPayload{Command: cmd.String(), Dir: cmd.dir}
+		err = ts.run(cancel, pause, ev, target, cmd, &payload)
+		if err == teststeps.ErrInterrupted {
+			return err
+		}
+		if err != nil {
+			payload.Error = err.Error()
+			if emitErr := teststeps.EmitTargetEvent(ev, EventCommandFailed, target, payload); emitErr != nil {
+				log.Warningf("Could not emit event %s for target %s: %v", EventCommandFailed, target, emitErr)
+			}
+			return err
+		}
+		return teststeps.EmitTargetEvent(ev, EventCommandSucceeded, target, payload)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// run runs the command for a target, and fills the payload of the target
+// event with its outcome.
+func (ts *Exec) run(cancel, pause <-chan struct{}, ev testevent.Emitter, target *target.Target, cmd command, payload *commandEventPayload) error {
+	ctx, ctxCancel := teststeps.NewContext(cancel, pause)
+	defer ctxCancel()
+	if ts.timeout > 0 {
+		ctx, ctxCancel = context.WithTimeout(ctx, ts.timeout)
+		defer ctxCancel()
+	}
+	c := exec.CommandContext(ctx, cmd.executable, cmd.args...)
+	c.Dir = cmd.dir
+	if len(cmd.env) > 0 {
+		c.Env = append(os.Environ(), cmd.env...)
+	}
+	var stdout, stderr bytes.Buffer
+	output := teststeps.OpenLiveOutput(ev, target)
+	defer output.Close()
+	c.Stdout, c.Stderr = io.MultiWriter(&stdout, output), io.MultiWriter(&stderr, output)
+
+	log.Infof("Running command '%s' for target %s", cmd, target)
+	start := time.Now()
+	err := c.Run()
+	payload.Duration = time.Since(start).String()
+	var stdoutTruncated, stderrTruncated bool
+	payload.Stdout, stdoutTruncated = captured(stdout.Bytes())
+	payload.Stderr, stderrTruncated = captured(stderr.Bytes())
+	payload.Truncated = stdoutTruncated || stderrTruncated
+	payload.ExitStatus = -1
+	if c.ProcessState != nil {
+		payload.ExitStatus = c.ProcessState.ExitCode()
+	}
+	select {
+	case <-cancel:
+		return teststeps.ErrInterrupted
+	case <-pause:
+		return teststeps.ErrInterrupted
+	default:
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command '%s' timed out after %s", cmd, ts.timeout)
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return fmt.Errorf("could not run command '%s': %v", cmd, err)
+	}
+	if payload.ExitStatus != ts.exitCode {
+		log.Warningf("Stderr of command '%s' is '%s'", cmd, stderr.Bytes())
+		return fmt.Errorf("command '%s' exited with status %d, expected %d", cmd, payload.ExitStatus, ts.exitCode)
+	}
+	return nil
+}
+
+// command expands the command for a target.
+func (ts *Exec) command(target *target.Target) (command, error) {
+	var (
+		cmd command
+		err error
+	)
+	if cmd.executable, err = ts.executable.Expand(target); err != nil {
+		return cmd, fmt.Errorf("cannot expand executable parameter: %v", err)
+	}
+	if cmd.dir, err = ts.dir.Expand(target); err != nil {
+		return cmd, fmt.Errorf("cannot expand dir parameter: %v", err)
+	}
+	for _, arg := range ts.args {
+		earg, err := arg.Expand(target)
+		if err != nil {
+			return cmd, fmt.Errorf("cannot expand command argument '%s': %v", arg.Raw(), err)
+		}
+		cmd.args = append(cmd.args, earg)
+	}
+	for _, env := range ts.env {
+		eenv, err := env.Expand(target)
+		if err != nil {
+			return cmd, fmt.Errorf("cannot expand environment variable '%s': %v", env.Raw(), err)
+		}
+		cmd.env = append(cmd.env, eenv)
+	}
+	return cmd, nil
+}
+
+// DryRun logs the command that would be run for each target, without running
+// it.
+func (ts *Exec) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	describe := func(target *target.Target) (string, error) {
+		cmd, err := ts.command(target)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("run command '%s'", cmd), nil
+	}
+	return teststeps.DryRunEachTarget(Name, cancel, pause, ch, describe)
+}
+
+func (ts *Exec) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.executable = params.GetOne("executable")
+	if len(params.Get("executable")) != 1 || ts.executable.IsEmpty() {
+		return errors.New("invalid or missing 'executable' parameter, must be exactly one string")
+	}
+	ts.args = params.Get("args")
+	ts.env = params.Get("env")
+	for _, env := range ts.env {
+		if i := strings.Index(env.Raw(), "="); i <= 0 {
+			return fmt.Errorf("invalid 'env' parameter %q, must be NAME=value", env.Raw())
+		}
+	}
+	ts.dir = params.GetOne("dir")
+	if ts.timeout, err = teststeps.DurationParam(params, "timeout", 0); err != nil {
+		return err
+	}
+	ts.exitCode = 0
+	if p := params.GetOne("exit_code"); !p.IsEmpty() {
+		if ts.exitCode, err = strconv.Atoi(p.Raw()); err != nil || ts.exitCode < 0 || ts.exitCode > 255 {
+			return fmt.Errorf("invalid 'exit_code' parameter %q, must be an integer between 0 and 255", p.Raw())
+		}
+	}
+	return nil
+}
+
+// ParameterSchema declares the parameters of the step.
+func (ts *Exec) ParameterSchema() test.Schema {
+	return test.Schema{
+		Version: 1,
+		Parameters: []test.ParameterDefinition{
+			{Name: "executable", Type: test.TypeString, Required: true, Description: "the command to run, looked up in PATH if not absolute"},
+			{Name: "args", Type: test.TypeString, Repeated: true, Description: "the arguments of the command"},
+			{Name: "env", Type: test.TypeString, Repeated: true, Description: "environment variables of the command, as NAME=value"},
+			{Name: "dir", Type: test.TypeString, Description: "the working directory of the command"},
+			{Name: "timeout", Type: test.TypeDuration, Description: "how long the command may run before it is killed"},
+			{Name: "exit_code", Type: test.TypeInt, Default: "0", Description: "the expected exit status"},
+		},
+	}
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *Exec) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume resumes an interrupted step: the command runs again on the targets
+// it was interrupted for, hence it should be safe to run twice.
+func (ts *Exec) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *Exec) CanResume() bool {
+	return true
+}
+
+// New initializes and returns a new Exec test step.
+func New() test.TestStep {
+	return &Exec{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
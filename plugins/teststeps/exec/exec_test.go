@@ -0,0 +1,135 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func params(values map[string][]string) test.TestStepParameters {
+	params := make(test.TestStepParameters)
+	for name, list := range values {
+		for _, v := range list {
+			params[name] = append(params[name], *test.NewParam(v))
+		}
+	}
+	return params
+}
+
+func TestValidateParameters(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		params map[string][]string
+		valid  bool
+	}{
+		{"minimal", map[string][]string{"executable": {"true"}}, true},
+		{"complete", map[string][]string{
+			"executable": {"/bin/echo"},
+			"args":       {"--host={{ .FQDN }}", "-n"},
+			"env":        {"TARGET={{ .ID }}", "EMPTY="},
+			"dir":        {"/tmp"},
+			"timeout":    {"5m"},
+			"exit_code":  {"255"},
+		}, true},
+		{"missing executable", map[string][]string{"args": {"-n"}}, false},
+		{"empty executable", map[string][]string{"executable": {""}}, false},
+		{"several executables", map[string][]string{"executable": {"true", "false"}}, false},
+		{"env without value", map[string][]string{"executable": {"true"}, "env": {"TARGET"}}, false},
+		{"env without name", map[string][]string{"executable": {"true"}, "env": {"=value"}}, false},
+		{"invalid timeout", map[string][]string{"executable": {"true"}, "timeout": {"soon"}}, false},
+		{"negative timeout", map[string][]string{"executable": {"true"}, "timeout": {"-1s"}}, false},
+		{"invalid exit code", map[string][]string{"executable": {"true"}, "exit_code": {"one"}}, false},
+		{"negative exit code", map[string][]string{"executable": {"true"}, "exit_code": {"-1"}}, false},
+		{"exit code out of range", map[string][]string{"executable": {"true"}, "exit_code": {"256"}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := New().ValidateParameters(params(tc.params))
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestRunRoutesTargetsByExitStatus(t *testing.T) {
+	for _, name := range []string{"true", "false"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s is not available: %v", name, err)
+		}
+	}
+	storage.SetStorage(memory.New())
+	header := testevent.Header{JobID: "1", RunID: 1, TestName: "test", TestStepLabel: "exec"}
+	targets := []*target.Target{{Name: "pass1", ID: "1"}, {Name: "fail", ID: "2"}, {Name: "pass2", ID: "3"}}
+	var (
+		in    = make(chan *target.Target, len(targets))
+		out   = make(chan *target.Target, len(targets))
+		errCh = make(chan cerrors.TargetError, len(targets))
+	)
+	for _, tgt := range targets {
+		in <- tgt
+	}
+	close(in)
+	// the command of the target named fail exits with status 1
+	p := params(map[string][]string{"executable": {`{{ if eq .Name "fail" }}false{{ else }}true{{ end }}`}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, New().Run(ctx, make(chan struct{}), test.TestStepChannels{In: in, Out: out, Err: errCh}, p, storage.NewTestEventEmitter(header)))
+	close(out)
+	close(errCh)
+
+	var passed []string
+	for tgt := range out {
+		passed = append(passed, tgt.Name)
+	}
+	require.ElementsMatch(t, []string{"pass1", "pass2"}, passed)
+	var failed []cerrors.TargetError
+	for terr := range errCh {
+		failed = append(failed, terr)
+	}
+	require.Len(t, failed, 1)
+	require.Equal(t, "fail", failed[0].Target.Name)
+	require.EqualError(t, failed[0].Err, "command 'false' exited with status 1, expected 0")
+
+	// each target has the event of its outcome, with the exit status
+	events, err := storage.NewTestEventFetcher().Fetch(
+		testevent.QueryJobID(header.JobID),
+		testevent.QueryEventNames([]event.Name{EventCommandSucceeded, EventCommandFailed}),
+	)
+	require.NoError(t, err)
+	outcomes := make(map[string][]testevent.Event)
+	for _, ev := range events {
+		outcomes[ev.Data.Target.Name] = append(outcomes[ev.Data.Target.Name], ev)
+	}
+	for name, expected := range map[string]struct {
+		event      event.Name
+		exitStatus int
+	}{
+		"pass1": {EventCommandSucceeded, 0},
+		"fail":  {EventCommandFailed, 1},
+		"pass2": {EventCommandSucceeded, 0},
+	} {
+		require.Len(t, outcomes[name], 1, name)
+		require.Equal(t, expected.event, outcomes[name][0].Data.EventName, name)
+		var payload commandEventPayload
+		require.NoError(t, json.Unmarshal(*outcomes[name][0].Data.Payload, &payload))
+		require.Equal(t, expected.exitStatus, payload.ExitStatus, name)
+	}
+}
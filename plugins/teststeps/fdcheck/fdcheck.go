@@ -0,0 +1,361 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fdcheck
+
+// The FDCheck plugin counts the open file descriptors and the established TCP
+// connections of a process on each target over SSH, and fails the targets
+// where the counts exceed the configured thresholds. The process is given
+// either by PID or by name, in which case the oldest process with this exact
+// name is checked.
+//
+// Multiple samples can be taken over a short window, so that leaks are caught
+// by the growth of the counts between the first and the last sample even
+// before they exhaust the resources.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "FDCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventResourceOK         = event.Name("TargetResourceOK")
+	EventResourceExhaustion = event.Name("TargetResourceExhaustion")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventResourceOK, EventResourceExhaustion}
+
+const (
+	defaultInterval = 5 * time.Second
+	// maxWindow bounds the time spent sampling each target.
+	maxWindow = 10 * time.Minute
+
+	// markers separating the sections of the sampling output.
+	fdMarker  = "-- fd"
+	tcpMarker = "-- tcp"
+	// tcpEstablished is the state of the established connections in
+	// /proc/net/tcp.
+	tcpEstablished = "01"
+)
+
+var processRe = regexp.MustCompile(`^[A-Za-z0-9_.:@+-]+$`)
+
+// sampleScript prints the PID of the process, the targets of its file
+// descriptors, and the TCP sockets of its network namespace.
+const sampleScript = `ls /proc/$pid/fd >/dev/null 2>&1 || { echo "cannot read the file descriptors of process $pid" >&2; exit 2; }
+echo "pid $pid"
+echo "` + fdMarker + `"
+for f in /proc/$pid/fd/*; do readlink "$f"; done
+echo "` + tcpMarker + `"
+cat /proc/$pid/net/tcp /proc/$pid/net/tcp6 2>/dev/null
+exit 0`
+
+// sample holds the counts read at one point in time.
+type sample struct {
+	FDs         int
+	Connections int
+}
+
+// resourceEventPayload is the payload of the events emitted by this step.
+type resourceEventPayload struct {
+	PID              int
+	Samples          []sample
+	FDGrowth         int      `json:",omitempty"`
+	ConnectionGrowth int      `json:",omitempty"`
+	Violations       []string `json:",omitempty"`
+}
+
+// FDCheck verifies the resource usage of a process on the targets.
+type FDCheck struct {
+	*teststeps.SSHParams
+	PID     *test.Param
+	Process string
+	Sudo    bool
+	// thresholds, ignored if negative.
+	MaxFDs              int
+	MaxConnections      int
+	MaxFDGrowth         int
+	MaxConnectionGrowth int
+	Samples             int
+	Interval            time.Duration
+}
+
+// Name returns the plugin name.
+func (ts FDCheck) Name() string {
+	return Name
+}
+
+// parseSample parses the output of the sampling script.
+func parseSample(out []byte) (int, sample, error) {
+	var (
+		pid     = -1
+		s       sample
+		section string
+		sockets = make(map[string]bool)
+		tcp     []string
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == fdMarker || line == tcpMarker:
+			section = line
+		case section == "" && strings.HasPrefix(line, "pid "):
+			v, err := strconv.Atoi(strings.TrimPrefix(line, "pid "))
+			if err != nil {
+				return 0, s, fmt.Errorf("invalid PID line %q", line)
+			}
+			pid = v
+		case section == fdMarker && line != "":
+			s.FDs++
+			if strings.HasPrefix(line, "socket:[") && strings.HasSuffix(line, "]") {
+				sockets[line[len("socket:["):len(line)-1]] = true
+			}
+		case section == tcpMarker:
+			tcp = append(tcp, line)
+		}
+	}
+	if pid < 0 || section != tcpMarker {
+		return 0, s, errors.New("incomplete sample output")
+	}
+	// fields: sl local_address rem_address st tx_queue:rx_queue tr:tm->when
+	// retrnsmt uid timeout inode
+	for _, line := range tcp {
+		fields := strings.Fields(line)
+		if len(fields) < 10 || fields[0] == "sl" {
+			continue
+		}
+		if fields[3] == tcpEstablished && sockets[fields[9]] {
+			s.Connections++
+		}
+	}
+	return pid, s, nil
+}
+
+// command returns the sampling command for a target.
+func (ts *FDCheck) command(target *target.Target) (string, error) {
+	var script string
+	if ts.Process != "" {
+		script = fmt.Sprintf("pid=$(pgrep -o -x %s) || { echo \"process %s not found\" >&2; exit 3; }\n", shellquote.Join(ts.Process), ts.Process) + sampleScript
+	} else {
+		pid, err := ts.PID.Expand(target)
+		if err != nil {
+			return "", fmt.Errorf("cannot expand pid parameter: %v", err)
+		}
+		if _, err := strconv.ParseUint(pid, 10, 32); err != nil {
+			return "", fmt.Errorf("invalid pid %q: %v", pid, err)
+		}
+		script = "pid=" + pid + "\n" + sampleScript
+	}
+	if ts.Sudo {
+		return shellquote.Join("sudo", "-n", "sh", "-c", script), nil
+	}
+	return shellquote.Join("sh", "-c", script), nil
+}
+
+// violations returns the thresholds exceeded by the samples.
+func (ts *FDCheck) violations(payload *resourceEventPayload) []string {
+	var violations []string
+	peak := sample{}
+	for _, s := range payload.Samples {
+		if s.FDs > peak.FDs {
+			peak.FDs = s.FDs
+		}
+		if s.Connections > peak.Connections {
+			peak.Connections = s.Connections
+		}
+	}
+	if ts.MaxFDs >= 0 && peak.FDs > ts.MaxFDs {
+		violations = append(violations, fmt.Sprintf("%d open file descriptors, maximum is %d", peak.FDs, ts.MaxFDs))
+	}
+	if ts.MaxConnections >= 0 && peak.Connections > ts.MaxConnections {
+		violations = append(violations, fmt.Sprintf("%d established connections, maximum is %d", peak.Connections, ts.MaxConnections))
+	}
+	if ts.MaxFDGrowth >= 0 && payload.FDGrowth > ts.MaxFDGrowth {
+		violations = append(violations, fmt.Sprintf("open file descriptors grew by %d, maximum is %d", payload.FDGrowth, ts.MaxFDGrowth))
+	}
+	if ts.MaxConnectionGrowth >= 0 && payload.ConnectionGrowth > ts.MaxConnectionGrowth {
+		violations = append(violations, fmt.Sprintf("established connections grew by %d, maximum is %d", payload.ConnectionGrowth, ts.MaxConnectionGrowth))
+	}
+	return violations
+}
+
+func (ts *FDCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	cmd, err := ts.command(target)
+	if err != nil {
+		return err
+	}
+	payload := resourceEventPayload{PID: -1}
+	for i := 0; i < ts.Samples; i++ {
+		if i > 0 {
+			if err := teststeps.Sleep(cancel, pause, ts.Interval); err != nil {
+				return err
+			}
+		}
+		result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+		if err != nil {
+			return err
+		}
+		if result.ExitStatus != 0 {
+			return fmt.Errorf("sampling exited with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+		}
+		pid, s, err := parseSample(result.Stdout)
+		if err != nil {
+			return err
+		}
+		if payload.PID >= 0 && pid != payload.PID {
+			return fmt.Errorf("process restarted while sampling, PID changed from %d to %d", payload.PID, pid)
+		}
+		payload.PID = pid
+		payload.Samples = append(payload.Samples, s)
+	}
+	first, last := payload.Samples[0], payload.Samples[len(payload.Samples)-1]
+	payload.FDGrowth = last.FDs - first.FDs
+	payload.ConnectionGrowth = last.Connections - first.Connections
+	payload.Violations = ts.violations(&payload)
+	if len(payload.Violations) == 0 {
+		return teststeps.EmitTargetEvent(ev, EventResourceOK, target, payload)
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventResourceExhaustion, target, payload); err != nil {
+		return err
+	}
+	return fmt.Errorf("process %d exceeds resource thresholds: %s", payload.PID, strings.Join(payload.Violations, "; "))
+}
+
+// Run executes the file descriptor check step.
+func (ts *FDCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as it only reads from /proc.
+func (ts *FDCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// thresholdParam returns the value of a threshold parameter, or -1 if it is
+// not set.
+func thresholdParam(params test.TestStepParameters, name string) (int, error) {
+	if params.GetOne(name).IsEmpty() {
+		return -1, nil
+	}
+	v, err := params.GetInt(name)
+	if err != nil {
+		return 0, fmt.Errorf("invalid '%s' parameter: %v", name, err)
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("invalid '%s' parameter: must not be negative", name)
+	}
+	return int(v), nil
+}
+
+func (ts *FDCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	ts.PID = params.GetOne("pid")
+	ts.Process = params.GetOne("process").Raw()
+	switch {
+	case ts.PID.IsEmpty() && ts.Process == "":
+		return errors.New("one of the 'pid' and 'process' parameters is required")
+	case !ts.PID.IsEmpty() && ts.Process != "":
+		return errors.New("parameters 'pid' and 'process' are mutually exclusive")
+	case ts.Process != "" && !processRe.MatchString(ts.Process):
+		return fmt.Errorf("invalid 'process' parameter %q", ts.Process)
+	}
+	if ts.Sudo, err = teststeps.BoolParam(params, "sudo", false); err != nil {
+		return err
+	}
+	if ts.MaxFDs, err = thresholdParam(params, "max_fds"); err != nil {
+		return err
+	}
+	if ts.MaxConnections, err = thresholdParam(params, "max_connections"); err != nil {
+		return err
+	}
+	if ts.MaxFDGrowth, err = thresholdParam(params, "max_fd_growth"); err != nil {
+		return err
+	}
+	if ts.MaxConnectionGrowth, err = thresholdParam(params, "max_connection_growth"); err != nil {
+		return err
+	}
+	if ts.MaxFDs < 0 && ts.MaxConnections < 0 && ts.MaxFDGrowth < 0 && ts.MaxConnectionGrowth < 0 {
+		return errors.New("at least one of 'max_fds', 'max_connections', 'max_fd_growth' and 'max_connection_growth' must be specified")
+	}
+	ts.Samples = 1
+	if !params.GetOne("samples").IsEmpty() {
+		samples, err := params.GetInt("samples")
+		if err != nil || samples < 1 {
+			return fmt.Errorf("invalid 'samples' parameter %q, must be a positive integer", params.GetOne("samples").Raw())
+		}
+		ts.Samples = int(samples)
+	}
+	if ts.Interval, err = teststeps.DurationParam(params, "interval", defaultInterval); err != nil {
+		return err
+	}
+	if ts.Interval <= 0 {
+		return errors.New("invalid 'interval' parameter: must be positive")
+	}
+	if window := time.Duration(ts.Samples-1) * ts.Interval; window > maxWindow {
+		return fmt.Errorf("sampling window of %v exceeds the maximum of %v", window, maxWindow)
+	}
+	if ts.Samples < 2 && (ts.MaxFDGrowth >= 0 || ts.MaxConnectionGrowth >= 0) {
+		return errors.New("growth thresholds require at least 2 'samples'")
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *FDCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. FDCheck cannot
+// resume.
+func (ts *FDCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *FDCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new FDCheck test step.
+func New() test.TestStep {
+	return &FDCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
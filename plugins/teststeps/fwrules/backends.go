@@ -0,0 +1,491 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fwrules
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// The supported firewall backends.
+const (
+	BackendAuto      = "auto"
+	BackendIPTables  = "iptables"
+	BackendNFTables  = "nftables"
+	BackendFirewalld = "firewalld"
+)
+
+// backend reads the ruleset of a firewall backend, and normalizes it.
+type backend struct {
+	// command prints the ruleset.
+	command string
+	// parse parses the output of command, and returns the normalized rules
+	// and the number of rules which could not be normalized, e.g. negated
+	// matches.
+	parse func(out []byte) ([]rule, int, error)
+}
+
+var backends = map[string]backend{
+	BackendIPTables: {
+		command: "iptables-save -t filter && { ip6tables-save -t filter 2>/dev/null || true; }",
+		parse:   parseIPTables,
+	},
+	BackendNFTables: {
+		command: "nft -j list ruleset",
+		parse:   parseNFTables,
+	},
+	BackendFirewalld: {
+		command: `for z in $(firewall-cmd --get-active-zones | grep -v '^[[:space:]]'); do ` +
+			`echo "zone $z"; ` +
+			`for s in $(firewall-cmd --zone="$z" --list-sources); do echo "source $s"; done; ` +
+			`for p in $(firewall-cmd --zone="$z" --list-ports); do echo "port $p"; done; ` +
+			`for s in $(firewall-cmd --zone="$z" --list-services); do ` +
+			`firewall-cmd --info-service="$s" | sed -n 's/^[[:space:]]*ports:[[:space:]]*/port /p'; done; ` +
+			`firewall-cmd --zone="$z" --list-rich-rules | sed 's/^/rich /'; done`,
+		parse: parseFirewalld,
+	},
+}
+
+// backendHeader prefixes the output of the commands, to tell which backend
+// was detected.
+const backendHeader = "backend: "
+
+// command returns the command printing the ruleset of a backend, preceded by
+// the backend header. The auto backend picks firewalld if it is running, then
+// nftables if the nft tool is installed, then iptables.
+func command(name string) string {
+	run := func(name string) string {
+		return fmt.Sprintf("echo '%s%s'; %s", backendHeader, name, backends[name].command)
+	}
+	if name != BackendAuto {
+		return run(name)
+	}
+	return fmt.Sprintf("if firewall-cmd --state >/dev/null 2>&1; then %s; elif command -v nft >/dev/null 2>&1; then %s; else %s; fi",
+		run(BackendFirewalld), run(BackendNFTables), run(BackendIPTables))
+}
+
+// builder builds the normalized rules of a backend rule. Fields may have
+// several values, e.g. the ports of a multiport match, in which case one rule
+// is built per combination of values.
+type builder struct {
+	values map[string][]string
+	// invalid is set if a value could not be normalized.
+	invalid bool
+}
+
+func newBuilder() *builder {
+	return &builder{values: make(map[string][]string)}
+}
+
+// set normalizes and sets the values of a field.
+func (b *builder) set(field string, values ...string) {
+	var normalized []string
+	for _, v := range values {
+		n, err := normalize(field, v)
+		if err != nil {
+			b.invalid = true
+			return
+		}
+		normalized = append(normalized, n)
+	}
+	b.values[field] = normalized
+}
+
+// rules returns the rules built, or nil if a value is invalid.
+func (b *builder) rules() []rule {
+	if b.invalid {
+		return nil
+	}
+	rules := []rule{{}}
+	for field, values := range b.values {
+		var next []rule
+		for _, r := range rules {
+			for _, v := range values {
+				c := make(rule, len(r)+1)
+				for k, rv := range r {
+					c[k] = rv
+				}
+				c[field] = v
+				next = append(next, c)
+			}
+		}
+		rules = next
+	}
+	return rules
+}
+
+// parseIPTables parses the output of iptables-save for the filter table.
+func parseIPTables(out []byte) ([]rule, int, error) {
+	var (
+		rules   []rule
+		skipped int
+	)
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case strings.HasPrefix(line, ":"):
+			// chain policy, e.g. ":INPUT DROP [0:0]". User chains have none.
+			f := strings.Fields(line[1:])
+			if len(f) >= 2 && f[1] != "-" {
+				b := newBuilder()
+				b.set("chain", f[0])
+				b.set("policy", f[1])
+				rules = append(rules, b.rules()...)
+			}
+		case strings.HasPrefix(line, "-A "):
+			r, err := parseIPTablesRule(line)
+			if err != nil {
+				return nil, 0, err
+			}
+			if r == nil {
+				skipped++
+			}
+			rules = append(rules, r...)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, 0, err
+	}
+	return rules, skipped, nil
+}
+
+// parseIPTablesRule parses a rule of iptables-save. It returns nil for the
+// rules which cannot be normalized.
+func parseIPTablesRule(line string) ([]rule, error) {
+	args, err := shellquote.Split(line)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse iptables rule %q: %v", line, err)
+	}
+	b := newBuilder()
+	options := map[string]string{
+		"-A": "chain", "-i": "iif", "-o": "oif", "-p": "proto", "-s": "src", "-d": "dst",
+		"--sport": "sport", "--dport": "dport", "-j": "action",
+	}
+	for i := 0; i < len(args); i++ {
+		if args[i] == "!" {
+			return nil, nil
+		}
+		if i+1 >= len(args) {
+			break
+		}
+		value := args[i+1]
+		switch args[i] {
+		case "--sports", "--dports":
+			b.set(strings.TrimSuffix(args[i][2:], "s"), strings.Split(value, ",")...)
+		case "--ctstate", "--state":
+			b.set("state", value)
+		default:
+			field, ok := options[args[i]]
+			if !ok {
+				continue
+			}
+			b.set(field, value)
+		}
+		i++
+	}
+	return b.rules(), nil
+}
+
+// nftValues returns the values of the right-hand side of an nftables match:
+// a number, a string, a prefix, a range or a set of those.
+func nftValues(v interface{}) []string {
+	switch v := v.(type) {
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case string:
+		return []string{v}
+	case []interface{}:
+		// a list of flags, e.g. of connection states
+		var flags []string
+		for _, f := range v {
+			flags = append(flags, nftValues(f)...)
+		}
+		return []string{strings.Join(flags, ",")}
+	case map[string]interface{}:
+		if p, ok := v["prefix"].(map[string]interface{}); ok {
+			return []string{fmt.Sprintf("%v/%v", p["addr"], p["len"])}
+		}
+		if r, ok := v["range"].([]interface{}); ok && len(r) == 2 {
+			low, high := nftValues(r[0]), nftValues(r[1])
+			if len(low) == 1 && len(high) == 1 {
+				return []string{low[0] + "-" + high[0]}
+			}
+		}
+		if set, ok := v["set"].([]interface{}); ok {
+			var values []string
+			for _, e := range set {
+				values = append(values, nftValues(e)...)
+			}
+			return values
+		}
+	}
+	return nil
+}
+
+// nftField returns the rule field matched by the left-hand side of an
+// nftables match, and the protocol it implies, if any.
+func nftField(left map[string]interface{}) (string, string) {
+	if p, ok := left["payload"].(map[string]interface{}); ok {
+		proto, _ := p["protocol"].(string)
+		switch field, _ := p["field"].(string); {
+		case (proto == "tcp" || proto == "udp" || proto == "sctp") && (field == "sport" || field == "dport"):
+			return field, proto
+		case (proto == "ip" || proto == "ip6") && field == "saddr":
+			return "src", ""
+		case (proto == "ip" || proto == "ip6") && field == "daddr":
+			return "dst", ""
+		case (proto == "ip" && field == "protocol") || (proto == "ip6" && field == "nexthdr"):
+			return "proto", ""
+		}
+	}
+	if m, ok := left["meta"].(map[string]interface{}); ok {
+		switch m["key"] {
+		case "l4proto":
+			return "proto", ""
+		case "iifname":
+			return "iif", ""
+		case "oifname":
+			return "oif", ""
+		}
+	}
+	if ct, ok := left["ct"].(map[string]interface{}); ok && ct["key"] == "state" {
+		return "state", ""
+	}
+	return "", ""
+}
+
+// parseNFTables parses the JSON output of nft list ruleset. The base chains of
+// the filter type are named after their hook, the others keep their name.
+func parseNFTables(out []byte) ([]rule, int, error) {
+	var ruleset struct {
+		NFTables []struct {
+			Chain *struct {
+				Family string `json:"family"`
+				Table  string `json:"table"`
+				Name   string `json:"name"`
+				Type   string `json:"type"`
+				Hook   string `json:"hook"`
+				Policy string `json:"policy"`
+			} `json:"chain"`
+			Rule *struct {
+				Family string                   `json:"family"`
+				Table  string                   `json:"table"`
+				Chain  string                   `json:"chain"`
+				Expr   []map[string]interface{} `json:"expr"`
+			} `json:"rule"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(out, &ruleset); err != nil {
+		return nil, 0, fmt.Errorf("cannot decode nftables ruleset: %v", err)
+	}
+	var (
+		rules   []rule
+		skipped int
+	)
+	chains := make(map[[3]string]string)
+	for _, o := range ruleset.NFTables {
+		if c := o.Chain; c != nil {
+			name := c.Name
+			if c.Type == "filter" && c.Hook != "" {
+				name = c.Hook
+				if c.Policy != "" {
+					b := newBuilder()
+					b.set("chain", name)
+					b.set("policy", c.Policy)
+					rules = append(rules, b.rules()...)
+				}
+			}
+			chains[[3]string{c.Family, c.Table, c.Name}] = name
+		}
+	}
+	for _, o := range ruleset.NFTables {
+		r := o.Rule
+		if r == nil {
+			continue
+		}
+		b := newBuilder()
+		chain, ok := chains[[3]string{r.Family, r.Table, r.Chain}]
+		if !ok {
+			chain = r.Chain
+		}
+		b.set("chain", chain)
+		negated := false
+		for _, expr := range r.Expr {
+			if m, ok := expr["match"].(map[string]interface{}); ok {
+				if op, _ := m["op"].(string); op != "==" && op != "in" {
+					negated = true
+					break
+				}
+				left, _ := m["left"].(map[string]interface{})
+				field, proto := nftField(left)
+				if field == "" {
+					continue
+				}
+				if proto != "" {
+					b.set("proto", proto)
+				}
+				b.set(field, nftValues(m["right"])...)
+				continue
+			}
+			for _, verdict := range []string{"accept", "drop", "reject"} {
+				if _, ok := expr[verdict]; ok {
+					b.set("action", verdict)
+				}
+			}
+			for _, jump := range []string{"jump", "goto"} {
+				if j, ok := expr[jump].(map[string]interface{}); ok {
+					if target, ok := j["target"].(string); ok {
+						b.set("action", target)
+					}
+				}
+			}
+		}
+		built := b.rules()
+		if negated || built == nil {
+			skipped++
+			continue
+		}
+		rules = append(rules, built...)
+	}
+	return rules, skipped, nil
+}
+
+// parseFirewalld parses the zones printed by the firewalld command: the ports
+// open in a zone, directly or by its services, are accepted in the input
+// chain, from the sources of the zone if it has some.
+func parseFirewalld(out []byte) ([]rule, int, error) {
+	var (
+		rules   []rule
+		skipped int
+		sources []string
+	)
+	// withSources builds the rules of b, once per source of the zone
+	withSources := func(b *builder) []rule {
+		if _, ok := b.values["src"]; !ok && len(sources) > 0 {
+			b.values["src"] = sources
+		}
+		return b.rules()
+	}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		idx := strings.Index(line, " ")
+		if idx < 0 {
+			continue
+		}
+		kind, value := line[:idx], strings.TrimSpace(line[idx+1:])
+		switch kind {
+		case "zone":
+			sources = nil
+		case "source":
+			// sources which are not addresses, e.g. ipsets, are kept as is
+			if n, err := normalizeAddr(value); err == nil {
+				value = n
+			}
+			sources = append(sources, value)
+		case "port":
+			for _, port := range strings.Fields(value) {
+				b := newBuilder()
+				b.set("chain", "input")
+				parts := strings.SplitN(port, "/", 2)
+				if len(parts) != 2 {
+					skipped++
+					continue
+				}
+				b.set("proto", parts[1])
+				b.set("dport", parts[0])
+				b.set("action", "accept")
+				built := withSources(b)
+				if built == nil {
+					skipped++
+				}
+				rules = append(rules, built...)
+			}
+		case "rich":
+			b, err := parseRichRule(value)
+			if err != nil {
+				return nil, 0, err
+			}
+			var built []rule
+			if b != nil {
+				built = withSources(b)
+			}
+			if built == nil {
+				skipped++
+			}
+			rules = append(rules, built...)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, 0, err
+	}
+	return rules, skipped, nil
+}
+
+// parseRichRule parses a firewalld rich rule. It returns nil for the rules
+// which cannot be normalized, e.g. negated, service or forwarding rules.
+func parseRichRule(line string) (*builder, error) {
+	args, err := shellquote.Split(line)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse rich rule %q: %v", line, err)
+	}
+	b := newBuilder()
+	b.set("chain", "input")
+	// element is the element that the attributes which follow belong to
+	element := ""
+	for _, arg := range args {
+		idx := strings.Index(arg, "=")
+		if idx < 0 {
+			arg = strings.ToLower(arg)
+			switch arg {
+			case "not", "service", "forward-port", "icmp-block", "icmp-type", "masquerade":
+				return nil, nil
+			case "accept", "drop", "reject":
+				b.set("action", arg)
+			}
+			element = arg
+			continue
+		}
+		key, value := strings.ToLower(arg[:idx]), arg[idx+1:]
+		switch {
+		case element == "source" && key == "address":
+			b.set("src", value)
+		case element == "destination" && key == "address":
+			b.set("dst", value)
+		case (element == "port" || element == "source-port") && key == "port":
+			field := "dport"
+			if element == "source-port" {
+				field = "sport"
+			}
+			b.set(field, value)
+		case (element == "port" || element == "source-port") && key == "protocol":
+			b.set("proto", value)
+		case element == "protocol" && key == "value":
+			b.set("proto", value)
+		case element == "source" && (key == "mac" || key == "ipset"):
+			return nil, nil
+		}
+	}
+	return b, nil
+}
+
+// backendNames returns the names of the backends, including auto.
+func backendNames() []string {
+	names := []string{BackendAuto}
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
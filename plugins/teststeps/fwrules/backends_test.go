@@ -0,0 +1,229 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fwrules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// iptablesSave is the output of the iptables command of a host with an IPv4
+// and an IPv6 filter table.
+const iptablesSave = `# Generated by iptables-save v1.8.7 on Tue Mar  5 10:12:01 2024
+*filter
+:INPUT DROP [0:0]
+:FORWARD DROP [0:0]
+:OUTPUT ACCEPT [1024:65536]
+:SSH - [0:0]
+-A INPUT -i lo -j ACCEPT
+-A INPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+-A INPUT -m conntrack --ctstate INVALID -j DROP
+-A INPUT -p tcp -m tcp --dport 22 -j SSH
+-A INPUT -s 10.0.0.0/8 -p tcp -m multiport --dports 80,443,8000:8080 -j ACCEPT
+-A INPUT -p udp -m udp --sport 53 -j ACCEPT
+-A INPUT -p tcp -m tcp --dport 23 -j REJECT --reject-with tcp-reset
+-A INPUT ! -s 192.168.0.0/16 -p tcp -m tcp --dport 9100 -j REJECT --reject-with icmp-port-unreachable
+-A INPUT -p icmp -m limit --limit 5/sec -j ACCEPT
+-A INPUT -s 192.0.2.10/32 -p tcp -m comment --comment "allow monitoring host" -m tcp --dport 9090 -j ACCEPT
+-A FORWARD -m conntrack --ctstate DNAT -j ACCEPT
+-A SSH -s 203.0.113.0/24 -j ACCEPT
+-A SSH -j LOG --log-prefix "ssh denied: "
+-A SSH -j DROP
+COMMIT
+# Completed on Tue Mar  5 10:12:01 2024
+# Generated by ip6tables-save v1.8.7 on Tue Mar  5 10:12:01 2024
+*filter
+:INPUT DROP [0:0]
+:FORWARD DROP [0:0]
+:OUTPUT ACCEPT [0:0]
+-A INPUT -i lo -j ACCEPT
+-A INPUT -p ipv6-icmp -j ACCEPT
+-A INPUT -s 2001:db8::/32 -p tcp -m tcp --dport 22 -j ACCEPT
+COMMIT
+# Completed on Tue Mar  5 10:12:01 2024
+`
+
+var iptablesRules = []string{
+	"chain=input policy=drop",
+	"chain=forward policy=drop",
+	"chain=output policy=accept",
+	"chain=input iif=lo action=accept",
+	"chain=input state=established,related action=accept",
+	"chain=input state=invalid action=drop",
+	"chain=input proto=tcp dport=22 action=ssh",
+	"chain=input proto=tcp src=10.0.0.0/8 dport=80 action=accept",
+	"chain=input proto=tcp src=10.0.0.0/8 dport=443 action=accept",
+	"chain=input proto=tcp src=10.0.0.0/8 dport=8000-8080 action=accept",
+	"chain=input proto=udp sport=53 action=accept",
+	"chain=input proto=tcp dport=23 action=reject",
+	"chain=input proto=icmp action=accept",
+	"chain=input proto=tcp src=192.0.2.10/32 dport=9090 action=accept",
+	"chain=ssh src=203.0.113.0/24 action=accept",
+	"chain=ssh action=log",
+	"chain=ssh action=drop",
+	"chain=input policy=drop",
+	"chain=forward policy=drop",
+	"chain=output policy=accept",
+	"chain=input iif=lo action=accept",
+	"chain=input proto=ipv6-icmp action=accept",
+	"chain=input proto=tcp src=2001:db8::/32 dport=22 action=accept",
+}
+
+// nftRuleset is the output of nft -j list ruleset, with an inet filter table
+// and an ip nat table.
+const nftRuleset = `{"nftables": [
+{"metainfo": {"version": "1.0.2", "release_name": "Lester Gooch", "json_schema_version": 1}},
+{"table": {"family": "inet", "name": "filter", "handle": 1}},
+{"chain": {"family": "inet", "table": "filter", "name": "input", "handle": 1, "type": "filter", "hook": "input", "prio": 0, "policy": "drop"}},
+{"chain": {"family": "inet", "table": "filter", "name": "forward", "handle": 2, "type": "filter", "hook": "forward", "prio": 0, "policy": "drop"}},
+{"chain": {"family": "inet", "table": "filter", "name": "output", "handle": 3, "type": "filter", "hook": "output", "prio": 0, "policy": "accept"}},
+{"chain": {"family": "inet", "table": "filter", "name": "ssh", "handle": 4}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 5, "expr": [
+  {"match": {"op": "==", "left": {"meta": {"key": "iifname"}}, "right": "lo"}},
+  {"accept": null}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 6, "expr": [
+  {"match": {"op": "in", "left": {"ct": {"key": "state"}}, "right": ["established", "related"]}},
+  {"accept": null}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 7, "expr": [
+  {"match": {"op": "in", "left": {"ct": {"key": "state"}}, "right": "invalid"}},
+  {"drop": null}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 8, "expr": [
+  {"match": {"op": "==", "left": {"payload": {"protocol": "ip", "field": "saddr"}}, "right": {"prefix": {"addr": "10.0.0.0", "len": 8}}}},
+  {"match": {"op": "==", "left": {"payload": {"protocol": "tcp", "field": "dport"}}, "right": 22}},
+  {"counter": {"packets": 12, "bytes": 720}},
+  {"accept": null}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 9, "expr": [
+  {"match": {"op": "==", "left": {"payload": {"protocol": "tcp", "field": "dport"}}, "right": {"set": [80, 443, {"range": [8000, 8080]}]}}},
+  {"accept": null}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 10, "expr": [
+  {"match": {"op": "==", "left": {"meta": {"key": "l4proto"}}, "right": "ipv6-icmp"}},
+  {"accept": null}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 11, "expr": [
+  {"match": {"op": "!=", "left": {"payload": {"protocol": "ip", "field": "saddr"}}, "right": {"prefix": {"addr": "192.168.0.0", "len": 16}}}},
+  {"match": {"op": "==", "left": {"payload": {"protocol": "tcp", "field": "dport"}}, "right": 9100}},
+  {"reject": {"type": "tcp reset"}}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 12, "expr": [
+  {"match": {"op": "==", "left": {"payload": {"protocol": "ip", "field": "saddr"}}, "right": "@blocklist"}},
+  {"drop": null}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 13, "expr": [
+  {"match": {"op": "==", "left": {"payload": {"protocol": "tcp", "field": "dport"}}, "right": 2222}},
+  {"jump": {"target": "ssh"}}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 14, "expr": [
+  {"match": {"op": "==", "left": {"payload": {"protocol": "udp", "field": "dport"}}, "right": 53}},
+  {"limit": {"rate": 10, "burst": 5, "per": "second"}},
+  {"accept": null}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "ssh", "handle": 15, "expr": [
+  {"match": {"op": "==", "left": {"payload": {"protocol": "ip6", "field": "saddr"}}, "right": {"prefix": {"addr": "2001:db8::", "len": 32}}}},
+  {"accept": null}]}},
+{"table": {"family": "ip", "name": "nat", "handle": 2}},
+{"chain": {"family": "ip", "table": "nat", "name": "POSTROUTING", "handle": 1, "type": "nat", "hook": "postrouting", "prio": 100, "policy": "accept"}},
+{"rule": {"family": "ip", "table": "nat", "chain": "POSTROUTING", "handle": 2, "expr": [
+  {"match": {"op": "==", "left": {"meta": {"key": "oifname"}}, "right": "eth0"}},
+  {"masquerade": null}]}}
+]}`
+
+var nftRules = []string{
+	"chain=input policy=drop",
+	"chain=forward policy=drop",
+	"chain=output policy=accept",
+	"chain=input iif=lo action=accept",
+	"chain=input state=established,related action=accept",
+	"chain=input state=invalid action=drop",
+	"chain=input proto=tcp src=10.0.0.0/8 dport=22 action=accept",
+	"chain=input proto=tcp dport=80 action=accept",
+	"chain=input proto=tcp dport=443 action=accept",
+	"chain=input proto=tcp dport=8000-8080 action=accept",
+	"chain=input proto=ipv6-icmp action=accept",
+	"chain=input proto=tcp dport=2222 action=ssh",
+	"chain=input proto=udp dport=53 action=accept",
+	"chain=ssh src=2001:db8::/32 action=accept",
+	"chain=postrouting oif=eth0",
+}
+
+// firewalldZones is the output of the firewalld command for a public zone and
+// an internal zone restricted to its sources.
+const firewalldZones = `zone public
+port 8080/tcp
+port 60000-61000/udp
+port 22/tcp
+port 80/tcp 443/tcp
+port
+rich rule family="ipv4" source address="192.0.2.0/24" port port="9100" protocol="tcp" accept
+rich rule family="ipv4" source NOT address="198.51.100.1" drop
+rich rule family="ipv4" service name="ftp" accept
+zone internal
+source 10.0.0.0/8
+source ipset:trusted
+port 5432/tcp
+rich rule protocol value="icmp" accept
+`
+
+var firewalldRules = []string{
+	"chain=input proto=tcp dport=8080 action=accept",
+	"chain=input proto=udp dport=60000-61000 action=accept",
+	"chain=input proto=tcp dport=22 action=accept",
+	"chain=input proto=tcp dport=80 action=accept",
+	"chain=input proto=tcp dport=443 action=accept",
+	"chain=input proto=tcp src=192.0.2.0/24 dport=9100 action=accept",
+	"chain=input proto=tcp src=10.0.0.0/8 dport=5432 action=accept",
+	"chain=input proto=tcp src=ipset:trusted dport=5432 action=accept",
+	"chain=input proto=icmp src=10.0.0.0/8 action=accept",
+	"chain=input proto=icmp src=ipset:trusted action=accept",
+}
+
+func ruleStrings(rules []rule) []string {
+	var list []string
+	for _, r := range rules {
+		list = append(list, r.String())
+	}
+	return list
+}
+
+func TestParseBackends(t *testing.T) {
+	for _, tc := range []struct {
+		backend string
+		out     string
+		rules   []string
+		skipped int
+	}{
+		// the negated rule and the one with an unknown state
+		{BackendIPTables, iptablesSave, iptablesRules, 2},
+		// the negated rule and the one matching a named set
+		{BackendNFTables, nftRuleset, nftRules, 2},
+		// the negated and service rich rules
+		{BackendFirewalld, firewalldZones, firewalldRules, 2},
+	} {
+		t.Run(tc.backend, func(t *testing.T) {
+			backend, rules, skipped, err := parseRuleset([]byte(backendHeader + tc.backend + "\n" + tc.out))
+			require.NoError(t, err)
+			require.Equal(t, tc.backend, backend)
+			require.Equal(t, tc.rules, ruleStrings(rules))
+			require.Equal(t, tc.skipped, skipped)
+		})
+	}
+}
+
+func TestParseRulesetErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		out  string
+		err  string
+	}{
+		{"no header", iptablesSave, "cannot tell the firewall backend"},
+		{"header only", "backend: iptables", "cannot tell the firewall backend"},
+		{"unknown backend", "backend: pf\n", `unknown firewall backend "pf"`},
+		{"invalid nftables ruleset", "backend: nftables\nError: syntax error\n", "cannot decode nftables ruleset"},
+		{"unbalanced iptables quote", "backend: iptables\n-A INPUT -m comment --comment \"open -j ACCEPT\n", "cannot parse iptables rule"},
+		{"unbalanced rich rule quote", "backend: firewalld\nzone public\nrich rule source address=\"10.0.0.0/8 accept\n", "cannot parse rich rule"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, _, err := parseRuleset([]byte(tc.out))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fwrules
+
+// The FWRules plugin reads the firewall ruleset of each target over SSH, from
+// iptables, nftables or firewalld, and verifies that the required rules are
+// present and the forbidden ones absent. The rules are normalized across the
+// backends, and specified as field=value pairs, e.g.
+// "chain=input proto=tcp dport=22 action=accept" or "chain=input policy=drop".
+// A rule matches a specification if it has all its fields: a forbidden
+// "chain=input action=accept" matches any rule accepting incoming traffic.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "FWRules"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventFirewallOK       = event.Name("TargetFirewallOK")
+	EventFirewallMismatch = event.Name("TargetFirewallMismatch")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventFirewallOK, EventFirewallMismatch}
+
+// fwRulesEventPayload is the payload of the events emitted by this step.
+type fwRulesEventPayload struct {
+	Backend string
+	// Rules is the number of normalized rules, and Skipped the number of
+	// rules which could not be normalized, e.g. negated ones.
+	Rules   int
+	Skipped int
+	// Missing are the required rules which are not present.
+	Missing []string `json:",omitempty"`
+	// Forbidden are the forbidden rules which are present, with the rules
+	// matching them.
+	Forbidden map[string][]string `json:",omitempty"`
+}
+
+// FWRules verifies the firewall rules of the targets.
+type FWRules struct {
+	*teststeps.SSHParams
+	Backend   string
+	Required  []*spec
+	Forbidden []*spec
+}
+
+// Name returns the plugin name.
+func (ts FWRules) Name() string {
+	return Name
+}
+
+// parseRuleset parses the output of command, and returns the backend which
+// printed it and its normalized rules.
+func parseRuleset(out []byte) (string, []rule, int, error) {
+	idx := bytes.IndexByte(out, '\n')
+	if idx < 0 || !bytes.HasPrefix(out, []byte(backendHeader)) {
+		return "", nil, 0, errors.New("cannot tell the firewall backend")
+	}
+	name := strings.TrimSpace(string(out[len(backendHeader):idx]))
+	b, ok := backends[name]
+	if !ok {
+		return "", nil, 0, fmt.Errorf("unknown firewall backend %q", name)
+	}
+	rules, skipped, err := b.parse(out[idx+1:])
+	if err != nil {
+		return "", nil, 0, err
+	}
+	return name, rules, skipped, nil
+}
+
+// compare fills the payload with the required rules which are missing and the
+// forbidden ones which are present.
+func (ts *FWRules) compare(rules []rule, payload *fwRulesEventPayload) {
+	for _, s := range ts.Required {
+		found := false
+		for _, r := range rules {
+			if s.matches(r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			payload.Missing = append(payload.Missing, s.raw)
+		}
+	}
+	for _, s := range ts.Forbidden {
+		for _, r := range rules {
+			if s.matches(r) {
+				if payload.Forbidden == nil {
+					payload.Forbidden = make(map[string][]string)
+				}
+				payload.Forbidden[s.raw] = append(payload.Forbidden[s.raw], r.String())
+			}
+		}
+	}
+}
+
+func (ts *FWRules) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	result, err := ts.RunSSHCommand(cancel, pause, target, command(ts.Backend))
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("cannot read the firewall ruleset, exit status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	backend, rules, skipped, err := parseRuleset(result.Stdout)
+	if err != nil {
+		return err
+	}
+	payload := fwRulesEventPayload{Backend: backend, Rules: len(rules), Skipped: skipped}
+	ts.compare(rules, &payload)
+	if len(payload.Missing) > 0 || len(payload.Forbidden) > 0 {
+		if err := teststeps.EmitTargetEvent(ev, EventFirewallMismatch, target, payload); err != nil {
+			return err
+		}
+		var problems []string
+		if len(payload.Missing) > 0 {
+			problems = append(problems, fmt.Sprintf("missing rules: %s", strings.Join(payload.Missing, "; ")))
+		}
+		for _, s := range ts.Forbidden {
+			if matching, ok := payload.Forbidden[s.raw]; ok {
+				problems = append(problems, fmt.Sprintf("forbidden rule %q matched by: %s", s.raw, strings.Join(matching, "; ")))
+			}
+		}
+		return fmt.Errorf("%s firewall mismatch: %s", backend, strings.Join(problems, ", "))
+	}
+	return teststeps.EmitTargetEvent(ev, EventFirewallOK, target, payload)
+}
+
+// Run executes the firewall rules check step.
+func (ts *FWRules) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as it only reads the ruleset.
+func (ts *FWRules) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// specs parses the rule specifications of a parameter.
+func specs(params test.TestStepParameters, name string) ([]*spec, error) {
+	var specs []*spec
+	for _, p := range params.Get(name) {
+		s, err := parseSpec(p.Raw())
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s' rule %q: %v", name, p.Raw(), err)
+		}
+		specs = append(specs, s)
+	}
+	return specs, nil
+}
+
+func (ts *FWRules) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.SSHParams, err = teststeps.NewSSHParams(params)
+	if err != nil {
+		return err
+	}
+	ts.Backend = strings.ToLower(strings.TrimSpace(params.GetOne("backend").Raw()))
+	if ts.Backend == "" {
+		ts.Backend = BackendAuto
+	}
+	if _, ok := backends[ts.Backend]; !ok && ts.Backend != BackendAuto {
+		return fmt.Errorf("invalid 'backend' parameter %q, must be one of %s", ts.Backend, strings.Join(backendNames(), ", "))
+	}
+	if ts.Required, err = specs(params, "required"); err != nil {
+		return err
+	}
+	if ts.Forbidden, err = specs(params, "forbidden"); err != nil {
+		return err
+	}
+	if len(ts.Required) == 0 && len(ts.Forbidden) == 0 {
+		return errors.New("at least one 'required' or 'forbidden' rule must be specified")
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *FWRules) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. FWRules cannot
+// resume.
+func (ts *FWRules) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *FWRules) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new FWRules test step.
+func New() test.TestStep {
+	return &FWRules{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
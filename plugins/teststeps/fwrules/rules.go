@@ -0,0 +1,201 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fwrules
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The fields of the normalized rules, in the order in which they are printed.
+var fields = []string{"chain", "policy", "iif", "oif", "proto", "src", "dst", "sport", "dport", "state", "action"}
+
+var (
+	nameRe   = regexp.MustCompile(`^[a-z][a-z0-9_.-]*$`)
+	ifaceRe  = regexp.MustCompile(`^[A-Za-z0-9_.:@+-]+$`)
+	actions  = map[string]bool{"accept": true, "drop": true, "reject": true}
+	policies = map[string]bool{"accept": true, "drop": true}
+	states   = map[string]bool{"new": true, "established": true, "related": true, "invalid": true, "untracked": true}
+)
+
+// rule is a firewall rule normalized across the backends. The chains of the
+// filter table are named after the netfilter hooks: input, output and
+// forward. Addresses are CIDR prefixes, ports single ports or a-b ranges, and
+// states sorted lists of connection tracking states. A chain policy is a rule
+// with the chain and policy fields only. The matches which have no field, e.g.
+// rate limits, are left out.
+type rule map[string]string
+
+func (r rule) String() string {
+	var parts []string
+	for _, f := range fields {
+		if v, ok := r[f]; ok {
+			parts = append(parts, f+"="+v)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// spec is a rule specification: a rule matches it if it has all its fields,
+// with the same values. Specs without a policy field only match rules, and
+// specs with one only match chain policies.
+type spec struct {
+	raw    string
+	fields rule
+}
+
+// matches tells whether a rule matches the spec.
+func (s *spec) matches(r rule) bool {
+	if _, ok := s.fields["policy"]; ok != (r["policy"] != "") {
+		return false
+	}
+	for f, v := range s.fields {
+		if r[f] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeAddr returns an address or address prefix as a CIDR prefix.
+func normalizeAddr(s string) (string, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return "", fmt.Errorf("invalid address %q", s)
+		}
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid address prefix %q", s)
+	}
+	return n.String(), nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// normalizePorts returns a port, or a port range separated by - or :, in the
+// a-b form. Ranges of a single port are a port.
+func normalizePorts(s string) (string, error) {
+	bounds := strings.FieldsFunc(s, func(r rune) bool { return r == '-' || r == ':' })
+	if len(bounds) == 0 || len(bounds) > 2 {
+		return "", fmt.Errorf("invalid port range %q", s)
+	}
+	low, err := parsePort(bounds[0])
+	if err != nil {
+		return "", err
+	}
+	if len(bounds) == 1 {
+		return strconv.Itoa(low), nil
+	}
+	high, err := parsePort(bounds[1])
+	if err != nil {
+		return "", err
+	}
+	if high < low {
+		return "", fmt.Errorf("invalid port range %q", s)
+	}
+	if high == low {
+		return strconv.Itoa(low), nil
+	}
+	return fmt.Sprintf("%d-%d", low, high), nil
+}
+
+// normalizeStates returns a comma-separated list of connection tracking
+// states, lowercased and sorted.
+func normalizeStates(s string) (string, error) {
+	list := strings.Split(strings.ToLower(s), ",")
+	for _, state := range list {
+		if !states[state] {
+			return "", fmt.Errorf("invalid connection state %q", state)
+		}
+	}
+	sort.Strings(list)
+	return strings.Join(list, ","), nil
+}
+
+// normalize returns the normalized value of a rule field.
+func normalize(field, value string) (string, error) {
+	switch field {
+	case "src", "dst":
+		return normalizeAddr(value)
+	case "sport", "dport":
+		return normalizePorts(value)
+	case "state":
+		return normalizeStates(value)
+	case "iif", "oif":
+		if !ifaceRe.MatchString(value) {
+			return "", fmt.Errorf("invalid interface %q", value)
+		}
+		return value, nil
+	}
+	value = strings.ToLower(value)
+	if !nameRe.MatchString(value) {
+		return "", fmt.Errorf("invalid %s %q", field, value)
+	}
+	return value, nil
+}
+
+// parseSpec parses a rule specification made of space-separated
+// field=value pairs, e.g. "chain=input proto=tcp dport=22 action=accept".
+func parseSpec(raw string) (*spec, error) {
+	s := spec{raw: raw, fields: make(rule)}
+	for _, pair := range strings.Fields(raw) {
+		idx := strings.Index(pair, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid rule field %q, must be field=value", pair)
+		}
+		field, value := pair[:idx], pair[idx+1:]
+		known := false
+		for _, f := range fields {
+			known = known || f == field
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown rule field %q, must be one of %s", field, strings.Join(fields, ", "))
+		}
+		if _, ok := s.fields[field]; ok {
+			return nil, fmt.Errorf("duplicate rule field %q", field)
+		}
+		v, err := normalize(field, value)
+		if err != nil {
+			return nil, err
+		}
+		s.fields[field] = v
+	}
+	if len(s.fields) == 0 {
+		return nil, errors.New("empty rule")
+	}
+	if a, ok := s.fields["action"]; ok && !actions[a] {
+		return nil, fmt.Errorf("invalid action %q, must be accept, drop or reject", a)
+	}
+	if p, ok := s.fields["policy"]; ok {
+		if !policies[p] {
+			return nil, fmt.Errorf("invalid policy %q, must be accept or drop", p)
+		}
+		if _, ok := s.fields["chain"]; !ok || len(s.fields) != 2 {
+			return nil, errors.New("policy rules must only have the chain and policy fields")
+		}
+	}
+	if _, ok := s.fields["proto"]; !ok && (s.fields["sport"] != "" || s.fields["dport"] != "") {
+		return nil, errors.New("rules with ports must have a proto field")
+	}
+	return &s, nil
+}
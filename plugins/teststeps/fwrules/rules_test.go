@@ -0,0 +1,138 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fwrules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpec(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		rule string
+		err  string
+	}{
+		{raw: "chain=INPUT proto=TCP dport=22 action=ACCEPT", rule: "chain=input proto=tcp dport=22 action=accept"},
+		{raw: "chain=input policy=DROP", rule: "chain=input policy=drop"},
+		{raw: "src=10.1.2.3/8", rule: "src=10.0.0.0/8"},
+		{raw: "dst=2001:DB8::1", rule: "dst=2001:db8::1/128"},
+		{raw: "src=192.0.2.1", rule: "src=192.0.2.1/32"},
+		{raw: "proto=tcp dport=8000:8080", rule: "proto=tcp dport=8000-8080"},
+		{raw: "proto=udp sport=53-53", rule: "proto=udp sport=53"},
+		{raw: "state=NEW,established", rule: "state=established,new"},
+		{raw: "iif=eth0.100 oif=wg+", rule: "iif=eth0.100 oif=wg+"},
+		{raw: "", err: "empty rule"},
+		{raw: "chain", err: `invalid rule field "chain", must be field=value`},
+		{raw: "=input", err: `invalid rule field "=input", must be field=value`},
+		{raw: "table=filter", err: `unknown rule field "table"`},
+		{raw: "chain=input chain=output", err: `duplicate rule field "chain"`},
+		{raw: "chain=1input", err: `invalid chain "1input"`},
+		{raw: "action=log", err: `invalid action "log", must be accept, drop or reject`},
+		{raw: "chain=input policy=reject", err: `invalid policy "reject", must be accept or drop`},
+		{raw: "policy=drop", err: "policy rules must only have the chain and policy fields"},
+		{raw: "chain=input policy=drop action=accept", err: "policy rules must only have the chain and policy fields"},
+		{raw: "dport=22", err: "rules with ports must have a proto field"},
+		{raw: "proto=tcp dport=70000", err: `invalid port "70000"`},
+		{raw: "proto=tcp dport=8080-8000", err: `invalid port range "8080-8000"`},
+		{raw: "proto=tcp dport=1-2-3", err: `invalid port range "1-2-3"`},
+		{raw: "src=10.0.0.256", err: `invalid address "10.0.0.256"`},
+		{raw: "src=10.0.0.0/33", err: `invalid address prefix "10.0.0.0/33"`},
+		{raw: "state=established,bogus", err: `invalid connection state "bogus"`},
+		{raw: "iif=eth0/1", err: `invalid interface "eth0/1"`},
+	} {
+		t.Run(tc.raw, func(t *testing.T) {
+			s, err := parseSpec(tc.raw)
+			if tc.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.rule, s.fields.String())
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		backend   string
+		out       string
+		required  []string
+		forbidden []string
+		missing   []string
+		matched   map[string][]string
+	}{
+		{
+			name:     "iptables required rules present",
+			backend:  BackendIPTables,
+			out:      iptablesSave,
+			required: []string{"chain=input policy=drop", "chain=input proto=tcp dport=443 action=accept", "chain=ssh action=drop", "chain=input state=related,established"},
+		},
+		{
+			name:     "iptables required rules missing",
+			backend:  BackendIPTables,
+			out:      iptablesSave,
+			required: []string{"chain=input proto=tcp dport=8443 action=accept", "chain=forward policy=accept", "chain=input proto=tcp dport=9100 action=reject"},
+			missing:  []string{"chain=input proto=tcp dport=8443 action=accept", "chain=forward policy=accept", "chain=input proto=tcp dport=9100 action=reject"},
+		},
+		{
+			name:      "iptables forbidden rules",
+			backend:   BackendIPTables,
+			out:       iptablesSave,
+			forbidden: []string{"chain=input proto=tcp dport=23", "chain=output policy=accept", "chain=forward", "chain=input src=0.0.0.0/0 action=accept"},
+			matched: map[string][]string{
+				"chain=input proto=tcp dport=23": {"chain=input proto=tcp dport=23 action=reject"},
+				"chain=output policy=accept":     {"chain=output policy=accept", "chain=output policy=accept"},
+			},
+		},
+		{
+			name:      "nftables",
+			backend:   BackendNFTables,
+			out:       nftRuleset,
+			required:  []string{"chain=input policy=drop", "chain=input proto=tcp dport=22 action=accept", "chain=input proto=tcp dport=9100 action=reject"},
+			forbidden: []string{"chain=input proto=tcp dport=8000-8080", "chain=input action=drop src=0.0.0.0/0", "chain=postrouting oif=eth0"},
+			missing:   []string{"chain=input proto=tcp dport=9100 action=reject"},
+			matched: map[string][]string{
+				"chain=input proto=tcp dport=8000-8080": {"chain=input proto=tcp dport=8000-8080 action=accept"},
+				"chain=postrouting oif=eth0":            {"chain=postrouting oif=eth0"},
+			},
+		},
+		{
+			name:      "firewalld",
+			backend:   BackendFirewalld,
+			out:       firewalldZones,
+			required:  []string{"chain=input proto=tcp src=10.0.0.0/8 dport=5432 action=accept", "chain=input policy=drop"},
+			forbidden: []string{"chain=input proto=tcp dport=5432 src=0.0.0.0/0", "chain=input proto=icmp"},
+			missing:   []string{"chain=input policy=drop"},
+			matched: map[string][]string{
+				"chain=input proto=icmp": {"chain=input proto=icmp src=10.0.0.0/8 action=accept", "chain=input proto=icmp src=ipset:trusted action=accept"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var ts FWRules
+			for _, raw := range tc.required {
+				s, err := parseSpec(raw)
+				require.NoError(t, err)
+				ts.Required = append(ts.Required, s)
+			}
+			for _, raw := range tc.forbidden {
+				s, err := parseSpec(raw)
+				require.NoError(t, err)
+				ts.Forbidden = append(ts.Forbidden, s)
+			}
+			_, rules, _, err := parseRuleset([]byte(backendHeader + tc.backend + "\n" + tc.out))
+			require.NoError(t, err)
+			var payload fwRulesEventPayload
+			ts.compare(rules, &payload)
+			require.Equal(t, tc.missing, payload.Missing)
+			require.Equal(t, tc.matched, payload.Forbidden)
+		})
+	}
+}
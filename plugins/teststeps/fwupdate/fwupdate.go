@@ -0,0 +1,349 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fwupdate
+
+// The FwUpdate plugin applies a firmware image to each target over SSH,
+// reboots the target and verifies that the expected firmware version is
+// running. If the verification fails, the prior firmware is restored via the
+// configured rollback command.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "FwUpdate"
+
+var log = logging.GetLogger("teststeps/" + strings.ToLower(Name))
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventFwUpdateStarted  = event.Name("TargetFwUpdateStarted")
+	EventFwUpdated        = event.Name("TargetFwUpdated")
+	EventFwRolledBack     = event.Name("TargetFwRolledBack")
+	EventFwRollbackFailed = event.Name("TargetFwRollbackFailed")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventFwUpdateStarted, EventFwUpdated, EventFwRolledBack, EventFwRollbackFailed}
+
+const (
+	defaultVersionCommand = "cat /sys/class/dmi/id/bios_version"
+	defaultUpdateCommand  = "fwupdtool install"
+	defaultRebootCommand  = "reboot"
+	defaultRebootTimeout  = 30 * time.Minute
+	defaultPollInterval   = 30 * time.Second
+)
+
+// imageSchemes are the URL schemes accepted for firmware images which are not
+// paths on the target.
+var imageSchemes = map[string]bool{"http": true, "https": true, "tftp": true, "ftp": true}
+
+// fwEventPayload is the payload of the events emitted by this step.
+type fwEventPayload struct {
+	Image           string
+	PriorVersion    string
+	ExpectedVersion string
+	FoundVersion    string `json:",omitempty"`
+	Error           string `json:",omitempty"`
+}
+
+// FwUpdate applies firmware images to targets and verifies the outcome.
+type FwUpdate struct {
+	*teststeps.SSHParams
+	Image           *test.Param
+	ExpectedVersion *test.Param
+	VersionCommand  *test.Param
+	UpdateCommand   *test.Param
+	RollbackCommand *test.Param
+	RebootCommand   *test.Param
+	RebootTimeout   time.Duration
+	PollInterval    time.Duration
+}
+
+// Name returns the plugin name.
+func (ts FwUpdate) Name() string {
+	return Name
+}
+
+// runCommand runs a command on the target and fails on non-zero exit status.
+func (ts *FwUpdate) runCommand(cancel, pause <-chan struct{}, target *target.Target, cmd string) ([]byte, error) {
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitStatus != 0 {
+		return nil, fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	return result.Stdout, nil
+}
+
+func (ts *FwUpdate) version(cancel, pause <-chan struct{}, target *target.Target) (string, error) {
+	cmd, err := ts.VersionCommand.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand version_command parameter: %v", err)
+	}
+	out, err := ts.runCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return "", fmt.Errorf("cannot read firmware version: %w", err)
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// reboot restarts the target and waits until it's back online.
+func (ts *FwUpdate) reboot(cancel, pause <-chan struct{}, target *target.Target) error {
+	bootID, err := ts.BootID(cancel, pause, target)
+	if err != nil {
+		return err
+	}
+	cmd, err := ts.RebootCommand.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand reboot_command parameter: %v", err)
+	}
+	log.Infof("Rebooting target %s", target)
+	// the connection is likely to be dropped by the reboot, so errors other
+	// than interruptions are not fatal here. Whether the reboot happened is
+	// determined by the boot ID.
+	if _, err := ts.RunSSHCommand(cancel, pause, target, cmd); errors.Is(err, teststeps.ErrInterrupted) {
+		return err
+	}
+	return ts.WaitForReboot(cancel, pause, target, bootID, ts.RebootTimeout, ts.PollInterval)
+}
+
+func (ts *FwUpdate) rollback(cancel, pause <-chan struct{}, target *target.Target, payload *fwEventPayload, ev testevent.Emitter) error {
+	cmd, err := ts.RollbackCommand.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand rollback_command parameter: %v", err)
+	}
+	log.Warningf("Rolling back firmware on target %s to version %s", target, payload.PriorVersion)
+	err = func() error {
+		if _, err := ts.runCommand(cancel, pause, target, cmd); err != nil {
+			return err
+		}
+		if err := ts.reboot(cancel, pause, target); err != nil {
+			return err
+		}
+		restored, err := ts.version(cancel, pause, target)
+		if err != nil {
+			return err
+		}
+		if restored != payload.PriorVersion {
+			return fmt.Errorf("firmware version after rollback is %q, expected %q", restored, payload.PriorVersion)
+		}
+		return nil
+	}()
+	if errors.Is(err, teststeps.ErrInterrupted) {
+		return err
+	}
+	if err != nil {
+		payload.Error = err.Error()
+		if emitErr := teststeps.EmitTargetEvent(ev, EventFwRollbackFailed, target, payload); emitErr != nil {
+			log.Warningf("%v", emitErr)
+		}
+		return fmt.Errorf("firmware rollback failed: %v", err)
+	}
+	return teststeps.EmitTargetEvent(ev, EventFwRolledBack, target, payload)
+}
+
+// Run executes the firmware update step.
+func (ts *FwUpdate) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		image, err := ts.Image.Expand(target)
+		if err != nil {
+			return fmt.Errorf("cannot expand image parameter: %v", err)
+		}
+		expected, err := ts.ExpectedVersion.Expand(target)
+		if err != nil {
+			return fmt.Errorf("cannot expand expected_version parameter: %v", err)
+		}
+		updateCmd, err := ts.UpdateCommand.Expand(target)
+		if err != nil {
+			return fmt.Errorf("cannot expand update_command parameter: %v", err)
+		}
+		prior, err := ts.version(cancel, pause, target)
+		if err != nil {
+			return err
+		}
+		payload := fwEventPayload{Image: image, PriorVersion: prior, ExpectedVersion: expected}
+		if prior == expected {
+			log.Infof("Target %s already runs firmware version %s", target, expected)
+			payload.FoundVersion = prior
+			return teststeps.EmitTargetEvent(ev, EventFwUpdated, target, payload)
+		}
+
+		if err := teststeps.EmitTargetEvent(ev, EventFwUpdateStarted, target, payload); err != nil {
+			return err
+		}
+		cmd := updateCmd + " " + shellquote.Join(image)
+		log.Infof("Applying firmware %s on target %s", image, target)
+		if _, err := ts.runCommand(cancel, pause, target, cmd); err != nil {
+			if errors.Is(err, teststeps.ErrInterrupted) {
+				return err
+			}
+			// the update may have partially applied, restore the prior version
+			if rbErr := ts.rollback(cancel, pause, target, &payload, ev); rbErr != nil {
+				return fmt.Errorf("firmware update failed: %v (%v)", err, rbErr)
+			}
+			return fmt.Errorf("firmware update failed and was rolled back: %v", err)
+		}
+		if err := ts.reboot(cancel, pause, target); err != nil {
+			return err
+		}
+		found, err := ts.version(cancel, pause, target)
+		if errors.Is(err, teststeps.ErrInterrupted) {
+			return err
+		}
+		if err == nil && found == expected {
+			payload.FoundVersion = found
+			return teststeps.EmitTargetEvent(ev, EventFwUpdated, target, payload)
+		}
+		if err != nil {
+			payload.Error = err.Error()
+		} else {
+			payload.FoundVersion = found
+			err = fmt.Errorf("firmware version is %q, expected %q", found, expected)
+		}
+		if rbErr := ts.rollback(cancel, pause, target, &payload, ev); rbErr != nil {
+			return fmt.Errorf("firmware verification failed: %v (%v)", err, rbErr)
+		}
+		return fmt.Errorf("firmware verification failed and was rolled back: %v", err)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun logs the firmware update that would be applied to each target,
+// without connecting to the targets.
+func (ts *FwUpdate) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	describe := func(target *target.Target) (string, error) {
+		image, err := ts.Image.Expand(target)
+		if err != nil {
+			return "", fmt.Errorf("cannot expand image parameter: %v", err)
+		}
+		expected, err := ts.ExpectedVersion.Expand(target)
+		if err != nil {
+			return "", fmt.Errorf("cannot expand expected_version parameter: %v", err)
+		}
+		updateCmd, err := ts.UpdateCommand.Expand(target)
+		if err != nil {
+			return "", fmt.Errorf("cannot expand update_command parameter: %v", err)
+		}
+		return fmt.Sprintf("apply firmware with '%s %s', reboot, and expect version %q", updateCmd, shellquote.Join(image), expected), nil
+	}
+	return teststeps.DryRunEachTarget(Name, cancel, pause, ch, describe)
+}
+
+// validateImage checks that the image is an absolute path or a URL with a
+// supported scheme. Images containing template expressions can only be
+// checked after expansion, at run time.
+func validateImage(image string) error {
+	if strings.Contains(image, "{{") {
+		return nil
+	}
+	if path.IsAbs(image) {
+		return nil
+	}
+	u, err := url.Parse(image)
+	if err != nil {
+		return fmt.Errorf("invalid 'image' parameter: %v", err)
+	}
+	if !imageSchemes[u.Scheme] || u.Host == "" {
+		return fmt.Errorf("invalid 'image' parameter %q: must be an absolute path or a http, https, tftp or ftp URL", image)
+	}
+	return nil
+}
+
+func (ts *FwUpdate) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.SSHParams, err = teststeps.NewSSHParams(params)
+	if err != nil {
+		return err
+	}
+	ts.Image = params.GetOne("image")
+	if ts.Image.IsEmpty() {
+		return errors.New("invalid or missing 'image' parameter, must be exactly one string")
+	}
+	if err := validateImage(ts.Image.Raw()); err != nil {
+		return err
+	}
+	ts.ExpectedVersion = params.GetOne("expected_version")
+	if ts.ExpectedVersion.IsEmpty() || strings.TrimSpace(ts.ExpectedVersion.Raw()) == "" {
+		return errors.New("invalid or missing 'expected_version' parameter, must be exactly one string")
+	}
+	ts.RollbackCommand = params.GetOne("rollback_command")
+	if ts.RollbackCommand.IsEmpty() {
+		return errors.New("invalid or missing 'rollback_command' parameter, must be exactly one string")
+	}
+	ts.VersionCommand = params.GetOne("version_command")
+	if ts.VersionCommand.IsEmpty() {
+		ts.VersionCommand = test.NewParam(defaultVersionCommand)
+	}
+	ts.UpdateCommand = params.GetOne("update_command")
+	if ts.UpdateCommand.IsEmpty() {
+		ts.UpdateCommand = test.NewParam(defaultUpdateCommand)
+	}
+	ts.RebootCommand = params.GetOne("reboot_command")
+	if ts.RebootCommand.IsEmpty() {
+		ts.RebootCommand = test.NewParam(defaultRebootCommand)
+	}
+	if ts.RebootTimeout, err = teststeps.DurationParam(params, "reboot_timeout", defaultRebootTimeout); err != nil {
+		return err
+	}
+	if ts.PollInterval, err = teststeps.DurationParam(params, "poll_interval", defaultPollInterval); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *FwUpdate) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. FwUpdate cannot
+// resume.
+func (ts *FwUpdate) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *FwUpdate) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new FwUpdate test step.
+func New() test.TestStep {
+	return &FwUpdate{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
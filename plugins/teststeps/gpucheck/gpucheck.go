@@ -0,0 +1,250 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package gpucheck
+
+// The GPUCheck plugin queries the GPUs of each target over SSH with
+// nvidia-smi, and fails the targets which have fewer GPUs than required, or
+// GPUs which are unhealthy: too little memory, uncorrected ECC errors, or a
+// temperature above a threshold.
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "GPUCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventGpuHealthy   = event.Name("TargetGpuHealthy")
+	EventGpuUnhealthy = event.Name("TargetGpuUnhealthy")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventGpuHealthy, EventGpuUnhealthy}
+
+// defaultCommand prints one CSV line per GPU with the fields parsed by
+// parseGPUs, in this order.
+const defaultCommand = "nvidia-smi --query-gpu=index,name,uuid,memory.total,ecc.errors.uncorrected.volatile.total,temperature.gpu --format=csv,noheader,nounits"
+
+// gpu is the state of a GPU as reported by the command. Values which are not
+// available on a GPU are reported as -1.
+type gpu struct {
+	Index        string
+	Name         string
+	UUID         string
+	MemoryMB     int64
+	ECCErrors    int64
+	TemperatureC int64
+}
+
+// gpuEventPayload is the payload of the events emitted by this step.
+type gpuEventPayload struct {
+	Count    int
+	MinCount int
+	GPUs     []gpu
+	Problems []string `json:",omitempty"`
+}
+
+// GPUCheck verifies the availability and health of the targets' GPUs.
+type GPUCheck struct {
+	*teststeps.SSHParams
+	Command        *test.Param
+	MinCount       int
+	MinMemoryMB    int64
+	MaxECCErrors   int64
+	MaxTemperature int64
+}
+
+// Name returns the plugin name.
+func (ts GPUCheck) Name() string {
+	return Name
+}
+
+// parseValue parses a numeric field, returning -1 if it is not available.
+func parseValue(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.HasPrefix(s, "[") || strings.EqualFold(s, "N/A") {
+		// nvidia-smi reports "[N/A]" or "[Not Supported]"
+		return -1, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// parseGPUs parses the CSV output of the command.
+func parseGPUs(out []byte) ([]gpu, error) {
+	r := csv.NewReader(bytes.NewReader(out))
+	r.TrimLeadingSpace = true
+	r.FieldsPerRecord = 6
+	var gpus []gpu
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return gpus, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse GPU list: %v", err)
+		}
+		g := gpu{Index: rec[0], Name: rec[1], UUID: rec[2]}
+		for i, v := range []*int64{&g.MemoryMB, &g.ECCErrors, &g.TemperatureC} {
+			if *v, err = parseValue(rec[3+i]); err != nil {
+				return nil, fmt.Errorf("cannot parse GPU %s: invalid value %q", g.Index, rec[3+i])
+			}
+		}
+		gpus = append(gpus, g)
+	}
+}
+
+// problems returns the health problems of a GPU.
+func (ts *GPUCheck) problems(g gpu) []string {
+	var p []string
+	if ts.MinMemoryMB > 0 && g.MemoryMB >= 0 && g.MemoryMB < ts.MinMemoryMB {
+		p = append(p, fmt.Sprintf("GPU %s has %d MB of memory, expected at least %d MB", g.Index, g.MemoryMB, ts.MinMemoryMB))
+	}
+	if ts.MaxECCErrors >= 0 && g.ECCErrors > ts.MaxECCErrors {
+		p = append(p, fmt.Sprintf("GPU %s has %d uncorrected ECC errors, expected at most %d", g.Index, g.ECCErrors, ts.MaxECCErrors))
+	}
+	if ts.MaxTemperature > 0 && g.TemperatureC > ts.MaxTemperature {
+		p = append(p, fmt.Sprintf("GPU %s temperature is %d C, expected at most %d C", g.Index, g.TemperatureC, ts.MaxTemperature))
+	}
+	return p
+}
+
+func (ts *GPUCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	cmd, err := ts.Command.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand command parameter: %v", err)
+	}
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	payload := gpuEventPayload{MinCount: ts.MinCount}
+	if result.ExitStatus != 0 {
+		// nvidia-smi fails if the driver cannot communicate with the GPUs
+		payload.Problems = []string{fmt.Sprintf("command exited with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))}
+	} else {
+		if payload.GPUs, err = parseGPUs(result.Stdout); err != nil {
+			return err
+		}
+		payload.Count = len(payload.GPUs)
+		if payload.Count < ts.MinCount {
+			payload.Problems = append(payload.Problems, fmt.Sprintf("found %d GPUs, expected at least %d", payload.Count, ts.MinCount))
+		}
+		for _, g := range payload.GPUs {
+			payload.Problems = append(payload.Problems, ts.problems(g)...)
+		}
+	}
+	if len(payload.Problems) > 0 {
+		if err := teststeps.EmitTargetEvent(ev, EventGpuUnhealthy, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("unhealthy GPUs: %s", strings.Join(payload.Problems, "; "))
+	}
+	return teststeps.EmitTargetEvent(ev, EventGpuHealthy, target, payload)
+}
+
+// Run executes the GPU check step.
+func (ts *GPUCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as querying the GPUs has no side
+// effects.
+func (ts *GPUCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// intParam parses an optional integer parameter which must be at least min.
+func intParam(params test.TestStepParameters, name string, defaultValue, min int64) (int64, error) {
+	p := params.GetOne(name)
+	if p.IsEmpty() {
+		return defaultValue, nil
+	}
+	v, err := strconv.ParseInt(p.Raw(), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid '%s' parameter: %v", name, err)
+	}
+	if v < min {
+		return 0, fmt.Errorf("invalid '%s' parameter: must be at least %d", name, min)
+	}
+	return v, nil
+}
+
+func (ts *GPUCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.SSHParams, err = teststeps.NewSSHParams(params)
+	if err != nil {
+		return err
+	}
+	ts.Command = params.GetOne("command")
+	if ts.Command.IsEmpty() {
+		ts.Command = test.NewParam(defaultCommand)
+	}
+	minCount, err := intParam(params, "min_count", 1, 1)
+	if err != nil {
+		return err
+	}
+	ts.MinCount = int(minCount)
+	if ts.MinMemoryMB, err = intParam(params, "min_memory_mb", 0, 1); err != nil {
+		return err
+	}
+	// -1 disables the ECC check
+	if ts.MaxECCErrors, err = intParam(params, "max_ecc_errors", 0, -1); err != nil {
+		return err
+	}
+	if ts.MaxTemperature, err = intParam(params, "max_temperature", 0, 1); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *GPUCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. GPUCheck cannot
+// resume.
+func (ts *GPUCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *GPUCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new GPUCheck test step.
+func New() test.TestStep {
+	return &GPUCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
@@ -0,0 +1,438 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package gracefulrestart
+
+// The GracefulRestart plugin sends a graceful shutdown signal over SSH to an
+// application on each target, and waits for the application to be restarted,
+// either by its supervisor or by start_command, and to be healthy again. The
+// requests sent to drain_url right before the signal must complete, which
+// verifies that the application drains its in-flight requests.
+//
+// The downtime runs from the first failed health probe after the signal to
+// the first successful probe of the restarted application, whose PID differs,
+// so it is measured with the resolution of poll_interval. Targets whose
+// downtime exceeds max_downtime fail.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "GracefulRestart"
+
+var log = logging.GetLogger("teststeps/" + strings.ToLower(Name))
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventRestarted        = event.Name("TargetRestarted")
+	EventRestartFailed    = event.Name("TargetRestartFailed")
+	EventDowntimeExceeded = event.Name("TargetDowntimeExceeded")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventRestarted, EventRestartFailed, EventDowntimeExceeded}
+
+const (
+	defaultSignal         = "TERM"
+	defaultRestartTimeout = 5 * time.Minute
+	defaultPollInterval   = 500 * time.Millisecond
+	defaultDrainRequests  = 1
+	maxDrainRequests      = 100
+)
+
+// signals are the signals which can request a graceful shutdown.
+var signals = map[string]bool{"TERM": true, "INT": true, "QUIT": true, "HUP": true, "USR1": true, "USR2": true}
+
+var pidRe = regexp.MustCompile(`^[1-9][0-9]*$`)
+
+// restartEventPayload is the payload of the events emitted by this step.
+type restartEventPayload struct {
+	OldPID        string
+	NewPID        string `json:",omitempty"`
+	Downtime      string `json:",omitempty"`
+	MaxDowntime   string
+	DrainRequests int    `json:",omitempty"`
+	Drained       int    `json:",omitempty"`
+	Error         string `json:",omitempty"`
+}
+
+// GracefulRestart restarts an application on the targets and measures its
+// downtime.
+type GracefulRestart struct {
+	*teststeps.SSHParams
+	PIDCommand     *test.Param
+	Signal         string
+	StartCommand   *test.Param
+	HealthURL      *test.Param
+	HealthStatus   int
+	Insecure       bool
+	DrainURL       *test.Param
+	DrainRequests  int
+	MaxDowntime    time.Duration
+	RestartTimeout time.Duration
+	PollInterval   time.Duration
+}
+
+// Name returns the plugin name.
+func (ts GracefulRestart) Name() string {
+	return Name
+}
+
+// pid returns the PID of the application, as printed by pid_command.
+func (ts *GracefulRestart) pid(cancel, pause <-chan struct{}, target *target.Target) (string, error) {
+	cmd, err := ts.PIDCommand.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand pid_command parameter: %v", err)
+	}
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitStatus != 0 {
+		return "", fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	pid := string(bytes.TrimSpace(result.Stdout))
+	if !pidRe.MatchString(pid) {
+		return "", fmt.Errorf("the application is not running, pid_command printed %q", pid)
+	}
+	return pid, nil
+}
+
+// exited tells whether the process with the given PID has exited.
+func (ts *GracefulRestart) exited(cancel, pause <-chan struct{}, target *target.Target, pid string) (bool, error) {
+	result, err := ts.RunSSHCommand(cancel, pause, target, "kill -0 "+pid)
+	if err != nil {
+		return false, err
+	}
+	return result.ExitStatus != 0, nil
+}
+
+// start runs start_command, once the application has exited.
+func (ts *GracefulRestart) start(cancel, pause <-chan struct{}, target *target.Target) error {
+	cmd, err := ts.StartCommand.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand start_command parameter: %v", err)
+	}
+	log.Infof("Starting the application on target %s", target)
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	return nil
+}
+
+func (ts *GracefulRestart) healthy(ctx context.Context, client *http.Client, u string) error {
+	return get(ctx, client, u, func(status int) bool { return status == ts.HealthStatus })
+}
+
+// waitRestarted polls the application until it runs with a PID other than
+// oldPID and is healthy, and returns its downtime and new PID.
+func (ts *GracefulRestart) waitRestarted(ctx context.Context, cancel, pause <-chan struct{}, client *http.Client, target *target.Target, healthURL, oldPID string) (time.Duration, string, error) {
+	deadline := time.Now().Add(ts.RestartTimeout)
+	started := ts.StartCommand.IsEmpty()
+	var (
+		downSince time.Time
+		lastErr   error
+	)
+	for {
+		if !started {
+			exited, err := ts.exited(cancel, pause, target, oldPID)
+			if errors.Is(err, teststeps.ErrInterrupted) {
+				return 0, "", err
+			}
+			if err == nil && exited {
+				if err := ts.start(cancel, pause, target); err != nil {
+					return 0, "", err
+				}
+				started = true
+			}
+		}
+		lastErr = ts.healthy(ctx, client, healthURL)
+		now := time.Now()
+		if ctx.Err() != nil {
+			return 0, "", teststeps.ErrInterrupted
+		}
+		if lastErr != nil {
+			if downSince.IsZero() {
+				downSince = now
+			}
+		} else {
+			pid, err := ts.pid(cancel, pause, target)
+			if errors.Is(err, teststeps.ErrInterrupted) {
+				return 0, "", err
+			}
+			if err == nil && pid != oldPID {
+				var downtime time.Duration
+				if !downSince.IsZero() {
+					downtime = now.Sub(downSince)
+				}
+				return downtime, pid, nil
+			}
+			lastErr = err
+			if err == nil {
+				lastErr = fmt.Errorf("the application still runs with PID %s", pid)
+			}
+		}
+		if now.Add(ts.PollInterval).After(deadline) {
+			return 0, "", fmt.Errorf("the application did not restart and become healthy within %v: %v", ts.RestartTimeout, lastErr)
+		}
+		if err := teststeps.Sleep(cancel, pause, ts.PollInterval); err != nil {
+			return 0, "", err
+		}
+	}
+}
+
+// restart runs the graceful restart on a target, fills the payload with its
+// outcome and returns the downtime.
+func (ts *GracefulRestart) restart(cancel, pause <-chan struct{}, target *target.Target, payload *restartEventPayload) (time.Duration, error) {
+	ctx, ctxCancel := teststeps.NewContext(cancel, pause)
+	defer ctxCancel()
+	client := teststeps.NewHTTPClient(teststeps.HTTPClientOptions{Timeouts: ts.Timeouts, InsecureSkipVerify: ts.Insecure, DisableKeepAlives: true})
+
+	healthURL, err := ts.HealthURL.Expand(target)
+	if err != nil {
+		return 0, fmt.Errorf("cannot expand health_url parameter: %v", err)
+	}
+	if err := teststeps.ValidateURL(healthURL, "health_url"); err != nil {
+		return 0, err
+	}
+	if err := ts.healthy(ctx, client, healthURL); err != nil {
+		if ctx.Err() != nil {
+			return 0, teststeps.ErrInterrupted
+		}
+		return 0, fmt.Errorf("the application is not healthy before the restart: %v", err)
+	}
+	if payload.OldPID, err = ts.pid(cancel, pause, target); err != nil {
+		return 0, err
+	}
+
+	var d *drain
+	if !ts.DrainURL.IsEmpty() {
+		drainURL, err := ts.DrainURL.Expand(target)
+		if err != nil {
+			return 0, fmt.Errorf("cannot expand drain_url parameter: %v", err)
+		}
+		if err := teststeps.ValidateURL(drainURL, "drain_url"); err != nil {
+			return 0, err
+		}
+		drainCtx, drainCancel := context.WithTimeout(ctx, ts.RestartTimeout)
+		defer drainCancel()
+		if d, err = startDrain(drainCtx, client, drainURL, ts.DrainRequests); err != nil {
+			if ctx.Err() != nil {
+				return 0, teststeps.ErrInterrupted
+			}
+			return 0, fmt.Errorf("cannot send the in-flight requests: %v", err)
+		}
+		payload.DrainRequests = d.total
+	}
+
+	log.Infof("Sending SIG%s to the application on target %s, PID %s", ts.Signal, target, payload.OldPID)
+	cmd := fmt.Sprintf("kill -%s %s", ts.Signal, payload.OldPID)
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err == nil && result.ExitStatus != 0 {
+		err = fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	if err != nil {
+		if d != nil {
+			ctxCancel()
+			d.wait()
+		}
+		return 0, err
+	}
+
+	downtime, newPID, err := ts.waitRestarted(ctx, cancel, pause, client, target, healthURL, payload.OldPID)
+	var drainErrs string
+	if d != nil {
+		payload.Drained, drainErrs = d.wait()
+	}
+	if err != nil {
+		return 0, err
+	}
+	payload.NewPID = newPID
+	payload.Downtime = downtime.Round(time.Millisecond).String()
+	if drainErrs != "" {
+		return 0, fmt.Errorf("%d of %d in-flight requests were not drained: %s", d.total-payload.Drained, d.total, drainErrs)
+	}
+	return downtime, nil
+}
+
+func (ts *GracefulRestart) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	payload := restartEventPayload{MaxDowntime: ts.MaxDowntime.String()}
+	downtime, err := ts.restart(cancel, pause, target, &payload)
+	if errors.Is(err, teststeps.ErrInterrupted) {
+		return err
+	}
+	if err != nil {
+		payload.Error = err.Error()
+		if emitErr := teststeps.EmitTargetEvent(ev, EventRestartFailed, target, payload); emitErr != nil {
+			return emitErr
+		}
+		return err
+	}
+	if downtime > ts.MaxDowntime {
+		if err := teststeps.EmitTargetEvent(ev, EventDowntimeExceeded, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("downtime of %s exceeds the maximum of %s", payload.Downtime, payload.MaxDowntime)
+	}
+	return teststeps.EmitTargetEvent(ev, EventRestarted, target, payload)
+}
+
+// Run executes the graceful restart step.
+func (ts *GracefulRestart) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun logs the application restart that would be performed on each
+// target, without connecting to the targets.
+func (ts *GracefulRestart) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	describe := func(target *target.Target) (string, error) {
+		pidCmd, err := ts.PIDCommand.Expand(target)
+		if err != nil {
+			return "", fmt.Errorf("cannot expand pid_command parameter: %v", err)
+		}
+		healthURL, err := ts.HealthURL.Expand(target)
+		if err != nil {
+			return "", fmt.Errorf("cannot expand health_url parameter: %v", err)
+		}
+		return fmt.Sprintf("send SIG%s to the PID printed by '%s' and wait for %s to be healthy", ts.Signal, pidCmd, healthURL), nil
+	}
+	return teststeps.DryRunEachTarget(Name, cancel, pause, ch, describe)
+}
+
+func signalNames() []string {
+	var names []string
+	for s := range signals {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (ts *GracefulRestart) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.SSHParams, err = teststeps.NewSSHParams(params)
+	if err != nil {
+		return err
+	}
+	ts.PIDCommand = params.GetOne("pid_command")
+	if ts.PIDCommand.IsEmpty() {
+		return errors.New("invalid or missing 'pid_command' parameter, must be exactly one string")
+	}
+	ts.Signal = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(params.GetOne("signal").Raw())), "SIG")
+	if ts.Signal == "" {
+		ts.Signal = defaultSignal
+	}
+	if !signals[ts.Signal] {
+		return fmt.Errorf("invalid 'signal' parameter %q, must be one of %s", ts.Signal, strings.Join(signalNames(), ", "))
+	}
+	ts.StartCommand = params.GetOne("start_command")
+	ts.HealthURL = params.GetOne("health_url")
+	if err := teststeps.ValidateURLTemplate(ts.HealthURL, "health_url"); err != nil {
+		return err
+	}
+	ts.HealthStatus = http.StatusOK
+	if !params.GetOne("health_status").IsEmpty() {
+		status, err := params.GetInt("health_status")
+		if err != nil || status < 100 || status > 599 {
+			return fmt.Errorf("invalid 'health_status' parameter %q, must be a HTTP status code", params.GetOne("health_status").Raw())
+		}
+		ts.HealthStatus = int(status)
+	}
+	if ts.Insecure, err = teststeps.BoolParam(params, "insecure", false); err != nil {
+		return err
+	}
+	ts.DrainURL = params.GetOne("drain_url")
+	ts.DrainRequests = defaultDrainRequests
+	if !ts.DrainURL.IsEmpty() {
+		if err := teststeps.ValidateURLTemplate(ts.DrainURL, "drain_url"); err != nil {
+			return err
+		}
+		if !params.GetOne("drain_requests").IsEmpty() {
+			n, err := params.GetInt("drain_requests")
+			if err != nil || n < 1 || n > maxDrainRequests {
+				return fmt.Errorf("invalid 'drain_requests' parameter %q, must be between 1 and %d", params.GetOne("drain_requests").Raw(), maxDrainRequests)
+			}
+			ts.DrainRequests = int(n)
+		}
+	}
+	if params.GetOne("max_downtime").IsEmpty() {
+		return errors.New("missing 'max_downtime' parameter")
+	}
+	if ts.MaxDowntime, err = teststeps.DurationParam(params, "max_downtime", 0); err != nil {
+		return err
+	}
+	if ts.RestartTimeout, err = teststeps.DurationParam(params, "restart_timeout", defaultRestartTimeout); err != nil {
+		return err
+	}
+	if ts.MaxDowntime >= ts.RestartTimeout {
+		return fmt.Errorf("invalid 'max_downtime' parameter: must be shorter than the restart timeout of %v", ts.RestartTimeout)
+	}
+	if ts.PollInterval, err = teststeps.DurationParam(params, "poll_interval", defaultPollInterval); err != nil {
+		return err
+	}
+	if ts.PollInterval > ts.MaxDowntime {
+		return fmt.Errorf("invalid 'poll_interval' parameter: must not exceed the 'max_downtime' it measures")
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *GracefulRestart) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. GracefulRestart
+// cannot resume.
+func (ts *GracefulRestart) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *GracefulRestart) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new GracefulRestart test step.
+func New() test.TestStep {
+	return &GracefulRestart{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package gracefulrestart
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+)
+
+// maxBodyRead bounds the response bodies read before closing them.
+const maxBodyRead = 1 << 16
+
+// get sends a GET request to u, and checks the status of the response.
+func get(ctx context.Context, client *http.Client, u string, ok func(int) bool) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("cannot build request: %v", err)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBodyRead))
+	_ = resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("cannot read response of GET %s: %v", u, err)
+	}
+	if !ok(resp.StatusCode) {
+		return fmt.Errorf("GET %s returned %s", u, resp.Status)
+	}
+	return nil
+}
+
+// drain tracks the requests which are in flight when the shutdown signal is
+// sent.
+type drain struct {
+	results chan error
+	total   int
+}
+
+// startDrain sends n concurrent GET requests to u, and returns once they have
+// all been written, so that they are in flight. u should be slow enough for
+// them to be pending when the application receives the signal.
+func startDrain(ctx context.Context, client *http.Client, u string, n int) (*drain, error) {
+	d := drain{results: make(chan error, n), total: n}
+	written := make(chan struct{}, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			var once sync.Once
+			wrote := func() { once.Do(func() { written <- struct{}{} }) }
+			trace := &httptrace.ClientTrace{
+				WroteRequest: func(httptrace.WroteRequestInfo) { wrote() },
+			}
+			err := get(httptrace.WithClientTrace(ctx, trace), client, u, func(status int) bool {
+				return status >= 200 && status < 300
+			})
+			// requests which failed before being written do not block the
+			// caller
+			wrote()
+			d.results <- err
+		}()
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-written:
+		case <-ctx.Done():
+			d.wait()
+			return nil, ctx.Err()
+		}
+	}
+	return &d, nil
+}
+
+// wait waits for the requests to complete, and returns how many completed
+// successfully and the errors of the others.
+func (d *drain) wait() (int, string) {
+	completed := 0
+	var errs []string
+	for i := 0; i < d.total; i++ {
+		if err := <-d.results; err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			completed++
+		}
+	}
+	return completed, strings.Join(errs, "; ")
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package teststeps
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+)
+
+// HTTPClientOptions configures the HTTP clients returned by NewHTTPClient.
+type HTTPClientOptions struct {
+	// Timeouts is the timeout policy of the client. The connect timeout
+	// also bounds the TLS handshake, and the total timeout bounds a request
+	// including reading the response body.
+	Timeouts NetworkTimeouts
+	// TLSConfig is the TLS configuration of the client, if not the default
+	// one.
+	TLSConfig *tls.Config
+	// InsecureSkipVerify disables the verification of server certificates.
+	InsecureSkipVerify bool
+	// DisableKeepAlives forces a new connection for each request.
+	DisableKeepAlives bool
+}
+
+// NewHTTPClient returns an HTTP client for use by the test steps. Each client
+// has its own transport, so that connections are not shared across steps.
+func NewHTTPClient(opts HTTPClientOptions) *http.Client {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           opts.Timeouts.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   opts.Timeouts.Connect,
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableKeepAlives:     opts.DisableKeepAlives,
+		TLSClientConfig:       opts.TLSConfig,
+	}
+	if opts.InsecureSkipVerify {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		} else {
+			transport.TLSClientConfig = transport.TLSClientConfig.Clone()
+		}
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+	return &http.Client{Transport: transport, Timeout: opts.Timeouts.Total}
+}
+
+// ValidateURLTemplate checks that the named parameter is a template which
+// expands to a valid http or https URL, using a placeholder target.
+func ValidateURLTemplate(p *test.Param, name string) error {
+	if p.IsEmpty() {
+		return fmt.Errorf("invalid or missing '%s' parameter, must be exactly one string", name)
+	}
+	expanded, err := p.Expand(&target.Target{Name: "validation.example.com", ID: "validation"})
+	if err != nil {
+		return fmt.Errorf("invalid '%s' parameter: %v", name, err)
+	}
+	return ValidateURL(expanded, name)
+}
+
+// ValidateURL checks that the value of the named parameter is a valid http or
+// https URL.
+func ValidateURL(rawURL, name string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid '%s' parameter: %v", name, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid '%s' parameter %q: must be a http or https URL", name, rawURL)
+	}
+	return nil
+}
@@ -0,0 +1,419 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package hwinventory
+
+// The HwInventory plugin collects the CPU, memory, disk and NIC inventory of
+// each target over SSH, and compares it against the expected hardware profile
+// of the target class. Targets which do not match fail with a
+// TargetHwMismatch event detailing the differences.
+//
+// The profiles are read from the JSON file passed in the profiles_file
+// parameter, which maps class names to profiles, e.g.:
+//
+//   {
+//     "default": {"cpu_count": 32, "memory_gib": 128},
+//     "storage": {"cpu_model": "EPYC", "disk_count": 12, "disk_size_gb": 8000,
+//                 "nic_drivers": ["mlx5_core", "mlx5_core"]}
+//   }
+//
+// The class of a target is the expansion of the class parameter, e.g.
+// "{{ .Name }}" if targets are named after their class, or "default".
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "HwInventory"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventHwMatch    = event.Name("TargetHwMatch")
+	EventHwMismatch = event.Name("TargetHwMismatch")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventHwMatch, EventHwMismatch}
+
+const (
+	defaultClass            = "default"
+	defaultTolerancePercent = 5
+)
+
+// command prints the inventory as "key: value" lines. Disks are the block
+// devices of type disk, except compressed RAM disks, and NICs are the network
+// interfaces backed by a device, with "-" for an unknown driver.
+const command = `echo "cpu_model: $(grep -m1 '^model name' /proc/cpuinfo | cut -d: -f2-)" && ` +
+	`echo "cpu_count: $(grep -c '^processor' /proc/cpuinfo)" && ` +
+	`grep '^MemTotal:' /proc/meminfo && ` +
+	`lsblk -d -b -n -o NAME,TYPE,SIZE | awk '$2 == "disk" && $1 !~ /^zram/ {print "disk: " $1 " " $3}' && ` +
+	`for n in /sys/class/net/*; do if [ -e "$n/device" ]; then ` +
+	`d=$(readlink "$n/device/driver"); d=${d##*/}; echo "nic: ${n##*/} ${d:--} $(cat "$n/speed" 2>/dev/null)"; fi; done`
+
+// profile is the expected hardware of a target class. Unset fields are not
+// checked.
+type profile struct {
+	// CPUModel is a regular expression matched against the CPU model name.
+	CPUModel string `json:"cpu_model"`
+	// CPUCount is the number of logical CPUs.
+	CPUCount *int `json:"cpu_count"`
+	// MemoryGiB is the installed memory. The memory reported by the kernel
+	// is slightly lower, so it is compared with a tolerance.
+	MemoryGiB *float64 `json:"memory_gib"`
+	DiskCount *int     `json:"disk_count"`
+	// DiskSizeGB is the size of every disk, in decimal gigabytes as
+	// advertised by vendors, compared with a tolerance.
+	DiskSizeGB *float64 `json:"disk_size_gb"`
+	NICCount   *int     `json:"nic_count"`
+	// NICDrivers lists the drivers of the NICs, in any order.
+	NICDrivers []string `json:"nic_drivers"`
+	// TolerancePercent is the tolerance of the memory and disk sizes, which
+	// defaults to 5%.
+	TolerancePercent *float64 `json:"tolerance_percent"`
+
+	cpuModelRe *regexp.Regexp
+	tolerance  float64
+}
+
+func (p *profile) validate() error {
+	if p.CPUModel == "" && p.CPUCount == nil && p.MemoryGiB == nil && p.DiskCount == nil &&
+		p.DiskSizeGB == nil && p.NICCount == nil && p.NICDrivers == nil {
+		return errors.New("no expected hardware specified")
+	}
+	if p.CPUModel != "" {
+		re, err := regexp.Compile(p.CPUModel)
+		if err != nil {
+			return fmt.Errorf("invalid cpu_model regular expression: %v", err)
+		}
+		p.cpuModelRe = re
+	}
+	for name, v := range map[string]*int{"cpu_count": p.CPUCount, "disk_count": p.DiskCount, "nic_count": p.NICCount} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("invalid %s: cannot be negative", name)
+		}
+	}
+	for name, v := range map[string]*float64{"memory_gib": p.MemoryGiB, "disk_size_gb": p.DiskSizeGB} {
+		if v != nil && *v <= 0 {
+			return fmt.Errorf("invalid %s: must be positive", name)
+		}
+	}
+	if p.NICCount != nil && p.NICDrivers != nil && len(p.NICDrivers) != *p.NICCount {
+		return fmt.Errorf("nic_count is %d but %d nic_drivers are listed", *p.NICCount, len(p.NICDrivers))
+	}
+	p.tolerance = defaultTolerancePercent
+	if p.TolerancePercent != nil {
+		if *p.TolerancePercent < 0 || *p.TolerancePercent >= 100 {
+			return errors.New("invalid tolerance_percent: must be between 0 and 100")
+		}
+		p.tolerance = *p.TolerancePercent
+	}
+	return nil
+}
+
+// loadProfiles reads and validates the hardware profiles file.
+func loadProfiles(path string) (map[string]*profile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read hardware profiles: %v", err)
+	}
+	var profiles map[string]*profile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&profiles); err != nil {
+		return nil, fmt.Errorf("cannot parse hardware profiles %s: %v", path, err)
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("hardware profiles %s define no class", path)
+	}
+	for class, p := range profiles {
+		if class == "" {
+			return nil, fmt.Errorf("hardware profiles %s: class name cannot be empty", path)
+		}
+		if p == nil {
+			return nil, fmt.Errorf("hardware profile of class %q cannot be null", class)
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("invalid hardware profile of class %q: %v", class, err)
+		}
+	}
+	return profiles, nil
+}
+
+type disk struct {
+	Name      string
+	SizeBytes uint64
+}
+
+type nic struct {
+	Name      string
+	Driver    string
+	SpeedMbps int `json:",omitempty"`
+}
+
+// inventory is the hardware collected from a target.
+type inventory struct {
+	CPUModel  string
+	CPUCount  int
+	MemoryKiB uint64
+	Disks     []disk
+	NICs      []nic
+}
+
+func parseInventory(out []byte) (*inventory, error) {
+	var inv inventory
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		fields := strings.Fields(value)
+		var err error
+		switch parts[0] {
+		case "cpu_model":
+			inv.CPUModel = value
+		case "cpu_count":
+			inv.CPUCount, err = strconv.Atoi(value)
+		case "MemTotal":
+			if len(fields) != 2 || fields[1] != "kB" {
+				return nil, fmt.Errorf("invalid MemTotal line %q", s.Text())
+			}
+			inv.MemoryKiB, err = strconv.ParseUint(fields[0], 10, 64)
+		case "disk":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("invalid disk line %q", s.Text())
+			}
+			d := disk{Name: fields[0]}
+			d.SizeBytes, err = strconv.ParseUint(fields[1], 10, 64)
+			inv.Disks = append(inv.Disks, d)
+		case "nic":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("invalid nic line %q", s.Text())
+			}
+			n := nic{Name: fields[0], Driver: fields[1]}
+			if n.Driver == "-" {
+				n.Driver = ""
+			}
+			// the speed is unknown, or -1, if the link is down
+			if len(fields) > 2 {
+				if speed, err := strconv.Atoi(fields[2]); err == nil && speed > 0 {
+					n.SpeedMbps = speed
+				}
+			}
+			inv.NICs = append(inv.NICs, n)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", parts[0], value, err)
+		}
+	}
+	if inv.CPUCount == 0 || inv.MemoryKiB == 0 {
+		return nil, errors.New("incomplete hardware inventory: CPU count or memory size not found")
+	}
+	return &inv, nil
+}
+
+// mismatch is a difference between the inventory and the profile.
+type mismatch struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+// withinTolerance tells whether actual is within tolerancePercent of expected.
+func withinTolerance(actual, expected, tolerancePercent float64) bool {
+	return math.Abs(actual-expected) <= expected*tolerancePercent/100
+}
+
+func formatSize(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// compare returns the differences between the inventory and the profile.
+func compare(inv *inventory, p *profile) []mismatch {
+	var mismatches []mismatch
+	add := func(field, expected, actual string) {
+		mismatches = append(mismatches, mismatch{Field: field, Expected: expected, Actual: actual})
+	}
+	if p.cpuModelRe != nil && !p.cpuModelRe.MatchString(inv.CPUModel) {
+		add("cpu_model", p.CPUModel, inv.CPUModel)
+	}
+	if p.CPUCount != nil && inv.CPUCount != *p.CPUCount {
+		add("cpu_count", strconv.Itoa(*p.CPUCount), strconv.Itoa(inv.CPUCount))
+	}
+	if p.MemoryGiB != nil {
+		gib := float64(inv.MemoryKiB) / (1 << 20)
+		if !withinTolerance(gib, *p.MemoryGiB, p.tolerance) {
+			add("memory_gib", formatSize(*p.MemoryGiB), strconv.FormatFloat(gib, 'f', 1, 64))
+		}
+	}
+	if p.DiskCount != nil && len(inv.Disks) != *p.DiskCount {
+		add("disk_count", strconv.Itoa(*p.DiskCount), strconv.Itoa(len(inv.Disks)))
+	}
+	if p.DiskSizeGB != nil {
+		for _, d := range inv.Disks {
+			gb := float64(d.SizeBytes) / 1e9
+			if !withinTolerance(gb, *p.DiskSizeGB, p.tolerance) {
+				add("disk_size_gb", formatSize(*p.DiskSizeGB), fmt.Sprintf("%.1f (%s)", gb, d.Name))
+			}
+		}
+	}
+	if p.NICCount != nil && len(inv.NICs) != *p.NICCount {
+		add("nic_count", strconv.Itoa(*p.NICCount), strconv.Itoa(len(inv.NICs)))
+	}
+	if p.NICDrivers != nil {
+		expected := append([]string(nil), p.NICDrivers...)
+		var actual []string
+		for _, n := range inv.NICs {
+			actual = append(actual, n.Driver)
+		}
+		sort.Strings(expected)
+		sort.Strings(actual)
+		if strings.Join(expected, ",") != strings.Join(actual, ",") {
+			add("nic_drivers", strings.Join(expected, ", "), strings.Join(actual, ", "))
+		}
+	}
+	return mismatches
+}
+
+// hwEventPayload is the payload of the events emitted by this step.
+type hwEventPayload struct {
+	Class      string
+	Inventory  *inventory
+	Mismatches []mismatch `json:",omitempty"`
+}
+
+// HwInventory verifies the hardware inventory of the targets.
+type HwInventory struct {
+	*teststeps.SSHParams
+	Class    *test.Param
+	Profiles map[string]*profile
+}
+
+// Name returns the plugin name.
+func (ts HwInventory) Name() string {
+	return Name
+}
+
+func (ts *HwInventory) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	class := defaultClass
+	if !ts.Class.IsEmpty() {
+		var err error
+		if class, err = ts.Class.Expand(target); err != nil {
+			return fmt.Errorf("cannot expand class parameter: %v", err)
+		}
+	}
+	p, ok := ts.Profiles[class]
+	if !ok {
+		return fmt.Errorf("no hardware profile for class %q", class)
+	}
+	result, err := ts.RunSSHCommand(cancel, pause, target, command)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command '%s' exited with status %d: %s", command, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	inv, err := parseInventory(result.Stdout)
+	if err != nil {
+		return err
+	}
+	payload := hwEventPayload{Class: class, Inventory: inv, Mismatches: compare(inv, p)}
+	if len(payload.Mismatches) == 0 {
+		return teststeps.EmitTargetEvent(ev, EventHwMatch, target, payload)
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventHwMismatch, target, payload); err != nil {
+		return err
+	}
+	var diffs []string
+	for _, m := range payload.Mismatches {
+		diffs = append(diffs, fmt.Sprintf("%s is %s, expected %s", m.Field, m.Actual, m.Expected))
+	}
+	return fmt.Errorf("hardware does not match profile %q: %s", class, strings.Join(diffs, ", "))
+}
+
+// Run executes the hardware inventory step.
+func (ts *HwInventory) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the step normally, as it only reads the hardware inventory.
+func (ts *HwInventory) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *HwInventory) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	profilesFile := params.GetOne("profiles_file")
+	if profilesFile.IsEmpty() {
+		return errors.New("invalid or missing 'profiles_file' parameter, must be exactly one string")
+	}
+	if ts.Profiles, err = loadProfiles(profilesFile.Raw()); err != nil {
+		return err
+	}
+	ts.Class = params.GetOne("class")
+	if ts.Class.IsEmpty() {
+		if _, ok := ts.Profiles[defaultClass]; !ok {
+			return fmt.Errorf("no 'class' parameter, and no %q class in the hardware profiles", defaultClass)
+		}
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *HwInventory) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. HwInventory
+// cannot resume.
+func (ts *HwInventory) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *HwInventory) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new HwInventory test step.
+func New() test.TestStep {
+	return &HwInventory{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
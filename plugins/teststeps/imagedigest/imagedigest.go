@@ -0,0 +1,437 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package imagedigest
+
+// The ImageDigest plugin lists the running containers of each target over SSH,
+// through the container runtime, and verifies the registry digests of their
+// images against an allowlist of digests per image name. Targets running an
+// image which is not in the allowlist, whose digest is not allowed or unknown,
+// or which was started from a tag rather than a digest, are failed.
+//
+// Image names are normalized as the container runtimes do, so that "nginx"
+// and "docker.io/library/nginx" refer to the same image.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "ImageDigest"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventImageDigestOK       = event.Name("TargetImageDigestOK")
+	EventImageDigestMismatch = event.Name("TargetImageDigestMismatch")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventImageDigestOK, EventImageDigestMismatch}
+
+const (
+	// inspectCommand prints the JSON descriptions of the running containers,
+	// followed by those of their images. %[1]s is the runtime command.
+	inspectCommand = `ids=$(%[1]s ps -q) || exit; [ -n "$ids" ] || exit 0; %[1]s inspect $ids && %[1]s image inspect $(%[1]s inspect --format '{{.Image}}' $ids | sort -u)`
+	// crictlCommand prints the JSON lists of the running containers and of the
+	// images.
+	crictlCommand = `%[1]s ps -o json && %[1]s images -o json`
+
+	defaultDomain = "docker.io"
+	// maxMismatches bounds the number of mismatches reported in the events.
+	maxMismatches = 100
+)
+
+var (
+	digestRe  = regexp.MustCompile(`^(sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+	imageIDRe = regexp.MustCompile(`^(sha256:)?[a-f0-9]{64}$`)
+)
+
+// container is a running container, with the reference it was started from,
+// which may be an image ID, and the ID of its image.
+type container struct {
+	Name      string
+	Reference string
+	ImageID   string
+}
+
+// image is a local image, with its tags and registry digests, both in the
+// repository@digest form.
+type image struct {
+	ID      string
+	Tags    []string
+	Digests []string
+}
+
+// mismatch describes a container failing the check.
+type mismatch struct {
+	Container string
+	Image     string
+	Digests   []string `json:",omitempty"`
+	Reason    string
+}
+
+// digestEventPayload is the payload of the events emitted by this step.
+type digestEventPayload struct {
+	Containers int
+	Mismatches []mismatch `json:",omitempty"`
+	Truncated  bool       `json:",omitempty"`
+}
+
+// ImageDigest verifies the image digests of the running containers.
+type ImageDigest struct {
+	*teststeps.SSHParams
+	Runtime       string
+	Sudo          bool
+	Allowed       map[string][]string
+	AllowUnlisted bool
+	AllowUnpinned bool
+}
+
+// Name returns the plugin name.
+func (ts ImageDigest) Name() string {
+	return Name
+}
+
+// splitReference splits an image reference into its normalized repository and
+// its digest, if any. Tags are dropped.
+func splitReference(ref string) (string, string) {
+	var digest string
+	if i := strings.IndexByte(ref, '@'); i >= 0 {
+		ref, digest = ref[:i], ref[i+1:]
+	}
+	// a colon after the last slash separates the tag
+	if i := strings.LastIndexByte(ref, ':'); i > strings.LastIndexByte(ref, '/') {
+		ref = ref[:i]
+	}
+	i := strings.IndexByte(ref, '/')
+	if i < 0 || (!strings.ContainsAny(ref[:i], ".:") && ref[:i] != "localhost") {
+		ref = defaultDomain + "/" + ref
+	} else if ref[:i] == "index.docker.io" {
+		ref = defaultDomain + ref[i:]
+	}
+	if strings.HasPrefix(ref, defaultDomain+"/") && strings.Count(ref, "/") == 1 {
+		ref = defaultDomain + "/library/" + strings.TrimPrefix(ref, defaultDomain+"/")
+	}
+	return ref, digest
+}
+
+// parseInspect parses the output of inspectCommand.
+func parseInspect(out []byte) ([]container, []image, error) {
+	var (
+		inspected []struct {
+			Name      string
+			ImageName string
+			Image     string
+			Config    struct {
+				Image string
+			}
+		}
+		images []struct {
+			ID          string `json:"Id"`
+			RepoTags    []string
+			RepoDigests []string
+		}
+	)
+	if len(bytes.TrimSpace(out)) == 0 {
+		// no running containers
+		return nil, nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	if err := dec.Decode(&inspected); err != nil {
+		return nil, nil, fmt.Errorf("cannot decode containers: %v", err)
+	}
+	if err := dec.Decode(&images); err != nil {
+		return nil, nil, fmt.Errorf("cannot decode images: %v", err)
+	}
+	containers := make([]container, 0, len(inspected))
+	for _, c := range inspected {
+		ref := c.Config.Image
+		// podman reports the full reference separately
+		if c.ImageName != "" {
+			ref = c.ImageName
+		}
+		containers = append(containers, container{Name: strings.TrimPrefix(c.Name, "/"), Reference: ref, ImageID: c.Image})
+	}
+	result := make([]image, 0, len(images))
+	for _, i := range images {
+		result = append(result, image{ID: i.ID, Tags: i.RepoTags, Digests: i.RepoDigests})
+	}
+	return containers, result, nil
+}
+
+// parseCrictl parses the output of crictlCommand.
+func parseCrictl(out []byte) ([]container, []image, error) {
+	var (
+		ps struct {
+			Containers []struct {
+				Metadata struct {
+					Name string `json:"name"`
+				} `json:"metadata"`
+				Image struct {
+					Image string `json:"image"`
+				} `json:"image"`
+				ImageRef string `json:"imageRef"`
+			} `json:"containers"`
+		}
+		images struct {
+			Images []struct {
+				ID          string   `json:"id"`
+				RepoTags    []string `json:"repoTags"`
+				RepoDigests []string `json:"repoDigests"`
+			} `json:"images"`
+		}
+	)
+	dec := json.NewDecoder(bytes.NewReader(out))
+	if err := dec.Decode(&ps); err != nil {
+		return nil, nil, fmt.Errorf("cannot decode containers: %v", err)
+	}
+	if err := dec.Decode(&images); err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf("cannot decode images: %v", err)
+	}
+	containers := make([]container, 0, len(ps.Containers))
+	for _, c := range ps.Containers {
+		containers = append(containers, container{Name: c.Metadata.Name, Reference: c.Image.Image, ImageID: c.ImageRef})
+	}
+	result := make([]image, 0, len(images.Images))
+	for _, i := range images.Images {
+		result = append(result, image{ID: i.ID, Tags: i.RepoTags, Digests: i.RepoDigests})
+	}
+	return containers, result, nil
+}
+
+// findImage returns the image of a container, which is referred to either by
+// its ID, with or without the algorithm prefix, or by one of its digests.
+func findImage(images []image, id string) *image {
+	trimmed := strings.TrimPrefix(id, "sha256:")
+	for i := range images {
+		if strings.TrimPrefix(images[i].ID, "sha256:") == trimmed {
+			return &images[i]
+		}
+		for _, d := range images[i].Digests {
+			if d == id {
+				return &images[i]
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the repository of a container image, its digests in that
+// repository, and whether the container was started from a digest.
+func resolve(c container, images []image) (string, []string, bool) {
+	img := findImage(images, c.ImageID)
+	ref := c.Reference
+	if ref == "" || imageIDRe.MatchString(ref) {
+		// started from an image ID: name the image after its first tag or,
+		// if it was pulled by digest and has no tags, after its digest
+		switch {
+		case img != nil && len(img.Tags) > 0:
+			ref = img.Tags[0]
+		case img != nil && len(img.Digests) > 0:
+			ref = img.Digests[0]
+		case strings.Contains(c.ImageID, "@"):
+			ref = c.ImageID
+		default:
+			return "", nil, false
+		}
+	}
+	repo, digest := splitReference(ref)
+	if digest != "" {
+		return repo, []string{digest}, true
+	}
+	var digests []string
+	if img != nil {
+		for _, d := range img.Digests {
+			if r, dg := splitReference(d); r == repo && dg != "" {
+				digests = append(digests, dg)
+			}
+		}
+	}
+	return repo, digests, false
+}
+
+// verify returns the mismatch of a container, or nil if it passes the check.
+func (ts *ImageDigest) verify(c container, images []image) *mismatch {
+	repo, digests, pinned := resolve(c, images)
+	m := mismatch{Container: c.Name, Image: repo, Digests: digests}
+	if repo == "" {
+		m.Image = c.Reference
+		m.Reason = "cannot determine the image name"
+		return &m
+	}
+	if !pinned && !ts.AllowUnpinned {
+		m.Reason = fmt.Sprintf("started from %q, which is not pinned to a digest", c.Reference)
+		return &m
+	}
+	allowed, listed := ts.Allowed[repo]
+	if !listed {
+		if ts.AllowUnlisted {
+			return nil
+		}
+		m.Reason = "image is not in the allowlist"
+		return &m
+	}
+	if len(digests) == 0 {
+		m.Reason = "image has no registry digest"
+		return &m
+	}
+	for _, d := range digests {
+		for _, a := range allowed {
+			if d == a {
+				return nil
+			}
+		}
+	}
+	m.Reason = "digest is not allowed"
+	return &m
+}
+
+func (ts *ImageDigest) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	runtime := ts.Runtime
+	if ts.Sudo {
+		runtime = "sudo -n " + runtime
+	}
+	cmd, parse := fmt.Sprintf(inspectCommand, runtime), parseInspect
+	if ts.Runtime == teststeps.RuntimeCrictl {
+		cmd, parse = fmt.Sprintf(crictlCommand, runtime), parseCrictl
+	}
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command exited with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	containers, images, err := parse(result.Stdout)
+	if err != nil {
+		return err
+	}
+	payload := digestEventPayload{Containers: len(containers)}
+	for _, c := range containers {
+		if m := ts.verify(c, images); m != nil {
+			payload.Mismatches = append(payload.Mismatches, *m)
+		}
+	}
+	if len(payload.Mismatches) == 0 {
+		return teststeps.EmitTargetEvent(ev, EventImageDigestOK, target, payload)
+	}
+	count := len(payload.Mismatches)
+	if count > maxMismatches {
+		payload.Mismatches, payload.Truncated = payload.Mismatches[:maxMismatches], true
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventImageDigestMismatch, target, payload); err != nil {
+		return err
+	}
+	first := payload.Mismatches[0]
+	return fmt.Errorf("%d of %d containers failed the image digest check, e.g. %s (%s): %s", count, len(containers), first.Container, first.Image, first.Reason)
+}
+
+// Run executes the image digest check step.
+func (ts *ImageDigest) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as it only inspects the containers.
+func (ts *ImageDigest) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// parseAllowed parses the allowlist entries, in the image@digest form.
+func parseAllowed(entries []test.Param) (map[string][]string, error) {
+	allowed := make(map[string][]string)
+	for _, e := range entries {
+		entry := strings.TrimSpace(e.Raw())
+		if strings.IndexByte(entry, '@') <= 0 {
+			return nil, fmt.Errorf("invalid 'allowed' entry %q: must be image@digest", entry)
+		}
+		repo, digest := splitReference(entry)
+		if !digestRe.MatchString(digest) {
+			return nil, fmt.Errorf("invalid 'allowed' entry %q: %q is not a valid sha256 or sha512 digest", entry, digest)
+		}
+		allowed[repo] = append(allowed[repo], digest)
+	}
+	for _, digests := range allowed {
+		sort.Strings(digests)
+	}
+	return allowed, nil
+}
+
+func (ts *ImageDigest) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	if ts.Runtime, err = teststeps.ContainerRuntimeParam(params); err != nil {
+		return err
+	}
+	if ts.Runtime == teststeps.RuntimeNone {
+		return fmt.Errorf("invalid 'container_runtime' parameter: %s cannot list containers", teststeps.RuntimeNone)
+	}
+	if ts.Sudo, err = teststeps.BoolParam(params, "sudo", false); err != nil {
+		return err
+	}
+	if ts.AllowUnlisted, err = teststeps.BoolParam(params, "allow_unlisted", false); err != nil {
+		return err
+	}
+	if ts.AllowUnpinned, err = teststeps.BoolParam(params, "allow_unpinned", false); err != nil {
+		return err
+	}
+	if ts.Allowed, err = parseAllowed(params.Get("allowed")); err != nil {
+		return err
+	}
+	if len(ts.Allowed) == 0 && !ts.AllowUnlisted {
+		return errors.New("missing 'allowed' parameter: at least one image@digest entry is required, unless 'allow_unlisted' is set")
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *ImageDigest) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. ImageDigest
+// cannot resume.
+func (ts *ImageDigest) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *ImageDigest) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new ImageDigest test step.
+func New() test.TestStep {
+	return &ImageDigest{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
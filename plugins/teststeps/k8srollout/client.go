@@ -0,0 +1,259 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package k8srollout
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// in-cluster configuration, as mounted by Kubernetes in every pod.
+const (
+	inClusterTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// defaultRequestTimeout bounds the API requests when the network timeouts do
+// not specify a total timeout.
+const defaultRequestTimeout = 30 * time.Second
+
+// kubeConfig is the subset of the kubeconfig file format used by this plugin.
+// Only the JSON representation is supported, as produced by
+// `kubectl config view --flatten -o json`.
+type kubeConfig struct {
+	CurrentContext string `json:"current-context"`
+	Clusters       []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server                   string `json:"server"`
+			CertificateAuthority     string `json:"certificate-authority"`
+			CertificateAuthorityData string `json:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			Token                 string `json:"token"`
+			TokenFile             string `json:"tokenFile"`
+			ClientCertificate     string `json:"client-certificate"`
+			ClientCertificateData string `json:"client-certificate-data"`
+			ClientKey             string `json:"client-key"`
+			ClientKeyData         string `json:"client-key-data"`
+		} `json:"user"`
+	} `json:"users"`
+	Contexts []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+}
+
+// client is a minimal client for the Kubernetes REST API.
+type client struct {
+	server     string
+	token      string
+	httpClient *http.Client
+}
+
+// kubeConfigPath follows the client config convention: an explicit path
+// first, then the KUBECONFIG environment variable, then ~/.kube/config. An
+// empty string means that the in-cluster configuration should be used.
+func kubeConfigPath(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		// only the first file of a KUBECONFIG list is considered
+		return filepath.SplitList(env)[0]
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		p := filepath.Join(home, ".kube", "config")
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+func readDataOrFile(data, file string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+	return nil, nil
+}
+
+// newClient builds a client from the kubeconfig file at path, using the
+// requested context or the current one. If path is empty, the in-cluster
+// configuration is used.
+func newClient(path, contextName string, timeouts teststeps.NetworkTimeouts) (*client, error) {
+	if timeouts.Total == 0 {
+		timeouts.Total = defaultRequestTimeout
+	}
+	if path == "" {
+		return newInClusterClient(timeouts)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read kubeconfig: %v", err)
+	}
+	var kc kubeConfig
+	if err := json.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("cannot parse kubeconfig %s (only JSON is supported, use `kubectl config view --flatten -o json`): %v", path, err)
+	}
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == contextName {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in kubeconfig %s", contextName, path)
+	}
+
+	tlsConfig := tls.Config{}
+	c := client{}
+	found = false
+	for _, cl := range kc.Clusters {
+		if cl.Name != clusterName {
+			continue
+		}
+		found = true
+		c.server = cl.Cluster.Server
+		tlsConfig.InsecureSkipVerify = cl.Cluster.InsecureSkipTLSVerify
+		ca, err := readDataOrFile(cl.Cluster.CertificateAuthorityData, cl.Cluster.CertificateAuthority)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read certificate authority of cluster %s: %v", clusterName, err)
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("invalid certificate authority for cluster %s", clusterName)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if !found || c.server == "" {
+		return nil, fmt.Errorf("cluster %q not found in kubeconfig %s", clusterName, path)
+	}
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		c.token = u.User.Token
+		if c.token == "" && u.User.TokenFile != "" {
+			token, err := ioutil.ReadFile(u.User.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read token file of user %s: %v", userName, err)
+			}
+			c.token = strings.TrimSpace(string(token))
+		}
+		cert, err := readDataOrFile(u.User.ClientCertificateData, u.User.ClientCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read client certificate of user %s: %v", userName, err)
+		}
+		key, err := readDataOrFile(u.User.ClientKeyData, u.User.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read client key of user %s: %v", userName, err)
+		}
+		if cert != nil && key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client certificate of user %s: %v", userName, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+	c.httpClient = teststeps.NewHTTPClient(teststeps.HTTPClientOptions{Timeouts: timeouts, TLSConfig: &tlsConfig})
+	return &c, nil
+}
+
+func newInClusterClient(timeouts teststeps.NetworkTimeouts) (*client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("no kubeconfig found and not running inside a Kubernetes cluster")
+	}
+	token, err := ioutil.ReadFile(inClusterTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read service account token: %v", err)
+	}
+	ca, err := ioutil.ReadFile(inClusterCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read service account CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account CA")
+	}
+	return &client{
+		server: "https://" + net.JoinHostPort(host, port),
+		token:  strings.TrimSpace(string(token)),
+		httpClient: teststeps.NewHTTPClient(teststeps.HTTPClientOptions{
+			Timeouts:  timeouts,
+			TLSConfig: &tls.Config{RootCAs: pool},
+		}),
+	}, nil
+}
+
+// get performs a GET request on the given API path and decodes the JSON
+// response into out.
+func (c *client) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	u := strings.TrimSuffix(c.server, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warningf("Failed to close response body: %v", err)
+		}
+	}()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,374 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package k8srollout
+
+// The K8sRollout plugin waits for the rollout of a Kubernetes workload to
+// complete. Each target is mapped to a workload through the templated 'name'
+// parameter (by default the target name). Deployments and StatefulSets are
+// complete when all the desired replicas are updated and available, Jobs when
+// the requested completions succeeded.
+//
+// The cluster is reached through the Kubernetes client config convention: the
+// 'kubeconfig' parameter, the KUBECONFIG environment variable, ~/.kube/config,
+// or the in-cluster service account configuration, in this order.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "K8sRollout"
+
+var log = logging.GetLogger("teststeps/" + strings.ToLower(Name))
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventRolloutComplete = event.Name("TargetRolloutComplete")
+	EventRolloutTimeout  = event.Name("TargetRolloutTimeout")
+	EventRolloutFailed   = event.Name("TargetRolloutFailed")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventRolloutComplete, EventRolloutTimeout, EventRolloutFailed}
+
+const (
+	defaultNamespace    = "default"
+	defaultTimeout      = 10 * time.Minute
+	defaultPollInterval = 5 * time.Second
+	// maxPodEvents limits the number of pod events attached to a failure.
+	maxPodEvents = 20
+)
+
+// workloadPaths maps the supported workload kinds to their API path.
+var workloadPaths = map[string]string{
+	"Deployment":  "/apis/apps/v1/namespaces/%s/deployments/%s",
+	"StatefulSet": "/apis/apps/v1/namespaces/%s/statefulsets/%s",
+	"Job":         "/apis/batch/v1/namespaces/%s/jobs/%s",
+}
+
+// workload is the subset of the Deployment, StatefulSet and Job objects used
+// to determine the rollout status.
+type workload struct {
+	Metadata struct {
+		Generation int64 `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas    *int32 `json:"replicas"`
+		Completions *int32 `json:"completions"`
+		Selector    struct {
+			MatchLabels map[string]string `json:"matchLabels"`
+		} `json:"selector"`
+	} `json:"spec"`
+	Status struct {
+		ObservedGeneration int64  `json:"observedGeneration"`
+		Replicas           int32  `json:"replicas"`
+		UpdatedReplicas    int32  `json:"updatedReplicas"`
+		ReadyReplicas      int32  `json:"readyReplicas"`
+		AvailableReplicas  int32  `json:"availableReplicas"`
+		CurrentRevision    string `json:"currentRevision"`
+		UpdateRevision     string `json:"updateRevision"`
+		Succeeded          int32  `json:"succeeded"`
+		Failed             int32  `json:"failed"`
+		Conditions         []struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Status struct {
+			Phase      string `json:"phase"`
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type eventList struct {
+	Items []struct {
+		Type    string `json:"type"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	} `json:"items"`
+}
+
+// PodEvent is a Kubernetes event related to a pod of the workload.
+type PodEvent struct {
+	Pod     string
+	Type    string
+	Reason  string
+	Message string
+}
+
+// rolloutPayload is the payload of the events emitted by this step.
+type rolloutPayload struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Desired   int32
+	Updated   int32
+	Ready     int32
+	Available int32
+	Message   string     `json:",omitempty"`
+	PodEvents []PodEvent `json:",omitempty"`
+}
+
+// K8sRollout waits for a Kubernetes rollout to complete.
+type K8sRollout struct {
+	Kind         string
+	Namespace    *test.Param
+	WorkloadName *test.Param
+	KubeConfig   string
+	Context      string
+	Timeout      time.Duration
+	PollInterval time.Duration
+	Timeouts     teststeps.NetworkTimeouts
+}
+
+// Name returns the plugin name.
+func (ts K8sRollout) Name() string {
+	return Name
+}
+
+// rolloutStatus returns whether the rollout is complete, or an error if it
+// failed in a non-recoverable way.
+func rolloutStatus(kind string, w *workload, p *rolloutPayload) (bool, error) {
+	desired := int32(1)
+	if w.Spec.Replicas != nil {
+		desired = *w.Spec.Replicas
+	}
+	p.Desired, p.Updated, p.Ready, p.Available = desired, w.Status.UpdatedReplicas, w.Status.ReadyReplicas, w.Status.AvailableReplicas
+	switch kind {
+	case "Job":
+		completions := int32(1)
+		if w.Spec.Completions != nil {
+			completions = *w.Spec.Completions
+		}
+		p.Desired, p.Ready = completions, w.Status.Succeeded
+		for _, c := range w.Status.Conditions {
+			if c.Type == "Failed" && c.Status == "True" {
+				return false, fmt.Errorf("job failed: %s: %s", c.Reason, c.Message)
+			}
+		}
+		return w.Status.Succeeded >= completions, nil
+	case "StatefulSet":
+		if w.Status.ObservedGeneration < w.Metadata.Generation {
+			return false, nil
+		}
+		if w.Status.UpdateRevision != "" && w.Status.CurrentRevision != w.Status.UpdateRevision {
+			return false, nil
+		}
+		return w.Status.ReadyReplicas == desired && w.Status.UpdatedReplicas == desired, nil
+	default:
+		if w.Status.ObservedGeneration < w.Metadata.Generation {
+			return false, nil
+		}
+		for _, c := range w.Status.Conditions {
+			if c.Type == "Progressing" && c.Reason == "ProgressDeadlineExceeded" {
+				return false, fmt.Errorf("deployment exceeded its progress deadline: %s", c.Message)
+			}
+		}
+		return w.Status.UpdatedReplicas == desired &&
+			w.Status.Replicas == desired &&
+			w.Status.AvailableReplicas == desired, nil
+	}
+}
+
+// podEvents collects the events of the pods of the workload which are not
+// ready, to explain why a rollout did not complete.
+func podEvents(ctx context.Context, c *client, namespace string, w *workload) ([]PodEvent, error) {
+	var selector []string
+	for k, v := range w.Spec.Selector.MatchLabels {
+		selector = append(selector, k+"="+v)
+	}
+	sort.Strings(selector)
+	var pods podList
+	if err := c.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/pods", namespace), url.Values{"labelSelector": {strings.Join(selector, ",")}}, &pods); err != nil {
+		return nil, fmt.Errorf("cannot list pods: %v", err)
+	}
+	var events []PodEvent
+	for _, pod := range pods.Items {
+		ready := false
+		for _, c := range pod.Status.Conditions {
+			if c.Type == "Ready" && c.Status == "True" {
+				ready = true
+			}
+		}
+		if ready || pod.Status.Phase == "Succeeded" {
+			continue
+		}
+		var evs eventList
+		query := url.Values{"fieldSelector": {"involvedObject.kind=Pod,involvedObject.name=" + pod.Metadata.Name}}
+		if err := c.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/events", namespace), query, &evs); err != nil {
+			return nil, fmt.Errorf("cannot list events of pod %s: %v", pod.Metadata.Name, err)
+		}
+		for _, e := range evs.Items {
+			events = append(events, PodEvent{Pod: pod.Metadata.Name, Type: e.Type, Reason: e.Reason, Message: e.Message})
+			if len(events) >= maxPodEvents {
+				return events, nil
+			}
+		}
+	}
+	return events, nil
+}
+
+// Run executes the rollout step.
+func (ts *K8sRollout) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	c, err := newClient(kubeConfigPath(ts.KubeConfig), ts.Context, ts.Timeouts)
+	if err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		namespace, err := ts.Namespace.Expand(target)
+		if err != nil {
+			return fmt.Errorf("cannot expand namespace parameter: %v", err)
+		}
+		name, err := ts.WorkloadName.Expand(target)
+		if err != nil {
+			return fmt.Errorf("cannot expand name parameter: %v", err)
+		}
+		ctx, ctxCancel := teststeps.NewContext(cancel, pause)
+		defer ctxCancel()
+
+		payload := rolloutPayload{Kind: ts.Kind, Namespace: namespace, Name: name}
+		path := fmt.Sprintf(workloadPaths[ts.Kind], url.PathEscape(namespace), url.PathEscape(name))
+		deadline := time.Now().Add(ts.Timeout)
+		var w workload
+		for {
+			w = workload{}
+			err := c.get(ctx, path, nil, &w)
+			if ctx.Err() != nil {
+				return teststeps.ErrInterrupted
+			}
+			if err != nil {
+				log.Warningf("Cannot get %s %s/%s: %v", ts.Kind, namespace, name, err)
+			} else {
+				done, err := rolloutStatus(ts.Kind, &w, &payload)
+				if err != nil {
+					payload.Message = err.Error()
+					if evs, evErr := podEvents(ctx, c, namespace, &w); evErr == nil {
+						payload.PodEvents = evs
+					}
+					if emitErr := teststeps.EmitTargetEvent(ev, EventRolloutFailed, target, payload); emitErr != nil {
+						log.Warningf("%v", emitErr)
+					}
+					return err
+				}
+				if done {
+					log.Infof("Rollout of %s %s/%s complete", ts.Kind, namespace, name)
+					return teststeps.EmitTargetEvent(ev, EventRolloutComplete, target, payload)
+				}
+			}
+			if time.Now().After(deadline) {
+				break
+			}
+			if err := teststeps.Sleep(cancel, pause, ts.PollInterval); err != nil {
+				return err
+			}
+		}
+		payload.Message = fmt.Sprintf("rollout did not complete within %s", ts.Timeout)
+		evs, err := podEvents(ctx, c, namespace, &w)
+		if err != nil {
+			log.Warningf("Cannot collect pod events for %s %s/%s: %v", ts.Kind, namespace, name, err)
+		}
+		payload.PodEvents = evs
+		if err := teststeps.EmitTargetEvent(ev, EventRolloutTimeout, target, payload); err != nil {
+			log.Warningf("%v", err)
+		}
+		return fmt.Errorf("rollout of %s %s/%s timed out after %s", ts.Kind, namespace, name, ts.Timeout)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the step normally, as it only watches the workloads.
+func (ts *K8sRollout) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *K8sRollout) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.Kind = params.GetOne("kind").Raw()
+	if _, ok := workloadPaths[ts.Kind]; !ok {
+		return fmt.Errorf("invalid or missing 'kind' parameter %q, must be one of Deployment, StatefulSet, Job", ts.Kind)
+	}
+	ts.WorkloadName = params.GetOne("name")
+	if ts.WorkloadName.IsEmpty() {
+		ts.WorkloadName = test.NewParam("{{ .Name }}")
+	}
+	if strings.TrimSpace(ts.WorkloadName.Raw()) == "" {
+		return errors.New("invalid 'name' parameter, must be a non-empty string")
+	}
+	ts.Namespace = params.GetOne("namespace")
+	if ts.Namespace.IsEmpty() {
+		ts.Namespace = test.NewParam(defaultNamespace)
+	}
+	ts.KubeConfig = params.GetOne("kubeconfig").Raw()
+	ts.Context = params.GetOne("context").Raw()
+	if ts.Timeout, err = teststeps.DurationParam(params, "timeout", defaultTimeout); err != nil {
+		return err
+	}
+	if ts.PollInterval, err = teststeps.DurationParam(params, "poll_interval", defaultPollInterval); err != nil {
+		return err
+	}
+	if ts.Timeouts, err = teststeps.NewNetworkTimeouts(params); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *K8sRollout) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. K8sRollout cannot
+// resume.
+func (ts *K8sRollout) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *K8sRollout) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new K8sRollout test step.
+func New() test.TestStep {
+	return &K8sRollout{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
@@ -0,0 +1,335 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package lbmembership
+
+// The LBMembership plugin queries the API of a load balancer to verify that
+// each target is a registered backend in the expected state, or that it is not
+// registered at all. The API is expected to return a JSON list of members,
+// either at the top level or under a configurable field, where each member is
+// an object with an address and a state field.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "LBMembership"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventLbMembershipOK    = event.Name("TargetLbMembershipOK")
+	EventLbMembershipWrong = event.Name("TargetLbMembershipWrong")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventLbMembershipOK, EventLbMembershipWrong}
+
+const (
+	// StateAbsent expects the target not to be a member of the pool.
+	StateAbsent = "absent"
+	// StatePresent expects the target to be a member, in any state.
+	StatePresent = "present"
+
+	defaultMember         = "{{ .Name }}"
+	defaultAddressField   = "address"
+	defaultStateField     = "state"
+	defaultRequestTimeout = 30 * time.Second
+	// maxResponseSize bounds the size of the API responses.
+	maxResponseSize = 16 * 1024 * 1024
+	// secretPlaceholder replaces the secrets during validation.
+	secretPlaceholder = "secret"
+)
+
+var (
+	stateRe = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+	fieldRe = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+)
+
+// membershipEventPayload is the payload of the events emitted by this step.
+type membershipEventPayload struct {
+	Member        string
+	ExpectedState string
+	// State is the state reported by the load balancer, or absent.
+	State string
+}
+
+// LBMembership verifies the load balancer membership of the targets.
+type LBMembership struct {
+	URL            *test.Param
+	Token          *test.Param
+	Member         *test.Param
+	ExpectedState  string
+	ListField      string
+	AddressField   string
+	StateField     string
+	RequestTimeout time.Duration
+	Insecure       bool
+	Timeouts       teststeps.NetworkTimeouts
+}
+
+// Name returns the plugin name.
+func (ts LBMembership) Name() string {
+	return Name
+}
+
+// lookup returns the value at the given dot-separated path of a decoded JSON
+// document.
+func lookup(doc interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return doc, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// memberState returns the state of the given member in the API response, or
+// StateAbsent if it is not a member.
+func (ts *LBMembership) memberState(doc interface{}, member string) (string, error) {
+	list, ok := lookup(doc, ts.ListField)
+	if !ok {
+		return "", fmt.Errorf("field %q not found in response", ts.ListField)
+	}
+	members, ok := list.([]interface{})
+	if !ok {
+		return "", fmt.Errorf("field %q of response is not a list", ts.ListField)
+	}
+	for _, m := range members {
+		addr, ok := lookup(m, ts.AddressField)
+		if !ok || fmt.Sprint(addr) != member {
+			continue
+		}
+		state, ok := lookup(m, ts.StateField)
+		if !ok {
+			return "", fmt.Errorf("member %s has no %q field", member, ts.StateField)
+		}
+		return fmt.Sprint(state), nil
+	}
+	return StateAbsent, nil
+}
+
+func (ts *LBMembership) query(ctx context.Context, client *http.Client, u, token string) (interface{}, error) {
+	rctx, cancel := context.WithTimeout(ctx, ts.RequestTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(rctx)
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, teststeps.ErrInterrupted
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("cannot decode response: %v", err)
+	}
+	return doc, nil
+}
+
+func (ts *LBMembership) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	u, err := ts.URL.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand url parameter: %v", err)
+	}
+	if u, err = secrets.Expand(u); err != nil {
+		return err
+	}
+	token, err := ts.Token.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand token parameter: %v", err)
+	}
+	if token, err = secrets.Expand(token); err != nil {
+		return err
+	}
+	member, err := ts.Member.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand member parameter: %v", err)
+	}
+
+	ctx, ctxCancel := teststeps.NewContext(cancel, pause)
+	defer ctxCancel()
+	client := teststeps.NewHTTPClient(teststeps.HTTPClientOptions{Timeouts: ts.Timeouts, InsecureSkipVerify: ts.Insecure})
+	defer client.CloseIdleConnections()
+	doc, err := ts.query(ctx, client, u, token)
+	if err != nil {
+		if errors.Is(err, teststeps.ErrInterrupted) {
+			return err
+		}
+		// do not include the URL, which may contain secrets
+		return fmt.Errorf("load balancer API request failed: %v", err)
+	}
+	state, err := ts.memberState(doc, member)
+	if err != nil {
+		return err
+	}
+
+	payload := membershipEventPayload{Member: member, ExpectedState: ts.ExpectedState, State: state}
+	var ok bool
+	switch ts.ExpectedState {
+	case StatePresent:
+		ok = state != StateAbsent
+	default:
+		ok = strings.EqualFold(state, ts.ExpectedState)
+	}
+	if !ok {
+		if err := teststeps.EmitTargetEvent(ev, EventLbMembershipWrong, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("member %s is %s, expected %s", member, state, ts.ExpectedState)
+	}
+	return teststeps.EmitTargetEvent(ev, EventLbMembershipOK, target, payload)
+}
+
+// Run executes the load balancer membership step.
+func (ts *LBMembership) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as it only queries the load balancer.
+func (ts *LBMembership) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// fieldParam parses an optional parameter naming a dot-separated JSON field.
+func fieldParam(params test.TestStepParameters, name, defaultValue string, allowEmpty bool) (string, error) {
+	v := params.GetOne(name).Raw()
+	if v == "" {
+		if defaultValue == "" && !allowEmpty {
+			return "", fmt.Errorf("missing '%s' parameter", name)
+		}
+		return defaultValue, nil
+	}
+	if !fieldRe.MatchString(v) {
+		return "", fmt.Errorf("invalid '%s' parameter %q: must be a dot-separated field path", name, v)
+	}
+	return v, nil
+}
+
+func (ts *LBMembership) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.URL = params.GetOne("url")
+	if ts.URL.IsEmpty() {
+		return errors.New("invalid or missing 'url' parameter, must be exactly one string")
+	}
+	u, err := ts.URL.Expand(&target.Target{Name: "validation.example.com", ID: "validation"})
+	if err != nil {
+		return fmt.Errorf("invalid 'url' parameter: %v", err)
+	}
+	if err := secrets.Validate(u); err != nil {
+		return fmt.Errorf("invalid 'url' parameter: %v", err)
+	}
+	if err := teststeps.ValidateURL(secrets.Mask(u, secretPlaceholder), "url"); err != nil {
+		return err
+	}
+	ts.Token = params.GetOne("token")
+	if err := secrets.Validate(ts.Token.Raw()); err != nil {
+		return fmt.Errorf("invalid 'token' parameter: %v", err)
+	}
+	ts.Member = params.GetOne("member")
+	if ts.Member.IsEmpty() {
+		ts.Member = test.NewParam(defaultMember)
+	}
+	ts.ExpectedState = strings.TrimSpace(params.GetOne("expected_state").Raw())
+	if ts.ExpectedState == "" {
+		return errors.New("missing 'expected_state' parameter, must be absent, present or a load balancer member state")
+	}
+	if !stateRe.MatchString(ts.ExpectedState) {
+		return fmt.Errorf("invalid 'expected_state' parameter %q", ts.ExpectedState)
+	}
+	if ts.ListField, err = fieldParam(params, "list_field", "", true); err != nil {
+		return err
+	}
+	if ts.AddressField, err = fieldParam(params, "address_field", defaultAddressField, false); err != nil {
+		return err
+	}
+	if ts.StateField, err = fieldParam(params, "state_field", defaultStateField, false); err != nil {
+		return err
+	}
+	if ts.RequestTimeout, err = teststeps.DurationParam(params, "request_timeout", defaultRequestTimeout); err != nil {
+		return err
+	}
+	if ts.Insecure, err = teststeps.BoolParam(params, "insecure", false); err != nil {
+		return err
+	}
+	if ts.Timeouts, err = teststeps.NewNetworkTimeouts(params); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *LBMembership) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. LBMembership
+// cannot resume.
+func (ts *LBMembership) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *LBMembership) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new LBMembership test step.
+func New() test.TestStep {
+	return &LBMembership{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
@@ -0,0 +1,39 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package teststeps
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/liveoutput"
+	"github.com/facebookincubator/contest/pkg/target"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// OpenLiveOutput opens the sink which the output of the commands run by a
+// step on a target is written to, so that API clients can stream it while
+// the step runs. The caller must close it when done with the target. The
+// output is discarded if the emitter does not tell which job and step it
+// belongs to.
+func OpenLiveOutput(ev testevent.Emitter, target *target.Target) io.WriteCloser {
+	h, ok := ev.(interface{ Header() testevent.Header })
+	if !ok {
+		return nopWriteCloser{ioutil.Discard}
+	}
+	header := h.Header()
+	return liveoutput.Open(liveoutput.Key{
+		JobID:     header.JobID,
+		TargetID:  target.ID,
+		StepLabel: header.TestStepLabel,
+	})
+}
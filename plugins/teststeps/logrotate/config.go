@@ -0,0 +1,373 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logrotate
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fileMarker prefixes the lines separating the files in the output of the
+// fetch command.
+const fileMarker = "#@@contest "
+
+// fetchedFiles holds the content of the configuration files fetched from a
+// target, and the files of the fetched directories.
+type fetchedFiles struct {
+	files   map[string]string
+	dirs    map[string][]string
+	missing map[string]bool
+}
+
+// fetchCommand returns the command printing the given files, and the files of
+// the given directories, separated by marker lines.
+func fetchCommand(paths []string) string {
+	var quoted []string
+	for _, p := range paths {
+		quoted = append(quoted, "'"+strings.Replace(p, "'", `'\''`, -1)+"'")
+	}
+	return `for p in ` + strings.Join(quoted, " ") + `; do ` +
+		`if [ -d "$p" ]; then printf '` + fileMarker + `dir %s\n' "$p"; ` +
+		`for f in "$p"/*; do if [ -f "$f" ]; then printf '` + fileMarker + `file %s\n' "$f"; cat "$f"; echo; fi; done; ` +
+		`elif [ -f "$p" ]; then printf '` + fileMarker + `file %s\n' "$p"; cat "$p"; echo; ` +
+		`else printf '` + fileMarker + `missing %s\n' "$p"; fi; done`
+}
+
+// parseFetched adds the files in the output of the fetch command.
+func (f *fetchedFiles) parseFetched(out string) {
+	var (
+		current string
+		content strings.Builder
+		inFile  bool
+		dir     string
+	)
+	flush := func() {
+		if inFile {
+			f.files[current] = content.String()
+			if dir != "" && path.Dir(current) == dir {
+				f.dirs[dir] = append(f.dirs[dir], current)
+			}
+		}
+		inFile = false
+		content.Reset()
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(line, fileMarker) {
+			if inFile {
+				content.WriteString(line)
+				content.WriteByte('\n')
+			}
+			continue
+		}
+		flush()
+		fields := strings.SplitN(strings.TrimPrefix(line, fileMarker), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "dir":
+			dir = fields[1]
+			f.dirs[dir] = []string{}
+		case "file":
+			current, inFile = fields[1], true
+			if path.Dir(current) != dir {
+				dir = ""
+			}
+		case "missing":
+			f.missing[fields[1]] = true
+			dir = ""
+		}
+	}
+	flush()
+	for _, files := range f.dirs {
+		sort.Strings(files)
+	}
+}
+
+// tabooSuffixes are the suffixes of the files that logrotate skips when
+// including a directory.
+var tabooSuffixes = []string{".rpmsave", ".rpmorig", ".rpmnew", ".dpkg-old", ".dpkg-dist", ".dpkg-new", ".dpkg-bak", ".dpkg-del", ".cfsaved", ".ucf-old", ".ucf-dist", ".ucf-new", ".disabled", ".bak", "~", ",v", ".swp"}
+
+func taboo(name string) bool {
+	for _, s := range tabooSuffixes {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// logrotateStanza is a block of directives applying to a list of log files.
+type logrotateStanza struct {
+	patterns []string
+	// directives holds the effective directives of the block, including the
+	// global ones it inherits.
+	directives map[string]string
+}
+
+// logrotateConfig is a parsed logrotate configuration.
+type logrotateConfig struct {
+	stanzas []logrotateStanza
+	// includes holds the paths included by the parsed files, which may not be
+	// fetched yet.
+	includes []string
+}
+
+// frequencies are the mutually exclusive rotation frequency directives.
+var frequencies = map[string]bool{"hourly": true, "daily": true, "weekly": true, "monthly": true, "yearly": true}
+
+// scriptDirectives start scripts, which last until endscript.
+var scriptDirectives = map[string]bool{"postrotate": true, "prerotate": true, "firstaction": true, "lastaction": true, "preremove": true}
+
+// setDirective sets a directive, and clears the directives it overrides.
+func setDirective(directives map[string]string, name, value string) {
+	switch {
+	case frequencies[name]:
+		for f := range frequencies {
+			delete(directives, f)
+		}
+	case name == "ifempty":
+		delete(directives, "notifempty")
+	case name == "notifempty":
+		delete(directives, "ifempty")
+	case strings.HasPrefix(name, "no"):
+		delete(directives, strings.TrimPrefix(name, "no"))
+	default:
+		delete(directives, "no"+name)
+	}
+	directives[name] = value
+}
+
+// splitWords splits a line into words, honoring double and single quotes.
+func splitWords(line string) []string {
+	var (
+		words []string
+		word  strings.Builder
+		quote rune
+		in    bool
+	)
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, in = c, true
+		case c == ' ' || c == '\t':
+			if in {
+				words = append(words, word.String())
+				word.Reset()
+				in = false
+			}
+		default:
+			word.WriteRune(c)
+			in = true
+		}
+	}
+	if in {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// parseLogrotate parses the main configuration file and the files it
+// includes, in the order logrotate reads them. Included files which were not
+// fetched yet are only listed in the includes of the result.
+func parseLogrotate(main string, fetched *fetchedFiles) (*logrotateConfig, error) {
+	cfg := &logrotateConfig{}
+	global := make(map[string]string)
+	visited := make(map[string]bool)
+	if err := cfg.parseFile(main, fetched, global, visited); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *logrotateConfig) parseFile(name string, fetched *fetchedFiles, global map[string]string, visited map[string]bool) error {
+	if visited[name] {
+		return nil
+	}
+	visited[name] = true
+	content, ok := fetched.files[name]
+	if !ok {
+		if files, ok := fetched.dirs[name]; ok {
+			for _, f := range files {
+				if taboo(path.Base(f)) {
+					continue
+				}
+				if err := cfg.parseFile(f, fetched, global, visited); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if fetched.missing[name] {
+			return fmt.Errorf("included file %s does not exist", name)
+		}
+		cfg.includes = append(cfg.includes, name)
+		return nil
+	}
+
+	var (
+		block    *logrotateStanza
+		inScript bool
+		lineno   int
+	)
+	s := bufio.NewScanner(strings.NewReader(content))
+	for s.Scan() {
+		lineno++
+		line := strings.TrimSpace(s.Text())
+		if inScript {
+			if line == "endscript" {
+				inScript = false
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "}" {
+			if block == nil {
+				return fmt.Errorf("%s:%d: unexpected }", name, lineno)
+			}
+			cfg.stanzas = append(cfg.stanzas, *block)
+			block = nil
+			continue
+		}
+		if strings.HasSuffix(line, "{") {
+			if block != nil {
+				return fmt.Errorf("%s:%d: nested block", name, lineno)
+			}
+			patterns := splitWords(strings.TrimSpace(strings.TrimSuffix(line, "{")))
+			if len(patterns) == 0 {
+				return fmt.Errorf("%s:%d: block without log files", name, lineno)
+			}
+			block = &logrotateStanza{patterns: patterns, directives: make(map[string]string)}
+			for k, v := range global {
+				block.directives[k] = v
+			}
+			continue
+		}
+		words := splitWords(line)
+		directive := strings.ToLower(words[0])
+		value := strings.Join(words[1:], " ")
+		if scriptDirectives[directive] {
+			inScript = true
+			continue
+		}
+		if directive == "include" {
+			if block != nil {
+				return fmt.Errorf("%s:%d: include within a block", name, lineno)
+			}
+			if value == "" {
+				return fmt.Errorf("%s:%d: include without a path", name, lineno)
+			}
+			if err := cfg.parseFile(value, fetched, global, visited); err != nil {
+				return err
+			}
+			continue
+		}
+		if block != nil {
+			setDirective(block.directives, directive, value)
+		} else {
+			setDirective(global, directive, value)
+		}
+	}
+	if block != nil {
+		return fmt.Errorf("%s: unterminated block for %s", name, strings.Join(block.patterns, " "))
+	}
+	if inScript {
+		return fmt.Errorf("%s: unterminated script", name)
+	}
+	return nil
+}
+
+// stanzaFor returns the block matching a log path, which may be a glob
+// pattern itself, or nil. As logrotate rejects log files matched by more than
+// one block, the first one is returned.
+func (cfg *logrotateConfig) stanzaFor(logPath string) *logrotateStanza {
+	for i, st := range cfg.stanzas {
+		for _, p := range st.patterns {
+			if p == logPath {
+				return &cfg.stanzas[i]
+			}
+			if ok, err := path.Match(p, logPath); err == nil && ok {
+				return &cfg.stanzas[i]
+			}
+		}
+	}
+	return nil
+}
+
+// parseJournald parses the merged journald configuration, as printed by
+// systemd-analyze cat-config, and returns the settings of the Journal
+// section. Later assignments override earlier ones.
+func parseJournald(content string) (map[string]string, error) {
+	settings := make(map[string]string)
+	var (
+		section string
+		lineno  int
+	)
+	s := bufio.NewScanner(strings.NewReader(content))
+	for s.Scan() {
+		lineno++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: invalid section header %q", lineno, line)
+			}
+			section = line[1 : len(line)-1]
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("line %d: invalid assignment %q", lineno, line)
+		}
+		if section == "" {
+			return nil, fmt.Errorf("line %d: assignment outside of a section", lineno)
+		}
+		if section == "Journal" {
+			settings[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return settings, nil
+}
+
+// parseSize parses an integer with an optional size suffix, e.g. 100k, 10M
+// or 1G, as used by the logrotate size directives and the journald limits.
+func parseSize(s string) (int64, bool) {
+	multiplier := int64(1)
+	if n := len(s); n > 1 {
+		switch s[n-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, false
+	}
+	return v * multiplier, true
+}
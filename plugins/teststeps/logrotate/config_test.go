@@ -0,0 +1,383 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logrotate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const mainConfig = "/etc/logrotate.conf"
+
+func newFetched(files map[string]string, dirs map[string][]string, missing ...string) *fetchedFiles {
+	f := &fetchedFiles{files: files, dirs: dirs, missing: make(map[string]bool)}
+	if f.dirs == nil {
+		f.dirs = make(map[string][]string)
+	}
+	for _, m := range missing {
+		f.missing[m] = true
+	}
+	return f
+}
+
+func TestParseFetched(t *testing.T) {
+	out := "#@@contest file /etc/logrotate.conf\n" +
+		"weekly\n" +
+		"include /etc/logrotate.d\n" +
+		"\n" +
+		"#@@contest dir /etc/logrotate.d\n" +
+		"#@@contest file /etc/logrotate.d/rsyslog\n" +
+		"/var/log/syslog {\n" +
+		"}\n" +
+		"\n" +
+		"#@@contest file /etc/logrotate.d/nginx\n" +
+		"/var/log/nginx/*.log {\n" +
+		"}\n" +
+		"\n" +
+		"#@@contest dir /etc/logrotate.empty.d\n" +
+		"#@@contest missing /etc/logrotate.extra\n" +
+		"#@@contest file /etc/logrotate.local\n" +
+		"daily\n" +
+		"\n"
+	fetched := newFetched(make(map[string]string), nil)
+	fetched.parseFetched(out)
+	require.Equal(t, map[string]string{
+		"/etc/logrotate.conf":      "weekly\ninclude /etc/logrotate.d\n\n",
+		"/etc/logrotate.d/rsyslog": "/var/log/syslog {\n}\n\n",
+		"/etc/logrotate.d/nginx":   "/var/log/nginx/*.log {\n}\n\n",
+		"/etc/logrotate.local":     "daily\n\n\n",
+	}, fetched.files)
+	require.Equal(t, map[string][]string{
+		"/etc/logrotate.d":       {"/etc/logrotate.d/nginx", "/etc/logrotate.d/rsyslog"},
+		"/etc/logrotate.empty.d": {},
+	}, fetched.dirs)
+	require.Equal(t, map[string]bool{"/etc/logrotate.extra": true}, fetched.missing)
+}
+
+func TestParseLogrotate(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		files    map[string]string
+		dirs     map[string][]string
+		missing  []string
+		stanzas  []logrotateStanza
+		includes []string
+	}{
+		{
+			name: "global directives are inherited",
+			files: map[string]string{mainConfig: `# see "man logrotate" for details
+weekly
+rotate 4
+create
+
+/var/log/wtmp {
+    missingok
+    size 1M
+}
+`},
+			stanzas: []logrotateStanza{
+				{patterns: []string{"/var/log/wtmp"}, directives: map[string]string{"weekly": "", "rotate": "4", "create": "", "missingok": "", "size": "1M"}},
+			},
+		},
+		{
+			name: "per-stanza overrides",
+			files: map[string]string{mainConfig: `weekly
+rotate 4
+compress
+notifempty
+nomissingok
+create 0640 root adm
+
+/var/log/wtmp {
+    monthly
+    rotate 1
+    nocompress
+    ifempty
+    missingok
+    create 0664 root utmp
+}
+/var/log/btmp {
+}
+`},
+			stanzas: []logrotateStanza{
+				{patterns: []string{"/var/log/wtmp"}, directives: map[string]string{"monthly": "", "rotate": "1", "nocompress": "", "ifempty": "", "missingok": "", "create": "0664 root utmp"}},
+				{patterns: []string{"/var/log/btmp"}, directives: map[string]string{"weekly": "", "rotate": "4", "compress": "", "notifempty": "", "nomissingok": "", "create": "0640 root adm"}},
+			},
+		},
+		{
+			name: "global directives only apply to the blocks which follow",
+			files: map[string]string{mainConfig: `/var/log/a.log {
+}
+daily
+nocompress
+/var/log/b.log {
+}
+compress
+/var/log/c.log {
+}
+`},
+			stanzas: []logrotateStanza{
+				{patterns: []string{"/var/log/a.log"}, directives: map[string]string{}},
+				{patterns: []string{"/var/log/b.log"}, directives: map[string]string{"daily": "", "nocompress": ""}},
+				{patterns: []string{"/var/log/c.log"}, directives: map[string]string{"daily": "", "compress": ""}},
+			},
+		},
+		{
+			name: "included directory",
+			files: map[string]string{
+				mainConfig: `weekly
+include /etc/logrotate.d
+/var/log/wtmp {
+}
+`,
+				// included files are read in order, and their global
+				// directives apply to the files which follow
+				"/etc/logrotate.d/apt": `compress
+/var/log/apt/term.log {
+    rotate 12
+}
+`,
+				"/etc/logrotate.d/nginx": `/var/log/nginx/*.log {
+    daily
+    nocompress
+}
+`,
+				"/etc/logrotate.d/nginx.dpkg-old": `/var/log/nginx/*.log {
+    hourly
+}
+`,
+				"/etc/logrotate.d/rsyslog~": `/var/log/syslog {
+}
+`,
+			},
+			dirs: map[string][]string{"/etc/logrotate.d": {
+				"/etc/logrotate.d/apt", "/etc/logrotate.d/nginx", "/etc/logrotate.d/nginx.dpkg-old", "/etc/logrotate.d/rsyslog~",
+			}},
+			stanzas: []logrotateStanza{
+				{patterns: []string{"/var/log/apt/term.log"}, directives: map[string]string{"weekly": "", "compress": "", "rotate": "12"}},
+				{patterns: []string{"/var/log/nginx/*.log"}, directives: map[string]string{"daily": "", "nocompress": ""}},
+				{patterns: []string{"/var/log/wtmp"}, directives: map[string]string{"weekly": "", "compress": ""}},
+			},
+		},
+		{
+			name: "included files not fetched yet",
+			files: map[string]string{mainConfig: `include /etc/logrotate.d
+include /etc/logrotate.local
+/var/log/wtmp {
+}
+`},
+			stanzas: []logrotateStanza{
+				{patterns: []string{"/var/log/wtmp"}, directives: map[string]string{}},
+			},
+			includes: []string{"/etc/logrotate.d", "/etc/logrotate.local"},
+		},
+		{
+			name: "included files are read once",
+			files: map[string]string{
+				mainConfig: `include /etc/logrotate.local
+include /etc/logrotate.local
+`,
+				"/etc/logrotate.local": `include /etc/logrotate.conf
+/var/log/local.log {
+}
+`,
+			},
+			stanzas: []logrotateStanza{
+				{patterns: []string{"/var/log/local.log"}, directives: map[string]string{}},
+			},
+		},
+		{
+			name: "scripts are skipped",
+			files: map[string]string{mainConfig: `/var/log/nginx/*.log {
+    daily
+    sharedscripts
+    postrotate
+        if [ -f /run/nginx.pid ]; then
+            kill -USR1 $(cat /run/nginx.pid)
+        fi
+        include /etc/nowhere
+        }
+    endscript
+    rotate 7
+}
+`},
+			stanzas: []logrotateStanza{
+				{patterns: []string{"/var/log/nginx/*.log"}, directives: map[string]string{"daily": "", "sharedscripts": "", "rotate": "7"}},
+			},
+		},
+		{
+			name: "patterns and values",
+			files: map[string]string{mainConfig: `"/var/log/my app/*.log" /var/log/other.log {
+    Daily
+    su   root	adm
+    dateformat '-%Y %m'
+}
+`},
+			stanzas: []logrotateStanza{
+				{patterns: []string{"/var/log/my app/*.log", "/var/log/other.log"}, directives: map[string]string{"daily": "", "su": "root adm", "dateformat": "-%Y %m"}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := parseLogrotate(mainConfig, newFetched(tc.files, tc.dirs, tc.missing...))
+			require.NoError(t, err)
+			require.Equal(t, tc.stanzas, cfg.stanzas)
+			require.Equal(t, tc.includes, cfg.includes)
+		})
+	}
+}
+
+func TestParseLogrotateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		files   map[string]string
+		missing []string
+		err     string
+	}{
+		{
+			name:  "unexpected closing brace",
+			files: map[string]string{mainConfig: "weekly\n}\n"},
+			err:   "/etc/logrotate.conf:2: unexpected }",
+		},
+		{
+			name:  "nested block",
+			files: map[string]string{mainConfig: "/var/log/a.log {\n    /var/log/b.log {\n    }\n}\n"},
+			err:   "/etc/logrotate.conf:2: nested block",
+		},
+		{
+			name:  "block without log files",
+			files: map[string]string{mainConfig: "daily\n{\n}\n"},
+			err:   "/etc/logrotate.conf:2: block without log files",
+		},
+		{
+			name:  "include within a block",
+			files: map[string]string{mainConfig: "/var/log/a.log {\n    include /etc/logrotate.d\n}\n"},
+			err:   "/etc/logrotate.conf:2: include within a block",
+		},
+		{
+			name:  "include without a path",
+			files: map[string]string{mainConfig: "include\n"},
+			err:   "/etc/logrotate.conf:1: include without a path",
+		},
+		{
+			name:  "unterminated block",
+			files: map[string]string{mainConfig: "/var/log/a.log /var/log/b.log {\n    daily\n"},
+			err:   "/etc/logrotate.conf: unterminated block for /var/log/a.log /var/log/b.log",
+		},
+		{
+			name:  "unterminated script",
+			files: map[string]string{mainConfig: "/var/log/a.log {\n    postrotate\n        true\n}\n"},
+			err:   "/etc/logrotate.conf: unterminated block for /var/log/a.log",
+		},
+		{
+			name:  "unterminated global script",
+			files: map[string]string{mainConfig: "firstaction\n    true\n"},
+			err:   "/etc/logrotate.conf: unterminated script",
+		},
+		{
+			name:    "missing included file",
+			files:   map[string]string{mainConfig: "include /etc/logrotate.local\n"},
+			missing: []string{"/etc/logrotate.local"},
+			err:     "included file /etc/logrotate.local does not exist",
+		},
+		{
+			name: "error in an included file",
+			files: map[string]string{
+				mainConfig:             "include /etc/logrotate.local\n",
+				"/etc/logrotate.local": "\n/var/log/a.log {\n",
+			},
+			err: "/etc/logrotate.local: unterminated block for /var/log/a.log",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseLogrotate(mainConfig, newFetched(tc.files, nil, tc.missing...))
+			require.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestStanzaFor(t *testing.T) {
+	cfg := logrotateConfig{stanzas: []logrotateStanza{
+		{patterns: []string{"/var/log/wtmp", "/var/log/btmp"}},
+		{patterns: []string{"/var/log/nginx/*.log"}},
+		{patterns: []string{"/var/log/nginx/access.log"}},
+	}}
+	for _, tc := range []struct {
+		path   string
+		stanza int
+	}{
+		{"/var/log/btmp", 0},
+		{"/var/log/nginx/access.log", 1},
+		{"/var/log/nginx/*.log", 1},
+		{"/var/log/nginx/old/access.log", -1},
+		{"/var/log/syslog", -1},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			st := cfg.stanzaFor(tc.path)
+			if tc.stanza < 0 {
+				require.Nil(t, st)
+				return
+			}
+			require.Equal(t, &cfg.stanzas[tc.stanza], st)
+		})
+	}
+}
+
+func TestParseJournald(t *testing.T) {
+	settings, err := parseJournald(`# /etc/systemd/journald.conf
+[Journal]
+Storage=persistent
+SystemMaxUse=1G
+; comment
+
+# /etc/systemd/journald.conf.d/size.conf
+[Journal]
+SystemMaxUse = 500M
+[Other]
+Storage=volatile
+`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"Storage": "persistent", "SystemMaxUse": "500M"}, settings)
+
+	for _, tc := range []struct {
+		content string
+		err     string
+	}{
+		{"[Journal\nStorage=auto\n", `line 1: invalid section header "[Journal"`},
+		{"[Journal]\nStorage\n", `line 2: invalid assignment "Storage"`},
+		{"[Journal]\n=auto\n", `line 2: invalid assignment "=auto"`},
+		{"Storage=auto\n", "line 1: assignment outside of a section"},
+	} {
+		_, err := parseJournald(tc.content)
+		require.EqualError(t, err, tc.err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		size int64
+		ok   bool
+	}{
+		{"100", 100, true},
+		{"100k", 100 << 10, true},
+		{"100K", 100 << 10, true},
+		{"10M", 10 << 20, true},
+		{"1G", 1 << 30, true},
+		{"2T", 2 << 40, true},
+		{"k", 0, false},
+		{"10m", 0, false},
+		{"-1", 0, false},
+		{"", 0, false},
+	} {
+		t.Run(tc.s, func(t *testing.T) {
+			size, ok := parseSize(tc.s)
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.size, size)
+		})
+	}
+}
@@ -0,0 +1,427 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logrotate
+
+// The LogRotate plugin verifies over SSH that the logs of each target are
+// rotated. In logrotate mode, the configuration is validated with
+// `logrotate --debug`, and each of the log_path parameters must be covered by
+// a block of the configuration, or of the files it includes, satisfying the
+// rule parameters. In journald mode, the merged journald configuration must
+// satisfy the rule parameters.
+//
+// Rules are a directive or setting name, optionally followed by its expected
+// value, e.g. "compress", "daily", "rotate >=7", "maxsize <=100M" or
+// "SystemMaxUse <=1G". Values are compared as sizes when they have one.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "LogRotate"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventLogRotationOK      = event.Name("TargetLogRotationOK")
+	EventLogRotationMissing = event.Name("TargetLogRotationMissing")
+	EventLogRotationInvalid = event.Name("TargetLogRotationInvalid")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventLogRotationOK, EventLogRotationMissing, EventLogRotationInvalid}
+
+const (
+	modeLogrotate = "logrotate"
+	modeJournald  = "journald"
+
+	defaultConfig = "/etc/logrotate.conf"
+	// maxIncludeDepth bounds the rounds of fetching of the included files.
+	maxIncludeDepth = 5
+
+	journaldCommand = "systemd-analyze cat-config systemd/journald.conf 2>/dev/null || " +
+		"cat /etc/systemd/journald.conf /etc/systemd/journald.conf.d/*.conf 2>/dev/null"
+)
+
+// operators are the comparison operators of the rule values, longest first
+// so that "<=" is not parsed as "<".
+var operators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+var (
+	logrotateDirectiveRe = regexp.MustCompile(`^[a-z]+$`)
+	journaldSettingRe    = regexp.MustCompile(`^[A-Za-z]+$`)
+)
+
+// rule is a directive, or setting, that the configuration must contain,
+// optionally with a value.
+type rule struct {
+	name  string
+	op    string
+	value string
+}
+
+func parseRule(s string, nameRe *regexp.Regexp) (rule, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return rule{}, fmt.Errorf("invalid rule %q: must be a name, optionally followed by a value", s)
+	}
+	if !nameRe.MatchString(fields[0]) {
+		return rule{}, fmt.Errorf("invalid rule %q: invalid name %q", s, fields[0])
+	}
+	r := rule{name: fields[0]}
+	if len(fields) == 1 {
+		return r, nil
+	}
+	r.op, r.value = "==", fields[1]
+	for _, op := range operators {
+		if strings.HasPrefix(r.value, op) {
+			r.op, r.value = op, r.value[len(op):]
+			break
+		}
+	}
+	if r.value == "" {
+		return rule{}, fmt.Errorf("invalid rule %q: missing value", s)
+	}
+	if _, ok := parseSize(r.value); !ok && r.op != "==" && r.op != "!=" {
+		return rule{}, fmt.Errorf("invalid rule %q: %s requires a numeric value", s, r.op)
+	}
+	return r, nil
+}
+
+// check returns why the settings do not satisfy the rule, or an empty string.
+func (r rule) check(settings map[string]string) string {
+	actual, ok := settings[r.name]
+	if !ok {
+		return fmt.Sprintf("%s is not set", r.name)
+	}
+	if r.op == "" {
+		return ""
+	}
+	want, wantOK := parseSize(r.value)
+	got, gotOK := parseSize(actual)
+	var matches bool
+	switch {
+	case wantOK && gotOK:
+		switch r.op {
+		case "==":
+			matches = got == want
+		case "!=":
+			matches = got != want
+		case "<":
+			matches = got < want
+		case "<=":
+			matches = got <= want
+		case ">":
+			matches = got > want
+		case ">=":
+			matches = got >= want
+		}
+	case r.op == "==":
+		matches = actual == r.value
+	case r.op == "!=":
+		matches = actual != r.value
+	}
+	if matches {
+		return ""
+	}
+	return fmt.Sprintf("%s is %q, expected %s", r.name, actual, r.String()[len(r.name)+1:])
+}
+
+func (r rule) String() string {
+	switch r.op {
+	case "":
+		return r.name
+	case "==":
+		return r.name + " " + r.value
+	}
+	return r.name + " " + r.op + r.value
+}
+
+// problem is a log which is not rotated as required.
+type problem struct {
+	Path    string `json:",omitempty"`
+	Problem string
+}
+
+// logRotationPayload is the payload of the events emitted by this step.
+type logRotationPayload struct {
+	Mode     string
+	Problems []problem `json:",omitempty"`
+	// Error is set when the configuration is invalid.
+	Error string `json:",omitempty"`
+}
+
+// LogRotate verifies the log rotation configuration of targets.
+type LogRotate struct {
+	*teststeps.SSHParams
+	Mode     string
+	Config   string
+	LogPaths []string
+	Rules    []rule
+}
+
+// Name returns the plugin name.
+func (ts LogRotate) Name() string {
+	return Name
+}
+
+// invalid emits the event of an invalid configuration and returns the error
+// failing the target.
+func (ts *LogRotate) invalid(ev testevent.Emitter, target *target.Target, err error) error {
+	if emitErr := teststeps.EmitTargetEvent(ev, EventLogRotationInvalid, target, logRotationPayload{Mode: ts.Mode, Error: err.Error()}); emitErr != nil {
+		return emitErr
+	}
+	return fmt.Errorf("invalid %s configuration: %v", ts.Mode, err)
+}
+
+// fetchLogrotate fetches and parses the logrotate configuration of a target,
+// including the files it includes.
+func (ts *LogRotate) fetchLogrotate(cancel, pause <-chan struct{}, target *target.Target) (*logrotateConfig, error) {
+	fetched := &fetchedFiles{files: make(map[string]string), dirs: make(map[string][]string), missing: make(map[string]bool)}
+	pending := []string{ts.Config}
+	for depth := 0; ; depth++ {
+		cmd := fetchCommand(pending)
+		result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+		if err != nil {
+			return nil, err
+		}
+		if result.ExitStatus != 0 {
+			return nil, fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+		}
+		fetched.parseFetched(string(result.Stdout))
+		if fetched.missing[ts.Config] {
+			return nil, fmt.Errorf("configuration file %s does not exist", ts.Config)
+		}
+		cfg, err := parseLogrotate(ts.Config, fetched)
+		if err != nil {
+			return nil, err
+		}
+		if len(cfg.includes) == 0 {
+			return cfg, nil
+		}
+		if depth == maxIncludeDepth {
+			return nil, fmt.Errorf("includes nested deeper than %d levels", maxIncludeDepth)
+		}
+		pending = cfg.includes
+	}
+}
+
+func (ts *LogRotate) checkLogrotate(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	cmd := "logrotate --debug --state /dev/null " + "'" + strings.Replace(ts.Config, "'", `'\''`, -1) + "'"
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	switch result.ExitStatus {
+	case 0:
+	case 127:
+		return fmt.Errorf("logrotate is not installed: %s", bytes.TrimSpace(result.Stderr))
+	default:
+		var errs []string
+		for _, line := range strings.Split(string(result.Stderr), "\n") {
+			if strings.HasPrefix(line, "error:") {
+				errs = append(errs, strings.TrimSpace(strings.TrimPrefix(line, "error:")))
+			}
+		}
+		if len(errs) == 0 {
+			errs = append(errs, fmt.Sprintf("logrotate exited with status %d", result.ExitStatus))
+		}
+		return ts.invalid(ev, target, errors.New(strings.Join(errs, "; ")))
+	}
+
+	cfg, err := ts.fetchLogrotate(cancel, pause, target)
+	if err != nil {
+		return ts.invalid(ev, target, err)
+	}
+	var problems []problem
+	for _, logPath := range ts.LogPaths {
+		st := cfg.stanzaFor(logPath)
+		if st == nil {
+			problems = append(problems, problem{Path: logPath, Problem: "no rotation configured"})
+			continue
+		}
+		for _, r := range ts.Rules {
+			if p := r.check(st.directives); p != "" {
+				problems = append(problems, problem{Path: logPath, Problem: p})
+			}
+		}
+	}
+	return ts.report(ev, target, problems)
+}
+
+func (ts *LogRotate) checkJournald(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	result, err := ts.RunSSHCommand(cancel, pause, target, journaldCommand)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("cannot read the journald configuration: command '%s' exited with status %d: %s", journaldCommand, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	settings, err := parseJournald(string(result.Stdout))
+	if err != nil {
+		return ts.invalid(ev, target, err)
+	}
+	var problems []problem
+	for _, r := range ts.Rules {
+		if p := r.check(settings); p != "" {
+			problems = append(problems, problem{Problem: p})
+		}
+	}
+	return ts.report(ev, target, problems)
+}
+
+// report emits the result of the checks of a target.
+func (ts *LogRotate) report(ev testevent.Emitter, target *target.Target, problems []problem) error {
+	payload := logRotationPayload{Mode: ts.Mode, Problems: problems}
+	if len(problems) == 0 {
+		return teststeps.EmitTargetEvent(ev, EventLogRotationOK, target, payload)
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventLogRotationMissing, target, payload); err != nil {
+		return err
+	}
+	var msgs []string
+	for _, p := range problems {
+		if p.Path != "" {
+			msgs = append(msgs, p.Path+": "+p.Problem)
+		} else {
+			msgs = append(msgs, p.Problem)
+		}
+	}
+	return fmt.Errorf("log rotation misconfigured: %s", strings.Join(msgs, ", "))
+}
+
+// Run executes the log rotation check step.
+func (ts *LogRotate) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		if ts.Mode == modeJournald {
+			return ts.checkJournald(cancel, pause, target, ev)
+		}
+		return ts.checkLogrotate(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the step normally, as it only reads the configuration of the
+// targets.
+func (ts *LogRotate) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *LogRotate) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	ts.Mode = modeLogrotate
+	if m := params.GetOne("mode"); !m.IsEmpty() {
+		ts.Mode = m.Raw()
+	}
+	nameRe := logrotateDirectiveRe
+	switch ts.Mode {
+	case modeLogrotate:
+	case modeJournald:
+		nameRe = journaldSettingRe
+	default:
+		return fmt.Errorf("invalid 'mode' parameter %q: must be %s or %s", ts.Mode, modeLogrotate, modeJournald)
+	}
+
+	ts.Rules = nil
+	seen := make(map[string]bool)
+	for _, p := range params.Get("rule") {
+		r, err := parseRule(p.Raw(), nameRe)
+		if err != nil {
+			return fmt.Errorf("invalid 'rule' parameter: %v", err)
+		}
+		if seen[r.name] {
+			return fmt.Errorf("invalid 'rule' parameter: more than one rule for %s", r.name)
+		}
+		seen[r.name] = true
+		ts.Rules = append(ts.Rules, r)
+	}
+	sort.Slice(ts.Rules, func(i, j int) bool { return ts.Rules[i].name < ts.Rules[j].name })
+
+	ts.LogPaths = nil
+	ts.Config = ""
+	if ts.Mode == modeJournald {
+		if len(params.Get("log_path")) != 0 || !params.GetOne("config").IsEmpty() {
+			return errors.New("the 'log_path' and 'config' parameters are not supported in journald mode")
+		}
+		if len(ts.Rules) == 0 {
+			return errors.New("missing 'rule' parameter, at least one rule must be specified in journald mode")
+		}
+		return nil
+	}
+	ts.Config = defaultConfig
+	if c := params.GetOne("config"); !c.IsEmpty() {
+		ts.Config = c.Raw()
+	}
+	if !path.IsAbs(ts.Config) {
+		return fmt.Errorf("invalid 'config' parameter %q: must be an absolute path", ts.Config)
+	}
+	paths := make(map[string]bool)
+	for _, p := range params.Get("log_path") {
+		logPath := p.Raw()
+		if !path.IsAbs(logPath) || path.Clean(logPath) != logPath {
+			return fmt.Errorf("invalid 'log_path' parameter %q: must be a clean absolute path", logPath)
+		}
+		if _, err := path.Match(logPath, ""); err != nil {
+			return fmt.Errorf("invalid 'log_path' parameter %q: %v", logPath, err)
+		}
+		if paths[logPath] {
+			return fmt.Errorf("invalid 'log_path' parameter: %s specified more than once", logPath)
+		}
+		paths[logPath] = true
+		ts.LogPaths = append(ts.LogPaths, logPath)
+	}
+	if len(ts.LogPaths) == 0 {
+		return errors.New("missing 'log_path' parameter, at least one log path must be specified")
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *LogRotate) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. LogRotate cannot
+// resume.
+func (ts *LogRotate) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *LogRotate) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new LogRotate test step.
+func New() test.TestStep {
+	return &LogRotate{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
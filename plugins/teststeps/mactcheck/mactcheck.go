@@ -0,0 +1,281 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package mactcheck
+
+// The MACTCheck plugin reads the mandatory access control mode of each target
+// over SSH, either SELinux or AppArmor, and fails the targets which are not in
+// the expected mode. The most recent denials found in the kernel log are
+// reported along with the mode, and can optionally fail the targets too.
+//
+// The SELinux mode is read with getenforce, or from selinuxfs. The AppArmor
+// mode is derived from the loaded profiles: enforce if all of them are
+// enforced, complain if all of them are in complain mode, and mixed otherwise.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "MACTCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventMacModeOK      = event.Name("TargetMacModeOK")
+	EventMacModeWrong   = event.Name("TargetMacModeWrong")
+	EventMacDenialsSeen = event.Name("TargetMacDenialsSeen")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventMacModeOK, EventMacModeWrong, EventMacDenialsSeen}
+
+// supported mandatory access control systems.
+const (
+	MacSELinux  = "selinux"
+	MacAppArmor = "apparmor"
+)
+
+// validModes are the modes which can be expected for each system.
+var validModes = map[string][]string{
+	MacSELinux:  {"enforcing", "permissive", "disabled"},
+	MacAppArmor: {"enforce", "complain", "disabled"},
+}
+
+const (
+	// maxDenials is the number of recent denials reported.
+	maxDenials = 20
+	// separator separates the mode from the denials in the command output.
+	separator = "---"
+)
+
+var (
+	// commands print the mode information, the separator, and the recent
+	// denials.
+	commands = map[string]string{
+		MacSELinux: `if command -v getenforce >/dev/null 2>&1; then getenforce
+elif [ -f /sys/fs/selinux/enforce ]; then if [ "$(cat /sys/fs/selinux/enforce)" = 1 ]; then echo Enforcing; else echo Permissive; fi
+else echo Disabled; fi
+echo ` + separator + `
+dmesg 2>/dev/null | grep -i 'avc: *denied' | tail -n ` + strconv.Itoa(maxDenials),
+		MacAppArmor: `echo "enabled=$(cat /sys/module/apparmor/parameters/enabled 2>/dev/null)"
+cat /sys/kernel/security/apparmor/profiles 2>/dev/null
+echo ` + separator + `
+dmesg 2>/dev/null | grep 'apparmor="DENIED"' | tail -n ` + strconv.Itoa(maxDenials),
+	}
+	// profileRe matches the lines of the AppArmor profiles file, e.g.
+	// "/usr/sbin/ntpd (enforce)".
+	profileRe = regexp.MustCompile(`^(.+) \(([a-z]+)\)$`)
+)
+
+// macEventPayload is the payload of the events emitted by this step.
+type macEventPayload struct {
+	Mac          string
+	ExpectedMode string
+	Mode         string
+	Profiles     map[string]int `json:",omitempty"`
+	Denials      []string       `json:",omitempty"`
+}
+
+// MACTCheck verifies the mandatory access control mode of the targets.
+type MACTCheck struct {
+	*teststeps.SSHParams
+	Mac           string
+	ExpectedMode  string
+	FailOnDenials bool
+	Sudo          bool
+}
+
+// Name returns the plugin name.
+func (ts MACTCheck) Name() string {
+	return Name
+}
+
+// splitOutput returns the mode and denials sections of the command output.
+func splitOutput(out []byte) (mode []string, denials []string) {
+	inDenials := false
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == separator && !inDenials:
+			inDenials = true
+		case line == "":
+		case inDenials:
+			denials = append(denials, line)
+		default:
+			mode = append(mode, line)
+		}
+	}
+	return mode, denials
+}
+
+// parseSELinux returns the SELinux mode from the getenforce output.
+func parseSELinux(lines []string) (string, error) {
+	if len(lines) != 1 {
+		return "", fmt.Errorf("cannot parse SELinux mode from %q", strings.Join(lines, "\n"))
+	}
+	return strings.ToLower(lines[0]), nil
+}
+
+// parseAppArmor returns the AppArmor mode, and the number of profiles in each
+// mode.
+func parseAppArmor(lines []string) (string, map[string]int, error) {
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "enabled=") {
+		return "", nil, fmt.Errorf("cannot parse AppArmor status from %q", strings.Join(lines, "\n"))
+	}
+	if strings.TrimPrefix(lines[0], "enabled=") != "Y" {
+		return "disabled", nil, nil
+	}
+	profiles := make(map[string]int)
+	for _, line := range lines[1:] {
+		m := profileRe.FindStringSubmatch(line)
+		if m == nil {
+			return "", nil, fmt.Errorf("cannot parse AppArmor profile %q", line)
+		}
+		profiles[m[2]]++
+	}
+	enforced := profiles["enforce"] + profiles["kill"]
+	switch {
+	case enforced == 0 && profiles["complain"] == 0:
+		// enabled, but no profile is loaded
+		return "unconfined", profiles, nil
+	case profiles["complain"] == 0:
+		return "enforce", profiles, nil
+	case enforced == 0:
+		return "complain", profiles, nil
+	default:
+		return "mixed", profiles, nil
+	}
+}
+
+func (ts *MACTCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	cmd := commands[ts.Mac]
+	if ts.Sudo {
+		cmd = "sudo -n sh -c " + shellquote.Join(cmd)
+	}
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command exited with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	modeLines, denials := splitOutput(result.Stdout)
+	payload := macEventPayload{Mac: ts.Mac, ExpectedMode: ts.ExpectedMode, Denials: denials}
+	switch ts.Mac {
+	case MacSELinux:
+		payload.Mode, err = parseSELinux(modeLines)
+	case MacAppArmor:
+		payload.Mode, payload.Profiles, err = parseAppArmor(modeLines)
+	}
+	if err != nil {
+		return err
+	}
+	if payload.Mode != ts.ExpectedMode {
+		if err := teststeps.EmitTargetEvent(ev, EventMacModeWrong, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("%s mode is %s, expected %s", ts.Mac, payload.Mode, ts.ExpectedMode)
+	}
+	if ts.FailOnDenials && len(denials) > 0 {
+		if err := teststeps.EmitTargetEvent(ev, EventMacDenialsSeen, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("%s reported %d recent denials, the last one being: %s", ts.Mac, len(denials), denials[len(denials)-1])
+	}
+	return teststeps.EmitTargetEvent(ev, EventMacModeOK, target, payload)
+}
+
+// Run executes the mandatory access control check step.
+func (ts *MACTCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as it only reads the mandatory access
+// control status.
+func (ts *MACTCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *MACTCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	ts.Mac = strings.ToLower(params.GetOne("mac").Raw())
+	modes, ok := validModes[ts.Mac]
+	if !ok {
+		return fmt.Errorf("invalid or missing 'mac' parameter %q, must be %s or %s", ts.Mac, MacSELinux, MacAppArmor)
+	}
+	ts.ExpectedMode = strings.ToLower(params.GetOne("expected_mode").Raw())
+	if ts.ExpectedMode == "" {
+		ts.ExpectedMode = modes[0]
+	}
+	valid := false
+	for _, m := range modes {
+		if ts.ExpectedMode == m {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid 'expected_mode' parameter %q for %s, must be one of %s", ts.ExpectedMode, ts.Mac, strings.Join(modes, ", "))
+	}
+	if ts.FailOnDenials, err = teststeps.BoolParam(params, "fail_on_denials", false); err != nil {
+		return err
+	}
+	if ts.Sudo, err = teststeps.BoolParam(params, "sudo", false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *MACTCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. MACTCheck cannot
+// resume.
+func (ts *MACTCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *MACTCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new MACTCheck test step.
+func New() test.TestStep {
+	return &MACTCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
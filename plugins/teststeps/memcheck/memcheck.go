@@ -0,0 +1,331 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package memcheck
+
+// The MemCheck plugin reads the swap, hugepages and memory overcommit
+// settings of each target over SSH, and fails the targets whose settings do
+// not match the expected values with a TargetMemConfigWrong event.
+//
+// Numeric settings are compared with an optional operator, e.g. "0", "!=0",
+// ">=512" or "<=10". The swap setting is either "enabled" or "disabled".
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "MemCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventMemConfigOK    = event.Name("TargetMemConfigOK")
+	EventMemConfigWrong = event.Name("TargetMemConfigWrong")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventMemConfigOK, EventMemConfigWrong}
+
+// command prints the memory information, followed by the VM settings and the
+// transparent hugepages mode in the same "key: value" format.
+const command = `cat /proc/meminfo && for s in overcommit_memory overcommit_ratio swappiness; do echo "vm.$s: $(cat /proc/sys/vm/$s)"; done && ` +
+	`echo "thp: $(cat /sys/kernel/mm/transparent_hugepage/enabled 2>/dev/null)"`
+
+// setting describes how a setting is read from the command output.
+type setting struct {
+	// key is the key of the setting in the command output.
+	key string
+	// kB is set for the sizes in /proc/meminfo.
+	kB bool
+}
+
+// the numeric settings that can be checked, by parameter name.
+var numericSettings = map[string]setting{
+	"hugepages":         {key: "HugePages_Total"},
+	"hugepages_free":    {key: "HugePages_Free"},
+	"hugepage_size_kb":  {key: "Hugepagesize", kB: true},
+	"swap_total_kb":     {key: "SwapTotal", kB: true},
+	"overcommit_memory": {key: "vm.overcommit_memory"},
+	"overcommit_ratio":  {key: "vm.overcommit_ratio"},
+	"swappiness":        {key: "vm.swappiness"},
+}
+
+var thpModes = map[string]bool{"always": true, "madvise": true, "never": true}
+
+// operators are the comparison operators of numeric expectations, longest
+// first so that "<=" is not parsed as "<".
+var operators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// expectation is an expected value of a numeric setting.
+type expectation struct {
+	op    string
+	value int64
+}
+
+func parseExpectation(s string) (expectation, error) {
+	e := expectation{op: "=="}
+	s = strings.TrimSpace(s)
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			e.op, s = op, strings.TrimSpace(s[len(op):])
+			break
+		}
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return e, fmt.Errorf("invalid value %q: must be an integer with an optional comparison operator", s)
+	}
+	e.value = v
+	return e, nil
+}
+
+func (e expectation) matches(v int64) bool {
+	switch e.op {
+	case "!=":
+		return v != e.value
+	case "<":
+		return v < e.value
+	case "<=":
+		return v <= e.value
+	case ">":
+		return v > e.value
+	case ">=":
+		return v >= e.value
+	}
+	return v == e.value
+}
+
+func (e expectation) String() string {
+	if e.op == "==" {
+		return strconv.FormatInt(e.value, 10)
+	}
+	return e.op + strconv.FormatInt(e.value, 10)
+}
+
+// parseOutput parses the "key: value" lines of the command output.
+func parseOutput(out []byte) map[string]string {
+	values := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return values
+}
+
+// numericValue returns the value of a numeric setting from the parsed output.
+func numericValue(values map[string]string, s setting) (int64, error) {
+	raw, ok := values[s.key]
+	if !ok {
+		return 0, fmt.Errorf("%s not found", s.key)
+	}
+	if s.kB {
+		raw = strings.TrimSpace(strings.TrimSuffix(raw, "kB"))
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %s %q: %v", s.key, values[s.key], err)
+	}
+	return v, nil
+}
+
+// thpMode returns the selected transparent hugepages mode, shown in brackets,
+// e.g. "always [madvise] never".
+func thpMode(raw string) string {
+	start, end := strings.Index(raw, "["), strings.Index(raw, "]")
+	if start < 0 || end < start {
+		return ""
+	}
+	return raw[start+1 : end]
+}
+
+// mismatch is a setting which does not have the expected value.
+type mismatch struct {
+	Setting  string
+	Expected string
+	Actual   string
+}
+
+// memEventPayload is the payload of the events emitted by this step.
+type memEventPayload struct {
+	// Settings are the values of the checked settings.
+	Settings   map[string]string
+	Mismatches []mismatch `json:",omitempty"`
+}
+
+// MemCheck verifies the memory configuration of the targets.
+type MemCheck struct {
+	*teststeps.SSHParams
+	// Swap, if not empty, is either "enabled" or "disabled".
+	Swap                 string
+	TransparentHugepages string
+	Expectations         map[string]expectation
+}
+
+// Name returns the plugin name.
+func (ts MemCheck) Name() string {
+	return Name
+}
+
+func (ts *MemCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	result, err := ts.RunSSHCommand(cancel, pause, target, command)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command '%s' exited with status %d: %s", command, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	values := parseOutput(result.Stdout)
+	payload := memEventPayload{Settings: make(map[string]string)}
+	compare := func(name, expected, actual string) {
+		payload.Settings[name] = actual
+		if expected != actual {
+			payload.Mismatches = append(payload.Mismatches, mismatch{Setting: name, Expected: expected, Actual: actual})
+		}
+	}
+	if ts.Swap != "" {
+		swapTotal, err := numericValue(values, numericSettings["swap_total_kb"])
+		if err != nil {
+			return err
+		}
+		actual := "disabled"
+		if swapTotal > 0 {
+			actual = "enabled"
+		}
+		compare("swap", ts.Swap, actual)
+	}
+	if ts.TransparentHugepages != "" {
+		actual := thpMode(values["thp"])
+		if actual == "" {
+			return errors.New("cannot read the transparent hugepages mode")
+		}
+		compare("transparent_hugepages", ts.TransparentHugepages, actual)
+	}
+	var names []string
+	for name := range ts.Expectations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e := ts.Expectations[name]
+		v, err := numericValue(values, numericSettings[name])
+		if err != nil {
+			return err
+		}
+		actual := strconv.FormatInt(v, 10)
+		payload.Settings[name] = actual
+		if !e.matches(v) {
+			payload.Mismatches = append(payload.Mismatches, mismatch{Setting: name, Expected: e.String(), Actual: actual})
+		}
+	}
+	if len(payload.Mismatches) == 0 {
+		return teststeps.EmitTargetEvent(ev, EventMemConfigOK, target, payload)
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventMemConfigWrong, target, payload); err != nil {
+		return err
+	}
+	var wrong []string
+	for _, m := range payload.Mismatches {
+		wrong = append(wrong, fmt.Sprintf("%s is %s, expected %s", m.Setting, m.Actual, m.Expected))
+	}
+	return fmt.Errorf("wrong memory configuration: %s", strings.Join(wrong, ", "))
+}
+
+// Run executes the memory configuration check step.
+func (ts *MemCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the step normally, as it only reads the memory settings.
+func (ts *MemCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *MemCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	ts.Swap = params.GetOne("swap").Raw()
+	switch ts.Swap {
+	case "", "enabled", "disabled":
+	default:
+		return fmt.Errorf("invalid 'swap' parameter %q: must be enabled or disabled", ts.Swap)
+	}
+	ts.TransparentHugepages = params.GetOne("transparent_hugepages").Raw()
+	if ts.TransparentHugepages != "" && !thpModes[ts.TransparentHugepages] {
+		return fmt.Errorf("invalid 'transparent_hugepages' parameter %q: must be always, madvise or never", ts.TransparentHugepages)
+	}
+	ts.Expectations = make(map[string]expectation)
+	for name := range numericSettings {
+		p := params.GetOne(name)
+		if p.IsEmpty() {
+			continue
+		}
+		e, err := parseExpectation(p.Raw())
+		if err != nil {
+			return fmt.Errorf("invalid '%s' parameter: %v", name, err)
+		}
+		if name == "overcommit_memory" && e.op == "==" && (e.value < 0 || e.value > 2) {
+			return errors.New("invalid 'overcommit_memory' parameter: must be 0, 1 or 2")
+		}
+		ts.Expectations[name] = e
+	}
+	if ts.Swap == "" && ts.TransparentHugepages == "" && len(ts.Expectations) == 0 {
+		return errors.New("no expected setting specified")
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *MemCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. MemCheck cannot
+// resume.
+func (ts *MemCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *MemCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new MemCheck test step.
+func New() test.TestStep {
+	return &MemCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
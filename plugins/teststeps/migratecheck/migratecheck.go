@@ -0,0 +1,403 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package migratecheck
+
+// The MigrateCheck plugin connects to the database of each target, and checks
+// that its schema version matches the expected one. Targets whose schema is
+// ahead of the expected version always fail. Targets which are behind fail,
+// unless a migrations directory is configured and dry_run is not set, in which
+// case the pending migrations are applied in order.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	"github.com/go-sql-driver/mysql"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "MigrateCheck"
+
+var log = logging.GetLogger("teststeps/" + strings.ToLower(Name))
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventSchemaVersionMatch    = event.Name("TargetSchemaVersionMatch")
+	EventSchemaVersionMismatch = event.Name("TargetSchemaVersionMismatch")
+	EventSchemaMigrated        = event.Name("TargetSchemaMigrated")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventSchemaVersionMatch, EventSchemaVersionMismatch, EventSchemaMigrated}
+
+const (
+	defaultDriver       = "mysql"
+	defaultVersionQuery = "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"
+	defaultRecordQuery  = "INSERT INTO schema_migrations (version) VALUES (?)"
+	defaultQueryTimeout = 30 * time.Second
+	// secretPlaceholder replaces secret references when validating DSNs.
+	secretPlaceholder = "secret"
+)
+
+// migrationFileRe matches the names of migration files, e.g. 0012_add_index.sql.
+var migrationFileRe = regexp.MustCompile(`^(\d+)_.*\.sql$`)
+
+// migration is a schema migration loaded from the migrations directory.
+type migration struct {
+	Version int64
+	Path    string
+}
+
+// schemaEventPayload is the payload of the events emitted by this step.
+type schemaEventPayload struct {
+	CurrentVersion  int64
+	ExpectedVersion int64
+	DryRun          bool
+	Pending         []int64 `json:",omitempty"`
+	Applied         []int64 `json:",omitempty"`
+	Error           string  `json:",omitempty"`
+}
+
+// MigrateCheck checks the schema version of the targets' databases.
+type MigrateCheck struct {
+	Driver          string
+	DSN             *test.Param
+	ExpectedVersion int64
+	VersionQuery    string
+	RecordQuery     string
+	QueryTimeout    time.Duration
+	// ReadOnly reports the pending migrations without applying them, as set
+	// by the dry_run parameter and in dry runs.
+	ReadOnly   bool
+	Migrations []migration
+	Timeouts   teststeps.NetworkTimeouts
+}
+
+// Name returns the plugin name.
+func (ts MigrateCheck) Name() string {
+	return Name
+}
+
+// loadMigrations returns the migrations found in dir, sorted by version.
+func loadMigrations(dir string) ([]migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read migrations directory: %v", err)
+	}
+	var migrations []migration
+	seen := make(map[int64]string)
+	for _, f := range files {
+		m := migrationFileRe.FindStringSubmatch(f.Name())
+		if f.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version in migration file %s: %v", f.Name(), err)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migration files %s and %s have the same version %d", other, f.Name(), version)
+		}
+		seen[version] = f.Name()
+		migrations = append(migrations, migration{Version: version, Path: filepath.Join(dir, f.Name())})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// pending returns the migrations which bring the schema from the current to
+// the expected version.
+func (ts *MigrateCheck) pending(current int64) []migration {
+	var pending []migration
+	for _, m := range ts.Migrations {
+		if m.Version > current && m.Version <= ts.ExpectedVersion {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// wrapCtxErr reports interruptions by cancellation or pause consistently with
+// the other steps.
+func wrapCtxErr(ctx context.Context, err error) error {
+	if ctx.Err() == context.Canceled {
+		return teststeps.ErrInterrupted
+	}
+	return err
+}
+
+func (ts *MigrateCheck) version(ctx context.Context, db *sql.DB) (int64, error) {
+	qctx, cancel := context.WithTimeout(ctx, ts.QueryTimeout)
+	defer cancel()
+	var version int64
+	if err := db.QueryRowContext(qctx, ts.VersionQuery).Scan(&version); err != nil {
+		return 0, wrapCtxErr(ctx, fmt.Errorf("cannot read schema version: %v", err))
+	}
+	return version, nil
+}
+
+// apply runs a migration and records its version in a single transaction.
+func (ts *MigrateCheck) apply(ctx context.Context, db *sql.DB, m migration) error {
+	stmt, err := ioutil.ReadFile(m.Path)
+	if err != nil {
+		return fmt.Errorf("cannot read migration %d: %v", m.Version, err)
+	}
+	qctx, cancel := context.WithTimeout(ctx, ts.QueryTimeout)
+	defer cancel()
+	tx, err := db.BeginTx(qctx, nil)
+	if err != nil {
+		return wrapCtxErr(ctx, fmt.Errorf("cannot start transaction for migration %d: %v", m.Version, err))
+	}
+	if _, err := tx.ExecContext(qctx, string(stmt)); err != nil {
+		_ = tx.Rollback()
+		return wrapCtxErr(ctx, fmt.Errorf("migration %d failed: %v", m.Version, err))
+	}
+	if _, err := tx.ExecContext(qctx, ts.RecordQuery, m.Version); err != nil {
+		_ = tx.Rollback()
+		return wrapCtxErr(ctx, fmt.Errorf("cannot record migration %d: %v", m.Version, err))
+	}
+	if err := tx.Commit(); err != nil {
+		return wrapCtxErr(ctx, fmt.Errorf("cannot commit migration %d: %v", m.Version, err))
+	}
+	return nil
+}
+
+func (ts *MigrateCheck) check(ctx context.Context, target *target.Target, ev testevent.Emitter) error {
+	dsn, err := ts.DSN.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand dsn parameter: %v", err)
+	}
+	if dsn, err = secrets.Expand(dsn); err != nil {
+		return err
+	}
+	if ts.Driver == "mysql" {
+		if dsn, err = ts.applyTimeouts(dsn); err != nil {
+			return err
+		}
+	}
+	db, err := sql.Open(ts.Driver, dsn)
+	if err != nil {
+		return fmt.Errorf("cannot open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	current, err := ts.version(ctx, db)
+	if err != nil {
+		return err
+	}
+	payload := schemaEventPayload{CurrentVersion: current, ExpectedVersion: ts.ExpectedVersion, DryRun: ts.ReadOnly}
+	if current == ts.ExpectedVersion {
+		return teststeps.EmitTargetEvent(ev, EventSchemaVersionMatch, target, payload)
+	}
+	mismatch := func(err error) error {
+		payload.Error = err.Error()
+		if emitErr := teststeps.EmitTargetEvent(ev, EventSchemaVersionMismatch, target, payload); emitErr != nil {
+			log.Warningf("%v", emitErr)
+		}
+		return err
+	}
+	if current > ts.ExpectedVersion {
+		return mismatch(fmt.Errorf("schema version %d is ahead of expected version %d", current, ts.ExpectedVersion))
+	}
+
+	pending := ts.pending(current)
+	for _, m := range pending {
+		payload.Pending = append(payload.Pending, m.Version)
+	}
+	if len(pending) == 0 || pending[len(pending)-1].Version != ts.ExpectedVersion {
+		return mismatch(fmt.Errorf("schema version %d is behind expected version %d, and no migrations lead to it", current, ts.ExpectedVersion))
+	}
+	if ts.ReadOnly {
+		return mismatch(fmt.Errorf("schema version %d is behind expected version %d, %d migrations pending (dry run)", current, ts.ExpectedVersion, len(pending)))
+	}
+	for _, m := range pending {
+		log.Infof("Applying migration %d on target %s", m.Version, target)
+		if err := ts.apply(ctx, db, m); err != nil {
+			if errors.Is(err, teststeps.ErrInterrupted) {
+				return err
+			}
+			return mismatch(err)
+		}
+		payload.Applied = append(payload.Applied, m.Version)
+	}
+	if payload.CurrentVersion, err = ts.version(ctx, db); err != nil {
+		return err
+	}
+	if payload.CurrentVersion != ts.ExpectedVersion {
+		return mismatch(fmt.Errorf("schema version is %d after migrating, expected %d", payload.CurrentVersion, ts.ExpectedVersion))
+	}
+	return teststeps.EmitTargetEvent(ev, EventSchemaMigrated, target, payload)
+}
+
+// applyTimeouts sets the network timeouts in a MySQL DSN, unless the DSN
+// already specifies them.
+func (ts *MigrateCheck) applyTimeouts(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		// do not include the DSN, which may contain secrets
+		return "", errors.New("cannot parse dsn")
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = ts.Timeouts.Connect
+	}
+	if cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = ts.Timeouts.Read
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = ts.Timeouts.Write
+	}
+	return cfg.FormatDSN(), nil
+}
+
+// Run executes the migration check step.
+func (ts *MigrateCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	return ts.run(cancel, pause, ch, ev)
+}
+
+// DryRun checks the schema version of the targets as if the dry_run parameter
+// was set: pending migrations are reported, but not applied.
+func (ts *MigrateCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	ts.ReadOnly = true
+	return ts.run(cancel, pause, ch, ev)
+}
+
+func (ts *MigrateCheck) run(cancel, pause <-chan struct{}, ch test.TestStepChannels, ev testevent.Emitter) error {
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		ctx, ctxCancel := teststeps.NewContext(cancel, pause)
+		defer ctxCancel()
+		ctx, totalCancel := ts.Timeouts.Context(ctx)
+		defer totalCancel()
+		return ts.check(ctx, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// validateDSN checks the DSN template and its secret references, using a
+// placeholder target and placeholder secrets.
+func (ts *MigrateCheck) validateDSN() error {
+	dsn, err := ts.DSN.Expand(&target.Target{Name: "validation", ID: "validation"})
+	if err != nil {
+		return fmt.Errorf("invalid 'dsn' parameter: %v", err)
+	}
+	if err := secrets.Validate(dsn); err != nil {
+		return fmt.Errorf("invalid 'dsn' parameter: %v", err)
+	}
+	if ts.Driver == "mysql" {
+		if _, err := mysql.ParseDSN(secrets.Mask(dsn, secretPlaceholder)); err != nil {
+			return fmt.Errorf("invalid 'dsn' parameter: %v", err)
+		}
+	}
+	return nil
+}
+
+func (ts *MigrateCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.Driver = params.GetOne("driver").Raw()
+	if ts.Driver == "" {
+		ts.Driver = defaultDriver
+	}
+	found := false
+	for _, d := range sql.Drivers() {
+		if d == ts.Driver {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("invalid 'driver' parameter: unknown driver %q", ts.Driver)
+	}
+	ts.DSN = params.GetOne("dsn")
+	if ts.DSN.IsEmpty() {
+		return errors.New("invalid or missing 'dsn' parameter, must be exactly one string")
+	}
+	if err := ts.validateDSN(); err != nil {
+		return err
+	}
+	expected := params.GetOne("expected_version")
+	if expected.IsEmpty() {
+		return errors.New("invalid or missing 'expected_version' parameter, must be exactly one string")
+	}
+	if ts.ExpectedVersion, err = strconv.ParseInt(expected.Raw(), 10, 64); err != nil || ts.ExpectedVersion < 0 {
+		return fmt.Errorf("invalid 'expected_version' parameter %q: must be a non-negative integer", expected.Raw())
+	}
+	ts.VersionQuery = params.GetOne("version_query").Raw()
+	if ts.VersionQuery == "" {
+		ts.VersionQuery = defaultVersionQuery
+	}
+	ts.RecordQuery = params.GetOne("record_query").Raw()
+	if ts.RecordQuery == "" {
+		ts.RecordQuery = defaultRecordQuery
+	}
+	if ts.QueryTimeout, err = teststeps.DurationParam(params, "query_timeout", defaultQueryTimeout); err != nil {
+		return err
+	}
+	if ts.ReadOnly, err = teststeps.BoolParam(params, "dry_run", false); err != nil {
+		return err
+	}
+	if ts.Timeouts, err = teststeps.NewNetworkTimeouts(params); err != nil {
+		return err
+	}
+	ts.Migrations = nil
+	if dir := params.GetOne("migrations_dir"); !dir.IsEmpty() {
+		if ts.Migrations, err = loadMigrations(dir.Raw()); err != nil {
+			return fmt.Errorf("invalid 'migrations_dir' parameter: %v", err)
+		}
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *MigrateCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. MigrateCheck
+// cannot resume.
+func (ts *MigrateCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *MigrateCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new MigrateCheck test step.
+func New() test.TestStep {
+	return &MigrateCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
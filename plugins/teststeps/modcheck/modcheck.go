@@ -0,0 +1,272 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package modcheck
+
+// The ModCheck plugin lists the kernel modules loaded on each target over SSH,
+// and fails the targets missing any of the required modules. Optionally, the
+// missing modules are loaded and the check is repeated.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "ModCheck"
+
+var log = logging.GetLogger("teststeps/" + strings.ToLower(Name))
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventModulesPresent = event.Name("TargetModulesPresent")
+	EventModuleMissing  = event.Name("TargetModuleMissing")
+	EventModuleLoaded   = event.Name("TargetModuleLoaded")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventModulesPresent, EventModuleMissing, EventModuleLoaded}
+
+const (
+	// defaultListCommand prints the loaded modules, followed by the modules
+	// built into the kernel, which do not appear in /proc/modules.
+	defaultListCommand = "cat /proc/modules; cat /lib/modules/$(uname -r)/modules.builtin 2>/dev/null; true"
+	defaultLoadCommand = "modprobe"
+)
+
+var moduleNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// normalize returns the canonical name of a module. The kernel reports module
+// names with underscores, while dashes are accepted by modprobe.
+func normalize(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
+
+// parseModules parses the output of the list command. Each line is either an
+// entry of /proc/modules, starting with the module name, or the path of a
+// built-in module as found in modules.builtin.
+func parseModules(out []byte) map[string]bool {
+	modules := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		name := fields[0]
+		if strings.HasSuffix(name, ".ko") {
+			name = strings.TrimSuffix(path.Base(name), ".ko")
+		}
+		modules[normalize(name)] = true
+	}
+	return modules
+}
+
+// modEventPayload is the payload of the events emitted by this step.
+type modEventPayload struct {
+	Required []string
+	Missing  []string `json:",omitempty"`
+	Loaded   []string `json:",omitempty"`
+	Error    string   `json:",omitempty"`
+}
+
+// ModCheck verifies that kernel modules are loaded on the targets.
+type ModCheck struct {
+	*teststeps.SSHParams
+	Modules     []string
+	ListCommand *test.Param
+	LoadCommand *test.Param
+	Load        bool
+}
+
+// Name returns the plugin name.
+func (ts ModCheck) Name() string {
+	return Name
+}
+
+func (ts *ModCheck) missing(cancel, pause <-chan struct{}, target *target.Target) ([]string, error) {
+	cmd, err := ts.ListCommand.Expand(target)
+	if err != nil {
+		return nil, fmt.Errorf("cannot expand list_command parameter: %v", err)
+	}
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitStatus != 0 {
+		return nil, fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	modules := parseModules(result.Stdout)
+	var missing []string
+	for _, m := range ts.Modules {
+		if !modules[normalize(m)] {
+			missing = append(missing, m)
+		}
+	}
+	return missing, nil
+}
+
+// load attempts to load the given modules, returning the ones which could be
+// loaded.
+func (ts *ModCheck) load(cancel, pause <-chan struct{}, target *target.Target, modules []string) ([]string, error) {
+	loadCmd, err := ts.LoadCommand.Expand(target)
+	if err != nil {
+		return nil, fmt.Errorf("cannot expand load_command parameter: %v", err)
+	}
+	var loaded []string
+	for _, m := range modules {
+		cmd := loadCmd + " " + shellquote.Join(m)
+		log.Infof("Loading module %s on target %s", m, target)
+		result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+		if err != nil {
+			return loaded, err
+		}
+		if result.ExitStatus != 0 {
+			log.Warningf("Could not load module %s on target %s: %s", m, target, bytes.TrimSpace(result.Stderr))
+			continue
+		}
+		loaded = append(loaded, m)
+	}
+	return loaded, nil
+}
+
+func (ts *ModCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	payload := modEventPayload{Required: ts.Modules}
+	missing, err := ts.missing(cancel, pause, target)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 && ts.Load {
+		loaded, err := ts.load(cancel, pause, target, missing)
+		if err != nil {
+			return err
+		}
+		if len(loaded) > 0 {
+			payload.Loaded = loaded
+			if err := teststeps.EmitTargetEvent(ev, EventModuleLoaded, target, payload); err != nil {
+				return err
+			}
+			// re-check, as loading a module may not be enough for it to be
+			// reported, e.g. if modprobe is a no-op on this system
+			if missing, err = ts.missing(cancel, pause, target); err != nil {
+				return err
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return teststeps.EmitTargetEvent(ev, EventModulesPresent, target, payload)
+	}
+	payload.Missing = missing
+	if err := teststeps.EmitTargetEvent(ev, EventModuleMissing, target, payload); err != nil {
+		return err
+	}
+	return fmt.Errorf("missing kernel modules: %s", strings.Join(missing, ", "))
+}
+
+// Run executes the kernel module check step.
+func (ts *ModCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	return ts.run(cancel, pause, ch, ev)
+}
+
+// DryRun checks the modules of the targets, but does not load the missing
+// ones even if the load parameter is set.
+func (ts *ModCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	if ts.Load {
+		log.Infof("Dry run: missing modules will not be loaded")
+		ts.Load = false
+	}
+	return ts.run(cancel, pause, ch, ev)
+}
+
+func (ts *ModCheck) run(cancel, pause <-chan struct{}, ch test.TestStepChannels, ev testevent.Emitter) error {
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+func (ts *ModCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.SSHParams, err = teststeps.NewSSHParams(params)
+	if err != nil {
+		return err
+	}
+	ts.Modules = nil
+	seen := make(map[string]bool)
+	for _, p := range params.Get("modules") {
+		m := strings.TrimSpace(p.Raw())
+		if !moduleNameRe.MatchString(m) {
+			return fmt.Errorf("invalid 'modules' parameter: invalid module name %q", m)
+		}
+		if seen[normalize(m)] {
+			return fmt.Errorf("invalid 'modules' parameter: module %s specified more than once", m)
+		}
+		seen[normalize(m)] = true
+		ts.Modules = append(ts.Modules, m)
+	}
+	if len(ts.Modules) == 0 {
+		return errors.New("missing 'modules' parameter, at least one module must be specified")
+	}
+	ts.ListCommand = params.GetOne("list_command")
+	if ts.ListCommand.IsEmpty() {
+		ts.ListCommand = test.NewParam(defaultListCommand)
+	}
+	ts.LoadCommand = params.GetOne("load_command")
+	if ts.LoadCommand.IsEmpty() {
+		ts.LoadCommand = test.NewParam(defaultLoadCommand)
+	}
+	if ts.Load, err = teststeps.BoolParam(params, "load", false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *ModCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. ModCheck cannot
+// resume.
+func (ts *ModCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *ModCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new ModCheck test step.
+func New() test.TestStep {
+	return &ModCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
@@ -0,0 +1,350 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package objstorecheck
+
+// The ObjStoreCheck plugin verifies that each target can access a bucket of an
+// S3 compatible object store. The requests are sent from the targets over SSH
+// with curl, signed with the access keys if any, so that the network path and
+// the name resolution of the targets are exercised. In read-write mode an
+// object is written, read back and deleted; in read-only mode the bucket, or
+// the given object, is read.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "ObjStoreCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventObjStoreOK          = event.Name("TargetObjStoreOK")
+	EventObjStoreUnreachable = event.Name("TargetObjStoreUnreachable")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventObjStoreOK, EventObjStoreUnreachable}
+
+// The access modes.
+const (
+	ModeReadOnly  = "read-only"
+	ModeReadWrite = "read-write"
+)
+
+const (
+	defaultRegion  = "us-east-1"
+	defaultPrefix  = "contest-objstorecheck/"
+	defaultTimeout = 30 * time.Second
+	// secretPlaceholder replaces the secrets during validation.
+	secretPlaceholder = "secret"
+)
+
+// operationResult is the outcome of a request to the object store.
+type operationResult struct {
+	Operation string
+	Status    int    `json:",omitempty"`
+	Error     string `json:",omitempty"`
+	Duration  string
+}
+
+// objStoreEventPayload is the payload of the events emitted by this step.
+type objStoreEventPayload struct {
+	Bucket     string
+	Mode       string
+	Operations []operationResult
+}
+
+// ObjStoreCheck verifies that the targets can access an object store bucket.
+type ObjStoreCheck struct {
+	*teststeps.SSHParams
+	Endpoint  *test.Param
+	Bucket    string
+	Region    string
+	AccessKey *test.Param
+	SecretKey *test.Param
+	Mode      string
+	Object    *test.Param
+	Prefix    string
+	Timeout   time.Duration
+	Insecure  bool
+}
+
+// Name returns the plugin name.
+func (ts ObjStoreCheck) Name() string {
+	return Name
+}
+
+// expand expands a parameter against the target, and resolves the secrets it
+// refers to.
+func expand(p *test.Param, name string, target *target.Target) (string, error) {
+	v, err := p.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand %s parameter: %v", name, err)
+	}
+	if v, err = secrets.Expand(v); err != nil {
+		return "", fmt.Errorf("cannot expand %s parameter: %v", name, err)
+	}
+	return v, nil
+}
+
+func (ts *ObjStoreCheck) credentials(target *target.Target) (credentials, error) {
+	var (
+		creds credentials
+		err   error
+	)
+	if creds.accessKey, err = expand(ts.AccessKey, "access_key", target); err != nil {
+		return creds, err
+	}
+	if creds.secretKey, err = expand(ts.SecretKey, "secret_key", target); err != nil {
+		return creds, err
+	}
+	if strings.ContainsAny(creds.accessKey+creds.secretKey, "\r\n") {
+		return creds, errors.New("the access keys cannot contain line breaks")
+	}
+	return creds, nil
+}
+
+// send runs a request from the target, and checks that it returns one of the
+// expected statuses.
+func (ts *ObjStoreCheck) send(cancel, pause <-chan struct{}, target *target.Target, op string, r *request, creds credentials, expected ...int) ([]byte, operationResult, error) {
+	result := operationResult{Operation: op}
+	start := time.Now()
+	body, err := func() ([]byte, error) {
+		cmd := r.command(creds, ts.Region, ts.Timeout, ts.Insecure)
+		out, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+		if errors.Is(err, teststeps.ErrInterrupted) {
+			return nil, err
+		}
+		if err != nil {
+			// the errors of the SSH commands quote them, with the credentials
+			return nil, errors.New(strings.Replace(err.Error(), cmd, "curl "+op, -1))
+		}
+		if out.ExitStatus != 0 {
+			// the messages of curl do not include the credentials
+			return nil, fmt.Errorf("curl exited with status %d: %s", out.ExitStatus, bytes.TrimSpace(out.Stderr))
+		}
+		body, status, err := parseResponse(out.Stdout)
+		if err != nil {
+			return nil, err
+		}
+		result.Status = status
+		for _, s := range expected {
+			if status == s {
+				return body, nil
+			}
+		}
+		return nil, fmt.Errorf("%s returned status %d", op, status)
+	}()
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return body, result, err
+}
+
+// access runs the requests of the mode, and appends their results to the
+// payload.
+func (ts *ObjStoreCheck) access(cancel, pause <-chan struct{}, target *target.Target, payload *objStoreEventPayload) error {
+	endpoint, err := expand(ts.Endpoint, "endpoint", target)
+	if err != nil {
+		return err
+	}
+	creds, err := ts.credentials(target)
+	if err != nil {
+		return err
+	}
+	run := func(op string, r *request, expected ...int) ([]byte, error) {
+		body, result, err := ts.send(cancel, pause, target, op, r, creds, expected...)
+		if !errors.Is(err, teststeps.ErrInterrupted) {
+			payload.Operations = append(payload.Operations, result)
+		}
+		return body, err
+	}
+
+	if ts.Mode == ModeReadOnly {
+		if ts.Object.IsEmpty() {
+			_, err := run("head-bucket", &request{method: "HEAD", url: objectURL(endpoint, ts.Bucket, "")}, 200)
+			return err
+		}
+		key, err := ts.Object.Expand(target)
+		if err != nil {
+			return fmt.Errorf("cannot expand object parameter: %v", err)
+		}
+		_, err = run("get", &request{method: "GET", url: objectURL(endpoint, ts.Bucket, key)}, 200)
+		return err
+	}
+
+	key := fmt.Sprintf("%s%s-%d", ts.Prefix, target.ID, time.Now().UnixNano())
+	u := objectURL(endpoint, ts.Bucket, key)
+	content := fmt.Sprintf("ConTest object store check from %s", target.Name)
+	if _, err := run("put", &request{method: "PUT", url: u, body: content}, 200); err != nil {
+		return err
+	}
+	body, err := run("get", &request{method: "GET", url: u}, 200)
+	if err == nil && string(body) != content {
+		err = fmt.Errorf("object %s read back with different content", key)
+		payload.Operations[len(payload.Operations)-1].Error = err.Error()
+	}
+	if errors.Is(err, teststeps.ErrInterrupted) {
+		return err
+	}
+	// the object is deleted even if it could not be read back
+	if _, deleteErr := run("delete", &request{method: "DELETE", url: u}, 200, 204); err == nil {
+		err = deleteErr
+	}
+	return err
+}
+
+func (ts *ObjStoreCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	payload := objStoreEventPayload{Bucket: ts.Bucket, Mode: ts.Mode}
+	err := ts.access(cancel, pause, target, &payload)
+	if errors.Is(err, teststeps.ErrInterrupted) {
+		return err
+	}
+	if err != nil {
+		if emitErr := teststeps.EmitTargetEvent(ev, EventObjStoreUnreachable, target, payload); emitErr != nil {
+			return emitErr
+		}
+		return fmt.Errorf("bucket %s is not accessible in %s mode: %v", ts.Bucket, ts.Mode, err)
+	}
+	return teststeps.EmitTargetEvent(ev, EventObjStoreOK, target, payload)
+}
+
+// Run executes the object store check step.
+func (ts *ObjStoreCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check in read-only mode, which has no side effects, and
+// logs the objects which would be written otherwise.
+func (ts *ObjStoreCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	if ts.Mode == ModeReadOnly {
+		f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+			return ts.check(cancel, pause, target, ev)
+		}
+		return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+	}
+	describe := func(target *target.Target) (string, error) {
+		return fmt.Sprintf("write, read back and delete an object under %s%s in bucket %s", ts.Prefix, target.ID, ts.Bucket), nil
+	}
+	return teststeps.DryRunEachTarget(Name, cancel, pause, ch, describe)
+}
+
+func (ts *ObjStoreCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.SSHParams, err = teststeps.NewSSHParams(params)
+	if err != nil {
+		return err
+	}
+	ts.Endpoint = params.GetOne("endpoint")
+	if ts.Endpoint.IsEmpty() {
+		return errors.New("invalid or missing 'endpoint' parameter, must be exactly one string")
+	}
+	u, err := ts.Endpoint.Expand(&target.Target{Name: "validation.example.com", ID: "validation"})
+	if err != nil {
+		return fmt.Errorf("invalid 'endpoint' parameter: %v", err)
+	}
+	if err := secrets.Validate(u); err != nil {
+		return fmt.Errorf("invalid 'endpoint' parameter: %v", err)
+	}
+	if err := teststeps.ValidateURL(secrets.Mask(u, secretPlaceholder), "endpoint"); err != nil {
+		return err
+	}
+	ts.Bucket = strings.TrimSpace(params.GetOne("bucket").Raw())
+	if err := validateBucket(ts.Bucket); err != nil {
+		return err
+	}
+	ts.Region = strings.TrimSpace(params.GetOne("region").Raw())
+	if ts.Region == "" {
+		ts.Region = defaultRegion
+	}
+	ts.AccessKey = params.GetOne("access_key")
+	ts.SecretKey = params.GetOne("secret_key")
+	if ts.AccessKey.IsEmpty() != ts.SecretKey.IsEmpty() {
+		return errors.New("'access_key' and 'secret_key' must be set together, or both left empty for anonymous access")
+	}
+	for name, p := range map[string]*test.Param{"access_key": ts.AccessKey, "secret_key": ts.SecretKey} {
+		if err := secrets.Validate(p.Raw()); err != nil {
+			return fmt.Errorf("invalid '%s' parameter: %v", name, err)
+		}
+	}
+	ts.Mode = strings.ToLower(strings.TrimSpace(params.GetOne("mode").Raw()))
+	if ts.Mode == "" {
+		ts.Mode = ModeReadWrite
+	}
+	if ts.Mode != ModeReadOnly && ts.Mode != ModeReadWrite {
+		return fmt.Errorf("invalid 'mode' parameter %q, must be %s or %s", ts.Mode, ModeReadOnly, ModeReadWrite)
+	}
+	ts.Object = params.GetOne("object")
+	if !ts.Object.IsEmpty() && ts.Mode != ModeReadOnly {
+		return fmt.Errorf("the 'object' parameter is only used in %s mode", ModeReadOnly)
+	}
+	ts.Prefix = params.GetOne("prefix").Raw()
+	if ts.Prefix == "" {
+		ts.Prefix = defaultPrefix
+	}
+	if strings.HasPrefix(ts.Prefix, "/") {
+		return errors.New("invalid 'prefix' parameter: cannot start with /")
+	}
+	if ts.Timeout, err = teststeps.DurationParam(params, "timeout", defaultTimeout); err != nil {
+		return err
+	}
+	if ts.Insecure, err = teststeps.BoolParam(params, "insecure", false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *ObjStoreCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. ObjStoreCheck
+// cannot resume.
+func (ts *ObjStoreCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *ObjStoreCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new ObjStoreCheck test step.
+func New() test.TestStep {
+	return &ObjStoreCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
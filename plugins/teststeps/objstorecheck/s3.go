@@ -0,0 +1,100 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package objstorecheck
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// bucketRe matches the S3 bucket naming rules.
+var bucketRe = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+func validateBucket(bucket string) error {
+	if !bucketRe.MatchString(bucket) || strings.Contains(bucket, "..") {
+		return fmt.Errorf("invalid 'bucket' parameter %q: must be 3 to 63 lowercase letters, digits, dots and dashes", bucket)
+	}
+	return nil
+}
+
+// credentials are the S3 access keys. Requests are anonymous if they are
+// empty.
+type credentials struct {
+	accessKey, secretKey string
+}
+
+// configQuote quotes a value for the curl configuration file format.
+func configQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// request is an S3 request sent with curl from a target.
+type request struct {
+	method string
+	url    string
+	body   string
+}
+
+// objectURL returns the path-style URL of an object, or of the bucket if key
+// is empty.
+func objectURL(endpoint, bucket, key string) string {
+	u := strings.TrimRight(endpoint, "/") + "/" + bucket
+	if key != "" {
+		u += "/" + (&url.URL{Path: key}).EscapedPath()
+	}
+	return u
+}
+
+// curlHeredoc delimits the curl configuration passed on the standard input.
+const curlHeredoc = "CONTEST_CURL_CONFIG"
+
+// command returns the shell command sending the request. The credentials are
+// passed in a configuration read from the standard input, so that they do not
+// appear on the command line of curl. The command prints the response body
+// followed by the status code on its own line.
+func (r *request) command(creds credentials, region string, timeout time.Duration, insecure bool) string {
+	args := []string{"curl", "-sS", "--max-time", strconv.Itoa(int((timeout + time.Second - 1) / time.Second)), "-w", `\n%{http_code}`}
+	if insecure {
+		args = append(args, "-k")
+	}
+	switch r.method {
+	case "HEAD":
+		args = append(args, "-I", "-o", "/dev/null")
+	case "PUT":
+		args = append(args, "-X", "PUT", "--data-binary", r.body)
+	case "GET":
+	default:
+		args = append(args, "-X", r.method)
+	}
+	if creds.accessKey == "" {
+		return shellquote.Join(append(args, r.url)...)
+	}
+	args = append(args, "-K", "-", r.url)
+	config := fmt.Sprintf("aws-sigv4 = %s\nuser = %s", configQuote("aws:amz:"+region+":s3"), configQuote(creds.accessKey+":"+creds.secretKey))
+	return fmt.Sprintf("%s <<'%s'\n%s\n%s", shellquote.Join(args...), curlHeredoc, config, curlHeredoc)
+}
+
+// parseResponse splits the output of a request command into the response
+// body and status code.
+func parseResponse(out []byte) ([]byte, int, error) {
+	idx := bytes.LastIndexByte(out, '\n')
+	if idx < 0 {
+		return nil, 0, fmt.Errorf("unexpected curl output %q", out)
+	}
+	status, err := strconv.Atoi(string(bytes.TrimSpace(out[idx+1:])))
+	if err != nil || status == 0 {
+		return nil, 0, fmt.Errorf("no response from the object store")
+	}
+	return out[:idx], status, nil
+}
@@ -0,0 +1,237 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package perfconfig
+
+// The PerfConfig plugin reads the active clocksource and the CPU frequency
+// scaling governors of each target over SSH, and fails the targets which do
+// not use the expected ones, e.g. the tsc clocksource and the performance
+// governor on every CPU.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "PerfConfig"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventPerfConfigOK    = event.Name("TargetPerfConfigOK")
+	EventPerfConfigWrong = event.Name("TargetPerfConfigWrong")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventPerfConfigOK, EventPerfConfigWrong}
+
+// command prints the current clocksource, then the scaling governor of each
+// CPU which has one, as "<key>: <value>" lines.
+const command = `echo "clocksource: $(cat /sys/devices/system/clocksource/clocksource0/current_clocksource)"; ` +
+	`for f in /sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_governor; do ` +
+	`[ -r "$f" ] || continue; c=${f#/sys/devices/system/cpu/}; echo "${c%%/*}: $(cat "$f")"; done`
+
+// governors are the CPU frequency scaling governors of the Linux kernel.
+var governors = []string{"conservative", "ondemand", "performance", "powersave", "schedutil", "userspace"}
+
+var (
+	clocksourceRe = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+	cpuRe         = regexp.MustCompile(`^cpu[0-9]+$`)
+)
+
+// perfConfigEventPayload is the payload of the events emitted by this step.
+type perfConfigEventPayload struct {
+	Clocksource         string `json:",omitempty"`
+	ExpectedClocksource string `json:",omitempty"`
+	// Governors maps the governors in use to the number of CPUs using them.
+	Governors        map[string]int `json:",omitempty"`
+	ExpectedGovernor string         `json:",omitempty"`
+	// WrongCPUs are the CPUs which do not use the expected governor.
+	WrongCPUs []string `json:",omitempty"`
+}
+
+// perfConfig is the configuration read from a target.
+type perfConfig struct {
+	clocksource string
+	// governors maps the CPUs to their governor.
+	governors map[string]string
+}
+
+// PerfConfig verifies the clocksource and CPU governors of the targets.
+type PerfConfig struct {
+	*teststeps.SSHParams
+	Clocksource string
+	Governor    string
+}
+
+// Name returns the plugin name.
+func (ts PerfConfig) Name() string {
+	return Name
+}
+
+// parseConfig parses the output of command.
+func parseConfig(out []byte) (*perfConfig, error) {
+	cfg := perfConfig{governors: make(map[string]string)}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			return nil, fmt.Errorf("unexpected output line %q", line)
+		}
+		key, value := line[:idx], strings.TrimSpace(line[idx+1:])
+		switch {
+		case key == "clocksource":
+			cfg.clocksource = value
+		case cpuRe.MatchString(key):
+			cfg.governors[key] = value
+		default:
+			return nil, fmt.Errorf("unexpected output line %q", line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if cfg.clocksource == "" {
+		return nil, errors.New("cannot read the current clocksource")
+	}
+	return &cfg, nil
+}
+
+// compare fills the payload with the configuration read from a target, and
+// returns the settings which differ from the expected ones.
+func (ts *PerfConfig) compare(cfg *perfConfig, payload *perfConfigEventPayload) []string {
+	var wrong []string
+	if ts.Clocksource != "" {
+		payload.Clocksource = cfg.clocksource
+		payload.ExpectedClocksource = ts.Clocksource
+		if cfg.clocksource != ts.Clocksource {
+			wrong = append(wrong, fmt.Sprintf("clocksource is %s, expected %s", cfg.clocksource, ts.Clocksource))
+		}
+	}
+	if ts.Governor != "" {
+		payload.ExpectedGovernor = ts.Governor
+		if len(cfg.governors) == 0 {
+			return append(wrong, "CPU frequency scaling is not available")
+		}
+		payload.Governors = make(map[string]int)
+		for cpu, governor := range cfg.governors {
+			payload.Governors[governor]++
+			if governor != ts.Governor {
+				payload.WrongCPUs = append(payload.WrongCPUs, cpu)
+			}
+		}
+		if len(payload.WrongCPUs) > 0 {
+			sort.Strings(payload.WrongCPUs)
+			wrong = append(wrong, fmt.Sprintf("%d of %d CPUs do not use the %s governor", len(payload.WrongCPUs), len(cfg.governors), ts.Governor))
+		}
+	}
+	return wrong
+}
+
+func (ts *PerfConfig) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	result, err := ts.RunSSHCommand(cancel, pause, target, command)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("cannot read the performance configuration, exit status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	cfg, err := parseConfig(result.Stdout)
+	if err != nil {
+		return err
+	}
+	var payload perfConfigEventPayload
+	if wrong := ts.compare(cfg, &payload); len(wrong) > 0 {
+		if err := teststeps.EmitTargetEvent(ev, EventPerfConfigWrong, target, payload); err != nil {
+			return err
+		}
+		return errors.New(strings.Join(wrong, "; "))
+	}
+	return teststeps.EmitTargetEvent(ev, EventPerfConfigOK, target, payload)
+}
+
+// Run executes the performance configuration check step.
+func (ts *PerfConfig) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as it only reads from sysfs.
+func (ts *PerfConfig) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *PerfConfig) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.SSHParams, err = teststeps.NewSSHParams(params)
+	if err != nil {
+		return err
+	}
+	ts.Clocksource = strings.TrimSpace(params.GetOne("clocksource").Raw())
+	if ts.Clocksource != "" && !clocksourceRe.MatchString(ts.Clocksource) {
+		return fmt.Errorf("invalid 'clocksource' parameter %q", ts.Clocksource)
+	}
+	ts.Governor = strings.TrimSpace(params.GetOne("governor").Raw())
+	if ts.Governor != "" {
+		idx := sort.SearchStrings(governors, ts.Governor)
+		if idx == len(governors) || governors[idx] != ts.Governor {
+			return fmt.Errorf("invalid 'governor' parameter %q, must be one of %s", ts.Governor, strings.Join(governors, ", "))
+		}
+	}
+	if ts.Clocksource == "" && ts.Governor == "" {
+		return errors.New("at least one of 'clocksource' and 'governor' must be specified")
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *PerfConfig) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. PerfConfig
+// cannot resume.
+func (ts *PerfConfig) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *PerfConfig) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new PerfConfig test step.
+func New() test.TestStep {
+	return &PerfConfig{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
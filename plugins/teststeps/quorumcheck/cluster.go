@@ -0,0 +1,209 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package quorumcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The supported cluster types.
+const (
+	ClusterEtcd      = "etcd"
+	ClusterZooKeeper = "zookeeper"
+	ClusterConsul    = "consul"
+)
+
+// member is a member of a cluster, as reported by its API.
+type member struct {
+	ID   string
+	Name string
+	// Hosts are the addresses of the member, without ports.
+	Hosts   []string
+	Voter   bool
+	Healthy bool
+}
+
+// matches tells whether the member is the given target, identified by name,
+// ID or address.
+func (m *member) matches(name string) bool {
+	if strings.EqualFold(m.Name, name) || m.ID == name {
+		return true
+	}
+	for _, h := range m.Hosts {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiRequest is a request to the API of a cluster.
+type apiRequest struct {
+	method string
+	path   string
+	body   string
+	// unhealthyStatus, if not zero, is the status returned by the API when
+	// the cluster is unhealthy, with a response which is decoded as usual.
+	unhealthyStatus int
+}
+
+// cluster knows the API of a cluster type: which requests return the
+// membership and health of the cluster, and how to decode the responses.
+type cluster struct {
+	// tokenHeader is the request header carrying the token parameter.
+	tokenHeader string
+	requests    []apiRequest
+	// members decodes the responses to the requests, in the same order.
+	members func(responses [][]byte) ([]member, error)
+}
+
+var clusters = map[string]cluster{
+	ClusterEtcd: {
+		tokenHeader: "Authorization",
+		requests: []apiRequest{
+			{method: "POST", path: "/v3/cluster/member/list", body: "{}"},
+			{method: "GET", path: "/health", unhealthyStatus: http.StatusServiceUnavailable},
+		},
+		members: etcdMembers,
+	},
+	ClusterZooKeeper: {
+		tokenHeader: "Authorization",
+		requests: []apiRequest{
+			{method: "GET", path: "/commands/voting_view"},
+			{method: "GET", path: "/commands/leader"},
+		},
+		members: zooKeeperMembers,
+	},
+	ClusterConsul: {
+		tokenHeader: "X-Consul-Token",
+		requests: []apiRequest{
+			{method: "GET", path: "/v1/operator/autopilot/health", unhealthyStatus: http.StatusTooManyRequests},
+		},
+		members: consulMembers,
+	},
+}
+
+// urlHost returns the host of a URL, or an empty string.
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// addrHost returns the host of a host:port address.
+func addrHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// etcdMembers decodes the member list and the health of an etcd cluster. The
+// members which were added but never started have no name, and are reported
+// unhealthy, as are all of them if the cluster has no quorum.
+func etcdMembers(responses [][]byte) ([]member, error) {
+	var list struct {
+		Members []struct {
+			ID         string   `json:"ID"`
+			Name       string   `json:"name"`
+			PeerURLs   []string `json:"peerURLs"`
+			ClientURLs []string `json:"clientURLs"`
+			IsLearner  bool     `json:"isLearner"`
+		} `json:"members"`
+	}
+	if err := json.Unmarshal(responses[0], &list); err != nil {
+		return nil, fmt.Errorf("cannot decode etcd member list: %v", err)
+	}
+	var health struct {
+		Health string `json:"health"`
+	}
+	if err := json.Unmarshal(responses[1], &health); err != nil {
+		return nil, fmt.Errorf("cannot decode etcd health: %v", err)
+	}
+	var members []member
+	for _, m := range list.Members {
+		em := member{ID: m.ID, Name: m.Name, Voter: !m.IsLearner, Healthy: m.Name != "" && health.Health == "true"}
+		for _, u := range append(m.PeerURLs, m.ClientURLs...) {
+			if h := urlHost(u); h != "" {
+				em.Hosts = append(em.Hosts, h)
+			}
+		}
+		members = append(members, em)
+	}
+	return members, nil
+}
+
+// zooKeeperMembers decodes the voting view and the leader of a ZooKeeper
+// ensemble, from its AdminServer. ZooKeeper only reports whether the queried
+// server is part of a serving quorum, which applies to all the members:
+// templating the url parameter to query the AdminServer of each target checks
+// the targets themselves.
+func zooKeeperMembers(responses [][]byte) ([]member, error) {
+	var view struct {
+		CurrentConfig map[string]string `json:"current_config"`
+		Error         *string           `json:"error"`
+	}
+	if err := json.Unmarshal(responses[0], &view); err != nil {
+		return nil, fmt.Errorf("cannot decode ZooKeeper voting view: %v", err)
+	}
+	if view.Error != nil {
+		return nil, fmt.Errorf("ZooKeeper voting view failed: %s", *view.Error)
+	}
+	var leader struct {
+		Error *string `json:"error"`
+	}
+	if err := json.Unmarshal(responses[1], &leader); err != nil {
+		return nil, fmt.Errorf("cannot decode ZooKeeper leader: %v", err)
+	}
+	var members []member
+	for id, server := range view.CurrentConfig {
+		// e.g. zk1.example.com:2888:3888:participant;0.0.0.0:2181
+		spec := strings.SplitN(server, ";", 2)[0]
+		fields := strings.Split(spec, ":")
+		role := "participant"
+		if len(fields) > 3 {
+			role = fields[3]
+		}
+		members = append(members, member{
+			ID:      id,
+			Name:    fields[0],
+			Hosts:   []string{fields[0]},
+			Voter:   role == "participant",
+			Healthy: leader.Error == nil,
+		})
+	}
+	return members, nil
+}
+
+// consulMembers decodes the autopilot health of the servers of a Consul
+// cluster.
+func consulMembers(responses [][]byte) ([]member, error) {
+	var health struct {
+		Servers []struct {
+			ID      string `json:"ID"`
+			Name    string `json:"Name"`
+			Address string `json:"Address"`
+			Healthy bool   `json:"Healthy"`
+			Voter   bool   `json:"Voter"`
+		} `json:"Servers"`
+	}
+	if err := json.Unmarshal(responses[0], &health); err != nil {
+		return nil, fmt.Errorf("cannot decode Consul autopilot health: %v", err)
+	}
+	var members []member
+	for _, s := range health.Servers {
+		members = append(members, member{ID: s.ID, Name: s.Name, Hosts: []string{addrHost(s.Address)}, Voter: s.Voter, Healthy: s.Healthy})
+	}
+	return members, nil
+}
@@ -0,0 +1,292 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package quorumcheck
+
+// The QuorumCheck plugin queries the membership API of a cluster, an etcd
+// cluster, a ZooKeeper ensemble or the servers of a Consul datacenter, and
+// verifies that each target is a healthy voting member. Learners, observers,
+// non-voters, unhealthy members and targets which are not members at all fail
+// with a TargetNotInQuorum event.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/secrets"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "QuorumCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventInQuorum    = event.Name("TargetInQuorum")
+	EventNotInQuorum = event.Name("TargetNotInQuorum")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventInQuorum, EventNotInQuorum}
+
+const (
+	defaultMember       = "{{ .Name }}"
+	defaultQueryTimeout = 30 * time.Second
+	// maxResponseSize bounds the size of the API responses.
+	maxResponseSize = 16 * 1024 * 1024
+	// secretPlaceholder replaces the secrets during validation.
+	secretPlaceholder = "secret"
+)
+
+// quorumEventPayload is the payload of the events emitted by this step.
+type quorumEventPayload struct {
+	Cluster string
+	Member  string
+	Found   bool
+	Voter   bool
+	Healthy bool
+	// Voters is the number of voting members of the cluster.
+	Voters int
+	Reason string `json:",omitempty"`
+}
+
+// QuorumCheck verifies that the targets are healthy voting members of a
+// cluster.
+type QuorumCheck struct {
+	Cluster      string
+	URL          *test.Param
+	Token        *test.Param
+	Member       *test.Param
+	QueryTimeout time.Duration
+	Insecure     bool
+	Timeouts     teststeps.NetworkTimeouts
+}
+
+// Name returns the plugin name.
+func (ts QuorumCheck) Name() string {
+	return Name
+}
+
+func (ts *QuorumCheck) query(ctx context.Context, client *http.Client, baseURL, token string, r apiRequest) ([]byte, error) {
+	var body io.Reader
+	if r.body != "" {
+		body = strings.NewReader(r.body)
+	}
+	req, err := http.NewRequest(r.method, strings.TrimSuffix(baseURL, "/")+r.path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if r.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set(clusters[ts.Cluster].tokenHeader, token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response to %s: %v", r.path, err)
+	}
+	if resp.StatusCode != http.StatusOK && (r.unhealthyStatus == 0 || resp.StatusCode != r.unhealthyStatus) {
+		return nil, fmt.Errorf("%s returned %s", r.path, resp.Status)
+	}
+	return data, nil
+}
+
+// members queries the members of the cluster.
+func (ts *QuorumCheck) members(cancel, pause <-chan struct{}, baseURL, token string) ([]member, error) {
+	ctx, ctxCancel := teststeps.NewContext(cancel, pause)
+	defer ctxCancel()
+	client := teststeps.NewHTTPClient(teststeps.HTTPClientOptions{Timeouts: ts.Timeouts, InsecureSkipVerify: ts.Insecure})
+	defer client.CloseIdleConnections()
+
+	queryCtx, queryCancel := context.WithTimeout(ctx, ts.QueryTimeout)
+	defer queryCancel()
+	c := clusters[ts.Cluster]
+	var responses [][]byte
+	for _, r := range c.requests {
+		data, err := ts.query(queryCtx, client, baseURL, token, r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, teststeps.ErrInterrupted
+			}
+			if queryCtx.Err() != nil {
+				return nil, fmt.Errorf("%s API query timed out after %s", ts.Cluster, ts.QueryTimeout)
+			}
+			// do not include the URL, which may contain secrets
+			return nil, fmt.Errorf("%s API request failed: %v", ts.Cluster, err)
+		}
+		responses = append(responses, data)
+	}
+	return c.members(responses)
+}
+
+func (ts *QuorumCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	u, err := ts.URL.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand url parameter: %v", err)
+	}
+	if u, err = secrets.Expand(u); err != nil {
+		return err
+	}
+	token, err := ts.Token.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand token parameter: %v", err)
+	}
+	if token, err = secrets.Expand(token); err != nil {
+		return err
+	}
+	name, err := ts.Member.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand member parameter: %v", err)
+	}
+
+	members, err := ts.members(cancel, pause, u, token)
+	if err != nil {
+		return err
+	}
+	payload := quorumEventPayload{Cluster: ts.Cluster, Member: name}
+	for _, m := range members {
+		if m.Voter {
+			payload.Voters++
+		}
+		if !payload.Found && m.matches(name) {
+			payload.Found, payload.Voter, payload.Healthy = true, m.Voter, m.Healthy
+		}
+	}
+	var reasons []string
+	switch {
+	case !payload.Found:
+		reasons = append(reasons, "not a member")
+	default:
+		if !payload.Voter {
+			reasons = append(reasons, "not a voting member")
+		}
+		if !payload.Healthy {
+			reasons = append(reasons, "not healthy")
+		}
+	}
+	if len(reasons) > 0 {
+		payload.Reason = strings.Join(reasons, ", ")
+		if err := teststeps.EmitTargetEvent(ev, EventNotInQuorum, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("%s is not in the %s quorum: %s", name, ts.Cluster, payload.Reason)
+	}
+	return teststeps.EmitTargetEvent(ev, EventInQuorum, target, payload)
+}
+
+// Run executes the quorum check step.
+func (ts *QuorumCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as it only reads the cluster membership.
+func (ts *QuorumCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *QuorumCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.Cluster = strings.ToLower(strings.TrimSpace(params.GetOne("cluster").Raw()))
+	if _, ok := clusters[ts.Cluster]; !ok {
+		var types []string
+		for t := range clusters {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return fmt.Errorf("invalid or missing 'cluster' parameter %q, must be one of %s", ts.Cluster, strings.Join(types, ", "))
+	}
+	ts.URL = params.GetOne("url")
+	if ts.URL.IsEmpty() {
+		return errors.New("invalid or missing 'url' parameter, must be exactly one string")
+	}
+	u, err := ts.URL.Expand(&target.Target{Name: "validation.example.com", ID: "validation"})
+	if err != nil {
+		return fmt.Errorf("invalid 'url' parameter: %v", err)
+	}
+	if err := secrets.Validate(u); err != nil {
+		return fmt.Errorf("invalid 'url' parameter: %v", err)
+	}
+	if err := teststeps.ValidateURL(secrets.Mask(u, secretPlaceholder), "url"); err != nil {
+		return err
+	}
+	ts.Token = params.GetOne("token")
+	if err := secrets.Validate(ts.Token.Raw()); err != nil {
+		return fmt.Errorf("invalid 'token' parameter: %v", err)
+	}
+	ts.Member = params.GetOne("member")
+	if ts.Member.IsEmpty() {
+		ts.Member = test.NewParam(defaultMember)
+	}
+	if ts.QueryTimeout, err = teststeps.DurationParam(params, "query_timeout", defaultQueryTimeout); err != nil {
+		return err
+	}
+	if ts.QueryTimeout <= 0 {
+		return errors.New("'query_timeout' must be positive")
+	}
+	if ts.Insecure, err = teststeps.BoolParam(params, "insecure", false); err != nil {
+		return err
+	}
+	if ts.Timeouts, err = teststeps.NewNetworkTimeouts(params); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *QuorumCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. QuorumCheck
+// cannot resume.
+func (ts *QuorumCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *QuorumCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new QuorumCheck test step.
+func New() test.TestStep {
+	return &QuorumCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
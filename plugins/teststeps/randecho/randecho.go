@@ -6,6 +6,7 @@
 package randecho
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -55,7 +56,8 @@ func (e Step) Name() string {
 }
 
 // Run executes the step
-func (e Step) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+func (e Step) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
 	for {
 		select {
 		case target := <-ch.In:
@@ -69,6 +71,7 @@ func (e Step) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, param
 					EventName: event.Name("TargetSucceeded"),
 					Target:    target,
 					Payload:   nil,
+					Severity:  testevent.SeverityInfo,
 				}
 				_ = ev.Emit(evData)
 				log.Infof("Run: target %s succeeded: %s", target, params.GetOne("text"))
@@ -78,6 +81,7 @@ func (e Step) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, param
 					EventName: event.Name("TargetFailed"),
 					Target:    target,
 					Payload:   nil,
+					Severity:  testevent.SeverityError,
 				}
 				_ = ev.Emit(evData)
 				log.Infof("Run: target %s failed: %s", target, params.GetOne("text"))
@@ -91,13 +95,18 @@ func (e Step) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, param
 	}
 }
 
+// DryRun runs the step normally, as it has no side effects.
+func (e Step) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return e.Run(ctx, pause, ch, params, ev)
+}
+
 // CanResume tells whether this step is able to resume.
 func (e Step) CanResume() bool {
-	return false
+	return true
 }
 
-// Resume tries to resume a previously interrupted test step. RandEchoStep cannot
-// resume.
-func (e Step) Resume(cancel, pause <-chan struct{}, _ test.TestStepChannels, _ test.TestStepParameters, ev testevent.EmitterFetcher) error {
-	return &cerrors.ErrResumeNotSupported{StepName: Name}
+// Resume resumes an interrupted step, drawing the outcome of the targets it
+// was resumed with again.
+func (e Step) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return e.Run(ctx, pause, ch, params, ev)
 }
@@ -0,0 +1,481 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package repocheck
+
+// The RepoCheck plugin reads the package repositories configured on each
+// target over SSH, and fails the targets which are missing a required
+// repository or which have a forbidden one enabled. Both the apt sources, in
+// the one-line and deb822 formats, and the yum/dnf repository files are
+// supported.
+//
+// Repositories are specified as patterns, where * matches any sequence of
+// characters, which are matched against the identifier and the URLs of the
+// enabled repositories. The identifier of a yum repository is the name of its
+// section, and the one of an apt source is the name of the file defining it,
+// without extension, e.g. "docker" for /etc/apt/sources.list.d/docker.list.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "RepoCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventRepoOK       = event.Name("TargetRepoOK")
+	EventRepoMismatch = event.Name("TargetRepoMismatch")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventRepoOK, EventRepoMismatch}
+
+// supported package managers.
+const (
+	ManagerAuto = "auto"
+	ManagerApt  = "apt"
+	ManagerYum  = "yum"
+)
+
+const (
+	// fileMarker precedes the content of each repository file in the output
+	// of the commands.
+	fileMarker = "==> "
+	// managerMarker precedes the name of the detected package manager.
+	managerMarker = "manager="
+
+	aptCommand = `printf '%s\n' "` + managerMarker + `apt"
+for f in /etc/apt/sources.list /etc/apt/sources.list.d/*.list /etc/apt/sources.list.d/*.sources; do
+  [ -f "$f" ] && { echo "` + fileMarker + `$f"; cat "$f"; echo; }
+done; true`
+	yumCommand = `printf '%s\n' "` + managerMarker + `yum"
+for f in /etc/yum.conf /etc/dnf/dnf.conf /etc/yum.repos.d/*.repo; do
+  [ -f "$f" ] && { echo "` + fileMarker + `$f"; cat "$f"; echo; }
+done; true`
+	autoCommand = `if [ -d /etc/yum.repos.d ]; then ` + yumCommand + `
+elif [ -d /etc/apt ]; then ` + aptCommand + `
+else echo "` + managerMarker + `none"; fi`
+)
+
+var (
+	commands = map[string]string{
+		ManagerAuto: autoCommand,
+		ManagerApt:  aptCommand,
+		ManagerYum:  yumCommand,
+	}
+	// aptOptionsRe matches the options of a one-line apt source, e.g.
+	// [arch=amd64 signed-by=/usr/share/keyrings/docker.gpg].
+	aptOptionsRe = regexp.MustCompile(`^\[[^\]]*\]$`)
+	// patternRe matches the valid repository patterns.
+	patternRe = regexp.MustCompile(`^\S+$`)
+)
+
+// repo is a repository configured on a target.
+type repo struct {
+	ID    string
+	URL   string `json:",omitempty"`
+	Suite string `json:",omitempty"`
+	File  string
+}
+
+// repoEventPayload is the payload of the events emitted by this step.
+type repoEventPayload struct {
+	Manager   string
+	Repos     []repo
+	Missing   []string `json:",omitempty"`
+	Forbidden []string `json:",omitempty"`
+}
+
+// RepoCheck verifies the package repositories of the targets.
+type RepoCheck struct {
+	*teststeps.SSHParams
+	Manager   string
+	Required  []string
+	Forbidden []string
+
+	required  []*regexp.Regexp
+	forbidden []*regexp.Regexp
+}
+
+// Name returns the plugin name.
+func (ts RepoCheck) Name() string {
+	return Name
+}
+
+// compilePattern turns a repository pattern into a regular expression, where
+// * matches any sequence of characters. Trailing slashes are not significant.
+func compilePattern(p string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(strings.TrimRight(p, "/"))
+	return regexp.MustCompile("^" + strings.Replace(quoted, `\*`, ".*", -1) + "$")
+}
+
+func (r repo) matches(re *regexp.Regexp) bool {
+	return re.MatchString(r.ID) || (r.URL != "" && re.MatchString(strings.TrimRight(r.URL, "/")))
+}
+
+// fileID returns the identifier of the repositories defined by an apt file.
+func fileID(file string) string {
+	base := path.Base(file)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// splitFiles returns the package manager, and the content of each file in the
+// output of the commands.
+func splitFiles(out []byte) (string, map[string][]string, []string, error) {
+	var (
+		manager string
+		files   = make(map[string][]string)
+		order   []string
+		current string
+	)
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case manager == "" && strings.HasPrefix(line, managerMarker):
+			manager = strings.TrimPrefix(line, managerMarker)
+		case strings.HasPrefix(line, fileMarker):
+			current = strings.TrimPrefix(line, fileMarker)
+			order = append(order, current)
+		case current != "":
+			files[current] = append(files[current], line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", nil, nil, fmt.Errorf("cannot read repository files: %v", err)
+	}
+	if manager == "" {
+		return "", nil, nil, errors.New("cannot detect the package manager")
+	}
+	return manager, files, order, nil
+}
+
+// stripComment removes the comment of a line.
+func stripComment(line string) string {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+// parseAptList parses the one-line format of the apt sources.
+func parseAptList(file string, lines []string) []repo {
+	var repos []repo
+	for _, line := range lines {
+		fields := strings.Fields(stripComment(line))
+		if len(fields) < 3 || (fields[0] != "deb" && fields[0] != "deb-src") {
+			continue
+		}
+		fields = fields[1:]
+		if strings.HasPrefix(fields[0], "[") {
+			// options may contain spaces, e.g. [ arch=amd64 ]
+			end := 0
+			for end < len(fields) && !strings.HasSuffix(fields[end], "]") {
+				end++
+			}
+			if end == len(fields) || !aptOptionsRe.MatchString(strings.Join(fields[:end+1], " ")) {
+				continue
+			}
+			fields = fields[end+1:]
+		}
+		if len(fields) > 0 && strings.Contains(fields[0], "[") && !strings.Contains(fields[0], "]") {
+			// URIs may contain spaces within brackets, e.g.
+			// cdrom:[Ubuntu 22.04 LTS]/
+			end := 1
+			for end < len(fields) && !strings.Contains(fields[end], "]") {
+				end++
+			}
+			if end == len(fields) {
+				continue
+			}
+			fields = append([]string{strings.Join(fields[:end+1], " ")}, fields[end+1:]...)
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		repos = append(repos, repo{ID: fileID(file), URL: fields[0], Suite: fields[1], File: file})
+	}
+	return repos
+}
+
+// parseAptSources parses the deb822 format of the apt sources, where each
+// paragraph defines the sources of one or more URIs.
+func parseAptSources(file string, lines []string) []repo {
+	var repos []repo
+	fields := make(map[string]string)
+	flush := func() {
+		if !strings.EqualFold(fields["enabled"], "no") {
+			suite := strings.Fields(fields["suites"])
+			for _, uri := range strings.Fields(fields["uris"]) {
+				r := repo{ID: fileID(file), URL: uri, File: file}
+				if len(suite) > 0 {
+					r.Suite = suite[0]
+				}
+				repos = append(repos, r)
+			}
+		}
+		fields = make(map[string]string)
+	}
+	last := ""
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && last != "" {
+			// continuation of a multi-line field
+			fields[last] += " " + strings.TrimSpace(line)
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		last = strings.ToLower(strings.TrimSpace(kv[0]))
+		fields[last] = strings.TrimSpace(kv[1])
+	}
+	flush()
+	return repos
+}
+
+// parseYum parses a yum/dnf INI file, and returns its enabled repositories.
+func parseYum(file string, lines []string) []repo {
+	var (
+		repos   []repo
+		section string
+		enabled = true
+		urls    []string
+		last    string
+	)
+	flush := func() {
+		if section != "" && section != "main" && enabled {
+			if len(urls) == 0 {
+				repos = append(repos, repo{ID: section, File: file})
+			}
+			// each URL is reported as a repository with the same ID
+			for _, u := range urls {
+				repos = append(repos, repo{ID: section, URL: u, File: file})
+			}
+		}
+		section, enabled, urls, last = "", true, nil, ""
+	}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			flush()
+			section = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && last == "baseurl" {
+			// additional base URLs can be listed on continuation lines
+			urls = append(urls, strings.Fields(trimmed)...)
+			continue
+		}
+		kv := strings.SplitN(trimmed, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		last = strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.TrimSpace(kv[1])
+		switch last {
+		case "enabled":
+			enabled = value != "0" && !strings.EqualFold(value, "false") && !strings.EqualFold(value, "no")
+		case "baseurl":
+			urls = append(urls, strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })...)
+		case "mirrorlist", "metalink":
+			urls = append(urls, value)
+		}
+	}
+	flush()
+	return repos
+}
+
+// parseRepos returns the package manager and the enabled repositories found
+// in the output of the commands.
+func parseRepos(out []byte) (string, []repo, error) {
+	manager, files, order, err := splitFiles(out)
+	if err != nil {
+		return "", nil, err
+	}
+	if manager != ManagerApt && manager != ManagerYum {
+		return "", nil, fmt.Errorf("unsupported package manager %q", manager)
+	}
+	var repos []repo
+	for _, file := range order {
+		switch {
+		case manager == ManagerYum:
+			repos = append(repos, parseYum(file, files[file])...)
+		case strings.HasSuffix(file, ".sources"):
+			repos = append(repos, parseAptSources(file, files[file])...)
+		default:
+			repos = append(repos, parseAptList(file, files[file])...)
+		}
+	}
+	return manager, repos, nil
+}
+
+func (ts *RepoCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	result, err := ts.RunSSHCommand(cancel, pause, target, commands[ts.Manager])
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command exited with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	manager, repos, err := parseRepos(result.Stdout)
+	if err != nil {
+		return err
+	}
+	payload := repoEventPayload{Manager: manager, Repos: repos}
+	for i, re := range ts.required {
+		found := false
+		for _, r := range repos {
+			if r.matches(re) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			payload.Missing = append(payload.Missing, ts.Required[i])
+		}
+	}
+	for i, re := range ts.forbidden {
+		for _, r := range repos {
+			if r.matches(re) {
+				payload.Forbidden = append(payload.Forbidden, fmt.Sprintf("%s (%s in %s)", ts.Forbidden[i], r.ID, r.File))
+				break
+			}
+		}
+	}
+	if len(payload.Missing) > 0 || len(payload.Forbidden) > 0 {
+		if err := teststeps.EmitTargetEvent(ev, EventRepoMismatch, target, payload); err != nil {
+			return err
+		}
+		var problems []string
+		if len(payload.Missing) > 0 {
+			problems = append(problems, "missing required repositories: "+strings.Join(payload.Missing, ", "))
+		}
+		if len(payload.Forbidden) > 0 {
+			problems = append(problems, "forbidden repositories enabled: "+strings.Join(payload.Forbidden, ", "))
+		}
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return teststeps.EmitTargetEvent(ev, EventRepoOK, target, payload)
+}
+
+// Run executes the package repository check step.
+func (ts *RepoCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as it only reads the repository files.
+func (ts *RepoCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// patternsParam parses a list of repository patterns.
+func patternsParam(params test.TestStepParameters, name string, seen map[string]string) ([]string, []*regexp.Regexp, error) {
+	var (
+		patterns []string
+		res      []*regexp.Regexp
+	)
+	for _, p := range params.Get(name) {
+		pattern := strings.TrimSpace(p.Raw())
+		if !patternRe.MatchString(pattern) || strings.Trim(pattern, "*/") == "" {
+			return nil, nil, fmt.Errorf("invalid '%s' parameter: invalid repository pattern %q", name, pattern)
+		}
+		if other, ok := seen[pattern]; ok {
+			return nil, nil, fmt.Errorf("invalid '%s' parameter: repository %s is already listed in '%s'", name, pattern, other)
+		}
+		seen[pattern] = name
+		patterns = append(patterns, pattern)
+		res = append(res, compilePattern(pattern))
+	}
+	return patterns, res, nil
+}
+
+func (ts *RepoCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	ts.Manager = strings.ToLower(params.GetOne("package_manager").Raw())
+	switch ts.Manager {
+	case "":
+		ts.Manager = ManagerAuto
+	case "dnf":
+		ts.Manager = ManagerYum
+	}
+	if _, ok := commands[ts.Manager]; !ok {
+		return fmt.Errorf("invalid 'package_manager' parameter %q, must be %s, %s or %s", ts.Manager, ManagerAuto, ManagerApt, ManagerYum)
+	}
+	seen := make(map[string]string)
+	if ts.Required, ts.required, err = patternsParam(params, "required", seen); err != nil {
+		return err
+	}
+	if ts.Forbidden, ts.forbidden, err = patternsParam(params, "forbidden", seen); err != nil {
+		return err
+	}
+	if len(ts.Required) == 0 && len(ts.Forbidden) == 0 {
+		return errors.New("missing 'required' or 'forbidden' parameter, at least one repository must be specified")
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *RepoCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. RepoCheck cannot
+// resume.
+func (ts *RepoCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *RepoCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new RepoCheck test step.
+func New() test.TestStep {
+	return &RepoCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
@@ -0,0 +1,399 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package repocheck
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func lines(s string) []string {
+	return strings.Split(strings.TrimPrefix(s, "\n"), "\n")
+}
+
+func TestParseAptList(t *testing.T) {
+	const file = "/etc/apt/sources.list.d/docker.list"
+	for _, tc := range []struct {
+		name    string
+		content string
+		repos   []repo
+	}{
+		{
+			name:    "source",
+			content: "deb http://archive.ubuntu.com/ubuntu jammy main restricted",
+			repos:   []repo{{ID: "docker", URL: "http://archive.ubuntu.com/ubuntu", Suite: "jammy", File: file}},
+		},
+		{
+			name: "binary and source packages",
+			content: `
+deb http://deb.debian.org/debian bookworm main
+deb-src http://deb.debian.org/debian bookworm main`,
+			repos: []repo{
+				{ID: "docker", URL: "http://deb.debian.org/debian", Suite: "bookworm", File: file},
+				{ID: "docker", URL: "http://deb.debian.org/debian", Suite: "bookworm", File: file},
+			},
+		},
+		{
+			name:    "options",
+			content: "deb [arch=amd64 signed-by=/etc/apt/keyrings/docker.gpg] https://download.docker.com/linux/ubuntu jammy stable",
+			repos:   []repo{{ID: "docker", URL: "https://download.docker.com/linux/ubuntu", Suite: "jammy", File: file}},
+		},
+		{
+			name:    "options with spaces",
+			content: "deb [ arch=amd64 trusted=yes ] http://repo.example.com/apt ./",
+			repos:   []repo{{ID: "docker", URL: "http://repo.example.com/apt", Suite: "./", File: file}},
+		},
+		{
+			name:    "unterminated options",
+			content: "deb [arch=amd64 http://repo.example.com/apt stable main",
+		},
+		{
+			name:    "URI with brackets",
+			content: "deb cdrom:[Ubuntu 22.04 LTS _Jammy Jellyfish_ - Release amd64 (20220419)]/ jammy main restricted",
+			repos:   []repo{{ID: "docker", URL: "cdrom:[Ubuntu 22.04 LTS _Jammy Jellyfish_ - Release amd64 (20220419)]/", Suite: "jammy", File: file}},
+		},
+		{
+			name:    "unterminated URI brackets",
+			content: "deb cdrom:[Ubuntu 22.04 LTS jammy main",
+		},
+		{
+			name: "comments",
+			content: `
+# deb http://archive.canonical.com/ubuntu jammy partner
+deb http://security.ubuntu.com/ubuntu jammy-security main # security updates
+   # deb-src http://security.ubuntu.com/ubuntu jammy-security main`,
+			repos: []repo{{ID: "docker", URL: "http://security.ubuntu.com/ubuntu", Suite: "jammy-security", File: file}},
+		},
+		{
+			name: "invalid lines",
+			content: `
+deb http://archive.ubuntu.com/ubuntu
+deb [arch=amd64] http://archive.ubuntu.com/ubuntu
+rpm http://archive.ubuntu.com/ubuntu jammy main
+Types: deb`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.repos, parseAptList(file, lines(tc.content)))
+		})
+	}
+}
+
+func TestParseAptSources(t *testing.T) {
+	const file = "/etc/apt/sources.list.d/ubuntu.sources"
+	for _, tc := range []struct {
+		name    string
+		content string
+		repos   []repo
+	}{
+		{
+			name: "paragraphs",
+			content: `
+Types: deb
+URIs: http://archive.ubuntu.com/ubuntu
+Suites: jammy jammy-updates jammy-backports
+Components: main restricted universe multiverse
+Signed-By: /usr/share/keyrings/ubuntu-archive-keyring.gpg
+
+Types: deb
+URIs: http://security.ubuntu.com/ubuntu
+Suites: jammy-security
+Components: main restricted universe multiverse
+Signed-By: /usr/share/keyrings/ubuntu-archive-keyring.gpg`,
+			repos: []repo{
+				{ID: "ubuntu", URL: "http://archive.ubuntu.com/ubuntu", Suite: "jammy", File: file},
+				{ID: "ubuntu", URL: "http://security.ubuntu.com/ubuntu", Suite: "jammy-security", File: file},
+			},
+		},
+		{
+			name: "several URIs",
+			content: `
+Types: deb deb-src
+URIs: http://mirror1.example.com/debian http://mirror2.example.com/debian
+Suites: bookworm
+Components: main`,
+			repos: []repo{
+				{ID: "ubuntu", URL: "http://mirror1.example.com/debian", Suite: "bookworm", File: file},
+				{ID: "ubuntu", URL: "http://mirror2.example.com/debian", Suite: "bookworm", File: file},
+			},
+		},
+		{
+			name: "disabled paragraph",
+			content: `
+Types: deb
+URIs: http://archive.ubuntu.com/ubuntu
+Suites: jammy
+Enabled: no
+
+Types: deb
+URIs: http://ppa.launchpad.net/example/ppa/ubuntu
+Suites: jammy
+Enabled: yes`,
+			repos: []repo{{ID: "ubuntu", URL: "http://ppa.launchpad.net/example/ppa/ubuntu", Suite: "jammy", File: file}},
+		},
+		{
+			name: "multi-line fields",
+			content: `
+Types: deb
+URIs: https://repo.example.com/apt
+ https://mirror.example.com/apt
+Suites: stable
+Components: main
+Signed-By:
+ -----BEGIN PGP PUBLIC KEY BLOCK-----
+ .
+ mQINBGGqEd4BEADGzsrh9B3bGxbHc1uZgL2wOf8VNrnhbJfzZ6p3uWw1nQ==
+ -----END PGP PUBLIC KEY BLOCK-----`,
+			repos: []repo{
+				{ID: "ubuntu", URL: "https://repo.example.com/apt", Suite: "stable", File: file},
+				{ID: "ubuntu", URL: "https://mirror.example.com/apt", Suite: "stable", File: file},
+			},
+		},
+		{
+			name: "comments and case",
+			content: `
+# the main archive
+types: deb
+# URIs: http://old.example.com/ubuntu
+uris: http://archive.ubuntu.com/ubuntu
+SUITES: noble
+ENABLED: NO
+
+types: deb
+uris: http://archive.ubuntu.com/ubuntu
+suites: noble`,
+			repos: []repo{{ID: "ubuntu", URL: "http://archive.ubuntu.com/ubuntu", Suite: "noble", File: file}},
+		},
+		{
+			name: "paragraph without URIs",
+			content: `
+Types: deb
+Suites: jammy`,
+		},
+		{
+			name: "paragraph without suites",
+			content: `
+Types: deb
+URIs: http://repo.example.com/flat`,
+			repos: []repo{{ID: "ubuntu", URL: "http://repo.example.com/flat", File: file}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.repos, parseAptSources(file, lines(tc.content)))
+		})
+	}
+}
+
+func TestParseYum(t *testing.T) {
+	const file = "/etc/yum.repos.d/example.repo"
+	for _, tc := range []struct {
+		name    string
+		content string
+		repos   []repo
+	}{
+		{
+			name: "repositories",
+			content: `
+[baseos]
+name=Rocky Linux $releasever - BaseOS
+mirrorlist=https://mirrors.rockylinux.org/mirrorlist?arch=$basearch&repo=BaseOS-$releasever
+gpgcheck=1
+enabled=1
+
+[appstream]
+name=Rocky Linux $releasever - AppStream
+baseurl=http://dl.rockylinux.org/$contentdir/$releasever/AppStream/$basearch/os/
+gpgcheck=1`,
+			repos: []repo{
+				{ID: "baseos", URL: "https://mirrors.rockylinux.org/mirrorlist?arch=$basearch&repo=BaseOS-$releasever", File: file},
+				{ID: "appstream", URL: "http://dl.rockylinux.org/$contentdir/$releasever/AppStream/$basearch/os/", File: file},
+			},
+		},
+		{
+			name: "main section",
+			content: `
+[main]
+gpgcheck=1
+installonly_limit=3
+baseurl=http://ignored.example.com/`,
+		},
+		{
+			name: "disabled repositories",
+			content: `
+[epel-testing]
+metalink=https://mirrors.fedoraproject.org/metalink?repo=testing-epel9&arch=$basearch
+enabled=0
+
+[epel-debug]
+baseurl=http://download.example/pub/epel/9/Everything/$basearch/debug
+enabled = False
+
+[epel-source]
+baseurl=http://download.example/pub/epel/9/Everything/source/tree/
+enabled=no
+
+[epel]
+metalink=https://mirrors.fedoraproject.org/metalink?repo=epel-9&arch=$basearch
+enabled=true`,
+			repos: []repo{{ID: "epel", URL: "https://mirrors.fedoraproject.org/metalink?repo=epel-9&arch=$basearch", File: file}},
+		},
+		{
+			name: "several base URLs",
+			content: `
+[internal]
+baseurl=http://mirror1.example.com/el9/, http://mirror2.example.com/el9/
+  http://mirror3.example.com/el9/
+	http://mirror4.example.com/el9/ http://mirror5.example.com/el9/
+gpgcheck=0`,
+			repos: []repo{
+				{ID: "internal", URL: "http://mirror1.example.com/el9/", File: file},
+				{ID: "internal", URL: "http://mirror2.example.com/el9/", File: file},
+				{ID: "internal", URL: "http://mirror3.example.com/el9/", File: file},
+				{ID: "internal", URL: "http://mirror4.example.com/el9/", File: file},
+				{ID: "internal", URL: "http://mirror5.example.com/el9/", File: file},
+			},
+		},
+		{
+			name: "continuation lines of other options",
+			content: `
+[internal]
+baseurl=http://mirror1.example.com/el9/
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-one
+  file:///etc/pki/rpm-gpg/RPM-GPG-KEY-two`,
+			repos: []repo{{ID: "internal", URL: "http://mirror1.example.com/el9/", File: file}},
+		},
+		{
+			name: "repository without URL",
+			content: `
+[local]
+name=Local packages`,
+			repos: []repo{{ID: "local", File: file}},
+		},
+		{
+			name: "comments",
+			content: `
+# [commented]
+; baseurl=http://commented.example.com/
+[ spaced ]
+BaseURL = http://repo.example.com/el9/
+#enabled=0`,
+			repos: []repo{{ID: "spaced", URL: "http://repo.example.com/el9/", File: file}},
+		},
+		{
+			name: "options outside of a section",
+			content: `
+baseurl=http://orphan.example.com/`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.repos, parseYum(file, lines(tc.content)))
+		})
+	}
+}
+
+func TestParseRepos(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		out     string
+		manager string
+		repos   []repo
+		err     string
+	}{
+		{
+			name: "apt",
+			out: `manager=apt
+==> /etc/apt/sources.list
+deb http://archive.ubuntu.com/ubuntu jammy main
+
+==> /etc/apt/sources.list.d/docker.list
+deb [arch=amd64] https://download.docker.com/linux/ubuntu jammy stable
+
+==> /etc/apt/sources.list.d/ubuntu.sources
+Types: deb
+URIs: http://security.ubuntu.com/ubuntu
+Suites: jammy-security
+
+`,
+			manager: ManagerApt,
+			repos: []repo{
+				{ID: "sources", URL: "http://archive.ubuntu.com/ubuntu", Suite: "jammy", File: "/etc/apt/sources.list"},
+				{ID: "docker", URL: "https://download.docker.com/linux/ubuntu", Suite: "jammy", File: "/etc/apt/sources.list.d/docker.list"},
+				{ID: "ubuntu", URL: "http://security.ubuntu.com/ubuntu", Suite: "jammy-security", File: "/etc/apt/sources.list.d/ubuntu.sources"},
+			},
+		},
+		{
+			name: "yum",
+			out: `manager=yum
+==> /etc/yum.conf
+[main]
+gpgcheck=1
+
+==> /etc/yum.repos.d/epel.repo
+[epel]
+metalink=https://mirrors.fedoraproject.org/metalink?repo=epel-9&arch=$basearch
+
+==> /etc/yum.repos.d/internal.repo
+[internal]
+baseurl=http://repo.example.com/el9/
+`,
+			manager: ManagerYum,
+			repos: []repo{
+				{ID: "epel", URL: "https://mirrors.fedoraproject.org/metalink?repo=epel-9&arch=$basearch", File: "/etc/yum.repos.d/epel.repo"},
+				{ID: "internal", URL: "http://repo.example.com/el9/", File: "/etc/yum.repos.d/internal.repo"},
+			},
+		},
+		{
+			name:    "no repository",
+			out:     "manager=apt\n",
+			manager: ManagerApt,
+		},
+		{
+			name: "no package manager",
+			out:  "==> /etc/apt/sources.list\n",
+			err:  "cannot detect the package manager",
+		},
+		{
+			name: "unsupported package manager",
+			out:  "manager=none\n",
+			err:  `unsupported package manager "none"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			manager, repos, err := parseRepos([]byte(tc.out))
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.manager, manager)
+			require.Equal(t, tc.repos, repos)
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	r := repo{ID: "docker", URL: "https://download.docker.com/linux/ubuntu/", File: "/etc/apt/sources.list.d/docker.list"}
+	for _, tc := range []struct {
+		pattern string
+		matches bool
+	}{
+		{"docker", true},
+		{"dock", false},
+		{"dock*", true},
+		{"https://download.docker.com/linux/ubuntu", true},
+		{"https://download.docker.com/linux/ubuntu/", true},
+		{"https://download.docker.com/linux", false},
+		{"*docker.com*", true},
+		{"*.example.com*", false},
+		{"https://download.docker.com/linux/*/", true},
+	} {
+		t.Run(tc.pattern, func(t *testing.T) {
+			require.Equal(t, tc.matches, r.matches(compilePattern(tc.pattern)))
+		})
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package retry implements a test step decorator which retries the targets
+// failed by the wrapped step. It is kept for the callers which wrap steps in
+// code: in job descriptors, the "retry" policy of a test step does the same
+// and is honored by the test runner, see test.WithRetry.
+package retry
+
+import (
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/insomniacslk/xjson"
+)
+
+// Wrap returns a test step which runs inner, and runs it again on the targets
+// it failed, up to maxAttempts times in total, waiting backoff between the
+// attempts. A maxAttempts lower than 1 is treated as 1, i.e. no retries.
+func Wrap(inner test.TestStep, maxAttempts int, backoff time.Duration) test.TestStep {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return test.WithRetry(inner, test.RetryPolicy{Count: uint(maxAttempts - 1), Backoff: xjson.Duration(backoff)})
+}
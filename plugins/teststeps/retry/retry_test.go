@@ -0,0 +1,177 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/facebookincubator/contest/plugins/teststeps/slowecho"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyEcho runs slowecho, and fails the targets in failing as many times as
+// their count.
+type flakyEcho struct {
+	test.TestStep
+	mu      sync.Mutex
+	failing map[string]int
+	runs    int
+}
+
+func (f *flakyEcho) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	f.mu.Lock()
+	f.runs++
+	f.mu.Unlock()
+	out := make(chan *target.Target)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- f.TestStep.Run(ctx, pause, test.TestStepChannels{In: ch.In, Out: out, Err: ch.Err}, params, ev)
+		close(out)
+	}()
+	for tgt := range out {
+		f.mu.Lock()
+		fail := f.failing[tgt.Name] > 0
+		if fail {
+			f.failing[tgt.Name]--
+		}
+		f.mu.Unlock()
+		if fail {
+			ch.Err <- cerrors.TargetError{Target: tgt, Err: errors.New("transient failure")}
+		} else {
+			ch.Out <- tgt
+		}
+	}
+	return <-errCh
+}
+
+func newTargets(n int) chan *target.Target {
+	in := make(chan *target.Target, n)
+	for i := 0; i < n; i++ {
+		in <- &target.Target{Name: fmt.Sprintf("host%d", i), ID: fmt.Sprintf("%d", i)}
+	}
+	close(in)
+	return in
+}
+
+func runWrapped(t *testing.T, step test.TestStep, numTargets int) (map[string]int, map[string]error, error) {
+	storage.SetStorage(memory.New())
+	ev := storage.NewTestEventEmitterFetcher(testevent.Header{JobID: "1", RunID: 1, TestName: "test", TestStepLabel: "retry"})
+	params := test.TestStepParameters{
+		"text":  []test.Param{*test.NewParam("hello")},
+		"sleep": []test.Param{*test.NewParam("10ms")},
+	}
+	require.NoError(t, step.ValidateParameters(params))
+	var (
+		out   = make(chan *target.Target, numTargets)
+		errCh = make(chan cerrors.TargetError, numTargets)
+	)
+	err := step.Run(context.Background(), make(chan struct{}), test.TestStepChannels{In: newTargets(numTargets), Out: out, Err: errCh}, params, ev)
+	close(out)
+	close(errCh)
+	passed := make(map[string]int)
+	for tgt := range out {
+		passed[tgt.Name]++
+	}
+	failed := make(map[string]error)
+	for terr := range errCh {
+		_, dup := failed[terr.Target.Name]
+		require.False(t, dup, "target %s failed twice", terr.Target.Name)
+		failed[terr.Target.Name] = terr.Err
+	}
+	return passed, failed, err
+}
+
+func TestWrapPassesThrough(t *testing.T) {
+	step := Wrap(slowecho.New(), 3, time.Millisecond)
+	require.Equal(t, slowecho.Name, step.Name())
+	require.True(t, step.CanResume())
+	require.Error(t, step.ValidateParameters(test.TestStepParameters{}))
+
+	passed, failed, err := runWrapped(t, step, 10)
+	require.NoError(t, err)
+	require.Empty(t, failed)
+	require.Len(t, passed, 10)
+	for name, n := range passed {
+		require.Equal(t, 1, n, "target %s forwarded %d times", name, n)
+	}
+}
+
+func TestRetriesFailedTargetsOnly(t *testing.T) {
+	inner := &flakyEcho{TestStep: slowecho.New(), failing: map[string]int{"host1": 1, "host3": 2}}
+	passed, failed, err := runWrapped(t, Wrap(inner, 3, time.Millisecond), 5)
+	require.NoError(t, err)
+	require.Empty(t, failed)
+	require.Len(t, passed, 5)
+	for name, n := range passed {
+		require.Equal(t, 1, n, "target %s forwarded %d times", name, n)
+	}
+	require.Equal(t, 3, inner.runs)
+
+	// the targets which succeeded were echoed once, the retried ones once
+	// per attempt
+	events, err := storage.NewTestEventFetcher().Fetch(testevent.QueryJobID("1"), testevent.QueryEventName(slowecho.EventTargetAwake))
+	require.NoError(t, err)
+	awake := make(map[string]int)
+	for _, e := range events {
+		awake[e.Data.Target.Name]++
+	}
+	require.Equal(t, map[string]int{"host0": 1, "host1": 2, "host2": 1, "host3": 3, "host4": 1}, awake)
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	inner := &flakyEcho{TestStep: slowecho.New(), failing: map[string]int{"host0": 5}}
+	passed, failed, err := runWrapped(t, Wrap(inner, 2, time.Millisecond), 2)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"host1": 1}, passed)
+	require.Len(t, failed, 1)
+	require.EqualError(t, failed["host0"], "transient failure")
+	require.Equal(t, 2, inner.runs)
+}
+
+func TestNoRetries(t *testing.T) {
+	inner := &flakyEcho{TestStep: slowecho.New(), failing: map[string]int{"host0": 1}}
+	_, failed, err := runWrapped(t, Wrap(inner, 0, time.Millisecond), 1)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	require.Equal(t, 1, inner.runs)
+}
+
+func TestCancelDuringBackoff(t *testing.T) {
+	storage.SetStorage(memory.New())
+	ev := storage.NewTestEventEmitterFetcher(testevent.Header{JobID: "1", RunID: 1, TestName: "test", TestStepLabel: "retry"})
+	params := test.TestStepParameters{
+		"text":  []test.Param{*test.NewParam("hello")},
+		"sleep": []test.Param{*test.NewParam("0")},
+	}
+	inner := &flakyEcho{TestStep: slowecho.New(), failing: map[string]int{"host0": 1}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		ch := test.TestStepChannels{In: newTargets(1), Out: make(chan *target.Target, 1), Err: make(chan cerrors.TargetError, 1)}
+		errCh <- Wrap(inner, 3, time.Hour).Run(ctx, make(chan struct{}), ch, params, ev)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("step did not return after cancellation")
+	}
+	require.Equal(t, 1, inner.runs)
+}
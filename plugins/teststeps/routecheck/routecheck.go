@@ -0,0 +1,531 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package routecheck
+
+// The RouteCheck plugin reads the routing table of each target over SSH, and
+// fails the targets which miss any of the required routes, or have any of the
+// forbidden ones, with a TargetRouteMismatch event.
+//
+// Routes are specified as "[type] destination [via gateway] [dev device]",
+// e.g. "default via 10.0.0.1", "10.1.0.0/16 dev eth1" or
+// "blackhole 10.9.0.0/16". The destination is "default", a CIDR prefix or an
+// address, for a host route. A route matches a specification if it has the
+// same type, unicast if unspecified, and destination, and the specified
+// gateway and device, if any. The output of "ip route", of its JSON form
+// "ip -j route" and of the legacy "route -n" is understood.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "RouteCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventRoutesOK      = event.Name("TargetRoutesOK")
+	EventRouteMismatch = event.Name("TargetRouteMismatch")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventRoutesOK, EventRouteMismatch}
+
+// defaultCommand prints the IPv4 and IPv6 routing tables, with the legacy
+// route command if iproute2 is not installed.
+const defaultCommand = "if command -v ip >/dev/null 2>&1; then ip -4 route show && ip -6 route show; else route -n && route -n -A inet6 2>/dev/null; true; fi"
+
+const unicast = "unicast"
+
+// routeTypes are the route types printed by "ip route" before the
+// destination. Unicast routes have no type.
+var routeTypes = map[string]bool{
+	unicast: true, "local": true, "broadcast": true, "multicast": true, "anycast": true,
+	"blackhole": true, "unreachable": true, "prohibit": true, "throw": true, "nat": true,
+}
+
+// route is an entry of a routing table, or a route specification, in which
+// case the empty fields match any value.
+type route struct {
+	Type string
+	// Destination is the canonical destination prefix, or "default".
+	Destination string
+	Gateway     net.IP
+	Device      string
+}
+
+func (r route) String() string {
+	s := r.Destination
+	if r.Type != unicast {
+		s = r.Type + " " + s
+	}
+	if r.Gateway != nil {
+		s += " via " + r.Gateway.String()
+	}
+	if r.Device != "" {
+		s += " dev " + r.Device
+	}
+	return s
+}
+
+// matches tells whether the route matches the specification.
+func (r route) matches(spec route) bool {
+	return r.Type == spec.Type && r.Destination == spec.Destination &&
+		(spec.Gateway == nil || spec.Gateway.Equal(r.Gateway)) &&
+		(spec.Device == "" || spec.Device == r.Device)
+}
+
+// parseDestination returns the canonical form of a destination: "default",
+// or the network prefix. Addresses without a prefix length are host routes.
+func parseDestination(s string) (string, error) {
+	if s == "default" {
+		return s, nil
+	}
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return "", fmt.Errorf("invalid destination %q", s)
+		}
+		if ip.To4() != nil {
+			s += "/32"
+		} else {
+			s += "/128"
+		}
+	}
+	_, prefix, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid destination %q", s)
+	}
+	if ones, _ := prefix.Mask.Size(); ones == 0 {
+		return "default", nil
+	}
+	return prefix.String(), nil
+}
+
+// parseSpec parses a route specification.
+func parseSpec(s string) (route, error) {
+	fields := strings.Fields(s)
+	r := route{Type: unicast}
+	if len(fields) > 0 && routeTypes[fields[0]] {
+		r.Type, fields = fields[0], fields[1:]
+	}
+	if len(fields) == 0 {
+		return r, fmt.Errorf("missing destination in route %q", s)
+	}
+	var err error
+	if r.Destination, err = parseDestination(fields[0]); err != nil {
+		return r, err
+	}
+	for fields = fields[1:]; len(fields) > 0; fields = fields[2:] {
+		if len(fields) < 2 {
+			return r, fmt.Errorf("missing value for %q in route %q", fields[0], s)
+		}
+		switch fields[0] {
+		case "via":
+			if r.Gateway != nil {
+				return r, fmt.Errorf("gateway specified more than once in route %q", s)
+			}
+			if r.Gateway = net.ParseIP(fields[1]); r.Gateway == nil {
+				return r, fmt.Errorf("invalid gateway %q in route %q", fields[1], s)
+			}
+		case "dev":
+			if r.Device != "" {
+				return r, fmt.Errorf("device specified more than once in route %q", s)
+			}
+			r.Device = fields[1]
+		default:
+			return r, fmt.Errorf("unknown keyword %q in route %q, must be via or dev", fields[0], s)
+		}
+	}
+	return r, nil
+}
+
+// parseIPRoute parses a line of "ip route" output, e.g.
+// "default via 10.0.0.1 dev eth0 proto dhcp metric 100". The attributes other
+// than the gateway and the device are ignored.
+func parseIPRoute(fields []string) (route, error) {
+	r := route{Type: unicast}
+	if routeTypes[fields[0]] {
+		r.Type, fields = fields[0], fields[1:]
+	}
+	if len(fields) == 0 {
+		return r, errors.New("missing destination")
+	}
+	var err error
+	if r.Destination, err = parseDestination(fields[0]); err != nil {
+		return r, err
+	}
+	parseNextHop(&r, fields[1:])
+	return r, nil
+}
+
+// parseNextHop sets the gateway and device found in the attributes of a
+// route.
+func parseNextHop(r *route, fields []string) {
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "via":
+			// IPv4 routes may have an IPv6 gateway, e.g. "via inet6 fe80::1"
+			if fields[i+1] == "inet" || fields[i+1] == "inet6" {
+				i++
+				if i+1 >= len(fields) {
+					return
+				}
+			}
+			r.Gateway = net.ParseIP(fields[i+1])
+			i++
+		case "dev":
+			r.Device = fields[i+1]
+			i++
+		}
+	}
+}
+
+// parseLegacyRoute parses a line of "route -n" output. IPv4 routes have the
+// fields destination, gateway, netmask, flags and the device last, IPv6 ones
+// the destination prefix, next hop, flags and the device last.
+func parseLegacyRoute(fields []string, ipv6 bool) (route, error) {
+	r := route{Type: unicast}
+	minFields := 8
+	if ipv6 {
+		minFields = 7
+	}
+	if len(fields) < minFields {
+		return r, errors.New("not enough fields")
+	}
+	dest, flags := fields[0], fields[3]
+	if ipv6 {
+		flags = fields[2]
+	} else {
+		mask := net.ParseIP(fields[2]).To4()
+		if mask == nil {
+			return r, fmt.Errorf("invalid netmask %q", fields[2])
+		}
+		ones, _ := net.IPMask(mask).Size()
+		dest = fmt.Sprintf("%s/%d", dest, ones)
+	}
+	var err error
+	if r.Destination, err = parseDestination(dest); err != nil {
+		return r, err
+	}
+	if strings.Contains(flags, "!") {
+		// rejected routes, e.g. "!H", are listed by ip route as unreachable
+		r.Type = "unreachable"
+	}
+	if gw := net.ParseIP(fields[1]); gw != nil && !gw.IsUnspecified() {
+		r.Gateway = gw
+	}
+	if dev := fields[len(fields)-1]; dev != "-" {
+		r.Device = dev
+	}
+	return r, nil
+}
+
+// jsonRoute is a route of "ip -j route" output, or a next hop of a multipath
+// route. The attributes other than the gateway and the device are ignored.
+type jsonRoute struct {
+	Type    string `json:"type"`
+	Dst     string `json:"dst"`
+	Gateway string `json:"gateway"`
+	// Via is set instead of the gateway for the IPv4 routes with an IPv6
+	// gateway.
+	Via *struct {
+		Host string `json:"host"`
+	} `json:"via"`
+	Dev      string      `json:"dev"`
+	NextHops []jsonRoute `json:"nexthops"`
+}
+
+// setNextHop sets the gateway and device of a route.
+func (jr *jsonRoute) setNextHop(r *route) {
+	gw := jr.Gateway
+	if jr.Via != nil {
+		gw = jr.Via.Host
+	}
+	if gw != "" {
+		r.Gateway = net.ParseIP(gw)
+	}
+	r.Device = jr.Dev
+}
+
+// parseJSONRoutes parses the output of "ip -j route", which may hold several
+// lists of routes, e.g. of the IPv4 and IPv6 tables. As for "ip route",
+// multipath routes are listed as one route per next hop.
+func parseJSONRoutes(out []byte) ([]route, error) {
+	var routes []route
+	d := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var list []jsonRoute
+		if err := d.Decode(&list); err == io.EOF {
+			return routes, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("cannot decode routes: %v", err)
+		}
+		for _, jr := range list {
+			r := route{Type: unicast}
+			if jr.Type != "" {
+				r.Type = jr.Type
+			}
+			var err error
+			if r.Destination, err = parseDestination(jr.Dst); err != nil {
+				return nil, fmt.Errorf("cannot parse route: %v", err)
+			}
+			if len(jr.NextHops) == 0 {
+				jr.setNextHop(&r)
+				routes = append(routes, r)
+				continue
+			}
+			for _, nh := range jr.NextHops {
+				hop := r
+				nh.setNextHop(&hop)
+				routes = append(routes, hop)
+			}
+		}
+	}
+}
+
+// parseRoutes parses the output of the route listing command.
+func parseRoutes(out []byte) ([]route, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(out), []byte("[")) {
+		return parseJSONRoutes(out)
+	}
+	var (
+		routes       []route
+		legacy, ipv6 bool
+		// last is the last route parsed from a line of "ip route" output,
+		// the head of a multipath route if next hops follow it.
+		last     *route
+		nextHops int
+	)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] == "Kernel" {
+			continue
+		}
+		if fields[0] == "Destination" {
+			// header of the route output, which tells the IP version
+			legacy, ipv6 = true, !strings.Contains(line, "Genmask")
+			continue
+		}
+		if legacy {
+			r, err := parseLegacyRoute(fields, ipv6)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse route %q: %v", line, err)
+			}
+			routes = append(routes, r)
+			continue
+		}
+		if fields[0] == "nexthop" {
+			// next hop of the preceding multipath route, which is replaced by
+			// one route per next hop
+			if last == nil {
+				return nil, fmt.Errorf("cannot parse route %q: next hop without route", line)
+			}
+			if nextHops == 0 {
+				routes = routes[:len(routes)-1]
+			}
+			nextHops++
+			r := route{Type: last.Type, Destination: last.Destination}
+			parseNextHop(&r, fields[1:])
+			routes = append(routes, r)
+			continue
+		}
+		r, err := parseIPRoute(fields)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse route %q: %v", line, err)
+		}
+		last, nextHops = &r, 0
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// routeEventPayload is the payload of the events emitted by this step.
+type routeEventPayload struct {
+	Required  []string `json:",omitempty"`
+	Forbidden []string `json:",omitempty"`
+	Missing   []string `json:",omitempty"`
+	// Present lists the routes matching forbidden specifications.
+	Present []string `json:",omitempty"`
+	Routes  []string
+}
+
+// RouteCheck verifies the routing tables of the targets.
+type RouteCheck struct {
+	*teststeps.SSHParams
+	Command   *test.Param
+	Required  []route
+	Forbidden []route
+}
+
+// Name returns the plugin name.
+func (ts RouteCheck) Name() string {
+	return Name
+}
+
+func routeStrings(routes []route) []string {
+	var s []string
+	for _, r := range routes {
+		s = append(s, r.String())
+	}
+	return s
+}
+
+func (ts *RouteCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	cmd, err := ts.Command.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand command parameter: %v", err)
+	}
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	routes, err := parseRoutes(result.Stdout)
+	if err != nil {
+		return err
+	}
+	payload := routeEventPayload{
+		Required:  routeStrings(ts.Required),
+		Forbidden: routeStrings(ts.Forbidden),
+		Routes:    routeStrings(routes),
+	}
+	for _, spec := range ts.Required {
+		found := false
+		for _, r := range routes {
+			if r.matches(spec) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			payload.Missing = append(payload.Missing, spec.String())
+		}
+	}
+	for _, spec := range ts.Forbidden {
+		for _, r := range routes {
+			if r.matches(spec) {
+				payload.Present = append(payload.Present, r.String())
+			}
+		}
+	}
+	if len(payload.Missing) == 0 && len(payload.Present) == 0 {
+		return teststeps.EmitTargetEvent(ev, EventRoutesOK, target, payload)
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventRouteMismatch, target, payload); err != nil {
+		return err
+	}
+	var problems []string
+	if len(payload.Missing) > 0 {
+		problems = append(problems, "missing routes: "+strings.Join(payload.Missing, ", "))
+	}
+	if len(payload.Present) > 0 {
+		problems = append(problems, "forbidden routes: "+strings.Join(payload.Present, ", "))
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// Run executes the route check step.
+func (ts *RouteCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the step normally, as it only reads the routing tables.
+func (ts *RouteCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// specsParam parses the route specifications of a parameter.
+func specsParam(params test.TestStepParameters, name string) ([]route, error) {
+	var specs []route
+	for _, p := range params.Get(name) {
+		spec, err := parseSpec(p.Raw())
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s' parameter: %v", name, err)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+func (ts *RouteCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.SSHParams, err = teststeps.NewSSHParams(params); err != nil {
+		return err
+	}
+	ts.Command = params.GetOne("command")
+	if ts.Command.IsEmpty() {
+		ts.Command = test.NewParam(defaultCommand)
+	}
+	if ts.Required, err = specsParam(params, "route"); err != nil {
+		return err
+	}
+	if ts.Forbidden, err = specsParam(params, "forbidden_route"); err != nil {
+		return err
+	}
+	if len(ts.Required) == 0 && len(ts.Forbidden) == 0 {
+		return errors.New("missing 'route' or 'forbidden_route' parameter, at least one route must be specified")
+	}
+	for _, r := range ts.Required {
+		for _, f := range ts.Forbidden {
+			// a required route matching a forbidden specification could
+			// never pass
+			if r.matches(f) {
+				return fmt.Errorf("route %s is both required and forbidden by %s", r, f)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *RouteCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. RouteCheck
+// cannot resume.
+func (ts *RouteCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *RouteCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new RouteCheck test step.
+func New() test.TestStep {
+	return &RouteCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
@@ -0,0 +1,226 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package routecheck
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ipRoute is the output of "ip -4 route show && ip -6 route show" on a host
+// with two uplinks, multipath routes and an IPv4 route with an IPv6 gateway.
+const ipRoute = `default via 10.0.0.1 dev eth0 proto dhcp src 10.0.0.5 metric 100
+default via 10.0.1.1 dev eth1 proto dhcp src 10.0.1.5 metric 200
+10.0.0.0/24 dev eth0 proto kernel scope link src 10.0.0.5
+10.0.0.1 dev eth0 proto dhcp scope link src 10.0.0.5 metric 100
+10.2.0.0/16 proto static metric 20
+	nexthop via 10.0.0.2 dev eth0 weight 1
+	nexthop via 10.0.1.2 dev eth1 weight 1
+10.3.0.0/16 via inet6 fe80::1 dev eth0 proto bgp
+blackhole 10.9.0.0/16 proto static
+unreachable 192.0.2.0/24 metric 1024
+172.16.0.0/12 via 10.0.1.1 dev eth1
+::1 dev lo proto kernel metric 256 pref medium
+2001:db8:1::/64 dev eth0 proto ra metric 100 pref medium
+2001:db8:2::/48 metric 1024 pref medium
+	nexthop via fe80::2 dev eth0 weight 1
+	nexthop via fe80::3 dev eth1 weight 1
+fe80::/64 dev eth0 proto kernel metric 256 pref medium
+default via fe80::1 dev eth0 proto ra metric 100 expires 1795sec pref medium
+`
+
+// ipJSONRoute is the output of "ip -j -4 route show && ip -j -6 route show"
+// on the same host.
+const ipJSONRoute = `[{"dst":"default","gateway":"10.0.0.1","dev":"eth0","protocol":"dhcp","prefsrc":"10.0.0.5","metric":100,"flags":[]},` +
+	`{"dst":"default","gateway":"10.0.1.1","dev":"eth1","protocol":"dhcp","prefsrc":"10.0.1.5","metric":200,"flags":[]},` +
+	`{"dst":"10.0.0.0/24","dev":"eth0","protocol":"kernel","scope":"link","prefsrc":"10.0.0.5","flags":[]},` +
+	`{"dst":"10.0.0.1","dev":"eth0","protocol":"dhcp","scope":"link","prefsrc":"10.0.0.5","metric":100,"flags":[]},` +
+	`{"dst":"10.2.0.0/16","protocol":"static","metric":20,"flags":[],"nexthops":[` +
+	`{"gateway":"10.0.0.2","dev":"eth0","weight":1,"flags":[]},{"gateway":"10.0.1.2","dev":"eth1","weight":1,"flags":[]}]},` +
+	`{"dst":"10.3.0.0/16","via":{"family":"inet6","host":"fe80::1"},"dev":"eth0","protocol":"bgp","flags":[]},` +
+	`{"type":"blackhole","dst":"10.9.0.0/16","protocol":"static","flags":[]},` +
+	`{"type":"unreachable","dst":"192.0.2.0/24","metric":1024,"flags":[]},` +
+	`{"dst":"172.16.0.0/12","gateway":"10.0.1.1","dev":"eth1","flags":[]}]
+[{"dst":"::1","dev":"lo","protocol":"kernel","metric":256,"flags":[],"pref":"medium"},` +
+	`{"dst":"2001:db8:1::/64","dev":"eth0","protocol":"ra","metric":100,"flags":[],"pref":"medium"},` +
+	`{"dst":"2001:db8:2::/48","metric":1024,"flags":[],"nexthops":[` +
+	`{"gateway":"fe80::2","dev":"eth0","weight":1,"flags":[]},{"gateway":"fe80::3","dev":"eth1","weight":1,"flags":[]}],"pref":"medium"},` +
+	`{"dst":"fe80::/64","dev":"eth0","protocol":"kernel","metric":256,"flags":[],"pref":"medium"},` +
+	`{"dst":"default","gateway":"fe80::1","dev":"eth0","protocol":"ra","metric":100,"flags":[],"expires":1795,"pref":"medium"}]
+`
+
+// ipRoutes are the routes of both ipRoute and ipJSONRoute.
+var ipRoutes = []string{
+	"default via 10.0.0.1 dev eth0",
+	"default via 10.0.1.1 dev eth1",
+	"10.0.0.0/24 dev eth0",
+	"10.0.0.1/32 dev eth0",
+	"10.2.0.0/16 via 10.0.0.2 dev eth0",
+	"10.2.0.0/16 via 10.0.1.2 dev eth1",
+	"10.3.0.0/16 via fe80::1 dev eth0",
+	"blackhole 10.9.0.0/16",
+	"unreachable 192.0.2.0/24",
+	"172.16.0.0/12 via 10.0.1.1 dev eth1",
+	"::1/128 dev lo",
+	"2001:db8:1::/64 dev eth0",
+	"2001:db8:2::/48 via fe80::2 dev eth0",
+	"2001:db8:2::/48 via fe80::3 dev eth1",
+	"fe80::/64 dev eth0",
+	"default via fe80::1 dev eth0",
+}
+
+// legacyRoute is the output of "route -n && route -n -A inet6".
+const legacyRoute = `Kernel IP routing table
+Destination     Gateway         Genmask         Flags Metric Ref    Use Iface
+0.0.0.0         10.0.0.1        0.0.0.0         UG    100    0        0 eth0
+10.0.0.0        0.0.0.0         255.255.255.0   U     0      0        0 eth0
+10.0.0.1        0.0.0.0         255.255.255.255 UH    100    0        0 eth0
+192.0.2.0       -               255.255.255.0   !     1024   -        0 -
+Kernel IPv6 routing table
+Destination                    Next Hop                   Flag Met Ref Use If
+2001:db8:1::/64                ::                         U    100 1     0 eth0
+fe80::/64                      ::                         U    256 1     0 eth0
+::/0                           fe80::1                    UGDAe 100 2     0 eth0
+::1/128                        ::                         Un   0   4     0 lo
+::/0                           ::                         !n   -1  1     0 lo
+`
+
+func TestParseRoutes(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		out    string
+		routes []string
+	}{
+		{"ip route", ipRoute, ipRoutes},
+		{"ip -j route", ipJSONRoute, ipRoutes},
+		{"route -n", legacyRoute, []string{
+			"default via 10.0.0.1 dev eth0",
+			"10.0.0.0/24 dev eth0",
+			"10.0.0.1/32 dev eth0",
+			"unreachable 192.0.2.0/24",
+			"2001:db8:1::/64 dev eth0",
+			"fe80::/64 dev eth0",
+			"default via fe80::1 dev eth0",
+			"::1/128 dev lo",
+			"unreachable default dev lo",
+		}},
+		{"local table", `local 10.0.0.5 dev eth0 table local proto kernel scope host src 10.0.0.5
+broadcast 10.0.0.255 dev eth0 table local proto kernel scope link src 10.0.0.5
+`, []string{"local 10.0.0.5/32 dev eth0", "broadcast 10.0.0.255/32 dev eth0"}},
+		{"local table as JSON", `[{"type":"local","dst":"10.0.0.5","dev":"eth0","table":"local","protocol":"kernel","scope":"host","prefsrc":"10.0.0.5","flags":[]}]`,
+			[]string{"local 10.0.0.5/32 dev eth0"}},
+		{"empty", "", nil},
+		{"empty JSON", "[]\n[]\n", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			routes, err := parseRoutes([]byte(tc.out))
+			require.NoError(t, err)
+			require.Equal(t, tc.routes, routeStrings(routes))
+		})
+	}
+}
+
+func TestParseRoutesErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		out  string
+		err  string
+	}{
+		{"invalid destination", "10.0.0.0/33 dev eth0\n", `cannot parse route "10.0.0.0/33 dev eth0": invalid destination "10.0.0.0/33"`},
+		{"type without destination", "blackhole\n", `cannot parse route "blackhole": missing destination`},
+		{"next hop without route", "\tnexthop via 10.0.0.2 dev eth0 weight 1\n", "next hop without route"},
+		{"invalid JSON", `[{"dst":"default",`, "cannot decode routes"},
+		{"invalid JSON destination", `[{"dst":"10.0.0"}]`, `cannot parse route: invalid destination "10.0.0"`},
+		{"legacy route without enough fields", "Destination Gateway Genmask Flags Metric Ref Use Iface\n0.0.0.0 10.0.0.1 0.0.0.0 UG eth0\n", "not enough fields"},
+		{"legacy route with an invalid netmask", "Destination Gateway Genmask Flags Metric Ref Use Iface\n0.0.0.0 10.0.0.1 ffff UG 0 0 0 eth0\n", `invalid netmask "ffff"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseRoutes([]byte(tc.out))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	for _, tc := range []struct {
+		spec  string
+		route string
+		err   string
+	}{
+		{spec: "default via 10.0.0.1", route: "default via 10.0.0.1"},
+		{spec: "0.0.0.0/0 dev eth0", route: "default dev eth0"},
+		{spec: "::/0 via fe80::1 dev eth0", route: "default via fe80::1 dev eth0"},
+		{spec: "10.1.2.3/16 dev eth1", route: "10.1.0.0/16 dev eth1"},
+		{spec: "10.0.0.1", route: "10.0.0.1/32"},
+		{spec: "2001:db8::1", route: "2001:db8::1/128"},
+		{spec: "blackhole 10.9.0.0/16", route: "blackhole 10.9.0.0/16"},
+		{spec: "unicast 10.0.0.0/24", route: "10.0.0.0/24"},
+		{spec: "", err: `missing destination in route ""`},
+		{spec: "unreachable", err: `missing destination in route "unreachable"`},
+		{spec: "10.0.0.0/24 via", err: `missing value for "via" in route "10.0.0.0/24 via"`},
+		{spec: "10.0.0.0/24 via 10.0.0.1 via 10.0.0.2", err: "gateway specified more than once"},
+		{spec: "10.0.0.0/24 dev eth0 dev eth1", err: "device specified more than once"},
+		{spec: "10.0.0.0/24 via gateway", err: `invalid gateway "gateway"`},
+		{spec: "10.0.0.0/24 metric 100", err: `unknown keyword "metric" in route "10.0.0.0/24 metric 100", must be via or dev`},
+		{spec: "nowhere", err: `invalid destination "nowhere"`},
+	} {
+		t.Run(tc.spec, func(t *testing.T) {
+			r, err := parseSpec(tc.spec)
+			if tc.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.route, r.String())
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	for name, out := range map[string]string{"ip route": ipRoute, "ip -j route": ipJSONRoute} {
+		routes, err := parseRoutes([]byte(out))
+		require.NoError(t, err)
+		for _, tc := range []struct {
+			spec string
+			// matched are the routes matching the spec: a required route
+			// is missing if there are none, and a forbidden one present if
+			// there are some.
+			matched []string
+		}{
+			{"default", []string{"default via 10.0.0.1 dev eth0", "default via 10.0.1.1 dev eth1", "default via fe80::1 dev eth0"}},
+			{"default via 10.0.0.1", []string{"default via 10.0.0.1 dev eth0"}},
+			{"0.0.0.0/0 dev eth1", []string{"default via 10.0.1.1 dev eth1"}},
+			{"default via 10.0.0.9", nil},
+			{"default via 10.0.0.1 dev eth1", nil},
+			{"10.2.0.0/16", []string{"10.2.0.0/16 via 10.0.0.2 dev eth0", "10.2.0.0/16 via 10.0.1.2 dev eth1"}},
+			{"10.2.0.0/16 via 10.0.1.2", []string{"10.2.0.0/16 via 10.0.1.2 dev eth1"}},
+			{"10.2.0.0/16 dev eth2", nil},
+			{"10.3.0.0/16 via fe80::1", []string{"10.3.0.0/16 via fe80::1 dev eth0"}},
+			{"10.0.0.1", []string{"10.0.0.1/32 dev eth0"}},
+			{"10.0.0.0/8", nil},
+			{"blackhole 10.9.0.0/16", []string{"blackhole 10.9.0.0/16"}},
+			{"10.9.0.0/16", nil},
+			{"unreachable 192.0.2.0/24", []string{"unreachable 192.0.2.0/24"}},
+			{"2001:db8:2::/48 via fe80::3 dev eth1", []string{"2001:db8:2::/48 via fe80::3 dev eth1"}},
+			{"::1 dev lo", []string{"::1/128 dev lo"}},
+		} {
+			t.Run(name+"/"+tc.spec, func(t *testing.T) {
+				spec, err := parseSpec(tc.spec)
+				require.NoError(t, err)
+				var matched []string
+				for _, r := range routes {
+					if r.matches(spec) {
+						matched = append(matched, r.String())
+					}
+				}
+				require.Equal(t, tc.matched, matched)
+			})
+		}
+	}
+}
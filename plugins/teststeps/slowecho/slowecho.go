@@ -6,6 +6,8 @@
 package slowecho
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -13,7 +15,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/facebookincubator/contest/pkg/cerrors"
 	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/logging"
@@ -26,8 +27,32 @@ var Name = "SlowEcho"
 
 var log = logging.GetLogger("teststeps/" + strings.ToLower(Name))
 
+// EventMessage is emitted with the text echoed for each target.
+var EventMessage = event.Name("SlowEchoMessage")
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	// EventTargetSleeping is emitted when a target starts sleeping.
+	EventTargetSleeping = event.Name("TargetSleeping")
+	// EventTargetAwake is emitted when the sleep of a target is over, right
+	// before the target is forwarded. Resume reads these events to tell the
+	// targets that do not need to sleep again.
+	EventTargetAwake = event.Name("TargetAwake")
+)
+
 // Events defines the events that a TestStep is allow to emit
-var Events = []event.Name{}
+var Events = []event.Name{EventMessage, EventTargetSleeping, EventTargetAwake}
+
+// messagePayload is the payload of EventMessage.
+type messagePayload struct {
+	Text string
+}
+
+// sleepPayload is the payload of EventTargetSleeping and EventTargetAwake.
+type sleepPayload struct {
+	Target string
+	Sleep  string
+}
 
 // Step implements an echo-style printing plugin.
 type Step struct {
@@ -49,16 +74,71 @@ func (e *Step) Name() string {
 	return Name
 }
 
+// sleepTime parses a sleep duration, either as a Go duration like "1500ms" or
+// "2m30s", or as a whole number of seconds.
 func sleepTime(secStr string) (time.Duration, error) {
-	seconds, err := strconv.Atoi(secStr)
+	sleep, err := time.ParseDuration(secStr)
 	if err != nil {
-		return 0, err
+		seconds, err := strconv.Atoi(secStr)
+		if err != nil {
+			return 0, fmt.Errorf("invalid sleep duration %q in slowecho parameters: must be a duration like 1500ms, or a number of seconds", secStr)
+		}
+		sleep = time.Duration(seconds) * time.Second
 	}
-	if seconds < 0 {
-		return 0, errors.New("seconds cannot be negative in slowecho parameters")
+	if sleep < 0 {
+		return 0, errors.New("sleep duration cannot be negative in slowecho parameters")
 	}
-	return time.Duration(seconds) * time.Second, nil
+	return sleep, nil
+}
 
+// isTemplate tells whether the sleep parameter is expanded per target, e.g.
+// "{{ .SleepSeconds }}", rather than being a literal duration.
+func isTemplate(secStr *test.Param) bool {
+	return strings.Contains(secStr.Raw(), "{{")
+}
+
+// targetSleepTime expands the sleep parameter against the fields of a target,
+// and returns the resulting duration.
+func targetSleepTime(secStr *test.Param, t *target.Target) (time.Duration, error) {
+	expanded, err := secStr.Expand(t)
+	if err != nil {
+		return 0, fmt.Errorf("cannot expand 'sleep' parameter for target %s: %v", t.Name, err)
+	}
+	sleep, err := sleepTime(strings.TrimSpace(expanded))
+	if err != nil {
+		return 0, fmt.Errorf("invalid 'sleep' value %q for target %s: %v", expanded, t.Name, err)
+	}
+	return sleep, nil
+}
+
+// concurrency parses the optional concurrency parameter, the maximum number of
+// targets sleeping at the same time. Zero means no limit.
+func concurrency(params test.TestStepParameters) (int, error) {
+	c := params.GetOne("concurrency")
+	if c.IsEmpty() {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(c.String()))
+	if err != nil {
+		return 0, fmt.Errorf("invalid 'concurrency' value %q in slowecho parameters: must be a whole number", c.String())
+	}
+	if n < 0 {
+		return 0, errors.New("concurrency cannot be negative in slowecho parameters")
+	}
+	return n, nil
+}
+
+// ParameterSchema declares the parameters of the step.
+func (e *Step) ParameterSchema() test.Schema {
+	return test.Schema{
+		Version: 1,
+		Parameters: []test.ParameterDefinition{
+			{Name: "text", Type: test.TypeString, Required: true, Description: "the text echoed for each target"},
+			// also takes a number of seconds, hence not a duration
+			{Name: "sleep", Type: test.TypeString, Required: true, Description: "how long each target sleeps, as a Go duration or a number of seconds"},
+			{Name: "concurrency", Type: test.TypeInt, Description: "the maximum number of targets sleeping at once, 0 for no limit"},
+		},
+	}
 }
 
 // ValidateParameters validates the parameters that will be passed to the Run
@@ -67,31 +147,92 @@ func (e *Step) ValidateParameters(params test.TestStepParameters) error {
 	if t := params.GetOne("text"); t.IsEmpty() {
 		return errors.New("missing 'text' field in slowecho parameters")
 	}
+	if _, err := concurrency(params); err != nil {
+		return err
+	}
 	secStr := params.GetOne("sleep")
 	if secStr.IsEmpty() {
 		return errors.New("missing 'sleep' field in slowecho parameters")
 	}
-
-	// no expression expansion here
-	if len(secStr.Raw()) != 1 {
-		return fmt.Errorf("invalid empty 'sleep' parameter: %v", secStr)
+	// expressions are expanded, and their values validated, per target at
+	// run time.
+	if isTemplate(secStr) {
+		return nil
 	}
 	_, err := sleepTime(secStr.Raw())
+	return err
+}
+
+// lockedEmitter serializes the events emitted by the goroutines of the
+// targets, as emitters are not required to be safe for concurrent use.
+type lockedEmitter struct {
+	lock sync.Mutex
+	ev   testevent.Emitter
+}
+
+func (l *lockedEmitter) emit(name event.Name, t *target.Target, payload interface{}) error {
+	data, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
-	return nil
+	raw := json.RawMessage(data)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.ev.Emit(testevent.Data{EventName: name, Target: t, Payload: &raw, Severity: testevent.SeverityInfo})
+}
+
+// emitSleep emits a sleep event of a target.
+func (l *lockedEmitter) emitSleep(name event.Name, t *target.Target, sleep time.Duration) {
+	if err := l.emit(name, t, sleepPayload{Target: t.Name, Sleep: sleep.String()}); err != nil {
+		log.Warningf("Could not emit %s event for target %s: %v", name, t, err)
+	}
 }
 
 // Run executes the step
-func (e *Step) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
-	sleep, err := sleepTime(params.GetOne("sleep").String())
+func (e *Step) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return e.run(ctx, pause, ch, params, ev, nil)
+}
+
+// run sleeps and forwards the incoming targets. Targets whose ID is in
+// completed already slept before the step was interrupted, and are forwarded
+// right away. The step stops when ctx is done, which includes the expiration
+// of its deadline, or when it is paused. With a concurrency parameter, at most
+// that many targets are handled at once, and the next targets are not read
+// from ch.In until a slot is released.
+func (e *Step) run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter, completed map[string]bool) error {
+	maxInFlight, err := concurrency(params)
 	if err != nil {
 		return err
 	}
+	secStr := params.GetOne("sleep")
+	var sleep time.Duration
+	if !isTemplate(secStr) {
+		if sleep, err = sleepTime(secStr.String()); err != nil {
+			return err
+		}
+	}
+	// slots is nil, hence never used, when the concurrency is not limited
+	var slots chan struct{}
+	if maxInFlight > 0 {
+		slots = make(chan struct{}, maxInFlight)
+	}
 	var wg sync.WaitGroup
+	output := test.NewStepOutput(test.CancelChannel(ctx), pause, ch)
+	emitter := &lockedEmitter{ev: ev}
+	stepMetrics := test.MetricsFromContext(ctx)
 processing:
 	for {
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				log.Infof("Requested cancellation: %v", ctx.Err())
+				break processing
+			case <-pause:
+				log.Infof("Requested pause")
+				break processing
+			}
+		}
 		select {
 		case t := <-ch.In:
 			if t == nil {
@@ -102,30 +243,58 @@ processing:
 			wg.Add(1)
 			go func(t *target.Target) {
 				defer wg.Done()
+				if slots != nil {
+					defer func() { <-slots }()
+				}
+				if completed[t.ID] {
+					log.Infof("Target %s already slept, forwarding it", t.Name)
+					if !output.Pass(t, "") {
+						log.Debug("Returning because cancellation or pause is requested")
+					}
+					return
+				}
+				sleep := sleep
+				if isTemplate(secStr) {
+					var err error
+					if sleep, err = targetSleepTime(secStr, t); err != nil {
+						log.Warningf("%v", err)
+						if !output.Fail(t, err.Error()) {
+							log.Debug("Returning because cancellation or pause is requested")
+						}
+						return
+					}
+				}
 				log.Infof("Waiting %v for target %s", sleep, t.Name)
+				emitter.emitSleep(EventTargetSleeping, t, sleep)
+				start := time.Now()
+				timer := time.NewTimer(sleep)
+				defer timer.Stop()
 				select {
-				case <-cancel:
-					log.Infof("Returning because cancellation is requested")
+				case <-ctx.Done():
+					log.Infof("Returning because the step is done: %v", ctx.Err())
 					return
 				case <-pause:
 					log.Infof("Returning because pause is requested")
 					return
-				case <-time.After(sleep):
+				case <-timer.C:
+				}
+				if err := stepMetrics.Observe("slowecho_sleep_seconds", time.Since(start).Seconds(), nil); err != nil {
+					log.Warningf("Could not record the sleep duration of target %s: %v", t, err)
 				}
 				log.Infof("target %s: %s", t, params.GetOne("text"))
-				select {
-				case <-cancel:
-					log.Debug("Returning because cancellation is requested")
-					return
-				case <-pause:
-					log.Debug("Returning because pause is requested")
-					return
-				default:
-					ch.Out <- t
+				if err := emitter.emit(EventMessage, t, messagePayload{Text: params.GetOne("text").String()}); err != nil {
+					log.Warningf("Could not emit %s event for target %s: %v", EventMessage, t, err)
+				}
+				// the event is recorded before the target is forwarded: if
+				// the server dies in between, Resume forwards it again
+				// rather than losing it.
+				emitter.emitSleep(EventTargetAwake, t, sleep)
+				if !output.Pass(t, "") {
+					log.Debug("Returning because cancellation or pause is requested")
 				}
 			}(t)
-		case <-cancel:
-			log.Infof("Requested cancellation")
+		case <-ctx.Done():
+			log.Infof("Requested cancellation: %v", ctx.Err())
 			break processing
 		case <-pause:
 			log.Infof("Requested pause")
@@ -138,13 +307,52 @@ processing:
 	return nil
 }
 
+// DryRun runs the step normally: it only waits and forwards the targets.
+func (e *Step) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return e.Run(ctx, pause, ch, params, ev)
+}
+
 // CanResume tells whether this step is able to resume.
 func (e Step) CanResume() bool {
-	return false
+	return true
+}
+
+// completedTargets returns the IDs of the targets which completed their sleep
+// in the current run of the step, as recorded by EventTargetAwake.
+func completedTargets(ev testevent.EmitterFetcher) (map[string]bool, error) {
+	h, ok := ev.(interface{ Header() testevent.Header })
+	if !ok {
+		return nil, errors.New("cannot resume: the event emitter has no header")
+	}
+	header := h.Header()
+	events, err := ev.Fetch(
+		testevent.QueryJobID(header.JobID),
+		testevent.QueryRunID(header.RunID),
+		testevent.QueryTestName(header.TestName),
+		testevent.QueryTestStepLabel(header.TestStepLabel),
+		testevent.QueryEventName(EventTargetAwake),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch %s events: %v", EventTargetAwake, err)
+	}
+	completed := make(map[string]bool)
+	for _, event := range events {
+		// not all the storage engines filter on the run ID
+		if event.Header.RunID != header.RunID || event.Data.Target == nil {
+			continue
+		}
+		completed[event.Data.Target.ID] = true
+	}
+	return completed, nil
 }
 
-// Resume tries to resume a previously interrupted test step. EchoStep cannot
-// resume.
-func (e Step) Resume(cancel, pause <-chan struct{}, _ test.TestStepChannels, _ test.TestStepParameters, ev testevent.EmitterFetcher) error {
-	return &cerrors.ErrResumeNotSupported{StepName: Name}
+// Resume resumes an interrupted step. The targets which completed their sleep
+// are forwarded immediately, the others sleep again from the start.
+func (e *Step) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	completed, err := completedTargets(ev)
+	if err != nil {
+		return err
+	}
+	log.Infof("Resuming with %d targets which already completed their sleep", len(completed))
+	return e.run(ctx, pause, ch, params, ev, completed)
 }
@@ -0,0 +1,287 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package slowecho
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSleepTime(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"500ms": 500 * time.Millisecond,
+		"2m":    2 * time.Minute,
+		"2m30s": 150 * time.Second,
+		"0":     0,
+		// whole numbers are seconds, for backward compatibility
+		"5":  5 * time.Second,
+		"90": 90 * time.Second,
+	} {
+		sleep, err := sleepTime(value)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, sleep, value)
+	}
+	for _, value := range []string{"abc", "", "-1", "-500ms", "1.5", "5 s"} {
+		_, err := sleepTime(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestValidateParametersSleep(t *testing.T) {
+	params := func(sleep string) test.TestStepParameters {
+		return test.TestStepParameters{
+			"text":  []test.Param{*test.NewParam("hello")},
+			"sleep": []test.Param{*test.NewParam(sleep)},
+		}
+	}
+	for _, sleep := range []string{"500ms", "2m", "0", "10", "{{ .ID }}"} {
+		require.NoError(t, New().ValidateParameters(params(sleep)), sleep)
+	}
+	for _, sleep := range []string{"abc", "-2m", ""} {
+		require.Error(t, New().ValidateParameters(params(sleep)), sleep)
+	}
+}
+
+func TestValidateParametersConcurrency(t *testing.T) {
+	params := func(concurrency string) test.TestStepParameters {
+		return test.TestStepParameters{
+			"text":        []test.Param{*test.NewParam("hello")},
+			"sleep":       []test.Param{*test.NewParam("{{ .ID }}")},
+			"concurrency": []test.Param{*test.NewParam(concurrency)},
+		}
+	}
+	for _, concurrency := range []string{"", "0", "1", "100"} {
+		require.NoError(t, New().ValidateParameters(params(concurrency)), concurrency)
+	}
+	for _, concurrency := range []string{"-1", "abc", "1.5"} {
+		require.Error(t, New().ValidateParameters(params(concurrency)), concurrency)
+	}
+}
+
+// inFlightEmitter tracks the number of targets sleeping at the same time.
+type inFlightEmitter struct {
+	inFlight, maxInFlight int
+}
+
+func (e *inFlightEmitter) Emit(data testevent.Data) error {
+	switch data.EventName {
+	case EventTargetSleeping:
+		e.inFlight++
+		if e.inFlight > e.maxInFlight {
+			e.maxInFlight = e.inFlight
+		}
+	case EventTargetAwake:
+		e.inFlight--
+	}
+	return nil
+}
+
+func (e *inFlightEmitter) StartSpan(string, *target.Target, testevent.SpanID) (testevent.SpanID, error) {
+	return "", nil
+}
+
+func (e *inFlightEmitter) EndSpan(testevent.SpanID) error {
+	return nil
+}
+
+func TestRunConcurrency(t *testing.T) {
+	const numTargets = 20
+	var (
+		in  = make(chan *target.Target, numTargets)
+		out = make(chan *target.Target, numTargets)
+	)
+	for i := 0; i < numTargets; i++ {
+		in <- &target.Target{Name: fmt.Sprintf("host%d", i), ID: fmt.Sprintf("%d", i)}
+	}
+	close(in)
+	params := test.TestStepParameters{
+		"text":        []test.Param{*test.NewParam("hello")},
+		"sleep":       []test.Param{*test.NewParam("5ms")},
+		"concurrency": []test.Param{*test.NewParam("3")},
+	}
+	ev := &inFlightEmitter{}
+	require.NoError(t, New().Run(context.Background(), make(chan struct{}), test.TestStepChannels{In: in, Out: out}, params, ev))
+	close(out)
+	// every target is forwarded exactly once
+	seen := make(map[string]bool)
+	for tgt := range out {
+		require.False(t, seen[tgt.ID], tgt.ID)
+		seen[tgt.ID] = true
+	}
+	require.Len(t, seen, numTargets)
+	require.Equal(t, 0, ev.inFlight)
+	require.True(t, ev.maxInFlight <= 3, ev.maxInFlight)
+}
+
+func TestResume(t *testing.T) {
+	storage.SetStorage(memory.New())
+	header := testevent.Header{JobID: "1", RunID: 2, TestName: "test", TestStepLabel: "sleep"}
+	ev := storage.NewTestEventEmitterFetcher(header)
+	done := &target.Target{Name: "done", ID: "1"}
+	pending := &target.Target{Name: "pending", ID: "2"}
+	// the server died after the sleep of the first target, before forwarding
+	// it. The second target only completed its sleep in a previous run.
+	require.NoError(t, ev.Emit(testevent.Data{EventName: EventTargetAwake, Target: done}))
+	previousRun := header
+	previousRun.RunID = 1
+	require.NoError(t, storage.NewTestEventEmitter(previousRun).Emit(testevent.Data{EventName: EventTargetAwake, Target: pending}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		pause = make(chan struct{})
+		in    = make(chan *target.Target, 2)
+		out   = make(chan *target.Target, 2)
+		errCh = make(chan error, 1)
+	)
+	in <- done
+	in <- pending
+	params := test.TestStepParameters{
+		"text":  []test.Param{*test.NewParam("hello")},
+		"sleep": []test.Param{*test.NewParam("1h")},
+	}
+	step := New()
+	require.True(t, step.CanResume())
+	go func() {
+		errCh <- step.Resume(ctx, pause, test.TestStepChannels{In: in, Out: out}, params, ev)
+	}()
+
+	select {
+	case tgt := <-out:
+		require.Equal(t, done, tgt)
+	case <-time.After(5 * time.Second):
+		t.Fatal("completed target was not forwarded")
+	}
+	select {
+	case tgt := <-out:
+		t.Fatalf("pending target %s was forwarded without sleeping", tgt)
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	require.NoError(t, <-errCh)
+}
+
+func TestRunEmitsSleepEvents(t *testing.T) {
+	storage.SetStorage(memory.New())
+	ev := storage.NewTestEventEmitterFetcher(testevent.Header{JobID: "1", RunID: 1, TestName: "test", TestStepLabel: "sleep"})
+	const numTargets = 20
+	var (
+		in  = make(chan *target.Target, numTargets)
+		out = make(chan *target.Target, numTargets)
+	)
+	for i := 0; i < numTargets; i++ {
+		in <- &target.Target{Name: fmt.Sprintf("host%d", i), ID: fmt.Sprintf("%d", i)}
+	}
+	close(in)
+	params := test.TestStepParameters{
+		"text":  []test.Param{*test.NewParam("hello")},
+		"sleep": []test.Param{*test.NewParam("10ms")},
+	}
+	require.NoError(t, New().Run(context.Background(), make(chan struct{}), test.TestStepChannels{In: in, Out: out}, params, ev))
+	require.Len(t, out, numTargets)
+
+	for _, name := range []event.Name{EventTargetSleeping, EventTargetAwake} {
+		events, err := ev.Fetch(testevent.QueryJobID("1"), testevent.QueryEventName(name))
+		require.NoError(t, err)
+		require.Len(t, events, numTargets, name)
+		for _, e := range events {
+			var payload sleepPayload
+			require.NoError(t, json.Unmarshal(*e.Data.Payload, &payload))
+			require.Equal(t, sleepPayload{Target: e.Data.Target.Name, Sleep: "10ms"}, payload)
+		}
+	}
+}
+
+// sleepRecorder records the sleep of each target, from its sleep events. The
+// span methods come from inFlightEmitter.
+type sleepRecorder struct {
+	inFlightEmitter
+	mu     sync.Mutex
+	sleeps map[string]string
+}
+
+func (e *sleepRecorder) Emit(data testevent.Data) error {
+	if data.EventName != EventTargetSleeping {
+		return nil
+	}
+	var payload sleepPayload
+	if err := json.Unmarshal(*data.Payload, &payload); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sleeps[data.Target.ID] = payload.Sleep
+	return nil
+}
+
+// runTemplate runs the step with a per target sleep on targets with the given
+// IDs, and returns the sleep of each target, the IDs of the targets it
+// forwarded, and those it failed.
+func runTemplate(t *testing.T, ids ...string) (map[string]string, []string, []string) {
+	var (
+		in   = make(chan *target.Target, len(ids))
+		out  = make(chan *target.Target, len(ids))
+		errs = make(chan cerrors.TargetError, len(ids))
+	)
+	for _, id := range ids {
+		in <- &target.Target{Name: "host" + id, ID: id}
+	}
+	close(in)
+	params := test.TestStepParameters{
+		"text":  []test.Param{*test.NewParam("hello")},
+		"sleep": []test.Param{*test.NewParam("{{ .ID }}ms")},
+	}
+	ev := &sleepRecorder{sleeps: make(map[string]string)}
+	require.NoError(t, New().Run(context.Background(), make(chan struct{}), test.TestStepChannels{In: in, Out: out, Err: errs}, params, ev))
+	close(out)
+	close(errs)
+	var forwarded, failed []string
+	for tgt := range out {
+		forwarded = append(forwarded, tgt.ID)
+	}
+	for targetErr := range errs {
+		failed = append(failed, targetErr.Target.ID)
+	}
+	sort.Strings(forwarded)
+	sort.Strings(failed)
+	return ev.sleeps, forwarded, failed
+}
+
+func TestRunTemplateSleep(t *testing.T) {
+	sleeps, forwarded, failed := runTemplate(t, "1", "30")
+	require.Equal(t, map[string]string{"1": "1ms", "30": "30ms"}, sleeps)
+	require.Equal(t, []string{"1", "30"}, forwarded)
+	require.Empty(t, failed)
+}
+
+func TestRunTemplateNegativeSleep(t *testing.T) {
+	sleeps, forwarded, failed := runTemplate(t, "1", "-5", "2")
+	// only the target with a negative sleep fails, without sleeping
+	require.Equal(t, map[string]string{"1": "1ms", "2": "2ms"}, sleeps)
+	require.Equal(t, []string{"1", "2"}, forwarded)
+	require.Equal(t, []string{"-5"}, failed)
+}
+
+func TestRunTemplateInvalidSleep(t *testing.T) {
+	sleeps, forwarded, failed := runTemplate(t, "1", "abc", "2")
+	require.Equal(t, map[string]string{"1": "1ms", "2": "2ms"}, sleeps)
+	require.Equal(t, []string{"1", "2"}, forwarded)
+	require.Equal(t, []string{"abc"}, failed)
+}
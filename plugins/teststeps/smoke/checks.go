@@ -0,0 +1,369 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package smoke
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// supported check kinds.
+const (
+	KindPing    = "ping"
+	KindTLS     = "tls"
+	KindHTTP    = "http"
+	KindProcess = "process"
+)
+
+// optionalPrefix marks a check whose failure does not fail the target.
+const optionalPrefix = "optional"
+
+// maxBodyRead bounds the response body read by the HTTP check, so that the
+// connection can be reused.
+const maxBodyRead = 64 * 1024
+
+var processRe = regexp.MustCompile(`^[A-Za-z0-9_.:+-]{1,15}$`)
+
+// placeholder is the target used to validate the check arguments.
+var placeholder = &target.Target{Name: "validation.example.com", ID: "validation", FQDN: "validation.example.com"}
+
+// env is what the checks need to run: the network timeouts and, for the
+// process checks, the SSH parameters.
+type env struct {
+	timeouts teststeps.NetworkTimeouts
+	ssh      *teststeps.SSHParams
+}
+
+// checker runs a check against a target, and returns a short description of
+// what was observed.
+type checker interface {
+	run(ctx context.Context, e *env, target *target.Target) (string, error)
+}
+
+// check is an entry of the check list.
+type check struct {
+	// Spec is the check as written in the parameters, used in the reports.
+	Spec     string
+	Kind     string
+	Optional bool
+	// Timeout bounds the check, if shorter than the remaining time.
+	Timeout time.Duration
+	checker checker
+}
+
+// options holds the key=value options of a check, and reports the unknown
+// ones.
+type options map[string]string
+
+func (o options) take(key string) (string, bool) {
+	v, ok := o[key]
+	delete(o, key)
+	return v, ok
+}
+
+func (o options) duration(key string, defaultValue time.Duration) (time.Duration, error) {
+	v, ok := o.take(key)
+	if !ok {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s option %q: must be a positive duration", key, v)
+	}
+	return d, nil
+}
+
+func (o options) integer(key string, defaultValue, min, max int) (int, error) {
+	v, ok := o.take(key)
+	if !ok {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("invalid %s option %q: must be an integer between %d and %d", key, v, min, max)
+	}
+	return n, nil
+}
+
+func (o options) boolean(key string) (bool, error) {
+	v, ok := o.take(key)
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s option %q: must be a boolean", key, v)
+	}
+	return b, nil
+}
+
+// parseCheck parses a check written as "[optional] <kind> <argument>
+// [key=value ...]". The argument is expanded against each target.
+func parseCheck(spec string, defaultTimeout time.Duration) (*check, error) {
+	words, err := shellquote.Split(spec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse check %q: %v", spec, err)
+	}
+	c := check{Spec: spec}
+	if len(words) > 0 && words[0] == optionalPrefix {
+		c.Optional, words = true, words[1:]
+	}
+	if len(words) < 2 {
+		return nil, fmt.Errorf("invalid check %q: must be [%s] <kind> <argument> [key=value ...]", spec, optionalPrefix)
+	}
+	c.Kind = words[0]
+	arg := test.NewParam(words[1])
+	if _, err := arg.Expand(placeholder); err != nil {
+		return nil, fmt.Errorf("invalid check %q: cannot expand argument: %v", spec, err)
+	}
+	opts := make(options)
+	for _, w := range words[2:] {
+		kv := strings.SplitN(w, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid check %q: option %q must be key=value", spec, w)
+		}
+		if _, ok := opts[kv[0]]; ok {
+			return nil, fmt.Errorf("invalid check %q: duplicate option %s", spec, kv[0])
+		}
+		opts[kv[0]] = kv[1]
+	}
+	if c.Timeout, err = opts.duration("timeout", defaultTimeout); err != nil {
+		return nil, fmt.Errorf("invalid check %q: %v", spec, err)
+	}
+	switch c.Kind {
+	case KindPing:
+		c.checker, err = newPingCheck(arg, opts)
+	case KindTLS:
+		c.checker, err = newTLSCheck(arg, opts)
+	case KindHTTP:
+		c.checker, err = newHTTPCheck(arg, opts)
+	case KindProcess:
+		c.checker, err = newProcessCheck(arg, opts)
+	default:
+		err = fmt.Errorf("unknown kind %q, must be one of %s, %s, %s, %s", c.Kind, KindPing, KindTLS, KindHTTP, KindProcess)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid check %q: %v", spec, err)
+	}
+	for key := range opts {
+		return nil, fmt.Errorf("invalid check %q: unknown option %s for %s", spec, key, c.Kind)
+	}
+	return &c, nil
+}
+
+// pingCheck sends ICMP echo requests to a host with the ping command of the
+// ConTest server.
+type pingCheck struct {
+	host  *test.Param
+	count int
+}
+
+func newPingCheck(host *test.Param, opts options) (checker, error) {
+	count, err := opts.integer("count", 1, 1, 10)
+	if err != nil {
+		return nil, err
+	}
+	return &pingCheck{host: host, count: count}, nil
+}
+
+func (c *pingCheck) run(ctx context.Context, e *env, target *target.Target) (string, error) {
+	host, err := c.host.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand host: %v", err)
+	}
+	if strings.HasPrefix(host, "-") {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	out, err := exec.CommandContext(ctx, "ping", "-n", "-c", strconv.Itoa(c.count), host).CombinedOutput()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", fmt.Errorf("ping %s failed: %v: %s", host, err, lastLine(out))
+	}
+	return lastLine(out), nil
+}
+
+// lastLine returns the last non empty line of a command output, which is the
+// summary of most tools.
+func lastLine(out []byte) string {
+	lines := bytes.Split(bytes.TrimSpace(out), []byte("\n"))
+	return string(bytes.TrimSpace(lines[len(lines)-1]))
+}
+
+// tlsCheck performs a TLS handshake and verifies the server certificate,
+// optionally requiring a minimal remaining validity.
+type tlsCheck struct {
+	addr        *test.Param
+	serverName  string
+	minValidity time.Duration
+	insecure    bool
+}
+
+func newTLSCheck(addr *test.Param, opts options) (checker, error) {
+	c := tlsCheck{addr: addr}
+	c.serverName, _ = opts.take("server_name")
+	var err error
+	if c.minValidity, err = opts.duration("min_validity", 0); err != nil {
+		return nil, err
+	}
+	if c.insecure, err = opts.boolean("insecure"); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *tlsCheck) run(ctx context.Context, e *env, target *target.Target) (string, error) {
+	addr, err := c.addr.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand address: %v", err)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, addr = addr, net.JoinHostPort(addr, "443")
+	}
+	serverName := c.serverName
+	if serverName == "" {
+		serverName = host
+	}
+	tlsConn, err := e.timeouts.DialTLS(ctx, addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: c.insecure})
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("TLS handshake with %s failed: %v", addr, err)
+	}
+	defer tlsConn.Close()
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", fmt.Errorf("%s presented no certificate", addr)
+	}
+	remaining := time.Until(certs[0].NotAfter)
+	if remaining < c.minValidity {
+		return "", fmt.Errorf("certificate of %s expires in %v, less than %v", addr, remaining.Round(time.Hour), c.minValidity)
+	}
+	subject := certs[0].Subject.CommonName
+	if subject == "" && len(certs[0].DNSNames) > 0 {
+		subject = certs[0].DNSNames[0]
+	}
+	return fmt.Sprintf("certificate for %q valid until %s", subject, certs[0].NotAfter.UTC().Format(time.RFC3339)), nil
+}
+
+// httpCheck sends a GET request and expects a status code.
+type httpCheck struct {
+	url      *test.Param
+	status   int
+	insecure bool
+}
+
+func newHTTPCheck(u *test.Param, opts options) (checker, error) {
+	if err := teststeps.ValidateURLTemplate(u, "url"); err != nil {
+		return nil, err
+	}
+	c := httpCheck{url: u}
+	var err error
+	if c.status, err = opts.integer("status", http.StatusOK, 100, 599); err != nil {
+		return nil, err
+	}
+	if c.insecure, err = opts.boolean("insecure"); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *httpCheck) run(ctx context.Context, e *env, target *target.Target) (string, error) {
+	u, err := c.url.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand URL: %v", err)
+	}
+	if err := teststeps.ValidateURL(u, "url"); err != nil {
+		return "", err
+	}
+	client := teststeps.NewHTTPClient(teststeps.HTTPClientOptions{Timeouts: e.timeouts, InsecureSkipVerify: c.insecure, DisableKeepAlives: true})
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot build request: %v", err)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", err
+	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBodyRead))
+	_ = resp.Body.Close()
+	if resp.StatusCode != c.status {
+		return "", fmt.Errorf("GET %s returned %s, expected %d", u, resp.Status, c.status)
+	}
+	return fmt.Sprintf("GET %s returned %s", u, resp.Status), nil
+}
+
+// processCheck verifies over SSH that processes with the given name are
+// running.
+type processCheck struct {
+	name     *test.Param
+	minCount int
+}
+
+func newProcessCheck(name *test.Param, opts options) (checker, error) {
+	minCount, err := opts.integer("min_count", 1, 1, 1<<16)
+	if err != nil {
+		return nil, err
+	}
+	return &processCheck{name: name, minCount: minCount}, nil
+}
+
+func (c *processCheck) run(ctx context.Context, e *env, target *target.Target) (string, error) {
+	name, err := c.name.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand process name: %v", err)
+	}
+	// pgrep matches the first 15 characters of the process name
+	if !processRe.MatchString(name) {
+		return "", fmt.Errorf("invalid process name %q", name)
+	}
+	result, err := e.ssh.RunSSHCommand(ctx.Done(), nil, target, shellquote.Join("pgrep", "-c", "-x", "--", name))
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", err
+	}
+	// pgrep exits with 1 when no process matched
+	if result.ExitStatus != 0 && result.ExitStatus != 1 {
+		return "", fmt.Errorf("pgrep exited with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	count, err := strconv.Atoi(string(bytes.TrimSpace(result.Stdout)))
+	if err != nil {
+		return "", fmt.Errorf("cannot parse pgrep output %q", bytes.TrimSpace(result.Stdout))
+	}
+	if count < c.minCount {
+		return "", fmt.Errorf("%d %s processes running, expected at least %d", count, name, c.minCount)
+	}
+	return fmt.Sprintf("%d %s processes running", count, name), nil
+}
+
+// errNotRun is reported for the checks which were not run because the total
+// time of the suite ran out.
+var errNotRun = errors.New("not run: the suite timed out")
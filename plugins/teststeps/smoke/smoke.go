@@ -0,0 +1,234 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package smoke
+
+// The Smoke plugin runs an ordered list of lightweight, read-only checks
+// against each target, and fails the targets which miss any mandatory check.
+// Each check is written as "[optional] <kind> <argument> [key=value ...]",
+// where the argument is expanded against the target:
+//
+// * ping <host> [count=N]: the host answers ICMP echo requests sent by the
+//   ConTest server.
+// * tls <host[:port]> [server_name=...] [min_validity=720h] [insecure=true]:
+//   a TLS handshake succeeds, and the certificate is valid for long enough.
+// * http <url> [status=200] [insecure=true]: a GET request returns the
+//   expected status.
+// * process <name> [min_count=N]: processes with this name run on the
+//   target, which is inspected over SSH.
+//
+// Every check also accepts a timeout option, and the whole suite is bounded
+// by the suite_timeout parameter. Failures of optional checks are reported,
+// but tolerated.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "Smoke"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventSmokePassed = event.Name("TargetSmokePassed")
+	EventSmokeFailed = event.Name("TargetSmokeFailed")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventSmokePassed, EventSmokeFailed}
+
+const (
+	defaultCheckTimeout = 10 * time.Second
+	defaultSuiteTimeout = time.Minute
+)
+
+// checkResult is the outcome of a check.
+type checkResult struct {
+	Check    string
+	Optional bool `json:",omitempty"`
+	Passed   bool
+	Detail   string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+	Duration string
+}
+
+// smokeEventPayload is the payload of the events emitted by this step.
+type smokeEventPayload struct {
+	Passed         int
+	Failed         int
+	OptionalFailed int `json:",omitempty"`
+	Results        []checkResult
+}
+
+// Smoke runs a suite of read-only checks against the targets.
+type Smoke struct {
+	env
+	Checks       []*check
+	SuiteTimeout time.Duration
+}
+
+// Name returns the plugin name.
+func (ts Smoke) Name() string {
+	return Name
+}
+
+// runCheck runs a single check within its own timeout and the remaining time
+// of the suite.
+func (ts *Smoke) runCheck(suiteCtx context.Context, c *check, target *target.Target) (string, error) {
+	ctx, cancel := context.WithTimeout(suiteCtx, c.Timeout)
+	defer cancel()
+	detail, err := c.checker.run(ctx, &ts.env, target)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		if suiteCtx.Err() != nil {
+			return "", fmt.Errorf("the suite timed out after %v", ts.SuiteTimeout)
+		}
+		return "", fmt.Errorf("timed out after %v", c.Timeout)
+	}
+	return detail, err
+}
+
+func (ts *Smoke) smoke(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	ctx, ctxCancel := teststeps.NewContext(cancel, pause)
+	defer ctxCancel()
+	ctx, suiteCancel := context.WithTimeout(ctx, ts.SuiteTimeout)
+	defer suiteCancel()
+
+	var (
+		payload smokeEventPayload
+		failed  []string
+	)
+	for _, c := range ts.Checks {
+		result := checkResult{Check: c.Spec, Optional: c.Optional}
+		start := time.Now()
+		var err error
+		if ctx.Err() != nil {
+			err = errNotRun
+		} else {
+			result.Detail, err = ts.runCheck(ctx, c, target)
+		}
+		result.Duration = time.Since(start).Round(time.Millisecond).String()
+		// stop on cancellation or pause, without reporting the target
+		select {
+		case <-cancel:
+			return teststeps.ErrInterrupted
+		case <-pause:
+			return teststeps.ErrInterrupted
+		default:
+		}
+		switch {
+		case err == nil:
+			result.Passed = true
+			payload.Passed++
+		case c.Optional:
+			result.Error = err.Error()
+			payload.OptionalFailed++
+		default:
+			result.Error = err.Error()
+			payload.Failed++
+			failed = append(failed, fmt.Sprintf("%s: %v", c.Spec, err))
+		}
+		payload.Results = append(payload.Results, result)
+	}
+	if len(failed) == 0 {
+		return teststeps.EmitTargetEvent(ev, EventSmokePassed, target, payload)
+	}
+	if err := teststeps.EmitTargetEvent(ev, EventSmokeFailed, target, payload); err != nil {
+		return err
+	}
+	return fmt.Errorf("%d mandatory smoke checks failed: %s", len(failed), strings.Join(failed, "; "))
+}
+
+// Run executes the smoke test step.
+func (ts *Smoke) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.smoke(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the suite normally, as all the checks are read-only.
+func (ts *Smoke) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *Smoke) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	if ts.timeouts, err = teststeps.NewNetworkTimeouts(params); err != nil {
+		return err
+	}
+	if ts.SuiteTimeout, err = teststeps.DurationParam(params, "suite_timeout", defaultSuiteTimeout); err != nil {
+		return err
+	}
+	checkTimeout, err := teststeps.DurationParam(params, "check_timeout", defaultCheckTimeout)
+	if err != nil {
+		return err
+	}
+	ts.Checks = nil
+	mandatory, needSSH := false, false
+	for _, p := range params.Get("checks") {
+		c, err := parseCheck(p.Raw(), checkTimeout)
+		if err != nil {
+			return err
+		}
+		ts.Checks = append(ts.Checks, c)
+		mandatory = mandatory || !c.Optional
+		needSSH = needSSH || c.Kind == KindProcess
+	}
+	if len(ts.Checks) == 0 {
+		return errors.New("missing 'checks' parameter: at least one check is required")
+	}
+	if !mandatory {
+		return errors.New("invalid 'checks' parameter: at least one check must be mandatory")
+	}
+	ts.ssh = nil
+	if needSSH {
+		if ts.ssh, err = teststeps.NewSSHParams(params); err != nil {
+			return fmt.Errorf("invalid SSH parameters for the %s checks: %v", KindProcess, err)
+		}
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *Smoke) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. Smoke cannot
+// resume.
+func (ts *Smoke) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *Smoke) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new Smoke test step.
+func New() test.TestStep {
+	return &Smoke{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
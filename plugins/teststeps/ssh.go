@@ -0,0 +1,306 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package teststeps
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultSSHPort is the port used when a step does not specify one.
+const DefaultSSHPort = 22
+
+// ErrInterrupted is returned by the helpers in this package when an operation
+// was aborted because of a cancellation or pause signal.
+var ErrInterrupted = errors.New("interrupted by cancellation or pause signal")
+
+// SSHParams holds the connection parameters shared by all the test steps that
+// operate on targets over SSH. The parameters are expanded against each target
+// at connection time. Only PublicKey and Password authentication are supported.
+type SSHParams struct {
+	Host           *test.Param
+	Port           *test.Param
+	User           *test.Param
+	PrivateKeyFile *test.Param
+	Password       *test.Param
+	// Timeouts is applied to the SSH connections. The total timeout bounds
+	// each remote command run with RunSSHCommand.
+	Timeouts NetworkTimeouts
+}
+
+// NewSSHParams validates and returns the SSH connection parameters found in
+// the test step parameters: host, port, user, private_key_file and password,
+// and the network timeouts.
+func NewSSHParams(params test.TestStepParameters) (*SSHParams, error) {
+	p := SSHParams{}
+	timeouts, err := NewNetworkTimeouts(params)
+	if err != nil {
+		return nil, err
+	}
+	p.Timeouts = timeouts
+	p.Host = params.GetOne("host")
+	if p.Host.IsEmpty() {
+		return nil, errors.New("invalid or missing 'host' parameter, must be exactly one string")
+	}
+	p.Port = params.GetOne("port")
+	if p.Port.IsEmpty() {
+		p.Port = test.NewParam(strconv.Itoa(DefaultSSHPort))
+	} else {
+		port, err := params.GetInt("port")
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'port' parameter, not an integer: %v", err)
+		}
+		if port < 0 || port > 0xffff {
+			return nil, fmt.Errorf("invalid 'port' parameter: not in range 0-65535")
+		}
+	}
+	p.User = params.GetOne("user")
+	if p.User.IsEmpty() {
+		return nil, errors.New("invalid or missing 'user' parameter, must be exactly one string")
+	}
+	// do not fail if key file or password are empty, in such case they won't
+	// be used
+	p.PrivateKeyFile = params.GetOne("private_key_file")
+	p.Password = params.GetOne("password")
+	return &p, nil
+}
+
+// Addr returns the host:port address of the SSH server for the given target.
+func (p *SSHParams) Addr(target *target.Target) (string, error) {
+	host, err := p.Host.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand host parameter: %v", err)
+	}
+	portStr, err := p.Port.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand port parameter: %v", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert port parameter to integer: %v", err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// ClientConfig builds the SSH client configuration for the given target.
+func (p *SSHParams) ClientConfig(target *target.Target) (*ssh.ClientConfig, error) {
+	user, err := p.User.Expand(target)
+	if err != nil {
+		return nil, fmt.Errorf("cannot expand user parameter: %v", err)
+	}
+	privKeyFile, err := p.PrivateKeyFile.Expand(target)
+	if err != nil {
+		return nil, fmt.Errorf("cannot expand private key file parameter: %v", err)
+	}
+	auth := []ssh.AuthMethod{}
+	if privKeyFile != "" {
+		key, err := ioutil.ReadFile(privKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read private key at %s: %v", privKeyFile, err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	password, err := p.Password.Expand(target)
+	if err != nil {
+		return nil, fmt.Errorf("cannot expand password parameter: %v", err)
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	return &ssh.ClientConfig{
+		User: user,
+		Auth: auth,
+		// TODO expose this in the plugin arguments
+		//HostKeyCallback: ssh.FixedHostKey(hostKey),
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         p.Timeouts.Connect,
+	}, nil
+}
+
+// Dial connects to the SSH server of the given target. The caller is
+// responsible for closing the returned client.
+func (p *SSHParams) Dial(target *target.Target) (*ssh.Client, error) {
+	addr, err := p.Addr(target)
+	if err != nil {
+		return nil, err
+	}
+	config, err := p.ClientConfig(target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := p.Timeouts.DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to SSH server %s: %v", addr, err)
+	}
+	// the connect timeout also bounds the SSH handshake
+	var timer *time.Timer
+	if p.Timeouts.Connect > 0 {
+		timer = time.AfterFunc(p.Timeouts.Connect, func() { _ = conn.Close() })
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if timer != nil && !timer.Stop() {
+		if err == nil {
+			_ = c.Close()
+		}
+		return nil, fmt.Errorf("cannot connect to SSH server %s: handshake did not complete within %s", addr, p.Timeouts.Connect)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("cannot connect to SSH server %s: %v", addr, err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// SSHResult is the outcome of a command executed over SSH.
+type SSHResult struct {
+	Stdout     []byte
+	Stderr     []byte
+	ExitStatus int
+}
+
+// RunSSHCommand runs a command on the given target over SSH. A non-zero exit
+// status of the remote command is not considered an error, and is reported
+// in the returned SSHResult instead. If a cancellation or pause signal is
+// received, the remote command is killed and ErrInterrupted is returned.
+func (p *SSHParams) RunSSHCommand(cancel, pause <-chan struct{}, target *target.Target, cmd string) (*SSHResult, error) {
+	return p.RunSSHCommandWithOutput(cancel, pause, target, cmd, nil)
+}
+
+// RunSSHCommandWithOutput is like RunSSHCommand, and additionally copies the
+// stdout and stderr of the remote command to output as they are produced.
+// Writes to output may happen concurrently.
+func (p *SSHParams) RunSSHCommandWithOutput(cancel, pause <-chan struct{}, target *target.Target, cmd string, output io.Writer) (*SSHResult, error) {
+	client, err := p.Dial(target)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			log.Warningf("Failed to close SSH connection to %s: %v", client.RemoteAddr(), err)
+		}
+	}()
+	return runSSHCommand(cancel, pause, client, cmd, p.Timeouts.Total, output)
+}
+
+// RunSSHCommandWithClient runs a command over an already established SSH
+// connection. It follows the same semantics as RunSSHCommand, without a total
+// timeout.
+func RunSSHCommandWithClient(cancel, pause <-chan struct{}, client *ssh.Client, cmd string) (*SSHResult, error) {
+	return runSSHCommand(cancel, pause, client, cmd, 0, nil)
+}
+
+func runSSHCommand(cancel, pause <-chan struct{}, client *ssh.Client, cmd string, timeout time.Duration, output io.Writer) (*SSHResult, error) {
+	addr := client.RemoteAddr()
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create SSH session to server %s: %v", addr, err)
+	}
+	defer func() {
+		if err := session.Close(); err != nil && err != io.EOF {
+			log.Warningf("Failed to close SSH session to %s: %v", addr, err)
+		}
+	}()
+	var stdout, stderr bytes.Buffer
+	session.Stdout, session.Stderr = &stdout, &stderr
+	if output != nil {
+		session.Stdout, session.Stderr = io.MultiWriter(&stdout, output), io.MultiWriter(&stderr, output)
+	}
+	log.Debugf("Running remote SSH command on %s: '%v'", addr, cmd)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- session.Run(cmd)
+	}()
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case err := <-errCh:
+		result := SSHResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+		if err != nil {
+			var exitErr *ssh.ExitError
+			if !errors.As(err, &exitErr) {
+				return nil, fmt.Errorf("failed to run command '%s' on %s: %v", cmd, addr, err)
+			}
+			result.ExitStatus = exitErr.ExitStatus()
+		}
+		return &result, nil
+	case <-cancel:
+		if err := session.Signal(ssh.SIGKILL); err != nil {
+			log.Warningf("Failed to kill command '%s' on %s: %v", cmd, addr, err)
+		}
+		return nil, ErrInterrupted
+	case <-pause:
+		if err := session.Signal(ssh.SIGKILL); err != nil {
+			log.Warningf("Failed to kill command '%s' on %s: %v", cmd, addr, err)
+		}
+		return nil, ErrInterrupted
+	case <-timeoutCh:
+		if err := session.Signal(ssh.SIGKILL); err != nil {
+			log.Warningf("Failed to kill command '%s' on %s: %v", cmd, addr, err)
+		}
+		return nil, fmt.Errorf("command '%s' on %s did not complete within %s", cmd, addr, timeout)
+	}
+}
+
+// BootIDCommand prints an identifier which changes at every boot of a Linux
+// system.
+const BootIDCommand = "cat /proc/sys/kernel/random/boot_id"
+
+// BootID returns the boot identifier of the given target.
+func (p *SSHParams) BootID(cancel, pause <-chan struct{}, target *target.Target) (string, error) {
+	result, err := p.RunSSHCommand(cancel, pause, target, BootIDCommand)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitStatus != 0 {
+		return "", fmt.Errorf("cannot read boot ID, command exited with status %d: %s", result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	return string(bytes.TrimSpace(result.Stdout)), nil
+}
+
+// WaitForReboot polls the given target until it is reachable over SSH again
+// with a boot identifier different from prevBootID, which means that the
+// target went through a reboot cycle. It gives up after timeout.
+func (p *SSHParams) WaitForReboot(cancel, pause <-chan struct{}, target *target.Target, prevBootID string, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		bootID, err := p.BootID(cancel, pause, target)
+		if errors.Is(err, ErrInterrupted) {
+			return err
+		}
+		if err == nil && bootID != prevBootID {
+			return nil
+		}
+		if err != nil {
+			log.Debugf("Target %s not reachable yet: %v", target, err)
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("target %s did not come back from reboot within %s", target, timeout)
+		}
+		if err := Sleep(cancel, pause, interval); err != nil {
+			return err
+		}
+	}
+}
@@ -14,14 +14,17 @@ package sshcmd
 //
 // Warning: commands are interpreted, so be careful with external input in the
 // test step arguments.
+//
+// Credentials can be taken from the job secrets, e.g. with
+// "password": ["secret://ssh_password"]. The command passes if it exits with
+// the expected status (0 by default) and, if given, its stdout matches the
+// expected regex. Each target gets a TargetCommandSucceeded or
+// TargetCommandFailed event with the captured output.
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net"
 	"regexp"
 	"strconv"
 	"strings"
@@ -34,7 +37,6 @@ import (
 	"github.com/facebookincubator/contest/pkg/test"
 	"github.com/facebookincubator/contest/plugins/teststeps"
 	shellquote "github.com/kballard/go-shellquote"
-	"golang.org/x/crypto/ssh"
 )
 
 // Name is the name used to look this plugin up.
@@ -42,23 +44,54 @@ var Name = "SSHCmd"
 
 var log = logging.GetLogger("teststeps/" + strings.ToLower(Name))
 
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventCommandSucceeded = event.Name("TargetCommandSucceeded")
+	EventCommandFailed    = event.Name("TargetCommandFailed")
+)
+
 // Events is used by the framework to determine which events this plugin will
 // emit. Any emitted event that is not registered here will cause the plugin to
 // fail.
-var Events = []event.Name{}
+var Events = []event.Name{EventCommandSucceeded, EventCommandFailed}
+
+// maxCapturedOutput bounds the stdout and stderr reported in the event
+// payloads. The whole output is streamed as live output events.
+const maxCapturedOutput = 4096
+
+// commandEventPayload is the payload of the events emitted by this step.
+type commandEventPayload struct {
+	Command    string
+	ExitStatus int
+	Stdout     string `json:",omitempty"`
+	Stderr     string `json:",omitempty"`
+	// Truncated tells whether stdout or stderr were cut to maxCapturedOutput.
+	Truncated bool   `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
 
-const defaultSSHPort = 22
+// captured returns an output as reported in the event payloads, and whether it
+// was truncated.
+func captured(output []byte) (string, bool) {
+	if len(output) > maxCapturedOutput {
+		return string(output[:maxCapturedOutput]), true
+	}
+	return string(output), false
+}
 
 // SSHCmd is used to run arbitrary commands as test steps.
 type SSHCmd struct {
-	Host           *test.Param
-	Port           *test.Param
-	User           *test.Param
-	PrivateKeyFile *test.Param
-	Password       *test.Param
-	Executable     *test.Param
-	Args           []test.Param
-	Expect         *test.Param
+	*teststeps.SSHParams
+	Executable *test.Param
+	Args       []test.Param
+	Expect     *test.Param
+	// ExitCode is the exit status the command is expected to return.
+	ExitCode int
+	// Idempotent tells whether the command is safe to run twice on a target,
+	// which resuming the step requires.
+	Idempotent bool
+
+	expectRe *regexp.Regexp
 }
 
 // Name returns the plugin name.
@@ -67,7 +100,8 @@ func (ts SSHCmd) Name() string {
 }
 
 // Run executes the cmd step.
-func (ts *SSHCmd) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+func (ts *SSHCmd) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
 	// XXX: Dragons ahead! The target (%t) substitution, and function
 	// expression evaluations are done at run-time, so they may still fail
 	// despite passing at early validation time.
@@ -81,178 +115,124 @@ func (ts *SSHCmd) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, p
 	}
 
 	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
-		// apply filters and substitutions to user, host, private key, and command args
-		user, err := ts.User.Expand(target)
-		if err != nil {
-			return fmt.Errorf("cannot expand user parameter: %v", err)
-		}
-
-		host, err := ts.Host.Expand(target)
+		cmd, err := ts.command(target)
 		if err != nil {
-			return fmt.Errorf("cannot expand host parameter: %v", err)
+			return err
 		}
 
-		portStr, err := ts.Port.Expand(target)
-		if err != nil {
-			return fmt.Errorf("cannot expand port parameter: %v", err)
+		// run the remote command and catch stdout/stderr
+		log.Printf("Running remote SSH command on %s: '%v'", target, cmd)
+		output := teststeps.OpenLiveOutput(ev, target)
+		defer output.Close()
+		payload := commandEventPayload{Command: cmd}
+		result, err := ts.RunSSHCommandWithOutput(cancel, pause, target, cmd, output)
+		if err == nil {
+			err = ts.check(cmd, target, result, &payload)
 		}
-		port, err := strconv.Atoi(portStr)
-		if err != nil {
-			return fmt.Errorf("failed to convert port parameter to integer: %v", err)
+		if err == teststeps.ErrInterrupted {
+			return err
 		}
-
-		// apply functions to the private key, if any
-		var signer ssh.Signer
-		privKeyFile, err := ts.PrivateKeyFile.Expand(target)
 		if err != nil {
-			return fmt.Errorf("cannot expand private key file parameter: %v", err)
-		}
-
-		if privKeyFile != "" {
-			key, err := ioutil.ReadFile(privKeyFile)
-			if err != nil {
-				return fmt.Errorf("cannot read private key at %s: %v", ts.PrivateKeyFile, err)
-			}
-			signer, err = ssh.ParsePrivateKey(key)
-			if err != nil {
-				return fmt.Errorf("cannot parse private key: %v", err)
+			payload.Error = err.Error()
+			if emitErr := teststeps.EmitTargetEvent(ev, EventCommandFailed, target, payload); emitErr != nil {
+				log.Warningf("Could not emit event %s for target %s: %v", EventCommandFailed, target, emitErr)
 			}
+			return err
 		}
+		return teststeps.EmitTargetEvent(ev, EventCommandSucceeded, target, payload)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
 
-		password, err := ts.Password.Expand(target)
-		if err != nil {
-			return fmt.Errorf("cannot expand password parameter: %v", err)
-		}
-
-		auth := []ssh.AuthMethod{}
-		if signer != nil {
-			auth = append(auth, ssh.PublicKeys(signer))
-		}
-		if password != "" {
-			auth = append(auth, ssh.Password(password))
-		}
-
-		config := ssh.ClientConfig{
-			User: user,
-			Auth: auth,
-			// TODO expose this in the plugin arguments
-			//HostKeyCallback: ssh.FixedHostKey(hostKey),
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		}
-
-		executable, err := ts.Executable.Expand(target)
-		if err != nil {
-			return fmt.Errorf("cannot expand executable parameter: %v", err)
-		}
+// check verifies the result of the command run on a target against the
+// expectations, and fills the payload of the target event.
+func (ts *SSHCmd) check(cmd string, target *target.Target, result *teststeps.SSHResult, payload *commandEventPayload) error {
+	var stdoutTruncated, stderrTruncated bool
+	payload.ExitStatus = result.ExitStatus
+	payload.Stdout, stdoutTruncated = captured(result.Stdout)
+	payload.Stderr, stderrTruncated = captured(result.Stderr)
+	payload.Truncated = stdoutTruncated || stderrTruncated
+	log.Infof("Stdout of command '%s' is '%s'", cmd, result.Stdout)
+	if result.ExitStatus != ts.ExitCode {
+		log.Warningf("Stderr of command '%s' is '%s'", cmd, result.Stderr)
+		return fmt.Errorf("command '%s' exited with status %d, expected %d", cmd, result.ExitStatus, ts.ExitCode)
+	}
+	if ts.expectRe == nil {
+		log.Warningf("no expectations specified")
+		return nil
+	}
+	if !ts.expectRe.Match(result.Stdout) {
+		return fmt.Errorf("match for %s not found for target %v", ts.expectRe, target)
+	}
+	log.Infof("match for regex \"%s\" found", ts.expectRe)
+	return nil
+}
 
-		// apply functions to the command args, if any
-		var args []string
-		for _, arg := range ts.Args {
-			earg, err := arg.Expand(target)
-			if err != nil {
-				return fmt.Errorf("cannot expand command argument '%s': %v", arg, err)
-			}
-			args = append(args, earg)
-		}
+// command returns the remote command to run on a target, with the executable
+// and the arguments expanded.
+func (ts *SSHCmd) command(target *target.Target) (string, error) {
+	executable, err := ts.Executable.Expand(target)
+	if err != nil {
+		return "", fmt.Errorf("cannot expand executable parameter: %v", err)
+	}
 
-		// connect to the host
-		addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
-		client, err := ssh.Dial("tcp", addr, &config)
+	// apply functions to the command args, if any
+	var args []string
+	for _, arg := range ts.Args {
+		earg, err := arg.Expand(target)
 		if err != nil {
-			return fmt.Errorf("cannot connect to SSH server %s: %v", addr, err)
-		}
-		defer func() {
-			if err := client.Close(); err != nil {
-				log.Warningf("Failed to close SSH connection to %s: %v", addr, err)
-			}
-		}()
-		session, err := client.NewSession()
-		if err != nil {
-			return fmt.Errorf("cannot create SSH session to server %s: %v", addr, err)
-		}
-		defer func() {
-			if err := session.Close(); err != nil && err != io.EOF {
-				log.Warningf("Failed to close SSH session to %s: %v", addr, err)
-			}
-		}()
-		// run the remote command and catch stdout/stderr
-		var stdout, stderr bytes.Buffer
-		session.Stdout, session.Stderr = &stdout, &stderr
-		cmd := shellquote.Join(append([]string{executable}, args...)...)
-		log.Printf("Running remote SSH command on %s: '%v'", addr, cmd)
-		errCh := make(chan error)
-		go func() {
-			innerErr := session.Run(cmd)
-			errCh <- innerErr
-		}()
-
-		select {
-		case err := <-errCh:
-			log.Infof("Stdout of command '%s' is '%s'", cmd, stdout.Bytes())
-			if err == nil {
-				// Execute expectations
-				expect := ts.Expect.Raw()
-				if expect == "" {
-					log.Warningf("no expectations specified")
-				} else {
-					re := regexp.MustCompile(expect)
-					matches := re.FindAll(stdout.Bytes(), -1)
-					if len(matches) > 0 {
-						log.Infof("match for regex \"%s\" found", expect)
-					} else {
-						return fmt.Errorf("match for %s not found for target %v", expect, target)
-					}
-				}
-			} else {
-				log.Warningf("Stderr of command '%s' is '%s'", cmd, stderr.Bytes())
-			}
-			return err
-		case <-cancel:
-			return session.Signal(ssh.SIGKILL)
-		case <-pause:
-			return session.Signal(ssh.SIGKILL)
+			return "", fmt.Errorf("cannot expand command argument '%s': %v", arg, err)
 		}
+		args = append(args, earg)
 	}
-	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+	return shellquote.Join(append([]string{executable}, args...)...), nil
 }
 
-func (ts *SSHCmd) validateAndPopulate(params test.TestStepParameters) error {
-	var err error
-	ts.Host = params.GetOne("host")
-	if ts.Host.IsEmpty() {
-		return errors.New("invalid or missing 'host' parameter, must be exactly one string")
+// DryRun logs the remote command that would be run on each target, without
+// connecting to it.
+func (ts *SSHCmd) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
 	}
-	if params.GetOne("port").IsEmpty() {
-		ts.Port = test.NewParam(strconv.Itoa(defaultSSHPort))
-	} else {
-		var port int64
-		port, err = params.GetInt("port")
+	describe := func(target *target.Target) (string, error) {
+		cmd, err := ts.command(target)
 		if err != nil {
-			return fmt.Errorf("invalid 'port' parameter, not an integer: %v", err)
-		}
-		if port < 0 || port > 0xffff {
-			return fmt.Errorf("invalid 'port' parameter: not in range 0-65535")
+			return "", err
 		}
+		return fmt.Sprintf("run remote SSH command '%s'", cmd), nil
 	}
+	return teststeps.DryRunEachTarget(Name, cancel, pause, ch, describe)
+}
 
-	ts.User = params.GetOne("user")
-	if ts.User.IsEmpty() {
-		return errors.New("invalid or missing 'user' parameter, must be exactly one string")
+func (ts *SSHCmd) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.SSHParams, err = teststeps.NewSSHParams(params)
+	if err != nil {
+		return err
 	}
 
-	ts.PrivateKeyFile = params.GetOne("private_key_file")
-	// do not fail if key file is empty, in such case it won't be used
-	ts.PrivateKeyFile = params.GetOne("private_key_file")
-
-	// do not fail if password is empty, in such case it won't be used
-	ts.Password = params.GetOne("password")
-
 	ts.Executable = params.GetOne("executable")
 	if ts.Executable.IsEmpty() {
 		return errors.New("invalid or missing 'executable' parameter, must be exactly one string")
 	}
 	ts.Args = params.Get("args")
 	ts.Expect = params.GetOne("expect")
+	ts.expectRe = nil
+	if expect := ts.Expect.Raw(); expect != "" {
+		if ts.expectRe, err = regexp.Compile(expect); err != nil {
+			return fmt.Errorf("invalid 'expect' parameter %q: %v", expect, err)
+		}
+	}
+	ts.ExitCode = 0
+	if p := params.GetOne("exit_code"); !p.IsEmpty() {
+		if ts.ExitCode, err = strconv.Atoi(p.Raw()); err != nil || ts.ExitCode < 0 || ts.ExitCode > 255 {
+			return fmt.Errorf("invalid 'exit_code' parameter %q, must be an integer between 0 and 255", p.Raw())
+		}
+	}
+	if ts.Idempotent, err = teststeps.BoolParam(params, "idempotent", false); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -262,15 +242,23 @@ func (ts *SSHCmd) ValidateParameters(params test.TestStepParameters) error {
 	return ts.validateAndPopulate(params)
 }
 
-// Resume tries to resume a previously interrupted test step. SSHCmd cannot
-// resume.
-func (ts *SSHCmd) Resume(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
-	return &cerrors.ErrResumeNotSupported{StepName: Name}
+// Resume resumes an interrupted step. The remote command runs again from the
+// start on the targets which had not completed it, so only idempotent
+// commands resume.
+func (ts *SSHCmd) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	if !ts.Idempotent {
+		return &cerrors.ErrResumeNotSupported{StepName: Name}
+	}
+	return ts.Run(ctx, pause, ch, params, ev)
 }
 
-// CanResume tells whether this step is able to resume.
+// CanResume tells whether this step is able to resume: the 'idempotent'
+// parameter must be set.
 func (ts *SSHCmd) CanResume() bool {
-	return false
+	return ts.Idempotent
 }
 
 // New initializes and returns a new SSHCmd test step.
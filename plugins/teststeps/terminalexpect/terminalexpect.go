@@ -6,13 +6,13 @@
 package terminalexpect
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
 	"time"
 
-	"github.com/facebookincubator/contest/pkg/cerrors"
 	"github.com/facebookincubator/contest/pkg/event"
 	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/logging"
@@ -56,7 +56,8 @@ func match(match string) termhook.LineHandler {
 }
 
 // Run executes the terminal step.
-func (ts *TerminalExpect) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+func (ts *TerminalExpect) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
 	if err := ts.validateAndPopulate(params); err != nil {
 		return err
 	}
@@ -86,6 +87,11 @@ func (ts *TerminalExpect) Run(cancel, pause <-chan struct{}, ch test.TestStepCha
 	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
 }
 
+// DryRun runs the step normally, as the terminal is only read.
+func (ts *TerminalExpect) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
 func (ts *TerminalExpect) validateAndPopulate(params test.TestStepParameters) error {
 	// no expression expansion for these parameters
 	port := params.GetOne("port")
@@ -120,15 +126,15 @@ func (ts *TerminalExpect) ValidateParameters(params test.TestStepParameters) err
 	return ts.validateAndPopulate(params)
 }
 
-// Resume tries to resume a previously interrupted test step. TerminalExpect cannot
-// resume.
-func (ts *TerminalExpect) Resume(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
-	return &cerrors.ErrResumeNotSupported{StepName: Name}
+// Resume resumes an interrupted step, waiting again for the expected output on
+// the targets which had not produced it.
+func (ts *TerminalExpect) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return ts.Run(ctx, pause, ch, params, ev)
 }
 
 // CanResume tells whether this step is able to resume.
 func (ts *TerminalExpect) CanResume() bool {
-	return false
+	return true
 }
 
 // New initializes and returns a new TerminalExpect test step.
@@ -6,7 +6,15 @@
 package teststeps
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/logging"
 	"github.com/facebookincubator/contest/pkg/target"
 	"github.com/facebookincubator/contest/pkg/test"
@@ -78,3 +86,118 @@ func ForEachTarget(pluginName string, cancel, pause <-chan struct{}, ch test.Tes
 		}
 	}
 }
+
+// DescribeFunc returns what a test step with side effects would do on a
+// target, e.g. "reboot the target".
+type DescribeFunc func(target *target.Target) (string, error)
+
+// DryRunEachTarget implements the dry run of a test step with side effects:
+// for each target, what the step would do is logged, and the target is
+// forwarded with a skip verdict. Targets for which describe fails are failed,
+// as they would be by a real run.
+func DryRunEachTarget(pluginName string, cancel, pause <-chan struct{}, ch test.TestStepChannels, describe DescribeFunc) error {
+	output := test.NewStepOutput(cancel, pause, ch)
+	for {
+		select {
+		case target, ok := <-ch.In:
+			if !ok || target == nil {
+				return nil
+			}
+			action, err := describe(target)
+			var sent bool
+			if err != nil {
+				sent = output.Fail(target, err.Error())
+			} else {
+				log.Infof("%s: dry run: would %s on target %s", pluginName, action, target)
+				sent = output.Skip(target, "dry run: would "+action)
+			}
+			if !sent {
+				return nil
+			}
+		case <-cancel:
+			return nil
+		case <-pause:
+			return nil
+		}
+	}
+}
+
+// Sleep waits for the specified duration, returning early with ErrInterrupted
+// if a cancellation or pause signal is received.
+func Sleep(cancel, pause <-chan struct{}, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-cancel:
+		return ErrInterrupted
+	case <-pause:
+		return ErrInterrupted
+	}
+}
+
+// EmitTargetEvent emits an event for the given target, serializing the
+// payload to JSON. A nil payload results in an event without payload.
+func EmitTargetEvent(ev testevent.Emitter, name event.Name, target *target.Target, payload interface{}) error {
+	data := testevent.Data{EventName: name, Target: target}
+	if payload != nil {
+		payloadJSON, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("cannot serialize payload for event %s: %v", name, err)
+		}
+		rawPayload := json.RawMessage(payloadJSON)
+		data.Payload = &rawPayload
+	}
+	if err := ev.Emit(data); err != nil {
+		return fmt.Errorf("cannot emit event %s: %v", name, err)
+	}
+	return nil
+}
+
+// DurationParam parses an optional, positive duration parameter expressed as
+// a Go duration string (e.g. "30s"). If the parameter is not set, the default
+// value is returned.
+func DurationParam(params test.TestStepParameters, name string, defaultValue time.Duration) (time.Duration, error) {
+	p := params.GetOne(name)
+	if p.IsEmpty() {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(p.Raw())
+	if err != nil {
+		return 0, fmt.Errorf("invalid '%s' parameter: %v", name, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid '%s' parameter: must be positive", name)
+	}
+	return d, nil
+}
+
+// BoolParam parses an optional boolean parameter, accepting the values
+// understood by strconv.ParseBool. If the parameter is not set, the default
+// value is returned.
+func BoolParam(params test.TestStepParameters, name string, defaultValue bool) (bool, error) {
+	p := params.GetOne(name)
+	if p.IsEmpty() {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(p.Raw())
+	if err != nil {
+		return false, fmt.Errorf("invalid '%s' parameter: %v", name, err)
+	}
+	return b, nil
+}
+
+// NewContext returns a context which is canceled when either the cancel or
+// the pause channel is closed, for use with APIs based on context.Context.
+// The returned CancelFunc must be called to release the associated resources.
+func NewContext(cancel, pause <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-cancel:
+		case <-pause:
+		case <-ctx.Done():
+		}
+		ctxCancel()
+	}()
+	return ctx, ctxCancel
+}
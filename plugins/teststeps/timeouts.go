@@ -0,0 +1,128 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package teststeps
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/test"
+)
+
+// DefaultConnectTimeout is the connect timeout used when neither the step nor
+// the job specify one.
+const DefaultConnectTimeout = 30 * time.Second
+
+// NetworkTimeouts is the timeout policy applied by the test steps at their
+// network boundaries. A zero value disables the corresponding timeout.
+type NetworkTimeouts struct {
+	// Connect bounds establishing a connection, including handshakes.
+	Connect time.Duration
+	// Read bounds each read on a connection, i.e. how long a peer may stay
+	// silent.
+	Read time.Duration
+	// Write bounds each write on a connection.
+	Write time.Duration
+	// Total bounds a whole operation, such as a request or a command.
+	Total time.Duration
+}
+
+// DefaultNetworkTimeouts returns the policy used for the timeouts set neither
+// in the step parameters nor in the job.
+func DefaultNetworkTimeouts() NetworkTimeouts {
+	return NetworkTimeouts{Connect: DefaultConnectTimeout}
+}
+
+// NewNetworkTimeouts returns the timeout policy found in the test step
+// parameters connect_timeout, read_timeout, write_timeout and total_timeout,
+// which the job manager populates from the job-wide policy. Unset timeouts
+// take the values of DefaultNetworkTimeouts.
+func NewNetworkTimeouts(params test.TestStepParameters) (NetworkTimeouts, error) {
+	t := DefaultNetworkTimeouts()
+	for name, d := range map[string]*time.Duration{
+		job.ConnectTimeoutParameter: &t.Connect,
+		job.ReadTimeoutParameter:    &t.Read,
+		job.WriteTimeoutParameter:   &t.Write,
+		job.TotalTimeoutParameter:   &t.Total,
+	} {
+		p := params.GetOne(name)
+		if p.IsEmpty() {
+			continue
+		}
+		v, err := time.ParseDuration(p.Raw())
+		if err != nil {
+			return t, fmt.Errorf("invalid '%s' parameter: %v", name, err)
+		}
+		if v < 0 {
+			return t, fmt.Errorf("invalid '%s' parameter: must be non-negative", name)
+		}
+		*d = v
+	}
+	return t, nil
+}
+
+// dialContext establishes the connections. It is a variable so that tests can
+// simulate unresponsive networks.
+var dialContext = (&net.Dialer{KeepAlive: 30 * time.Second}).DialContext
+
+// DialContext connects to the given address within the connect timeout. The
+// returned connection applies the read and write timeouts to each operation.
+func (t NetworkTimeouts) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if t.Connect > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Connect)
+		defer cancel()
+	}
+	conn, err := dialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return t.Conn(conn), nil
+}
+
+// Conn wraps an established connection so that it applies the read and write
+// timeouts. The connection is returned as is if both are disabled.
+func (t NetworkTimeouts) Conn(conn net.Conn) net.Conn {
+	if t.Read <= 0 && t.Write <= 0 {
+		return conn
+	}
+	return &timeoutConn{Conn: conn, read: t.Read, write: t.Write}
+}
+
+// Context returns a context bounded by the total timeout.
+func (t NetworkTimeouts) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	if t.Total > 0 {
+		return context.WithTimeout(parent, t.Total)
+	}
+	return context.WithCancel(parent)
+}
+
+// timeoutConn is a net.Conn which sets a deadline before each read and write.
+type timeoutConn struct {
+	net.Conn
+	read, write time.Duration
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if c.read > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.read)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	if c.write > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.write)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(b)
+}
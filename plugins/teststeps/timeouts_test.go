@@ -0,0 +1,123 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package teststeps
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/job"
+	"github.com/facebookincubator/contest/pkg/test"
+
+	"github.com/insomniacslk/xjson"
+	"github.com/stretchr/testify/require"
+)
+
+// silentListener accepts connections and never writes to them. The caller
+// must close it.
+func silentListener(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	return l
+}
+
+func TestConnectTimeoutIndependentOfReadTimeout(t *testing.T) {
+	// simulate a peer which never completes the connection
+	defer func(orig func(context.Context, string, string) (net.Conn, error)) { dialContext = orig }(dialContext)
+	dialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	timeouts := NetworkTimeouts{Connect: 50 * time.Millisecond, Read: time.Minute}
+	start := time.Now()
+	_, err := timeouts.DialContext(context.Background(), "tcp", "192.0.2.1:80")
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Less(t, int64(time.Since(start)), int64(10*time.Second))
+}
+
+func TestReadTimeoutIndependentOfConnectTimeout(t *testing.T) {
+	l := silentListener(t)
+	defer l.Close()
+	timeouts := NetworkTimeouts{Connect: 50 * time.Millisecond, Read: 300 * time.Millisecond}
+	conn, err := timeouts.DialContext(context.Background(), "tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the connect timeout does not apply once the connection is established
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	netErr, ok := err.(net.Error)
+	require.True(t, ok)
+	require.True(t, netErr.Timeout())
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(300*time.Millisecond))
+}
+
+func TestHTTPClientReadTimeout(t *testing.T) {
+	l := silentListener(t)
+	defer l.Close()
+	client := NewHTTPClient(HTTPClientOptions{Timeouts: NetworkTimeouts{Connect: time.Minute, Read: 100 * time.Millisecond}})
+	start := time.Now()
+	_, err := client.Get("http://" + l.Addr().String())
+	require.Error(t, err)
+	require.Less(t, int64(time.Since(start)), int64(10*time.Second))
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	l := silentListener(t)
+	defer l.Close()
+	// the read timeout does not bound the handshake, the connect timeout does
+	timeouts := NetworkTimeouts{Connect: 100 * time.Millisecond, Read: time.Minute}
+	start := time.Now()
+	_, err := timeouts.DialTLS(context.Background(), l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	require.Error(t, err)
+	require.Less(t, int64(time.Since(start)), int64(10*time.Second))
+
+	// cancellation aborts the handshake
+	ctx, cancel := context.WithCancel(context.Background())
+	timeouts.Connect = time.Minute
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	_, err = timeouts.DialTLS(ctx, l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestNewNetworkTimeoutsFromJob(t *testing.T) {
+	policy := job.NetworkTimeouts{
+		Connect: xjson.Duration(5 * time.Second),
+		Read:    xjson.Duration(time.Second),
+	}
+	params := policy.Apply(test.TestStepParameters{
+		job.ReadTimeoutParameter: []test.Param{*test.NewParam("2s")},
+	})
+	timeouts, err := NewNetworkTimeouts(params)
+	require.NoError(t, err)
+	// the step parameters take precedence over the job policy
+	require.Equal(t, NetworkTimeouts{Connect: 5 * time.Second, Read: 2 * time.Second}, timeouts)
+
+	timeouts, err = NewNetworkTimeouts(test.TestStepParameters{})
+	require.NoError(t, err)
+	require.Equal(t, DefaultNetworkTimeouts(), timeouts)
+
+	_, err = NewNetworkTimeouts(test.TestStepParameters{
+		job.WriteTimeoutParameter: []test.Param{*test.NewParam("-1s")},
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package teststeps
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+)
+
+// DialTLS connects to the given address and performs a TLS handshake. As in
+// the HTTP clients returned by NewHTTPClient, the connect timeout also bounds
+// the handshake. The handshake is aborted when the context is done.
+func (t NetworkTimeouts) DialTLS(ctx context.Context, addr string, config *tls.Config) (*tls.Conn, error) {
+	conn, err := t.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		handshakeCtx context.Context
+		cancel       context.CancelFunc
+	)
+	if t.Connect > 0 {
+		handshakeCtx, cancel = context.WithTimeout(ctx, t.Connect)
+	} else {
+		handshakeCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	tlsConn := tls.Client(conn, config)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tlsConn.Handshake()
+	}()
+	select {
+	case err = <-errCh:
+	case <-handshakeCtx.Done():
+		// closing the connection unblocks the handshake
+		conn.Close()
+		<-errCh
+		if err = ctx.Err(); err == nil {
+			err = fmt.Errorf("TLS handshake timed out after %v", t.Connect)
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
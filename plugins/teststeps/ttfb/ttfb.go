@@ -0,0 +1,342 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ttfb
+
+// The TTFB plugin measures the time-to-first-byte of an HTTP endpoint of each
+// target over a number of samples, and fails the targets for which the
+// selected percentile of the measurements exceeds a threshold.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/logging"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "TTFB"
+
+var log = logging.GetLogger("teststeps/" + strings.ToLower(Name))
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventTTFBMeasured = event.Name("TargetTTFBMeasured")
+	EventTTFBExceeded = event.Name("TargetTTFBExceeded")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventTTFBMeasured, EventTTFBExceeded}
+
+const (
+	defaultSamples         = 5
+	maxSamples             = 1000
+	defaultWarmup          = 1
+	defaultPercentile      = 50
+	defaultInterval        = 100 * time.Millisecond
+	defaultRequestTimeout  = 30 * time.Second
+	defaultMaxSamplingTime = time.Minute
+	// maxBodyDrain is the amount of response body read before closing the
+	// connection, so that the response is consumed by the server.
+	maxBodyDrain = 64 * 1024
+)
+
+// distribution summarizes the measured TTFB samples, in milliseconds.
+type distribution struct {
+	Samples []float64
+	Min     float64
+	Mean    float64
+	Median  float64
+	P90     float64
+	Max     float64
+}
+
+// ttfbEventPayload is the payload of the events emitted by this step.
+type ttfbEventPayload struct {
+	URL          string
+	Distribution distribution
+	Percentile   float64
+	// ValueMs is the value of the selected percentile.
+	ValueMs     float64
+	ThresholdMs float64
+	StatusCodes []int
+	// Truncated is true if sampling stopped because max_sampling_time was
+	// reached.
+	Truncated bool `json:",omitempty"`
+}
+
+// TTFB measures the time-to-first-byte of the targets' endpoints.
+type TTFB struct {
+	URL              *test.Param
+	Method           string
+	Samples          int
+	Warmup           int
+	Percentile       float64
+	Threshold        time.Duration
+	Interval         time.Duration
+	RequestTimeout   time.Duration
+	MaxSamplingTime  time.Duration
+	ReuseConnections bool
+	Insecure         bool
+	Timeouts         teststeps.NetworkTimeouts
+}
+
+// Name returns the plugin name.
+func (ts TTFB) Name() string {
+	return Name
+}
+
+// percentile returns the p-th percentile of the sorted values, using the
+// nearest-rank method.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func newDistribution(samples []time.Duration) distribution {
+	d := distribution{}
+	var sum float64
+	for _, s := range samples {
+		ms := float64(s) / float64(time.Millisecond)
+		d.Samples = append(d.Samples, ms)
+		sum += ms
+	}
+	sorted := append([]float64(nil), d.Samples...)
+	sort.Float64s(sorted)
+	if len(sorted) > 0 {
+		d.Min = sorted[0]
+		d.Max = sorted[len(sorted)-1]
+		d.Mean = sum / float64(len(sorted))
+		d.Median = percentile(sorted, 50)
+		d.P90 = percentile(sorted, 90)
+	}
+	return d
+}
+
+// sample performs one request and returns the time elapsed between sending
+// it and receiving the first byte of the response.
+func (ts *TTFB) sample(ctx context.Context, client *http.Client, u string) (time.Duration, int, error) {
+	var firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+	req, err := http.NewRequest(ts.Method, u, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	rctx, cancel := context.WithTimeout(ctx, ts.RequestTimeout)
+	defer cancel()
+	req = req.WithContext(httptrace.WithClientTrace(rctx, trace))
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, 0, teststeps.ErrInterrupted
+		}
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBodyDrain))
+	if firstByte.IsZero() {
+		return 0, 0, errors.New("no response byte received")
+	}
+	return firstByte.Sub(start), resp.StatusCode, nil
+}
+
+func (ts *TTFB) measure(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	u, err := ts.URL.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand url parameter: %v", err)
+	}
+	if err := teststeps.ValidateURL(u, "url"); err != nil {
+		return err
+	}
+	ctx, ctxCancel := teststeps.NewContext(cancel, pause)
+	defer ctxCancel()
+	client := teststeps.NewHTTPClient(teststeps.HTTPClientOptions{
+		Timeouts:           ts.Timeouts,
+		InsecureSkipVerify: ts.Insecure,
+		DisableKeepAlives:  !ts.ReuseConnections,
+	})
+	defer client.CloseIdleConnections()
+
+	payload := ttfbEventPayload{URL: u, Percentile: ts.Percentile, ThresholdMs: float64(ts.Threshold) / float64(time.Millisecond)}
+	deadline := time.Now().Add(ts.MaxSamplingTime)
+	var samples []time.Duration
+	for i := 0; i < ts.Warmup+ts.Samples; i++ {
+		if i > 0 {
+			if err := teststeps.Sleep(cancel, pause, ts.Interval); err != nil {
+				return err
+			}
+		}
+		if time.Now().After(deadline) {
+			log.Warningf("Reached max sampling time for target %s after %d samples", target, len(samples))
+			payload.Truncated = true
+			break
+		}
+		d, status, err := ts.sample(ctx, client, u)
+		if err != nil {
+			if errors.Is(err, teststeps.ErrInterrupted) {
+				return err
+			}
+			return fmt.Errorf("request to %s failed: %v", u, err)
+		}
+		if i < ts.Warmup {
+			continue
+		}
+		samples = append(samples, d)
+		payload.StatusCodes = append(payload.StatusCodes, status)
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("no TTFB samples collected within %s", ts.MaxSamplingTime)
+	}
+	payload.Distribution = newDistribution(samples)
+	sorted := append([]float64(nil), payload.Distribution.Samples...)
+	sort.Float64s(sorted)
+	payload.ValueMs = percentile(sorted, ts.Percentile)
+	if payload.ValueMs > payload.ThresholdMs {
+		if err := teststeps.EmitTargetEvent(ev, EventTTFBExceeded, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("p%v TTFB of %.1fms exceeds threshold of %s", ts.Percentile, payload.ValueMs, ts.Threshold)
+	}
+	return teststeps.EmitTargetEvent(ev, EventTTFBMeasured, target, payload)
+}
+
+// Run executes the TTFB step.
+func (ts *TTFB) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.measure(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the measurements normally, as they only send GET requests.
+func (ts *TTFB) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+// intParam parses an optional integer parameter in the range [min, max].
+func intParam(params test.TestStepParameters, name string, defaultValue, min, max int) (int, error) {
+	p := params.GetOne(name)
+	if p.IsEmpty() {
+		return defaultValue, nil
+	}
+	v, err := strconv.Atoi(p.Raw())
+	if err != nil {
+		return 0, fmt.Errorf("invalid '%s' parameter: %v", name, err)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("invalid '%s' parameter: must be between %d and %d", name, min, max)
+	}
+	return v, nil
+}
+
+func (ts *TTFB) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.URL = params.GetOne("url")
+	if err := teststeps.ValidateURLTemplate(ts.URL, "url"); err != nil {
+		return err
+	}
+	ts.Method = strings.ToUpper(params.GetOne("method").Raw())
+	switch ts.Method {
+	case "":
+		ts.Method = http.MethodGet
+	case http.MethodGet, http.MethodHead:
+	default:
+		return fmt.Errorf("invalid 'method' parameter %q: must be GET or HEAD", ts.Method)
+	}
+	if ts.Samples, err = intParam(params, "samples", defaultSamples, 1, maxSamples); err != nil {
+		return err
+	}
+	if ts.Warmup, err = intParam(params, "warmup", defaultWarmup, 0, maxSamples); err != nil {
+		return err
+	}
+	threshold := params.GetOne("threshold")
+	if threshold.IsEmpty() {
+		return errors.New("invalid or missing 'threshold' parameter, must be exactly one string")
+	}
+	if ts.Threshold, err = teststeps.DurationParam(params, "threshold", 0); err != nil {
+		return err
+	}
+	ts.Percentile = defaultPercentile
+	if p := params.GetOne("percentile"); !p.IsEmpty() {
+		if ts.Percentile, err = strconv.ParseFloat(p.Raw(), 64); err != nil || ts.Percentile <= 0 || ts.Percentile > 100 {
+			return fmt.Errorf("invalid 'percentile' parameter %q: must be a number in (0, 100]", p.Raw())
+		}
+	}
+	if ts.Interval, err = teststeps.DurationParam(params, "interval", defaultInterval); err != nil {
+		return err
+	}
+	if ts.RequestTimeout, err = teststeps.DurationParam(params, "request_timeout", defaultRequestTimeout); err != nil {
+		return err
+	}
+	if ts.MaxSamplingTime, err = teststeps.DurationParam(params, "max_sampling_time", defaultMaxSamplingTime); err != nil {
+		return err
+	}
+	if ts.ReuseConnections, err = teststeps.BoolParam(params, "reuse_connections", false); err != nil {
+		return err
+	}
+	if ts.Insecure, err = teststeps.BoolParam(params, "insecure", false); err != nil {
+		return err
+	}
+	if ts.Timeouts, err = teststeps.NewNetworkTimeouts(params); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *TTFB) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. TTFB cannot
+// resume.
+func (ts *TTFB) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *TTFB) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new TTFB test step.
+func New() test.TestStep {
+	return &TTFB{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
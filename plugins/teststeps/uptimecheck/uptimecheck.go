@@ -0,0 +1,189 @@
+// Copyright (c) Facebook, Inc. and its affiliates.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package uptimecheck
+
+// The UptimeCheck plugin reads the uptime of each target over SSH, and fails
+// the targets which rebooted too recently to be considered stable, or which
+// have been running for longer than allowed and are overdue for a reboot.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/facebookincubator/contest/pkg/cerrors"
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
+	"github.com/facebookincubator/contest/pkg/target"
+	"github.com/facebookincubator/contest/pkg/test"
+	"github.com/facebookincubator/contest/plugins/teststeps"
+)
+
+// Name is the name used to look this plugin up.
+var Name = "UptimeCheck"
+
+// events that we may emit during the plugin's lifecycle.
+const (
+	EventUptimeOK            = event.Name("TargetUptimeOK")
+	EventTooRecentlyRebooted = event.Name("TargetTooRecentlyRebooted")
+	EventRebootOverdue       = event.Name("TargetRebootOverdue")
+)
+
+// Events defines the events that a TestStep is allow to emit. Emitting an event
+// that is not registered here will cause the plugin to terminate with an error.
+var Events = []event.Name{EventUptimeOK, EventTooRecentlyRebooted, EventRebootOverdue}
+
+// defaultCommand prints the uptime of a Linux system in seconds.
+const defaultCommand = "cat /proc/uptime"
+
+// uptimeEventPayload is the payload of the events emitted by this step.
+type uptimeEventPayload struct {
+	Uptime    string
+	UptimeSec float64
+	MinUptime string `json:",omitempty"`
+	MaxUptime string `json:",omitempty"`
+}
+
+// UptimeCheck verifies that the uptime of the targets is within bounds.
+type UptimeCheck struct {
+	*teststeps.SSHParams
+	Command   *test.Param
+	MinUptime time.Duration
+	MaxUptime time.Duration
+}
+
+// Name returns the plugin name.
+func (ts UptimeCheck) Name() string {
+	return Name
+}
+
+// parseUptime parses the output of /proc/uptime, whose first field is the
+// uptime of the system in seconds.
+func parseUptime(out []byte) (time.Duration, error) {
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return 0, errors.New("empty uptime output")
+	}
+	sec, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || sec < 0 {
+		return 0, fmt.Errorf("invalid uptime %q", fields[0])
+	}
+	return time.Duration(sec * float64(time.Second)), nil
+}
+
+func (ts *UptimeCheck) check(cancel, pause <-chan struct{}, target *target.Target, ev testevent.Emitter) error {
+	cmd, err := ts.Command.Expand(target)
+	if err != nil {
+		return fmt.Errorf("cannot expand command parameter: %v", err)
+	}
+	result, err := ts.RunSSHCommand(cancel, pause, target, cmd)
+	if err != nil {
+		return err
+	}
+	if result.ExitStatus != 0 {
+		return fmt.Errorf("command '%s' exited with status %d: %s", cmd, result.ExitStatus, bytes.TrimSpace(result.Stderr))
+	}
+	uptime, err := parseUptime(result.Stdout)
+	if err != nil {
+		return err
+	}
+	payload := uptimeEventPayload{
+		Uptime:    uptime.Round(time.Second).String(),
+		UptimeSec: uptime.Seconds(),
+	}
+	if ts.MinUptime > 0 {
+		payload.MinUptime = ts.MinUptime.String()
+	}
+	if ts.MaxUptime > 0 {
+		payload.MaxUptime = ts.MaxUptime.String()
+	}
+	switch {
+	case ts.MinUptime > 0 && uptime < ts.MinUptime:
+		if err := teststeps.EmitTargetEvent(ev, EventTooRecentlyRebooted, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("uptime %s is below the minimum of %s", payload.Uptime, ts.MinUptime)
+	case ts.MaxUptime > 0 && uptime > ts.MaxUptime:
+		if err := teststeps.EmitTargetEvent(ev, EventRebootOverdue, target, payload); err != nil {
+			return err
+		}
+		return fmt.Errorf("uptime %s is above the maximum of %s", payload.Uptime, ts.MaxUptime)
+	}
+	return teststeps.EmitTargetEvent(ev, EventUptimeOK, target, payload)
+}
+
+// Run executes the uptime check step.
+func (ts *UptimeCheck) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	cancel := test.CancelChannel(ctx)
+	if err := ts.validateAndPopulate(params); err != nil {
+		return err
+	}
+	f := func(cancel, pause <-chan struct{}, target *target.Target) error {
+		return ts.check(cancel, pause, target, ev)
+	}
+	return teststeps.ForEachTarget(Name, cancel, pause, ch, f)
+}
+
+// DryRun runs the check normally, as reading the uptime has no side
+// effects.
+func (ts *UptimeCheck) DryRun(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+	return ts.Run(ctx, pause, ch, params, ev)
+}
+
+func (ts *UptimeCheck) validateAndPopulate(params test.TestStepParameters) error {
+	var err error
+	ts.SSHParams, err = teststeps.NewSSHParams(params)
+	if err != nil {
+		return err
+	}
+	ts.Command = params.GetOne("command")
+	if ts.Command.IsEmpty() {
+		ts.Command = test.NewParam(defaultCommand)
+	}
+	if ts.MinUptime, err = teststeps.DurationParam(params, "min_uptime", 0); err != nil {
+		return err
+	}
+	if ts.MaxUptime, err = teststeps.DurationParam(params, "max_uptime", 0); err != nil {
+		return err
+	}
+	if ts.MinUptime == 0 && ts.MaxUptime == 0 {
+		return errors.New("at least one of 'min_uptime' and 'max_uptime' must be specified")
+	}
+	if ts.MinUptime > 0 && ts.MaxUptime > 0 && ts.MinUptime >= ts.MaxUptime {
+		return fmt.Errorf("'min_uptime' (%s) must be lower than 'max_uptime' (%s)", ts.MinUptime, ts.MaxUptime)
+	}
+	return nil
+}
+
+// ValidateParameters validates the parameters associated to the TestStep
+func (ts *UptimeCheck) ValidateParameters(params test.TestStepParameters) error {
+	return ts.validateAndPopulate(params)
+}
+
+// Resume tries to resume a previously interrupted test step. UptimeCheck
+// cannot resume.
+func (ts *UptimeCheck) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+	return &cerrors.ErrResumeNotSupported{StepName: Name}
+}
+
+// CanResume tells whether this step is able to resume.
+func (ts *UptimeCheck) CanResume() bool {
+	return false
+}
+
+// New initializes and returns a new UptimeCheck test step.
+func New() test.TestStep {
+	return &UptimeCheck{}
+}
+
+// Load returns the name, factory and events which are needed to register the step.
+func Load() (string, test.TestStepFactory, []event.Name) {
+	return Name, New, Events
+}
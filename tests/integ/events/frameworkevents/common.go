@@ -38,8 +38,8 @@ func populateFrameworkEvents(backend storage.Storage, emitTime time.Time) error
 	data := []byte("{ 'test_key': 'test_value' }")
 	payload := (*json.RawMessage)(&data)
 
-	eventFirst := frameworkevent.Event{JobID: 1, EventName: "AFrameworkEvent", Payload: payload, EmitTime: emitTime}
-	eventSecond := frameworkevent.Event{JobID: 1, EventName: "BFrameworkEvent", Payload: payload, EmitTime: emitTime}
+	eventFirst := frameworkevent.Event{JobID: "1", EventName: "AFrameworkEvent", Payload: payload, EmitTime: emitTime}
+	eventSecond := frameworkevent.Event{JobID: "1", EventName: "BFrameworkEvent", Payload: payload, EmitTime: emitTime}
 
 	err := backend.StoreFrameworkEvent(eventFirst)
 	if err != nil {
@@ -52,13 +52,13 @@ func assertFrameworkEvents(t *testing.T, ev []frameworkevent.Event, emitTime tim
 	data := []byte("{ 'test_key': 'test_value' }")
 	payload := (*json.RawMessage)(&data)
 
-	assert.Equal(t, types.JobID(1), ev[0].JobID)
+	assert.Equal(t, types.JobID("1"), ev[0].JobID)
 	assert.Equal(t, event.Name("AFrameworkEvent"), ev[0].EventName)
 	assert.Equal(t, payload, ev[0].Payload)
 	assert.Equal(t, emitTime.UTC(), ev[0].EmitTime.UTC())
 
 	if len(ev) == 2 {
-		assert.Equal(t, types.JobID(1), ev[1].JobID)
+		assert.Equal(t, types.JobID("1"), ev[1].JobID)
 		assert.Equal(t, event.Name("BFrameworkEvent"), ev[1].EventName)
 		assert.Equal(t, payload, ev[1].Payload)
 		assert.Equal(t, emitTime.UTC(), ev[1].EmitTime.UTC())
@@ -98,7 +98,7 @@ func (suite *FrameworkEventsSuite) TestRetrieveSingleFrameworkEvent() {
 
 func (suite *FrameworkEventsSuite) TestRetrieveMultipleFrameworkEvents() {
 
-	eventQuery := mustBuildQuery(suite.T(), frameworkevent.QueryJobID(1))
+	eventQuery := mustBuildQuery(suite.T(), frameworkevent.QueryJobID("1"))
 	results, err := suite.storage.GetFrameworkEvent(eventQuery)
 	require.NoError(suite.T(), err)
 	assert.Equal(suite.T(), 0, len(results))
@@ -107,7 +107,7 @@ func (suite *FrameworkEventsSuite) TestRetrieveMultipleFrameworkEvents() {
 	err = populateFrameworkEvents(suite.storage, emitTime)
 	require.NoError(suite.T(), err)
 
-	eventQuery = mustBuildQuery(suite.T(), frameworkevent.QueryJobID(1))
+	eventQuery = mustBuildQuery(suite.T(), frameworkevent.QueryJobID("1"))
 	results, err = suite.storage.GetFrameworkEvent(eventQuery)
 
 	require.NoError(suite.T(), err)
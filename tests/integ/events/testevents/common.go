@@ -9,6 +9,7 @@ package test
 
 import (
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 
@@ -40,11 +41,11 @@ func populateTestEvents(backend storage.Storage, emitTime time.Time) error {
 	testTargetFirst := target.Target{Name: "ATargetName", ID: "ATargetID", FQDN: "AFQDN"}
 	testTargetSecond := target.Target{Name: "BTargetName", ID: "BTargetID", FQDN: "BFQDN"}
 
-	hdrFirst := testevent.Header{JobID: 1, TestName: "ATestName", TestStepLabel: "TestStepLabel"}
-	dataFirst := testevent.Data{EventName: event.Name("AEventName"), Target: &testTargetFirst, Payload: payload}
+	hdrFirst := testevent.Header{JobID: "1", TestName: "ATestName", TestStepLabel: "TestStepLabel"}
+	dataFirst := testevent.Data{EventName: event.Name("AEventName"), Target: &testTargetFirst, Payload: payload, Severity: testevent.SeverityInfo}
 
-	hdrSecond := testevent.Header{JobID: 2, TestName: "BTestName", TestStepLabel: "TestStepLabel"}
-	dataSecond := testevent.Data{EventName: event.Name("BEventName"), Target: &testTargetSecond, Payload: payload}
+	hdrSecond := testevent.Header{JobID: "2", TestName: "BTestName", TestStepLabel: "TestStepLabel"}
+	dataSecond := testevent.Data{EventName: event.Name("BEventName"), Target: &testTargetSecond, Payload: payload, Severity: testevent.SeverityError}
 
 	eventFirst := testevent.Event{Header: &hdrFirst, Data: &dataFirst, EmitTime: emitTime}
 	eventSecond := testevent.Event{Header: &hdrSecond, Data: &dataSecond, EmitTime: emitTime}
@@ -61,23 +62,25 @@ func assertTestEvents(t *testing.T, ev []testevent.Event, emitTime time.Time) {
 	data := []byte("{ 'test_key': 'test_value' }")
 	payload := (*json.RawMessage)(&data)
 
-	assert.Equal(t, types.JobID(1), ev[0].Header.JobID)
+	assert.Equal(t, types.JobID("1"), ev[0].Header.JobID)
 	assert.Equal(t, "ATestName", ev[0].Header.TestName)
 	assert.Equal(t, "TestStepLabel", ev[0].Header.TestStepLabel)
 	assert.Equal(t, event.Name("AEventName"), ev[0].Data.EventName)
 	assert.Equal(t, "ATargetName", ev[0].Data.Target.Name)
 	assert.Equal(t, "ATargetID", ev[0].Data.Target.ID)
 	assert.Equal(t, payload, ev[0].Data.Payload)
+	assert.Equal(t, testevent.SeverityInfo, ev[0].Data.Severity)
 	assert.Equal(t, emitTime.UTC(), ev[0].EmitTime.UTC())
 
 	if len(ev) == 2 {
-		assert.Equal(t, types.JobID(2), ev[1].Header.JobID)
+		assert.Equal(t, types.JobID("2"), ev[1].Header.JobID)
 		assert.Equal(t, "BTestName", ev[1].Header.TestName)
 		assert.Equal(t, "TestStepLabel", ev[1].Header.TestStepLabel)
 		assert.Equal(t, event.Name("BEventName"), ev[1].Data.EventName)
 		assert.Equal(t, "BTargetName", ev[1].Data.Target.Name)
 		assert.Equal(t, "BTargetID", ev[1].Data.Target.ID)
 		assert.Equal(t, payload, ev[1].Data.Payload)
+		assert.Equal(t, testevent.SeverityError, ev[1].Data.Severity)
 		assert.Equal(t, emitTime.UTC(), ev[1].EmitTime.UTC())
 	}
 }
@@ -185,7 +188,7 @@ func (suite *TestEventsSuite) TestRetrieveSingleTestEventsByNameAndJobID() {
 
 	testEventQuery := mustBuildQuery(suite.T(),
 		testevent.QueryEventName(event.Name("AEventName")),
-		testevent.QueryJobID(1),
+		testevent.QueryJobID("1"),
 	)
 	results, err := suite.storage.GetTestEvents(testEventQuery)
 
@@ -193,3 +196,193 @@ func (suite *TestEventsSuite) TestRetrieveSingleTestEventsByNameAndJobID() {
 	assert.Equal(suite.T(), 1, len(results))
 	assertTestEvents(suite.T(), results, emitTime)
 }
+
+func (suite *TestEventsSuite) TestRetrieveTestEventsBySeverity() {
+
+	emitTime := time.Now().Truncate(2 * time.Second)
+	err := populateTestEvents(suite.storage, emitTime)
+	require.NoError(suite.T(), err)
+
+	testEventQuery := mustBuildQuery(suite.T(), testevent.QuerySeverity(testevent.SeverityError))
+	results, err := suite.storage.GetTestEvents(testEventQuery)
+
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), 1, len(results))
+	assert.Equal(suite.T(), event.Name("BEventName"), results[0].Data.EventName)
+	assert.Equal(suite.T(), testevent.SeverityError, results[0].Data.Severity)
+
+	testEventQuery = mustBuildQuery(suite.T(),
+		testevent.QuerySeverities([]testevent.Severity{testevent.SeverityInfo, testevent.SeverityWarning}),
+		testevent.QueryTestStepLabel("TestStepLabel"),
+	)
+	results, err = suite.storage.GetTestEvents(testEventQuery)
+
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(results))
+	assertTestEvents(suite.T(), results, emitTime)
+
+	testEventQuery = mustBuildQuery(suite.T(),
+		testevent.QuerySeverity(testevent.SeverityError),
+		testevent.QueryJobID("1"),
+	)
+	results, err = suite.storage.GetTestEvents(testEventQuery)
+
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, len(results))
+}
+
+func (suite *TestEventsSuite) TestRetrieveTestEventsPages() {
+
+	emitTime := time.Now().Truncate(2 * time.Second)
+	err := populateTestEvents(suite.storage, emitTime)
+	require.NoError(suite.T(), err)
+
+	testEventQuery := mustBuildQuery(suite.T(), testevent.QueryTestStepLabel("TestStepLabel"))
+	page, err := suite.storage.GetTestEventsPage(testEventQuery, 0, 1)
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), 1, len(page.Events))
+	require.True(suite.T(), page.More)
+	assertTestEvents(suite.T(), page.Events, emitTime)
+
+	// events stored after the first page are returned in the following ones
+	err = populateTestEvents(suite.storage, emitTime)
+	require.NoError(suite.T(), err)
+	var names []event.Name
+	for after := page.LastSequence; page.More; after = page.LastSequence {
+		page, err = suite.storage.GetTestEventsPage(testEventQuery, after, 2)
+		require.NoError(suite.T(), err)
+		for _, ev := range page.Events {
+			names = append(names, ev.Data.EventName)
+		}
+	}
+	assert.Equal(suite.T(), []event.Name{"BEventName", "AEventName", "BEventName"}, names)
+
+	page, err = suite.storage.GetTestEventsPage(testEventQuery, page.LastSequence, 2)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, len(page.Events))
+	assert.False(suite.T(), page.More)
+}
+
+// pageRecordingStorage records the size of the pages of test events fetched,
+// and fails full fetches.
+type pageRecordingStorage struct {
+	storage.Storage
+	pageSizes []int
+}
+
+func (s *pageRecordingStorage) GetTestEvents(eventQuery *testevent.Query) ([]testevent.Event, error) {
+	return nil, errors.New("test events must be fetched by page")
+}
+
+func (s *pageRecordingStorage) GetTestEventsPage(eventQuery *testevent.Query, afterSequence uint64, limit int) (*testevent.Page, error) {
+	page, err := s.Storage.GetTestEventsPage(eventQuery, afterSequence, limit)
+	if err == nil {
+		s.pageSizes = append(s.pageSizes, len(page.Events))
+	}
+	return page, err
+}
+
+func (suite *TestEventsSuite) TestStreamEvents() {
+	const total = 2500
+	hdr := testevent.Header{JobID: "1", TestName: "ATestName", TestStepLabel: "TestStepLabel"}
+	other := testevent.Header{JobID: "2", TestName: "ATestName", TestStepLabel: "TestStepLabel"}
+	for i := 0; i < total; i++ {
+		name := event.Name("Odd")
+		if i%2 == 0 {
+			name = "Even"
+		}
+		for _, h := range []*testevent.Header{&hdr, &other} {
+			ev := testevent.Event{Header: h, Data: &testevent.Data{EventName: name, Severity: testevent.SeverityInfo}, EmitTime: time.Now()}
+			require.NoError(suite.T(), suite.storage.StoreTestEvent(ev))
+		}
+	}
+	recorder := &pageRecordingStorage{Storage: suite.storage}
+	storage.SetStorage(recorder)
+	defer storage.SetStorage(suite.storage)
+
+	count := 0
+	err := storage.StreamEvents("1",
+		func(ev testevent.Event) bool { return ev.Data.EventName == "Even" },
+		func(ev testevent.Event) error {
+			require.Equal(suite.T(), types.JobID("1"), ev.Header.JobID)
+			count++
+			return nil
+		},
+	)
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), total/2, count)
+	// the events were never all in memory at once
+	require.True(suite.T(), len(recorder.pageSizes) > 1)
+	for _, size := range recorder.pageSizes {
+		require.True(suite.T(), size < total, "page of %d events", size)
+	}
+
+	// streaming stops at the first error
+	stop := errors.New("stop")
+	count = 0
+	err = storage.StreamEvents("1", nil, func(ev testevent.Event) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	require.Equal(suite.T(), stop, err)
+	require.Equal(suite.T(), 3, count)
+}
+
+func (suite *TestEventsSuite) TestRetrieveTestEventsByResult() {
+	hdr := testevent.Header{JobID: "1", TestName: "ATestName", TestStepLabel: "TestStepLabel"}
+	tgt := &target.Target{Name: "ATargetName", ID: "ATargetID"}
+	bootTime := 12.5
+	for _, r := range []testevent.Result{
+		{Measurement: "boot_time", Unit: "s", Value: &bootTime, Status: testevent.ResultPass},
+		{Measurement: "nic_link", Status: testevent.ResultFail, Message: "link down"},
+	} {
+		data, err := testevent.NewResultData(tgt, r)
+		require.NoError(suite.T(), err)
+		require.NoError(suite.T(), suite.storage.StoreTestEvent(testevent.Event{Header: &hdr, Data: &data, EmitTime: time.Now()}))
+	}
+
+	results, err := suite.storage.GetTestEvents(mustBuildQuery(suite.T(), testevent.QueryMeasurement("boot_time")))
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), 1, len(results))
+	result, err := results[0].Data.Result()
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "s", result.Unit)
+	assert.Equal(suite.T(), bootTime, *result.Value)
+
+	results, err = suite.storage.GetTestEvents(mustBuildQuery(suite.T(),
+		testevent.QueryResultStatus(testevent.ResultFail),
+		testevent.QueryJobID("1"),
+	))
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), 1, len(results))
+	assert.Equal(suite.T(), testevent.SeverityError, results[0].Data.Severity)
+
+	results, err = suite.storage.GetTestEvents(mustBuildQuery(suite.T(),
+		testevent.QueryMeasurement("nic_link"),
+		testevent.QueryResultStatus(testevent.ResultPass),
+	))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 0, len(results))
+}
+
+func (suite *TestEventsSuite) TestRetrieveTestEventsByRunID() {
+	tgt := &target.Target{Name: "ATargetName", ID: "ATargetID"}
+	for _, runID := range []types.RunID{1, 2, 2} {
+		hdr := testevent.Header{JobID: "1", RunID: runID, TestName: "ATestName", TestStepLabel: "TestStepLabel"}
+		ev := testevent.Event{Header: &hdr, Data: &testevent.Data{EventName: event.Name("AEventName"), Target: tgt, Severity: testevent.SeverityInfo}, EmitTime: time.Now()}
+		require.NoError(suite.T(), suite.storage.StoreTestEvent(ev))
+	}
+
+	results, err := suite.storage.GetTestEvents(mustBuildQuery(suite.T(), testevent.QueryJobID("1"), testevent.QueryRunID(2)))
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), 2, len(results))
+	for _, ev := range results {
+		assert.Equal(suite.T(), types.RunID(2), ev.Header.RunID)
+	}
+	results, err = suite.storage.GetTestEvents(mustBuildQuery(suite.T(), testevent.QueryRunID(1)))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, len(results))
+}
@@ -8,10 +8,15 @@
 package test
 
 import (
+	"errors"
 	"time"
 
+	"github.com/facebookincubator/contest/pkg/event"
+	"github.com/facebookincubator/contest/pkg/event/frameworkevent"
+	"github.com/facebookincubator/contest/pkg/event/testevent"
 	"github.com/facebookincubator/contest/pkg/job"
 	"github.com/facebookincubator/contest/pkg/storage"
+	"github.com/facebookincubator/contest/pkg/target"
 	"github.com/facebookincubator/contest/pkg/types"
 
 	"github.com/stretchr/testify/require"
@@ -77,7 +82,7 @@ func (suite *JobSuite) TestPersistJobRequestReturnsCorrectID() {
 
 	jobID, err := suite.storage.StoreJobRequest(&jobRequestFirst)
 	require.NoError(suite.T(), err)
-	require.Equal(suite.T(), types.JobID(1), jobID)
+	require.Equal(suite.T(), types.JobID("1"), jobID)
 
 	jobRequestSecond := job.Request{
 		JobName:       "BName",
@@ -88,16 +93,16 @@ func (suite *JobSuite) TestPersistJobRequestReturnsCorrectID() {
 
 	jobID, err = suite.storage.StoreJobRequest(&jobRequestSecond)
 	require.NoError(suite.T(), err)
-	require.Equal(suite.T(), types.JobID(2), jobID)
+	require.Equal(suite.T(), types.JobID("2"), jobID)
 }
 
 func (suite *JobSuite) TestGetJobRequest() {
 
 	populateJob(suite.storage)
 
-	request, err := suite.storage.GetJobRequest(types.JobID(1))
+	request, err := suite.storage.GetJobRequest(types.JobID("1"))
 	require.NoError(suite.T(), err)
-	require.Equal(suite.T(), types.JobID(1), request.JobID)
+	require.Equal(suite.T(), types.JobID("1"), request.JobID)
 	require.Equal(suite.T(), request.Requestor, "AIntegrationTest")
 	require.Equal(suite.T(), request.JobDescriptor, jobDescriptorFirst)
 
@@ -107,17 +112,232 @@ func (suite *JobSuite) TestGetJobRequest() {
 	require.True(suite.T(), request.RequestTime.After(time.Now().Add(-2*time.Second)))
 	require.True(suite.T(), request.RequestTime.Before(time.Now().Add(2*time.Second)))
 
-	request, err = suite.storage.GetJobRequest(types.JobID(2))
+	request, err = suite.storage.GetJobRequest(types.JobID("2"))
 
 	// Creation timestamp corresponds to the timestamp of the insertion into the
 	// database. Assert that the timestamp retrieved from the database is within
 	// and acceptable range
 	require.NoError(suite.T(), err)
-	require.Equal(suite.T(), types.JobID(2), request.JobID)
+	require.Equal(suite.T(), types.JobID("2"), request.JobID)
 	require.Equal(suite.T(), request.Requestor, "BIntegrationTest")
 	require.Equal(suite.T(), request.JobDescriptor, jobDescriptorSecond)
 
 	require.True(suite.T(), request.RequestTime.After(time.Now().Add(-2*time.Second)))
 	require.True(suite.T(), request.RequestTime.Before(time.Now().Add(2*time.Second)))
 
+	_, err = suite.storage.GetJobRequest(types.JobID("3"))
+	var notFound *storage.ErrJobRequestNotFound
+	require.True(suite.T(), errors.As(err, &notFound))
+	require.Equal(suite.T(), types.JobID("3"), notFound.JobID)
+}
+
+func (suite *JobSuite) TestFetchEventCounts() {
+
+	require.NoError(suite.T(), populateJob(suite.storage))
+	fetcher := storage.NewJobRequestFetcher()
+
+	// a job which did not emit anything yet has no counts
+	counts, err := fetcher.FetchEventCounts(types.JobID("1"))
+	require.NoError(suite.T(), err)
+	require.Empty(suite.T(), counts)
+
+	header := testevent.Header{JobID: types.JobID("1"), RunID: 1, TestName: "test", TestStepLabel: "sleep"}
+	emitter := storage.NewTestEventEmitter(header)
+	for _, name := range []event.Name{"TargetSleeping", "TargetSleeping", "TargetAwake"} {
+		require.NoError(suite.T(), emitter.Emit(testevent.Data{EventName: name}))
+	}
+	// events of other jobs are not counted
+	otherHeader := header
+	otherHeader.JobID = types.JobID("2")
+	require.NoError(suite.T(), storage.NewTestEventEmitter(otherHeader).Emit(testevent.Data{EventName: "TargetAwake"}))
+
+	counts, err = fetcher.FetchEventCounts(types.JobID("1"))
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), map[event.Name]uint64{"TargetSleeping": 2, "TargetAwake": 1}, counts)
+
+	_, err = fetcher.FetchEventCounts(types.JobID("3"))
+	var notFound *storage.ErrJobRequestNotFound
+	require.True(suite.T(), errors.As(err, &notFound))
+	require.Equal(suite.T(), types.JobID("3"), notFound.JobID)
+}
+
+func (suite *JobSuite) TestGetJobRequests() {
+	start := time.Now().Truncate(time.Second)
+	for i, name := range []string{"A", "B", "C"} {
+		_, err := suite.storage.StoreJobRequest(&job.Request{
+			JobName:       name,
+			Requestor:     "IntegrationTest",
+			RequestTime:   start.Add(time.Duration(i) * time.Second),
+			JobDescriptor: jobDescriptorFirst,
+		})
+		require.NoError(suite.T(), err)
+	}
+	for _, ev := range []frameworkevent.Event{
+		{JobID: "1", EventName: job.EventJobStarted, EmitTime: start},
+		{JobID: "1", EventName: job.EventJobCompleted, EmitTime: start},
+		{JobID: "2", EventName: job.EventJobStarted, EmitTime: start},
+	} {
+		require.NoError(suite.T(), suite.storage.StoreFrameworkEvent(ev))
+	}
+
+	names := func(query job.JobQuery) []string {
+		if query.Limit == 0 {
+			query.Limit = job.DefaultJobQueryLimit
+		}
+		requests, err := suite.storage.GetJobRequests(query)
+		require.NoError(suite.T(), err)
+		var names []string
+		for _, r := range requests {
+			names = append(names, r.JobName)
+		}
+		return names
+	}
+	require.Equal(suite.T(), []string{"A", "B", "C"}, names(job.JobQuery{}))
+	require.Equal(suite.T(), []string{"B"}, names(job.JobQuery{Offset: 1, Limit: 1}))
+	require.Empty(suite.T(), names(job.JobQuery{Offset: 3}))
+	require.Equal(suite.T(), []string{"B", "C"}, names(job.JobQuery{States: []event.Name{job.EventJobStarted, job.StateQueued}}))
+	require.Equal(suite.T(), []string{"A"}, names(job.JobQuery{States: []event.Name{job.EventJobCompleted}}))
+}
+
+func (suite *JobSuite) TestStoreJobRequests() {
+	newRequest := func(name string, jobID types.JobID) *job.Request {
+		return &job.Request{
+			JobID:         jobID,
+			JobName:       name,
+			Requestor:     "IntegrationTest",
+			RequestTime:   time.Now(),
+			JobDescriptor: jobDescriptorFirst,
+		}
+	}
+	jobIDs, err := suite.storage.StoreJobRequests([]*job.Request{newRequest("A", ""), newRequest("B", "custom"), newRequest("C", "")})
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), []types.JobID{"1", "custom", "2"}, jobIDs)
+
+	// a conflicting job ID rolls back the whole batch
+	failed := []*job.Request{newRequest("D", ""), newRequest("E", "custom")}
+	_, err = suite.storage.StoreJobRequests(failed)
+	require.Error(suite.T(), err)
+	require.Equal(suite.T(), types.JobID(""), failed[0].JobID)
+	requests, err := suite.storage.GetJobRequests(job.JobQuery{Limit: job.DefaultJobQueryLimit})
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), requests, 3)
+	for _, request := range requests {
+		require.NotEqual(suite.T(), "D", request.JobName)
+	}
+	// the valid request of the batch can be stored on its own
+	jobIDs, err = suite.storage.StoreJobRequests(failed[:1])
+	require.NoError(suite.T(), err)
+	request, err := suite.storage.GetJobRequest(jobIDs[0])
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), "D", request.JobName)
+}
+
+func (suite *JobSuite) TestJobSchedules() {
+	nextRun := time.Now().Add(time.Hour).Truncate(time.Second)
+	schedule := job.Schedule{
+		Recurrence:    job.Recurrence{Cron: "@hourly"},
+		JobDescriptor: jobDescriptorFirst,
+		Requestor:     "IntegrationTest",
+		CreateTime:    time.Now(),
+		State:         job.ScheduleActive,
+		NextRun:       nextRun,
+	}
+	id, err := suite.storage.StoreJobSchedule(&schedule)
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), uint64(1), id)
+
+	// a run can only be claimed once
+	following := nextRun.Add(time.Hour)
+	claimed, err := suite.storage.ClaimJobScheduleRun(id, nextRun, following)
+	require.NoError(suite.T(), err)
+	require.True(suite.T(), claimed)
+	claimed, err = suite.storage.ClaimJobScheduleRun(id, nextRun, following)
+	require.NoError(suite.T(), err)
+	require.False(suite.T(), claimed)
+
+	_, err = suite.storage.StoreJobRequest(&job.Request{JobName: "A", SeriesID: "schedule-1", Requestor: "IntegrationTest", RequestTime: time.Now(), JobDescriptor: jobDescriptorFirst})
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), suite.storage.RecordJobScheduleRun(id, "1"))
+	jobIDs, err := suite.storage.GetJobIDsBySeriesID("schedule-1")
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), []types.JobID{"1"}, jobIDs)
+
+	stored, err := suite.storage.GetJobSchedule(id)
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), "@hourly", stored.Cron)
+	require.True(suite.T(), following.Equal(stored.NextRun))
+	require.Equal(suite.T(), uint64(1), stored.Runs)
+	require.Equal(suite.T(), types.JobID("1"), stored.LastJobID)
+
+	// paused schedules cannot be claimed
+	require.NoError(suite.T(), suite.storage.SetJobScheduleState(id, job.SchedulePaused, time.Time{}))
+	claimed, err = suite.storage.ClaimJobScheduleRun(id, following, following.Add(time.Hour))
+	require.NoError(suite.T(), err)
+	require.False(suite.T(), claimed)
+	schedules, err := suite.storage.GetJobSchedules()
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), schedules, 1)
+	require.Equal(suite.T(), job.SchedulePaused, schedules[0].State)
+	require.True(suite.T(), schedules[0].NextRun.IsZero())
+}
+
+func (suite *JobSuite) TestTargetHealth() {
+	now := time.Now().Truncate(time.Second)
+	outcomes := []target.Outcome{
+		{TargetID: "t1", JobID: "1", RunID: 1, Success: true, Time: now.Add(-time.Hour)},
+		{TargetID: "t1", JobID: "2", RunID: 1, Success: false, Time: now},
+		{TargetID: "t2", JobID: "2", RunID: 1, Success: true, Time: now},
+	}
+	require.NoError(suite.T(), suite.storage.StoreTargetOutcomes(outcomes))
+	stored, err := suite.storage.GetTargetOutcomes("t1", time.Time{}, 10)
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), stored, 2)
+	// the latest outcome comes first
+	require.False(suite.T(), stored[0].Success)
+	require.Equal(suite.T(), types.JobID("2"), stored[0].JobID)
+	stored, err = suite.storage.GetTargetOutcomes("t1", now, 10)
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), stored, 1)
+
+	h, err := suite.storage.GetTargetHealth("t1")
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), &target.Health{TargetID: "t1"}, h)
+	require.NoError(suite.T(), suite.storage.StoreTargetHealth(&target.Health{TargetID: "t1", Quarantined: true, QuarantineTime: now, FailureRate: 0.5}))
+	quarantined, err := suite.storage.GetQuarantinedTargets()
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), quarantined, 1)
+	require.Equal(suite.T(), "t1", quarantined[0].TargetID)
+	require.Equal(suite.T(), 0.5, quarantined[0].FailureRate)
+
+	require.NoError(suite.T(), suite.storage.StoreTargetHealth(&target.Health{TargetID: "t1", QuarantineTime: now, FailureRate: 0.5, ReleaseTime: now}))
+	quarantined, err = suite.storage.GetQuarantinedTargets()
+	require.NoError(suite.T(), err)
+	require.Empty(suite.T(), quarantined)
+	h, err = suite.storage.GetTargetHealth("t1")
+	require.NoError(suite.T(), err)
+	require.True(suite.T(), now.Equal(h.ReleaseTime))
+}
+
+func (suite *JobSuite) TestStepState() {
+	state, err := suite.storage.GetStepState("1")
+	require.NoError(suite.T(), err)
+	require.Nil(suite.T(), state)
+
+	require.NoError(suite.T(), suite.storage.StoreStepState("1", &job.PauseSnapshot{RunID: 1}))
+	paused := job.PauseSnapshot{
+		RunID:     2,
+		TestIndex: 1,
+		Targets:   []*target.Target{{ID: "t1"}},
+		Steps:     []job.StepState{{TestStepLabel: "step", InFlight: []*target.Target{{ID: "t1"}}}},
+	}
+	// the state of the last pause replaces the previous one
+	require.NoError(suite.T(), suite.storage.StoreStepState("1", &paused))
+	state, err = suite.storage.GetStepState("1")
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), &paused, state)
+
+	require.NoError(suite.T(), suite.storage.DeleteJobData("1"))
+	state, err = suite.storage.GetStepState("1")
+	require.NoError(suite.T(), err)
+	require.Nil(suite.T(), state)
 }
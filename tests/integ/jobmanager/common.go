@@ -149,10 +149,10 @@ func (suite *TestJobManagerSuite) startJob(jobDescriptor string) (types.JobID, e
 	select {
 	case resp = <-suite.responseCh:
 		if resp.Err != nil {
-			return types.JobID(0), resp.Err
+			return types.JobID(""), resp.Err
 		}
 	case <-time.After(2 * time.Second):
-		return types.JobID(0), fmt.Errorf("Listener response should come within the timeout")
+		return types.JobID(""), fmt.Errorf("Listener response should come within the timeout")
 	}
 	jobID := resp.Data.(api.ResponseDataStart).JobID
 	return jobID, nil
@@ -245,36 +245,36 @@ func (suite *TestJobManagerSuite) TestJobManagerJobStartSingle() {
 
 	jobID, err := suite.startJob(jobDescriptorNoop)
 	require.NoError(suite.T(), err)
-	require.Equal(suite.T(), types.JobID(1), jobID)
+	require.Equal(suite.T(), types.JobID("1"), jobID)
 
 	_, err = suite.jobRequestManager.Fetch(types.JobID(jobID))
 	require.NoError(suite.T(), err)
 
-	r, err := suite.jobRequestManager.Fetch(types.JobID(jobID + 1))
+	r, err := suite.jobRequestManager.Fetch(types.JobID("2"))
 	require.Error(suite.T(), err)
 	require.NotEqual(suite.T(), nil, r)
 
 	// JobManager will emit an EventJobStarted when the Job is started
-	ev, err := pollForEvent(suite.eventManager, jobmanager.EventJobStarted, types.JobID(1))
+	ev, err := pollForEvent(suite.eventManager, jobmanager.EventJobStarted, types.JobID("1"))
 	require.Equal(suite.T(), 1, len(ev))
 	require.NoError(suite.T(), err)
 
 	// JobManager will emit an EventJobCompleted when the Job completes
-	ev, err = pollForEvent(suite.eventManager, jobmanager.EventJobCompleted, types.JobID(1))
+	ev, err = pollForEvent(suite.eventManager, jobmanager.EventJobCompleted, types.JobID("1"))
 	require.NoError(suite.T(), err)
 	require.Equal(suite.T(), 1, len(ev))
 
 	// A Report must be persisted for the Job
-	jobReport, err := suite.jobReportManager.Fetch(types.JobID(1))
+	jobReport, err := suite.jobReportManager.Fetch(types.JobID("1"))
 	require.NoError(suite.T(), err)
 	require.Equal(suite.T(), 1, len(jobReport.RunReports))
 	require.Equal(suite.T(), 0, len(jobReport.FinalReports))
 
 	// Any other Job should not have a Job report, but fetching the
 	// report should not error out
-	jobReport, err = suite.jobReportManager.Fetch(types.JobID(2))
+	jobReport, err = suite.jobReportManager.Fetch(types.JobID("2"))
 	require.NoError(suite.T(), err)
-	require.Equal(suite.T(), &job.JobReport{JobID: 2}, jobReport)
+	require.Equal(suite.T(), &job.JobReport{JobID: "2"}, jobReport)
 
 }
 
@@ -287,7 +287,7 @@ func (suite *TestJobManagerSuite) TestJobManagerJobCancellation() {
 
 	jobID, err := suite.startJob(jobDescriptorSlowecho)
 	require.NoError(suite.T(), err)
-	require.Equal(suite.T(), types.JobID(1), jobID)
+	require.Equal(suite.T(), types.JobID("1"), jobID)
 
 	// Wait EventJobStarted event. This is necessary so that we can later issue a
 	// Stop command for a Job that we know is already running.
@@ -323,7 +323,7 @@ func (suite *TestJobManagerSuite) TestJobManagerJobNotSuccessful() {
 
 	jobID, err := suite.startJob(jobDescriptorFailure)
 	require.NoError(suite.T(), err)
-	require.Equal(suite.T(), types.JobID(1), jobID)
+	require.Equal(suite.T(), types.JobID("1"), jobID)
 
 	// If the Job completes, but the result of the reporting phase indicates a failure,
 	// an EventJobCompleted is emitted and the Report will indicate that the Job was unsuccessful
@@ -331,7 +331,7 @@ func (suite *TestJobManagerSuite) TestJobManagerJobNotSuccessful() {
 	require.NoError(suite.T(), err)
 	require.Equal(suite.T(), 1, len(ev))
 
-	jobReport, err := suite.jobReportManager.Fetch(types.JobID(1))
+	jobReport, err := suite.jobReportManager.Fetch(types.JobID("1"))
 	require.NoError(suite.T(), err)
 	require.Equal(suite.T(), 1, len(jobReport.RunReports))
 	require.Equal(suite.T(), 0, len(jobReport.FinalReports))
@@ -346,7 +346,7 @@ func (suite *TestJobManagerSuite) TestJobManagerJobFailure() {
 
 	jobID, err := suite.startJob(jobDescriptorFailure)
 	require.NoError(suite.T(), err)
-	require.Equal(suite.T(), types.JobID(1), jobID)
+	require.Equal(suite.T(), types.JobID("1"), jobID)
 
 	// If the Job completes, but the result of the reporting phase indicates a failure,
 	// an EventJobCompleted is emitted and the Report will indicate that the Job was unsuccessful
@@ -354,7 +354,7 @@ func (suite *TestJobManagerSuite) TestJobManagerJobFailure() {
 	require.NoError(suite.T(), err)
 	require.Equal(suite.T(), 1, len(ev))
 
-	jobReport, err := suite.jobReportManager.Fetch(types.JobID(1))
+	jobReport, err := suite.jobReportManager.Fetch(types.JobID("1"))
 	require.NoError(suite.T(), err)
 	require.Equal(suite.T(), 1, len(jobReport.RunReports))
 	require.Equal(suite.T(), 0, len(jobReport.FinalReports))
@@ -369,7 +369,7 @@ func (suite *TestJobManagerSuite) TestJobManagerJobCrash() {
 
 	jobID, err := suite.startJob(jobDescriptorCrash)
 	require.NoError(suite.T(), err)
-	require.Equal(suite.T(), types.JobID(1), jobID)
+	require.Equal(suite.T(), types.JobID("1"), jobID)
 
 	// If the Job does not complete and returns an error instead, an EventJobFailed
 	// is emitted. The report will indicate that the job was unsuccessful, and
@@ -379,7 +379,7 @@ func (suite *TestJobManagerSuite) TestJobManagerJobCrash() {
 	require.Equal(suite.T(), 1, len(ev))
 	require.Equal(suite.T(), string(*ev[0].Payload), "{\"Err\":\"TestStep crashed\"}")
 
-	jobReport, err := suite.jobReportManager.Fetch(types.JobID(1))
+	jobReport, err := suite.jobReportManager.Fetch(types.JobID("1"))
 	require.NoError(suite.T(), err)
 	// no reports are expected if the job crashes
 	require.Equal(suite.T(), &job.JobReport{JobID: jobID}, jobReport)
@@ -397,7 +397,7 @@ func (suite *TestJobManagerSuite) TestJobManagerJobCancellationFailure() {
 
 	jobID, err := suite.startJob(jobDescriptorHang)
 	require.NoError(suite.T(), err)
-	require.Equal(suite.T(), types.JobID(1), jobID)
+	require.Equal(suite.T(), types.JobID("1"), jobID)
 
 	// Wait EventJobStarted event. This is necessary so that we can later issue a
 	// Stop command for a Job that we know is already running.
@@ -95,7 +95,7 @@ func TestMain(m *testing.M) {
 
 func TestSuccessfulCompletion(t *testing.T) {
 
-	jobID := types.JobID(1)
+	jobID := types.JobID("1")
 
 	ts1, err := pluginRegistry.NewTestStep("Example")
 	require.NoError(t, err)
@@ -130,7 +130,7 @@ func TestSuccessfulCompletion(t *testing.T) {
 
 func TestPanicStep(t *testing.T) {
 
-	jobID := types.JobID(1)
+	jobID := types.JobID("1")
 
 	ts1, err := pluginRegistry.NewTestStep("Panic")
 	require.NoError(t, err)
@@ -162,7 +162,7 @@ func TestPanicStep(t *testing.T) {
 
 func TestNoReturnStepWithCorrectTargetForwarding(t *testing.T) {
 
-	jobID := types.JobID(1)
+	jobID := types.JobID("1")
 
 	ts1, err := pluginRegistry.NewTestStep("NoReturn")
 	require.NoError(t, err)
@@ -205,7 +205,7 @@ func TestNoReturnStepWithCorrectTargetForwarding(t *testing.T) {
 
 func TestNoReturnStepWithoutTargetForwarding(t *testing.T) {
 
-	jobID := types.JobID(1)
+	jobID := types.JobID("1")
 
 	ts1, err := pluginRegistry.NewTestStep("Hanging")
 	require.NoError(t, err)
@@ -268,7 +268,7 @@ func TestNoReturnStepWithoutTargetForwarding(t *testing.T) {
 
 func TestStepClosesChannels(t *testing.T) {
 
-	jobID := types.JobID(1)
+	jobID := types.JobID("1")
 
 	ts1, err := pluginRegistry.NewTestStep("Channels")
 	require.NoError(t, err)
@@ -305,7 +305,7 @@ func TestStepClosesChannels(t *testing.T) {
 
 func TestCmdPlugin(t *testing.T) {
 
-	jobID := types.JobID(1)
+	jobID := types.JobID("1")
 
 	ts1, err := pluginRegistry.NewTestStep("cmd")
 	require.NoError(t, err)
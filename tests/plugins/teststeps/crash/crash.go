@@ -6,6 +6,7 @@
 package crash
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/facebookincubator/contest/pkg/cerrors"
@@ -29,7 +30,7 @@ func (ts *crash) Name() string {
 }
 
 // Run executes a step which does never return.
-func (ts *crash) Run(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
+func (ts *crash) Run(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.Emitter) error {
 	return fmt.Errorf("TestStep crashed")
 }
 
@@ -40,7 +41,7 @@ func (ts *crash) ValidateParameters(params test.TestStepParameters) error {
 
 // Resume tries to resume a previously interrupted test step. ExampleTestStep
 // cannot resume.
-func (ts *crash) Resume(cancel, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
+func (ts *crash) Resume(ctx context.Context, pause <-chan struct{}, ch test.TestStepChannels, params test.TestStepParameters, ev testevent.EmitterFetcher) error {
 	return &cerrors.ErrResumeNotSupported{StepName: Name}
 }
 